@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// checkTimeout bounds each dependency check.
+const checkTimeout = 10 * time.Second
+
+// checkResult is the outcome of one dependency check.
+type checkResult struct {
+	name    string
+	detail  string // what was found, when the check passed
+	skipped bool   // the dependency is not configured
+	err     error
+}
+
+// runCheck runs the check subcommand: it loads the config, checks every
+// dependency, prints a pass/fail report and returns exitFailure if any
+// check failed.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "popcornvault check: unexpected argument %q\n", fs.Arg(0))
+		return exitUsage
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	// The config is reported like the other checks rather than as an error,
+	// so the report lists every problem with it.
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(tw, "FAIL\tconfig\t%v\n", err)
+		tw.Flush()
+		return exitFailure
+	}
+	fmt.Fprintf(tw, "PASS\tconfig\t\n")
+
+	code := exitOK
+	for _, res := range runChecks(context.Background(), cfg) {
+		switch {
+		case res.err != nil:
+			code = exitFailure
+			fmt.Fprintf(tw, "FAIL\t%s\t%s\n", res.name, strings.ReplaceAll(res.err.Error(), "\n", "; "))
+		case res.skipped:
+			fmt.Fprintf(tw, "SKIP\t%s\t%s\n", res.name, res.detail)
+		default:
+			fmt.Fprintf(tw, "PASS\t%s\t%s\n", res.name, res.detail)
+		}
+	}
+	tw.Flush()
+	return code
+}
+
+// runChecks checks, in order, that Postgres answers, that pgvector is
+// installed, that the schema is fully and cleanly migrated, that Redis
+// answers and that VoyageAI embeds a test text, skipping the last two when
+// they are not configured. Once Postgres fails, the checks that need it
+// are not run.
+func runChecks(ctx context.Context, cfg *config.Config) []checkResult {
+	var results []checkResult
+	check := func(name string, fn func(ctx context.Context) (string, error)) error {
+		ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		start := time.Now()
+		detail, err := fn(ctx)
+		if err == nil {
+			detail = fmt.Sprintf("%s (%s)", detail, time.Since(start).Round(time.Millisecond))
+		}
+		results = append(results, checkResult{name: name, detail: detail, err: err})
+		return err
+	}
+
+	err := check("postgres", func(ctx context.Context) (string, error) {
+		return "connected", store.PingDatabase(ctx, cfg.DatabaseURL)
+	})
+	if err == nil {
+		check("pgvector", func(ctx context.Context) (string, error) {
+			ok, err := store.ExtensionInstalled(ctx, cfg.DatabaseURL, "vector")
+			if err == nil && !ok {
+				err = fmt.Errorf("the vector extension is not installed")
+			}
+			return "installed", err
+		})
+		check("migrations", func(ctx context.Context) (string, error) {
+			return checkMigrations(cfg)
+		})
+	}
+
+	if cfg.RedisURL == "" {
+		results = append(results, checkResult{name: "redis", detail: "REDIS_URL not set", skipped: true})
+	} else {
+		check("redis", func(ctx context.Context) (string, error) {
+			rds, err := cache.New(redisOptions(cfg))
+			if err != nil {
+				return "", err
+			}
+			defer rds.Close()
+			return "connected", rds.Ping(ctx)
+		})
+	}
+
+	if cfg.VoyageAPIKey == "" {
+		results = append(results, checkResult{name: "voyage", detail: "VOYAGE_API_KEY not set", skipped: true})
+	} else {
+		check("voyage", func(ctx context.Context) (string, error) {
+			return checkEmbedding(ctx, cfg)
+		})
+	}
+	return results
+}
+
+// checkMigrations compares the schema version with the latest migration.
+func checkMigrations(cfg *config.Config) (string, error) {
+	latest, err := store.LatestMigration(cfg.MigrationsPath)
+	if err != nil {
+		return "", err
+	}
+	version, dirty, err := store.MigrationVersion(cfg.DatabaseURL, cfg.MigrationsPath)
+	switch {
+	case err != nil:
+		return "", err
+	case dirty:
+		return "", fmt.Errorf("migration %d failed and left the schema dirty; see popcornvault migrate force", version)
+	case version < latest:
+		return "", fmt.Errorf("schema at version %d, %d migrations pending up to %d; run popcornvault migrate up", version, latest-version, latest)
+	case version > latest:
+		return "", fmt.Errorf("schema at version %d is newer than this binary's migrations (%d)", version, latest)
+	}
+	return fmt.Sprintf("version %d", version), nil
+}
+
+// checkEmbedding embeds a test text and checks the vector's size.
+func checkEmbedding(ctx context.Context, cfg *config.Config) (string, error) {
+	embedder, err := newEmbedder(cfg)
+	if err != nil {
+		return "", err
+	}
+	res, err := embedder.Embed(ctx, []string{"popcornvault check"}, "query")
+	if err != nil {
+		return "", err
+	}
+	if len(res.Embeddings) != 1 || len(res.Embeddings[0]) != embedding.Dimensions {
+		return "", fmt.Errorf("model %s returned an unexpected embedding", embedder.Model())
+	}
+	return "model " + embedder.Model(), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/service"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// runIngest runs the ingest subcommand: it adds or updates the source named
+// -name from the playlist at -url, embeds its channels when VOYAGE_API_KEY is
+// set, prints a summary and returns the exit code.
+func runIngest(args []string) int {
+	fs := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	m3uURL := fs.String("url", "", "Playlist URL (required)")
+	name := fs.String("name", "", "Source name; a source of the same name is updated (default \"m3u\")")
+	userAgent := fs.String("user-agent", "", "User-Agent for the playlist request (default FETCHER_USER_AGENT)")
+	useTvgID := fs.Bool("use-tvg-id", true, "Name untitled channels after their tvg-id")
+	dedupe := fs.Bool("dedupe", false, "Keep only the first entry for each stream URL")
+	cfg, code := parseCommand(fs, args, configPath)
+	if cfg == nil {
+		return code
+	}
+	if *m3uURL == "" {
+		fmt.Fprintln(os.Stderr, "popcornvault ingest: -url is required")
+		return exitUsage
+	}
+	if *userAgent == "" {
+		*userAgent = cfg.UserAgent
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault ingest: %v\n", err)
+		return exitFailure
+	}
+	defer a.Close()
+
+	res, err := service.Ingest(ctx, a.store, *m3uURL, *name, ingestOptions(cfg, service.IngestOptions{
+		UserAgent: *userAgent,
+		UseTvgID:  *useTvgID,
+		Dedupe:    *dedupe,
+	}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault ingest: %v\n", err)
+		return exitFailure
+	}
+	printIngestResult(res)
+	return embedAfterIngest(ctx, a, "ingest", res, sourceNameOr(*name), false)
+}
+
+// runRefresh runs the refresh subcommand: it re-ingests a source from its
+// playlist URL with the source's settings, or with -embeddings-only only
+// regenerates its embeddings, prints a summary and returns the exit code.
+func runRefresh(args []string) int {
+	fs := flag.NewFlagSet("refresh", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	sourceID := fs.Int64("source-id", 0, "Source to refresh (required)")
+	embeddingsOnly := fs.Bool("embeddings-only", false, "Only regenerate the embeddings whose text changed")
+	force := fs.Bool("force", false, "Re-ingest an unchanged playlist and re-embed unchanged channels")
+	cfg, code := parseCommand(fs, args, configPath)
+	if cfg == nil {
+		return code
+	}
+	if *sourceID <= 0 {
+		fmt.Fprintln(os.Stderr, "popcornvault refresh: -source-id is required")
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: %v\n", err)
+		return exitFailure
+	}
+	defer a.Close()
+
+	src, err := a.store.GetSourceByID(ctx, *sourceID)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: source %d not found\n", *sourceID)
+		return exitFailure
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: %v\n", err)
+		return exitFailure
+	}
+	if !src.Enabled {
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: source %d is disabled\n", src.ID)
+		return exitFailure
+	}
+
+	if *embeddingsOnly {
+		if a.embedder == nil {
+			fmt.Fprintln(os.Stderr, "popcornvault refresh: embeddings not configured (VOYAGE_API_KEY not set)")
+			return exitFailure
+		}
+		er, err := service.RefreshEmbeddings(ctx, a.store, a.embedder, src.ID, src.Name, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "popcornvault refresh: embeddings: %v\n", err)
+			return exitFailure
+		}
+		printEmbedResult(er)
+		return exitOK
+	}
+
+	switch src.SourceType {
+	case models.SourceTypeCustom:
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: source %d is a custom source and has no playlist to refresh\n", src.ID)
+		return exitFailure
+	case models.SourceTypeM3U:
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: source %d was created from an uploaded file and cannot be re-fetched\n", src.ID)
+		return exitFailure
+	}
+
+	userAgent := src.UserAgent
+	if userAgent == "" {
+		userAgent = cfg.UserAgent
+	}
+	res, err := service.Ingest(ctx, a.store, src.URL, src.Name, ingestOptions(cfg, service.IngestOptions{
+		UserAgent: userAgent,
+		Headers:   src.FetchHeaders,
+		UseTvgID:  src.UseTvgID == nil || *src.UseTvgID,
+		NoGuess:   !src.GuessMediaType,
+		Dedupe:    src.Dedupe,
+		SourceID:  src.ID,
+		Force:     *force,
+	}))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault refresh: %v\n", err)
+		return exitFailure
+	}
+	printIngestResult(res)
+	return embedAfterIngest(ctx, a, "refresh", res, src.Name, *force)
+}
+
+// ingestOptions fills in the fetch settings of the config. Embeddings are
+// left to embedAfterIngest, which waits for them.
+func ingestOptions(cfg *config.Config, opts service.IngestOptions) service.IngestOptions {
+	opts.Timeout = cfg.Timeout
+	opts.MaxBytes = cfg.MaxBodyBytes
+	opts.Retries = cfg.Retries
+	opts.Backoff = cfg.RetryBackoff
+	// An in-process cache ends with the command, so only Redis is worth
+	// warming.
+	opts.WarmCache = cfg.CacheWarm && cfg.RedisURL != ""
+	return opts
+}
+
+// embedAfterIngest embeds the channels of an ingested source when an
+// embedder is configured. Given the embedder, Ingest would embed them in the
+// background, which the command exiting right after it would cut short. An
+// unchanged playlist only gets its missing embeddings.
+func embedAfterIngest(ctx context.Context, a *app, cmd string, res service.IngestResult, sourceName string, force bool) int {
+	if a.embedder == nil || res.ChannelCount == 0 {
+		return exitOK
+	}
+	var er service.EmbedResult
+	var err error
+	if res.Unchanged {
+		er, err = service.ResumeEmbeddings(ctx, a.store, a.embedder, res.SourceID, sourceName)
+	} else {
+		er, err = service.RefreshEmbeddings(ctx, a.store, a.embedder, res.SourceID, sourceName, force)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault %s: embeddings: %v\n", cmd, err)
+		return exitFailure
+	}
+	printEmbedResult(er)
+	return exitOK
+}
+
+// sourceNameOr returns name, or the default name Ingest gives a source.
+func sourceNameOr(name string) string {
+	if name == "" {
+		return "m3u"
+	}
+	return name
+}
+
+func printIngestResult(res service.IngestResult) {
+	if res.Unchanged {
+		fmt.Printf("source %d: playlist unchanged, %d channels\n", res.SourceID, res.ChannelCount)
+		return
+	}
+	fmt.Printf("source %d: %d channels (%d added, %d updated, %d removed, %d duplicates skipped)\n",
+		res.SourceID, res.ChannelCount, len(res.Diff.Added), len(res.Diff.Updated), len(res.Diff.Removed), res.Duplicates)
+}
+
+func printEmbedResult(er service.EmbedResult) {
+	fmt.Printf("embeddings: %d embedded, %d unchanged, %d tokens\n", er.Embedded, er.Skipped, er.Tokens)
+}
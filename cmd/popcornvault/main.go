@@ -2,144 +2,526 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/voyagen/popcornvault/internal/buildinfo"
 	"github.com/voyagen/popcornvault/internal/cache"
 	"github.com/voyagen/popcornvault/internal/config"
 	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/logging"
+	"github.com/voyagen/popcornvault/internal/metrics"
 	"github.com/voyagen/popcornvault/internal/server"
 	"github.com/voyagen/popcornvault/internal/service"
 	"github.com/voyagen/popcornvault/internal/store"
+	"github.com/voyagen/popcornvault/internal/webhook"
 )
 
 func main() {
-	configPath := flag.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
-	flag.Parse()
-
-	var cfg *config.Config
-	var err error
-	if *configPath != "" {
-		cfg, err = config.LoadFromFile(*configPath)
-	} else {
-		cfg, err = config.Load()
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "migrate":
+		os.Exit(runMigrate(args))
+	case "ingest":
+		os.Exit(runIngest(args))
+	case "refresh":
+		os.Exit(runRefresh(args))
+	case "search":
+		os.Exit(runSearch(args))
+	case "check":
+		os.Exit(runCheck(args))
+	case "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "popcornvault: unknown command %q\n\n%s", cmd, usage)
+		os.Exit(exitUsage)
+	}
+}
+
+// Exit codes of the subcommands other than serve.
+const (
+	exitOK      = 0
+	exitFailure = 1 // the config, database or a remote could not be read or changed
+	exitUsage   = 2 // bad arguments
+)
+
+const usage = `usage: popcornvault [command] [flags]
+
+Commands:
+  serve      run the API server (the default)
+  migrate    manage the database schema
+  ingest     add or update a source from a playlist URL and exit
+  refresh    refresh a source, or only its embeddings, and exit
+  search     search channels and print the results
+  check      check the config and every dependency, and report
+
+Run "popcornvault <command> -h" for the flags of a command.
+`
+
+// serve runs the API server until SIGINT or SIGTERM.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	skipMigrations := fs.Bool("skip-migrations", false, "Do not create extensions or run migrations at startup; see the migrate subcommand")
+	fs.Parse(args)
+
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "config: %v\n", err)
-		os.Exit(1)
+		fatal("config", err)
+	}
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fatal("config", err)
 	}
+	build := buildinfo.Get()
+	slog.Info("starting popcornvault", "version", build.Version, "commit", build.Commit, "build_date", build.BuildDate, "go", build.GoVersion)
 
 	ctx := context.Background()
 
-	// Run migrations.
-	absMigrations, err := filepath.Abs("migrations")
+	if *skipMigrations {
+		slog.Info("skipping migrations (-skip-migrations)")
+	} else if err := migrateUp(cfg); err != nil {
+		fatal("migrate", err)
+	}
+
+	// With STRICT_STARTUP, a dependency that does not work stops the server
+	// here instead of degrading it, e.g. to name search on a bad Voyage key.
+	if cfg.StrictStartup {
+		failed := 0
+		for _, res := range runChecks(ctx, cfg) {
+			switch {
+			case res.err != nil:
+				failed++
+				slog.Error("startup check failed", "check", res.name, "err", res.err)
+			case res.skipped:
+			default:
+				slog.Info("startup check passed", "check", res.name, "detail", res.detail)
+			}
+		}
+		if failed > 0 {
+			fatal("startup", fmt.Errorf("%d startup checks failed (STRICT_STARTUP is set)", failed))
+		}
+	}
+
+	a, err := openApp(ctx, cfg)
 	if err != nil {
-		absMigrations = "migrations"
+		fatal("startup", err)
 	}
-	if _, err := os.Stat(absMigrations); err != nil {
-		if exe, e := os.Executable(); e == nil {
-			absMigrations = filepath.Join(filepath.Dir(exe), "migrations")
+	defer a.Close()
+	appStore, rds, embedder := a.store, a.rds, a.embedder
+	metrics.RegisterDBPool(a.pg.PoolStat)
+	if ci, ok := appStore.(store.CacheInspector); ok {
+		metrics.RegisterCache(cacheCounts(ci))
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Start the background job worker when Redis is available. Without Redis,
+	// the server runs jobs in-process instead.
+	var workers sync.WaitGroup
+	if rds != nil {
+		runner := &jobs.Runner{
+			Store:     appStore,
+			Embedder:  embedder,
+			Tracker:   jobs.NewRedisTracker(rds),
+			Timeout:   cfg.Timeout,
+			MaxBytes:  cfg.MaxBodyBytes,
+			Retries:   cfg.Retries,
+			Backoff:   cfg.RetryBackoff,
+			WarmCache: cfg.CacheWarm,
+
+			CheckConcurrency: cfg.CheckConcurrency,
+			CheckTimeout:     cfg.CheckTimeout,
+
+			Queue: rds,
+
+			Webhooks:   webhook.NewSender(cfg.WebhookSecret),
+			WebhookURL: cfg.WebhookURL,
 		}
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			runJobWorker(ctx, rds, runner, cfg.JobMaxAttempts, cfg.JobRetryBackoff)
+		}()
+	}
+
+	if cfg.WatchHistoryRetention > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			runWatchPruner(ctx, appStore, cfg.WatchHistoryRetention)
+		}()
 	}
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		runArchivePruner(ctx, appStore, cfg.ArchiveRetention)
+	}()
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		toggleSlowQueries(ctx, a.tracer)
+	}()
+
+	srv := server.New(appStore, cfg, embedder, rds)
+	srv.SetSlowQueryTracer(a.tracer)
+	if cfg.VoyageResumeOnStart {
+		if err := srv.ResumeEmbeddings(ctx); err != nil {
+			slog.Error("resume embeddings", "err", err)
+		}
+	}
+	if err := srv.ListenAndServe(ctx); err != nil {
+		fatal("server", err)
+	}
+
+	// The worker stops after requeueing its current job; background work
+	// gets SHUTDOWN_TIMEOUT to finish before the pool is closed under it.
+	workers.Wait()
+	drainBackground(cfg.ShutdownTimeout)
+}
+
+// loadConfig reads the config file at path, or the environment when path is empty.
+func loadConfig(path string) (*config.Config, error) {
+	if path != "" {
+		return config.LoadFromFile(path)
+	}
+	return config.Load()
+}
+
+// parseCommand parses the flags of a subcommand, then loads the config
+// named by its -config flag and sets up logging. It returns a nil config and
+// the exit code when the command cannot run.
+func parseCommand(fs *flag.FlagSet, args []string, configPath *string) (*config.Config, int) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK
+		}
+		return nil, exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "popcornvault %s: unexpected argument %q\n", fs.Name(), fs.Arg(0))
+		return nil, exitUsage
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault %s: config: %v\n", fs.Name(), err)
+		return nil, exitFailure
+	}
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault %s: config: %v\n", fs.Name(), err)
+		return nil, exitFailure
+	}
+	return cfg, exitOK
+}
+
+// migrateUp creates the database extensions the schema needs and applies
+// all pending migrations.
+func migrateUp(cfg *config.Config) error {
 	// Ensure pgvector extension exists before running migrations.
 	if err := store.EnsurePgvector(cfg.DatabaseURL); err != nil {
-		fmt.Fprintf(os.Stderr, "pgvector: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("pgvector: %w", err)
+	}
+
+	// pg_trgm only speeds up name search, so run without it if need be.
+	if err := store.EnsurePgTrgm(cfg.DatabaseURL); err != nil {
+		slog.Warn("pg_trgm unavailable, name search will not be indexed", "err", err)
 	}
 
-	migrationsPath := "file://" + absMigrations
-	if err := store.RunMigrations(cfg.DatabaseURL, migrationsPath); err != nil {
-		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
-		os.Exit(1)
+	if cfg.MigrationsPath != "" {
+		slog.Info("using migrations from disk", "path", cfg.MigrationsPath)
+	}
+	return store.RunMigrations(cfg.DatabaseURL, cfg.MigrationsPath)
+}
+
+// app holds the connections the subcommands share.
+type app struct {
+	pg       *store.Postgres
+	store    store.Store       // pg, behind the Redis cache when configured
+	rds      *cache.Redis      // nil without REDIS_URL
+	embedder *embedding.Client // nil without VOYAGE_API_KEY
+	tracer   *store.SlowQueryTracer
+}
+
+// openApp connects to Postgres and, when configured, Redis and VoyageAI.
+// The schema must be migrated already.
+func openApp(ctx context.Context, cfg *config.Config) (*app, error) {
+	tracer := store.NewSlowQueryTracer(cfg.DBSlowQueryLog, cfg.DBSlowQueryThreshold)
+	pg, err := store.NewPostgres(ctx, cfg.DatabaseURL, store.PoolOptions{
+		MaxConns:          int32(cfg.DBMaxConns),
+		MinConns:          int32(cfg.DBMinConns),
+		MaxConnLifetime:   cfg.DBMaxConnLifetime,
+		HealthCheckPeriod: cfg.DBHealthCheckPeriod,
+		StatementTimeout:  cfg.DBStatementTimeout,
+		WriteConns:        cfg.DBWriteConns,
+		Tracer:            tracer,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
 	}
+	a := &app{pg: pg, store: pg, tracer: tracer}
 
-	pg, err := store.NewPostgres(ctx, cfg.DatabaseURL)
+	// Vectors of the wrong length cannot be stored or searched, so refuse to
+	// start rather than fail on every embedding batch.
+	dims, err := pg.EmbeddingDimensions(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "db: %v\n", err)
-		os.Exit(1)
+		a.Close()
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	if dims != embedding.Dimensions {
+		a.Close()
+		return nil, fmt.Errorf("db: channels.embedding holds %d-dimensional vectors but the embedding model returns %d; "+
+			"migrate the column to vector(%d) and re-embed all sources", dims, embedding.Dimensions, embedding.Dimensions)
 	}
-	defer pg.Close()
 
 	// Create embedding client if VOYAGE_API_KEY is configured.
-	var embedder *embedding.Client
 	if cfg.VoyageAPIKey != "" {
-		embedder = embedding.NewClient(cfg.VoyageAPIKey)
-		fmt.Fprintln(os.Stderr, "semantic search enabled (VoyageAI)")
+		if a.embedder, err = newEmbedder(cfg); err != nil {
+			a.Close()
+			return nil, fmt.Errorf("embedding: %w", err)
+		}
+		slog.Info("semantic search enabled", "provider", "VoyageAI", "model", a.embedder.Model())
 	} else {
-		fmt.Fprintln(os.Stderr, "semantic search disabled (VOYAGE_API_KEY not set)")
+		slog.Info("semantic search disabled (VOYAGE_API_KEY not set)")
 	}
 
 	// Connect to Redis if REDIS_URL is configured.
-	var rds *cache.Redis
-	var appStore store.Store = pg
 	if cfg.RedisURL != "" {
-		rds, err = cache.New(cfg.RedisURL)
+		a.rds, err = cache.New(redisOptions(cfg))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "redis: %v\n", err)
-			os.Exit(1)
+			a.Close()
+			return nil, fmt.Errorf("redis: %w", err)
 		}
-		defer rds.Close()
+		if err := a.rds.Ping(ctx); err != nil {
+			a.Close()
+			return nil, fmt.Errorf("redis ping: %w", err)
+		}
+		a.store = store.NewCachedStore(pg, a.rds, cacheOptions(cfg))
+		slog.Info("redis connected (caching enabled)")
+	} else {
+		a.store = store.NewCachedStore(pg, cache.NewMemory(cfg.MemoryCacheEntries, cfg.MemoryCacheBytes), cacheOptions(cfg))
+		slog.Info("redis disabled (REDIS_URL not set), caching in memory")
+	}
+	return a, nil
+}
 
-		if err := rds.Ping(ctx); err != nil {
-			fmt.Fprintf(os.Stderr, "redis ping: %v\n", err)
-			os.Exit(1)
+// redisOptions returns the Redis deployment of cfg.
+func redisOptions(cfg *config.Config) cache.Options {
+	return cache.Options{
+		URL:              cfg.RedisURL,
+		SentinelMaster:   cfg.RedisSentinelMaster,
+		SentinelAddrs:    cfg.RedisSentinelAddrs,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		Cluster:          cfg.RedisCluster,
+		BreakerThreshold: cfg.RedisBreakerThreshold,
+		BreakerCooldown:  cfg.RedisBreakerCooldown,
+	}
+}
+
+// cacheOptions returns the CachedStore settings of cfg.
+func cacheOptions(cfg *config.Config) store.CacheOptions {
+	return store.CacheOptions{
+		Prefix:       cfg.CachePrefix,
+		TTLSources:   cfg.CacheTTLSources,
+		TTLSource:    cfg.CacheTTLSource,
+		TTLChannels:  cfg.CacheTTLChannels,
+		TTLChannel:   cfg.CacheTTLChannel,
+		TTLGroups:    cfg.CacheTTLGroups,
+		TTLSearch:    cfg.CacheTTLSearch,
+		TTLSeries:    cfg.CacheTTLSeries,
+		TTLPlaylists: cfg.CacheTTLPlaylists,
+		TTLNotFound:  cfg.CacheTTLNotFound,
+	}
+}
+
+// cacheCounts reads the cache statistics of ci for the metrics collector.
+func cacheCounts(ci store.CacheInspector) func() map[string]metrics.CacheCounts {
+	return func() map[string]metrics.CacheCounts {
+		stats := ci.CacheStats()
+		counts := make(map[string]metrics.CacheCounts, len(stats))
+		for entity, st := range stats {
+			counts[entity] = metrics.CacheCounts{Hits: st.Hits, Misses: st.Misses, FillErrors: st.FillErrors, Invalidations: st.Invalidations}
 		}
+		return counts
+	}
+}
 
-		appStore = store.NewCachedStore(pg, rds)
-		fmt.Fprintln(os.Stderr, "redis connected (caching enabled)")
-	} else {
-		fmt.Fprintln(os.Stderr, "redis disabled (REDIS_URL not set)")
+// newEmbedder returns a VoyageAI client set up from cfg.
+func newEmbedder(cfg *config.Config) (*embedding.Client, error) {
+	return embedding.NewClient(cfg.VoyageAPIKey, cfg.VoyageModel, embedding.Options{
+		MaxAttempts:       cfg.VoyageRetries,
+		RequestsPerMinute: cfg.VoyageRequestsPerMinute,
+		TokensPerMinute:   cfg.VoyageTokensPerMinute,
+		BatchSize:         cfg.VoyageBatchSize,
+		MaxTextChars:      cfg.VoyageMaxTextChars,
+	})
+}
+
+// Close closes the Redis and Postgres connections.
+func (a *app) Close() {
+	if a.rds != nil {
+		a.rds.Close()
 	}
+	a.pg.Close()
+}
 
-	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+// drainBackground waits for the background work started by requests and
+// in-process jobs, and logs what finished and what was cancelled.
+func drainBackground(timeout time.Duration) {
+	slog.Info("shutdown: waiting for background work", "timeout", timeout)
+	drained, abandoned := service.Drain(timeout)
+	for _, name := range drained {
+		slog.Info("shutdown: drained", "task", name)
+	}
+	for _, name := range abandoned {
+		slog.Warn("shutdown: abandoned", "task", name)
+	}
+	slog.Info("shutdown: background work done", "drained", len(drained), "abandoned", len(abandoned))
+}
 
-	// Start the background embedding job worker if both Redis and embedder are available.
-	if rds != nil && embedder != nil {
-		go runEmbeddingWorker(ctx, rds, appStore, embedder)
+// fatal logs a startup error and exits.
+func fatal(what string, err error) {
+	slog.Error(what, "err", err)
+	os.Exit(1)
+}
+
+// toggleSlowQueries switches slow query logging on or off at each SIGUSR1,
+// until ctx is cancelled.
+func toggleSlowQueries(ctx context.Context, tracer *store.SlowQueryTracer) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			on := !tracer.Enabled()
+			tracer.SetEnabled(on)
+			slog.Info("slow query logging toggled (SIGUSR1)", "enabled", on, "threshold", tracer.Threshold())
+		}
 	}
+}
 
-	srv := server.New(appStore, cfg, embedder, rds)
-	if err := srv.ListenAndServe(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "server: %v\n", err)
-		os.Exit(1)
+// runWatchPruner deletes watch events older than retention at startup and
+// then hourly, until ctx is cancelled.
+func runWatchPruner(ctx context.Context, s store.Store, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		n, err := s.PruneWatchEvents(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			slog.Error("prune watch history", "err", err)
+		} else if n > 0 {
+			slog.Info("pruned watch history", "events", n, "retention", retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runArchivePruner deletes channels archived longer than retention ago at
+// startup and then hourly, until ctx is cancelled.
+func runArchivePruner(ctx context.Context, s store.Store, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		n, err := s.PruneArchivedChannels(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			slog.Error("prune archived channels", "err", err)
+		} else if n > 0 {
+			slog.Info("pruned archived channels", "channels", n, "retention", retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
-// runEmbeddingWorker continuously dequeues embedding jobs from Redis and
-// processes them. It stops when ctx is cancelled (graceful shutdown).
-func runEmbeddingWorker(ctx context.Context, rds *cache.Redis, s store.Store, embedder *embedding.Client) {
-	log.Println("embedding worker started")
+// runJobWorker continuously dequeues ingest and embedding jobs from Redis and
+// processes them. Failed jobs are retried with backoff and dead-lettered
+// after maxAttempts; a job interrupted by shutdown goes back on the queue.
+// It stops when ctx is cancelled (graceful shutdown).
+func runJobWorker(ctx context.Context, rds *cache.Redis, runner *jobs.Runner, maxAttempts int, backoff time.Duration) {
+	consumer := cache.NewConsumer(rds, cache.DefaultQueue, maxAttempts, backoff)
+	go consumer.Heartbeat(ctx)
+	go consumer.RunReaper(ctx, 15*time.Second)
+
+	logger := slog.With("component", "worker")
+	logger.Info("job worker started")
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("embedding worker stopping")
+			logger.Info("job worker stopping")
 			return
 		default:
 		}
 
-		job, err := cache.Dequeue(ctx, rds, cache.DefaultQueue, 5*time.Second)
+		d, err := consumer.Next(ctx, 5*time.Second)
 		if err != nil {
-			log.Printf("embedding worker: dequeue error: %v", err)
+			if !errors.Is(err, cache.ErrUnavailable) {
+				logger.Error("dequeue", "err", err)
+			}
 			time.Sleep(2 * time.Second)
 			continue
 		}
-		if job == nil {
+		if d == nil {
 			continue // timeout, loop back to check ctx
 		}
+		job := d.Job
 
-		log.Printf("embedding worker: processing job source_id=%d source=%q embeddings_only=%v",
-			job.SourceID, job.SourceName, job.EmbeddingsOnly)
+		jobLog := logger.With("job_id", job.ID, "kind", job.Kind, "source_id", job.SourceID, "source", job.SourceName)
+		jobLog.Info("processing job", "attempt", job.Attempts+1)
 
-		if job.EmbeddingsOnly {
-			if _, err := service.RefreshEmbeddings(ctx, s, embedder, job.SourceID, job.SourceName); err != nil {
-				log.Printf("embedding worker: RefreshEmbeddings error: %v", err)
+		_, runErr := runner.Run(ctx, job)
+
+		// The queue must be updated even when shutdown cancelled the job.
+		qctx := context.WithoutCancel(ctx)
+		switch {
+		case ctx.Err() != nil:
+			if err := consumer.Requeue(qctx, d); err != nil {
+				jobLog.Error("requeue job", "err", err)
+			}
+		case errors.Is(runErr, jobs.ErrCancelled):
+			// Cancelled through the API: done with, not retried.
+			if err := consumer.Ack(qctx, d); err != nil {
+				jobLog.Error("ack job", "err", err)
+			}
+		case runErr != nil:
+			retryAt, err := consumer.Fail(qctx, d, runErr)
+			switch {
+			case err != nil:
+				jobLog.Error("fail job", "err", err)
+			case retryAt.IsZero():
+				jobLog.Warn("job moved to the dead-letter list", "attempts", job.Attempts+1)
+			default:
+				jobLog.Warn("job failed, will retry", "retry_at", retryAt.Format(time.RFC3339))
+				runner.Retrying(qctx, job, job.Attempts+1, retryAt)
+			}
+		default:
+			if err := consumer.Ack(qctx, d); err != nil {
+				jobLog.Error("ack job", "err", err)
 			}
 		}
 	}
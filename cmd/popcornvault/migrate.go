@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/logging"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// exitDirty is the exit code of migrate version when the last migration
+// failed halfway.
+const exitDirty = 3
+
+const migrateUsage = `usage: popcornvault migrate [-config file] <command>
+
+Commands:
+  up         apply all pending migrations
+  down [N]   revert the last N migrations (default 1)
+  version    print the schema version, followed by "dirty" if the last
+             migration failed halfway (exit status 3)
+  force N    set the schema version to N and clear the dirty flag without
+             running anything, after repairing the schema by hand
+             (-1 means no migration applied)
+
+Flags:
+`
+
+// runMigrate runs the migrate subcommand with args (after "migrate") and
+// returns the process exit code. Errors go to stderr; the schema version is
+// printed to stdout.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), migrateUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+
+	usageErr := func(format string, a ...any) int {
+		fmt.Fprintf(os.Stderr, "popcornvault migrate %s: %s\n", cmd, fmt.Sprintf(format, a...))
+		return exitUsage
+	}
+	var steps, version int
+	switch cmd {
+	case "up", "version":
+		if len(rest) != 0 {
+			return usageErr("takes no arguments")
+		}
+	case "down":
+		steps = 1
+		if len(rest) > 1 {
+			return usageErr("takes at most one argument")
+		}
+		if len(rest) == 1 {
+			n, err := strconv.Atoi(rest[0])
+			if err != nil || n <= 0 {
+				return usageErr("step count must be a positive integer, got %q", rest[0])
+			}
+			steps = n
+		}
+	case "force":
+		if len(rest) != 1 {
+			return usageErr("takes exactly one argument, the version")
+		}
+		n, err := strconv.Atoi(rest[0])
+		if err != nil || n < -1 {
+			return usageErr("version must be an integer >= -1, got %q", rest[0])
+		}
+		version = n
+	default:
+		fmt.Fprintf(os.Stderr, "popcornvault migrate: unknown command %q\n", cmd)
+		fs.Usage()
+		return exitUsage
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault migrate: config: %v\n", err)
+		return exitFailure
+	}
+	if err := logging.Setup(cfg.LogFormat, cfg.LogLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault migrate: config: %v\n", err)
+		return exitFailure
+	}
+
+	switch cmd {
+	case "up":
+		err = migrateUp(cfg)
+	case "down":
+		err = store.RollbackMigrations(cfg.DatabaseURL, cfg.MigrationsPath, steps)
+	case "force":
+		err = store.ForceMigrationVersion(cfg.DatabaseURL, cfg.MigrationsPath, version)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault migrate %s: %v\n", cmd, err)
+		return exitFailure
+	}
+	return printMigrationVersion(cfg)
+}
+
+// printMigrationVersion prints the schema version to stdout and returns
+// exitDirty when the last migration failed halfway.
+func printMigrationVersion(cfg *config.Config) int {
+	v, dirty, err := store.MigrationVersion(cfg.DatabaseURL, cfg.MigrationsPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault migrate version: %v\n", err)
+		return exitFailure
+	}
+	if dirty {
+		fmt.Printf("%d dirty\n", v)
+		return exitDirty
+	}
+	fmt.Println(v)
+	return exitOK
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// Search modes, as accepted by GET /api/channels/search.
+const (
+	searchModeSemantic = "semantic"
+	searchModeHybrid   = "hybrid"
+	searchModeLexical  = "lexical"
+)
+
+// runSearch runs the search subcommand: it ranks channels against -q like
+// GET /api/channels/search and prints them as a table, or with -json in the
+// API's response shape. Without VOYAGE_API_KEY it searches channel names.
+func runSearch(args []string) int {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Optional config file path (YAML); else use env DATABASE_URL")
+	query := fs.String("q", "", "Search text (required)")
+	limit := fs.Int("limit", 20, "Number of results (1-200)")
+	mode := fs.String("mode", searchModeSemantic, "semantic, hybrid or lexical")
+	sourceID := fs.Int64("source-id", 0, "Only search this source")
+	asJSON := fs.Bool("json", false, "Print the results as JSON")
+	cfg, code := parseCommand(fs, args, configPath)
+	if cfg == nil {
+		return code
+	}
+	if *query == "" {
+		fmt.Fprintln(os.Stderr, "popcornvault search: -q is required")
+		return exitUsage
+	}
+	switch *mode {
+	case searchModeSemantic, searchModeHybrid, searchModeLexical:
+	default:
+		fmt.Fprintf(os.Stderr, "popcornvault search: invalid -mode %q (want semantic, hybrid or lexical)\n", *mode)
+		return exitUsage
+	}
+	if *limit < 1 || *limit > 200 {
+		fmt.Fprintf(os.Stderr, "popcornvault search: -limit must be from 1 to 200, got %d\n", *limit)
+		return exitUsage
+	}
+
+	ctx := context.Background()
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault search: %v\n", err)
+		return exitFailure
+	}
+	defer a.Close()
+
+	filter := store.ChannelFilter{Limit: *limit}
+	if *sourceID > 0 {
+		filter.SourceID = sourceID
+	}
+	if a.embedder == nil {
+		*mode = searchModeLexical
+	}
+
+	var results []store.SemanticResult
+	var total int
+	if *mode == searchModeLexical {
+		filter.Search = *query
+		filter.Rank = true
+		var channels []models.Channel
+		channels, total, err = a.store.ListChannels(ctx, filter)
+		for _, ch := range channels {
+			results = append(results, store.SemanticResult{Channel: ch})
+		}
+	} else {
+		// Vectors of other models are not comparable with the query's.
+		filter.EmbeddingModel = a.embedder.Model()
+		res, embedErr := a.embedder.Embed(ctx, []string{*query}, "query")
+		switch {
+		case embedErr != nil:
+			err = fmt.Errorf("embed query: %w", embedErr)
+		case len(res.Embeddings) == 0 || len(res.Embeddings[0]) == 0:
+			err = fmt.Errorf("empty embedding returned")
+		case *mode == searchModeHybrid:
+			results, total, err = a.store.HybridSearch(ctx, res.Embeddings[0], *query, filter)
+		default:
+			results, total, err = a.store.SemanticSearch(ctx, res.Embeddings[0], filter)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "popcornvault search: %v\n", err)
+		return exitFailure
+	}
+	if results == nil {
+		results = []store.SemanticResult{}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{
+			"channels": results,
+			"total":    total,
+			"limit":    filter.Limit,
+			"offset":   0,
+			"mode":     *mode,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "popcornvault search: %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSCORE\tNAME\tGROUP\tTYPE\tSOURCE")
+	for _, r := range results {
+		score := "-"
+		switch {
+		case *mode == searchModeHybrid:
+			score = strconv.FormatFloat(r.Score, 'f', 4, 64)
+		case *mode == searchModeSemantic:
+			score = strconv.FormatFloat(r.Similarity, 'f', 4, 64)
+		}
+		group := ""
+		if r.Channel.GroupName != nil {
+			group = *r.Channel.GroupName
+		} else if r.Channel.Group != nil {
+			group = *r.Channel.Group
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\n", r.Channel.ID, score, r.Channel.Name, group,
+			models.MediaTypeName(r.Channel.MediaType), r.Channel.SourceID)
+	}
+	if err := tw.Flush(); err != nil {
+		return exitFailure
+	}
+	fmt.Printf("%d of %d matches (%s)\n", len(results), total, *mode)
+	return exitOK
+}
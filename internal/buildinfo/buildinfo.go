@@ -0,0 +1,47 @@
+// Package buildinfo describes the running build. Version, Commit and Date
+// are set at link time:
+//
+//	go build -ldflags "-X github.com/voyagen/popcornvault/internal/buildinfo.Version=1.4.0 \
+//	  -X github.com/voyagen/popcornvault/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/voyagen/popcornvault/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X; the defaults mark a development build.
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// Info is the build description served by GET /api/version.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build description. Without -ldflags, the commit and date
+// recorded by the go command from the VCS checkout are used when present.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "unknown":
+				info.Commit = s.Value
+				if len(info.Commit) > 12 {
+					info.Commit = info.Commit[:12]
+				}
+			case s.Key == "vcs.time" && info.BuildDate == "unknown":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Blob is a cached binary payload, such as a channel logo.
+type Blob struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// BlobStore caches blobs with a TTL. Get returns (nil, nil) on a miss.
+type BlobStore interface {
+	Get(ctx context.Context, key string) (*Blob, error)
+	Set(ctx context.Context, key string, b *Blob, ttl time.Duration) error
+}
+
+// RedisBlobs stores blobs in Redis.
+type RedisBlobs struct {
+	r *Redis
+}
+
+// NewRedisBlobs returns a BlobStore backed by r.
+func NewRedisBlobs(r *Redis) *RedisBlobs {
+	return &RedisBlobs{r: r}
+}
+
+// Get implements BlobStore.
+func (s *RedisBlobs) Get(ctx context.Context, key string) (*Blob, error) {
+	b, err := Get[Blob](ctx, s.r, key)
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// Set implements BlobStore.
+func (s *RedisBlobs) Set(ctx context.Context, key string, b *Blob, ttl time.Duration) error {
+	return Set(ctx, s.r, key, b, ttl)
+}
+
+// DiskBlobs stores blobs as files in a directory, for running without
+// Redis. Each file starts with a header line holding the expiry time and
+// content type; expired files are ignored and overwritten on the next Set.
+type DiskBlobs struct {
+	dir string
+}
+
+// NewDiskBlobs returns a BlobStore that keeps files in dir, creating it if
+// needed.
+func NewDiskBlobs(dir string) (*DiskBlobs, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("blob cache dir: %w", err)
+	}
+	return &DiskBlobs{dir: dir}, nil
+}
+
+func (s *DiskBlobs) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:16]))
+}
+
+// Get implements BlobStore.
+func (s *DiskBlobs) Get(_ context.Context, key string) (*Blob, error) {
+	raw, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("blob read: %w", err)
+	}
+	header, data, ok := bytes.Cut(raw, []byte("\n"))
+	if !ok {
+		return nil, nil // truncated write; treat as a miss
+	}
+	expiry, contentType, _ := bytes.Cut(header, []byte(" "))
+	exp, err := strconv.ParseInt(string(expiry), 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return nil, nil
+	}
+	return &Blob{ContentType: string(contentType), Data: data}, nil
+}
+
+// Set implements BlobStore. The file is written under a temporary name and
+// renamed, so concurrent readers never see a partial blob.
+func (s *DiskBlobs) Set(_ context.Context, key string, b *Blob, ttl time.Duration) error {
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("blob write: %w", err)
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+
+	_, err = fmt.Fprintf(f, "%d %s\n", time.Now().Add(ttl).Unix(), b.ContentType)
+	if err == nil {
+		_, err = f.Write(b.Data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("blob write: %w", err)
+	}
+	if err := os.Rename(f.Name(), s.path(key)); err != nil {
+		return fmt.Errorf("blob write: %w", err)
+	}
+	return nil
+}
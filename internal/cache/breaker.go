@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrUnavailable is returned by every Redis command while the breaker is
+// open, without contacting Redis.
+var ErrUnavailable = errors.New("cache: redis unavailable")
+
+// Breaker defaults, used for zero Options fields.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// probeTimeout bounds the ping that checks whether Redis is back.
+const probeTimeout = 2 * time.Second
+
+type probeKey struct{}
+
+// breaker is a go-redis hook that stops sending commands to Redis after
+// threshold consecutive failures, so an outage costs callers an immediate
+// ErrUnavailable instead of a connection timeout each. While it is open a
+// background probe pings Redis every cooldown and closes it once Redis
+// answers. Opening and closing are logged once each.
+type breaker struct {
+	client    redis.UniversalClient
+	threshold int64
+	cooldown  time.Duration
+
+	failures atomic.Int64 // consecutive
+	open     atomic.Bool
+	done     chan struct{} // closed by stop to end the probe
+	stopOnce sync.Once
+}
+
+func newBreaker(client redis.UniversalClient, threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &breaker{client: client, threshold: int64(threshold), cooldown: cooldown, done: make(chan struct{})}
+}
+
+// allow reports whether a command may go to Redis.
+func (b *breaker) allow(ctx context.Context) bool {
+	return !b.open.Load() || ctx.Value(probeKey{}) != nil
+}
+
+// record counts the outcome of a command. Replies such as redis.Nil or a
+// WRONGTYPE error mean Redis answered; a cancelled caller says nothing
+// about Redis either way.
+func (b *breaker) record(ctx context.Context, err error) {
+	var reply redis.Error
+	switch {
+	case err == nil || errors.As(err, &reply):
+		b.failures.Store(0)
+	case ctx.Err() != nil || errors.Is(err, ErrUnavailable):
+	default:
+		if b.failures.Add(1) >= b.threshold && b.open.CompareAndSwap(false, true) {
+			slog.Warn("redis: unavailable, skipping it", "for", b.cooldown, "err", err)
+			go b.probe()
+		}
+	}
+}
+
+// probe pings Redis every cooldown until it answers, then closes the
+// breaker.
+func (b *breaker) probe() {
+	ticker := time.NewTicker(b.cooldown)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), probeKey{}, true), probeTimeout)
+		err := b.client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			b.failures.Store(0)
+			b.open.Store(false)
+			slog.Info("redis: available again")
+			return
+		}
+	}
+}
+
+// stop ends a running probe.
+func (b *breaker) stop() {
+	b.stopOnce.Do(func() { close(b.done) })
+}
+
+// Available reports whether commands are being sent to Redis, that is
+// whether the breaker is closed.
+func (r *Redis) Available() bool {
+	return !r.breaker.open.Load()
+}
+
+// DialHook implements redis.Hook. Dials only follow commands, which are
+// already held back while the breaker is open.
+func (b *breaker) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook.
+func (b *breaker) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !b.allow(ctx) {
+			cmd.SetErr(ErrUnavailable)
+			return ErrUnavailable
+		}
+		err := next(ctx, cmd)
+		b.record(ctx, err)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (b *breaker) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !b.allow(ctx) {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrUnavailable)
+			}
+			return ErrUnavailable
+		}
+		err := next(ctx, cmds)
+		b.record(ctx, err)
+		return err
+	}
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Memory is an in-process Backend for running without Redis: a least
+// recently used cache bounded by its number of entries and the approximate
+// bytes of their keys and values, with a TTL per entry. Only the process
+// that holds it sees its entries.
+type Memory struct {
+	maxEntries int
+	maxBytes   int64
+
+	mu      sync.Mutex
+	bytes   int64
+	order   *list.List // of *memoryEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	data    []byte
+	expires time.Time // zero when the entry has no TTL
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.data))
+}
+
+// NewMemory returns an empty Memory holding at most maxEntries entries and
+// about maxBytes bytes.
+func NewMemory(maxEntries int, maxBytes int64) *Memory {
+	return &Memory{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// GetBytes implements Backend.
+func (m *Memory) GetBytes(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, redis.Nil
+	}
+	e := el.Value.(*memoryEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, redis.Nil
+	}
+	m.order.MoveToFront(el)
+	return e.data, nil
+}
+
+// SetBytes implements Backend. A value larger than the whole cache is not
+// stored.
+func (m *Memory) SetBytes(_ context.Context, key string, data []byte, ttl time.Duration) error {
+	e := &memoryEntry{key: key, data: bytes.Clone(data)}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	if e.size() > m.maxBytes {
+		return nil
+	}
+	m.entries[key] = m.order.PushFront(e)
+	m.bytes += e.size()
+	for m.order.Len() > m.maxEntries || m.bytes > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Delete implements Backend.
+func (m *Memory) Delete(_ context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := m.entries[key]; ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// DeletePattern implements Backend. The pattern is matched with path.Match,
+// which agrees with Redis globs for keys without slashes.
+func (m *Memory) DeletePattern(_ context.Context, pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, el := range m.entries {
+		if ok, _ := path.Match(pattern, key); ok {
+			m.remove(el)
+		}
+	}
+	return nil
+}
+
+// CountPattern implements Backend, matching like DeletePattern. Expired
+// entries not evicted yet are counted too.
+func (m *Memory) CountPattern(_ context.Context, pattern string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for key := range m.entries {
+		if ok, _ := path.Match(pattern, key); ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// remove drops an entry; m.mu must be held.
+func (m *Memory) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.bytes -= e.size()
+}
@@ -2,38 +2,179 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/voyagen/popcornvault/internal/logging"
+)
+
+// Job kinds understood by the background worker.
+const (
+	JobIngest     = "ingest"
+	JobEmbeddings = "embeddings"
+	JobEPG        = "epg"
+	JobCheck      = "check"
+	JobReindex    = "reindex"
 )
 
-// EmbeddingJob describes a background embedding generation task.
-type EmbeddingJob struct {
-	SourceID       int64   `json:"source_id"`
-	SourceName     string  `json:"source_name"`
-	ChannelIDs     []int64 `json:"channel_ids,omitempty"`
-	EmbeddingsOnly bool    `json:"embeddings_only"`
+// Job describes a background task: a full M3U ingest of a new source
+// (JobIngest), embedding generation for an existing one (JobEmbeddings), or
+// an XMLTV guide refresh (JobEPG, with URL set to the guide URL), a
+// stream health check of its channels (JobCheck), or a rebuild of the
+// embedding index (JobReindex, not tied to a source).
+type Job struct {
+	ID             string            `json:"id,omitempty"`
+	Kind           string            `json:"kind"`
+	SourceID       int64             `json:"source_id"`
+	SourceName     string            `json:"source_name"`
+	URL            string            `json:"url,omitempty"`
+	UserAgent      string            `json:"user_agent,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"` // extra playlist request headers
+	UseTvgID       bool              `json:"use_tvg_id,omitempty"`
+	NoMediaGuess   bool              `json:"no_media_guess,omitempty"` // see service.IngestOptions.NoGuess
+	Dedupe         bool              `json:"dedupe,omitempty"`
+	DeadPolicy     string            `json:"dead_policy,omitempty"`    // check jobs: see service.CheckOptions
+	DeadThreshold  int               `json:"dead_threshold,omitempty"` // check jobs: see service.CheckOptions
+	ChannelIDs     []int64           `json:"channel_ids,omitempty"`
+	EmbeddingsOnly bool              `json:"embeddings_only"`
+	MissingOnly    bool              `json:"missing_only,omitempty"` // embeddings jobs: only channels without a current embedding
+	Force          bool              `json:"force,omitempty"`        // ingest even if the playlist is unchanged, and re-embed unchanged channels
+	RequestID      string            `json:"request_id,omitempty"`   // the API request that queued the job, for log correlation
+
+	// Delivery bookkeeping, maintained by Enqueue and Consumer.
+	Attempts   int        `json:"attempts,omitempty"` // failed or lost deliveries so far
+	EnqueuedAt *time.Time `json:"enqueued_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	FailedAt   *time.Time `json:"failed_at,omitempty"` // when the last attempt failed
 }
 
-// DefaultQueue is the Redis list key used for the embedding job queue.
+// DefaultQueue is the Redis list key used for the background job queue.
+// The name predates ingest jobs and is kept so queued work survives upgrades.
 const DefaultQueue = "popcornvault:jobs:embeddings"
 
-// Enqueue pushes a job onto the left side of a Redis list.
-func Enqueue(ctx context.Context, r *Redis, queue string, job EmbeddingJob) error {
+// Enqueue pushes a job onto the left side of a Redis list. A job without a
+// RequestID takes the one carried by ctx.
+func Enqueue(ctx context.Context, r *Redis, queue string, job Job) error {
+	if job.RequestID == "" {
+		job.RequestID = logging.RequestID(ctx)
+	}
+	if job.EnqueuedAt == nil {
+		now := time.Now()
+		job.EnqueuedAt = &now
+	}
 	data, err := json.Marshal(job)
 	if err != nil {
 		return fmt.Errorf("queue marshal: %w", err)
 	}
-	return r.client.LPush(ctx, queue, data).Err()
+	return r.client.LPush(ctx, r.queueKey(queue), data).Err()
+}
+
+// queueKey returns the key of queue. On a cluster the name is wrapped in a
+// hash tag, so the queue and the keys derived from it share a slot and the
+// commands and scripts that move jobs between them are allowed.
+func (r *Redis) queueKey(queue string) string {
+	if !r.cluster || strings.Contains(queue, "{") {
+		return queue
+	}
+	return "{" + queue + "}"
 }
 
-// Dequeue blocks until a job is available on the right side of the list
-// or the timeout expires. When the timeout elapses without a job,
-// (nil, nil) is returned so the caller can loop and check for shutdown.
-func Dequeue(ctx context.Context, r *Redis, queue string, timeout time.Duration) (*EmbeddingJob, error) {
-	result, err := r.client.BRPop(ctx, timeout, queue).Result()
+// Keys derived from a queue name. Jobs wait in the queue list itself; a
+// consumer moves each one into its own processing list while it runs.
+func processingKey(queue, consumer string) string { return queue + ":processing:" + consumer }
+func consumerKey(queue, consumer string) string   { return queue + ":consumer:" + consumer }
+func delayedKey(queue string) string              { return queue + ":delayed" }
+func deadKey(queue string) string                 { return queue + ":dead" }
+
+const (
+	// consumerTTL is how long a consumer counts as alive after its last
+	// heartbeat; the jobs of a consumer gone longer are requeued.
+	consumerTTL       = 30 * time.Second
+	heartbeatInterval = 10 * time.Second
+
+	// maxDeadJobs caps the dead-letter list; older entries are dropped.
+	maxDeadJobs = 1000
+)
+
+// moveScript removes ARGV[1] from the list KEYS[1] and, only if it was
+// there, adds ARGV[2] to KEYS[2] with ARGV[3] (LPUSH, RPUSH or ZADD with
+// score ARGV[4]). Checking the removal makes concurrent reapers and a late
+// consumer safe: only one of them moves the job.
+var moveScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+if ARGV[3] == 'ZADD' then
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[2])
+else
+	redis.call(ARGV[3], KEYS[2], ARGV[2])
+end
+return 1
+`)
+
+// promoteScript moves up to ARGV[2] jobs from the delayed set KEYS[1] whose
+// retry time (score) is at most ARGV[1] onto the queue KEYS[2].
+var promoteScript = redis.NewScript(`
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, job in ipairs(jobs) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('LPUSH', KEYS[2], job)
+end
+return #jobs
+`)
+
+// Delivery is a job taken off the queue by a Consumer. It stays in the
+// consumer's processing list until it is acknowledged, failed or requeued.
+type Delivery struct {
+	Job Job
+	raw string // the queued JSON, which identifies it in the processing list
+}
+
+// Consumer takes jobs off a queue reliably: a job moves atomically into the
+// consumer's processing list, so a crash leaves it there instead of losing
+// it, and Reap puts it back once the consumer's heartbeat stops. Failed jobs
+// are retried with exponential backoff and moved to a dead-letter list after
+// MaxAttempts.
+type Consumer struct {
+	r     *Redis
+	queue string
+	id    string
+
+	MaxAttempts int           // attempts before a job is dead-lettered; <= 1 disables retries
+	Backoff     time.Duration // delay before the first retry, doubled after each one
+}
+
+// NewConsumer returns a consumer of queue with a unique id.
+func NewConsumer(r *Redis, queue string, maxAttempts int, backoff time.Duration) *Consumer {
+	host, _ := os.Hostname()
+	var b [4]byte
+	rand.Read(b[:])
+	return &Consumer{
+		r:           r,
+		queue:       r.queueKey(queue),
+		id:          fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b[:])),
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+	}
+}
+
+// Next blocks until a job is available or the timeout expires, and moves
+// it into the consumer's processing list. When the timeout elapses without
+// a job, or ctx is cancelled, (nil, nil) is returned so the caller can loop
+// and check for shutdown.
+func (c *Consumer) Next(ctx context.Context, timeout time.Duration) (*Delivery, error) {
+	raw, err := c.r.client.BLMove(ctx, c.queue, processingKey(c.queue, c.id), "RIGHT", "LEFT", timeout).Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil // timeout, no job available
@@ -44,13 +185,177 @@ func Dequeue(ctx context.Context, r *Redis, queue string, timeout time.Duration)
 		}
 		return nil, fmt.Errorf("queue dequeue: %w", err)
 	}
-	// BRPop returns [key, value].
-	if len(result) < 2 {
-		return nil, nil
-	}
-	var job EmbeddingJob
-	if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+	var job Job
+	if err := json.Unmarshal([]byte(raw), &job); err != nil {
+		// An unreadable job would fail forever; set it aside.
+		c.r.client.LRem(ctx, processingKey(c.queue, c.id), 1, raw)
+		c.r.client.LPush(ctx, deadKey(c.queue), raw)
 		return nil, fmt.Errorf("queue unmarshal: %w", err)
 	}
-	return &job, nil
+	// Jobs queued before kinds existed were always embedding jobs.
+	if job.Kind == "" {
+		job.Kind = JobEmbeddings
+	}
+	return &Delivery{Job: job, raw: raw}, nil
+}
+
+// Ack removes a finished job from the processing list.
+func (c *Consumer) Ack(ctx context.Context, d *Delivery) error {
+	if err := c.r.client.LRem(ctx, processingKey(c.queue, c.id), 1, d.raw).Err(); err != nil {
+		return fmt.Errorf("queue ack: %w", err)
+	}
+	return nil
+}
+
+// Requeue puts a job that was interrupted (e.g. by shutdown) back at the
+// front of the queue without counting an attempt.
+func (c *Consumer) Requeue(ctx context.Context, d *Delivery) error {
+	return c.move(ctx, processingKey(c.queue, c.id), d.raw, d.raw, c.queue, "RPUSH", 0)
+}
+
+// Fail records a failed attempt. The job is scheduled for another attempt
+// after the backoff delay, or moved to the dead-letter list once it has
+// used MaxAttempts; retryAt is zero in that case.
+func (c *Consumer) Fail(ctx context.Context, d *Delivery, jobErr error) (retryAt time.Time, err error) {
+	return c.fail(ctx, processingKey(c.queue, c.id), d.raw, d.Job, jobErr.Error())
+}
+
+func (c *Consumer) fail(ctx context.Context, from, raw string, job Job, reason string) (time.Time, error) {
+	now := time.Now()
+	job.Attempts++
+	job.LastError = reason
+	job.FailedAt = &now
+	data, err := json.Marshal(job)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("queue marshal: %w", err)
+	}
+
+	if job.Attempts >= c.MaxAttempts {
+		if err := c.move(ctx, from, raw, string(data), deadKey(c.queue), "LPUSH", 0); err != nil {
+			return time.Time{}, err
+		}
+		c.r.client.LTrim(ctx, deadKey(c.queue), 0, maxDeadJobs-1)
+		return time.Time{}, nil
+	}
+
+	retryAt := now.Add(c.backoff(job.Attempts))
+	if err := c.move(ctx, from, raw, string(data), delayedKey(c.queue), "ZADD", float64(retryAt.UnixMilli())); err != nil {
+		return time.Time{}, err
+	}
+	return retryAt, nil
+}
+
+// backoff returns the delay after the given (1-based) failed attempt.
+func (c *Consumer) backoff(attempt int) time.Duration {
+	d := c.Backoff << (attempt - 1)
+	if d <= 0 || d > time.Hour {
+		d = time.Hour
+	}
+	return d
+}
+
+func (c *Consumer) move(ctx context.Context, from, raw, data, to, op string, score float64) error {
+	err := moveScript.Run(ctx, c.r.client, []string{from, to}, raw, data, op, score).Err()
+	if err != nil {
+		return fmt.Errorf("queue move: %w", err)
+	}
+	return nil
+}
+
+// Heartbeat keeps the consumer alive for Reap until ctx is done, then
+// removes its liveness key.
+func (c *Consumer) Heartbeat(ctx context.Context) {
+	key := consumerKey(c.queue, c.id)
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.r.client.Set(ctx, key, time.Now().Unix(), consumerTTL).Err(); err != nil && ctx.Err() == nil && !errors.Is(err, ErrUnavailable) {
+			slog.Warn("queue: heartbeat", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			c.r.client.Del(context.WithoutCancel(ctx), key)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap moves delayed retries that are due onto the queue, and recovers the
+// jobs of consumers whose heartbeat has stopped: each counts as a failed
+// attempt, so a job that keeps crashing its worker ends up dead-lettered.
+// It is safe to run from several processes at once.
+func (c *Consumer) Reap(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := promoteScript.Run(ctx, c.r.client, []string{delayedKey(c.queue), c.queue}, now, 100).Err(); err != nil {
+		return fmt.Errorf("queue promote: %w", err)
+	}
+
+	// The processing lists share the queue's hash tag, so on a cluster
+	// only one master has any; scanning them all keeps this simple.
+	prefix := processingKey(c.queue, "")
+	var mu sync.Mutex
+	return c.r.scan(ctx, prefix+"*", func(ctx context.Context, keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, key := range keys {
+			id := strings.TrimPrefix(key, prefix)
+			alive, err := c.r.client.Exists(ctx, consumerKey(c.queue, id)).Result()
+			if err != nil {
+				return fmt.Errorf("queue exists: %w", err)
+			}
+			if alive > 0 {
+				continue
+			}
+			raws, err := c.r.client.LRange(ctx, key, 0, -1).Result()
+			if err != nil {
+				return fmt.Errorf("queue lrange: %w", err)
+			}
+			for _, raw := range raws {
+				var job Job
+				if err := json.Unmarshal([]byte(raw), &job); err != nil {
+					c.move(ctx, key, raw, raw, deadKey(c.queue), "LPUSH", 0)
+					continue
+				}
+				if _, err := c.fail(ctx, key, raw, job, "worker stopped while running the job"); err != nil {
+					return err
+				}
+				slog.Info("queue: recovered job from stopped worker", "job_id", job.ID, "kind", job.Kind, "worker", id)
+			}
+		}
+		return nil
+	})
+}
+
+// RunReaper calls Reap every interval until ctx is done.
+func (c *Consumer) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Reap(ctx); err != nil && ctx.Err() == nil && !errors.Is(err, ErrUnavailable) {
+			slog.Warn("queue: reap", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeadJobs returns up to limit dead-lettered jobs of queue, newest first.
+func DeadJobs(ctx context.Context, r *Redis, queue string, limit int) ([]Job, error) {
+	raws, err := r.client.LRange(ctx, deadKey(r.queueKey(queue)), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("queue dead jobs: %w", err)
+	}
+	jobs := make([]Job, 0, len(raws))
+	for _, raw := range raws {
+		var job Job
+		if err := json.Unmarshal([]byte(raw), &job); err != nil {
+			continue // unreadable entries are kept for manual inspection only
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
 }
@@ -3,95 +3,316 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 // Redis wraps a go-redis client with convenience helpers for JSON
-// serialisation, pattern deletion, and health checks.
+// serialisation, pattern deletion, and health checks. The client is a
+// single server, a Sentinel-managed master or a Cluster; see Options.
 type Redis struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// cluster is set when client is a *redis.ClusterClient, whose keys are
+	// spread over shards: SCAN must visit every master, multi-key commands
+	// must stay in one hash slot.
+	cluster bool
+	breaker *breaker
 }
 
-// New parses a Redis URL (e.g. "redis://host:6379/0") and returns a
-// connected client. Call Ping to verify the connection.
-func New(rawURL string) (*Redis, error) {
+// Options select the Redis deployment. URL alone is enough for a single
+// server, and its scheme can also select Sentinel or Cluster:
+//
+//	redis://[user:pass@]host:6379/0
+//	rediss://...                       (TLS)
+//	redis+sentinel://[sentinel-user:pass@]host:26379/0?master_name=mymaster&addr=host2:26379
+//	redis+cluster://[user:pass@]host:7000?addr=host2:7000
+//
+// rediss+sentinel:// and rediss+cluster:// use TLS. A plain redis:// URL
+// with a master_name parameter is also taken as Sentinel.
+//
+// The other fields select the same modes explicitly. With SentinelMaster,
+// URL provides the master's credentials, database and TLS, and its host is
+// ignored in favour of SentinelAddrs. With Cluster, URL is read as a
+// cluster URL whose host and addr parameters are the seed nodes.
+type Options struct {
+	URL              string
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelPassword string
+	Cluster          bool
+
+	// Consecutive failures after which Redis is skipped, and how often it
+	// is then probed; zero uses DefaultBreakerThreshold and
+	// DefaultBreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// URL scheme suffixes that select Sentinel or Cluster.
+const (
+	sentinelScheme = "+sentinel"
+	clusterScheme  = "+cluster"
+)
+
+// New returns a client for the deployment o describes. Call Ping to verify
+// the connection.
+func New(o Options) (*Redis, error) {
+	rawURL, mode := o.URL, ""
+	if u, err := url.Parse(rawURL); err == nil {
+		if base, ok := strings.CutSuffix(u.Scheme, sentinelScheme); ok {
+			u.Scheme, mode = base, sentinelScheme
+		} else if base, ok := strings.CutSuffix(u.Scheme, clusterScheme); ok {
+			u.Scheme, mode = base, clusterScheme
+		} else if u.Query().Has("master_name") {
+			mode = sentinelScheme
+		}
+		rawURL = u.String()
+	}
+	switch {
+	case o.Cluster && o.SentinelMaster != "":
+		return nil, errors.New("redis: sentinel and cluster are exclusive")
+	case o.Cluster:
+		mode = clusterScheme
+	case o.SentinelMaster != "":
+		if mode != "" {
+			return nil, errors.New("redis: sentinel master set with a sentinel or cluster url")
+		}
+		return newSentinel(rawURL, o)
+	}
+
+	switch mode {
+	case sentinelScheme:
+		opts, err := redis.ParseFailoverURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redis sentinel url: %w", err)
+		}
+		if opts.MasterName == "" {
+			return nil, errors.New("parse redis sentinel url: master_name is required")
+		}
+		return newRedis(redis.NewFailoverClient(opts), o), nil
+	case clusterScheme:
+		opts, err := redis.ParseClusterURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redis cluster url: %w", err)
+		}
+		return newRedis(redis.NewClusterClient(opts), o), nil
+	}
 	opts, err := redis.ParseURL(rawURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse redis url: %w", err)
 	}
-	return &Redis{client: redis.NewClient(opts)}, nil
+	return newRedis(redis.NewClient(opts), o), nil
 }
 
-// Ping checks the connection to Redis.
+// newSentinel returns a client for the master o.SentinelMaster, found
+// through o.SentinelAddrs, that connects with the settings of rawURL.
+func newSentinel(rawURL string, o Options) (*Redis, error) {
+	if len(o.SentinelAddrs) == 0 {
+		return nil, errors.New("redis: sentinel master set without sentinel addresses")
+	}
+	base, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse redis url: %w", err)
+	}
+	return newRedis(redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:       o.SentinelMaster,
+		SentinelAddrs:    o.SentinelAddrs,
+		SentinelPassword: o.SentinelPassword,
+		Username:         base.Username,
+		Password:         base.Password,
+		DB:               base.DB,
+		TLSConfig:        base.TLSConfig,
+	}), o), nil
+}
+
+// newRedis wraps client, guarding it with a breaker configured by o.
+func newRedis(client redis.UniversalClient, o Options) *Redis {
+	_, cluster := client.(*redis.ClusterClient)
+	b := newBreaker(client, o.BreakerThreshold, o.BreakerCooldown)
+	client.AddHook(b)
+	return &Redis{client: client, cluster: cluster, breaker: b}
+}
+
+// Ping checks the connection to Redis. For a cluster it reaches a node,
+// which is enough to know the cluster is answering. While Redis is being
+// skipped it returns ErrUnavailable.
 func (r *Redis) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx).Err()
 }
 
-// Close shuts down the Redis client.
+// Close shuts down the Redis client and its connection pools.
 func (r *Redis) Close() error {
+	r.breaker.stop()
 	return r.client.Close()
 }
 
 // Client returns the underlying go-redis client for direct access.
-func (r *Redis) Client() *redis.Client {
+func (r *Redis) Client() redis.UniversalClient {
 	return r.client
 }
 
+// Backend is a key-value store with TTLs: Redis, or Memory when there is
+// none. A missing key is reported as redis.Nil by both.
+type Backend interface {
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	SetBytes(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	// Delete deletes exact keys.
+	Delete(ctx context.Context, keys ...string) error
+	// DeletePattern deletes the keys matching a glob pattern.
+	DeletePattern(ctx context.Context, pattern string) error
+	// CountPattern counts the keys matching a glob pattern.
+	CountPattern(ctx context.Context, pattern string) (int64, error)
+}
+
+// GetBytes implements Backend.
+func (r *Redis) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	return r.client.Get(ctx, key).Bytes()
+}
+
+// SetBytes implements Backend.
+func (r *Redis) SetBytes(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, data, ttl).Err()
+}
+
+// Delete implements Backend.
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	return r.del(ctx, keys)
+}
+
+// DeletePattern implements Backend. It uses SCAN so it is safe for
+// production, unlike KEYS; on a cluster it scans every master.
+func (r *Redis) DeletePattern(ctx context.Context, pattern string) error {
+	return r.scan(ctx, pattern, func(ctx context.Context, keys []string) error {
+		if err := r.del(ctx, keys); err != nil {
+			return fmt.Errorf("cache del pattern %s: %w", pattern, err)
+		}
+		return nil
+	})
+}
+
+// CountPattern implements Backend. Like DeletePattern it walks the keyspace
+// with SCAN.
+func (r *Redis) CountPattern(ctx context.Context, pattern string) (int64, error) {
+	var n atomic.Int64
+	err := r.scan(ctx, pattern, func(_ context.Context, keys []string) error {
+		n.Add(int64(len(keys)))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n.Load(), nil
+}
+
+// scan calls fn with each batch of keys matching pattern. A cluster's
+// masters are scanned concurrently, so fn must be safe for that.
+func (r *Redis) scan(ctx context.Context, pattern string, fn func(ctx context.Context, keys []string) error) error {
+	if cc, ok := r.client.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+			return scanNode(ctx, shard, pattern, fn)
+		})
+	}
+	return scanNode(ctx, r.client, pattern, fn)
+}
+
+// scanNode runs scan against a single server.
+func scanNode(ctx context.Context, c redis.Cmdable, pattern string, fn func(ctx context.Context, keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := c.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return fmt.Errorf("cache scan %s: %w", pattern, err)
+		}
+		if len(keys) > 0 {
+			if err := fn(ctx, keys); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// del deletes keys. A cluster refuses a DEL whose keys are in different
+// hash slots, so there each key gets its own DEL, pipelined.
+func (r *Redis) del(ctx context.Context, keys []string) error {
+	if !r.cluster {
+		return r.client.Del(ctx, keys...).Err()
+	}
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
 // --- generic JSON helpers ---
 
+var (
+	// ErrMissing is returned by Get for a key that SetMissing marked as
+	// holding nothing.
+	ErrMissing = errors.New("cache: cached as missing")
+	// ErrCodec is wrapped by the errors of Get and Set for values that
+	// cannot be decoded or encoded, as opposed to Redis failures.
+	ErrCodec = errors.New("cache: bad value")
+)
+
+// missingValue is stored by SetMissing. It is not valid JSON, so it cannot
+// be mistaken for a value.
+const missingValue = "\x00missing"
+
 // Get fetches a key and JSON-unmarshals the value into dst.
-// Returns redis.Nil when the key does not exist.
-func Get[T any](ctx context.Context, r *Redis, key string) (T, error) {
+// Returns redis.Nil when the key does not exist and ErrMissing when it was
+// set with SetMissing.
+func Get[T any](ctx context.Context, b Backend, key string) (T, error) {
 	var zero T
-	raw, err := r.client.Get(ctx, key).Bytes()
+	raw, err := b.GetBytes(ctx, key)
 	if err != nil {
 		return zero, err
 	}
+	if string(raw) == missingValue {
+		return zero, ErrMissing
+	}
 	var v T
 	if err := json.Unmarshal(raw, &v); err != nil {
-		return zero, fmt.Errorf("cache unmarshal %s: %w", key, err)
+		return zero, fmt.Errorf("%w: unmarshal %s: %w", ErrCodec, key, err)
 	}
 	return v, nil
 }
 
 // Set JSON-marshals v and stores it under key with the given TTL.
-func Set(ctx context.Context, r *Redis, key string, v any, ttl time.Duration) error {
+func Set(ctx context.Context, b Backend, key string, v any, ttl time.Duration) error {
 	data, err := json.Marshal(v)
 	if err != nil {
-		return fmt.Errorf("cache marshal %s: %w", key, err)
+		return fmt.Errorf("%w: marshal %s: %w", ErrCodec, key, err)
 	}
-	return r.client.Set(ctx, key, data, ttl).Err()
+	return b.SetBytes(ctx, key, data, ttl)
+}
+
+// SetMissing records under key, for the given TTL, that the value does not
+// exist; Get then returns ErrMissing.
+func SetMissing(ctx context.Context, b Backend, key string, ttl time.Duration) error {
+	return b.SetBytes(ctx, key, []byte(missingValue), ttl)
 }
 
 // Del deletes one or more exact keys.
-func Del(ctx context.Context, r *Redis, keys ...string) error {
+func Del(ctx context.Context, b Backend, keys ...string) error {
 	if len(keys) == 0 {
 		return nil
 	}
-	return r.client.Del(ctx, keys...).Err()
+	return b.Delete(ctx, keys...)
 }
 
 // DelPattern deletes all keys matching a glob pattern (e.g. "channels:*").
-// Uses SCAN so it is safe for production, unlike KEYS.
-func DelPattern(ctx context.Context, r *Redis, pattern string) error {
-	var cursor uint64
-	for {
-		keys, next, err := r.client.Scan(ctx, cursor, pattern, 100).Result()
-		if err != nil {
-			return fmt.Errorf("cache scan %s: %w", pattern, err)
-		}
-		if len(keys) > 0 {
-			if err := r.client.Del(ctx, keys...).Err(); err != nil {
-				return fmt.Errorf("cache del pattern %s: %w", pattern, err)
-			}
-		}
-		cursor = next
-		if cursor == 0 {
-			break
-		}
-	}
-	return nil
+func DelPattern(ctx context.Context, b Backend, pattern string) error {
+	return b.DeletePattern(ctx, pattern)
 }
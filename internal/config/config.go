@@ -1,8 +1,16 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // Config holds application configuration (DB, server, and optional fetcher settings).
@@ -10,39 +18,353 @@ type Config struct {
 	DatabaseURL  string        `yaml:"database_url" env:"DATABASE_URL"`
 	RedisURL     string        `yaml:"redis_url" env:"REDIS_URL"`
 	ServerPort   string        `yaml:"server_port" env:"SERVER_PORT"`
+	ServerListen string        `yaml:"server_listen" env:"SERVER_LISTEN"` // plain HTTP address or unix:// socket; overrides ServerPort
 	UserAgent    string        `yaml:"user_agent" env:"FETCHER_USER_AGENT"`
 	Timeout      time.Duration `yaml:"timeout" env:"FETCHER_TIMEOUT"`
+	MaxBodyBytes int64         `yaml:"max_body_bytes" env:"FETCHER_MAX_BODY_BYTES"` // 0 means no limit
+	Retries      int           `yaml:"retries" env:"FETCHER_RETRIES"`               // fetch attempts; 0 uses the fetcher default
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"FETCHER_RETRY_BACKOFF"`   // first retry delay; 0 uses the fetcher default
 	VoyageAPIKey string        `yaml:"voyage_api_key" env:"VOYAGE_API_KEY"`
+	VoyageModel  string        `yaml:"voyage_model" env:"VOYAGE_MODEL"` // empty uses the embedding package default
+
+	// Postgres connection pool; zero values keep the pgxpool defaults.
+	DBMaxConns          int           `yaml:"db_max_conns" env:"DB_MAX_CONNS"`
+	DBMinConns          int           `yaml:"db_min_conns" env:"DB_MIN_CONNS"`
+	DBMaxConnLifetime   time.Duration `yaml:"db_max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME"`
+	DBHealthCheckPeriod time.Duration `yaml:"db_health_check_period" env:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementTimeout  time.Duration `yaml:"db_statement_timeout" env:"DB_STATEMENT_TIMEOUT"` // 0 means none
+	DBWriteConns        int           `yaml:"db_write_conns" env:"DB_WRITE_CONNS"`             // ingests writing at once; 0 means a quarter of the pool
+
+	// Slow query logging, also switched at runtime with SIGUSR1 or
+	// PATCH /api/admin/slow-queries.
+	DBSlowQueryLog       bool          `yaml:"db_slow_query_log" env:"DB_SLOW_QUERY_LOG"`
+	DBSlowQueryThreshold time.Duration `yaml:"db_slow_query_threshold" env:"DB_SLOW_QUERY_THRESHOLD"` // 0 uses the store default
+
+	// Run the dependency checks of the check subcommand at startup, and
+	// exit if one fails.
+	StrictStartup bool `yaml:"strict_startup" env:"STRICT_STARTUP"`
+
+	// Directory to read SQL migrations from instead of the embedded set;
+	// meant for developing new migrations.
+	MigrationsPath string `yaml:"migrations_path" env:"MIGRATIONS_PATH"`
+
+	LogFormat string `yaml:"log_format" env:"LOG_FORMAT"` // text (colored on a terminal) or json
+	LogLevel  string `yaml:"log_level" env:"LOG_LEVEL"`   // debug, info, warn or error
+
+	MaxRequestBytes int64 `yaml:"max_request_bytes" env:"MAX_REQUEST_BYTES"` // largest JSON request body the API accepts
+
+	// Deadlines of API requests, after which their queries are cancelled
+	// and they get 504; 0 means none. Refreshes, uploads and exports have
+	// none.
+	RequestTimeout      time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT"`             // GET and HEAD requests
+	RequestWriteTimeout time.Duration `yaml:"request_write_timeout" env:"REQUEST_WRITE_TIMEOUT"` // other methods
+
+	// HTTPS listener, served alongside the plain one when a certificate
+	// pair or autocert hosts are set.
+	TLSListen           string   `yaml:"tls_listen" env:"TLS_LISTEN"`
+	TLSCertFile         string   `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile          string   `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	TLSAutocertHosts    []string `yaml:"tls_autocert_hosts" env:"TLS_AUTOCERT_HOSTS"` // get certificates from Let's Encrypt for these hosts
+	TLSAutocertCacheDir string   `yaml:"tls_autocert_cache_dir" env:"TLS_AUTOCERT_CACHE_DIR"`
+	TLSRedirectHTTP     bool     `yaml:"tls_redirect_http" env:"TLS_REDIRECT_HTTP"` // the plain listener redirects to HTTPS
+
+	// Origins browsers may call the API from; "*" allows any.
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+
+	VoyageRetries           int  `yaml:"voyage_retries" env:"VOYAGE_RETRIES"`                         // embedding request attempts; 0 uses the embedding default
+	VoyageRequestsPerMinute int  `yaml:"voyage_requests_per_minute" env:"VOYAGE_REQUESTS_PER_MINUTE"` // 0 means no client-side limit
+	VoyageTokensPerMinute   int  `yaml:"voyage_tokens_per_minute" env:"VOYAGE_TOKENS_PER_MINUTE"`     // 0 means no client-side limit
+	VoyageBatchSize         int  `yaml:"voyage_batch_size" env:"VOYAGE_BATCH_SIZE"`                   // texts per request; 0 uses the embedding default
+	VoyageMaxTextChars      int  `yaml:"voyage_max_text_chars" env:"VOYAGE_MAX_TEXT_CHARS"`           // longer texts are truncated; 0 uses the embedding default
+	VoyageResumeOnStart     bool `yaml:"voyage_resume_on_start" env:"VOYAGE_RESUME_ON_START"`         // queue missing-only embedding jobs at startup
+
+	CheckConcurrency int           `yaml:"check_concurrency" env:"CHECK_CONCURRENCY"` // parallel stream checks; 0 uses the default
+	CheckTimeout     time.Duration `yaml:"check_timeout" env:"CHECK_TIMEOUT"`         // per-channel check timeout; 0 uses the default
+	CheckUserAgent   string        `yaml:"check_user_agent" env:"CHECK_USER_AGENT"`   // overrides the source's user agent for checks
+
+	JobMaxAttempts  int           `yaml:"job_max_attempts" env:"JOB_MAX_ATTEMPTS"`   // queued job attempts before dead-lettering
+	JobRetryBackoff time.Duration `yaml:"job_retry_backoff" env:"JOB_RETRY_BACKOFF"` // delay before the first job retry, doubled after each
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`   // how long shutdown waits for background work
+
+	RefreshConcurrency int `yaml:"refresh_concurrency" env:"REFRESH_CONCURRENCY"` // sources refreshed at once by POST /api/sources/refresh
+
+	// Refresh notifications; sources may also set their own webhook_url.
+	WebhookURL    string `yaml:"webhook_url" env:"WEBHOOK_URL"`
+	WebhookSecret string `yaml:"webhook_secret" env:"WEBHOOK_SECRET"` // HMAC key for the signature header
+
+	// Bearer tokens for /api; the API is open when neither list is set.
+	APITokens     []string `yaml:"api_tokens" env:"API_TOKENS"`           // read-write tokens
+	APIReadTokens []string `yaml:"api_read_tokens" env:"API_READ_TOKENS"` // tokens allowed GET requests only
+
+	// How long watch history is kept; 0 keeps it forever.
+	WatchHistoryRetention time.Duration `yaml:"watch_history_retention" env:"WATCH_HISTORY_RETENTION"`
+	// How long channels archived by refreshes are kept (see the stale_policy
+	// of sources).
+	ArchiveRetention time.Duration `yaml:"archive_retention" env:"ARCHIVE_RETENTION"`
+
+	LogoCacheDir string        `yaml:"logo_cache_dir" env:"LOGO_CACHE_DIR"` // logo cache location when Redis is not configured
+	LogoCacheTTL time.Duration `yaml:"logo_cache_ttl" env:"LOGO_CACHE_TTL"` // how long logos are cached and may be cached by clients
+
+	// Redis Sentinel or Cluster, as an alternative to selecting them with the
+	// REDIS_URL scheme (redis+sentinel://, redis+cluster://). REDIS_URL still
+	// provides the credentials, database and TLS.
+	RedisSentinelMaster   string   `yaml:"redis_sentinel_master" env:"REDIS_SENTINEL_MASTER"`
+	RedisSentinelAddrs    []string `yaml:"redis_sentinel_addrs" env:"REDIS_SENTINEL_ADDRS"`
+	RedisSentinelPassword string   `yaml:"redis_sentinel_password" env:"REDIS_SENTINEL_PASSWORD"`
+	RedisCluster          bool     `yaml:"redis_cluster" env:"REDIS_CLUSTER"` // REDIS_URL is a cluster seed node
+
+	// Consecutive Redis failures after which Redis is skipped, reads going
+	// to Postgres and jobs running in-process, and how often it is then
+	// probed; 0 uses the cache package defaults.
+	RedisBreakerThreshold int           `yaml:"redis_breaker_threshold" env:"REDIS_BREAKER_THRESHOLD"`
+	RedisBreakerCooldown  time.Duration `yaml:"redis_breaker_cooldown" env:"REDIS_BREAKER_COOLDOWN"`
+
+	// Bounds of the in-process cache used instead of Redis.
+	MemoryCacheEntries int   `yaml:"memory_cache_entries" env:"MEMORY_CACHE_ENTRIES"`
+	MemoryCacheBytes   int64 `yaml:"memory_cache_bytes" env:"MEMORY_CACHE_BYTES"`
+
+	// How long each kind of entry is cached; 0 leaves it uncached.
+	CacheTTLSources   time.Duration `yaml:"cache_ttl_sources" env:"CACHE_TTL_SOURCES"` // the source list
+	CacheTTLSource    time.Duration `yaml:"cache_ttl_source" env:"CACHE_TTL_SOURCE"`   // one source
+	CacheTTLChannels  time.Duration `yaml:"cache_ttl_channels" env:"CACHE_TTL_CHANNELS"`
+	CacheTTLChannel   time.Duration `yaml:"cache_ttl_channel" env:"CACHE_TTL_CHANNEL"`
+	CacheTTLGroups    time.Duration `yaml:"cache_ttl_groups" env:"CACHE_TTL_GROUPS"`
+	CacheTTLSearch    time.Duration `yaml:"cache_ttl_search" env:"CACHE_TTL_SEARCH"`
+	CacheTTLSeries    time.Duration `yaml:"cache_ttl_series" env:"CACHE_TTL_SERIES"`
+	CacheTTLPlaylists time.Duration `yaml:"cache_ttl_playlists" env:"CACHE_TTL_PLAYLISTS"`
+	CacheTTLNotFound  time.Duration `yaml:"cache_ttl_not_found" env:"CACHE_TTL_NOT_FOUND"` // lookups of missing sources and channels
+	CachePrefix       string        `yaml:"cache_prefix" env:"CACHE_PREFIX"`               // prepended to every cache key
+
+	// Fill the cache for a source after each refresh: the source list, its
+	// groups and the first page of its channels and of each group.
+	CacheWarm bool `yaml:"cache_warm" env:"CACHE_WARM"`
+
+	// HDHomeRun emulation for Plex/Jellyfin; the lineup holds live channels
+	// matching the optional favorites, source and group filters.
+	HDHREnabled       bool   `yaml:"hdhr_enabled" env:"HDHR_ENABLED"`
+	HDHRDeviceID      string `yaml:"hdhr_device_id" env:"HDHR_DEVICE_ID"`
+	HDHRTunerCount    int    `yaml:"hdhr_tuner_count" env:"HDHR_TUNER_COUNT"`
+	HDHRFavoritesOnly bool   `yaml:"hdhr_favorites_only" env:"HDHR_FAVORITES_ONLY"`
+	HDHRSourceID      int64  `yaml:"hdhr_source_id" env:"HDHR_SOURCE_ID"` // 0 means all sources
+	HDHRGroupID       int64  `yaml:"hdhr_group_id" env:"HDHR_GROUP_ID"`   // 0 means all groups
 }
 
-// Load builds config from environment variables.
-// If DATABASE_URL is not set, Load tries to load .env.local and .env from the current directory.
-// DATABASE_URL is required. FETCHER_USER_AGENT and FETCHER_TIMEOUT are optional.
+// DefaultMaxRequestBytes caps JSON request bodies when MAX_REQUEST_BYTES is unset.
+const DefaultMaxRequestBytes = 1 << 20
+
+// Default cache TTLs and key prefix.
+const (
+	DefaultCacheTTLSources   = 2 * time.Minute
+	DefaultCacheTTLSource    = 5 * time.Minute
+	DefaultCacheTTLChannels  = 1 * time.Minute
+	DefaultCacheTTLChannel   = 5 * time.Minute
+	DefaultCacheTTLGroups    = 5 * time.Minute
+	DefaultCacheTTLSearch    = 2 * time.Minute
+	DefaultCacheTTLSeries    = 5 * time.Minute
+	DefaultCacheTTLPlaylists = 5 * time.Minute
+	DefaultCacheTTLNotFound  = 30 * time.Second
+	DefaultCachePrefix       = "pv:"
+)
+
+// Default bounds of the in-process cache.
+const (
+	DefaultMemoryCacheEntries = 10000
+	DefaultMemoryCacheBytes   = 64 << 20
+)
+
+// DefaultTLSListen is the HTTPS address when TLS_LISTEN is unset.
+const DefaultTLSListen = ":8443"
+
+// Default API request deadlines.
+const (
+	DefaultRequestTimeout      = 30 * time.Second
+	DefaultRequestWriteTimeout = 2 * time.Minute
+)
+
+// Defaults for the Redis job queue and background work.
+const (
+	DefaultJobMaxAttempts  = 3
+	DefaultJobRetryBackoff = time.Minute
+	DefaultShutdownTimeout = 30 * time.Second
+
+	DefaultRefreshConcurrency = 2
+	DefaultArchiveRetention   = 30 * 24 * time.Hour
+)
+
+// Defaults for the HDHomeRun emulation.
+const (
+	DefaultHDHRDeviceID   = "504F5056"
+	DefaultHDHRTunerCount = 2
+)
+
+// Load builds config from environment variables, see LoadFromFile.
+// If DATABASE_URL is not set, Load tries to load .env.local and .env from the
+// current directory first.
 func Load() (*Config, error) {
 	if os.Getenv("DATABASE_URL") == "" {
 		loadEnvFiles()
 	}
+	return load(nil)
+}
+
+// load builds config from the defaults, then the YAML document data when
+// non-nil, then the environment variables named by the env tags of Config,
+// so env takes precedence over the file. API_TOKEN adds to API_TOKENS and
+// list variables take comma-separated values. Values that do not parse are
+// reported together with the problems found by Validate.
+func load(data []byte) (*Config, error) {
 	c := &Config{
-		DatabaseURL:  os.Getenv("DATABASE_URL"),
-		RedisURL:     os.Getenv("REDIS_URL"),
-		ServerPort:   os.Getenv("SERVER_PORT"),
-		UserAgent:    os.Getenv("FETCHER_USER_AGENT"),
-		Timeout:      5 * time.Minute,
-		VoyageAPIKey: os.Getenv("VOYAGE_API_KEY"),
+		Timeout:         5 * time.Minute,
+		ShutdownTimeout: DefaultShutdownTimeout,
+
+		RequestTimeout:      DefaultRequestTimeout,
+		RequestWriteTimeout: DefaultRequestWriteTimeout,
+
+		CacheTTLSources:   DefaultCacheTTLSources,
+		CacheTTLSource:    DefaultCacheTTLSource,
+		CacheTTLChannels:  DefaultCacheTTLChannels,
+		CacheTTLChannel:   DefaultCacheTTLChannel,
+		CacheTTLGroups:    DefaultCacheTTLGroups,
+		CacheTTLSearch:    DefaultCacheTTLSearch,
+		CacheTTLSeries:    DefaultCacheTTLSeries,
+		CacheTTLPlaylists: DefaultCacheTTLPlaylists,
+		CacheTTLNotFound:  DefaultCacheTTLNotFound,
+		CachePrefix:       DefaultCachePrefix,
+	}
+	var problems []error
+	if data != nil {
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+	}
+	problems = append(problems, applyEnv(c)...)
+	if tok := splitList(os.Getenv("API_TOKEN")); len(tok) > 0 {
+		c.APITokens = append(tok, c.APITokens...)
+	}
+	c.applyDefaults()
+
+	if err := c.Validate(); err != nil {
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			problems = append(problems, ve.Problems...)
+		} else {
+			problems = append(problems, err)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
 	}
+	return c, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets every field of c whose env variable is set and returns the
+// values that could not be parsed.
+func applyEnv(c *Config) []error {
+	var problems []error
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Tag.Get("env")
+		s := os.Getenv(name)
+		if name == "" || s == "" {
+			continue
+		}
+		f := v.Field(i)
+		switch {
+		case f.Type() == durationType:
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: invalid duration %q", name, s))
+				continue
+			}
+			f.SetInt(int64(d))
+		case f.Kind() == reflect.String:
+			f.SetString(s)
+		case f.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: invalid boolean %q", name, s))
+				continue
+			}
+			f.SetBool(b)
+		case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("%s: invalid integer %q", name, s))
+				continue
+			}
+			f.SetInt(n)
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			f.Set(reflect.ValueOf(splitList(s)))
+		default:
+			panic("config: unsupported type for " + name)
+		}
+	}
+	return problems
+}
+
+// applyDefaults fills in the settings that are unset, or zero where zero
+// has no meaning of its own.
+func (c *Config) applyDefaults() {
 	if c.ServerPort == "" {
 		c.ServerPort = "8080"
 	}
 	if c.UserAgent == "" {
 		c.UserAgent = "PopcornVault/1.0"
 	}
-	if s := os.Getenv("FETCHER_TIMEOUT"); s != "" {
-		if d, err := time.ParseDuration(s); err == nil {
-			c.Timeout = d
-		}
+	if c.TLSListen == "" {
+		c.TLSListen = DefaultTLSListen
 	}
-	if c.DatabaseURL == "" {
-		return nil, ErrMissingDatabaseURL
+	if c.TLSAutocertCacheDir == "" {
+		c.TLSAutocertCacheDir = "autocert-cache"
 	}
-	return c, nil
+	if c.MaxRequestBytes == 0 {
+		c.MaxRequestBytes = DefaultMaxRequestBytes
+	}
+	if c.CORSAllowedOrigins == nil {
+		c.CORSAllowedOrigins = []string{"*"}
+	}
+	if c.JobMaxAttempts == 0 {
+		c.JobMaxAttempts = DefaultJobMaxAttempts
+	}
+	if c.JobRetryBackoff == 0 {
+		c.JobRetryBackoff = DefaultJobRetryBackoff
+	}
+	if c.RefreshConcurrency == 0 {
+		c.RefreshConcurrency = DefaultRefreshConcurrency
+	}
+	if c.ArchiveRetention == 0 {
+		c.ArchiveRetention = DefaultArchiveRetention
+	}
+	if c.LogoCacheDir == "" {
+		c.LogoCacheDir = filepath.Join(os.TempDir(), "popcornvault-logos")
+	}
+	if c.LogoCacheTTL == 0 {
+		c.LogoCacheTTL = 24 * time.Hour
+	}
+	if c.MemoryCacheEntries == 0 {
+		c.MemoryCacheEntries = DefaultMemoryCacheEntries
+	}
+	if c.MemoryCacheBytes == 0 {
+		c.MemoryCacheBytes = DefaultMemoryCacheBytes
+	}
+	if c.HDHRDeviceID == "" {
+		c.HDHRDeviceID = DefaultHDHRDeviceID
+	}
+	if c.HDHRTunerCount == 0 {
+		c.HDHRTunerCount = DefaultHDHRTunerCount
+	}
+}
+
+// splitList splits a comma-separated list, dropping blank entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
 }
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setEnv clears every variable Config reads, so the tests do not depend on
+// the environment they run in, then sets env.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := typ.Field(i).Tag.Get("env"); name != "" {
+			t.Setenv(name, "")
+		}
+	}
+	t.Setenv("API_TOKEN", "")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+}
+
+const testDatabaseURL = "postgres://u:p@localhost:5432/popcornvault"
+
+func TestLoadDefaults(t *testing.T) {
+	setEnv(t, map[string]string{"DATABASE_URL": testDatabaseURL})
+	c, err := load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name      string
+		got, want any
+	}{
+		{"DatabaseURL", c.DatabaseURL, testDatabaseURL},
+		{"ServerPort", c.ServerPort, "8080"},
+		{"UserAgent", c.UserAgent, "PopcornVault/1.0"},
+		{"Timeout", c.Timeout, 5 * time.Minute},
+		{"MaxRequestBytes", c.MaxRequestBytes, int64(DefaultMaxRequestBytes)},
+		{"RequestTimeout", c.RequestTimeout, DefaultRequestTimeout},
+		{"RequestWriteTimeout", c.RequestWriteTimeout, DefaultRequestWriteTimeout},
+		{"TLSListen", c.TLSListen, DefaultTLSListen},
+		{"CORSAllowedOrigins", c.CORSAllowedOrigins, []string{"*"}},
+		{"JobMaxAttempts", c.JobMaxAttempts, DefaultJobMaxAttempts},
+		{"JobRetryBackoff", c.JobRetryBackoff, DefaultJobRetryBackoff},
+		{"ShutdownTimeout", c.ShutdownTimeout, DefaultShutdownTimeout},
+		{"RefreshConcurrency", c.RefreshConcurrency, DefaultRefreshConcurrency},
+		{"ArchiveRetention", c.ArchiveRetention, DefaultArchiveRetention},
+		{"LogoCacheTTL", c.LogoCacheTTL, 24 * time.Hour},
+		{"MemoryCacheEntries", c.MemoryCacheEntries, DefaultMemoryCacheEntries},
+		{"MemoryCacheBytes", c.MemoryCacheBytes, int64(DefaultMemoryCacheBytes)},
+		{"CacheTTLChannels", c.CacheTTLChannels, DefaultCacheTTLChannels},
+		{"CacheTTLNotFound", c.CacheTTLNotFound, DefaultCacheTTLNotFound},
+		{"CachePrefix", c.CachePrefix, DefaultCachePrefix},
+		{"HDHRDeviceID", c.HDHRDeviceID, DefaultHDHRDeviceID},
+		{"HDHRTunerCount", c.HDHRTunerCount, DefaultHDHRTunerCount},
+		{"APITokens", c.APITokens, []string(nil)},
+	} {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(*Config) any // the value the env should have set
+		want  any
+	}{
+		{"string", map[string]string{"SERVER_PORT": "9090"}, func(c *Config) any { return c.ServerPort }, "9090"},
+		{"duration", map[string]string{"REQUEST_TIMEOUT": "1m30s"}, func(c *Config) any { return c.RequestTimeout }, 90 * time.Second},
+		{"zero duration", map[string]string{"REQUEST_TIMEOUT": "0s"}, func(c *Config) any { return c.RequestTimeout }, time.Duration(0)},
+		{"int", map[string]string{"DB_MAX_CONNS": "12"}, func(c *Config) any { return c.DBMaxConns }, 12},
+		{"int64", map[string]string{"FETCHER_MAX_BODY_BYTES": "1048576"}, func(c *Config) any { return c.MaxBodyBytes }, int64(1 << 20)},
+		{"bool", map[string]string{"CACHE_WARM": "true"}, func(c *Config) any { return c.CacheWarm }, true},
+		{"bool as 1", map[string]string{"HDHR_ENABLED": "1"}, func(c *Config) any { return c.HDHREnabled }, true},
+		{"list", map[string]string{"CORS_ALLOWED_ORIGINS": " https://a.example.com, ,https://b.example.com "},
+			func(c *Config) any { return c.CORSAllowedOrigins }, []string{"https://a.example.com", "https://b.example.com"}},
+		{"API_TOKEN before API_TOKENS", map[string]string{"API_TOKEN": "one", "API_TOKENS": "two,three"},
+			func(c *Config) any { return c.APITokens }, []string{"one", "two", "three"}},
+		{"API_TOKEN alone", map[string]string{"API_TOKEN": "one"}, func(c *Config) any { return c.APITokens }, []string{"one"}},
+		{"zero kept where it means none", map[string]string{"DB_STATEMENT_TIMEOUT": "0"},
+			func(c *Config) any { return c.DBStatementTimeout }, time.Duration(0)},
+		{"zero replaced by the default", map[string]string{"JOB_MAX_ATTEMPTS": "0"},
+			func(c *Config) any { return c.JobMaxAttempts }, DefaultJobMaxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"DATABASE_URL": testDatabaseURL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			setEnv(t, env)
+			c, err := load(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.check(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want []string // one per problem, in order
+	}{
+		{"no database", map[string]string{"DATABASE_URL": ""}, []string{"DATABASE_URL is required"}},
+		{"bad duration", map[string]string{"REQUEST_TIMEOUT": "soon"}, []string{`REQUEST_TIMEOUT: invalid duration "soon"`}},
+		{"bad bool", map[string]string{"CACHE_WARM": "yes"}, []string{`CACHE_WARM: invalid boolean "yes"`}},
+		{"bad int", map[string]string{"DB_MAX_CONNS": "ten"}, []string{`DB_MAX_CONNS: invalid integer "ten"`}},
+		{"negative", map[string]string{"VOYAGE_BATCH_SIZE": "-1"}, []string{"VOYAGE_BATCH_SIZE: must not be negative, got -1"}},
+		{"negative duration", map[string]string{"REQUEST_WRITE_TIMEOUT": "-1s"}, []string{"REQUEST_WRITE_TIMEOUT: must not be negative, got -1s"}},
+		{"not positive", map[string]string{"MAX_REQUEST_BYTES": "-5"}, []string{"MAX_REQUEST_BYTES: must be positive, got -5"}},
+		{"port", map[string]string{"SERVER_PORT": "70000"}, []string{`SERVER_PORT: invalid port "70000"`}},
+		{"listen", map[string]string{"SERVER_LISTEN": "unix://"}, []string{"SERVER_LISTEN: unix:// needs a socket path"}},
+		{"redis scheme", map[string]string{"REDIS_URL": "http://localhost:6379"},
+			[]string{"REDIS_URL: want a redis://, rediss://, redis+sentinel:// or redis+cluster:// URL"}},
+		{"sentinel without addrs", map[string]string{"REDIS_URL": "redis://localhost", "REDIS_SENTINEL_MASTER": "mymaster"},
+			[]string{"REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS must be set together"}},
+		{"tls pair", map[string]string{"TLS_CERT_FILE": "cert.pem"}, []string{"TLS_CERT_FILE and TLS_KEY_FILE must be set together"}},
+		{"redirect without tls", map[string]string{"TLS_REDIRECT_HTTP": "true"},
+			[]string{"TLS_REDIRECT_HTTP needs TLS_CERT_FILE or TLS_AUTOCERT_HOSTS"}},
+		{"log level", map[string]string{"LOG_LEVEL": "loud"}, []string{`LOG_LEVEL: "loud" is not debug, info, warn or error`}},
+		{"webhook", map[string]string{"WEBHOOK_URL": "ftp://example.com"}, []string{"WEBHOOK_URL: want an http or https URL"}},
+		{"pool sizes", map[string]string{"DB_MAX_CONNS": "4", "DB_MIN_CONNS": "5", "DB_WRITE_CONNS": "4"}, []string{
+			"DB_MIN_CONNS: 5 is more than DB_MAX_CONNS (4)",
+			"DB_WRITE_CONNS: 4 leaves no connection of the 4 in DB_MAX_CONNS for reads",
+		}},
+		{"every problem reported", map[string]string{"DATABASE_URL": "", "CACHE_WARM": "maybe", "LOG_FORMAT": "xml"}, []string{
+			`CACHE_WARM: invalid boolean "maybe"`,
+			"DATABASE_URL is required",
+			`LOG_FORMAT: "xml" is not text or json`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"DATABASE_URL": testDatabaseURL}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			setEnv(t, env)
+			c, err := load(nil)
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("config %+v, err = %v, want a *ValidationError", c, err)
+			}
+			var got []string
+			for _, p := range ve.Problems {
+				got = append(got, p.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems:\n  %s\nwant:\n  %s", strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+			}
+		})
+	}
+
+	setEnv(t, nil)
+	if _, err := load(nil); !errors.Is(err, ErrMissingDatabaseURL) {
+		t.Errorf("err = %v, want errors.Is ErrMissingDatabaseURL", err)
+	}
+}
+
+// TestLoadFromFile checks that the file's values apply over the defaults
+// and that the environment applies over the file.
+func TestLoadFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `database_url: ` + testDatabaseURL + `
+server_port: "9000"
+request_timeout: 10s
+api_tokens: [file-token]
+cache_warm: true
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	setEnv(t, map[string]string{"SERVER_PORT": "9100"})
+	c, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ServerPort != "9100" {
+		t.Errorf("ServerPort = %q, want the env's 9100", c.ServerPort)
+	}
+	if c.RequestTimeout != 10*time.Second || !c.CacheWarm || !reflect.DeepEqual(c.APITokens, []string{"file-token"}) {
+		t.Errorf("file values not applied: %+v", c)
+	}
+	if c.RequestWriteTimeout != DefaultRequestWriteTimeout {
+		t.Errorf("RequestWriteTimeout = %v, want the default", c.RequestWriteTimeout)
+	}
+
+	if _, err := LoadFromFile(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: err = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("server_port: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromFile(path); err == nil || !strings.HasPrefix(err.Error(), "config file: ") {
+		t.Errorf("malformed file: err = %v", err)
+	}
+}
+
+func TestApplyEnvFile(t *testing.T) {
+	setEnv(t, map[string]string{"SERVER_PORT": "9200"})
+	applyEnvFile([]byte(`# comment
+DATABASE_URL="` + testDatabaseURL + `"
+SERVER_PORT=9300
+LOG_LEVEL = 'debug'
+not a variable
+=empty
+`))
+	for k, want := range map[string]string{"DATABASE_URL": testDatabaseURL, "SERVER_PORT": "9200", "LOG_LEVEL": "debug"} {
+		if got := os.Getenv(k); got != want {
+			t.Errorf("%s = %q, want %q", k, got, want)
+		}
+	}
+}
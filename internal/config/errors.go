@@ -1,5 +1,25 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var ErrMissingDatabaseURL = errors.New("DATABASE_URL is required")
+
+// ValidationError lists every problem found in a configuration, so they
+// can all be fixed at once. errors.Is looks through the problems, e.g. for
+// ErrMissingDatabaseURL.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() []error { return e.Problems }
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks c and returns a *ValidationError listing every problem,
+// or nil. Settings are named by their environment variables.
+func (c *Config) Validate() error {
+	var problems []error
+	add := func(format string, a ...any) {
+		problems = append(problems, fmt.Errorf(format, a...))
+	}
+
+	if c.DatabaseURL == "" {
+		problems = append(problems, ErrMissingDatabaseURL)
+	}
+	if c.RedisURL != "" {
+		u, err := url.Parse(c.RedisURL)
+		if err == nil {
+			u.Scheme = strings.TrimSuffix(strings.TrimSuffix(u.Scheme, "+sentinel"), "+cluster")
+		}
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") {
+			add("REDIS_URL: want a redis://, rediss://, redis+sentinel:// or redis+cluster:// URL")
+		}
+	}
+	if (c.RedisSentinelMaster != "" || len(c.RedisSentinelAddrs) > 0 || c.RedisCluster) && c.RedisURL == "" {
+		add("REDIS_SENTINEL_MASTER, REDIS_SENTINEL_ADDRS and REDIS_CLUSTER need REDIS_URL")
+	}
+	if (c.RedisSentinelMaster == "") != (len(c.RedisSentinelAddrs) == 0) {
+		add("REDIS_SENTINEL_MASTER and REDIS_SENTINEL_ADDRS must be set together")
+	}
+	if c.RedisSentinelMaster != "" && c.RedisCluster {
+		add("REDIS_SENTINEL_MASTER and REDIS_CLUSTER are mutually exclusive")
+	}
+	for _, addr := range c.RedisSentinelAddrs {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			add("REDIS_SENTINEL_ADDRS: %q is not host:port", addr)
+		}
+	}
+	if n, err := strconv.Atoi(c.ServerPort); err != nil || n < 1 || n > 65535 {
+		add("SERVER_PORT: invalid port %q", c.ServerPort)
+	}
+	if path, ok := strings.CutPrefix(c.ServerListen, "unix://"); ok && path == "" {
+		add("SERVER_LISTEN: unix:// needs a socket path")
+	} else if !ok && c.ServerListen != "" {
+		if _, _, err := net.SplitHostPort(c.ServerListen); err != nil {
+			add("SERVER_LISTEN: %q is not host:port or unix:///path", c.ServerListen)
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		add("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	tls := c.TLSCertFile != "" || len(c.TLSAutocertHosts) > 0
+	if c.TLSCertFile != "" && len(c.TLSAutocertHosts) > 0 {
+		add("TLS_CERT_FILE and TLS_AUTOCERT_HOSTS are mutually exclusive")
+	}
+	if tls {
+		if _, _, err := net.SplitHostPort(c.TLSListen); err != nil {
+			add("TLS_LISTEN: %q is not host:port", c.TLSListen)
+		}
+	}
+	if c.TLSRedirectHTTP && !tls {
+		add("TLS_REDIRECT_HTTP needs TLS_CERT_FILE or TLS_AUTOCERT_HOSTS")
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "", "text", "json":
+	default:
+		add("LOG_FORMAT: %q is not text or json", c.LogFormat)
+	}
+	if c.LogLevel != "" {
+		var lvl slog.Level
+		if err := lvl.UnmarshalText([]byte(c.LogLevel)); err != nil {
+			add("LOG_LEVEL: %q is not debug, info, warn or error", c.LogLevel)
+		}
+	}
+	if c.WebhookURL != "" {
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("WEBHOOK_URL: want an http or https URL")
+		}
+	}
+	if c.DBMaxConns > 0 && c.DBMinConns > c.DBMaxConns {
+		add("DB_MIN_CONNS: %d is more than DB_MAX_CONNS (%d)", c.DBMinConns, c.DBMaxConns)
+	}
+	if c.DBMaxConns > 0 && c.DBWriteConns >= c.DBMaxConns {
+		add("DB_WRITE_CONNS: %d leaves no connection of the %d in DB_MAX_CONNS for reads", c.DBWriteConns, c.DBMaxConns)
+	}
+
+	for _, v := range []struct {
+		name string
+		n    int64
+	}{
+		{"FETCHER_MAX_BODY_BYTES", c.MaxBodyBytes},
+		{"FETCHER_RETRIES", int64(c.Retries)},
+		{"VOYAGE_RETRIES", int64(c.VoyageRetries)},
+		{"VOYAGE_REQUESTS_PER_MINUTE", int64(c.VoyageRequestsPerMinute)},
+		{"VOYAGE_TOKENS_PER_MINUTE", int64(c.VoyageTokensPerMinute)},
+		{"VOYAGE_BATCH_SIZE", int64(c.VoyageBatchSize)},
+		{"VOYAGE_MAX_TEXT_CHARS", int64(c.VoyageMaxTextChars)},
+		{"CHECK_CONCURRENCY", int64(c.CheckConcurrency)},
+		{"HDHR_SOURCE_ID", c.HDHRSourceID},
+		{"DB_MAX_CONNS", int64(c.DBMaxConns)},
+		{"DB_MIN_CONNS", int64(c.DBMinConns)},
+		{"DB_WRITE_CONNS", int64(c.DBWriteConns)},
+		{"HDHR_GROUP_ID", c.HDHRGroupID},
+		{"REDIS_BREAKER_THRESHOLD", int64(c.RedisBreakerThreshold)},
+	} {
+		if v.n < 0 {
+			add("%s: must not be negative, got %d", v.name, v.n)
+		}
+	}
+	for _, v := range []struct {
+		name string
+		n    int64
+	}{
+		{"MAX_REQUEST_BYTES", c.MaxRequestBytes},
+		{"JOB_MAX_ATTEMPTS", int64(c.JobMaxAttempts)},
+		{"REFRESH_CONCURRENCY", int64(c.RefreshConcurrency)},
+		{"HDHR_TUNER_COUNT", int64(c.HDHRTunerCount)},
+		{"MEMORY_CACHE_ENTRIES", int64(c.MemoryCacheEntries)},
+		{"MEMORY_CACHE_BYTES", c.MemoryCacheBytes},
+	} {
+		if v.n < 1 {
+			add("%s: must be positive, got %d", v.name, v.n)
+		}
+	}
+	for _, v := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"FETCHER_TIMEOUT", c.Timeout},
+		{"FETCHER_RETRY_BACKOFF", c.RetryBackoff},
+		{"CHECK_TIMEOUT", c.CheckTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"REQUEST_TIMEOUT", c.RequestTimeout},
+		{"REQUEST_WRITE_TIMEOUT", c.RequestWriteTimeout},
+		{"WATCH_HISTORY_RETENTION", c.WatchHistoryRetention},
+		{"ARCHIVE_RETENTION", c.ArchiveRetention},
+		{"DB_MAX_CONN_LIFETIME", c.DBMaxConnLifetime},
+		{"DB_HEALTH_CHECK_PERIOD", c.DBHealthCheckPeriod},
+		{"DB_STATEMENT_TIMEOUT", c.DBStatementTimeout},
+		{"DB_SLOW_QUERY_THRESHOLD", c.DBSlowQueryThreshold},
+		{"CACHE_TTL_SOURCES", c.CacheTTLSources},
+		{"CACHE_TTL_SOURCE", c.CacheTTLSource},
+		{"CACHE_TTL_CHANNELS", c.CacheTTLChannels},
+		{"CACHE_TTL_CHANNEL", c.CacheTTLChannel},
+		{"CACHE_TTL_GROUPS", c.CacheTTLGroups},
+		{"CACHE_TTL_SEARCH", c.CacheTTLSearch},
+		{"CACHE_TTL_SERIES", c.CacheTTLSeries},
+		{"CACHE_TTL_PLAYLISTS", c.CacheTTLPlaylists},
+		{"CACHE_TTL_NOT_FOUND", c.CacheTTLNotFound},
+		{"REDIS_BREAKER_COOLDOWN", c.RedisBreakerCooldown},
+	} {
+		if v.d < 0 {
+			add("%s: must not be negative, got %s", v.name, v.d)
+		}
+	}
+	for _, v := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"JOB_RETRY_BACKOFF", c.JobRetryBackoff},
+		{"LOGO_CACHE_TTL", c.LogoCacheTTL},
+	} {
+		if v.d <= 0 {
+			add("%s: must be positive, got %s", v.name, v.d)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
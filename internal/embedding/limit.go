@@ -0,0 +1,118 @@
+package embedding
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter paces requests to stay within VoyageAI's per-minute request and
+// token limits. It keeps the requests sent during the last minute and
+// delays a new one until it fits in both budgets. A nil limiter never waits.
+type limiter struct {
+	rpm, tpm int // 0 means no limit
+
+	mu     sync.Mutex
+	window []*limitSlot // requests sent in the last minute, oldest first
+}
+
+// limitSlot is one request counted against the limits.
+type limitSlot struct {
+	at     time.Time
+	tokens int
+}
+
+// newLimiter returns a limiter for the given limits, or nil if both are 0.
+func newLimiter(rpm, tpm int) *limiter {
+	if rpm <= 0 && tpm <= 0 {
+		return nil
+	}
+	return &limiter{rpm: max(rpm, 0), tpm: max(tpm, 0)}
+}
+
+// wait blocks until a request of the given (estimated) token count may be
+// sent, then counts it and returns its slot. It returns early with ctx's
+// error when ctx is done.
+func (l *limiter) wait(ctx context.Context, tokens int) (*limitSlot, error) {
+	if l == nil {
+		return nil, nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.prune(now)
+		delay := l.delay(now, tokens)
+		if delay <= 0 {
+			s := &limitSlot{at: now, tokens: tokens}
+			l.window = append(l.window, s)
+			l.mu.Unlock()
+			return s, nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// settle replaces the estimated token count of s with the count the API
+// reported.
+func (l *limiter) settle(s *limitSlot, tokens int) {
+	if l == nil || s == nil || tokens <= 0 {
+		return
+	}
+	l.mu.Lock()
+	s.tokens = tokens
+	l.mu.Unlock()
+}
+
+// prune drops the requests sent more than a minute before now.
+func (l *limiter) prune(now time.Time) {
+	n := 0
+	for n < len(l.window) && !l.window[n].at.Add(time.Minute).After(now) {
+		n++
+	}
+	l.window = l.window[n:]
+}
+
+// delay returns how long a request of tokens must wait until enough of the
+// window has expired, or 0 if it may go now. A request larger than the
+// whole token budget goes out once the window is empty.
+func (l *limiter) delay(now time.Time, tokens int) time.Duration {
+	if l.rpm > 0 && len(l.window) >= l.rpm {
+		return l.window[len(l.window)-l.rpm].at.Add(time.Minute).Sub(now)
+	}
+	if l.tpm > 0 && len(l.window) > 0 {
+		excess := tokens - l.tpm
+		for _, s := range l.window {
+			excess += s.tokens
+		}
+		if excess > 0 {
+			// Wait for the oldest requests holding excess tokens to expire.
+			for _, s := range l.window {
+				excess -= s.tokens
+				if excess <= 0 {
+					return s.at.Add(time.Minute).Sub(now)
+				}
+			}
+			return l.window[len(l.window)-1].at.Add(time.Minute).Sub(now)
+		}
+	}
+	return 0
+}
+
+// estimateTokens approximates the tokens VoyageAI counts for texts, at
+// about four characters per token, before the response reports the real
+// count.
+func estimateTokens(texts []string) int {
+	n := 0
+	for _, t := range texts {
+		n += len(t)/4 + 1
+	}
+	return n
+}
@@ -0,0 +1,59 @@
+package embedding
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry defaults used when Options leaves them zero.
+const (
+	DefaultMaxAttempts = 5
+	defaultBaseDelay   = time.Second
+	maxRetryDelay      = time.Minute
+)
+
+// apiError is a non-200 response from the VoyageAI API.
+type apiError struct {
+	status     int
+	detail     string
+	retryAfter time.Duration // from the Retry-After header; 0 if absent
+}
+
+func (e *apiError) Error() string {
+	return "voyage API " + strconv.Itoa(e.status) + ": " + e.detail
+}
+
+// retryable reports whether the response status is worth retrying:
+// rate limiting and server errors.
+func (e *apiError) retryable() bool {
+	return e.status == http.StatusTooManyRequests || e.status >= 500
+}
+
+// backoff returns the delay after the given (1-based) failed attempt:
+// exponential in attempt with jitter in [d/2, d), capped at maxRetryDelay.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.baseDelay << (attempt - 1)
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -4,43 +4,158 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"sync/atomic"
 	"time"
 )
 
+// Dimensions is the length of the vectors stored for channels. The
+// channels.embedding column is declared with it; models that cannot return
+// vectors of this length are rejected by NewClient.
+const Dimensions = 512
+
 const (
 	voyageAPIURL       = "https://api.voyageai.com/v1/embeddings"
 	defaultModel       = "voyage-3-lite"
 	defaultBatchSize   = 128
+	maxBatchSize       = 1000 // most texts the API accepts per request
 	defaultHTTPTimeout = 30 * time.Second
 )
 
-// Client is a lightweight VoyageAI embeddings HTTP client.
+// fixedDimensions lists the models whose output length cannot be chosen.
+// Other models are asked for Dimensions-long vectors (output_dimension).
+var fixedDimensions = map[string]int{
+	"voyage-3-lite":         512,
+	"voyage-3":              1024,
+	"voyage-2":              1024,
+	"voyage-large-2":        1536,
+	"voyage-code-2":         1536,
+	"voyage-multilingual-2": 1024,
+	"voyage-finance-2":      1024,
+	"voyage-law-2":          1024,
+}
+
+// Client is a lightweight VoyageAI embeddings HTTP client. It is safe for
+// concurrent use; the rate limits are shared by all callers.
 type Client struct {
 	apiKey     string
 	model      string
+	url        string // embeddings endpoint
+	outputDim  int    // sent as output_dimension; 0 for fixed-size models
 	httpClient *http.Client
+
+	batchSize    int
+	maxTextChars int
+
+	maxAttempts int
+	baseDelay   time.Duration
+	limiter     *limiter // nil when no rate limit is configured
+	retries     atomic.Int64
+}
+
+// Options controls how a Client retries and paces its requests. Zero
+// fields take their defaults.
+type Options struct {
+	MaxAttempts       int           // attempts per request including the first; 0 uses DefaultMaxAttempts
+	BaseDelay         time.Duration // delay before the first retry, doubled after each one; 0 uses 1s
+	RequestsPerMinute int           // client-side request limit; 0 means none
+	TokensPerMinute   int           // client-side token limit; 0 means none
+	BatchSize         int           // texts per request; 0 uses 128, capped at the API's 1000
+	MaxTextChars      int           // longer texts are truncated; 0 uses DefaultMaxTextChars
+	URL               string        // embeddings endpoint, e.g. a proxy; empty uses VoyageAI's
 }
 
-// NewClient creates a VoyageAI embedding client.
-// The model is always voyage-3-lite (512 dimensions).
-func NewClient(apiKey string) *Client {
-	return &Client{
+// DefaultMaxTextChars is the length texts are truncated to when Options
+// leaves MaxTextChars zero. Channel texts are short; the limit only catches
+// playlists with absurdly long names.
+const DefaultMaxTextChars = 1000
+
+// NewClient creates a VoyageAI embedding client for model (voyage-3-lite if
+// empty). It fails if the model only returns vectors of another length than
+// Dimensions.
+func NewClient(apiKey, model string, opts Options) (*Client, error) {
+	if model == "" {
+		model = defaultModel
+	}
+	c := &Client{
 		apiKey: apiKey,
-		model:  defaultModel,
+		model:  model,
+		url:    opts.URL,
 		httpClient: &http.Client{
 			Timeout: defaultHTTPTimeout,
 		},
+		batchSize:    min(opts.BatchSize, maxBatchSize),
+		maxTextChars: opts.MaxTextChars,
+		maxAttempts:  opts.MaxAttempts,
+		baseDelay:    opts.BaseDelay,
+		limiter:      newLimiter(opts.RequestsPerMinute, opts.TokensPerMinute),
+	}
+	if c.url == "" {
+		c.url = voyageAPIURL
+	}
+	if c.batchSize <= 0 {
+		c.batchSize = defaultBatchSize
+	}
+	if c.maxTextChars <= 0 {
+		c.maxTextChars = DefaultMaxTextChars
+	}
+	if c.maxAttempts <= 0 {
+		c.maxAttempts = DefaultMaxAttempts
+	}
+	if c.baseDelay <= 0 {
+		c.baseDelay = defaultBaseDelay
+	}
+	if dims, ok := fixedDimensions[model]; !ok {
+		c.outputDim = Dimensions
+	} else if dims != Dimensions {
+		return nil, fmt.Errorf("model %s returns %d-dimensional vectors, but embeddings are stored with %d", model, dims, Dimensions)
+	}
+	return c, nil
+}
+
+// BatchSize returns the number of texts to send per Embed call.
+func (c *Client) BatchSize() int {
+	return c.batchSize
+}
+
+// Truncate shortens text to the client's character limit, cutting at a
+// rune boundary, and reports whether it did. Embed truncates its inputs
+// itself; callers use Truncate to know which texts were cut.
+func (c *Client) Truncate(text string) (string, bool) {
+	if len(text) <= c.maxTextChars {
+		return text, false
 	}
+	n := 0
+	for i := range text {
+		if n == c.maxTextChars {
+			return text[:i], true
+		}
+		n++
+	}
+	return text, false
+}
+
+// Retries returns the number of requests retried since the client was
+// created, after rate limiting, server or network errors.
+func (c *Client) Retries() int64 {
+	return c.retries.Load()
+}
+
+// Model returns the name of the model embeddings are generated with.
+func (c *Client) Model() string {
+	return c.model
 }
 
 // embeddingRequest is the JSON body sent to the VoyageAI API.
 type embeddingRequest struct {
-	Input     []string `json:"input"`
-	Model     string   `json:"model"`
-	InputType string   `json:"input_type"`
+	Input           []string `json:"input"`
+	Model           string   `json:"model"`
+	InputType       string   `json:"input_type"`
+	OutputDimension int      `json:"output_dimension,omitempty"`
 }
 
 // embeddingResponse is the JSON body returned by the VoyageAI API.
@@ -61,25 +176,111 @@ type voyageErrorResponse struct {
 	Detail string `json:"detail"`
 }
 
+// Result holds the vectors of an Embed call, in input order, and the tokens
+// the API billed for it.
+type Result struct {
+	Embeddings [][]float32
+	Tokens     int
+}
+
 // Embed calls the VoyageAI API to embed one or more texts in a single request.
 // inputType should be "document" for stored content or "query" for search queries.
-func (c *Client) Embed(ctx context.Context, texts []string, inputType string) ([][]float32, error) {
+// Network errors, 429 and 5xx responses are retried with exponential backoff,
+// or after the delay the API asks for in Retry-After. Waiting for a retry or
+// for the rate limiter stops as soon as ctx is done.
+func (c *Client) Embed(ctx context.Context, texts []string, inputType string) (Result, error) {
 	if len(texts) == 0 {
-		return nil, nil
+		return Result{}, nil
+	}
+
+	// One over-long text would fail the whole request with a 400.
+	var input []string
+	for i, t := range texts {
+		if short, cut := c.Truncate(t); cut {
+			if input == nil {
+				input = slices.Clone(texts)
+			}
+			input[i] = short
+		}
+	}
+	if input == nil {
+		input = texts
 	}
 
 	reqBody := embeddingRequest{
-		Input:     texts,
-		Model:     c.model,
-		InputType: inputType,
+		Input:           input,
+		Model:           c.model,
+		InputType:       inputType,
+		OutputDimension: c.outputDim,
 	}
 
 	bodyBytes, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("marshal request: %w", err)
+		return Result{}, fmt.Errorf("marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, voyageAPIURL, bytes.NewReader(bodyBytes))
+	tokens := estimateTokens(texts)
+	var embResp *embeddingResponse
+	for attempt := 1; ; attempt++ {
+		slot, err := c.limiter.wait(ctx, tokens)
+		if err != nil {
+			return Result{}, fmt.Errorf("rate limit: %w", err)
+		}
+
+		embResp, err = c.post(ctx, bodyBytes)
+		if err == nil {
+			c.limiter.settle(slot, embResp.Usage.TotalTokens)
+			break
+		}
+
+		var delay time.Duration
+		var apiErr *apiError
+		switch {
+		case ctx.Err() != nil:
+			// A cancelled or expired ctx is final, not a transient error.
+			return Result{}, err
+		case errors.As(err, &apiErr) && !apiErr.retryable():
+			return Result{}, err
+		case errors.As(err, &apiErr) && apiErr.retryAfter > 0:
+			delay = min(apiErr.retryAfter, maxRetryDelay)
+		default:
+			delay = c.backoff(attempt)
+		}
+		if attempt >= c.maxAttempts {
+			if attempt == 1 {
+				return Result{}, err
+			}
+			return Result{}, fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+
+		c.retries.Add(1)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Result{}, fmt.Errorf("after %d attempts: %w", attempt, errors.Join(err, ctx.Err()))
+		case <-timer.C:
+		}
+	}
+
+	// Return embeddings in input order (API returns them indexed).
+	embeddings := make([][]float32, len(texts))
+	for _, d := range embResp.Data {
+		if len(d.Embedding) != Dimensions {
+			return Result{}, fmt.Errorf("model %s returned a %d-dimensional vector, want %d", c.model, len(d.Embedding), Dimensions)
+		}
+		if d.Index < len(embeddings) {
+			embeddings[d.Index] = d.Embedding
+		}
+	}
+
+	return Result{Embeddings: embeddings, Tokens: embResp.Usage.TotalTokens}, nil
+}
+
+// post sends one embeddings request. A non-200 response is returned as an
+// *apiError.
+func (c *Client) post(ctx context.Context, body []byte) (*embeddingResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("new request: %w", err)
 	}
@@ -100,35 +301,32 @@ func (c *Client) Embed(ctx context.Context, texts []string, inputType string) ([
 	if resp.StatusCode != http.StatusOK {
 		var voyageErr voyageErrorResponse
 		_ = json.Unmarshal(respBody, &voyageErr)
-		return nil, fmt.Errorf("voyage API %d: %s", resp.StatusCode, voyageErr.Detail)
+		return nil, &apiError{
+			status:     resp.StatusCode,
+			detail:     voyageErr.Detail,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
 	}
 
 	var embResp embeddingResponse
 	if err := json.Unmarshal(respBody, &embResp); err != nil {
 		return nil, fmt.Errorf("unmarshal response: %w", err)
 	}
-
-	// Return embeddings in input order (API returns them indexed).
-	embeddings := make([][]float32, len(texts))
-	for _, d := range embResp.Data {
-		if d.Index < len(embeddings) {
-			embeddings[d.Index] = d.Embedding
-		}
-	}
-
-	return embeddings, nil
+	return &embResp, nil
 }
 
 // ProgressFunc is called after each batch completes during EmbedBatch.
 // batchIndex is 1-based, totalBatches is the total number of batches.
 type ProgressFunc func(batchIndex, totalBatches int)
 
-// EmbedBatch splits texts into batches of batchSize and calls Embed for each batch.
-// Results are returned in the same order as the input texts.
+// EmbedBatch splits texts into batches of batchSize (the client's batch size
+// if <= 0) and calls Embed for each batch.
+// Embeddings are returned in the same order as the input texts, with the
+// tokens of all batches summed.
 // onProgress is optional; if non-nil it is called after each batch completes.
-func (c *Client) EmbedBatch(ctx context.Context, texts []string, inputType string, batchSize int, onProgress ...ProgressFunc) ([][]float32, error) {
+func (c *Client) EmbedBatch(ctx context.Context, texts []string, inputType string, batchSize int, onProgress ...ProgressFunc) (Result, error) {
 	if batchSize <= 0 {
-		batchSize = defaultBatchSize
+		batchSize = c.batchSize
 	}
 
 	totalBatches := (len(texts) + batchSize - 1) / batchSize
@@ -137,7 +335,7 @@ func (c *Client) EmbedBatch(ctx context.Context, texts []string, inputType strin
 		progressFn = onProgress[0]
 	}
 
-	all := make([][]float32, 0, len(texts))
+	all := Result{Embeddings: make([][]float32, 0, len(texts))}
 	batchIdx := 0
 
 	for i := 0; i < len(texts); i += batchSize {
@@ -148,10 +346,11 @@ func (c *Client) EmbedBatch(ctx context.Context, texts []string, inputType strin
 
 		batch, err := c.Embed(ctx, texts[i:end], inputType)
 		if err != nil {
-			return nil, fmt.Errorf("embed batch [%d:%d]: %w", i, end, err)
+			return Result{}, fmt.Errorf("embed batch [%d:%d]: %w", i, end, err)
 		}
 
-		all = append(all, batch...)
+		all.Embeddings = append(all.Embeddings, batch.Embeddings...)
+		all.Tokens += batch.Tokens
 		batchIdx++
 
 		if progressFn != nil {
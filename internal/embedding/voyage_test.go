@@ -0,0 +1,210 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewClientModels(t *testing.T) {
+	tests := []struct {
+		model     string
+		wantModel string
+		outputDim int
+		err       string
+	}{
+		{"", "voyage-3-lite", 0, ""},
+		{"voyage-3-lite", "voyage-3-lite", 0, ""},
+		{"voyage-3.5", "voyage-3.5", Dimensions, ""},
+		{"voyage-3", "", 0, "returns 1024-dimensional vectors"},
+		{"voyage-large-2", "", 0, "returns 1536-dimensional vectors"},
+	}
+	for _, tt := range tests {
+		c, err := NewClient("key", tt.model, Options{})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("NewClient(%q) err = %v, want %q", tt.model, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewClient(%q): %v", tt.model, err)
+			continue
+		}
+		if c.Model() != tt.wantModel || c.outputDim != tt.outputDim {
+			t.Errorf("NewClient(%q) = model %q, output dimension %d, want %q, %d", tt.model, c.Model(), c.outputDim, tt.wantModel, tt.outputDim)
+		}
+	}
+}
+
+// fakeAPI serves handle as the embeddings endpoint and returns a client
+// for it with short retry delays.
+func fakeAPI(t *testing.T, model string, opts Options, handle http.HandlerFunc) *Client {
+	t.Helper()
+	ts := httptest.NewServer(handle)
+	t.Cleanup(ts.Close)
+	opts.URL = ts.URL
+	opts.BaseDelay = time.Millisecond
+	c, err := NewClient("secret", model, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// vectors answers req with one Dimensions-long vector per input, whose
+// first element is the input's length, listed in reverse index order.
+func vectors(w http.ResponseWriter, req embeddingRequest) {
+	var resp embeddingResponse
+	for i := len(req.Input) - 1; i >= 0; i-- {
+		vec := make([]float32, Dimensions)
+		vec[0] = float32(len(req.Input[i]))
+		resp.Data = append(resp.Data, embeddingData{Embedding: vec, Index: i})
+	}
+	resp.Usage.TotalTokens = 3 * len(req.Input)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestEmbedRequest(t *testing.T) {
+	tests := []struct {
+		model     string
+		outputDim int // expected output_dimension; 0 when omitted
+	}{
+		{"voyage-3-lite", 0},
+		{"voyage-3.5-lite", Dimensions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var got map[string]any
+			var auth, contentType string
+			c := fakeAPI(t, tt.model, Options{MaxTextChars: 4}, func(w http.ResponseWriter, r *http.Request) {
+				auth, contentType = r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+				var raw json.RawMessage
+				json.NewDecoder(r.Body).Decode(&raw)
+				json.Unmarshal(raw, &got)
+				var req embeddingRequest
+				json.Unmarshal(raw, &req)
+				vectors(w, req)
+			})
+			res, err := c.Embed(context.Background(), []string{"BBC One", "CNN", "ÄÖÜßé"}, "query")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if auth != "Bearer secret" || contentType != "application/json" {
+				t.Errorf("headers = %q, %q", auth, contentType)
+			}
+			if got["model"] != tt.model || got["input_type"] != "query" {
+				t.Errorf("request = %v", got)
+			}
+			if dim, ok := got["output_dimension"]; (tt.outputDim == 0 && ok) || (tt.outputDim != 0 && dim != float64(tt.outputDim)) {
+				t.Errorf("output_dimension = %v, want %d", dim, tt.outputDim)
+			}
+			// Over-long texts are cut at MaxTextChars runes.
+			if input := got["input"].([]any); len(input) != 3 || input[0] != "BBC " || input[1] != "CNN" || input[2] != "ÄÖÜß" {
+				t.Errorf("input = %q", input)
+			}
+			// Vectors come back in input order whatever order the API lists them in.
+			for i, want := range []float32{4, 3, 8} {
+				if res.Embeddings[i][0] != want {
+					t.Errorf("embedding %d = %v..., want %v...", i, res.Embeddings[i][0], want)
+				}
+			}
+			if res.Tokens != 9 {
+				t.Errorf("tokens = %d, want 9", res.Tokens)
+			}
+		})
+	}
+}
+
+func TestEmbedAPIErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // failed responses before a success
+		status   int
+		body     string
+		attempts int32
+		err      string // substring of the error; empty for success
+	}{
+		{"bad request", 5, http.StatusBadRequest, `{"detail":"input too long"}`, 1, "voyage API 400: input too long"},
+		{"unauthorized", 5, http.StatusUnauthorized, `{"detail":"bad key"}`, 1, "voyage API 401: bad key"},
+		{"rate limited then ok", 1, http.StatusTooManyRequests, `{"detail":"slow down"}`, 2, ""},
+		{"server errors then ok", 2, http.StatusInternalServerError, `oops`, 3, ""},
+		{"out of attempts", 5, http.StatusBadGateway, ``, 3, "after 3 attempts: voyage API 502"},
+		{"wrong dimension", 0, 0, ``, 1, "returned a 3-dimensional vector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			c := fakeAPI(t, "", Options{MaxAttempts: 3}, func(w http.ResponseWriter, r *http.Request) {
+				if int(attempts.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.status)
+					w.Write([]byte(tt.body))
+					return
+				}
+				var req embeddingRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				if tt.name == "wrong dimension" {
+					w.Write([]byte(`{"data":[{"embedding":[1,2,3],"index":0}]}`))
+					return
+				}
+				vectors(w, req)
+			})
+			res, err := c.Embed(context.Background(), []string{"a"}, "document")
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+			if got := c.Retries(); got != int64(tt.attempts-1) {
+				t.Errorf("Retries = %d, want %d", got, tt.attempts-1)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Embeddings) != 1 || len(res.Embeddings[0]) != Dimensions {
+				t.Fatalf("embeddings = %d", len(res.Embeddings))
+			}
+		})
+	}
+}
+
+func TestEmbedBatch(t *testing.T) {
+	var requests atomic.Int32
+	c := fakeAPI(t, "", Options{BatchSize: 2}, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req embeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		vectors(w, req)
+	})
+	var progress []int
+	res, err := c.EmbedBatch(context.Background(), []string{"a", "bb", "ccc", "dddd", "eeeee"}, "document", 0, func(i, total int) {
+		progress = append(progress, i, total)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+	if len(res.Embeddings) != 5 || res.Tokens != 15 {
+		t.Fatalf("result = %d embeddings for %d tokens, want 5 for 15", len(res.Embeddings), res.Tokens)
+	}
+	for i, vec := range res.Embeddings {
+		if vec[0] != float32(i+1) {
+			t.Errorf("embedding %d is for a %v-character text", i, vec[0])
+		}
+	}
+	if want := []int{1, 3, 2, 3, 3, 3}; !slices.Equal(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+}
@@ -0,0 +1,101 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// StreamChecker probes stream URLs to see whether they still play. It is
+// safe for concurrent use.
+type StreamChecker struct {
+	client   *http.Client
+	insecure *http.Client // for channels marked ignore_ssl
+	timeout  time.Duration
+}
+
+// NewStreamChecker returns a checker that gives up on a stream after timeout
+// (covering both the HEAD and the fallback GET request).
+func NewStreamChecker(timeout time.Duration) *StreamChecker {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	insecure := transport.Clone()
+	insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return &StreamChecker{
+		client:   &http.Client{Transport: transport},
+		insecure: &http.Client{Transport: insecure},
+		timeout:  timeout,
+	}
+}
+
+// Check requests url with HEAD and, since many IPTV servers reject or
+// mishandle HEAD, falls back to a GET for the first byte when HEAD does not
+// succeed. Only response headers are read. It returns the resulting
+// models.ChannelStatus* value and the last HTTP status code (0 if no
+// response arrived).
+func (c *StreamChecker) Check(ctx context.Context, url string, headers map[string]string, ignoreSSL bool) (status string, code int) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	client := c.client
+	if ignoreSSL {
+		client = c.insecure
+	}
+
+	code, err := probe(ctx, client, http.MethodHead, url, headers)
+	if err == nil && code < 400 {
+		return models.ChannelStatusOK, code
+	}
+	if err != nil && isTimeout(ctx, err) {
+		return models.ChannelStatusTimeout, 0
+	}
+
+	code, err = probe(ctx, client, http.MethodGet, url, headers)
+	switch {
+	case err != nil && isTimeout(ctx, err):
+		return models.ChannelStatusTimeout, 0
+	case err != nil:
+		return models.ChannelStatusDead, 0
+	case code < 400:
+		return models.ChannelStatusOK, code
+	default:
+		return models.ChannelStatusDead, code
+	}
+}
+
+// probe sends one request and returns the response status without reading
+// the body. GET requests ask for the first byte only.
+func probe(ctx context.Context, client *http.Client, method, url string, headers map[string]string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// isTimeout reports whether err is due to the check's deadline or a network
+// timeout rather than a refused or broken connection.
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
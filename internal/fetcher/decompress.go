@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decompress wraps r according to contentEncoding ("gzip", "deflate" or
+// empty). Regardless of the header, gzip data is also recognised by its magic
+// bytes, since many providers serve .m3u.gz / .xml.gz files as plain
+// application/octet-stream. Unknown encodings are an error rather than being
+// handed to the parser as garbage.
+func decompress(r io.Reader, contentEncoding string) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	switch enc := strings.ToLower(strings.TrimSpace(contentEncoding)); enc {
+	case "", "identity":
+		if isGzip(br) {
+			return newGzipReader(br)
+		}
+		return br, nil
+	case "gzip", "x-gzip":
+		return newGzipReader(br)
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw
+		// DEFLATE data; a zlib stream starts with a 0x78 CMF byte.
+		if b, err := br.Peek(1); err == nil && b[0] == 0x78 {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("zlib: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+}
+
+// isGzip reports whether br starts with the gzip magic bytes.
+func isGzip(br *bufio.Reader) bool {
+	magic, err := br.Peek(2)
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+func newGzipReader(r io.Reader) (io.Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	return gz, nil
+}
@@ -1,40 +1,151 @@
 package fetcher
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
 )
 
-// FetchM3U fetches the M3U playlist from url and parses it.
-// userAgent is optional; useTvgID controls name fallback (tvg-id vs comma-alt).
-func FetchM3U(ctx context.Context, url string, userAgent string, useTvgID bool, timeout time.Duration) ([]ParsedEntry, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("NewRequest: %w", err)
+// ErrNotModified is returned by FetchM3U when the playlist is the same
+// version as the one described by the previous Validators.
+var ErrNotModified = errors.New("playlist not modified")
+
+// ErrBodyTooLarge is returned when a playlist exceeds FetchOptions.MaxBodyBytes.
+var ErrBodyTooLarge = errors.New("playlist exceeds the maximum body size")
+
+// FetchOptions controls how FetchM3U requests and parses a playlist.
+type FetchOptions struct {
+	UserAgent    string            // optional User-Agent for the request
+	Headers      map[string]string // extra request headers, e.g. Referer or a token
+	Timeout      time.Duration     // overall timeout, covering all attempts and the body
+	Retry        RetryPolicy       // zero fields take DefaultRetryPolicy's values
+	UseTvgID     bool              // prefer tvg-id over comma-alt for the name fallback
+	MaxBodyBytes int64             // limit on the decompressed playlist size; 0 means no limit
+}
+
+// Validators identify a fetched playlist version. They are stored with the
+// source and passed back on the next fetch so unchanged playlists can be
+// skipped.
+type Validators struct {
+	ETag         string
+	LastModified string
+	ContentHash  string // hex SHA-256 of the body
+}
+
+// FetchM3U fetches the M3U playlist from url and parses it as it downloads,
+// so the raw body is never held in memory.
+// prev holds the validators of the last ingested version (zero to fetch
+// unconditionally); when the server answers 304, repeats the ETag, or the
+// body hashes the same, ErrNotModified is returned instead of a playlist.
+func FetchM3U(ctx context.Context, url string, opts FetchOptions, prev Validators) (*ParsedPlaylist, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	retry := opts.Retry
+	if retry.Attempts == 0 {
+		retry.Attempts = DefaultRetryPolicy.Attempts
 	}
-	if userAgent != "" {
-		req.Header.Set("User-Agent", userAgent)
+	if retry.BaseDelay == 0 {
+		retry.BaseDelay = DefaultRetryPolicy.BaseDelay
 	}
-	client := &http.Client{Timeout: timeout}
-	resp, err := client.Do(req)
+	if retry.MaxDelay == 0 {
+		retry.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+
+	resp, err := doWithRetry(ctx, http.DefaultClient, retry, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if opts.UserAgent != "" {
+			req.Header.Set("User-Agent", opts.UserAgent)
+		}
+		for k, v := range opts.Headers {
+			req.Header.Set(k, v)
+		}
+		// Setting Accept-Encoding ourselves turns off the transport's
+		// transparent gzip handling, so decompress below handles both
+		// encodings.
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		if prev.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.LastModified)
+		}
+		return req, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("Do: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("ReadAll: %w", err)
+
+	// Some servers ignore If-None-Match but still send a stable ETag.
+	etag := resp.Header.Get("ETag")
+	if prev.ETag != "" && etag == prev.ETag {
+		return nil, ErrNotModified
 	}
-	entries, err := ParseM3U(bytes.NewReader(body), useTvgID)
+
+	decoded, err := decompress(resp.Body, resp.Header.Get("Content-Encoding"))
 	if err != nil {
 		return nil, err
 	}
-	return entries, nil
+	if opts.MaxBodyBytes > 0 {
+		decoded = newMaxBytesReader(decoded, opts.MaxBodyBytes)
+	}
+
+	// The hash is taken over the decompressed playlist while it is parsed,
+	// so it does not change when a server toggles compression. A read error
+	// part way through (dropped connection, size limit) fails the parse, and
+	// nothing is stored.
+	h := sha256.New()
+	pl, err := ParseM3U(io.TeeReader(decoded, h), opts.UseTvgID)
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if prev.ContentHash != "" && hash == prev.ContentHash {
+		return nil, ErrNotModified
+	}
+
+	pl.Validators = Validators{
+		ETag:         etag,
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentHash:  hash,
+	}
+	return pl, nil
+}
+
+// maxBytesReader fails with ErrBodyTooLarge once more than max bytes have
+// been read, instead of silently truncating like a bare io.LimitReader.
+type maxBytesReader struct {
+	r   io.Reader // limited to max+1 bytes, so an overrun is detectable
+	n   int64
+	max int64
+}
+
+func newMaxBytesReader(r io.Reader, max int64) *maxBytesReader {
+	return &maxBytesReader{r: io.LimitReader(r, max+1), max: max}
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if m.n > m.max {
+		return 0, fmt.Errorf("%w (%d bytes)", ErrBodyTooLarge, m.max)
+	}
+	return n, err
 }
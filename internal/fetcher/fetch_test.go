@@ -0,0 +1,237 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+const testPlaylist = `#EXTM3U
+#EXTINF:-1 tvg-id="bbc1" group-title="News",BBC One
+http://example.com/live/1.ts
+#EXTINF:-1 group-title="News",CNN
+http://example.com/live/2.ts
+`
+
+// serve starts an httptest server answering with h and returns its URL.
+func serve(t *testing.T, h http.HandlerFunc) string {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// compress returns data encoded as gzip, zlib or raw DEFLATE.
+func compress(t *testing.T, encoding string, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "flate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	if _, err := io.WriteString(w, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// channelNames returns the names of the playlist's entries.
+func channelNames(pl *ParsedPlaylist) []string {
+	var names []string
+	for _, e := range pl.Entries {
+		names = append(names, e.Channel.Name)
+	}
+	return names
+}
+
+func TestFetchM3UDecompress(t *testing.T) {
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+	}{
+		{"plain", "", []byte(testPlaylist)},
+		{"gzip", "gzip", compress(t, "gzip", testPlaylist)},
+		{"x-gzip", "x-gzip", compress(t, "gzip", testPlaylist)},
+		{"sniffed gzip", "", compress(t, "gzip", testPlaylist)},
+		{"zlib deflate", "deflate", compress(t, "zlib", testPlaylist)},
+		{"raw deflate", "deflate", compress(t, "flate", testPlaylist)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding string
+			url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				if tt.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", tt.contentEncoding)
+				}
+				w.Write(tt.body)
+			})
+			pl, err := FetchM3U(context.Background(), url, FetchOptions{}, Validators{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if acceptEncoding != "gzip, deflate" {
+				t.Errorf("Accept-Encoding = %q, want gzip, deflate", acceptEncoding)
+			}
+			if got := strings.Join(channelNames(pl), ","); got != "BBC One,CNN" {
+				t.Errorf("channels = %s, want BBC One,CNN", got)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(testPlaylist))
+		})
+		_, err := FetchM3U(context.Background(), url, FetchOptions{}, Validators{})
+		if err == nil || !strings.Contains(err.Error(), `unsupported Content-Encoding "br"`) {
+			t.Fatalf("err = %v, want unsupported Content-Encoding", err)
+		}
+	})
+}
+
+// TestFetchM3UDecompressedLimit checks that MaxBodyBytes applies to the
+// decompressed playlist, not to the bytes on the wire.
+func TestFetchM3UDecompressedLimit(t *testing.T) {
+	body := compress(t, "gzip", testPlaylist+strings.Repeat("#EXTVLCOPT:http-user-agent=padding\n", 1000))
+	url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body)
+	})
+	limit := int64(2 * len(body))
+	_, err := FetchM3U(context.Background(), url, FetchOptions{MaxBodyBytes: limit}, Validators{})
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("err = %v, want ErrBodyTooLarge for %d compressed bytes under a %d byte limit", err, len(body), limit)
+	}
+}
+
+// TestFetchM3UBodyTooLarge checks that a playlist over MaxBodyBytes fails
+// with ErrBodyTooLarge rather than parsing as the channels before the cut.
+func TestFetchM3UBodyTooLarge(t *testing.T) {
+	url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testPlaylist))
+	})
+	for _, limit := range []int64{int64(len(testPlaylist)) - 1, int64(len(testPlaylist)) / 2} {
+		pl, err := FetchM3U(context.Background(), url, FetchOptions{MaxBodyBytes: limit}, Validators{})
+		if !errors.Is(err, ErrBodyTooLarge) || pl != nil {
+			t.Fatalf("limit %d: playlist %v, err = %v, want ErrBodyTooLarge", limit, pl, err)
+		}
+	}
+	pl, err := FetchM3U(context.Background(), url, FetchOptions{MaxBodyBytes: int64(len(testPlaylist))}, Validators{})
+	if err != nil {
+		t.Fatalf("at the limit: %v", err)
+	}
+	if len(pl.Entries) != 2 {
+		t.Errorf("at the limit: entries = %d, want 2", len(pl.Entries))
+	}
+}
+
+func TestFetchM3URequestHeaders(t *testing.T) {
+	var got http.Header
+	url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(testPlaylist))
+	})
+	opts := FetchOptions{
+		UserAgent: "popcornvault-test/1.0",
+		Headers:   map[string]string{"Referer": "http://portal.example.com/", "X-Token": "secret"},
+	}
+	prev := Validators{ETag: `"v1"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	if _, err := FetchM3U(context.Background(), url, opts, prev); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"User-Agent":        "popcornvault-test/1.0",
+		"Referer":           "http://portal.example.com/",
+		"X-Token":           "secret",
+		"If-None-Match":     `"v1"`,
+		"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT",
+		"Accept-Encoding":   "gzip, deflate",
+	}
+	for k, v := range want {
+		if got.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, got.Get(k), v)
+		}
+	}
+
+	// Without a UserAgent the request goes out with Go's default, and a
+	// header given in Headers overrides it.
+	if _, err := FetchM3U(context.Background(), url, FetchOptions{}, Validators{}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Get("User-Agent"); !strings.HasPrefix(ua, "Go-http-client/") {
+		t.Errorf("default User-Agent = %q", ua)
+	}
+	opts = FetchOptions{UserAgent: "a", Headers: map[string]string{"User-Agent": "b"}}
+	if _, err := FetchM3U(context.Background(), url, opts, Validators{}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := got.Get("User-Agent"); ua != "b" {
+		t.Errorf("User-Agent = %q, want the Headers value b", ua)
+	}
+}
+
+// TestFetchM3UHeaderGzipped fetches a gzipped playlist with header
+// attributes, served without a Content-Encoding as .m3u.gz files are.
+func TestFetchM3UHeaderGzipped(t *testing.T) {
+	playlist := `#EXTM3U url-tvg="http://epg.example.com/guide.xml.gz" tvg-shift=2
+#EXTINF:-1 tvg-id="bbc1" tvg-chno="101" tvg-logo="http://logos.example.com/bbc1.png" group-title="News",BBC One
+http://example.com/live/1.ts
+#EXTINF:-1 group-title="Movies",Heat
+http://example.com/movie/u/p/2.mkv
+`
+	body := compress(t, "gzip", playlist)
+	url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
+	})
+	pl, err := FetchM3U(context.Background(), url, FetchOptions{}, Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl.Meta.EPGURL != "http://epg.example.com/guide.xml.gz" {
+		t.Errorf("EPGURL = %q", pl.Meta.EPGURL)
+	}
+	if pl.Meta.TvgShift == nil || *pl.Meta.TvgShift != 2 {
+		t.Errorf("TvgShift = %v, want 2", pl.Meta.TvgShift)
+	}
+	if len(pl.Entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(pl.Entries))
+	}
+	bbc := pl.Entries[0].Channel
+	if bbc.Name != "BBC One" || bbc.URL != "http://example.com/live/1.ts" ||
+		bbc.TvgID == nil || *bbc.TvgID != "bbc1" || bbc.Number == nil || *bbc.Number != 101 ||
+		bbc.Group == nil || *bbc.Group != "News" || bbc.Image == nil || *bbc.Image != "http://logos.example.com/bbc1.png" ||
+		bbc.MediaType != models.MediaTypeLivestream {
+		t.Errorf("first entry = %+v", bbc)
+	}
+	heat := pl.Entries[1].Channel
+	if heat.Name != "Heat" || heat.Group == nil || *heat.Group != "Movies" || heat.MediaType != models.MediaTypeMovie {
+		t.Errorf("second entry = %+v", heat)
+	}
+	if pl.Validators.ContentHash == "" {
+		t.Error("no content hash")
+	}
+}
@@ -2,37 +2,48 @@ package fetcher
 
 import (
 	"bufio"
+	"encoding/json"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/voyagen/popcornvault/internal/models"
 )
 
 var (
-	reTvgName   = regexp.MustCompile(`tvg-name="([^"]*)"`)
-	reTvgID     = regexp.MustCompile(`tvg-id="([^"]*)"`)
-	reTvgLogo   = regexp.MustCompile(`tvg-logo="([^"]*)"`)
-	reGroup     = regexp.MustCompile(`group-title="([^"]*)"`)
-	reCommaName = regexp.MustCompile(`,([^\n\r\t]*)`)
-	reHTTPOrigin   = regexp.MustCompile(`http-origin=(.+)`)
-	reHTTPReferrer = regexp.MustCompile(`http-referrer=(.+)`)
+	reTvgName       = regexp.MustCompile(`tvg-name="([^"]*)"`)
+	reTvgID         = regexp.MustCompile(`tvg-id="([^"]*)"`)
+	reTvgLogo       = regexp.MustCompile(`tvg-logo="([^"]*)"`)
+	reTvgChno       = regexp.MustCompile(`tvg-chno="([^"]*)"`)
+	reGroup         = regexp.MustCompile(`group-title="([^"]*)"`)
+	reHeaderAttr    = regexp.MustCompile(`([A-Za-z0-9_-]+)=(?:"([^"]*)"|'([^']*)'|([^\s"']+))`)
+	reHTTPOrigin    = regexp.MustCompile(`http-origin=(.+)`)
+	reHTTPReferrer  = regexp.MustCompile(`http-referrer=(.+)`)
 	reHTTPUserAgent = regexp.MustCompile(`http-user-agent=(.+)`)
 )
 
-// ParseM3U reads an M3U playlist from r and returns channel entries with optional headers.
+// ParseM3U reads an M3U playlist from r and returns channel entries with optional headers,
+// plus the attributes of the #EXTM3U header.
 // useTvgID: if true, prefer tvg-id over comma-alt for channel name when tvg-name is empty.
-func ParseM3U(r io.Reader, useTvgID bool) ([]ParsedEntry, error) {
-	var entries []ParsedEntry
+func ParseM3U(r io.Reader, useTvgID bool) (*ParsedPlaylist, error) {
+	pl := &ParsedPlaylist{}
 	scanner := bufio.NewScanner(r)
 	// Handle long lines (some M3U have very long EXTINF lines).
 	const maxSize = 1024 * 1024
 	buf := make([]byte, 0, 64*1024)
 	scanner.Buffer(buf, maxSize)
 
+	// Directives (EXTVLCOPT, EXTHTTP, KODIPROP) may come before or after the
+	// #EXTINF line; all of them up to the URL line belong to that entry.
 	var extinfLine string
 	var headers *models.ChannelHttpHeaders
 	headersSet := false
+	var props map[string]string
+	// extgrp is the group opened by the last #EXTGRP line. It applies to
+	// every following entry without a group-title until the next #EXTGRP;
+	// an empty "#EXTGRP:" closes it.
+	var extgrp string
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -40,11 +51,35 @@ func ParseM3U(r io.Reader, useTvgID bool) ([]ParsedEntry, error) {
 		trimmed := strings.TrimSpace(line)
 
 		switch {
+		case strings.HasPrefix(lineUpper, "#EXTM3U"):
+			parseHeaderAttrs(&pl.Meta, line)
 		case strings.HasPrefix(lineUpper, "#EXTINF"):
-			// Previous EXTINF without URL is skipped (malformed)
+			// Previous EXTINF without URL is skipped (malformed), along
+			// with its directives.
+			if extinfLine != "" {
+				headers = nil
+				headersSet = false
+				props = nil
+			}
 			extinfLine = line
-			headers = nil
-			headersSet = false
+		case strings.HasPrefix(lineUpper, "#EXTGRP:"):
+			extgrp = strings.TrimSpace(line[len("#EXTGRP:"):])
+		case strings.HasPrefix(lineUpper, "#EXTHTTP:"):
+			if headers == nil {
+				headers = &models.ChannelHttpHeaders{}
+			}
+			if applyEXTHTTP(headers, line[len("#EXTHTTP:"):]) {
+				headersSet = true
+			}
+		case strings.HasPrefix(lineUpper, "#KODIPROP:"):
+			if k, v, ok := strings.Cut(line[len("#KODIPROP:"):], "="); ok {
+				if k = strings.TrimSpace(k); k != "" {
+					if props == nil {
+						props = make(map[string]string)
+					}
+					props[k] = strings.TrimSpace(v)
+				}
+			}
 		case strings.HasPrefix(lineUpper, "#EXTVLCOPT"):
 			if headers == nil {
 				headers = &models.ChannelHttpHeaders{}
@@ -72,28 +107,94 @@ func ParseM3U(r io.Reader, useTvgID bool) ([]ParsedEntry, error) {
 				continue
 			}
 			group := matchFirstPtr(reGroup, extinfLine)
+			if group == nil && extgrp != "" {
+				g := extgrp
+				group = &g
+			}
 			image := matchFirstPtr(reTvgLogo, extinfLine)
-			mediaType := mediaTypeFromURL(trimmed)
+			mediaType := DetectMediaType(trimmed, group, true)
 			ch := models.Channel{
 				Name:      strings.TrimSpace(name),
 				URL:       trimmed,
 				Group:     group,
 				Image:     image,
 				MediaType: mediaType,
+				TvgID:     matchFirstPtr(reTvgID, extinfLine),
+				Number:    channelNumber(extinfLine),
 			}
 			var h *models.ChannelHttpHeaders
 			if headersSet && headers != nil {
 				h = headers
 			}
-			entries = append(entries, ParsedEntry{Channel: ch, Headers: h})
+			pl.Entries = append(pl.Entries, ParsedEntry{Channel: ch, Headers: h, ExtraProps: props})
 			extinfLine = ""
 			headers = nil
+			headersSet = false
+			props = nil
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		return nil, err
 	}
-	return entries, nil
+	return pl, nil
+}
+
+// applyEXTHTTP sets the headers in an #EXTHTTP JSON object, e.g.
+// {"User-Agent":"...","Referer":"..."}, on h. Header names are matched
+// case-insensitively; ones ChannelHttpHeaders has no field for are ignored.
+// Reports whether any header was set.
+func applyEXTHTTP(h *models.ChannelHttpHeaders, raw string) bool {
+	var m map[string]string
+	if err := json.Unmarshal([]byte(strings.TrimSpace(raw)), &m); err != nil {
+		return false
+	}
+	set := false
+	for k, v := range m {
+		v := strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		switch strings.ToLower(k) {
+		case "user-agent":
+			h.UserAgent = &v
+		case "referer", "referrer":
+			h.Referrer = &v
+		case "origin":
+			h.HTTPOrigin = &v
+		default:
+			continue
+		}
+		set = true
+	}
+	return set
+}
+
+// parseHeaderAttrs adds the attributes of an #EXTM3U line to meta. Values may
+// be double-quoted, single-quoted or bare. Should a playlist repeat the
+// header, the first value of each attribute wins.
+func parseHeaderAttrs(meta *PlaylistMeta, line string) {
+	for _, m := range reHeaderAttr.FindAllStringSubmatch(line, -1) {
+		name := strings.ToLower(m[1])
+		value := strings.TrimSpace(m[2] + m[3] + m[4])
+		if meta.Attrs == nil {
+			meta.Attrs = make(map[string]string)
+		}
+		if _, ok := meta.Attrs[name]; ok {
+			continue
+		}
+		meta.Attrs[name] = value
+
+		switch name {
+		case "url-tvg", "x-tvg-url":
+			if meta.EPGURL == "" {
+				meta.EPGURL = value
+			}
+		case "tvg-shift":
+			if h, err := strconv.ParseFloat(value, 64); err == nil {
+				meta.TvgShift = &h
+			}
+		}
+	}
 }
 
 func matchFirst(re *regexp.Regexp, s string) string {
@@ -108,6 +209,15 @@ func matchFirst(re *regexp.Regexp, s string) string {
 	return v
 }
 
+// channelNumber parses tvg-chno, ignoring values that are not whole numbers.
+func channelNumber(extinf string) *int {
+	n, err := strconv.Atoi(matchFirst(reTvgChno, extinf))
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
 func matchFirstPtr(re *regexp.Regexp, s string) *string {
 	v := matchFirst(re, s)
 	if v == "" {
@@ -122,7 +232,7 @@ func channelNameFromEXTINF(extinf string, useTvgID bool) (string, error) {
 		return n, nil
 	}
 	id := matchFirst(reTvgID, extinf)
-	alt := matchFirst(reCommaName, extinf)
+	alt := extinfTitle(extinf)
 	if useTvgID {
 		if id != "" {
 			return id, nil
@@ -141,24 +251,26 @@ func channelNameFromEXTINF(extinf string, useTvgID bool) (string, error) {
 	return "", errNoName
 }
 
+// extinfTitle returns the display title of an EXTINF line: the text after the
+// first comma that is not inside a quoted attribute value, so titles and
+// attributes (e.g. group-title="News, Sports") may both contain commas.
+func extinfTitle(extinf string) string {
+	inQuote := false
+	for i := 0; i < len(extinf); i++ {
+		switch extinf[i] {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				return strings.TrimSpace(strings.TrimRight(extinf[i+1:], "\r\n\t"))
+			}
+		}
+	}
+	return ""
+}
+
 var errNoName = &parseError{msg: "no name from EXTINF"}
 
 type parseError struct{ msg string }
 
 func (e *parseError) Error() string { return e.msg }
-
-func mediaTypeFromURL(url string) int16 {
-	lower := strings.ToLower(url)
-	// Xtream Codes path patterns take priority.
-	if strings.Contains(lower, "/movie/") {
-		return models.MediaTypeMovie
-	}
-	if strings.Contains(lower, "/series/") {
-		return models.MediaTypeSerie
-	}
-	// Fallback: file extension for non-Xtream VOD.
-	if strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".mkv") {
-		return models.MediaTypeMovie
-	}
-	return models.MediaTypeLivestream
-}
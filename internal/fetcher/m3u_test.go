@@ -0,0 +1,172 @@
+package fetcher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseM3UHeader(t *testing.T) {
+	tests := []struct {
+		name, header string
+		epgURL       string
+		tvgShift     float64 // 0 for none
+		attrs        map[string]string
+	}{
+		{"none", `#EXTM3U`, "", 0, nil},
+		{"quoted url-tvg", `#EXTM3U url-tvg="http://epg.example.com/guide.xml"`, "http://epg.example.com/guide.xml", 0, nil},
+		{"unquoted x-tvg-url", `#EXTM3U x-tvg-url=http://epg.example.com/a.xml.gz`, "http://epg.example.com/a.xml.gz", 0, nil},
+		{"single quotes", `#EXTM3U url-tvg='http://epg.example.com/b.xml'`, "http://epg.example.com/b.xml", 0, nil},
+		{"url-tvg first", `#EXTM3U url-tvg="http://a.example.com" x-tvg-url="http://b.example.com"`, "http://a.example.com", 0, nil},
+		{"several guides", `#EXTM3U url-tvg="http://a.example.com/1.xml,http://a.example.com/2.xml"`, "http://a.example.com/1.xml,http://a.example.com/2.xml", 0, nil},
+		{"tvg-shift", `#EXTM3U tvg-shift="-1.5" url-tvg=http://e.example.com`, "http://e.example.com", -1.5, nil},
+		{"other attrs", `#EXTM3U Refresh=3600 catchup="append"`, "", 0, map[string]string{"refresh": "3600", "catchup": "append"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl, err := ParseM3U(strings.NewReader(tt.header+"\n#EXTINF:-1,A\nhttp://example.com/a\n"), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pl.Meta.EPGURL != tt.epgURL {
+				t.Errorf("EPGURL = %q, want %q", pl.Meta.EPGURL, tt.epgURL)
+			}
+			switch {
+			case tt.tvgShift == 0 && pl.Meta.TvgShift != nil:
+				t.Errorf("TvgShift = %v, want none", *pl.Meta.TvgShift)
+			case tt.tvgShift != 0 && (pl.Meta.TvgShift == nil || *pl.Meta.TvgShift != tt.tvgShift):
+				t.Errorf("TvgShift = %v, want %v", pl.Meta.TvgShift, tt.tvgShift)
+			}
+			for k, v := range tt.attrs {
+				if got := pl.Meta.Attrs[k]; got != v {
+					t.Errorf("Attrs[%q] = %q, want %q", k, got, v)
+				}
+			}
+			if len(pl.Entries) != 1 {
+				t.Errorf("entries = %d, want 1", len(pl.Entries))
+			}
+		})
+	}
+}
+
+func TestParseM3UDirectives(t *testing.T) {
+	playlist := `#EXTM3U
+#EXTINF:-1,VLC
+#EXTVLCOPT:http-user-agent=VLC/3.0
+#EXTVLCOPT:http-referrer=http://vlc.example.com/
+http://example.com/1
+#EXTHTTP:{"User-Agent":"Kodi/20","referer":"http://kodi.example.com/","Origin":"http://o.example.com","Cookie":"x=1"}
+#EXTINF:-1,HTTP before EXTINF
+http://example.com/2
+#EXTINF:-1,Mixed
+#KODIPROP:inputstream.adaptive.manifest_type=mpd
+#KODIPROP:inputstream.adaptive.license_type=com.widevine.alpha
+#KODIPROP:inputstream.adaptive.license_key=http://license.example.com/?k=v
+#EXTVLCOPT:http-user-agent=VLC/3.0
+#EXTHTTP:{"Referer":"http://mixed.example.com/"}
+http://example.com/3.mpd
+#EXTINF:-1,Bad JSON
+#EXTHTTP:{"User-Agent":
+#KODIPROP:no-equals-sign
+http://example.com/4
+#EXTINF:-1,Dropped
+#KODIPROP:dropped=1
+#EXTINF:-1,Plain
+http://example.com/5
+`
+	pl, err := ParseM3U(strings.NewReader(playlist), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(channelNames(pl), ","); got != "VLC,HTTP before EXTINF,Mixed,Bad JSON,Plain" {
+		t.Fatalf("channels = %s", got)
+	}
+	str := func(p *string) string {
+		if p == nil {
+			return "<nil>"
+		}
+		return *p
+	}
+
+	tests := []struct {
+		ua, referrer, origin string
+		props                map[string]string
+	}{
+		{"VLC/3.0", "http://vlc.example.com/", "<nil>", nil},
+		{"Kodi/20", "http://kodi.example.com/", "http://o.example.com", nil},
+		{"VLC/3.0", "http://mixed.example.com/", "<nil>", map[string]string{
+			"inputstream.adaptive.manifest_type": "mpd",
+			"inputstream.adaptive.license_type":  "com.widevine.alpha",
+			"inputstream.adaptive.license_key":   "http://license.example.com/?k=v",
+		}},
+		{"", "", "", nil},
+		{"", "", "", nil},
+	}
+	for i, tt := range tests {
+		e := pl.Entries[i]
+		if tt.ua == "" {
+			if e.Headers != nil {
+				t.Errorf("%s: headers = %+v, want none", e.Channel.Name, e.Headers)
+			}
+		} else if e.Headers == nil {
+			t.Errorf("%s: no headers", e.Channel.Name)
+		} else if str(e.Headers.UserAgent) != tt.ua || str(e.Headers.Referrer) != tt.referrer || str(e.Headers.HTTPOrigin) != tt.origin {
+			t.Errorf("%s: headers = %s, %s, %s, want %s, %s, %s", e.Channel.Name,
+				str(e.Headers.UserAgent), str(e.Headers.Referrer), str(e.Headers.HTTPOrigin), tt.ua, tt.referrer, tt.origin)
+		}
+		if len(e.ExtraProps) != len(tt.props) {
+			t.Errorf("%s: props = %v, want %v", e.Channel.Name, e.ExtraProps, tt.props)
+		}
+		for k, v := range tt.props {
+			if e.ExtraProps[k] != v {
+				t.Errorf("%s: props[%q] = %q, want %q", e.Channel.Name, k, e.ExtraProps[k], v)
+			}
+		}
+	}
+}
+
+func TestParseM3UEXTGRP(t *testing.T) {
+	playlist := `#EXTM3U
+#EXTINF:-1,No group
+http://example.com/0
+#EXTGRP:Movies
+#EXTINF:-1,Heat
+http://example.com/1
+#EXTINF:-1 group-title="Drama",Own group
+http://example.com/2
+#EXTINF:-1,Still movies
+http://example.com/3
+#EXTINF:-1,Group after EXTINF
+#EXTGRP:Sports
+http://example.com/4
+#EXTINF:-1,Sports too
+http://example.com/5
+#EXTGRP:
+#EXTINF:-1,Closed
+http://example.com/6
+`
+	pl, err := ParseM3U(strings.NewReader(playlist), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"No group":           "",
+		"Heat":               "Movies",
+		"Own group":          "Drama",
+		"Still movies":       "Movies",
+		"Group after EXTINF": "Sports",
+		"Sports too":         "Sports",
+		"Closed":             "",
+	}
+	if len(pl.Entries) != len(want) {
+		t.Fatalf("entries = %d, want %d", len(pl.Entries), len(want))
+	}
+	for _, e := range pl.Entries {
+		got := ""
+		if e.Channel.Group != nil {
+			got = *e.Channel.Group
+		}
+		if got != want[e.Channel.Name] {
+			t.Errorf("%s: group = %q, want %q", e.Channel.Name, got, want[e.Channel.Name])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package fetcher
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// M3UWriter writes channels as an extended M3U playlist that ParseM3U reads
+// back to the same names, URLs, tvg-ids, channel numbers, groups, logos and
+// headers.
+type M3UWriter struct {
+	w *bufio.Writer
+}
+
+// NewM3UWriter returns a writer that buffers output to w. Call Flush when done.
+func NewM3UWriter(w io.Writer) *M3UWriter {
+	return &M3UWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteHeader writes the #EXTM3U header line.
+func (mw *M3UWriter) WriteHeader() error {
+	_, err := mw.w.WriteString("#EXTM3U\n")
+	return err
+}
+
+// WriteEntry writes one channel: its #EXTINF line, optional #EXTVLCOPT header
+// lines, and the stream URL. h may be nil.
+func (mw *M3UWriter) WriteEntry(ch *models.Channel, h *models.ChannelHttpHeaders) error {
+	var b strings.Builder
+	b.WriteString("#EXTINF:-1")
+
+	// Attribute values cannot contain double quotes. The name is always
+	// repeated as the title after the comma, which the parser falls back to
+	// when tvg-name is absent, so names with quotes still round-trip. With a
+	// tvg-id present the parser would prefer that over the title, so there
+	// tvg-name is written anyway, with quotes replaced.
+	name := singleLine(ch.Name)
+	hasTvgID := ch.TvgID != nil && *ch.TvgID != ""
+	if hasTvgID || !strings.Contains(name, `"`) {
+		writeAttr(&b, "tvg-name", name)
+	}
+	if hasTvgID {
+		writeAttr(&b, "tvg-id", *ch.TvgID)
+	}
+	if ch.Number != nil {
+		writeAttr(&b, "tvg-chno", strconv.Itoa(*ch.Number))
+	}
+	if ch.Image != nil && *ch.Image != "" {
+		writeAttr(&b, "tvg-logo", *ch.Image)
+	}
+	group := ch.GroupName
+	if group == nil {
+		group = ch.Group
+	}
+	if group != nil && *group != "" {
+		writeAttr(&b, "group-title", *group)
+	}
+	b.WriteString(",")
+	b.WriteString(name)
+	b.WriteString("\n")
+
+	if h != nil {
+		if h.UserAgent != nil && *h.UserAgent != "" {
+			b.WriteString("#EXTVLCOPT:http-user-agent=" + singleLine(*h.UserAgent) + "\n")
+		}
+		if h.Referrer != nil && *h.Referrer != "" {
+			b.WriteString("#EXTVLCOPT:http-referrer=" + singleLine(*h.Referrer) + "\n")
+		}
+		if h.HTTPOrigin != nil && *h.HTTPOrigin != "" {
+			b.WriteString("#EXTVLCOPT:http-origin=" + singleLine(*h.HTTPOrigin) + "\n")
+		}
+	}
+
+	b.WriteString(singleLine(ch.URL))
+	b.WriteString("\n")
+
+	_, err := mw.w.WriteString(b.String())
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (mw *M3UWriter) Flush() error {
+	return mw.w.Flush()
+}
+
+// writeAttr appends ` key="value"`, replacing double quotes in value with
+// single quotes since the attribute syntax has no escape for them.
+func writeAttr(b *strings.Builder, key, value string) {
+	b.WriteString(" ")
+	b.WriteString(key)
+	b.WriteString(`="`)
+	b.WriteString(strings.ReplaceAll(singleLine(value), `"`, "'"))
+	b.WriteString(`"`)
+}
+
+// singleLine strips line breaks, which would split an entry across lines.
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
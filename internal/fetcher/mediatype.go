@@ -0,0 +1,119 @@
+package fetcher
+
+import (
+	"path"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// ParserVersion changes whenever parsing or classification changes in a way
+// that alters stored channels. Sources ingested by an older version are
+// re-ingested on their next refresh even if the playlist itself is unchanged.
+const ParserVersion = 4
+
+// vodExtensions are container formats that are practically never used for
+// live streams.
+var vodExtensions = map[string]bool{
+	".mp4": true, ".mkv": true, ".avi": true, ".mov": true, ".m4v": true,
+	".wmv": true, ".flv": true, ".webm": true, ".mpg": true, ".mpeg": true,
+	".divx": true, ".xvid": true, ".3gp": true, ".ogv": true, ".vob": true,
+}
+
+// Group-title words that mark VOD groups. Groups that also mention "live" or
+// "channels" (e.g. "Movie Channels") are live TV and are not matched.
+var (
+	movieGroupWords  = map[string]bool{"vod": true, "movie": true, "movies": true, "film": true, "films": true, "cinema": true, "peliculas": true}
+	seriesGroupWords = map[string]bool{"series": true, "serie": true, "season": true, "seasons": true, "episodes": true, "shows": true}
+	liveGroupWords   = map[string]bool{"live": true, "channel": true, "channels": true, "24/7": true}
+)
+
+// DetectMediaType classifies a playlist entry as livestream, movie or series.
+// Xtream path conventions (/movie/, /series/ segments) always apply. When
+// guess is true, the file extension and then group-title keywords are used
+// as well; otherwise everything else is a livestream.
+func DetectMediaType(rawURL string, group *string, guess bool) int16 {
+	p := strings.ToLower(urlPath(rawURL))
+
+	for _, seg := range strings.Split(p, "/") {
+		switch seg {
+		case "movie", "movies":
+			return models.MediaTypeMovie
+		case "series":
+			return models.MediaTypeSerie
+		}
+	}
+	if !guess {
+		return models.MediaTypeLivestream
+	}
+
+	ext := path.Ext(p)
+	if vodExtensions[ext] {
+		return models.MediaTypeMovie
+	}
+	// .ts is also the usual suffix of Xtream live URLs
+	// (/live/user/pass/123.ts or /user/pass/123.ts), so only a named file
+	// outside a live path counts as VOD.
+	if ext == ".ts" && !strings.Contains(p, "/live/") && !isNumeric(strings.TrimSuffix(path.Base(p), ext)) {
+		return models.MediaTypeMovie
+	}
+
+	if group != nil {
+		return mediaTypeFromGroup(*group)
+	}
+	return models.MediaTypeLivestream
+}
+
+// mediaTypeFromGroup guesses the media type from group-title keywords.
+func mediaTypeFromGroup(group string) int16 {
+	words := strings.FieldsFunc(strings.ToLower(group), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '/')
+	})
+	movie, series := false, false
+	for _, w := range words {
+		switch {
+		case liveGroupWords[w]:
+			return models.MediaTypeLivestream
+		case seriesGroupWords[w]:
+			series = true
+		case movieGroupWords[w]:
+			movie = true
+		}
+	}
+	// "VOD Series" is series; plain "VOD" is movies.
+	if series {
+		return models.MediaTypeSerie
+	}
+	if movie {
+		return models.MediaTypeMovie
+	}
+	return models.MediaTypeLivestream
+}
+
+// urlPath returns rawURL without its scheme, host, query string or fragment.
+func urlPath(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if i := strings.Index(rawURL, "://"); i >= 0 {
+		rawURL = rawURL[i+3:]
+		if j := strings.IndexByte(rawURL, '/'); j >= 0 {
+			rawURL = rawURL[j:]
+		} else {
+			rawURL = "/"
+		}
+	}
+	return rawURL
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
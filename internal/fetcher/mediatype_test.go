@@ -0,0 +1,56 @@
+package fetcher
+
+import (
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+func TestDetectMediaType(t *testing.T) {
+	const (
+		live   = models.MediaTypeLivestream
+		movie  = models.MediaTypeMovie
+		series = models.MediaTypeSerie
+	)
+	tests := []struct {
+		url, group string
+		want       int16 // with guessing
+		noGuess    int16 // with guessing disabled
+	}{
+		{"http://example.com/live/u/p/123.ts", "", live, live},
+		{"http://example.com/u/p/123.ts", "", live, live},
+		{"http://example.com/u/p/123.m3u8", "", live, live},
+		{"http://example.com/movie/u/p/123.mp4", "", movie, movie},
+		{"http://example.com/movie/u/p/123?token=x", "", movie, movie},
+		{"http://example.com/movies/Heat", "", movie, movie},
+		{"http://example.com/series/u/p/456.mkv", "", series, series},
+		{"http://example.com/vod/Heat.mkv", "", movie, live},
+		{"http://example.com/vod/Heat.AVI", "", movie, live},
+		{"http://example.com/vod/Heat.mp4?token=x&exp=1", "", movie, live},
+		{"http://example.com/vod/Heat.mp4#t=10", "", movie, live},
+		{"http://example.com/files/Heat.1995.ts", "", movie, live},
+		{"http://example.com/live/Heat.1995.ts", "", live, live},
+		{"http://example.com/stream?file=Heat.mp4", "", live, live},
+		{"http://example.com/mp4", "", live, live},
+		{"http://example.com/123", "VOD", movie, live},
+		{"http://example.com/123", "Movies | Action", movie, live},
+		{"http://example.com/123", "VOD Series", series, live},
+		{"http://example.com/123", "TV Shows", series, live},
+		{"http://example.com/123", "Movie Channels", live, live},
+		{"http://example.com/123", "24/7 Movies", live, live},
+		{"http://example.com/123", "News", live, live},
+		{"/relative/Heat.mkv", "", movie, live},
+	}
+	for _, tt := range tests {
+		var group *string
+		if tt.group != "" {
+			group = &tt.group
+		}
+		if got := DetectMediaType(tt.url, group, true); got != tt.want {
+			t.Errorf("DetectMediaType(%q, %q) = %d, want %d", tt.url, tt.group, got, tt.want)
+		}
+		if got := DetectMediaType(tt.url, group, false); got != tt.noGuess {
+			t.Errorf("DetectMediaType(%q, %q) without guessing = %d, want %d", tt.url, tt.group, got, tt.noGuess)
+		}
+	}
+}
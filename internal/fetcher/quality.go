@@ -0,0 +1,70 @@
+package fetcher
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// qualityTokens maps name tokens (lowercased, brackets removed) to the
+// quality tier they denote.
+var qualityTokens = map[string]string{
+	"sd": models.QualitySD, "480p": models.QualitySD, "576p": models.QualitySD, "ˢᴰ": models.QualitySD,
+	"hd": models.QualityHD, "720p": models.QualityHD, "ᴴᴰ": models.QualityHD,
+	"fhd": models.QualityFHD, "1080p": models.QualityFHD, "1080i": models.QualityFHD, "ᶠᴴᴰ": models.QualityFHD,
+	"uhd": models.Quality4K, "4k": models.Quality4K, "2160p": models.Quality4K, "ᵁᴴᴰ": models.Quality4K, "⁴ᴷ": models.Quality4K,
+}
+
+// superscriptQualities are the glyph forms providers often glue straight onto
+// the name ("ESPNᴴᴰ"), longest first.
+var superscriptQualities = []string{"ᶠᴴᴰ", "ᵁᴴᴰ", "ᴴᴰ", "ˢᴰ", "⁴ᴷ"}
+
+// qualityModifiers are codec and frame rate tokens that say nothing about the
+// channel itself; they are dropped from the display name.
+var qualityModifiers = map[string]bool{
+	"h264": true, "h265": true, "hevc": true, "x265": true,
+	"25fps": true, "50fps": true, "60fps": true,
+}
+
+// qualityRank orders tiers so the best one wins when a name has several.
+var qualityRank = map[string]int{models.QualitySD: 1, models.QualityHD: 2, models.QualityFHD: 3, models.Quality4K: 4}
+
+// DetectQuality recognises quality tokens such as "FHD", "4K", "[HD]" or
+// "H265" in a channel name. It returns the best quality tier found (empty if
+// none) and the name with all such tokens removed, e.g. "ESPN" for
+// "ESPN FHD H265". A name made only of tokens is returned unchanged.
+func DetectQuality(name string) (quality, displayName string) {
+	words := strings.Fields(name)
+	kept := make([]string, 0, len(words))
+	for _, w := range words {
+		for _, sup := range superscriptQualities {
+			if base, ok := strings.CutSuffix(w, sup); ok && base != "" {
+				if q := qualityTokens[sup]; qualityRank[q] > qualityRank[quality] {
+					quality = q
+				}
+				w = base
+				break
+			}
+		}
+		tok := strings.ToLower(strings.TrimFunc(w, func(r rune) bool {
+			return unicode.IsPunct(r) || unicode.IsSymbol(r)
+		}))
+		if q, ok := qualityTokens[tok]; ok {
+			if qualityRank[q] > qualityRank[quality] {
+				quality = q
+			}
+			continue
+		}
+		if qualityModifiers[tok] {
+			continue
+		}
+		kept = append(kept, w)
+	}
+
+	displayName = strings.TrimRight(strings.Join(kept, " "), " -|:")
+	if displayName == "" {
+		return quality, name
+	}
+	return quality, displayName
+}
@@ -2,8 +2,30 @@ package fetcher
 
 import "github.com/voyagen/popcornvault/internal/models"
 
-// ParsedEntry is a channel plus optional HTTP headers (from EXTVLCOPT).
+// ParsedEntry is a channel plus optional HTTP headers (from EXTVLCOPT or
+// EXTHTTP) and player properties (from KODIPROP).
 type ParsedEntry struct {
-	Channel models.Channel
-	Headers *models.ChannelHttpHeaders
+	Channel    models.Channel
+	Headers    *models.ChannelHttpHeaders
+	ExtraProps map[string]string
+}
+
+// PlaylistMeta holds the attributes of the #EXTM3U header line.
+type PlaylistMeta struct {
+	// EPGURL is the XMLTV guide declared by url-tvg or x-tvg-url; it may
+	// list several comma-separated URLs. Empty if none.
+	EPGURL string
+	// TvgShift is the guide time shift in hours (tvg-shift), if declared.
+	TvgShift *float64
+	// Attrs holds every header attribute by lowercased name, including the
+	// ones above and any the parser does not interpret.
+	Attrs map[string]string
+}
+
+// ParsedPlaylist is the result of parsing an M3U playlist.
+type ParsedPlaylist struct {
+	Meta    PlaylistMeta
+	Entries []ParsedEntry
+	// Validators identify this version of the playlist; set by FetchM3U.
+	Validators Validators
 }
@@ -0,0 +1,122 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only network errors,
+// 429 and 5xx responses are retried; anything else fails immediately.
+type RetryPolicy struct {
+	Attempts  int           // total attempts including the first; <= 1 disables retries
+	BaseDelay time.Duration // delay before the second attempt, doubled after each one
+	MaxDelay  time.Duration // upper bound for a single delay, including Retry-After
+}
+
+// DefaultRetryPolicy is used when a caller leaves the policy zero, or fills
+// in just some of its fields.
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// doWithRetry sends the request built by newReq, retrying per policy. newReq
+// is called once per attempt since a request cannot be reused after Do. It
+// returns a response with a non-retryable status, which the caller must
+// close. Waiting between attempts stops as soon as ctx is done, and no
+// attempt is made whose delay would run past ctx's deadline.
+func doWithRetry(ctx context.Context, client *http.Client, policy RetryPolicy, newReq func() (*http.Request, error)) (*http.Response, error) {
+	attempts := max(policy.Attempts, 1)
+	var (
+		lastErr error
+		attempt int
+	)
+	for attempt = 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, fmt.Errorf("NewRequest: %w", err)
+		}
+
+		var retryAfter time.Duration
+		resp, err := client.Do(req)
+		switch {
+		case err != nil:
+			// A cancelled or expired ctx is final, not a transient error.
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("Do: %w", err)
+			}
+			lastErr = fmt.Errorf("Do: %w", err)
+		case retryableStatus(resp.StatusCode):
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			// Drain a little so the connection can be reused.
+			io.CopyN(io.Discard, resp.Body, 4096)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("HTTP %d", resp.StatusCode)
+		default:
+			return resp, nil
+		}
+
+		if attempt >= attempts {
+			break
+		}
+		delay := policy.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+			if policy.MaxDelay > 0 {
+				delay = min(delay, policy.MaxDelay)
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			break
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("after %d attempts: %w", attempt, errors.Join(lastErr, ctx.Err()))
+		case <-timer.C:
+		}
+	}
+	if attempt == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("after %d attempts: %w", attempt, lastErr)
+}
+
+// backoff returns the delay after the given (1-based) failed attempt:
+// exponential in attempt with jitter in [d/2, d), capped at MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry retries without waiting long between attempts.
+var fastRetry = RetryPolicy{Attempts: 4, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+func TestFetchM3URetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int // responses with status before the playlist
+		status   int
+		attempts int32
+		err      string // substring of the error; empty for success
+	}{
+		{"first try", 0, 0, 1, ""},
+		{"503 then success", 2, http.StatusServiceUnavailable, 3, ""},
+		{"429 then success", 3, http.StatusTooManyRequests, 4, ""},
+		{"out of attempts", 4, http.StatusBadGateway, 4, "after 4 attempts: HTTP 502"},
+		{"404 is final", 4, http.StatusNotFound, 1, "HTTP 404"},
+		{"401 is final", 4, http.StatusUnauthorized, 1, "HTTP 401"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+				if int(attempts.Add(1)) <= tt.failures {
+					w.Header().Set("Retry-After", "0")
+					http.Error(w, "try later", tt.status)
+					return
+				}
+				w.Write([]byte(testPlaylist))
+			})
+			pl, err := FetchM3U(context.Background(), url, FetchOptions{Retry: fastRetry}, Validators{})
+			if got := attempts.Load(); got != tt.attempts {
+				t.Errorf("attempts = %d, want %d", got, tt.attempts)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pl.Entries) != 2 {
+				t.Errorf("entries = %d, want 2", len(pl.Entries))
+			}
+		})
+	}
+}
+
+func TestFetchM3URetryStopsAtDeadline(t *testing.T) {
+	var attempts atomic.Int32
+	url := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+	})
+	retry := RetryPolicy{Attempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Minute}
+	start := time.Now()
+	_, err := FetchM3U(context.Background(), url, FetchOptions{Retry: retry, Timeout: time.Second}, Validators{})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Fatalf("err = %v, want HTTP 503", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("attempts = %d, want 1: the Retry-After delay runs past the timeout", got)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("gave up after %v, want at once", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(future); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, want about an hour", future, got)
+	}
+}
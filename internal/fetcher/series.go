@@ -0,0 +1,63 @@
+package fetcher
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Episode is a series episode recognised from a channel name.
+type Episode struct {
+	Series  string
+	Season  int
+	Episode int // first episode of a multi-part entry such as S01E01E02
+}
+
+var (
+	// S01E02, S01 E02, S01.E02, and multi-part S01E01E02 / S01E01-E02 / S01E01-02.
+	reSxxEyy = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,3})(?:-?E?\d{1,3})*\b`)
+	// 1x05; at most two season digits so resolutions like 1920x1080 do not match.
+	reNxNN = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	// Season markers stripped from a group name used as the series title.
+	reSeasonSuffix = regexp.MustCompile(`(?i)[ ._-]*(?:\bS\d{1,2}\b|\bSeason[ ._-]*\d{1,2}\b|\bStaffel[ ._-]*\d{1,2}\b|\bTemporada[ ._-]*\d{1,2}\b).*$`)
+)
+
+// ParseEpisode recognises SxxEyy and NxNN episode markers in name. The series
+// title is the text before the marker (e.g. "Show (2019)" in
+// "Show (2019) S01E02 - Pilot"); when the name has no such prefix, as in
+// playlists that put each series in its own group, the group name is used
+// instead with any season suffix removed. Reports false if name has no
+// marker or no title can be found.
+func ParseEpisode(name string, group *string) (Episode, bool) {
+	loc := reSxxEyy.FindStringSubmatchIndex(name)
+	if loc == nil {
+		loc = reNxNN.FindStringSubmatchIndex(name)
+	}
+	if loc == nil {
+		return Episode{}, false
+	}
+	season, err1 := strconv.Atoi(name[loc[2]:loc[3]])
+	episode, err2 := strconv.Atoi(name[loc[4]:loc[5]])
+	if err1 != nil || err2 != nil {
+		return Episode{}, false
+	}
+
+	title := cleanSeriesTitle(name[:loc[0]])
+	if title == "" && group != nil {
+		title = cleanSeriesTitle(reSeasonSuffix.ReplaceAllString(*group, ""))
+	}
+	if title == "" {
+		return Episode{}, false
+	}
+	return Episode{Series: title, Season: season, Episode: episode}, true
+}
+
+// cleanSeriesTitle trims separators around a title and turns dotted release
+// names ("The.Show.") into spaced ones.
+func cleanSeriesTitle(s string) string {
+	if !strings.Contains(strings.TrimSpace(s), " ") {
+		s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	}
+	s = strings.Trim(s, " \t-_.|:[(")
+	return strings.Join(strings.Fields(s), " ")
+}
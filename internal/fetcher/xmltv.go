@@ -0,0 +1,212 @@
+package fetcher
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// XMLTVReader reads <programme> elements from an XMLTV guide one at a time.
+// Guides can be hundreds of megabytes, so the document is decoded as a
+// token stream and never held in memory as a whole.
+type XMLTVReader struct {
+	dec    *xml.Decoder
+	closer io.Closer
+}
+
+// xmltvProgramme mirrors the parts of an XMLTV <programme> element we keep.
+type xmltvProgramme struct {
+	Start      string   `xml:"start,attr"`
+	Stop       string   `xml:"stop,attr"`
+	Channel    string   `xml:"channel,attr"`
+	Titles     []string `xml:"title"`
+	Descs      []string `xml:"desc"`
+	Categories []string `xml:"category"`
+}
+
+// FetchXMLTV requests an XMLTV guide and returns a reader over its
+// programmes. The caller must Close the reader. timeout bounds the wait for
+// the response headers only; the body of a large guide may take much longer
+// to download and is bounded by ctx instead.
+func FetchXMLTV(ctx context.Context, url string, userAgent string, timeout time.Duration) (*XMLTVReader, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("NewRequest: %w", err)
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Do: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	xr, err := NewXMLTVReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	xr.closer = resp.Body
+	return xr, nil
+}
+
+// NewXMLTVReader returns a reader over the programmes in r. Gzip-compressed
+// input (e.g. guide.xml.gz) is detected from its magic bytes and
+// decompressed on the fly.
+func NewXMLTVReader(r io.Reader) (*XMLTVReader, error) {
+	r, err := decompress(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = xmltvCharsetReader
+	return &XMLTVReader{dec: dec}, nil
+}
+
+// Next returns the next programme in the guide, or io.EOF when there are no
+// more. Programmes without a channel, title or valid start/stop time are
+// skipped.
+func (x *XMLTVReader) Next() (*models.EPGProgram, error) {
+	for {
+		tok, err := x.dec.Token()
+		if err != nil {
+			return nil, err // io.EOF at the end of the document
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "programme" {
+			continue
+		}
+
+		var xp xmltvProgramme
+		if err := x.dec.DecodeElement(&xp, &se); err != nil {
+			return nil, fmt.Errorf("decode programme: %w", err)
+		}
+		if p := xp.toProgram(); p != nil {
+			return p, nil
+		}
+	}
+}
+
+// Close closes the underlying response body, if any.
+func (x *XMLTVReader) Close() error {
+	if x.closer == nil {
+		return nil
+	}
+	return x.closer.Close()
+}
+
+// toProgram converts xp to a model, or returns nil if it is unusable.
+func (xp *xmltvProgramme) toProgram() *models.EPGProgram {
+	channel := strings.TrimSpace(xp.Channel)
+	title := firstNonEmpty(xp.Titles)
+	if channel == "" || title == "" {
+		return nil
+	}
+	start, err := parseXMLTVTime(xp.Start)
+	if err != nil {
+		return nil
+	}
+	stop, err := parseXMLTVTime(xp.Stop)
+	if err != nil || !stop.After(start) {
+		return nil
+	}
+
+	p := &models.EPGProgram{
+		ChannelTvgID: channel,
+		Start:        start,
+		Stop:         stop,
+		Title:        title,
+	}
+	if d := firstNonEmpty(xp.Descs); d != "" {
+		p.Description = &d
+	}
+	if c := firstNonEmpty(xp.Categories); c != "" {
+		p.Category = &c
+	}
+	return p
+}
+
+// xmltvTimeLayouts are the timestamp forms seen in XMLTV files: full
+// precision with or without a UTC offset, and the minute-precision variant.
+var xmltvTimeLayouts = []string{
+	"20060102150405 -0700",
+	"20060102150405-0700",
+	"20060102150405",
+	"200601021504 -0700",
+	"200601021504",
+}
+
+// parseXMLTVTime parses an XMLTV timestamp. Times without an offset are UTC.
+func parseXMLTVTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range xmltvTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid XMLTV time %q", s)
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// xmltvCharsetReader lets the decoder read guides declared as ISO-8859-1,
+// which many providers still emit; encoding/xml only understands UTF-8.
+func xmltvCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return &latin1Reader{r: bufio.NewReader(input)}, nil
+	}
+	return nil, fmt.Errorf("unsupported XMLTV charset %q", charset)
+}
+
+// latin1Reader converts ISO-8859-1 bytes to UTF-8.
+type latin1Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (l *latin1Reader) Read(p []byte) (int, error) {
+	for len(l.buf) < len(p) {
+		b, err := l.r.ReadByte()
+		if err != nil {
+			if len(l.buf) > 0 {
+				break
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			l.buf = append(l.buf, b)
+		} else {
+			// Latin-1 code points map 1:1 to U+0080..U+00FF.
+			l.buf = append(l.buf, 0xc0|b>>6, 0x80|b&0x3f)
+		}
+	}
+	n := copy(p, l.buf)
+	l.buf = l.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,51 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrCancelled is returned by Runner.Run, and is the context cause, for a
+// job stopped through Cancel.
+var ErrCancelled = errors.New("job cancelled")
+
+// running holds the cancel functions of the jobs running in this process.
+// It is shared by every Runner, so the HTTP handlers can stop jobs started
+// by the server as well as by the Redis worker.
+var running = &registry{jobs: make(map[string]context.CancelCauseFunc)}
+
+type registry struct {
+	mu   sync.Mutex
+	jobs map[string]context.CancelCauseFunc
+}
+
+func (r *registry) add(id string, cancel context.CancelCauseFunc) {
+	r.mu.Lock()
+	r.jobs[id] = cancel
+	r.mu.Unlock()
+}
+
+func (r *registry) remove(id string) {
+	r.mu.Lock()
+	delete(r.jobs, id)
+	r.mu.Unlock()
+}
+
+// Cancel stops the job id if it is running in this process, including the
+// embeddings an ingest left running in the background, and reports whether
+// it was. The job's status becomes StateCancelled once it has stopped.
+func Cancel(id string) bool {
+	running.mu.Lock()
+	cancel, ok := running.jobs[id]
+	running.mu.Unlock()
+	if ok {
+		cancel(ErrCancelled)
+	}
+	return ok
+}
+
+// Cancelled reports whether ctx was cancelled through Cancel.
+func Cancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
@@ -0,0 +1,124 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// ErrNotFound is returned when a job id is unknown or its record has expired.
+var ErrNotFound = errors.New("job not found")
+
+// State is the lifecycle state of a background job.
+type State string
+
+// Job states reported by GET /api/jobs/{id}.
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateDone      State = "done"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+)
+
+// Finished reports whether s is a final state.
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed || s == StateCancelled
+}
+
+// statusTTL is how long finished job records are kept around for polling.
+const statusTTL = 24 * time.Hour
+
+// Status is the externally visible record of a background job.
+type Status struct {
+	ID           string     `json:"id"`
+	Kind         string     `json:"kind"`
+	State        State      `json:"state"`
+	SourceID     int64      `json:"source_id,omitempty"`
+	SourceName   string     `json:"source_name,omitempty"`
+	Phase        string     `json:"phase,omitempty"`
+	Processed    int        `json:"processed"`
+	Total        int        `json:"total"`
+	ChannelCount int        `json:"channel_count"`
+	Unchanged    bool       `json:"unchanged,omitempty"`
+	Duplicates   int        `json:"duplicates_skipped,omitempty"`
+	StaleRemoved int        `json:"stale_removed,omitempty"`
+	Added        int        `json:"channels_added,omitempty"`
+	Updated      int        `json:"channels_updated,omitempty"`
+	RefreshRunID int64      `json:"refresh_run_id,omitempty"` // ingest jobs: see GET /api/sources/{id}/refreshes
+	Embedded     int        `json:"embedded,omitempty"`
+	EmbedSkipped int        `json:"embeddings_skipped,omitempty"`
+	EmbedTokens  int64      `json:"embedding_tokens,omitempty"`
+	EmbedJobID   string     `json:"embed_job_id,omitempty"` // ingest jobs: the queued embeddings job
+	Error        string     `json:"error,omitempty"`
+	Attempts     int        `json:"attempts,omitempty"` // failed attempts of a queued job so far
+	RetryAt      *time.Time `json:"retry_at,omitempty"` // when a failed queued job runs again
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// Result summarises a finished job.
+type Result struct {
+	SourceID     int64
+	Count        int    // channels ingested, embedded or checked, EPG programmes stored, or tuples indexed
+	Unchanged    bool   // ingest skipped because the playlist had not changed
+	Duplicates   int    // playlist entries skipped by the source's dedupe setting
+	StaleRemoved int    // channels removed because the playlist no longer lists them
+	EmbedJobID   string // embeddings job queued by an ingest
+
+	Diff         models.RefreshDiff // channels an ingest added, removed and updated
+	RefreshRunID int64              // the refresh run an ingest was recorded as
+}
+
+// Tracker persists job status records.
+type Tracker interface {
+	// Save creates or replaces the record for st.ID.
+	Save(ctx context.Context, st *Status) error
+	// Get returns the record for id, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Status, error)
+	// SetLatest records jobID as the most recent job for sourceID.
+	SetLatest(ctx context.Context, sourceID int64, jobID string) error
+	// Latest returns the most recent job for sourceID, or ErrNotFound.
+	Latest(ctx context.Context, sourceID int64) (*Status, error)
+}
+
+// NewID returns a random job identifier.
+func NewID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Queue records job as queued, and as the latest job of its source, and
+// pushes it onto the Redis job queue.
+func Queue(ctx context.Context, rds *cache.Redis, t Tracker, job cache.Job) error {
+	if job.ID != "" && t != nil {
+		st := &Status{
+			ID:         job.ID,
+			Kind:       job.Kind,
+			State:      StateQueued,
+			SourceID:   job.SourceID,
+			SourceName: job.SourceName,
+			CreatedAt:  time.Now(),
+		}
+		if err := t.Save(ctx, st); err != nil {
+			return fmt.Errorf("save job: %w", err)
+		}
+		if job.SourceID != 0 {
+			if err := t.SetLatest(ctx, job.SourceID, job.ID); err != nil {
+				return fmt.Errorf("save job: %w", err)
+			}
+		}
+	}
+	if err := cache.Enqueue(ctx, rds, cache.DefaultQueue, job); err != nil {
+		return fmt.Errorf("enqueue job: %w", err)
+	}
+	return nil
+}
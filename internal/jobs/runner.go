@@ -0,0 +1,383 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/logging"
+	"github.com/voyagen/popcornvault/internal/service"
+	"github.com/voyagen/popcornvault/internal/store"
+	"github.com/voyagen/popcornvault/internal/webhook"
+)
+
+// Runner executes background jobs and records their progress in a Tracker.
+// It is shared by the Redis worker and the in-process fallback used when
+// Redis is not configured.
+type Runner struct {
+	Store    store.Store
+	Embedder *embedding.Client // nil when VOYAGE_API_KEY is not set
+	Tracker  Tracker
+	Timeout  time.Duration // fetch timeout for ingest jobs
+	MaxBytes int64         // playlist size limit for ingest jobs; 0 means no limit
+	Retries  int           // fetch attempts for ingest jobs; 0 uses the default
+	Backoff  time.Duration // delay before the first fetch retry; 0 uses the default
+	// WarmCache fills the store's cache after each ingest (see
+	// service.IngestOptions.WarmCache).
+	WarmCache bool
+
+	CheckConcurrency int           // parallel requests for check jobs; 0 uses the default
+	CheckTimeout     time.Duration // per-channel timeout for check jobs; 0 uses the default
+
+	// Queue, when set, receives the embeddings jobs that follow an ingest;
+	// without it they run in a background goroutine.
+	Queue *cache.Redis
+
+	// Webhooks, when set, notifies WebhookURL and the source's webhook_url
+	// when an ingest or embeddings job ends.
+	Webhooks   *webhook.Sender
+	WebhookURL string
+}
+
+// Run executes job and returns its result. Progress and the outcome are
+// recorded on the job's status record when it has an id. Ingest jobs queue
+// their embeddings as a separate job when Queue is set; otherwise the record
+// stays "running" while embeddings are generated in the background after
+// Run returns. A job with an id can be stopped with Cancel until then; one
+// cancelled while still queued is not run and ErrCancelled is returned.
+// Entries logged for the job carry the request id of the API request that
+// submitted it.
+func (r *Runner) Run(ctx context.Context, job cache.Job) (Result, error) {
+	if job.RequestID != "" {
+		ctx = logging.WithRequestID(ctx, job.RequestID)
+	}
+	rec, err := r.begin(ctx, job)
+	if err != nil {
+		slog.InfoContext(ctx, "job cancelled before it started", "job_id", job.ID, "kind", job.Kind, "source", job.SourceName)
+		return Result{SourceID: job.SourceID}, err
+	}
+	if rec != nil {
+		// The lifetime outlasts Run while background embeddings are still
+		// reporting to rec; Cancel ends it, and with it ctx.
+		life, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+		running.add(job.ID, cancel)
+		rec.life = life
+		rec.release = func(st Status) {
+			running.remove(job.ID)
+			r.notify(st)
+		}
+
+		var cancelRun context.CancelCauseFunc
+		ctx, cancelRun = context.WithCancelCause(ctx)
+		defer cancelRun(nil)
+		stop := context.AfterFunc(life, func() { cancelRun(context.Cause(life)) })
+		defer stop()
+
+		ctx = service.WithLifetime(service.WithProgress(ctx, rec), life)
+	}
+
+	res := Result{SourceID: job.SourceID}
+	switch job.Kind {
+	case cache.JobIngest:
+		var ir service.IngestResult
+		ir, err = service.Ingest(ctx, r.Store, job.URL, job.SourceName, service.IngestOptions{
+			UserAgent: job.UserAgent,
+			Headers:   job.Headers,
+			Timeout:   r.Timeout,
+			MaxBytes:  r.MaxBytes,
+			Retries:   r.Retries,
+			Backoff:   r.Backoff,
+			UseTvgID:  job.UseTvgID,
+			NoGuess:   job.NoMediaGuess,
+			Dedupe:    job.Dedupe,
+			SourceID:  job.SourceID,
+			Force:     job.Force,
+			Embedder:  r.Embedder,
+			WarmCache: r.WarmCache,
+
+			EmbedQueue: r.embedQueue(),
+		})
+		res = Result{SourceID: ir.SourceID, Count: ir.ChannelCount, Unchanged: ir.Unchanged, Duplicates: ir.Duplicates, StaleRemoved: ir.StaleRemoved, EmbedJobID: ir.EmbedJobID,
+			Diff: ir.Diff, RefreshRunID: ir.RunID}
+	case cache.JobEmbeddings:
+		if r.Embedder == nil {
+			err = fmt.Errorf("embeddings not configured (VOYAGE_API_KEY not set)")
+			break
+		}
+		var er service.EmbedResult
+		if job.MissingOnly {
+			er, err = service.ResumeEmbeddings(ctx, r.Store, r.Embedder, job.SourceID, job.SourceName)
+		} else {
+			er, err = service.RefreshEmbeddings(ctx, r.Store, r.Embedder, job.SourceID, job.SourceName, job.Force)
+		}
+		res.Count = er.Embedded
+	case cache.JobEPG:
+		res.Count, err = service.RefreshEPG(ctx, r.Store, job.SourceID, job.SourceName, job.URL, job.UserAgent, r.Timeout)
+	case cache.JobCheck:
+		var cr service.CheckResult
+		cr, err = service.CheckChannels(ctx, r.Store, job.SourceID, job.SourceName, service.CheckOptions{
+			Concurrency: r.CheckConcurrency,
+			Timeout:     r.CheckTimeout,
+			UserAgent:   job.UserAgent,
+
+			DeadPolicy:    job.DeadPolicy,
+			DeadThreshold: job.DeadThreshold,
+		})
+		res.Count = cr.Checked
+	case cache.JobReindex:
+		res.Count, err = service.RebuildEmbeddingIndex(ctx, r.Store)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	if err != nil && Cancelled(ctx) {
+		err = ErrCancelled
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "job failed", "job_id", job.ID, "kind", job.Kind, "source", job.SourceName, "err", err)
+	}
+
+	if rec != nil {
+		rec.update(func(st *Status) {
+			rec.ran = true
+			if res.SourceID != 0 {
+				st.SourceID = res.SourceID
+			}
+			st.ChannelCount = res.Count
+			st.Unchanged = res.Unchanged
+			st.Duplicates = res.Duplicates
+			st.StaleRemoved = res.StaleRemoved
+			st.Added = len(res.Diff.Added)
+			st.Updated = len(res.Diff.Updated)
+			st.RefreshRunID = res.RefreshRunID
+			st.EmbedJobID = res.EmbedJobID
+			if err != nil && !st.State.Finished() {
+				now := time.Now()
+				st.State = StateFailed
+				if errors.Is(err, ErrCancelled) {
+					st.State = StateCancelled
+				}
+				st.Error = err.Error()
+				st.FinishedAt = &now
+			}
+		})
+	}
+	return res, err
+}
+
+// notify sends the webhooks for a finished ingest or embeddings job, to
+// WebhookURL and to the source's own webhook_url, in the background. A
+// failed delivery is only logged; it does not change the job's outcome.
+func (r *Runner) notify(st Status) {
+	if r.Webhooks == nil || st.SourceID == 0 || (st.Kind != cache.JobIngest && st.Kind != cache.JobEmbeddings) {
+		return
+	}
+	// Not tied to the job's lifetime: a cancelled job is reported too.
+	service.Go(context.Background(), "webhooks for job "+st.ID, func(ctx context.Context) {
+		var urls []string
+		if r.WebhookURL != "" {
+			urls = append(urls, r.WebhookURL)
+		}
+		src, err := r.Store.GetSourceByID(ctx, st.SourceID)
+		if err != nil {
+			slog.WarnContext(ctx, "webhook: load source", "job_id", st.ID, "err", err)
+		} else if src.WebhookURL != "" && src.WebhookURL != r.WebhookURL {
+			urls = append(urls, src.WebhookURL)
+		}
+
+		ev := webhookEvent(st)
+		for _, u := range urls {
+			if err := r.Webhooks.Send(ctx, u, ev); err != nil {
+				slog.WarnContext(ctx, "webhook delivery failed", "job_id", st.ID, "url", u, "err", err)
+			}
+		}
+	})
+}
+
+// webhookEvent describes a finished job for a webhook delivery.
+func webhookEvent(st Status) webhook.Event {
+	ev := webhook.Event{
+		Event:            webhook.EventRefreshCompleted,
+		JobID:            st.ID,
+		Kind:             st.Kind,
+		State:            string(st.State),
+		SourceID:         st.SourceID,
+		SourceName:       st.SourceName,
+		ChannelCount:     st.ChannelCount,
+		StaleRemoved:     st.StaleRemoved,
+		EmbeddingsStored: st.Embedded,
+		Unchanged:        st.Unchanged,
+		Error:            st.Error,
+		FinishedAt:       time.Now(),
+	}
+	if st.State != StateDone {
+		ev.Event = webhook.EventRefreshFailed
+	}
+	if st.FinishedAt != nil {
+		ev.FinishedAt = *st.FinishedAt
+	}
+	start := st.CreatedAt
+	if st.StartedAt != nil {
+		start = *st.StartedAt
+	}
+	ev.DurationMS = ev.FinishedAt.Sub(start).Milliseconds()
+	return ev
+}
+
+// embedQueue returns the service.EmbedQueue that queues embeddings jobs on
+// r.Queue, or nil without a queue.
+func (r *Runner) embedQueue() service.EmbedQueue {
+	if r.Queue == nil {
+		return nil
+	}
+	return func(ctx context.Context, sourceID int64, sourceName string, missingOnly, force bool) (string, error) {
+		job := cache.Job{
+			ID:             NewID(),
+			Kind:           cache.JobEmbeddings,
+			SourceID:       sourceID,
+			SourceName:     sourceName,
+			EmbeddingsOnly: true,
+			MissingOnly:    missingOnly,
+			Force:          force,
+		}
+		if err := Queue(ctx, r.Queue, r.Tracker, job); err != nil {
+			return "", err
+		}
+		return job.ID, nil
+	}
+}
+
+// begin marks the job as running and returns a recorder for it, or nil for
+// jobs without an id (e.g. queued by an older version). It returns
+// ErrCancelled for a job cancelled while it was queued.
+func (r *Runner) begin(ctx context.Context, job cache.Job) (*recorder, error) {
+	if job.ID == "" || r.Tracker == nil {
+		return nil, nil
+	}
+	// Status must be recorded even when the job is cancelled by shutdown.
+	ctx = context.WithoutCancel(ctx)
+
+	st, err := r.Tracker.Get(ctx, job.ID)
+	if err != nil {
+		st = &Status{
+			ID:         job.ID,
+			Kind:       job.Kind,
+			SourceID:   job.SourceID,
+			SourceName: job.SourceName,
+			CreatedAt:  time.Now(),
+		}
+	}
+	if st.State == StateCancelled {
+		return nil, ErrCancelled
+	}
+	rec := &recorder{ctx: ctx, tracker: r.Tracker, st: *st}
+	rec.update(func(st *Status) {
+		now := time.Now()
+		st.State = StateRunning
+		st.StartedAt = &now
+		st.FinishedAt = nil
+		st.RetryAt = nil
+		st.Error = ""
+	})
+	return rec, nil
+}
+
+// Retrying marks a failed job as queued again for another attempt at
+// retryAt, keeping the error of the attempt that failed.
+func (r *Runner) Retrying(ctx context.Context, job cache.Job, attempts int, retryAt time.Time) {
+	if job.ID == "" || r.Tracker == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	st, err := r.Tracker.Get(ctx, job.ID)
+	if err != nil {
+		return
+	}
+	st.State = StateQueued
+	st.Attempts = attempts
+	st.RetryAt = &retryAt
+	if err := r.Tracker.Save(ctx, st); err != nil {
+		slog.WarnContext(ctx, "save job status", "job_id", job.ID, "err", err)
+	}
+}
+
+// recorder holds the in-memory copy of one job's status and persists every
+// change. It implements service.ProgressReporter; the mutex serialises
+// updates from the job itself and from the background embedding goroutine.
+type recorder struct {
+	ctx     context.Context
+	tracker Tracker
+
+	// life is cancelled with ErrCancelled when the job is cancelled;
+	// release is called once Run has returned (ran) and the status is
+	// final, which for an ingest may be when its background embeddings end.
+	life    context.Context
+	release func(Status)
+
+	mu       sync.Mutex
+	st       Status
+	ran      bool
+	released bool
+}
+
+// Report implements service.ProgressReporter.
+func (rec *recorder) Report(p service.Progress) {
+	rec.update(func(st *Status) {
+		if st.State.Finished() {
+			return // a late report must not reopen a finished job
+		}
+		st.Phase = p.Phase
+		if p.Phase != service.PhaseFailed {
+			// Keep the last counts on failure so clients see how far it got.
+			st.Processed = p.Processed
+			st.Total = p.Total
+			if p.Phase == service.PhaseEmbeddings || p.Embedded > 0 || p.Skipped > 0 || p.Tokens > 0 {
+				st.Embedded = p.Embedded
+				st.EmbedSkipped = p.Skipped
+				st.EmbedTokens = p.Tokens
+			}
+		}
+
+		now := time.Now()
+		switch p.Phase {
+		case service.PhaseDone:
+			st.State = StateDone
+			st.FinishedAt = &now
+		case service.PhaseFailed:
+			st.State = StateFailed
+			st.FinishedAt = &now
+			if p.Err != nil {
+				st.Error = p.Err.Error()
+			}
+			if rec.life != nil && Cancelled(rec.life) {
+				st.State = StateCancelled
+				st.Error = ErrCancelled.Error()
+			}
+		}
+	})
+}
+
+func (rec *recorder) update(fn func(*Status)) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	fn(&rec.st)
+	if rec.st.State.Finished() && rec.ran && rec.release != nil && !rec.released {
+		rec.released = true
+		rec.release(rec.st)
+	}
+	if err := rec.tracker.Save(rec.ctx, &rec.st); err != nil {
+		slog.WarnContext(rec.ctx, "save job status", "job_id", rec.st.ID, "err", err)
+	}
+	watchers.publish(rec.st)
+	if rec.st.SourceID != 0 {
+		if err := rec.tracker.SetLatest(rec.ctx, rec.st.SourceID, rec.st.ID); err != nil {
+			slog.WarnContext(rec.ctx, "save latest job", "job_id", rec.st.ID, "source_id", rec.st.SourceID, "err", err)
+		}
+	}
+}
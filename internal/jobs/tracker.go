@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/voyagen/popcornvault/internal/cache"
+)
+
+// RedisTracker stores job records in Redis under "job:{id}" so that the
+// HTTP server and the background worker share the same view. Records it
+// cannot write to Redis are kept in process memory instead, as the jobs
+// they belong to then run in this process (see Server.submitJob).
+type RedisTracker struct {
+	rds      *cache.Redis
+	fallback *MemoryTracker
+}
+
+// NewRedisTracker returns a Tracker backed by Redis.
+func NewRedisTracker(rds *cache.Redis) *RedisTracker {
+	return &RedisTracker{rds: rds, fallback: NewMemoryTracker()}
+}
+
+func (t *RedisTracker) Save(ctx context.Context, st *Status) error {
+	if err := cache.Set(ctx, t.rds, "job:"+st.ID, st, statusTTL); err != nil {
+		if !errors.Is(err, cache.ErrUnavailable) {
+			slog.WarnContext(ctx, "jobs: save to redis failed, keeping the record in memory", "job_id", st.ID, "err", err)
+		}
+		return t.fallback.Save(ctx, st)
+	}
+	return nil
+}
+
+func (t *RedisTracker) Get(ctx context.Context, id string) (*Status, error) {
+	st, err := cache.Get[Status](ctx, t.rds, "job:"+id)
+	if err != nil {
+		if fst, ferr := t.fallback.Get(ctx, id); ferr == nil {
+			return fst, nil
+		}
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (t *RedisTracker) SetLatest(ctx context.Context, sourceID int64, jobID string) error {
+	if err := cache.Set(ctx, t.rds, fmt.Sprintf("job:source:%d", sourceID), jobID, statusTTL); err != nil {
+		return t.fallback.SetLatest(ctx, sourceID, jobID)
+	}
+	return nil
+}
+
+// Latest returns the newer of the records in Redis and in memory, as jobs
+// submitted while Redis was down are only in memory.
+func (t *RedisTracker) Latest(ctx context.Context, sourceID int64) (*Status, error) {
+	fst, ferr := t.fallback.Latest(ctx, sourceID)
+	id, err := cache.Get[string](ctx, t.rds, fmt.Sprintf("job:source:%d", sourceID))
+	var st *Status
+	if err == nil {
+		st, err = t.Get(ctx, id)
+	}
+	switch {
+	case ferr != nil:
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrNotFound
+		}
+		return st, err
+	case err != nil || fst.CreatedAt.After(st.CreatedAt):
+		return fst, nil
+	}
+	return st, nil
+}
+
+// MemoryTracker keeps job records in process memory. It is used when Redis
+// is not configured; records are lost on restart.
+type MemoryTracker struct {
+	mu     sync.Mutex
+	jobs   map[string]Status
+	latest map[int64]string // source id -> job id
+}
+
+// NewMemoryTracker returns an empty in-process Tracker.
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{jobs: make(map[string]Status), latest: make(map[int64]string)}
+}
+
+func (t *MemoryTracker) Save(_ context.Context, st *Status) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[st.ID] = *st
+
+	// Drop finished records past their TTL so the map cannot grow forever.
+	cutoff := time.Now().Add(-statusTTL)
+	for id, j := range t.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			delete(t.jobs, id)
+		}
+	}
+	return nil
+}
+
+func (t *MemoryTracker) Get(_ context.Context, id string) (*Status, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st, ok := t.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &st, nil
+}
+
+func (t *MemoryTracker) SetLatest(_ context.Context, sourceID int64, jobID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.latest[sourceID] = jobID
+	return nil
+}
+
+func (t *MemoryTracker) Latest(ctx context.Context, sourceID int64) (*Status, error) {
+	t.mu.Lock()
+	id, ok := t.latest[sourceID]
+	t.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return t.Get(ctx, id)
+}
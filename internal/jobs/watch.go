@@ -0,0 +1,54 @@
+package jobs
+
+import "sync"
+
+// watchers fans out the status changes of jobs running in this process to
+// subscribers, keyed by source id. It is fed by every recorder update, so
+// it sees the same progress as the status endpoints.
+var watchers = &broker{subs: make(map[int64]map[chan Status]struct{})}
+
+type broker struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan Status]struct{}
+}
+
+// Watch subscribes to the status changes of the jobs of sourceID that run
+// in this process. The channel holds only the latest status: a subscriber
+// that falls behind skips intermediate updates instead of blocking the job,
+// and always receives the last one. Call stop to unsubscribe.
+func Watch(sourceID int64) (updates <-chan Status, stop func()) {
+	ch := make(chan Status, 1)
+	b := watchers
+	b.mu.Lock()
+	if b.subs[sourceID] == nil {
+		b.subs[sourceID] = make(map[chan Status]struct{})
+	}
+	b.subs[sourceID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[sourceID], ch)
+		if len(b.subs[sourceID]) == 0 {
+			delete(b.subs, sourceID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// publish sends st to the subscribers of its source, replacing any update
+// they have not read yet.
+func (b *broker) publish(st Status) {
+	if st.SourceID == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[st.SourceID] {
+		select {
+		case <-ch: // drop the unread update
+		default:
+		}
+		ch <- st
+	}
+}
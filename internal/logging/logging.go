@@ -0,0 +1,78 @@
+// Package logging configures the process-wide log/slog logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Formats accepted by Setup.
+const (
+	FormatText = "text" // key=value lines, colored when writing to a terminal
+	FormatJSON = "json" // one JSON object per line, for Loki and the like
+)
+
+// Setup installs the default slog logger writing to stderr in format
+// ("text" or "json", empty meaning text) at level ("debug", "info", "warn"
+// or "error", empty meaning info). Output of the standard log package goes
+// through it as well, at info level.
+func Setup(format, level string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("log level %q: %w", level, err)
+		}
+	}
+	h, err := NewHandler(os.Stderr, format, lvl, isTerminal(os.Stderr))
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// NewHandler returns a handler writing records at or above level to w.
+// color only applies to the text format. Records logged with a context
+// carrying a request id (see WithRequestID) get a request_id attribute.
+func NewHandler(w io.Writer, format string, level slog.Leveler, color bool) (slog.Handler, error) {
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return contextHandler{newTextHandler(w, level, color)}, nil
+	case FormatJSON:
+		return contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: replaceJSONAttr})}, nil
+	default:
+		return nil, fmt.Errorf("log format %q: want %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+// replaceJSONAttr writes durations as strings such as "1.5s", which LogQL
+// compares as durations, instead of nanosecond counts.
+func replaceJSONAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindDuration {
+		a.Value = slog.StringValue(roundDuration(a.Value.Duration()).String())
+	}
+	return a
+}
+
+// roundDuration drops the precision that is noise in a log line.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond)
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond)
+	default:
+		return d.Round(time.Second)
+	}
+}
+
+// isTerminal reports whether f is a character device, i.e. not a file,
+// pipe or log collector.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
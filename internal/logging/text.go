@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ANSI escapes used by the colored text format.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+	ansiGray   = "\x1b[90m"
+)
+
+// textHandler writes records as
+//
+//	2006/01/02 15:04:05 INFO  message key=value key="quoted value"
+//
+// with the level colored and the keys dimmed when color is set.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	color bool
+
+	attrs  []byte // attributes added with WithAttrs, already formatted
+	prefix string // key prefix of the open groups, e.g. "req."
+}
+
+func newTextHandler(w io.Writer, level slog.Leveler, color bool) *textHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &textHandler{mu: new(sync.Mutex), w: w, level: level, color: color}
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = h2.appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := make([]byte, 0, 256)
+	if !r.Time.IsZero() {
+		buf = r.Time.AppendFormat(buf, "2006/01/02 15:04:05 ")
+	}
+	buf = h.appendLevel(buf, r.Level)
+	buf = append(buf, ' ')
+	buf = append(buf, r.Message...)
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = h.appendAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+// appendLevel appends the level padded to five characters.
+func (h *textHandler) appendLevel(buf []byte, level slog.Level) []byte {
+	s := level.String()
+	pad := strings.Repeat(" ", max(5-len(s), 0))
+	if !h.color {
+		return append(buf, s+pad...)
+	}
+	color := ansiCyan
+	switch {
+	case level >= slog.LevelError:
+		color = ansiRed
+	case level >= slog.LevelWarn:
+		color = ansiYellow
+	case level < slog.LevelInfo:
+		color = ansiGray
+	}
+	return append(buf, color+s+ansiReset+pad...)
+}
+
+func (h *textHandler) appendAttr(buf []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			buf = h.appendAttr(buf, prefix, ga)
+		}
+		return buf
+	}
+
+	buf = append(buf, ' ')
+	if h.color {
+		buf = append(buf, ansiDim+prefix+a.Key+"="+ansiReset...)
+	} else {
+		buf = append(buf, prefix+a.Key+"="...)
+	}
+	return appendValue(buf, a.Value)
+}
+
+func appendValue(buf []byte, v slog.Value) []byte {
+	var s string
+	switch v.Kind() {
+	case slog.KindDuration:
+		s = roundDuration(v.Duration()).String()
+	case slog.KindTime:
+		s = v.Time().Format(time.RFC3339)
+	default:
+		s = v.String()
+	}
+	if s == "" || strings.IndexFunc(s, needsQuote) >= 0 {
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, s...)
+}
+
+func needsQuote(r rune) bool {
+	return r == ' ' || r == '=' || r == '"' || !unicode.IsPrint(r)
+}
@@ -0,0 +1,121 @@
+// Package metrics defines the Prometheus metrics served on /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// EmbeddingTokens counts the VoyageAI tokens billed for embedding a
+// source's channels, labelled by source name.
+var EmbeddingTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "popcornvault_embedding_tokens_total",
+	Help: "VoyageAI tokens used to embed channels, by source.",
+}, []string{"source"})
+
+// SlowQueries counts the Postgres queries logged as slow, labelled by the
+// store method that ran them.
+var SlowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "popcornvault_db_slow_queries_total",
+	Help: "Postgres queries slower than the slow query threshold, by store method.",
+}, []string{"method"})
+
+// RegisterDBPool exports the statistics of the Postgres connection pool,
+// read from stat at every scrape.
+func RegisterDBPool(stat func() *pgxpool.Stat) {
+	prometheus.MustRegister(dbPoolCollector{stat})
+}
+
+var (
+	dbPoolMaxConns = prometheus.NewDesc("popcornvault_db_pool_max_conns",
+		"Maximum size of the Postgres connection pool.", nil, nil)
+	dbPoolTotalConns = prometheus.NewDesc("popcornvault_db_pool_total_conns",
+		"Postgres connections open, in use, idle or being opened.", nil, nil)
+	dbPoolAcquiredConns = prometheus.NewDesc("popcornvault_db_pool_acquired_conns",
+		"Postgres connections in use.", nil, nil)
+	dbPoolIdleConns = prometheus.NewDesc("popcornvault_db_pool_idle_conns",
+		"Idle Postgres connections.", nil, nil)
+	dbPoolAcquires = prometheus.NewDesc("popcornvault_db_pool_acquires_total",
+		"Connections acquired from the Postgres pool.", nil, nil)
+	dbPoolEmptyAcquires = prometheus.NewDesc("popcornvault_db_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection because the pool was empty.", nil, nil)
+	dbPoolCanceledAcquires = prometheus.NewDesc("popcornvault_db_pool_canceled_acquires_total",
+		"Acquires cancelled while waiting for a connection.", nil, nil)
+	dbPoolAcquireSeconds = prometheus.NewDesc("popcornvault_db_pool_acquire_seconds_total",
+		"Time spent acquiring connections from the Postgres pool.", nil, nil)
+)
+
+// dbPoolCollector turns pgxpool statistics into metrics.
+type dbPoolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{dbPoolMaxConns, dbPoolTotalConns, dbPoolAcquiredConns, dbPoolIdleConns,
+		dbPoolAcquires, dbPoolEmptyAcquires, dbPoolCanceledAcquires, dbPoolAcquireSeconds} {
+		ch <- d
+	}
+}
+
+func (c dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.stat()
+	ch <- prometheus.MustNewConstMetric(dbPoolMaxConns, prometheus.GaugeValue, float64(st.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(dbPoolTotalConns, prometheus.GaugeValue, float64(st.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(dbPoolAcquiredConns, prometheus.GaugeValue, float64(st.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(dbPoolIdleConns, prometheus.GaugeValue, float64(st.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(dbPoolAcquires, prometheus.CounterValue, float64(st.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbPoolEmptyAcquires, prometheus.CounterValue, float64(st.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbPoolCanceledAcquires, prometheus.CounterValue, float64(st.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(dbPoolAcquireSeconds, prometheus.CounterValue, st.AcquireDuration().Seconds())
+}
+
+// CacheCounts are the counters of one kind of cache entry.
+type CacheCounts struct {
+	Hits, Misses, FillErrors, Invalidations int64
+}
+
+// RegisterCache exports the cache counters of each kind of entry, read from
+// counts at every scrape.
+func RegisterCache(counts func() map[string]CacheCounts) {
+	prometheus.MustRegister(cacheCollector{counts})
+}
+
+var (
+	cacheHits = prometheus.NewDesc("popcornvault_cache_hits_total",
+		"Store reads answered from the cache, by kind of entry.", []string{"entity"}, nil)
+	cacheMisses = prometheus.NewDesc("popcornvault_cache_misses_total",
+		"Store reads that went to Postgres, by kind of entry.", []string{"entity"}, nil)
+	cacheFillErrors = prometheus.NewDesc("popcornvault_cache_fill_errors_total",
+		"Cache misses whose Postgres read failed, by kind of entry.", []string{"entity"}, nil)
+	cacheInvalidations = prometheus.NewDesc("popcornvault_cache_invalidations_total",
+		"Cache keys and key patterns deleted, by kind of entry.", []string{"entity"}, nil)
+)
+
+// cacheCollector turns cache counters into metrics.
+type cacheCollector struct {
+	counts func() map[string]CacheCounts
+}
+
+func (c cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{cacheHits, cacheMisses, cacheFillErrors, cacheInvalidations} {
+		ch <- d
+	}
+}
+
+func (c cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for entity, n := range c.counts() {
+		ch <- prometheus.MustNewConstMetric(cacheHits, prometheus.CounterValue, float64(n.Hits), entity)
+		ch <- prometheus.MustNewConstMetric(cacheMisses, prometheus.CounterValue, float64(n.Misses), entity)
+		ch <- prometheus.MustNewConstMetric(cacheFillErrors, prometheus.CounterValue, float64(n.FillErrors), entity)
+		ch <- prometheus.MustNewConstMetric(cacheInvalidations, prometheus.CounterValue, float64(n.Invalidations), entity)
+	}
+}
+
+// Handler serves the registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
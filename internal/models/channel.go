@@ -1,15 +1,111 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
 // Channel represents a single stream entry from an M3U (name, url, group, image, media_type).
 type Channel struct {
-	ID        int64   `json:"id,omitempty"`
-	Name      string  `json:"name"`
-	URL       string  `json:"url,omitempty"`
-	Group     *string `json:"group,omitempty"`
-	Image     *string `json:"image,omitempty"`
-	MediaType int16   `json:"media_type"`
-	SourceID  int64   `json:"source_id,omitempty"`
-	GroupID   *int64  `json:"group_id,omitempty"`
-	Favorite  bool    `json:"favorite"`
-	GroupName *string `json:"group_name,omitempty"` // populated by read queries (joined from groups table)
+	ID          int64      `json:"id,omitempty"`
+	Name        string     `json:"name"`
+	URL         string     `json:"url,omitempty"`
+	Group       *string    `json:"group,omitempty"`
+	Image       *string    `json:"image,omitempty"`
+	MediaType   int16      `json:"media_type"`
+	SourceID    int64      `json:"source_id,omitempty"`
+	GroupID     *int64     `json:"group_id,omitempty"`
+	Favorite    bool       `json:"favorite"`
+	TvgID       *string    `json:"tvg_id,omitempty"`         // XMLTV channel id; links the channel to its EPG programmes
+	Number      *int       `json:"channel_number,omitempty"` // lineup position from tvg-chno
+	Series      *string    `json:"series_name,omitempty"`    // set for episodes (MediaTypeSerie)
+	Season      *int       `json:"season,omitempty"`
+	Episode     *int       `json:"episode,omitempty"`
+	Quality     *string    `json:"quality,omitempty"`      // SD, HD, FHD or 4K, detected from the name
+	DisplayName *string    `json:"display_name,omitempty"` // name without quality tokens, if it differs
+	Status      *string    `json:"status,omitempty"`       // ok, dead or timeout; nil until checked
+	StatusCode  *int       `json:"status_code,omitempty"`  // HTTP status of the last check, if a response arrived
+	LastChecked *time.Time `json:"last_checked,omitempty"`
+	FailCount   int        `json:"failed_checks,omitempty"` // consecutive failed checks
+	Hidden      bool       `json:"hidden,omitempty"`        // hidden by the source's dead channel policy
+	CreatedAt   *time.Time `json:"created_at,omitempty"`    // when the channel first appeared in its source
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`    // when a refresh last changed its playlist fields, or moved it
+	GroupName   *string    `json:"group_name,omitempty"`    // populated by read queries (joined from groups table)
+	GroupImage  *string    `json:"group_image,omitempty"`   // likewise
+	SourceName  string     `json:"source_name,omitempty"`   // likewise, from the sources table
+
+	Edited EditedFields `json:"edited_fields,omitempty"` // fields set through the API, kept across refreshes
+
+	Headers *ChannelHttpHeaders `json:"headers,omitempty"` // populated by GetChannelByID
+}
+
+// MarshalJSON adds media_type_label, the name of MediaType, to the JSON
+// form of the channel.
+func (c Channel) MarshalJSON() ([]byte, error) {
+	type channel Channel // without the MarshalJSON method
+	return json.Marshal(struct {
+		channel
+		MediaTypeLabel string `json:"media_type_label,omitempty"`
+	}{channel(c), MediaTypeName(c.MediaType)})
+}
+
+// EditedFields is the set of channel fields a user changed through the API.
+// A refresh keeps their values instead of taking the playlist's.
+type EditedFields int
+
+// Editable channel fields.
+const (
+	EditedName EditedFields = 1 << iota
+	EditedURL
+	EditedImage
+	EditedGroup
+	EditedMediaType
+)
+
+// editedFieldNames are the JSON names of the editable fields, in bit order.
+var editedFieldNames = []string{"name", "url", "image", "group_id", "media_type"}
+
+// ParseEditedField returns the bit of an editable field's JSON name.
+func ParseEditedField(name string) (EditedFields, error) {
+	for i, n := range editedFieldNames {
+		if n == name {
+			return 1 << i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown field %q (valid: %s)", name, strings.Join(editedFieldNames, ", "))
+}
+
+// Names returns the JSON names of the fields in f.
+func (f EditedFields) Names() []string {
+	var names []string
+	for i, n := range editedFieldNames {
+		if f&(1<<i) != 0 {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// MarshalJSON writes f as a list of field names.
+func (f EditedFields) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.Names())
+}
+
+// UnmarshalJSON reads a list of field names.
+func (f *EditedFields) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	*f = 0
+	for _, n := range names {
+		bit, err := ParseEditedField(n)
+		if err != nil {
+			return err
+		}
+		*f |= bit
+	}
+	return nil
 }
@@ -1,11 +1,17 @@
 package models
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Source type constants (aligned with Rust source_type).
 const (
-	SourceTypeM3U      int16 = 0
-	SourceTypeM3ULink  int16 = 1
-	SourceTypeXtream   int16 = 2
-	SourceTypeCustom   int16 = 3
+	SourceTypeM3U     int16 = 0
+	SourceTypeM3ULink int16 = 1
+	SourceTypeXtream  int16 = 2
+	SourceTypeCustom  int16 = 3
 )
 
 // Media type constants.
@@ -14,3 +20,66 @@ const (
 	MediaTypeMovie      int16 = 1
 	MediaTypeSerie      int16 = 2
 )
+
+// mediaTypeNames are the names of the media types, indexed by code.
+var mediaTypeNames = []string{"livestream", "movie", "serie"}
+
+// MediaTypeName returns the name of a media type code, or "" for an unknown
+// code.
+func MediaTypeName(mt int16) string {
+	if mt < 0 || int(mt) >= len(mediaTypeNames) {
+		return ""
+	}
+	return mediaTypeNames[mt]
+}
+
+// ParseMediaType parses a media type given by code ("1") or by name
+// ("movie"), ignoring case; "series" is accepted for "serie".
+func ParseMediaType(s string) (int16, error) {
+	if n, err := strconv.ParseInt(s, 10, 16); err == nil {
+		if MediaTypeName(int16(n)) == "" {
+			return 0, fmt.Errorf("unknown media type %d (valid: 0-%d)", n, len(mediaTypeNames)-1)
+		}
+		return int16(n), nil
+	}
+	name := strings.ToLower(strings.TrimSpace(s))
+	if name == "series" {
+		name = "serie"
+	}
+	for i, n := range mediaTypeNames {
+		if n == name {
+			return int16(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown media type %q (valid: %s, series or 0-%d)", s, strings.Join(mediaTypeNames, ", "), len(mediaTypeNames)-1)
+}
+
+// Stream quality tiers detected from channel names.
+const (
+	QualitySD  = "SD"
+	QualityHD  = "HD"
+	QualityFHD = "FHD"
+	Quality4K  = "4K"
+)
+
+// Channel health states recorded by stream checks.
+const (
+	ChannelStatusOK      = "ok"
+	ChannelStatusDead    = "dead"
+	ChannelStatusTimeout = "timeout"
+)
+
+// Dead channel policies: what happens to channels that fail a source's
+// threshold of consecutive checks.
+const (
+	DeadPolicyKeep   = "keep"   // only record the status
+	DeadPolicyHide   = "hide"   // hide from default listings
+	DeadPolicyDelete = "delete" // delete, except favorites, which are hidden
+)
+
+// Stale channel policies: what a refresh does with channels the playlist no
+// longer lists.
+const (
+	StalePolicyDelete  = "delete"  // delete them with their headers
+	StalePolicyArchive = "archive" // keep them aside until they come back or age out
+)
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// EPGProgram is one programme from a source's XMLTV guide. ChannelTvgID
+// matches the tvg-id of the channels it belongs to.
+type EPGProgram struct {
+	ID           int64     `json:"id,omitempty"`
+	SourceID     int64     `json:"source_id,omitempty"`
+	ChannelTvgID string    `json:"channel_tvg_id"`
+	Start        time.Time `json:"start"`
+	Stop         time.Time `json:"stop"`
+	Title        string    `json:"title"`
+	Description  *string   `json:"description,omitempty"`
+	Category     *string   `json:"category,omitempty"`
+}
@@ -6,4 +6,12 @@ type Group struct {
 	Name     string  `json:"name"`
 	Image    *string `json:"image,omitempty"`
 	SourceID int64   `json:"source_id"`
+	Hidden   bool    `json:"hidden"` // left out of listings with its channels
+	// Virtual marks the Favorites entry GET /api/groups adds on request. Its
+	// id is 0, so it cannot be passed as group_id; its channels are those of
+	// GET /api/favorites, and its count leaves out hidden ones like that
+	// listing does.
+	Virtual bool `json:"virtual,omitempty"`
+
+	ChannelCount int `json:"channel_count"` // channels in the group, hidden ones included
 }
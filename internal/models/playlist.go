@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Playlist is a user-defined collection of channels, which may come from
+// several sources.
+type Playlist struct {
+	ID          int64      `json:"id,omitempty"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+
+	ChannelCount int `json:"channel_count"` // channels in the playlist, hidden ones included
+}
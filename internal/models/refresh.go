@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// RefreshRun records an ingest of a source and what it changed.
+type RefreshRun struct {
+	ID           int64     `json:"id"`
+	SourceID     int64     `json:"source_id"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	ChannelCount int       `json:"channel_count"`
+	Unchanged    bool      `json:"unchanged"` // the playlist had not changed; nothing was written
+	RefreshDiff
+}
+
+// RefreshDiff lists the channels an ingest added, removed and updated, and
+// the groups it deleted for having no channels left. Updated channels are
+// existing ones whose name, URL or group changed.
+type RefreshDiff struct {
+	Added         []int64      `json:"added"`
+	Removed       []ChannelRef `json:"removed"`
+	Updated       []int64      `json:"updated"`
+	RemovedGroups []GroupRef   `json:"removed_groups"`
+}
+
+// ChannelRef names a channel, such as one that no longer exists.
+type ChannelRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// GroupRef names a group, such as one that no longer exists.
+type GroupRef struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// ArchivedChannel is a channel a refresh archived because its playlist no
+// longer listed it (see StalePolicyArchive). Its favorite and hidden flags,
+// edits and headers are kept for when it comes back.
+type ArchivedChannel struct {
+	ID         int64     `json:"id"` // the channel's id, which it gets back
+	SourceID   int64     `json:"source_id"`
+	Name       string    `json:"name"` // the edited name, if any
+	URL        string    `json:"url"`
+	TvgID      *string   `json:"tvg_id,omitempty"`
+	Favorite   bool      `json:"favorite"`
+	Hidden     bool      `json:"hidden"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+// IngestPlan previews what refreshing a source would change, with up to a
+// few channels of each kind as samples.
+type IngestPlan struct {
+	SourceID     int64 `json:"source_id"`
+	ChannelCount int   `json:"channel_count"` // channels the source would have
+	Duplicates   int   `json:"duplicates_skipped"`
+	Added        int   `json:"added"`
+	Removed      int   `json:"removed"`
+	Modified     int   `json:"modified"`
+	Unchanged    int   `json:"unchanged"`
+
+	AddedSample    []PlannedChannel `json:"added_sample"`
+	RemovedSample  []ChannelRef     `json:"removed_sample"`
+	ModifiedSample []PlannedChange  `json:"modified_sample"`
+}
+
+// PlannedChannel is a playlist entry a refresh would add.
+type PlannedChannel struct {
+	Name  string  `json:"name"`
+	URL   string  `json:"url"`
+	Group *string `json:"group,omitempty"`
+}
+
+// PlannedChange is an existing channel a refresh would modify, with its new
+// values and the fields that change ("name", "url" or "group").
+type PlannedChange struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Group   *string  `json:"group,omitempty"`
+	Changed []string `json:"changed"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// Series is a TV series assembled from episode channels sharing a series name.
+type Series struct {
+	Name         string  `json:"name"`
+	EpisodeCount int     `json:"episode_count"`
+	SeasonCount  int     `json:"season_count"`
+	Image        *string `json:"image,omitempty"` // logo of one of the episodes
+}
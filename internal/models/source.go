@@ -1,16 +1,90 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // Source represents an IPTV source (e.g. one M3U URL).
 type Source struct {
-	ID          int64      `json:"id,omitempty"`
-	Name        string     `json:"name"`
-	URL         string     `json:"url,omitempty"`
-	SourceType  int16      `json:"source_type"`
-	UseTvgID    *bool      `json:"use_tvg_id,omitempty"`
-	UserAgent   string     `json:"user_agent,omitempty"`
-	Enabled     bool       `json:"enabled"`
-	LastUpdated *time.Time `json:"last_updated,omitempty"`
-	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	ID             int64             `json:"id,omitempty"`
+	Name           string            `json:"name"`
+	URL            string            `json:"url,omitempty"`
+	SourceType     int16             `json:"source_type"`
+	UseTvgID       *bool             `json:"use_tvg_id,omitempty"`
+	UserAgent      string            `json:"user_agent,omitempty"`
+	FetchHeaders   map[string]string `json:"fetch_headers,omitempty"` // extra headers for the playlist request
+	EPGURL         string            `json:"epg_url,omitempty"`
+	EPGURLManual   bool              `json:"epg_url_manual,omitempty"` // epg_url was set via the API, not the playlist
+	Enabled        bool              `json:"enabled"`
+	GuessMediaType bool              `json:"guess_media_type"`       // classify VOD by extension and group-title, not only Xtream paths
+	Dedupe         bool              `json:"dedupe"`                 // collapse playlist entries with the same URL
+	DeadPolicy     string            `json:"dead_channel_policy"`    // DeadPolicyKeep, DeadPolicyHide or DeadPolicyDelete
+	DeadThreshold  int               `json:"dead_channel_threshold"` // consecutive failed checks before DeadPolicy applies
+	StalePolicy    string            `json:"stale_policy"`           // StalePolicyDelete or StalePolicyArchive
+	WebhookURL     string            `json:"webhook_url,omitempty"`  // notified when a refresh ends
+	LastUpdated    *time.Time        `json:"last_updated,omitempty"`
+	CreatedAt      *time.Time        `json:"created_at,omitempty"`
+	ETag           string            `json:"etag,omitempty"`           // ETag of the last ingested playlist
+	LastModified   string            `json:"last_modified,omitempty"`  // Last-Modified of the last ingested playlist
+	ContentHash    string            `json:"content_hash,omitempty"`   // SHA-256 of the last ingested playlist
+	ParserVersion  int               `json:"parser_version,omitempty"` // fetcher.ParserVersion of the last ingest
+
+	LastEmbeddingRun *EmbeddingRun `json:"last_embedding_run,omitempty"`
+
+	// Set by ListSources only; hidden channels are counted.
+	ChannelCount *int `json:"channel_count,omitempty"`
+	GroupCount   *int `json:"group_count,omitempty"`
+}
+
+// EmbeddingRun summarises a completed embedding pass over a source's
+// channels.
+type EmbeddingRun struct {
+	Embedded   int       `json:"embedded"` // channels sent to the embedding API
+	Skipped    int       `json:"skipped"`  // channels whose embedding text was unchanged
+	Tokens     int64     `json:"tokens"`   // tokens billed by the embedding API
+	Model      string    `json:"model"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// RedactedHeaderValue replaces the value of secret fetch headers in API
+// responses.
+const RedactedHeaderValue = "********"
+
+// Redacted returns a copy of s whose secret fetch header values (tokens,
+// cookies, credentials) are replaced with RedactedHeaderValue.
+func (s Source) Redacted() Source {
+	s.FetchHeaders = RedactHeaders(s.FetchHeaders)
+	return s
+}
+
+// RedactHeaders returns a copy of h with the values of secret headers masked,
+// for API responses and log lines.
+func RedactHeaders(h map[string]string) map[string]string {
+	if h == nil {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if IsSecretHeader(k) {
+			v = RedactedHeaderValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// secretHeaderWords mark a header name as carrying a credential.
+var secretHeaderWords = []string{"auth", "token", "key", "secret", "cookie", "password", "session", "signature"}
+
+// IsSecretHeader reports whether the header called name likely carries a
+// credential, e.g. Authorization, Cookie or X-Api-Key.
+func IsSecretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range secretHeaderWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,22 @@
+package models
+
+// Stats are totals over every source, for dashboards. Hidden channels are
+// counted.
+type Stats struct {
+	Sources     int            `json:"sources"`
+	Groups      int            `json:"groups"`
+	Channels    int            `json:"channels"`
+	ByMediaType map[string]int `json:"channels_by_media_type"` // by media type name
+	Favorites   int            `json:"favorites"`
+	Embedded    int            `json:"embedded"` // channels with an embedding, of any model
+}
+
+// NewStats returns Stats with every media type present in ByMediaType, so
+// that one without channels reads as 0.
+func NewStats() Stats {
+	s := Stats{ByMediaType: make(map[string]int, len(mediaTypeNames))}
+	for _, name := range mediaTypeNames {
+		s.ByMediaType[name] = 0
+	}
+	return s
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// WatchEvent is one viewing session of a channel and where playback was
+// when the player last reported it.
+type WatchEvent struct {
+	ID        int64      `json:"id,omitempty"`
+	ChannelID int64      `json:"channel_id"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // last position report
+	Position  int        `json:"position"`             // seconds from the start
+	Duration  *int       `json:"duration,omitempty"`   // seconds; unknown for live streams
+	Finished  bool       `json:"finished"`             // position reached 95% of duration
+}
+
+// WatchHistoryEntry is a recently watched channel with its latest session.
+type WatchHistoryEntry struct {
+	Channel Channel    `json:"channel"`
+	Watch   WatchEvent `json:"watch"`
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// handleReindexEmbeddings queues a rebuild of the embedding index. Building
+// it over a large table takes minutes, so the progress is reported on the
+// returned job.
+func (s *Server) handleReindexEmbeddings(w http.ResponseWriter, r *http.Request) {
+	job := cache.Job{ID: jobs.NewID(), Kind: cache.JobReindex}
+	if err := s.queueJob(r.Context(), job); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id": job.ID,
+		"state":  jobs.StateQueued,
+	})
+}
+
+// handleDeadJobs lists queued jobs that failed on every attempt, newest
+// first. They stay on the dead-letter list for inspection; re-run one by
+// triggering the same refresh or check again.
+func (s *Server) handleDeadJobs(w http.ResponseWriter, r *http.Request) {
+	if s.redis == nil {
+		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("job queue not configured (REDIS_URL not set)"))
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+
+	dead, err := cache.DeadJobs(r.Context(), s.redis, cache.DefaultQueue, limit)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range dead {
+		dead[i].Headers = models.RedactHeaders(dead[i].Headers)
+	}
+	writeJSON(w, http.StatusOK, dead)
+}
+
+// handleFlushCache drops everything the store cached, for when cached data
+// is suspected to be stale.
+func (s *Server) handleFlushCache(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := s.store.(store.CacheFlusher)
+	if !ok {
+		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("caching not enabled"))
+		return
+	}
+	if err := flusher.FlushCache(r.Context()); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "cache flushed")
+	writeNoContent(w)
+}
+
+// handleCacheStats reports the hits, misses, fill errors and invalidations
+// of each kind of cache entry since the server started, and with keys=true
+// how many entries of each kind are cached now, which walks the keyspace.
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	inspector, ok := s.store.(store.CacheInspector)
+	if !ok {
+		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("caching not enabled"))
+		return
+	}
+	countKeys := false
+	if v := r.URL.Query().Get("keys"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid keys: %s", v))
+			return
+		}
+		countKeys = b
+	}
+
+	stats := inspector.CacheStats()
+	if countKeys {
+		keys, err := inspector.CountCacheKeys(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		for entity, n := range keys {
+			st := stats[entity]
+			st.Keys = &n
+			stats[entity] = st
+		}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// SetSlowQueryTracer lets the slow query endpoints show and switch t.
+func (s *Server) SetSlowQueryTracer(t *store.SlowQueryTracer) {
+	s.tracer = t
+}
+
+// slowQuerySettings is the body of the slow query endpoints.
+type slowQuerySettings struct {
+	Enabled   bool   `json:"enabled"`
+	Threshold string `json:"threshold"`
+}
+
+// handleGetSlowQueries reports whether slow queries are logged, and from
+// which duration.
+func (s *Server) handleGetSlowQueries(w http.ResponseWriter, r *http.Request) {
+	if s.tracer == nil {
+		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("slow query logging not available"))
+		return
+	}
+	writeJSON(w, http.StatusOK, slowQuerySettings{Enabled: s.tracer.Enabled(), Threshold: s.tracer.Threshold().String()})
+}
+
+// handlePatchSlowQueries switches slow query logging and sets its
+// threshold without a restart. The change lasts until the process exits.
+func (s *Server) handlePatchSlowQueries(w http.ResponseWriter, r *http.Request) {
+	if s.tracer == nil {
+		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("slow query logging not available"))
+		return
+	}
+	var req struct {
+		Enabled   *bool   `json:"enabled"`
+		Threshold *string `json:"threshold"`
+	}
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	if req.Threshold != nil {
+		d, err := time.ParseDuration(*req.Threshold)
+		if err != nil || d <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid threshold: %q (want a positive duration such as 100ms)", *req.Threshold))
+			return
+		}
+		s.tracer.SetThreshold(d)
+	}
+	if req.Enabled != nil {
+		s.tracer.SetEnabled(*req.Enabled)
+	}
+	slog.InfoContext(r.Context(), "slow query logging changed", "enabled", s.tracer.Enabled(), "threshold", s.tracer.Threshold())
+	writeJSON(w, http.StatusOK, slowQuerySettings{Enabled: s.tracer.Enabled(), Threshold: s.tracer.Threshold().String()})
+}
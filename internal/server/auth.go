@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// authExempt matches the /api paths served without a token: the health
+// checks and the docs.
+var authExempt = regexp.MustCompile(`^/api/(health(/live|/ready)?|docs(/openapi\.yaml)?)$`)
+
+// signedPath matches the channel URLs that accept a sig query parameter in
+// place of a bearer token, for HDHomeRun and Xtream clients that cannot send
+// an Authorization header.
+var signedPath = regexp.MustCompile(`^/api/channels/(\d+)/(stream|logo)$`)
+
+// hdhrPath matches the HDHomeRun routes. They are outside /api but hand out
+// signed stream URLs, so they need a token like the API does.
+var hdhrPath = regexp.MustCompile(`^/(discover|lineup_status|lineup)\.json$`)
+
+// withAuth requires a bearer token on /api and HDHomeRun routes when API
+// tokens are configured. Read-write tokens allow every method; read-only tokens allow
+// GET and HEAD only. A missing or unknown token gets 401, a read-only token
+// on any other method 403. Channel stream and logo URLs may carry a valid
+// sig parameter instead (see channelURL).
+func (s *Server) withAuth(next http.Handler) http.Handler {
+	if len(s.cfg.APITokens) == 0 && len(s.cfg.APIReadTokens) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected := strings.HasPrefix(r.URL.Path, "/api/") || hdhrPath.MatchString(r.URL.Path)
+		if !protected || authExempt.MatchString(r.URL.Path) || s.validSig(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		switch {
+		case !ok:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="popcornvault"`)
+			writeErr(w, http.StatusUnauthorized, errors.New("missing bearer token"))
+			return
+		case matchToken(s.cfg.APITokens, token):
+		case matchToken(s.cfg.APIReadTokens, token):
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				writeErr(w, http.StatusForbidden, errors.New("read-only token"))
+				return
+			}
+		default:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="popcornvault", error="invalid_token"`)
+			writeErr(w, http.StatusUnauthorized, errors.New("invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// matchToken reports whether token is in tokens, comparing in constant time.
+func matchToken(tokens []string, token string) bool {
+	found := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// channelURL returns the absolute URL of a channel's stream or logo route
+// (kind "stream" or "logo"). When API tokens are configured it carries a sig
+// parameter signed with the first token, so that clients which cannot send
+// an Authorization header can still open it.
+func (s *Server) channelURL(base string, id int64, kind string) string {
+	u := fmt.Sprintf("%s/api/channels/%d/%s", base, id, kind)
+	if key := s.signingKey(); key != "" {
+		u += "?sig=" + channelSig(key, id, kind)
+	}
+	return u
+}
+
+// signingKey returns the token channel URLs are signed with: the first
+// read-write token, else the first read-only one, else "".
+func (s *Server) signingKey() string {
+	if len(s.cfg.APITokens) > 0 {
+		return s.cfg.APITokens[0]
+	}
+	if len(s.cfg.APIReadTokens) > 0 {
+		return s.cfg.APIReadTokens[0]
+	}
+	return ""
+}
+
+// validSig reports whether r is a GET or HEAD of a channel stream or logo
+// URL whose sig parameter was signed with any configured token, so URLs
+// handed out before a token rotation keep working while the old token is
+// still listed.
+func (s *Server) validSig(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	m := signedPath.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return false
+	}
+	sig := r.URL.Query().Get("sig")
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	if sig == "" || err != nil {
+		return false
+	}
+	found := false
+	for _, tokens := range [][]string{s.cfg.APITokens, s.cfg.APIReadTokens} {
+		for _, t := range tokens {
+			if hmac.Equal([]byte(channelSig(t, id, m[2])), []byte(sig)) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// channelSig is the HMAC-SHA256 of the channel id and URL kind keyed on
+// token, base64url-encoded.
+func channelSig(token string, id int64, kind string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s:%d", kind, id)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/config"
+)
+
+func withTokens(cfg *config.Config) {
+	cfg.APITokens = []string{"rw-secret"}
+	cfg.APIReadTokens = []string{"ro-secret"}
+	cfg.HDHREnabled = true
+}
+
+func TestAuth(t *testing.T) {
+	srv, _ := newTestServer(t, withTokens)
+	rw := []string{"Authorization", "Bearer rw-secret"}
+
+	tests := []struct {
+		name, method, target, body string
+		header                     []string
+		status                     int
+	}{
+		{"missing token", "GET", "/api/sources", "", nil, http.StatusUnauthorized},
+		{"wrong token", "GET", "/api/sources", "", []string{"Authorization", "Bearer nope"}, http.StatusUnauthorized},
+		{"not bearer", "GET", "/api/sources", "", []string{"Authorization", "Basic cnctc2VjcmV0"}, http.StatusUnauthorized},
+		{"read-only token on GET", "GET", "/api/sources", "", []string{"Authorization", "Bearer ro-secret"}, http.StatusOK},
+		{"read-only token on POST", "POST", "/api/sources", `{"type":"custom","name":"A"}`, []string{"Authorization", "Bearer ro-secret"}, http.StatusForbidden},
+		{"valid token", "POST", "/api/sources", `{"type":"custom","name":"A"}`, rw, http.StatusCreated},
+		{"health is exempt", "GET", "/api/health", "", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, srv, tt.method, tt.target, tt.body, tt.header...)
+			if tt.status >= 400 {
+				wantAPIError(t, w, tt.status)
+			} else {
+				wantStatus(t, w, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestAuthSignedChannelURLs(t *testing.T) {
+	srv, _ := newTestServer(t, withTokens)
+	rw := []string{"Authorization", "Bearer rw-secret"}
+	w := request(t, srv, "POST", "/api/sources", `{"type":"custom","name":"A"}`, rw...)
+	wantStatus(t, w, http.StatusCreated)
+	src := decode[struct{ ID int64 }](t, w).ID
+	w = request(t, srv, "POST", fmt.Sprintf("/api/sources/%d/channels", src), `{"name":"BBC One","url":"http://example.com/bbc"}`, rw...)
+	wantStatus(t, w, http.StatusCreated)
+	id := decode[struct{ ID int64 }](t, w).ID
+
+	stream := fmt.Sprintf("/api/channels/%d/stream", id)
+	logo := fmt.Sprintf("/api/channels/%d/logo", id)
+	wantAPIError(t, request(t, srv, "GET", stream, ""), http.StatusUnauthorized)
+	wantAPIError(t, request(t, srv, "GET", logo, ""), http.StatusUnauthorized)
+	wantAPIError(t, request(t, srv, "GET", stream+"?sig=bogus", ""), http.StatusUnauthorized)
+	wantAPIError(t, request(t, srv, "GET", stream+"?sig="+channelSig("other", id, "stream"), ""), http.StatusUnauthorized)
+	// A signature for the logo, or another channel, does not open the stream.
+	wantAPIError(t, request(t, srv, "GET", stream+"?sig="+channelSig("rw-secret", id, "logo"), ""), http.StatusUnauthorized)
+	wantAPIError(t, request(t, srv, "GET", stream+"?sig="+channelSig("rw-secret", id+1, "stream"), ""), http.StatusUnauthorized)
+
+	w = request(t, srv, "GET", stream, "", rw...)
+	wantStatus(t, w, http.StatusFound)
+
+	// Signatures made with either kind of configured token are accepted.
+	for _, token := range []string{"rw-secret", "ro-secret"} {
+		w = request(t, srv, "GET", stream+"?sig="+channelSig(token, id, "stream"), "")
+		wantStatus(t, w, http.StatusFound)
+		if loc := w.Header().Get("Location"); loc != "http://example.com/bbc" {
+			t.Errorf("Location = %q", loc)
+		}
+	}
+	// The channel has no logo, so getting past auth means a 404.
+	wantAPIError(t, request(t, srv, "GET", logo+"?sig="+channelSig("ro-secret", id, "logo"), ""), http.StatusNotFound)
+
+	// The HDHomeRun routes need a token, since the lineup hands out signed
+	// stream URLs that work without one.
+	for _, path := range []string{"/discover.json", "/lineup_status.json", "/lineup.json"} {
+		wantAPIError(t, request(t, srv, "GET", path, ""), http.StatusUnauthorized)
+		wantAPIError(t, request(t, srv, "GET", path, "", "Authorization", "Bearer nope"), http.StatusUnauthorized)
+	}
+	w = request(t, srv, "GET", "/lineup.json", "", "Authorization", "Bearer ro-secret")
+	wantStatus(t, w, http.StatusOK)
+	lineup := decode[[]hdhrLineupEntry](t, w)
+	if len(lineup) != 1 {
+		t.Fatalf("lineup = %+v, want one entry", lineup)
+	}
+	u, err := url.Parse(lineup[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("sig") == "" {
+		t.Fatalf("lineup URL %q is not signed", lineup[0].URL)
+	}
+	wantStatus(t, request(t, srv, "GET", u.RequestURI(), ""), http.StatusFound)
+}
+
+func TestHDHRLineupWithoutAuth(t *testing.T) {
+	srv, _ := newTestServer(t, func(cfg *config.Config) { cfg.HDHREnabled = true })
+	src := addCustomSource(t, srv, "A")
+	addChannel(t, srv, src.ID, "BBC One", "")
+
+	w := request(t, srv, "GET", "/lineup.json", "")
+	wantStatus(t, w, http.StatusOK)
+	lineup := decode[[]hdhrLineupEntry](t, w)
+	if len(lineup) != 1 {
+		t.Fatalf("lineup = %+v, want one entry", lineup)
+	}
+	u, err := url.Parse(lineup[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.RawQuery != "" {
+		t.Fatalf("lineup URL %q is signed without API tokens", lineup[0].URL)
+	}
+	wantStatus(t, request(t, srv, "GET", u.RequestURI(), ""), http.StatusFound)
+}
+
+// TestXtreamAuth checks that Xtream logins and stream URLs take an API token
+// of either kind as the password, whatever the username.
+func TestXtreamAuth(t *testing.T) {
+	srv, _ := newTestServer(t, withTokens)
+	rw := []string{"Authorization", "Bearer rw-secret"}
+	w := request(t, srv, "POST", "/api/sources", `{"type":"custom","name":"A"}`, rw...)
+	wantStatus(t, w, http.StatusCreated)
+	src := decode[struct{ ID int64 }](t, w).ID
+	w = request(t, srv, "POST", fmt.Sprintf("/api/sources/%d/channels", src), `{"name":"BBC One","url":"http://example.com/bbc"}`, rw...)
+	wantStatus(t, w, http.StatusCreated)
+	id := decode[struct{ ID int64 }](t, w).ID
+
+	type login struct {
+		UserInfo struct {
+			Auth     int    `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"user_info"`
+	}
+	tests := []struct {
+		name, user, pass string
+		ok               bool
+	}{
+		{"read-write token", "tivimate", "rw-secret", true},
+		{"read-only token", "anyone", "ro-secret", true},
+		{"token as username", "rw-secret", "nope", false},
+		{"wrong password", "tivimate", "nope", false},
+		{"empty password", "tivimate", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{"username": {tt.user}, "password": {tt.pass}}
+			w := request(t, srv, "GET", "/player_api.php?"+q.Encode(), "")
+			wantStatus(t, w, http.StatusOK)
+			info := decode[login](t, w).UserInfo
+			if (info.Auth == 1) != tt.ok {
+				t.Fatalf("auth = %d, want logged in %t", info.Auth, tt.ok)
+			}
+			if tt.ok && (info.Username != tt.user || info.Password != tt.pass) {
+				t.Errorf("user_info echoes %q/%q, want %q/%q", info.Username, info.Password, tt.user, tt.pass)
+			}
+			if tt.pass == "" {
+				return // no stream URL has an empty path segment
+			}
+
+			w = request(t, srv, "GET", fmt.Sprintf("/live/%s/%s/%d.ts", url.PathEscape(tt.user), url.PathEscape(tt.pass), id), "")
+			if !tt.ok {
+				wantAPIError(t, w, http.StatusUnauthorized)
+				return
+			}
+			wantStatus(t, w, http.StatusFound)
+			if loc := w.Header().Get("Location"); loc != "http://example.com/bbc" {
+				t.Errorf("Location = %q", loc)
+			}
+		})
+	}
+}
+
+// TestXtreamWithoutAuth checks that without API tokens, when the API is
+// open, any credentials log in.
+func TestXtreamWithoutAuth(t *testing.T) {
+	srv, _ := newTestServer(t)
+	w := request(t, srv, "GET", "/player_api.php?username=a&password=b", "")
+	wantStatus(t, w, http.StatusOK)
+	if auth := decode[struct {
+		UserInfo struct{ Auth int } `json:"user_info"`
+	}](t, w).UserInfo.Auth; auth != 1 {
+		t.Errorf("auth = %d, want 1", auth)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// handleCheckSource queues a health check of every channel of a source.
+// Checking tens of thousands of streams takes a while, so the client follows
+// the job with GET /api/jobs/{id} and then filters channels by status.
+func (s *Server) handleCheckSource(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	src, err := s.store.GetSourceByID(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Providers often block unknown clients, so CHECK_USER_AGENT can be set
+	// to a player's user agent for checks only.
+	userAgent := s.cfg.CheckUserAgent
+	if userAgent == "" {
+		userAgent = src.UserAgent
+	}
+	if userAgent == "" {
+		userAgent = s.cfg.UserAgent
+	}
+
+	job := cache.Job{
+		ID:         jobs.NewID(),
+		Kind:       cache.JobCheck,
+		SourceID:   sourceID,
+		SourceName: src.Name,
+		UserAgent:  userAgent,
+
+		DeadPolicy:    src.DeadPolicy,
+		DeadThreshold: src.DeadThreshold,
+	}
+	if err := s.queueJob(r.Context(), job); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id":    job.ID,
+		"state":     jobs.StateQueued,
+		"source_id": sourceID,
+	})
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// BenchmarkCompressChannelList serves a page of 200 channels with logos and
+// group names, as a client lists them, with and without gzip, and reports
+// the bytes sent and the compressed size as a share of the raw one.
+func BenchmarkCompressChannelList(b *testing.B) {
+	srv, _ := newTestServer(b)
+	src := addCustomSource(b, srv, "A")
+	for i := 0; i < 200; i++ {
+		addChannel(b, srv, src.ID, fmt.Sprintf("Channel %d HD", i),
+			fmt.Sprintf(`,"group":"Group %d","image":"https://logos.example.com/channels/%d/logo-dark.png"`, i%20, i))
+	}
+	h := withCompression(srv)
+	target := fmt.Sprintf("/api/channels?source_id=%d&limit=200", src.ID)
+
+	var raw int
+	for _, encoding := range []string{"identity", "gzip"} {
+		b.Run(encoding, func(b *testing.B) {
+			var size int
+			for i := 0; i < b.N; i++ {
+				w := request(b, h, "GET", target, "", "Accept-Encoding", encoding)
+				if w.Code != http.StatusOK {
+					b.Fatalf("status = %d", w.Code)
+				}
+				size = w.Body.Len()
+				if encoding == "gzip" {
+					if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+						b.Fatalf("Content-Encoding = %q, want gzip", got)
+					}
+					gz, err := gzip.NewReader(w.Body)
+					if err != nil {
+						b.Fatal(err)
+					}
+					n, err := io.Copy(io.Discard, gz)
+					if err != nil || int(n) != raw {
+						b.Fatalf("decompressed %d bytes (%v), want %d", n, err, raw)
+					}
+				}
+			}
+			b.ReportMetric(float64(size), "bytes/resp")
+			if encoding == "identity" {
+				raw = size
+			} else {
+				b.ReportMetric(100*float64(size)/float64(raw), "%-of-raw")
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/config"
+)
+
+// decodeJSON decodes the JSON request body into v, returning the status to
+// answer with when it cannot: 415 for a Content-Type other than JSON, 413
+// for a body over the configured limit, and 400 for malformed JSON, a field
+// v does not have, a value of the wrong type or data after the object. A
+// request without a Content-Type is read as JSON.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v any) (int, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			return http.StatusUnsupportedMediaType, fmt.Errorf("content type %q: want application/json", ct)
+		}
+	}
+
+	limit := s.cfg.MaxRequestBytes
+	if limit <= 0 {
+		limit = config.DefaultMaxRequestBytes
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		// A single value is expected; anything after it is a client bug.
+		var extra json.RawMessage
+		if err = dec.Decode(&extra); err == io.EOF {
+			return 0, nil
+		}
+		var maxErr *http.MaxBytesError
+		if !errors.As(err, &maxErr) {
+			err = errors.New("unexpected data after the JSON value")
+		}
+	}
+
+	var (
+		maxErr    *http.MaxBytesError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("request body larger than %d bytes", maxErr.Limit)
+	case errors.Is(err, io.EOF):
+		return http.StatusBadRequest, errors.New("request body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadRequest, errors.New("invalid JSON: unexpected end of body")
+	case errors.As(err, &syntaxErr):
+		return http.StatusBadRequest, fmt.Errorf("invalid JSON at offset %d: %w", syntaxErr.Offset, err)
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return http.StatusBadRequest, fmt.Errorf("field %q: want %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields.
+		return http.StatusBadRequest, errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	default:
+		return http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err)
+	}
+}
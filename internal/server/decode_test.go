@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/config"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	srv, _ := newTestServer(t, func(cfg *config.Config) { cfg.MaxRequestBytes = 64 })
+
+	tests := []struct {
+		name, contentType, body string
+		status                  int
+		detail                  string // substring of the error
+	}{
+		{"valid", "application/json", `{"name":"a","count":1}`, 0, ""},
+		{"charset", "application/json; charset=utf-8", `{"name":"a"}`, 0, ""},
+		{"+json type", "application/merge-patch+json", `{"name":"a"}`, 0, ""},
+		{"no content type", "", `{"name":"a"}`, 0, ""},
+		{"unknown field", "application/json", `{"name":"a","nmae":"b"}`, http.StatusBadRequest, `unknown field "nmae"`},
+		{"over the limit", "application/json", `{"name":"` + strings.Repeat("a", 100) + `"}`, http.StatusRequestEntityTooLarge, "larger than 64 bytes"},
+		{"not JSON", "text/plain", `{"name":"a"}`, http.StatusUnsupportedMediaType, `content type "text/plain"`},
+		{"form", "application/x-www-form-urlencoded", `name=a`, http.StatusUnsupportedMediaType, "want application/json"},
+		{"trailing data", "application/json", `{"name":"a"} {"name":"b"}`, http.StatusBadRequest, "unexpected data after the JSON value"},
+		{"trailing garbage", "application/json", `{"name":"a"}x`, http.StatusBadRequest, "unexpected data after the JSON value"},
+		{"wrong type", "application/json", `{"count":"1"}`, http.StatusBadRequest, `field "count"`},
+		{"empty", "application/json", ``, http.StatusBadRequest, "request body is empty"},
+		{"truncated", "application/json", `{"name":`, http.StatusBadRequest, "unexpected end of body"},
+		{"syntax", "application/json", `{name}`, http.StatusBadRequest, "invalid JSON at offset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var v struct {
+				Name  string `json:"name"`
+				Count int    `json:"count"`
+			}
+			status, err := srv.decodeJSON(httptest.NewRecorder(), r, &v)
+			if status != tt.status {
+				t.Fatalf("status = %d (%v), want %d", status, err, tt.status)
+			}
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatalf("err = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.detail) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.detail)
+			}
+		})
+	}
+
+	// Handlers answer with the status and the error as the detail.
+	w := request(t, srv, "POST", "/api/sources", `{"type":"custom","name":"A","nmae":"b"}`)
+	if e := wantAPIError(t, w, http.StatusBadRequest); !strings.Contains(e.Detail, `unknown field "nmae"`) {
+		t.Fatalf("detail = %q", e.Detail)
+	}
+	w = request(t, srv, "POST", "/api/sources", `{"type":"custom","name":"A"}`, "Content-Type", "text/plain")
+	wantAPIError(t, w, http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// maxEPGWindow caps the from/to range of a guide request.
+const maxEPGWindow = 14 * 24 * time.Hour
+
+// --- EPG handlers ---
+
+// handleRefreshEPG queues a download of the source's XMLTV guide. Guides are
+// often hundreds of megabytes, so the work runs as a background job that the
+// client follows with GET /api/jobs/{id}.
+func (s *Server) handleRefreshEPG(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	src, err := s.store.GetSourceByID(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if src.EPGURL == "" {
+		writeErr(w, http.StatusConflict, fmt.Errorf("source %d has no EPG URL; set epg_url with PATCH /api/sources/%d", sourceID, sourceID))
+		return
+	}
+
+	userAgent := src.UserAgent
+	if userAgent == "" {
+		userAgent = s.cfg.UserAgent
+	}
+
+	job := cache.Job{
+		ID:         jobs.NewID(),
+		Kind:       cache.JobEPG,
+		SourceID:   sourceID,
+		SourceName: src.Name,
+		URL:        src.EPGURL,
+		UserAgent:  userAgent,
+	}
+	if err := s.queueJob(r.Context(), job); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id":    job.ID,
+		"state":     jobs.StateQueued,
+		"source_id": sourceID,
+	})
+}
+
+// handleChannelEPG returns the programmes of a channel that overlap the
+// from/to window (RFC 3339). The window defaults to the next 24 hours.
+func (s *Server) handleChannelEPG(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	q := r.URL.Query()
+	from := time.Now()
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse(time.RFC3339, v); err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid from: %s (use RFC 3339, e.g. 2024-01-02T15:04:05Z)", v))
+			return
+		}
+	}
+	to := from.Add(24 * time.Hour)
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid to: %s (use RFC 3339, e.g. 2024-01-02T15:04:05Z)", v))
+			return
+		}
+	}
+	if !to.After(from) {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("to must be after from"))
+		return
+	}
+	if to.Sub(from) > maxEPGWindow {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("window must not exceed %d days", int(maxEPGWindow.Hours()/24)))
+		return
+	}
+
+	if _, err := s.store.GetChannelByID(r.Context(), channelID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	programs, err := s.store.ListChannelEPG(r.Context(), channelID, from, to)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if programs == nil {
+		programs = []models.EPGProgram{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channel_id": channelID,
+		"from":       from,
+		"to":         to,
+		"programs":   programs,
+	})
+}
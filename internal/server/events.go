@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/jobs"
+)
+
+// eventsPollInterval is how often the refresh event stream re-reads the
+// source's latest job, which catches jobs running in another process and
+// keeps idle connections alive.
+const eventsPollInterval = 2 * time.Second
+
+// progressEvent is the data of a refresh event: the job status plus the
+// time since it started.
+type progressEvent struct {
+	*jobs.Status
+	ElapsedMS int64 `json:"elapsed_ms"`
+}
+
+// handleRefreshEvents streams the progress of a source's refresh as
+// Server-Sent Events: "progress" events while the ingest and embedding
+// phases run, then a final "done" or "error" event (failed or cancelled),
+// after which the stream ends. A client connecting after the job finished
+// gets the final event straight away.
+func (s *Server) handleRefreshEvents(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Subscribe before reading the record so no update falls in between.
+	updates, stop := jobs.Watch(sourceID)
+	defer stop()
+
+	st, err := s.jobs.Tracker.Latest(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("no refresh recorded for source %d", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// A large ingest runs far longer than the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeErr(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // no proxy buffering
+	skipCompression(w)
+	w.WriteHeader(http.StatusOK)
+
+	var last jobs.Status
+	// send writes st as an event and reports whether the stream should end.
+	send := func(st *jobs.Status) bool {
+		if st.ID == last.ID && st.State == last.State && st.Phase == last.Phase && st.Processed == last.Processed && st.Total == last.Total {
+			_, err := fmt.Fprint(w, ": keep-alive\n\n")
+			return err != nil || rc.Flush() != nil
+		}
+		last = *st
+
+		event := "progress"
+		switch st.State {
+		case jobs.StateDone:
+			event = "done"
+		case jobs.StateFailed, jobs.StateCancelled:
+			event = "error"
+		}
+		data, err := json.Marshal(progressEvent{Status: st, ElapsedMS: elapsed(st).Milliseconds()})
+		if err != nil {
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return true // client gone
+		}
+		return rc.Flush() != nil || st.State.Finished()
+	}
+
+	if send(st) {
+		return
+	}
+	ticker := time.NewTicker(eventsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case st := <-updates:
+			if send(&st) {
+				return
+			}
+		case <-ticker.C:
+			st, err := s.jobs.Tracker.Latest(r.Context(), sourceID)
+			if err != nil {
+				continue
+			}
+			if send(st) {
+				return
+			}
+		}
+	}
+}
+
+// elapsed returns how long the job has run, or ran if it has finished.
+func elapsed(st *jobs.Status) time.Duration {
+	start := st.CreatedAt
+	if st.StartedAt != nil {
+		start = *st.StartedAt
+	}
+	end := time.Now()
+	if st.FinishedAt != nil {
+		end = *st.FinishedAt
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// --- playlist export handlers ---
+
+// handleExportPlaylist streams every channel matching the /api/channels
+// filters (source_id, group_id, media_type, favorite, tvg_id, search) as M3U,
+// in the order selected by sort.
+func (s *Server) handleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Search = q.Get("search")
+	if filter.Sort, err = parseChannelSort(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.writePlaylist(r.Context(), w, filter, "popcornvault.m3u")
+}
+
+// handleExportSourcePlaylist streams one source's channels as M3U. The same
+// filters as handleExportPlaylist apply, except source_id comes from the path.
+func (s *Server) handleExportSourcePlaylist(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if _, err := s.store.GetSourceByID(r.Context(), sourceID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	q := r.URL.Query()
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Search = q.Get("search")
+	if filter.Sort, err = parseChannelSort(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.SourceID = &sourceID
+
+	s.writePlaylist(r.Context(), w, filter, fmt.Sprintf("source-%d.m3u", sourceID))
+}
+
+// handleExportFavorites streams the favorite channels of every source as
+// M3U, with the filters of /api/favorites.
+func (s *Server) handleExportFavorites(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFavoritesFilter(r.URL.Query())
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writePlaylist(r.Context(), w, filter, "favorites.m3u")
+}
+
+// writePlaylist streams the channels matching filter as an M3U document.
+// Rows are written as they are read from the store, so memory use does not
+// grow with the playlist size. Once the first byte is sent an error can no
+// longer change the status code, so later failures are only logged.
+func (s *Server) writePlaylist(ctx context.Context, w http.ResponseWriter, filter store.ChannelFilter, filename string) {
+	w.Header().Set("Content-Type", "application/x-mpegurl")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	// Players read the playlist as it streams in, and some of them ignore
+	// Content-Encoding, so it is always sent as is.
+	skipCompression(w)
+	w.WriteHeader(http.StatusOK)
+
+	mw := fetcher.NewM3UWriter(w)
+	if err := mw.WriteHeader(); err != nil {
+		return
+	}
+	err := s.store.StreamChannels(ctx, filter, func(ch *models.Channel, h *models.ChannelHttpHeaders) error {
+		return mw.WriteEntry(ch, h)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "playlist export", "err", err)
+		return
+	}
+	if err := mw.Flush(); err != nil {
+		slog.WarnContext(ctx, "playlist export: flush", "err", err)
+	}
+}
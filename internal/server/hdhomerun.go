@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// --- HDHomeRun emulation ---
+//
+// Plex and Jellyfin can use an HDHomeRun network tuner as a live TV source.
+// Answering the tuner's discovery and lineup requests lets them add this
+// server as one; the channels are the live channels matching the HDHR_*
+// filters in the config. With API tokens configured, withAuth requires one
+// on these routes too.
+
+// hdhrDiscover is the device description returned by /discover.json.
+type hdhrDiscover struct {
+	FriendlyName    string
+	Manufacturer    string
+	ModelNumber     string
+	FirmwareName    string
+	FirmwareVersion string
+	DeviceID        string
+	DeviceAuth      string
+	BaseURL         string
+	LineupURL       string
+	TunerCount      int
+}
+
+// hdhrLineupEntry is one channel in /lineup.json.
+type hdhrLineupEntry struct {
+	GuideNumber string
+	GuideName   string
+	URL         string
+	HD          int `json:",omitempty"`
+}
+
+func (s *Server) handleHDHRDiscover(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	writeJSON(w, http.StatusOK, hdhrDiscover{
+		FriendlyName:    "PopcornVault",
+		Manufacturer:    "Silicondust",
+		ModelNumber:     "HDTC-2US",
+		FirmwareName:    "hdhomeruntc_atsc",
+		FirmwareVersion: "20200101",
+		DeviceID:        s.cfg.HDHRDeviceID,
+		DeviceAuth:      "popcornvault",
+		BaseURL:         base,
+		LineupURL:       base + "/lineup.json",
+		TunerCount:      s.cfg.HDHRTunerCount,
+	})
+}
+
+// handleHDHRLineupStatus reports that no channel scan is running; the lineup
+// is always current.
+func (s *Server) handleHDHRLineupStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ScanInProgress": 0,
+		"ScanPossible":   1,
+		"Source":         "Cable",
+		"SourceList":     []string{"Cable"},
+	})
+}
+
+// handleHDHRLineup lists the live channels matching the HDHR_* filters in
+// channel number order. Guide numbers are the tvg-chno where present and
+// the channel id otherwise, and stream URLs go through
+// /api/channels/{id}/stream so they survive playlist refreshes, signed when
+// API tokens are configured.
+func (s *Server) handleHDHRLineup(w http.ResponseWriter, r *http.Request) {
+	live := models.MediaTypeLivestream
+	filter := store.ChannelFilter{MediaType: &live, Sort: store.SortNumber}
+	if s.cfg.HDHRFavoritesOnly {
+		fav := true
+		filter.Favorite = &fav
+	}
+	if s.cfg.HDHRSourceID != 0 {
+		filter.SourceID = &s.cfg.HDHRSourceID
+	}
+	if s.cfg.HDHRGroupID != 0 {
+		filter.GroupID = &s.cfg.HDHRGroupID
+	}
+
+	base := baseURL(r)
+	lineup := []hdhrLineupEntry{}
+	err := s.store.StreamChannels(r.Context(), filter, func(ch *models.Channel, _ *models.ChannelHttpHeaders) error {
+		entry := hdhrLineupEntry{
+			GuideNumber: strconv.FormatInt(ch.ID, 10),
+			GuideName:   ch.Name,
+			URL:         s.channelURL(base, ch.ID, "stream"),
+		}
+		if ch.Number != nil {
+			entry.GuideNumber = strconv.Itoa(*ch.Number)
+		}
+		if ch.DisplayName != nil {
+			entry.GuideName = *ch.DisplayName
+		}
+		if ch.Quality != nil && *ch.Quality != models.QualitySD {
+			entry.HD = 1
+		}
+		lineup = append(lineup, entry)
+		return nil
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lineup)
+}
+
+// handleChannelStream redirects to the channel's current stream URL. Players
+// that keep a channel list (such as Plex through the HDHomeRun lineup) can
+// store this URL, which stays valid when a refresh changes the upstream one.
+func (s *Server) handleChannelStream(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	s.redirectToStream(w, r, channelID)
+}
+
+// redirectToStream redirects to the current stream URL of the channel.
+func (s *Server) redirectToStream(w http.ResponseWriter, r *http.Request, channelID int64) {
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	http.Redirect(w, r, ch.URL, http.StatusFound)
+}
+
+// baseURL returns the scheme and host the client used to reach the server,
+// honouring X-Forwarded-Proto from a reverse proxy.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p == "http" || p == "https" {
+		scheme = p
+	}
+	return scheme + "://" + r.Host
+}
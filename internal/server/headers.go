@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// forbiddenFetchHeaders are headers a source may not set: hop-by-hop headers
+// (RFC 9110 §7.6.1) and the ones the fetcher manages itself.
+var forbiddenFetchHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Host":                true,
+	"Content-Length":      true,
+	"Accept-Encoding":     true,
+	"If-None-Match":       true,
+	"If-Modified-Since":   true,
+}
+
+// maxFetchHeaders bounds how many extra headers a source can carry.
+const maxFetchHeaders = 20
+
+// validateFetchHeaders checks the extra playlist request headers of a source
+// and returns them keyed by canonical header name.
+func validateFetchHeaders(h map[string]string) (map[string]string, error) {
+	if len(h) > maxFetchHeaders {
+		return nil, fmt.Errorf("fetch_headers: at most %d headers are allowed", maxFetchHeaders)
+	}
+	out := make(map[string]string, len(h))
+	for name, value := range h {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("fetch_headers: invalid header name %q", name)
+		}
+		canon := http.CanonicalHeaderKey(name)
+		if forbiddenFetchHeaders[canon] {
+			return nil, fmt.Errorf("fetch_headers: header %q cannot be set", canon)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, fmt.Errorf("fetch_headers: invalid value for header %q", canon)
+		}
+		if _, dup := out[canon]; dup {
+			return nil, fmt.Errorf("fetch_headers: header %q given more than once", canon)
+		}
+		out[canon] = value
+	}
+	return out, nil
+}
+
+// mergeRedactedHeaders fills in values a client echoed back masked (as
+// returned by GET) from the currently stored headers, so a source can be
+// edited without re-entering its secrets.
+func mergeRedactedHeaders(next, current map[string]string) map[string]string {
+	for k, v := range next {
+		if v == models.RedactedHeaderValue {
+			if cur, ok := current[k]; ok {
+				next[k] = cur
+			}
+		}
+	}
+	return next
+}
+
+// validHeaderName reports whether name is an RFC 9110 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// channelHeadersRequest holds the HTTP headers a player must send for a
+// channel's stream.
+type channelHeadersRequest struct {
+	Referrer   string `json:"referrer"`
+	UserAgent  string `json:"user_agent"`
+	HTTPOrigin string `json:"http_origin"`
+	IgnoreSSL  bool   `json:"ignore_ssl"`
+}
+
+// headers validates the request and returns it as stored headers; empty
+// values are left unset.
+func (req channelHeadersRequest) headers() (*models.ChannelHttpHeaders, error) {
+	h := &models.ChannelHttpHeaders{IgnoreSSL: &req.IgnoreSSL}
+	for _, f := range []struct {
+		name  string
+		value string
+		dst   **string
+	}{
+		{"referrer", req.Referrer, &h.Referrer},
+		{"user_agent", req.UserAgent, &h.UserAgent},
+		{"http_origin", req.HTTPOrigin, &h.HTTPOrigin},
+	} {
+		if strings.ContainsAny(f.value, "\r\n\x00") {
+			return nil, fmt.Errorf("headers: invalid value for %s", f.name)
+		}
+		if v := strings.TrimSpace(f.value); v != "" {
+			*f.dst = &v
+		}
+	}
+	return h, nil
+}
+
+// handleSetChannelHeaders replaces the HTTP headers of a channel. They are
+// marked edited, so refreshes keep them instead of the playlist's, and are
+// sent by the stream proxy and written to M3U exports as #EXTVLCOPT lines.
+func (s *Server) handleSetChannelHeaders(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req channelHeadersRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	h, err := req.headers()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	h.Edited = true
+
+	err = s.store.UpsertChannelHeaders(r.Context(), channelID, h)
+	var ch *models.Channel
+	if err == nil {
+		ch, err = s.store.GetChannelByID(r.Context(), channelID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ch)
+}
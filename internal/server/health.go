@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readyCheckTimeout bounds each dependency check of the readiness probe.
+const readyCheckTimeout = 2 * time.Second
+
+// dependencyStatus is one entry of the readiness report.
+type dependencyStatus struct {
+	Status    string  `json:"status"` // "up", "down", or "degraded" for an optional dependency that is down
+	LatencyMS float64 `json:"latency_ms"`
+	Version   int64   `json:"version,omitempty"` // migrations only
+	Error     string  `json:"error,omitempty"`
+}
+
+// readyResponse is the body of GET /api/health/ready.
+type readyResponse struct {
+	Status       string                      `json:"status"` // "ready", "degraded" or "unavailable"
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+}
+
+// handleReady reports whether the server can serve traffic: Postgres must
+// answer and the schema must not be left dirty by a failed migration. It
+// returns 503 when either check fails, so a load balancer or Kubernetes
+// stops routing to the instance. Redis is optional, as reads then go to
+// Postgres and jobs run in-process: when it is down the report says
+// "degraded" with a 200.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) (int64, error){
+		"postgres": func(ctx context.Context) (int64, error) {
+			return 0, s.store.Ping(ctx)
+		},
+		"migrations": func(ctx context.Context) (int64, error) {
+			version, dirty, err := s.store.MigrationVersion(ctx)
+			if err == nil && dirty {
+				err = fmt.Errorf("migration %d failed and left the schema dirty", version)
+			}
+			return version, err
+		},
+	}
+	if s.redis != nil {
+		checks["redis"] = func(ctx context.Context) (int64, error) {
+			return 0, s.redis.Ping(ctx)
+		}
+	}
+	optional := map[string]bool{"redis": true}
+
+	resp := readyResponse{Status: "ready", Dependencies: make(map[string]dependencyStatus, len(checks))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			version, err := check(ctx)
+			dep := dependencyStatus{
+				Status:    "up",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Version:   version,
+			}
+			if err != nil {
+				dep.Status = "down"
+				if optional[name] {
+					dep.Status = "degraded"
+				}
+				dep.Error = err.Error()
+			}
+			mu.Lock()
+			resp.Dependencies[name] = dep
+			switch {
+			case err == nil:
+			case !optional[name]:
+				resp.Status = "unavailable"
+			case resp.Status == "ready":
+				resp.Status = "degraded"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
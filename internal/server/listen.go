@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// unixPrefix marks a SERVER_LISTEN address as a unix socket path.
+const unixPrefix = "unix://"
+
+// listener is one address the server accepts connections on.
+type listener struct {
+	name string // "http" or "https", for logs
+	addr string
+	srv  *http.Server
+}
+
+// ListenAndServe serves the API on the plain HTTP address (SERVER_LISTEN, a
+// TCP address or unix:// socket path, else SERVER_PORT) and, when TLS is
+// configured, on TLS_LISTEN as well, with the certificate files or
+// certificates obtained from Let's Encrypt for TLS_AUTOCERT_HOSTS. With
+// TLS_REDIRECT_HTTP the plain listener redirects to HTTPS instead. It
+// blocks until ctx is cancelled, then shuts every listener down gracefully,
+// or until one of them fails.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	handler := withCORS(s.cfg.CORSAllowedOrigins, withRequestID(withLogging(withCompression(s))))
+
+	var tlsConfig *tls.Config
+	plain := handler
+	switch {
+	case len(s.cfg.TLSAutocertHosts) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.cfg.TLSAutocertHosts...),
+			Cache:      autocert.DirCache(s.cfg.TLSAutocertCacheDir),
+		}
+		tlsConfig = m.TLSConfig()
+		if s.cfg.TLSRedirectHTTP {
+			plain = s.redirectToHTTPS()
+		}
+		// Answers the HTTP-01 challenges, passing other requests on.
+		plain = m.HTTPHandler(plain)
+	case s.cfg.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCertFile, s.cfg.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("load TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if s.cfg.TLSRedirectHTTP {
+			plain = s.redirectToHTTPS()
+		}
+	}
+
+	addr := s.cfg.ServerListen
+	if addr == "" {
+		addr = ":" + s.cfg.ServerPort
+	}
+	listeners := []listener{{name: "http", addr: addr, srv: newHTTPServer(plain)}}
+	if tlsConfig != nil {
+		srv := newHTTPServer(handler)
+		srv.TLSConfig = tlsConfig
+		listeners = append(listeners, listener{name: "https", addr: s.cfg.TLSListen, srv: srv})
+	}
+
+	// Bind every address before serving any, so a taken port fails startup.
+	lns := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		ln, err := listen(l.addr)
+		if err != nil {
+			for _, ln := range lns[:i] {
+				ln.Close()
+			}
+			return fmt.Errorf("listen %s: %w", l.name, err)
+		}
+		lns[i] = ln
+	}
+
+	errs := make(chan error, len(listeners))
+	var wg sync.WaitGroup
+	for i, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("listening", "listener", l.name, "addr", l.addr)
+			var err error
+			if l.srv.TLSConfig != nil {
+				err = l.srv.ServeTLS(lns[i], "", "")
+			} else {
+				err = l.srv.Serve(lns[i])
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("serve %s: %w", l.name, err)
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+
+	// Serve returns as soon as Shutdown is called, so wait for the
+	// in-flight requests of every listener too.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var shutdown sync.WaitGroup
+	for _, l := range listeners {
+		shutdown.Add(1)
+		go func() {
+			defer shutdown.Done()
+			if err := l.srv.Shutdown(shutdownCtx); err != nil {
+				slog.Error("server shutdown", "listener", l.name, "err", err)
+			}
+		}()
+	}
+	shutdown.Wait()
+	wg.Wait()
+	return err
+}
+
+// newHTTPServer returns a server for h with the API's timeouts.
+func newHTTPServer(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      h,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Minute,
+		IdleTimeout:  120 * time.Second,
+	}
+}
+
+// listen binds a TCP address or, with the unix:// prefix, a unix socket. A
+// socket file left over by an unclean exit is removed first.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// redirectToHTTPS redirects every request permanently to the same URL on
+// the HTTPS listener.
+func (s *Server) redirectToHTTPS() http.Handler {
+	_, port, _ := net.SplitHostPort(s.cfg.TLSListen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// Logo proxy limits.
+const (
+	maxLogoBytes     = 2 << 20
+	logoFetchTimeout = 15 * time.Second
+)
+
+// handleChannelLogo serves a channel's tvg-logo through the server, so web
+// UIs on HTTPS are not broken by slow or plain-HTTP logo hosts. Logos are
+// fetched with the channel's user-agent and referrer and cached per URL.
+func (s *Server) handleChannelLogo(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if ch.Image == nil || *ch.Image == "" {
+		writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d has no logo", channelID))
+		return
+	}
+	logoURL := *ch.Image
+
+	sum := sha256.Sum256([]byte(logoURL))
+	key := "logo:" + hex.EncodeToString(sum[:16])
+	if blob := s.cachedLogo(r.Context(), key); blob != nil {
+		writeLogo(w, blob, s.cfg.LogoCacheTTL)
+		return
+	}
+
+	headers := map[string]string{"User-Agent": s.cfg.UserAgent}
+	h, err := s.store.GetChannelHeaders(r.Context(), channelID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if h != nil {
+		if h.UserAgent != nil && *h.UserAgent != "" {
+			headers["User-Agent"] = *h.UserAgent
+		}
+		if h.Referrer != nil && *h.Referrer != "" {
+			headers["Referer"] = *h.Referrer
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), logoFetchTimeout)
+	defer cancel()
+	data, contentType, err := fetcher.FetchImage(ctx, logoURL, headers, maxLogoBytes)
+	switch {
+	case errors.Is(err, fetcher.ErrBlockedAddress):
+		writeErr(w, http.StatusForbidden, fmt.Errorf("logo URL of channel %d is not allowed", channelID))
+		return
+	case err != nil:
+		writeErr(w, http.StatusBadGateway, fmt.Errorf("fetch logo: %w", err))
+		return
+	}
+
+	blob := &cache.Blob{ContentType: contentType, Data: data}
+	if s.logos != nil {
+		if err := s.logos.Set(r.Context(), key, blob, s.cfg.LogoCacheTTL); err != nil {
+			slog.WarnContext(r.Context(), "logo cache: set", "key", key, "err", err)
+		}
+	}
+	writeLogo(w, blob, s.cfg.LogoCacheTTL)
+}
+
+// cachedLogo returns the cached logo for key, or nil on a miss or error.
+func (s *Server) cachedLogo(ctx context.Context, key string) *cache.Blob {
+	if s.logos == nil {
+		return nil
+	}
+	blob, err := s.logos.Get(ctx, key)
+	if err != nil {
+		slog.WarnContext(ctx, "logo cache: get", "key", key, "err", err)
+		return nil
+	}
+	return blob
+}
+
+// writeLogo serves an image. The restrictive CSP keeps an SVG logo from
+// running scripts in the API's origin.
+func writeLogo(w http.ResponseWriter, blob *cache.Blob, ttl time.Duration) {
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(blob.Data)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(blob.Data); err != nil {
+		slog.Warn("writeLogo", "err", err)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// routeMethods are the methods probed for the Allow header of a 405.
+var routeMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost,
+	http.MethodPut, http.MethodPatch, http.MethodDelete,
+}
+
+// handleNoRoute is the mux's catch-all, so that requests no route matches
+// get the APIError envelope rather than the mux's plain-text pages: 405
+// with an Allow header when the path is served for other methods, 404
+// otherwise.
+func (s *Server) handleNoRoute(w http.ResponseWriter, r *http.Request) {
+	if allow := s.allowedMethods(r); len(allow) > 0 {
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		writeErr(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not supported on %s", r.Method, r.URL.Path))
+		return
+	}
+	writeErr(w, http.StatusNotFound, errors.New("no such route"))
+}
+
+// allowedMethods returns the methods a route other than the catch-all
+// serves for the path of r.
+func (s *Server) allowedMethods(r *http.Request) []string {
+	var allow []string
+	probe := *r
+	for _, m := range routeMethods {
+		probe.Method = m
+		if _, pattern := s.mux.Handler(&probe); pattern != "" && pattern != noRoutePattern {
+			allow = append(allow, m)
+		}
+	}
+	return allow
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHandleNoRoute(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	tests := []struct {
+		name, method, target string
+		status               int
+		allow                string
+	}{
+		{"unknown path", "GET", "/api/source", http.StatusNotFound, ""},
+		{"unknown path, other method", "DELETE", "/api/nope/1", http.StatusNotFound, ""},
+		{"wrong method", "PUT", "/api/sources", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{"wrong method with a wildcard", "POST", "/api/sources/1", http.StatusMethodNotAllowed, "GET, HEAD, PATCH, DELETE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(t, srv, tt.method, tt.target, "")
+			e := wantAPIError(t, w, tt.status)
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.status == http.StatusNotFound && e.Detail != "no such route" {
+				t.Errorf("detail = %q, want \"no such route\"", e.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// --- playlist handlers ---
+
+func (s *Server) handleListPlaylists(w http.ResponseWriter, r *http.Request) {
+	playlists, err := s.store.ListPlaylists(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if playlists == nil {
+		playlists = []models.Playlist{}
+	}
+	writeJSON(w, http.StatusOK, playlists)
+}
+
+type createPlaylistRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (s *Server) handleCreatePlaylist(w http.ResponseWriter, r *http.Request) {
+	var req createPlaylistRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("name is required"))
+		return
+	}
+
+	playlistID, err := s.store.CreatePlaylist(r.Context(), name, req.Description)
+	var pl *models.Playlist
+	if err == nil {
+		pl, err = s.store.GetPlaylist(r.Context(), playlistID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			writeErr(w, http.StatusConflict, fmt.Errorf("a playlist named %q already exists", name))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, pl)
+}
+
+func (s *Server) handleGetPlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	pl, err := s.store.GetPlaylist(r.Context(), playlistID)
+	if err != nil {
+		writePlaylistErr(w, playlistID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pl)
+}
+
+type updatePlaylistRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"` // "" clears it
+}
+
+func (s *Server) handleUpdatePlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req updatePlaylistRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	fields := store.PlaylistUpdate{Description: req.Description}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("name must not be empty"))
+			return
+		}
+		fields.Name = &name
+	}
+
+	err = s.store.UpdatePlaylist(r.Context(), playlistID, fields)
+	var pl *models.Playlist
+	if err == nil {
+		pl, err = s.store.GetPlaylist(r.Context(), playlistID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			writeErr(w, http.StatusConflict, fmt.Errorf("a playlist named %q already exists", *fields.Name))
+			return
+		}
+		writePlaylistErr(w, playlistID, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pl)
+}
+
+// handleDeletePlaylist deletes a playlist; its channels are not touched.
+func (s *Server) handleDeletePlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.store.DeletePlaylist(r.Context(), playlistID); err != nil {
+		writePlaylistErr(w, playlistID, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// handleListPlaylistChannels lists a playlist's channels with the filters,
+// sorts and paging of GET /api/channels.
+func (s *Server) handleListPlaylistChannels(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.playlistFilter(w, r)
+	if !ok {
+		return
+	}
+	s.writeChannelPage(w, r, filter)
+}
+
+// handleExportPlaylistM3U streams a playlist's channels as M3U. The filters
+// and sorts of GET /api/playlist.m3u apply.
+func (s *Server) handleExportPlaylistM3U(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.playlistFilter(w, r)
+	if !ok {
+		return
+	}
+	s.writePlaylist(r.Context(), w, filter, fmt.Sprintf("playlist-%d.m3u", *filter.PlaylistID))
+}
+
+// playlistFilter parses the channel filter and sort of a request for the
+// channels of the playlist in the path, answering the request itself and
+// returning false when the playlist does not exist or a parameter is
+// invalid.
+func (s *Server) playlistFilter(w http.ResponseWriter, r *http.Request) (store.ChannelFilter, bool) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return store.ChannelFilter{}, false
+	}
+	if _, err := s.store.GetPlaylist(r.Context(), playlistID); err != nil {
+		writePlaylistErr(w, playlistID, err)
+		return store.ChannelFilter{}, false
+	}
+
+	q := r.URL.Query()
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return store.ChannelFilter{}, false
+	}
+	filter.Search = q.Get("search")
+	if filter.Sort, err = parseChannelSort(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return store.ChannelFilter{}, false
+	}
+	filter.PlaylistID = &playlistID
+	return filter, true
+}
+
+// handleAddPlaylistChannel adds a channel to a playlist. Adding a channel
+// that is already in it succeeds without changing anything.
+func (s *Server) handleAddPlaylistChannel(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	channelID, err := parseID(r, "channelID")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	added, err := s.store.AddPlaylistChannel(r.Context(), playlistID, channelID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// The error names the playlist or the channel.
+			writeErr(w, http.StatusNotFound, err)
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, map[string]any{
+		"playlist_id": playlistID,
+		"channel_id":  channelID,
+		"added":       added,
+	})
+}
+
+func (s *Server) handleRemovePlaylistChannel(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	channelID, err := parseID(r, "channelID")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.store.RemovePlaylistChannel(r.Context(), playlistID, channelID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d is not in playlist %d", channelID, playlistID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeNoContent(w)
+}
+
+// writePlaylistErr answers with 404 for a missing playlist and 500 for any
+// other store error.
+func writePlaylistErr(w http.ResponseWriter, playlistID int64, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, fmt.Errorf("playlist %d not found", playlistID))
+		return
+	}
+	writeErr(w, http.StatusInternalServerError, err)
+}
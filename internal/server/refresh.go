@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/service"
+)
+
+// refreshLockTTL is how long a source's refresh lock is held at most, a
+// safety net for refreshes that never release it.
+const refreshLockTTL = 30 * time.Minute
+
+// refreshLockKey returns the Redis key of a source's refresh lock.
+func refreshLockKey(sourceID int64) string {
+	return fmt.Sprintf("lock:refresh:%d", sourceID)
+}
+
+// Reasons a source is skipped by POST /api/sources/refresh.
+const (
+	skipNotFound   = "not found"
+	skipDisabled   = "disabled"
+	skipNoPlaylist = "no playlist URL to refresh"
+	skipRefreshing = "already refreshing"
+)
+
+type refreshAllRequest struct {
+	Sources []int64 `json:"sources"` // leave out for every enabled source
+	Force   bool    `json:"force"`
+}
+
+type refreshAllEntry struct {
+	SourceID   int64  `json:"source_id"`
+	SourceName string `json:"source_name,omitempty"`
+	JobID      string `json:"job_id,omitempty"`
+	Skipped    string `json:"skipped,omitempty"`
+}
+
+// refreshAllJob is a queued refresh of POST /api/sources/refresh, with the
+// release of its source's refresh lock (nil without Redis).
+type refreshAllJob struct {
+	job    cache.Job
+	unlock func()
+}
+
+// handleRefreshAll refreshes every enabled playlist source, or those listed
+// in the body, at most REFRESH_CONCURRENCY at a time. It answers at once
+// with a job per source; sources that are disabled, have no playlist URL or
+// are already refreshing are skipped and reported as such.
+func (s *Server) handleRefreshAll(w http.ResponseWriter, r *http.Request) {
+	var req refreshAllRequest
+	if r.ContentLength != 0 {
+		if status, err := s.decodeJSON(w, r, &req); err != nil {
+			writeErr(w, status, err)
+			return
+		}
+		if req.Sources != nil && len(req.Sources) == 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("sources must not be empty; leave it out to refresh every source"))
+			return
+		}
+	}
+
+	sources, err := s.store.ListSources(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	byID := make(map[int64]*models.Source, len(sources))
+	for i := range sources {
+		byID[sources[i].ID] = &sources[i]
+	}
+	ids := req.Sources
+	if ids == nil {
+		for _, src := range sources {
+			if src.Enabled {
+				ids = append(ids, src.ID)
+			}
+		}
+	}
+
+	concurrency := s.cfg.RefreshConcurrency
+	if concurrency <= 0 {
+		concurrency = config.DefaultRefreshConcurrency
+	}
+
+	entries := make([]refreshAllEntry, 0, len(ids))
+	var queued []refreshAllJob
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		src := byID[id]
+		entry := refreshAllEntry{SourceID: id}
+		switch {
+		case src == nil:
+			entry.Skipped = skipNotFound
+		case !src.Enabled:
+			entry.Skipped = skipDisabled
+		case src.SourceType != models.SourceTypeM3ULink:
+			entry.Skipped = skipNoPlaylist
+		}
+		if src != nil {
+			entry.SourceName = src.Name
+		}
+		if entry.Skipped != "" {
+			entries = append(entries, entry)
+			continue
+		}
+
+		// Jobs wait their turn holding the lock, so it must outlast the
+		// refreshes queued ahead of them.
+		ttl := refreshLockTTL * time.Duration(1+len(queued)/concurrency)
+		unlock, busy, err := s.reserveRefresh(r.Context(), id, ttl)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		if busy {
+			entry.Skipped = skipRefreshing
+			entries = append(entries, entry)
+			continue
+		}
+
+		job := s.refreshJob(src, req.Force)
+		if err := s.trackJob(r.Context(), job); err != nil {
+			if unlock != nil {
+				unlock()
+			}
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		entry.JobID = job.ID
+		entries = append(entries, entry)
+		queued = append(queued, refreshAllJob{job: job, unlock: unlock})
+	}
+
+	if len(queued) > 0 {
+		service.Go(r.Context(), fmt.Sprintf("refresh of %d sources", len(queued)), func(ctx context.Context) {
+			s.runRefreshes(ctx, queued, concurrency)
+		})
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"concurrency": concurrency,
+		"queued":      len(queued),
+		"sources":     entries,
+	})
+}
+
+// reserveRefresh takes the refresh lock of a source for ttl, reporting busy
+// when it is held. Without Redis there is no lock, and a source is busy
+// while its latest ingest job has not finished; unlock is then nil.
+func (s *Server) reserveRefresh(ctx context.Context, sourceID int64, ttl time.Duration) (unlock func(), busy bool, err error) {
+	if s.redis == nil {
+		st, err := s.jobs.Tracker.Latest(ctx, sourceID)
+		if errors.Is(err, jobs.ErrNotFound) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("latest job: %w", err)
+		}
+		return nil, st.Kind == cache.JobIngest && !st.State.Finished(), nil
+	}
+
+	unlock, err = cache.TryLock(ctx, s.redis, refreshLockKey(sourceID), ttl)
+	if errors.Is(err, cache.ErrLocked) {
+		return nil, true, nil
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "cache: lock", "key", refreshLockKey(sourceID), "err", err)
+		// Non-fatal — proceed without the lock.
+		return nil, false, nil
+	}
+	return unlock, false, nil
+}
+
+// runRefreshes runs the queued refreshes, at most concurrency at a time,
+// releasing each source's lock when its refresh ends.
+func (s *Server) runRefreshes(ctx context.Context, queued []refreshAllJob, concurrency int) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, q := range queued {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if q.unlock != nil {
+				defer q.unlock()
+			}
+			// Failures are logged and recorded on the job by Run.
+			s.jobs.Run(ctx, q.job)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// --- series handlers ---
+
+// handleListSeries lists the series recognised among episode channels, with
+// episode and season counts.
+func (s *Server) handleListSeries(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := optionalSourceID(r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	series, err := s.store.ListSeries(r.Context(), sourceID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if series == nil {
+		series = []models.Series{}
+	}
+	writeJSON(w, http.StatusOK, series)
+}
+
+// handleListSeriesEpisodes lists the episodes of one series in season and
+// episode order.
+func (s *Server) handleListSeriesEpisodes(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PathValue("name"))
+	if name == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("series name is required"))
+		return
+	}
+	sourceID, err := optionalSourceID(r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	episodes, err := s.store.ListSeriesEpisodes(r.Context(), name, sourceID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(episodes) == 0 {
+		writeErr(w, http.StatusNotFound, fmt.Errorf("series %q not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":     name,
+		"episodes": episodes,
+	})
+}
+
+// optionalSourceID parses the optional source_id query parameter.
+func optionalSourceID(r *http.Request) (*int64, error) {
+	v := r.URL.Query().Get("source_id")
+	if v == "" {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source_id: %s", v)
+	}
+	return &id, nil
+}
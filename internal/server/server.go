@@ -1,23 +1,35 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/voyagen/popcornvault/api"
+	"github.com/voyagen/popcornvault/internal/buildinfo"
 	"github.com/voyagen/popcornvault/internal/cache"
 	"github.com/voyagen/popcornvault/internal/config"
 	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/logging"
+	"github.com/voyagen/popcornvault/internal/metrics"
 	"github.com/voyagen/popcornvault/internal/models"
 	"github.com/voyagen/popcornvault/internal/service"
 	"github.com/voyagen/popcornvault/internal/store"
+	"github.com/voyagen/popcornvault/internal/webhook"
 )
 
 // Server holds dependencies for the HTTP API.
@@ -26,81 +38,203 @@ type Server struct {
 	cfg      *config.Config
 	embedder *embedding.Client // nil when VOYAGE_API_KEY is not set
 	redis    *cache.Redis      // nil when REDIS_URL is not set
+	jobs     *jobs.Runner
+	logos    cache.BlobStore // nil if the disk cache could not be created
 	mux      *http.ServeMux
+	handler  http.Handler // mux behind panic recovery and the API token check
+	build    buildinfo.Info
+	tracer   *store.SlowQueryTracer // nil until SetSlowQueryTracer
 }
 
 // New creates a Server and registers routes.
 // embedder may be nil if semantic search is not configured.
 // rds may be nil if Redis is not configured (lock/queue features disabled).
 func New(s store.Store, cfg *config.Config, embedder *embedding.Client, rds *cache.Redis) *Server {
-	srv := &Server{store: s, cfg: cfg, embedder: embedder, redis: rds, mux: http.NewServeMux()}
+	srv := &Server{store: s, cfg: cfg, embedder: embedder, redis: rds, mux: http.NewServeMux(), build: buildinfo.Get()}
+	srv.jobs = &jobs.Runner{
+		Store:     s,
+		Embedder:  embedder,
+		Timeout:   cfg.Timeout,
+		MaxBytes:  cfg.MaxBodyBytes,
+		Retries:   cfg.Retries,
+		Backoff:   cfg.RetryBackoff,
+		WarmCache: cfg.CacheWarm,
+
+		CheckConcurrency: cfg.CheckConcurrency,
+		CheckTimeout:     cfg.CheckTimeout,
+
+		Webhooks:   webhook.NewSender(cfg.WebhookSecret),
+		WebhookURL: cfg.WebhookURL,
+	}
+	if rds != nil {
+		srv.jobs.Tracker = jobs.NewRedisTracker(rds)
+		srv.jobs.Queue = rds
+		srv.logos = cache.NewRedisBlobs(rds)
+	} else {
+		srv.jobs.Tracker = jobs.NewMemoryTracker()
+		if logos, err := cache.NewDiskBlobs(cfg.LogoCacheDir); err != nil {
+			slog.Warn("logo cache disabled", "err", err)
+		} else {
+			srv.logos = logos
+		}
+	}
 	srv.routes()
+	srv.handler = withRecovery(srv.withAuth(srv.withTimeout(withCacheControl(srv.mux))))
 	return srv
 }
 
 func (s *Server) routes() {
 	s.mux.HandleFunc("GET /api/health", s.handleHealth)
+	s.mux.HandleFunc("GET /api/health/live", s.handleHealth)
+	s.mux.HandleFunc("GET /api/health/ready", s.handleReady)
+	s.mux.HandleFunc("GET /api/version", s.handleVersion)
+	s.mux.Handle("GET /metrics", metrics.Handler())
 
 	// Sources
 	s.mux.HandleFunc("GET /api/sources", s.handleListSources)
 	s.mux.HandleFunc("POST /api/sources", s.handleAddSource)
+	s.mux.HandleFunc("POST /api/sources/upload", s.handleUploadSource)
 	s.mux.HandleFunc("GET /api/sources/{id}", s.handleGetSource)
 	s.mux.HandleFunc("PATCH /api/sources/{id}", s.handleUpdateSource)
 	s.mux.HandleFunc("DELETE /api/sources/{id}", s.handleDeleteSource)
 	s.mux.HandleFunc("POST /api/sources/{id}/refresh", s.handleRefreshSource)
+	s.mux.HandleFunc("GET /api/sources/{id}/refresh/status", s.handleRefreshStatus)
+	s.mux.HandleFunc("GET /api/sources/{id}/refresh/events", s.handleRefreshEvents)
+	s.mux.HandleFunc("GET /api/sources/{id}/refreshes", s.handleListRefreshRuns)
+	s.mux.HandleFunc("GET /api/sources/{id}/archived", s.handleListArchivedChannels)
+	s.mux.HandleFunc("POST /api/sources/refresh", s.handleRefreshAll)
+	s.mux.HandleFunc("DELETE /api/sources/{id}/refresh", s.handleCancelRefresh)
+	s.mux.HandleFunc("POST /api/sources/{id}/channels", s.handleAddChannel)
+	s.mux.HandleFunc("GET /api/sources/{id}/playlist.m3u", s.handleExportSourcePlaylist)
+	s.mux.HandleFunc("POST /api/sources/{id}/epg/refresh", s.handleRefreshEPG)
+	s.mux.HandleFunc("POST /api/sources/{id}/check", s.handleCheckSource)
+
+	// Playlist export
+	s.mux.HandleFunc("GET /api/playlist.m3u", s.handleExportPlaylist)
 
 	// Channels
 	s.mux.HandleFunc("GET /api/channels/search", s.handleSearchChannels)
+	s.mux.HandleFunc("GET /api/channels/recommended", s.handleRecommendedChannels)
 	s.mux.HandleFunc("GET /api/channels", s.handleListChannels)
+	s.mux.HandleFunc("GET /api/channels/recent", s.handleRecentChannels)
+	s.mux.HandleFunc("GET /api/channels/count", s.handleCountChannels)
+	s.mux.HandleFunc("POST /api/channels/bulk", s.handleBulkUpdateChannels)
 	s.mux.HandleFunc("GET /api/channels/{id}", s.handleGetChannel)
+	s.mux.HandleFunc("GET /api/channels/{id}/epg", s.handleChannelEPG)
+	s.mux.HandleFunc("GET /api/channels/{id}/similar", s.handleSimilarChannels)
+	s.mux.HandleFunc("GET /api/channels/{id}/logo", s.handleChannelLogo)
+	s.mux.HandleFunc("GET /api/channels/{id}/stream", s.handleChannelStream)
+	s.mux.HandleFunc("GET /api/channels/{id}/watch", s.handleGetWatch)
+	s.mux.HandleFunc("POST /api/channels/{id}/watch", s.handleRecordWatch)
+	s.mux.HandleFunc("PATCH /api/channels/{id}", s.handleUpdateChannel)
+	s.mux.HandleFunc("DELETE /api/channels/{id}", s.handleDeleteChannel)
 	s.mux.HandleFunc("PATCH /api/channels/{id}/favorite", s.handleToggleChannelFavorite)
+	s.mux.HandleFunc("PATCH /api/channels/{id}/hidden", s.handleSetChannelHidden)
+	s.mux.HandleFunc("POST /api/channels/{id}/restore", s.handleRestoreChannel)
+	s.mux.HandleFunc("PUT /api/channels/{id}/headers", s.handleSetChannelHeaders)
+
+	// Favorites
+	s.mux.HandleFunc("GET /api/favorites", s.handleListFavorites)
+	s.mux.HandleFunc("GET /api/favorites/playlist.m3u", s.handleExportFavorites)
+
+	// Stats
+	s.mux.HandleFunc("GET /api/stats", s.handleStats)
 
 	// Groups
 	s.mux.HandleFunc("GET /api/groups", s.handleListGroups)
+	s.mux.HandleFunc("POST /api/groups/merge", s.handleMergeGroups)
+	s.mux.HandleFunc("GET /api/groups/{id}", s.handleGetGroup)
+	s.mux.HandleFunc("PATCH /api/groups/{id}", s.handleUpdateGroup)
+	s.mux.HandleFunc("DELETE /api/groups/{id}", s.handleDeleteGroup)
+
+	// Watch history
+	s.mux.HandleFunc("GET /api/history", s.handleWatchHistory)
+
+	// Playlists
+	s.mux.HandleFunc("GET /api/playlists", s.handleListPlaylists)
+	s.mux.HandleFunc("POST /api/playlists", s.handleCreatePlaylist)
+	s.mux.HandleFunc("GET /api/playlists/{id}", s.handleGetPlaylist)
+	s.mux.HandleFunc("PATCH /api/playlists/{id}", s.handleUpdatePlaylist)
+	s.mux.HandleFunc("DELETE /api/playlists/{id}", s.handleDeletePlaylist)
+	s.mux.HandleFunc("GET /api/playlists/{id}/channels", s.handleListPlaylistChannels)
+	s.mux.HandleFunc("POST /api/playlists/{id}/channels/{channelID}", s.handleAddPlaylistChannel)
+	s.mux.HandleFunc("DELETE /api/playlists/{id}/channels/{channelID}", s.handleRemovePlaylistChannel)
+	s.mux.HandleFunc("GET /api/playlists/{id}/playlist.m3u", s.handleExportPlaylistM3U)
+
+	// Series
+	s.mux.HandleFunc("GET /api/series", s.handleListSeries)
+	s.mux.HandleFunc("GET /api/series/{name}/episodes", s.handleListSeriesEpisodes)
+
+	// Jobs
+	s.mux.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	s.mux.HandleFunc("POST /api/jobs/{id}/cancel", s.handleCancelJob)
+
+	// Admin
+	s.mux.HandleFunc("POST /api/admin/reindex-embeddings", s.handleReindexEmbeddings)
+	s.mux.HandleFunc("GET /api/admin/jobs/dead", s.handleDeadJobs)
+	s.mux.HandleFunc("GET /api/admin/slow-queries", s.handleGetSlowQueries)
+	s.mux.HandleFunc("PATCH /api/admin/slow-queries", s.handlePatchSlowQueries)
+	s.mux.HandleFunc("GET /api/admin/cache/stats", s.handleCacheStats)
+	s.mux.HandleFunc("POST /api/admin/cache/flush", s.handleFlushCache)
+
+	// HDHomeRun emulation (Plex/Jellyfin expect these at the root)
+	if s.cfg.HDHREnabled {
+		s.mux.HandleFunc("GET /discover.json", s.handleHDHRDiscover)
+		s.mux.HandleFunc("GET /lineup_status.json", s.handleHDHRLineupStatus)
+		s.mux.HandleFunc("GET /lineup.json", s.handleHDHRLineup)
+	}
+
+	// Xtream Codes emulation for IPTV apps
+	s.mux.HandleFunc("GET /player_api.php", s.handleXtreamAPI)
+	s.mux.HandleFunc("POST /player_api.php", s.handleXtreamAPI)
+	s.mux.HandleFunc("GET /live/{user}/{pass}/{file}", s.handleXtreamStream)
+	s.mux.HandleFunc("GET /movie/{user}/{pass}/{file}", s.handleXtreamStream)
+	s.mux.HandleFunc("GET /series/{user}/{pass}/{file}", s.handleXtreamStream)
 
 	// Docs
 	s.mux.HandleFunc("GET /api/docs", handleSwaggerUI)
 	s.mux.HandleFunc("GET /api/docs/openapi.yaml", handleOpenAPISpec)
+
+	s.mux.HandleFunc(noRoutePattern, s.handleNoRoute)
 }
 
+// noRoutePattern matches every request no other route does; see
+// handleNoRoute.
+const noRoutePattern = "/"
+
 // ServeHTTP implements http.Handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.mux.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
-// ListenAndServe starts the HTTP server on the configured port.
-// It blocks until the server is shut down or ctx is cancelled.
-func (s *Server) ListenAndServe(ctx context.Context) error {
-	addr := ":" + s.cfg.ServerPort
-	httpServer := &http.Server{
-		Addr:         addr,
-		Handler:      withCORS(withLogging(s)),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Minute,
-		IdleTimeout:  120 * time.Second,
-	}
-
-	// Graceful shutdown on context cancellation.
-	go func() {
-		<-ctx.Done()
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			log.Printf("server shutdown: %v", err)
-		}
-	}()
+// --- handlers ---
 
-	log.Printf("listening on %s", addr)
-	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("ListenAndServe: %w", err)
-	}
-	return nil
+// handleHealth is the liveness probe: it only shows that the process serves
+// requests, and which version. See handleReady for the dependencies.
+func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "version": s.build.Version})
 }
 
-// --- handlers ---
+// versionResponse is the body of GET /api/version.
+type versionResponse struct {
+	buildinfo.Info
+	Features map[string]bool `json:"features"`
+}
 
-func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+// handleVersion reports the build and which optional features this
+// instance runs with.
+func (s *Server) handleVersion(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, versionResponse{
+		Info: s.build,
+		Features: map[string]bool{
+			"semantic_search": s.embedder != nil,
+			"redis":           s.redis != nil,
+			"auth":            len(s.cfg.APITokens) > 0 || len(s.cfg.APIReadTokens) > 0,
+			"webhooks":        s.cfg.WebhookURL != "",
+			"hdhomerun":       s.cfg.HDHREnabled,
+			"xtream":          true,
+		},
+	})
 }
 
 // --- source handlers ---
@@ -114,18 +248,35 @@ func (s *Server) handleListSources(w http.ResponseWriter, r *http.Request) {
 	if sources == nil {
 		sources = []models.Source{}
 	}
+	for i := range sources {
+		sources[i] = sources[i].Redacted()
+	}
 	writeJSON(w, http.StatusOK, sources)
 }
 
 type addSourceRequest struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Type         string            `json:"type"` // "custom" for a source without a playlist
+	Name         string            `json:"name"`
+	URL          string            `json:"url"`
+	FetchHeaders map[string]string `json:"fetch_headers"`
+	Dedupe       bool              `json:"dedupe"`
+	UserAgent    string            `json:"user_agent"` // defaults to FETCHER_USER_AGENT
+	UseTvgID     *bool             `json:"use_tvg_id"` // defaults to true
 }
 
 func (s *Server) handleAddSource(w http.ResponseWriter, r *http.Request) {
 	var req addSourceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err))
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	switch req.Type {
+	case "":
+	case "custom":
+		s.addCustomSource(w, r, req)
+		return
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid type: %s (use custom, or leave it out for a playlist URL)", req.Type))
 		return
 	}
 	if req.URL == "" {
@@ -139,19 +290,317 @@ func (s *Server) handleAddSource(w http.ResponseWriter, r *http.Request) {
 	if req.Name == "" {
 		req.Name = "m3u"
 	}
+	headers, err := validateFetchHeaders(req.FetchHeaders)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	userAgent := strings.TrimSpace(req.UserAgent)
+	if strings.ContainsAny(userAgent, "\r\n\x00") {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid user_agent"))
+		return
+	}
+	if userAgent == "" {
+		userAgent = s.cfg.UserAgent
+	}
+
+	// A second source for the same playlist would ingest every channel
+	// twice, and a refresh must not quietly move a source to another URL.
+	sources, err := s.store.ListSources(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	force := r.URL.Query().Get("force") == "true"
+	norm := normalizeSourceURL(req.URL)
+	for _, src := range sources {
+		switch {
+		case src.Name == req.Name:
+			writeSourceConflict(w, src.ID, fmt.Errorf("a source named %q already exists (id %d); refresh it, or change its URL with PATCH /api/sources/%d", req.Name, src.ID, src.ID))
+			return
+		case !force && src.URL != "" && normalizeSourceURL(src.URL) == norm:
+			writeSourceConflict(w, src.ID, fmt.Errorf("source %q (id %d) already has this URL; pass force=true to add it again", src.Name, src.ID))
+			return
+		}
+	}
+
+	// Large playlists take minutes to ingest, so the work is queued and the
+	// client polls GET /api/jobs/{id} for the outcome.
+	job := cache.Job{
+		ID:         jobs.NewID(),
+		Kind:       cache.JobIngest,
+		SourceName: req.Name,
+		URL:        req.URL,
+		UserAgent:  userAgent,
+		Headers:    headers,
+		UseTvgID:   req.UseTvgID == nil || *req.UseTvgID,
+		Dedupe:     req.Dedupe,
+	}
+	if err := s.queueJob(r.Context(), job); err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id": job.ID,
+		"state":  jobs.StateQueued,
+	})
+}
+
+// normalizeSourceURL returns a playlist URL in a form in which two URLs of
+// the same playlist compare equal: scheme and host in lower case, without a
+// default port, fragment or trailing slash. The query is kept, as it often
+// carries the account.
+func normalizeSourceURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return strings.TrimSpace(raw)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u.String()
+}
+
+// writeSourceConflict answers 409 for a source that duplicates sourceID.
+func writeSourceConflict(w http.ResponseWriter, sourceID int64, err error) {
+	writeJSON(w, http.StatusConflict, APIError{
+		Status:    http.StatusConflict,
+		Error:     http.StatusText(http.StatusConflict),
+		Detail:    err.Error(),
+		RequestID: w.Header().Get(requestIDHeader),
+		SourceID:  sourceID,
+	})
+}
+
+// addCustomSource creates a custom source: one without a playlist, which is
+// never refreshed and whose channels are added with
+// POST /api/sources/{id}/channels.
+func (s *Server) addCustomSource(w http.ResponseWriter, r *http.Request, req addSourceRequest) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("name is required"))
+		return
+	}
+	if req.URL != "" || req.FetchHeaders != nil || req.Dedupe || req.UserAgent != "" || req.UseTvgID != nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("a custom source takes only a name"))
+		return
+	}
 
-	sourceID, count, err := service.Ingest(r.Context(), s.store, req.URL, req.Name, s.cfg.UserAgent, s.cfg.Timeout, true, s.embedder)
+	sourceID, err := s.store.CreateCustomSource(r.Context(), name)
+	var src *models.Source
+	if err == nil {
+		src, err = s.store.GetSourceByID(r.Context(), sourceID)
+	}
 	if err != nil {
-		writeErr(w, http.StatusInternalServerError, fmt.Errorf("ingest: %w", err))
+		if errors.Is(err, store.ErrConflict) {
+			writeErr(w, http.StatusConflict, fmt.Errorf("a source named %q already exists", name))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
+	writeJSON(w, http.StatusCreated, src.Redacted())
+}
+
+// queueJob records job as queued and submits it for background execution.
+func (s *Server) queueJob(ctx context.Context, job cache.Job) error {
+	if err := s.trackJob(ctx, job); err != nil {
+		return err
+	}
+	s.submitJob(ctx, job)
+	return nil
+}
+
+// trackJob records job as queued, and as the latest job of its source.
+func (s *Server) trackJob(ctx context.Context, job cache.Job) error {
+	st := &jobs.Status{
+		ID:         job.ID,
+		Kind:       job.Kind,
+		State:      jobs.StateQueued,
+		SourceID:   job.SourceID,
+		SourceName: job.SourceName,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.jobs.Tracker.Save(ctx, st); err != nil {
+		return fmt.Errorf("save job: %w", err)
+	}
+	if job.SourceID != 0 {
+		if err := s.jobs.Tracker.SetLatest(ctx, job.SourceID, job.ID); err != nil {
+			return fmt.Errorf("save job: %w", err)
+		}
+	}
+	return nil
+}
+
+// Embedding refresh modes for POST /api/sources/{id}/refresh?embeddings_only=true.
+const (
+	embedModeAll         = "all"          // every channel whose embedding text changed
+	embedModeMissingOnly = "missing_only" // only channels without a current embedding
+)
+
+// ResumeEmbeddings queues a missing-only embedding job for every enabled
+// source with channels that have no embedding from the current model, so
+// runs interrupted by a restart pick up where they stopped. It does nothing
+// without an embedder.
+func (s *Server) ResumeEmbeddings(ctx context.Context) error {
+	if s.embedder == nil {
+		return nil
+	}
+	sources, err := s.store.ListSources(ctx)
+	if err != nil {
+		return fmt.Errorf("ListSources: %w", err)
+	}
+	for _, src := range sources {
+		if !src.Enabled {
+			continue
+		}
+		stats, err := s.store.EmbeddingStats(ctx, src.ID, s.embedder.Model())
+		if err != nil {
+			return fmt.Errorf("EmbeddingStats: %w", err)
+		}
+		if stats.Missing+stats.Stale == 0 {
+			continue
+		}
+		job := cache.Job{
+			ID:             jobs.NewID(),
+			Kind:           cache.JobEmbeddings,
+			SourceID:       src.ID,
+			SourceName:     src.Name,
+			EmbeddingsOnly: true,
+			MissingOnly:    true,
+		}
+		if err := s.queueJob(ctx, job); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "resume: queued embeddings job", "job_id", job.ID, "source", src.Name, "channels", stats.Missing+stats.Stale)
+	}
+	return nil
+}
 
-	writeJSON(w, http.StatusCreated, map[string]any{
-		"source_id":     sourceID,
-		"channel_count": count,
+// submitJob enqueues job on Redis when available, otherwise (or when the
+// enqueue fails, as it does at once while Redis is down) runs it in a
+// background goroutine.
+func (s *Server) submitJob(ctx context.Context, job cache.Job) {
+	if s.redis != nil {
+		err := cache.Enqueue(ctx, s.redis, cache.DefaultQueue, job)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, cache.ErrUnavailable) {
+			slog.WarnContext(ctx, "queue: enqueue failed, running the job in-process", "job_id", job.ID, "err", err)
+		}
+	}
+	service.Go(ctx, "job "+job.ID+" ("+job.Kind+")", func(ctx context.Context) {
+		s.jobs.Run(ctx, job)
 	})
 }
 
+// handleUploadSource ingests an M3U playlist uploaded as multipart/form-data.
+// The body is read as a stream so large playlists are never buffered in full;
+// for that reason an optional "name" field must precede the "file" part
+// (the name may also be passed as a query parameter).
+func (s *Server) handleUploadSource(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("expected multipart/form-data body: %w", err))
+		return
+	}
+
+	// Large uploads can take longer than the server-wide read timeout.
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		slog.WarnContext(r.Context(), "upload: extend read deadline", "err", err)
+	}
+
+	name := r.URL.Query().Get("name")
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("read multipart body: %w", err))
+			return
+		}
+
+		switch part.FormName() {
+		case "name":
+			b, err := io.ReadAll(io.LimitReader(part, 1024))
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, fmt.Errorf("read name field: %w", err))
+				return
+			}
+			name = strings.TrimSpace(string(b))
+		case "file":
+			if name == "" {
+				name = strings.TrimSuffix(part.FileName(), path.Ext(part.FileName()))
+			}
+			if name == "" {
+				name = "m3u"
+			}
+
+			// Uploading again replaces an uploaded source's channels, but
+			// must not take over a playlist or custom source of that name.
+			sources, err := s.store.ListSources(r.Context())
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, err)
+				return
+			}
+			for _, src := range sources {
+				if src.Name == name && src.SourceType != models.SourceTypeM3U {
+					writeSourceConflict(w, src.ID, fmt.Errorf("a source named %q already exists (id %d) and is not an uploaded playlist", name, src.ID))
+					return
+				}
+			}
+
+			// With Redis the embeddings are queued as their own job, which
+			// survives restarts, instead of running in the background here.
+			embedder := s.embedder
+			if s.redis != nil {
+				embedder = nil
+			}
+			sourceID, count, err := service.IngestUpload(r.Context(), s.store, part, name, true, embedder)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, fmt.Errorf("ingest: %w", err))
+				return
+			}
+
+			resp := map[string]any{
+				"source_id":     sourceID,
+				"channel_count": count,
+			}
+			if s.embedder != nil && s.redis != nil && count > 0 {
+				job := cache.Job{
+					ID:             jobs.NewID(),
+					Kind:           cache.JobEmbeddings,
+					SourceID:       sourceID,
+					SourceName:     name,
+					EmbeddingsOnly: true,
+				}
+				if err := s.queueJob(r.Context(), job); err != nil {
+					slog.WarnContext(r.Context(), "upload: queue embeddings", "source", name, "err", err)
+				} else {
+					resp["embed_job_id"] = job.ID
+				}
+			}
+			writeJSON(w, http.StatusCreated, resp)
+			return
+		}
+		part.Close()
+	}
+
+	writeErr(w, http.StatusBadRequest, fmt.Errorf("file is required"))
+}
+
 func (s *Server) handleGetSource(w http.ResponseWriter, r *http.Request) {
 	sourceID, err := parseID(r, "id")
 	if err != nil {
@@ -169,14 +618,42 @@ func (s *Server) handleGetSource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	writeJSON(w, http.StatusOK, src)
+	if s.embedder == nil {
+		writeJSON(w, http.StatusOK, src.Redacted())
+		return
+	}
+	stats, err := s.store.EmbeddingStats(r.Context(), sourceID, s.embedder.Model())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sourceWithEmbeddings{Source: src.Redacted(), Embeddings: stats})
+}
+
+// sourceWithEmbeddings is a source with its embedding coverage, returned by
+// GET /api/sources/{id} when embeddings are configured.
+type sourceWithEmbeddings struct {
+	models.Source
+	Embeddings store.EmbeddingStats `json:"embeddings"`
 }
 
 type updateSourceRequest struct {
-	Name      *string `json:"name"`
-	URL       *string `json:"url"`
-	UserAgent *string `json:"user_agent"`
-	Enabled   *bool   `json:"enabled"`
+	Name           *string `json:"name"`
+	URL            *string `json:"url"`
+	UserAgent      *string `json:"user_agent"`
+	EPGURL         *string `json:"epg_url"`
+	Enabled        *bool   `json:"enabled"`
+	GuessMediaType *bool   `json:"guess_media_type"`
+	Dedupe         *bool   `json:"dedupe"`
+	UseTvgID       *bool   `json:"use_tvg_id"`
+	DeadPolicy     *string `json:"dead_channel_policy"`
+	DeadThreshold  *int    `json:"dead_channel_threshold"`
+	StalePolicy    *string `json:"stale_policy"`
+	WebhookURL     *string `json:"webhook_url"`
+
+	// FetchHeaders replaces all extra headers; {} removes them. Values sent
+	// back masked keep the stored value.
+	FetchHeaders map[string]string `json:"fetch_headers"`
 }
 
 func (s *Server) handleUpdateSource(w http.ResponseWriter, r *http.Request) {
@@ -187,24 +664,92 @@ func (s *Server) handleUpdateSource(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req updateSourceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err))
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
 		return
 	}
 
 	fields := store.SourceUpdate{
-		Name:      req.Name,
-		URL:       req.URL,
-		UserAgent: req.UserAgent,
-		Enabled:   req.Enabled,
+		Name:           req.Name,
+		URL:            req.URL,
+		UserAgent:      req.UserAgent,
+		EPGURL:         req.EPGURL,
+		Enabled:        req.Enabled,
+		GuessMediaType: req.GuessMediaType,
+		Dedupe:         req.Dedupe,
+		UseTvgID:       req.UseTvgID,
+		DeadPolicy:     req.DeadPolicy,
+		DeadThreshold:  req.DeadThreshold,
+		StalePolicy:    req.StalePolicy,
+		WebhookURL:     req.WebhookURL,
+	}
+	if req.URL != nil {
+		if u, err := url.ParseRequestURI(*req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("url must be a valid http or https URL"))
+			return
+		}
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("name must not be empty"))
+		return
+	}
+	if req.UserAgent != nil && strings.ContainsAny(*req.UserAgent, "\r\n\x00") {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid user_agent"))
+		return
+	}
+	if req.DeadPolicy != nil {
+		switch *req.DeadPolicy {
+		case models.DeadPolicyKeep, models.DeadPolicyHide, models.DeadPolicyDelete:
+		default:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid dead_channel_policy: %s (use keep, hide or delete)", *req.DeadPolicy))
+			return
+		}
+	}
+	if req.StalePolicy != nil {
+		switch *req.StalePolicy {
+		case models.StalePolicyDelete, models.StalePolicyArchive:
+		default:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid stale_policy: %s (use delete or archive)", *req.StalePolicy))
+			return
+		}
+	}
+	if req.DeadThreshold != nil && *req.DeadThreshold < 1 {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("dead_channel_threshold must be at least 1"))
+		return
+	}
+	if req.WebhookURL != nil && *req.WebhookURL != "" {
+		if u, err := url.ParseRequestURI(*req.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("webhook_url must be an http or https URL"))
+			return
+		}
+	}
+	if req.FetchHeaders != nil {
+		headers, err := validateFetchHeaders(req.FetchHeaders)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		cur, err := s.store.GetSourceByID(r.Context(), sourceID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		fields.FetchHeaders = mergeRedactedHeaders(headers, cur.FetchHeaders)
 	}
 
 	if err := s.store.UpdateSource(r.Context(), sourceID, fields); err != nil {
-		if errors.Is(err, store.ErrNotFound) {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
 			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
-			return
+		case errors.Is(err, store.ErrConflict):
+			writeErr(w, http.StatusConflict, fmt.Errorf("a source named %q already exists", *req.Name))
+		default:
+			writeErr(w, http.StatusInternalServerError, err)
 		}
-		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -214,7 +759,7 @@ func (s *Server) handleUpdateSource(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, src)
+	writeJSON(w, http.StatusOK, src.Redacted())
 }
 
 func (s *Server) handleDeleteSource(w http.ResponseWriter, r *http.Request) {
@@ -258,17 +803,36 @@ func (s *Server) handleRefreshSource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if src.SourceType == models.SourceTypeCustom && r.URL.Query().Get("embeddings_only") != "true" {
+		writeErr(w, http.StatusConflict, fmt.Errorf("source %d is a custom source and has no playlist to refresh; add channels with POST /api/sources/%d/channels", sourceID, sourceID))
+		return
+	}
+	if src.SourceType == models.SourceTypeM3U && r.URL.Query().Get("embeddings_only") != "true" {
+		writeErr(w, http.StatusConflict, fmt.Errorf("source %d was created from an uploaded file and cannot be re-fetched; upload a new file with POST /api/sources/upload to update it", sourceID))
+		return
+	}
+
+	// A dry run only reads, so it neither takes nor waits for the lock.
+	if r.URL.Query().Get("dry_run") == "true" {
+		if r.URL.Query().Get("embeddings_only") == "true" {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("dry_run cannot be combined with embeddings_only"))
+			return
+		}
+		s.planRefresh(w, r, src)
+		return
+	}
+
 	// Acquire a distributed lock to prevent concurrent refreshes of the same source.
-	// The lock auto-expires after 30 minutes (safety net for long ingests).
-	lockKey := fmt.Sprintf("lock:refresh:%d", sourceID)
+	// The lock auto-expires after refreshLockTTL (safety net for long ingests).
+	lockKey := refreshLockKey(sourceID)
 	if s.redis != nil {
-		unlock, err := cache.TryLock(r.Context(), s.redis, lockKey, 30*time.Minute)
+		unlock, err := cache.TryLock(r.Context(), s.redis, lockKey, refreshLockTTL)
 		if errors.Is(err, cache.ErrLocked) {
 			writeErr(w, http.StatusConflict, fmt.Errorf("source %d refresh is already in progress", sourceID))
 			return
 		}
 		if err != nil {
-			log.Printf("cache: lock %s: %v", lockKey, err)
+			slog.WarnContext(r.Context(), "cache: lock", "key", lockKey, "err", err)
 			// Non-fatal — proceed without the lock.
 		} else {
 			defer unlock()
@@ -283,6 +847,15 @@ func (s *Server) handleRefreshSource(w http.ResponseWriter, r *http.Request) {
 			writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("embeddings not configured (VOYAGE_API_KEY not set)"))
 			return
 		}
+		mode := r.URL.Query().Get("mode")
+		switch mode {
+		case "":
+			mode = embedModeAll
+		case embedModeAll, embedModeMissingOnly:
+		default:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("mode must be %q or %q", embedModeAll, embedModeMissingOnly))
+			return
+		}
 
 		// Pre-count so the response can report the expected number of channels.
 		channelCount, err := s.store.CountChannelsBySource(r.Context(), sourceID)
@@ -292,103 +865,431 @@ func (s *Server) handleRefreshSource(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Enqueue via Redis if available, otherwise fall back to goroutine.
-		if s.redis != nil {
-			job := cache.EmbeddingJob{
-				SourceID:       sourceID,
-				SourceName:     src.Name,
-				EmbeddingsOnly: true,
-			}
-			if err := cache.Enqueue(r.Context(), s.redis, cache.DefaultQueue, job); err != nil {
-				log.Printf("queue: enqueue failed, falling back to goroutine: %v", err)
-				s.refreshEmbeddingsAsync(sourceID, src.Name)
-			}
-		} else {
-			s.refreshEmbeddingsAsync(sourceID, src.Name)
+		job := cache.Job{
+			ID:             jobs.NewID(),
+			Kind:           cache.JobEmbeddings,
+			SourceID:       sourceID,
+			SourceName:     src.Name,
+			EmbeddingsOnly: true,
+			MissingOnly:    mode == embedModeMissingOnly,
+			Force:          r.URL.Query().Get("force") == "true",
+		}
+		if err := s.queueJob(r.Context(), job); err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
 		}
 
 		writeJSON(w, http.StatusAccepted, map[string]any{
+			"job_id":          job.ID,
 			"source_id":       sourceID,
 			"channel_count":   channelCount,
 			"embeddings_only": true,
+			"mode":            mode,
 		})
 		return
 	}
 
-	userAgent := src.UserAgent
-	if userAgent == "" {
-		userAgent = s.cfg.UserAgent
+	job := s.refreshJob(src, r.URL.Query().Get("force") == "true")
+	res, err := s.jobs.Run(r.Context(), job)
+	if errors.Is(err, jobs.ErrCancelled) {
+		writeErr(w, http.StatusConflict, fmt.Errorf("refresh of source %d was cancelled", sourceID))
+		return
 	}
-
-	_, count, err := service.Ingest(r.Context(), s.store, src.URL, src.Name, userAgent, s.cfg.Timeout, true, s.embedder)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, fmt.Errorf("refresh: %w", err))
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"source_id":     sourceID,
-		"channel_count": count,
-		"refreshed":     true,
-	})
+	resp := map[string]any{
+		"job_id":             job.ID,
+		"source_id":          sourceID,
+		"channel_count":      res.Count,
+		"refreshed":          true,
+		"unchanged":          res.Unchanged,
+		"duplicates_skipped": res.Duplicates,
+		"diff":               res.Diff,
+	}
+	if res.RefreshRunID != 0 {
+		resp["refresh_run_id"] = res.RefreshRunID
+	}
+	if res.EmbedJobID != "" {
+		resp["embed_job_id"] = res.EmbedJobID
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
-// refreshEmbeddingsAsync runs embedding refresh in a background goroutine (fallback when Redis queue is unavailable).
-func (s *Server) refreshEmbeddingsAsync(sourceID int64, sourceName string) {
-	go func() {
-		bgCtx := context.Background()
-		if _, err := service.RefreshEmbeddings(bgCtx, s.store, s.embedder, sourceID, sourceName); err != nil {
-			log.Printf("embed-refresh[%s]: error: %v", sourceName, err)
-		}
-	}()
+// refreshJob returns the ingest job that refreshes src from its playlist URL
+// with the source's settings.
+func (s *Server) refreshJob(src *models.Source, force bool) cache.Job {
+	userAgent := src.UserAgent
+	if userAgent == "" {
+		userAgent = s.cfg.UserAgent
+	}
+	return cache.Job{
+		ID:           jobs.NewID(),
+		Kind:         cache.JobIngest,
+		SourceID:     src.ID,
+		SourceName:   src.Name,
+		URL:          src.URL,
+		UserAgent:    userAgent,
+		Headers:      src.FetchHeaders,
+		UseTvgID:     src.UseTvgID == nil || *src.UseTvgID,
+		NoMediaGuess: !src.GuessMediaType,
+		Dedupe:       src.Dedupe,
+		Force:        force,
+	}
 }
 
-// --- channel handlers ---
-
-func (s *Server) handleListChannels(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
+// planRefresh answers a dry-run refresh: the source's playlist is fetched
+// and compared with its channels, and nothing is written.
+func (s *Server) planRefresh(w http.ResponseWriter, r *http.Request, src *models.Source) {
+	userAgent := src.UserAgent
+	if userAgent == "" {
+		userAgent = s.cfg.UserAgent
+	}
+	plan, err := service.PlanIngest(r.Context(), s.store, src.URL, src.Name, service.IngestOptions{
+		UserAgent: userAgent,
+		Headers:   src.FetchHeaders,
+		Timeout:   s.cfg.Timeout,
+		MaxBytes:  s.cfg.MaxBodyBytes,
+		Retries:   s.cfg.Retries,
+		Backoff:   s.cfg.RetryBackoff,
+		UseTvgID:  src.UseTvgID == nil || *src.UseTvgID,
+		NoGuess:   !src.GuessMediaType,
+		Dedupe:    src.Dedupe,
+		SourceID:  src.ID,
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, fmt.Errorf("dry run: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
 
-	filter := store.ChannelFilter{
-		Search: q.Get("search"),
+// handleRefreshStatus reports the progress of the running refresh for a
+// source, or the summary of the last one when none is running.
+func (s *Server) handleRefreshStatus(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
 	}
 
-	if v := q.Get("source_id"); v != "" {
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid source_id: %s", v))
+	st, err := s.jobs.Tracker.Latest(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("no refresh recorded for source %d", sourceID))
 			return
 		}
-		filter.SourceID = &id
+		writeErr(w, http.StatusInternalServerError, err)
+		return
 	}
-	if v := q.Get("group_id"); v != "" {
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid group_id: %s", v))
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleListRefreshRuns lists the latest ingests of a source, most recent
+// first, with the channels each one added, removed and updated.
+func (s *Server) handleListRefreshRuns(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
 			return
 		}
-		filter.GroupID = &id
+		limit = min(n, 100)
 	}
-	if v := q.Get("media_type"); v != "" {
-		n, err := strconv.ParseInt(v, 10, 16)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid media_type: %s", v))
+
+	if _, err := s.store.GetSourceByID(r.Context(), sourceID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
 			return
 		}
-		mt := int16(n)
-		filter.MediaType = &mt
+		writeErr(w, http.StatusInternalServerError, err)
+		return
 	}
-	if v := q.Get("favorite"); v != "" {
-		switch v {
-		case "true", "1":
-			fav := true
-			filter.Favorite = &fav
+	runs, err := s.store.ListRefreshRuns(r.Context(), sourceID, limit)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if runs == nil {
+		runs = []models.RefreshRun{}
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleListArchivedChannels lists the channels refreshes of the source
+// archived, most recently archived first.
+func (s *Server) handleListArchivedChannels(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := 50, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		limit = min(n, 200)
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %s", v))
+			return
+		}
+		offset = n
+	}
+
+	if _, err := s.store.GetSourceByID(r.Context(), sourceID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	channels, total, err := s.store.ListArchivedChannels(r.Context(), sourceID, limit, offset)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if channels == nil {
+		channels = []models.ArchivedChannel{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channels": channels,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// --- job handlers ---
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	st, err := s.jobs.Tracker.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// handleCancelJob stops a queued or running job.
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	st, err := s.jobs.Tracker.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("job %s not found", id))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.cancelJob(w, r, st)
+}
+
+// handleCancelRefresh stops the queued or running refresh (or embeddings
+// job) of a source, i.e. its latest job.
+func (s *Server) handleCancelRefresh(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	st, err := s.jobs.Tracker.Latest(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("no refresh recorded for source %d", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.cancelJob(w, r, st)
+}
+
+// cancelJob cancels the job st describes. A queued job is marked cancelled
+// and skipped when a worker picks it up; a running one is stopped, which
+// takes effect at its next batch, so the response is 202 and the final
+// status (with the counts reached) is reported by GET /api/jobs/{id}.
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request, st *jobs.Status) {
+	switch st.State {
+	case jobs.StateQueued:
+		now := time.Now()
+		st.State = jobs.StateCancelled
+		st.Error = jobs.ErrCancelled.Error()
+		st.FinishedAt = &now
+		st.RetryAt = nil
+		if err := s.jobs.Tracker.Save(r.Context(), st); err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		// It may have been picked up in the meantime.
+		jobs.Cancel(st.ID)
+	case jobs.StateRunning:
+		if !jobs.Cancel(st.ID) {
+			writeErr(w, http.StatusConflict, fmt.Errorf("job %s is not running in this process", st.ID))
+			return
+		}
+	default:
+		writeErr(w, http.StatusConflict, fmt.Errorf("job %s has already finished (%s)", st.ID, st.State))
+		return
+	}
+
+	slog.InfoContext(r.Context(), "job cancel requested", "job_id", st.ID, "kind", st.Kind, "source", st.SourceName)
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job_id":    st.ID,
+		"source_id": st.SourceID,
+		"state":     st.State,
+	})
+}
+
+// --- channel handlers ---
+
+func (s *Server) handleListChannels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Search = q.Get("search")
+	if filter.Sort, err = parseChannelSort(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writeChannelPage(w, r, filter)
+}
+
+// handleRecentChannels lists the channels added in the last days (default
+// 7), newest first unless sort says otherwise.
+func (s *Server) handleRecentChannels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	if filter.AddedSince != nil {
+		writeErr(w, http.StatusBadRequest, errors.New("added_since is not accepted here; use days"))
+		return
+	}
+	days := 7
+	if v := q.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid days: %s", v))
+			return
+		}
+		days = n
+	}
+	// Whole minutes, so that the pages and cached results of requests made
+	// within a minute line up.
+	since := time.Now().AddDate(0, 0, -days).Truncate(time.Minute)
+	filter.AddedSince = &since
+	filter.Search = q.Get("search")
+	if filter.Sort, err = parseChannelSort(q); err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Sort = cmp.Or(filter.Sort, store.SortCreatedDesc)
+	s.writeChannelPage(w, r, filter)
+}
+
+// handleCountChannels counts the channels matching the /api/channels
+// filters without listing them.
+func (s *Server) handleCountChannels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Search = q.Get("search")
+
+	total, err := s.store.CountChannels(r.Context(), filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"total": total})
+}
+
+// handleStats answers with the totals over every source. They may be up to
+// a minute old when caching is enabled.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.Stats(r.Context())
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleListFavorites lists the favorite channels of every source, paged
+// like /api/channels.
+func (s *Server) handleListFavorites(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFavoritesFilter(r.URL.Query())
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writeChannelPage(w, r, filter)
+}
+
+// parseFavoritesFilter parses the /api/channels filters, search and sort for
+// the favorites endpoints, which set favorite themselves.
+func parseFavoritesFilter(q url.Values) (store.ChannelFilter, error) {
+	if q.Has("favorite") {
+		return store.ChannelFilter{}, errors.New("favorite is not accepted here")
+	}
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		return filter, err
+	}
+	fav := true
+	filter.Favorite = &fav
+	filter.Search = q.Get("search")
+	filter.Sort, err = parseChannelSort(q)
+	return filter, err
+}
+
+// writeChannelPage answers with the page of channels matching filter that
+// the rank, limit and offset or cursor query parameters select, the total
+// count unless include_total=false, and the cursor of the next page.
+func (s *Server) writeChannelPage(w http.ResponseWriter, r *http.Request, filter store.ChannelFilter) {
+	q := r.URL.Query()
+	if v := q.Get("rank"); v != "" {
+		switch v {
+		case "true", "1":
+			filter.Rank = true
 		case "false", "0":
-			fav := false
-			filter.Favorite = &fav
 		default:
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid favorite: %s (use true or false)", v))
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid rank: %s (use true or false)", v))
 			return
 		}
 	}
+
 	if v := q.Get("limit"); v != "" {
 		n, err := strconv.Atoi(v)
 		if err != nil {
@@ -405,218 +1306,1113 @@ func (s *Server) handleListChannels(w http.ResponseWriter, r *http.Request) {
 		}
 		filter.Offset = n
 	}
+	ranked := filter.Rank && filter.Search != ""
+	if v := q.Get("cursor"); v != "" {
+		cur, err := store.DecodeChannelCursor(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		sort := cmp.Or(filter.Sort, store.SortName)
+		switch {
+		case cur.Sort != sort:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("cursor was made for sort %s, not %s", cur.Sort, sort))
+			return
+		case q.Has("offset"):
+			writeErr(w, http.StatusBadRequest, errors.New("cursor and offset are mutually exclusive"))
+			return
+		case ranked:
+			writeErr(w, http.StatusBadRequest, errors.New("ranked search results cannot be paged by cursor"))
+			return
+		}
+		filter.After = cur
+	}
+	if v := q.Get("include_total"); v != "" {
+		switch v {
+		case "true", "1":
+		case "false", "0":
+			filter.NoTotal = true
+		default:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid include_total: %s (use true or false)", v))
+			return
+		}
+	}
 
 	// Apply defaults so the response reflects actual values used.
 	if filter.Limit <= 0 {
 		filter.Limit = 50
 	}
-	if filter.Limit > 200 {
-		filter.Limit = 200
+	if filter.Limit > 200 {
+		filter.Limit = 200
+	}
+
+	channels, total, err := s.store.ListChannels(r.Context(), filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if channels == nil {
+		channels = []models.Channel{}
+	}
+
+	resp := map[string]any{
+		"channels": channels,
+		"limit":    filter.Limit,
+	}
+	if !filter.NoTotal {
+		resp["total"] = total
+	}
+	if filter.After == nil {
+		resp["offset"] = filter.Offset
+	}
+	// A full page may have more after it; the next page, possibly empty,
+	// tells. Ranked results have no stable order to continue from.
+	if len(channels) == filter.Limit && !ranked {
+		resp["next_cursor"] = store.CursorAfter(channels[len(channels)-1], filter.Sort).Encode()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetChannel(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ch)
+}
+
+type toggleFavoriteRequest struct {
+	Favorite bool `json:"favorite"`
+}
+
+func (s *Server) handleToggleChannelFavorite(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req toggleFavoriteRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+
+	if err := s.store.ToggleChannelFavorite(r.Context(), channelID, req.Favorite); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channel_id": channelID,
+		"favorite":   req.Favorite,
+	})
+}
+
+type setHiddenRequest struct {
+	Hidden bool `json:"hidden"`
+}
+
+// handleSetChannelHidden hides a channel or restores one hidden by the dead
+// channel policy.
+func (s *Server) handleSetChannelHidden(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req setHiddenRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+
+	if err := s.store.SetChannelHidden(r.Context(), channelID, req.Hidden); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channel_id": channelID,
+		"hidden":     req.Hidden,
+	})
+}
+
+// handleRestoreChannel moves an archived channel back among its source's
+// channels and returns it. A later refresh archives it again if the
+// playlist still does not list it.
+func (s *Server) handleRestoreChannel(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := s.store.RestoreArchivedChannel(r.Context(), channelID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErr(w, http.StatusNotFound, fmt.Errorf("archived channel %d not found", channelID))
+		case errors.Is(err, store.ErrConflict):
+			writeErr(w, http.StatusConflict, fmt.Errorf("channel %d: its source has a channel with the same name and url", channelID))
+		default:
+			writeErr(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ch)
+}
+
+// parseMediaTypeJSON parses a media type given in a request body as a code
+// (1) or a name ("movie").
+func parseMediaTypeJSON(raw json.RawMessage) (int16, error) {
+	var v string
+	if err := json.Unmarshal(raw, &v); err != nil {
+		v = string(raw)
+	}
+	return models.ParseMediaType(v)
+}
+
+type updateChannelRequest struct {
+	Name      *string         `json:"name"`
+	Image     *string         `json:"image"`
+	URL       *string         `json:"url"`
+	GroupID   *int64          `json:"group_id"`
+	MediaType json.RawMessage `json:"media_type"` // a code or a name, as in the media_type filter
+	Favorite  *bool           `json:"favorite"`
+	Hidden    *bool           `json:"hidden"`
+	Reset     []string        `json:"reset"` // edited fields to give back to the playlist
+}
+
+// handleUpdateChannel edits a channel. Name, image, URL, group and media type
+// are marked as edited and survive refreshes until listed in reset; the
+// favorite and hidden flags always survive them. Hidden channels are left
+// out of listings, searches and exports unless include_hidden=true.
+func (s *Server) handleUpdateChannel(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req updateChannelRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+
+	fields := store.ChannelUpdate{Image: req.Image, URL: req.URL, GroupID: req.GroupID, Favorite: req.Favorite, Hidden: req.Hidden}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("name must not be empty"))
+			return
+		}
+		fields.Name = &name
+	}
+	if req.URL != nil {
+		if u, err := url.ParseRequestURI(*req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("url must be a valid http or https URL"))
+			return
+		}
+	}
+	if len(req.MediaType) > 0 && string(req.MediaType) != "null" {
+		mt, err := parseMediaTypeJSON(req.MediaType)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid media_type: %w", err))
+			return
+		}
+		fields.MediaType = &mt
+	}
+	for _, name := range req.Reset {
+		bit, err := models.ParseEditedField(name)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid reset: %w", err))
+			return
+		}
+		fields.Reset |= bit
+	}
+
+	if req.GroupID != nil && *req.GroupID != 0 {
+		// The group must belong to the channel's source.
+		ch, err := s.store.GetChannelByID(r.Context(), channelID)
+		var g *models.Group
+		if err == nil {
+			g, err = s.store.GetGroup(r.Context(), *req.GroupID)
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusBadRequest, fmt.Errorf("group %d not found", *req.GroupID))
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+				return
+			}
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		if g.SourceID != ch.SourceID {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("group %d belongs to another source", g.ID))
+			return
+		}
+	}
+
+	err = s.store.UpdateChannel(r.Context(), channelID, fields)
+	var ch *models.Channel
+	if err == nil {
+		ch, err = s.store.GetChannelByID(r.Context(), channelID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ch)
+}
+
+type addChannelRequest struct {
+	Name      string                 `json:"name"`
+	URL       string                 `json:"url"`
+	Group     string                 `json:"group"` // group name, created if the source has none by that name
+	Image     string                 `json:"image"`
+	MediaType json.RawMessage        `json:"media_type"` // a code or a name, as in the media_type filter
+	Headers   *channelHeadersRequest `json:"headers"`
+}
+
+// handleAddChannel adds a channel to a custom source. It is embedded in the
+// background like the channels of a refresh.
+func (s *Server) handleAddChannel(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req addChannelRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	ch := models.Channel{SourceID: sourceID, Name: strings.TrimSpace(req.Name), URL: req.URL}
+	if ch.Name == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("name is required"))
+		return
+	}
+	if u, err := url.ParseRequestURI(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("url must be a valid http or https URL"))
+		return
+	}
+	if req.Image != "" {
+		ch.Image = &req.Image
+	}
+	if len(req.MediaType) > 0 && string(req.MediaType) != "null" {
+		mt, err := parseMediaTypeJSON(req.MediaType)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid media_type: %w", err))
+			return
+		}
+		ch.MediaType = mt
+	}
+	var headers *models.ChannelHttpHeaders
+	if req.Headers != nil {
+		if headers, err = req.Headers.headers(); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	src, err := s.store.GetSourceByID(r.Context(), sourceID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("source %d not found", sourceID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if src.SourceType != models.SourceTypeCustom {
+		writeErr(w, http.StatusConflict, fmt.Errorf("source %d is not a custom source; its channels come from its playlist", sourceID))
+		return
+	}
+
+	if group := strings.TrimSpace(req.Group); group != "" {
+		groupID, err := s.store.GetOrCreateGroup(r.Context(), sourceID, group, nil)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		ch.GroupID = &groupID
+	}
+	channelID, err := s.store.AddChannel(r.Context(), &ch, headers)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			writeErr(w, http.StatusConflict, fmt.Errorf("source %d already has a channel named %q with this URL", sourceID, ch.Name))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if s.embedder != nil {
+		job := cache.Job{
+			ID:             jobs.NewID(),
+			Kind:           cache.JobEmbeddings,
+			SourceID:       sourceID,
+			SourceName:     src.Name,
+			EmbeddingsOnly: true,
+			MissingOnly:    true,
+		}
+		if err := s.queueJob(r.Context(), job); err != nil {
+			slog.WarnContext(r.Context(), "add channel: queue embeddings", "source", src.Name, "err", err)
+		}
+	}
+
+	created, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleDeleteChannel deletes a channel of a custom source. Channels of other
+// sources would come back with the next refresh, so hiding is the way to
+// drop those.
+func (s *Server) handleDeleteChannel(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	var src *models.Source
+	if err == nil {
+		src, err = s.store.GetSourceByID(r.Context(), ch.SourceID)
+	}
+	if err == nil && src.SourceType != models.SourceTypeCustom {
+		writeErr(w, http.StatusConflict, fmt.Errorf("channel %d belongs to a playlist source; hide it with PATCH /api/channels/%d instead", channelID, channelID))
+		return
+	}
+	if err == nil {
+		err = s.store.DeleteChannel(r.Context(), channelID)
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeNoContent(w)
+}
+
+// bulkFilterCap is the most channels a filter-based bulk update may change
+// without all_matching set.
+const bulkFilterCap = 1000
+
+type bulkChannelsRequest struct {
+	IDs         []int64        `json:"ids"`
+	Filter      map[string]any `json:"filter"` // the channel list query parameters
+	AllMatching bool           `json:"all_matching"`
+	Favorite    *bool          `json:"favorite"`
+	Hidden      *bool          `json:"hidden"`
+}
+
+// handleBulkUpdateChannels sets favorite and/or hidden on the channels listed
+// in ids, or on every channel matching filter, in one UPDATE. The filter
+// takes the query parameters of GET /api/channels; when it matches more than
+// bulkFilterCap channels, all_matching must be set to confirm.
+func (s *Server) handleBulkUpdateChannels(w http.ResponseWriter, r *http.Request) {
+	var req bulkChannelsRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	if req.Favorite == nil && req.Hidden == nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("set favorite or hidden"))
+		return
+	}
+	if (req.IDs == nil) == (req.Filter == nil) {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("set exactly one of ids and filter"))
+		return
+	}
+
+	fields := store.ChannelFlags{Favorite: req.Favorite, Hidden: req.Hidden}
+	var filter store.ChannelFilter
+	if req.IDs != nil {
+		if len(req.IDs) == 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("ids must not be empty"))
+			return
+		}
+	} else {
+		q, err := filterQuery(req.Filter)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		if filter, err = parseChannelFilter(q); err != nil {
+			writeErr(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Search = q.Get("search")
+		// Hidden channels can only be restored if the filter selects them.
+		if req.Hidden != nil && !*req.Hidden {
+			filter.IncludeHidden = true
+		}
+		if !req.AllMatching {
+			count := filter
+			count.Limit = 1
+			_, total, err := s.store.ListChannels(r.Context(), count)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, err)
+				return
+			}
+			if total > bulkFilterCap {
+				writeErr(w, http.StatusBadRequest, fmt.Errorf("filter matches %d channels, more than %d; set all_matching to true to update them all", total, bulkFilterCap))
+				return
+			}
+		}
+	}
+
+	n, err := s.store.BulkUpdateChannels(r.Context(), req.IDs, filter, fields)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"updated": n})
+}
+
+// bulkFilterKeys are the channel list query parameters a bulk update filter
+// may use.
+var bulkFilterKeys = []string{"source_id", "group_id", "exclude_group_id", "media_type", "favorite", "tvg_id",
+	"quality", "status", "watched", "include_hidden", "search"}
+
+// filterQuery turns a JSON filter object into query parameters for
+// parseChannelFilter; arrays become repeated parameters.
+func filterQuery(filter map[string]any) (url.Values, error) {
+	q := url.Values{}
+	for key, v := range filter {
+		if !slices.Contains(bulkFilterKeys, key) {
+			return nil, fmt.Errorf("filter: unknown field %q (use %s)", key, strings.Join(bulkFilterKeys, ", "))
+		}
+		values, ok := v.([]any)
+		if !ok {
+			values = []any{v}
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case string:
+				q.Add(key, v)
+			case float64:
+				q.Add(key, strconv.FormatFloat(v, 'f', -1, 64))
+			case bool:
+				q.Add(key, strconv.FormatBool(v))
+			default:
+				return nil, fmt.Errorf("filter: invalid %s", key)
+			}
+		}
+	}
+	return q, nil
+}
+
+// --- search handler ---
+
+// Search modes, requested with ?mode= and reported in the search response.
+const (
+	searchModeSemantic = "semantic"
+	searchModeHybrid   = "hybrid"
+	searchModeLexical  = "lexical"
+)
+
+// handleSearchChannels ranks channels by semantic similarity to q, or with
+// mode=hybrid by similarity and name match combined. Without an embedder it
+// falls back to a name search with the same filters and reports
+// "mode": "lexical", so the endpoint works on every deployment.
+func (s *Server) handleSearchChannels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if query == "" {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("q parameter is required"))
+		return
+	}
+	mode := q.Get("mode")
+	switch mode {
+	case "":
+		mode = searchModeSemantic
+	case searchModeSemantic, searchModeHybrid, searchModeLexical:
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid mode: %s (want semantic, hybrid or lexical)", mode))
+		return
+	}
+
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		filter.Limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %s", v))
+			return
+		}
+		filter.Offset = n
+	}
+	if v := q.Get("accuracy"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid accuracy: %s (want 1 to 1000)", v))
+			return
+		}
+		filter.SearchAccuracy = n
+	}
+	if v := q.Get("min_similarity"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < -1 || f > 1 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid min_similarity: %s (want a number from -1 to 1)", v))
+			return
+		}
+		filter.MinSimilarity = f
+	}
+
+	// Apply defaults.
+	if filter.Limit <= 0 {
+		filter.Limit = 20
+	}
+	if filter.Limit > 200 {
+		filter.Limit = 200
+	}
+
+	if s.embedder == nil || mode == searchModeLexical {
+		s.lexicalSearch(w, r, query, filter)
+		return
+	}
+
+	// Vectors of other models are not comparable with the query's.
+	filter.EmbeddingModel = s.embedder.Model()
+
+	// Log active filters for debugging.
+	attrs := []any{"mode", mode, "q", query, "min_similarity", filter.MinSimilarity, "limit", filter.Limit, "offset", filter.Offset}
+	if filter.SourceID != nil {
+		attrs = append(attrs, "source_id", *filter.SourceID)
+	}
+	if len(filter.GroupIDs) > 0 {
+		attrs = append(attrs, "group_ids", filter.GroupIDs)
+	}
+	if len(filter.ExcludeGroupIDs) > 0 {
+		attrs = append(attrs, "exclude_group_ids", filter.ExcludeGroupIDs)
+	}
+	if filter.MediaType != nil {
+		attrs = append(attrs, "media_type", *filter.MediaType)
+	}
+	if filter.Favorite != nil {
+		attrs = append(attrs, "favorite", *filter.Favorite)
+	}
+	slog.DebugContext(r.Context(), "semantic search", attrs...)
+
+	// Embed the query text.
+	res, err := s.embedder.Embed(r.Context(), []string{query}, "query")
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, fmt.Errorf("embed query: %w", err))
+		return
+	}
+	vecs := res.Embeddings
+	if len(vecs) == 0 || len(vecs[0]) == 0 {
+		writeErr(w, http.StatusInternalServerError, fmt.Errorf("empty embedding returned"))
+		return
+	}
+
+	var results []store.SemanticResult
+	var total int
+	if mode == searchModeHybrid {
+		results, total, err = s.store.HybridSearch(r.Context(), vecs[0], query, filter)
+	} else {
+		results, total, err = s.store.SemanticSearch(r.Context(), vecs[0], filter)
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if results == nil {
+		results = []store.SemanticResult{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channels": results,
+		"total":    total,
+		"limit":    filter.Limit,
+		"offset":   filter.Offset,
+		"mode":     mode,
+	})
+}
+
+// lexicalSearch answers a search request with the ranked name search of
+// ListChannels. Results keep the semantic response shape, with a zero
+// similarity; min_similarity does not apply.
+func (s *Server) lexicalSearch(w http.ResponseWriter, r *http.Request, query string, filter store.ChannelFilter) {
+	filter.Search = query
+	filter.Rank = true
+	channels, total, err := s.store.ListChannels(r.Context(), filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	results := make([]store.SemanticResult, len(channels))
+	for i, ch := range channels {
+		results[i] = store.SemanticResult{Channel: ch}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channels": results,
+		"total":    total,
+		"limit":    filter.Limit,
+		"offset":   filter.Offset,
+		"mode":     searchModeLexical,
+	})
+}
+
+// handleSimilarChannels ranks channels by similarity to the stored embedding
+// of one channel, for "you might also like" lists. same_media_type and
+// other_sources narrow the candidates; the channel itself is left out.
+func (s *Server) handleSimilarChannels(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query()
+
+	limit := 10
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		limit = min(n, 100)
+	}
+	var sameMediaType, otherSources bool
+	for name, dst := range map[string]*bool{"same_media_type": &sameMediaType, "other_sources": &otherSources} {
+		switch v := q.Get(name); v {
+		case "", "false", "0":
+		case "true", "1":
+			*dst = true
+		default:
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %s (use true or false)", name, v))
+			return
+		}
+	}
+
+	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	vec, err := s.store.GetChannelEmbedding(r.Context(), channelID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if vec == nil {
+		writeErr(w, http.StatusConflict, fmt.Errorf("channel %d has no embedding yet", channelID))
+		return
+	}
+
+	// One extra result makes up for the channel itself, which ranks first.
+	filter := store.ChannelFilter{Limit: limit + 1}
+	if sameMediaType {
+		filter.MediaType = &ch.MediaType
+	}
+	if otherSources {
+		filter.ExcludeSourceID = &ch.SourceID
+	}
+	results, _, err := s.store.SemanticSearch(r.Context(), vec, filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	similar := make([]store.SemanticResult, 0, limit)
+	for _, res := range results {
+		if res.Channel.ID != channelID && len(similar) < limit {
+			similar = append(similar, res)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channel_id": channelID,
+		"channels":   similar,
+		"limit":      limit,
+	})
+}
+
+// Reasons for an empty recommendation list.
+const (
+	reasonNoFavorites  = "no_favorites"
+	reasonNoEmbeddings = "no_embeddings"
+)
+
+// handleRecommendedChannels searches for channels like the favorites: the
+// query vector is the centroid of the favorites' embeddings, and favorites
+// themselves are left out. The usual channel filters apply. Without
+// favorites or embeddings the list is empty and "reason" says why.
+func (s *Server) handleRecommendedChannels(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseChannelFilter(q)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Limit = 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		filter.Limit = min(n, 200)
+	}
+
+	empty := func(reason string) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"channels": []store.SemanticResult{},
+			"limit":    filter.Limit,
+			"reason":   reason,
+		})
+	}
+
+	fav := true
+	var favIDs []int64
+	err = s.store.StreamChannels(r.Context(), store.ChannelFilter{Favorite: &fav, IncludeHidden: true}, func(ch *models.Channel, _ *models.ChannelHttpHeaders) error {
+		favIDs = append(favIDs, ch.ID)
+		return nil
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(favIDs) == 0 {
+		empty(reasonNoFavorites)
+		return
+	}
+
+	embeddings, err := s.store.GetChannelEmbeddings(r.Context(), favIDs)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	// In favorite order, so the centroid (and its search cache key) is stable.
+	vecs := make([][]float32, 0, len(embeddings))
+	for _, id := range favIDs {
+		if v, ok := embeddings[id]; ok {
+			vecs = append(vecs, v)
+		}
+	}
+	centroid := service.Centroid(vecs)
+	if centroid == nil {
+		empty(reasonNoEmbeddings)
+		return
+	}
+
+	notFav := false
+	filter.Favorite = &notFav
+	results, _, err := s.store.SemanticSearch(r.Context(), centroid, filter)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if results == nil {
+		results = []store.SemanticResult{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"channels": results,
+		"limit":    filter.Limit,
+	})
+}
+
+// --- group handlers ---
+
+func (s *Server) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	var sourceID *int64
+	if v := r.URL.Query().Get("source_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid source_id: %s", v))
+			return
+		}
+		sourceID = &id
+	}
+
+	var includeHidden bool
+	switch v := r.URL.Query().Get("include_hidden"); v {
+	case "", "false", "0":
+	case "true", "1":
+		includeHidden = true
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid include_hidden: %s (use true or false)", v))
+		return
+	}
+	var includeFavorites bool
+	switch v := r.URL.Query().Get("include_favorites"); v {
+	case "", "false", "0":
+	case "true", "1":
+		includeFavorites = true
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid include_favorites: %s (use true or false)", v))
+		return
 	}
 
-	channels, total, err := s.store.ListChannels(r.Context(), filter)
+	groups, err := s.store.ListGroups(r.Context(), sourceID)
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
-	if channels == nil {
-		channels = []models.Channel{}
+	if !includeHidden {
+		groups = slices.DeleteFunc(groups, func(g models.Group) bool { return g.Hidden })
 	}
-
-	writeJSON(w, http.StatusOK, map[string]any{
-		"channels": channels,
-		"total":    total,
-		"limit":    filter.Limit,
-		"offset":   filter.Offset,
-	})
+	if includeFavorites {
+		// Listed first, for clients that show favorites as just another
+		// group; they fetch its channels from /api/favorites.
+		n, err := s.store.CountFavorites(r.Context(), sourceID, includeHidden)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, err)
+			return
+		}
+		fav := models.Group{Name: "Favorites", Virtual: true, ChannelCount: n}
+		if sourceID != nil {
+			fav.SourceID = *sourceID
+		}
+		groups = slices.Insert(groups, 0, fav)
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	writeJSON(w, http.StatusOK, groups)
 }
 
-func (s *Server) handleGetChannel(w http.ResponseWriter, r *http.Request) {
-	channelID, err := parseID(r, "id")
+func (s *Server) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parseID(r, "id")
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, err)
 		return
 	}
 
-	ch, err := s.store.GetChannelByID(r.Context(), channelID)
+	g, err := s.store.GetGroup(r.Context(), groupID)
 	if err != nil {
 		if errors.Is(err, store.ErrNotFound) {
-			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			writeErr(w, http.StatusNotFound, fmt.Errorf("group %d not found", groupID))
 			return
 		}
 		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, ch)
+	writeJSON(w, http.StatusOK, g)
 }
 
-type toggleFavoriteRequest struct {
-	Favorite bool `json:"favorite"`
+type updateGroupRequest struct {
+	Name   *string `json:"name"`
+	Image  *string `json:"image"`  // "" clears it
+	Hidden *bool   `json:"hidden"` // hides the group and its channels
 }
 
-func (s *Server) handleToggleChannelFavorite(w http.ResponseWriter, r *http.Request) {
-	channelID, err := parseID(r, "id")
+// handleUpdateGroup renames, hides or sets the image of a group. A hidden
+// group stays hidden across refreshes. A refresh assigns
+// channels to the group named in the playlist, so a renamed group of a
+// remote playlist is emptied by the next refresh unless the name matches.
+func (s *Server) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parseID(r, "id")
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, err)
 		return
 	}
 
-	var req toggleFavoriteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err))
+	var req updateGroupRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
 		return
 	}
-
-	if err := s.store.ToggleChannelFavorite(r.Context(), channelID, req.Favorite); err != nil {
-		if errors.Is(err, store.ErrNotFound) {
-			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("name must not be empty"))
 			return
 		}
-		writeErr(w, http.StatusInternalServerError, err)
-		return
+		req.Name = &name
 	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"channel_id": channelID,
-		"favorite":   req.Favorite,
-	})
-}
-
-// --- semantic search handler ---
-
-func (s *Server) handleSearchChannels(w http.ResponseWriter, r *http.Request) {
-	if s.embedder == nil {
-		writeErr(w, http.StatusServiceUnavailable, fmt.Errorf("semantic search is not configured (VOYAGE_API_KEY not set)"))
+	fields := store.GroupUpdate{Name: req.Name, Image: req.Image, Hidden: req.Hidden}
+	if err := s.store.UpdateGroup(r.Context(), groupID, fields); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErr(w, http.StatusNotFound, fmt.Errorf("group %d not found", groupID))
+		case errors.Is(err, store.ErrConflict):
+			writeErr(w, http.StatusConflict, fmt.Errorf("the source already has a group named %q", *req.Name))
+		default:
+			writeErr(w, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
-	q := r.URL.Query()
-	query := q.Get("q")
-	if query == "" {
-		writeErr(w, http.StatusBadRequest, fmt.Errorf("q parameter is required"))
+	g, err := s.store.GetGroup(r.Context(), groupID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, g)
+}
 
-	filter := store.ChannelFilter{}
+type mergeGroupsRequest struct {
+	TargetID int64   `json:"target_id"`
+	GroupIDs []int64 `json:"group_ids"`
+}
 
-	if v := q.Get("source_id"); v != "" {
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid source_id: %s", v))
-			return
-		}
-		filter.SourceID = &id
-	}
-	if v := q.Get("group_id"); v != "" {
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid group_id: %s", v))
-			return
-		}
-		filter.GroupID = &id
-	}
-	if v := q.Get("media_type"); v != "" {
-		n, err := strconv.ParseInt(v, 10, 16)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid media_type: %s", v))
-			return
-		}
-		mt := int16(n)
-		filter.MediaType = &mt
-	}
-	if v := q.Get("favorite"); v != "" {
-		switch v {
-		case "true", "1":
-			fav := true
-			filter.Favorite = &fav
-		case "false", "0":
-			fav := false
-			filter.Favorite = &fav
-		default:
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid favorite: %s (use true or false)", v))
-			return
-		}
-	}
-	if v := q.Get("limit"); v != "" {
-		n, err := strconv.Atoi(v)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
-			return
-		}
-		filter.Limit = n
-	}
+type mergeGroupsResponse struct {
+	TargetID      int64 `json:"target_id"`
+	ChannelsMoved int64 `json:"channels_moved"`
+	GroupsDeleted int   `json:"groups_deleted"`
+}
 
-	// Apply defaults.
-	if filter.Limit <= 0 {
-		filter.Limit = 20
+// handleMergeGroups moves the channels of group_ids into target_id and
+// deletes those groups, for providers that split one category into several
+// spellings. All groups must belong to the same source.
+func (s *Server) handleMergeGroups(w http.ResponseWriter, r *http.Request) {
+	var req mergeGroupsRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
 	}
-	if filter.Limit > 200 {
-		filter.Limit = 200
+	if req.TargetID <= 0 {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("target_id is required"))
+		return
 	}
-
-	// Log active filters for debugging.
-	log.Printf("SemanticSearch q=%q source_id=%v group_id=%v media_type=%v favorite=%v limit=%d",
-		query, filter.SourceID, filter.GroupID, filter.MediaType, filter.Favorite, filter.Limit)
-
-	// Embed the query text.
-	vecs, err := s.embedder.Embed(r.Context(), []string{query}, "query")
-	if err != nil {
-		writeErr(w, http.StatusInternalServerError, fmt.Errorf("embed query: %w", err))
+	if len(req.GroupIDs) == 0 {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("group_ids must list at least one group"))
 		return
 	}
-	if len(vecs) == 0 || len(vecs[0]) == 0 {
-		writeErr(w, http.StatusInternalServerError, fmt.Errorf("empty embedding returned"))
+	slices.Sort(req.GroupIDs)
+	req.GroupIDs = slices.Compact(req.GroupIDs)
+	if slices.Contains(req.GroupIDs, req.TargetID) {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("group_ids must not contain target_id %d", req.TargetID))
 		return
 	}
 
-	results, err := s.store.SemanticSearch(r.Context(), vecs[0], filter)
+	moved, err := s.store.MergeGroups(r.Context(), req.TargetID, req.GroupIDs)
 	if err != nil {
-		writeErr(w, http.StatusInternalServerError, err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			writeErr(w, http.StatusNotFound, err)
+		case errors.Is(err, store.ErrSourceMismatch):
+			writeErr(w, http.StatusBadRequest, err)
+		default:
+			writeErr(w, http.StatusInternalServerError, err)
+		}
 		return
 	}
-	if results == nil {
-		results = []store.SemanticResult{}
-	}
 
-	writeJSON(w, http.StatusOK, map[string]any{
-		"channels": results,
-		"limit":    filter.Limit,
+	writeJSON(w, http.StatusOK, mergeGroupsResponse{
+		TargetID:      req.TargetID,
+		ChannelsMoved: moved,
+		GroupsDeleted: len(req.GroupIDs),
 	})
 }
 
-// --- group handlers ---
+// handleDeleteGroup deletes a group. Its channels are kept without a group
+// unless delete_channels=true, which deletes them too.
+func (s *Server) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+	var deleteChannels bool
+	switch v := r.URL.Query().Get("delete_channels"); v {
+	case "", "false", "0":
+	case "true", "1":
+		deleteChannels = true
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid delete_channels: %s (use true or false)", v))
+		return
+	}
 
-func (s *Server) handleListGroups(w http.ResponseWriter, r *http.Request) {
-	var sourceID *int64
-	if v := r.URL.Query().Get("source_id"); v != "" {
-		id, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid source_id: %s", v))
+	if _, err := s.store.DeleteGroup(r.Context(), groupID, deleteChannels); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("group %d not found", groupID))
 			return
 		}
-		sourceID = &id
-	}
-
-	groups, err := s.store.ListGroups(r.Context(), sourceID)
-	if err != nil {
 		writeErr(w, http.StatusInternalServerError, err)
 		return
 	}
-	if groups == nil {
-		groups = []models.Group{}
-	}
-	writeJSON(w, http.StatusOK, groups)
+
+	writeNoContent(w)
 }
 
 // --- middleware ---
 
-// withCORS adds CORS headers to every response and handles preflight OPTIONS requests.
-func withCORS(next http.Handler) http.Handler {
+// withCORS adds CORS headers to every response and handles preflight OPTIONS
+// requests. Browsers may call the API from the listed origins, or from any
+// origin when the list holds "*".
+func withCORS(origins []string, next http.Handler) http.Handler {
+	anyOrigin := slices.Contains(origins, "*")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if o := r.Header.Get("Origin"); o != "" && slices.Contains(origins, o) {
+				w.Header().Set("Access-Control-Allow-Origin", o)
+			}
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cache-Control, "+requestIDHeader)
+		w.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == http.MethodOptions {
@@ -628,18 +2424,114 @@ func withCORS(next http.Handler) http.Handler {
 	})
 }
 
-// statusWriter wraps http.ResponseWriter to capture the status code.
+// withCacheControl lets a request with "Cache-Control: no-cache" read past
+// the store's cache, e.g. to rule out stale data (see store.WithoutCache).
+func withCacheControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				r = r.WithContext(store.WithoutCache(r.Context()))
+				break
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestIDHeader carries the id that ties a request to its log entries.
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID takes the request id from the X-Request-ID header, or
+// generates one when it is missing or unusable, stores it in the request
+// context (see logging.WithRequestID) and echoes it in the response header,
+// where writeErr also picks it up.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts ids of up to 128 visible ASCII characters, so a
+// client cannot inject spaces or control characters into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// statusWriter wraps http.ResponseWriter to capture the status code and
+// the number of body bytes written.
 type statusWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
+	wrote  bool // the header has been sent
 }
 
 func (w *statusWriter) WriteHeader(code int) {
-	w.status = code
+	if !w.wrote {
+		w.status = code
+		w.wrote = true
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
-// withLogging wraps a handler and logs each request with method, path, status, and duration.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withRecovery turns a panic in a handler into a logged stack trace and a
+// 500 APIError, so one bad request neither drops the connection nor takes
+// the server down. If the handler had already started the response, the
+// connection is aborted instead, as a partial body cannot be repaired.
+// http.ErrAbortHandler is re-panicked: it is how a handler asks for that.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			sw = &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			slog.ErrorContext(r.Context(), "panic serving request", "method", r.Method, "path", r.URL.Path,
+				"panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+			if sw.wrote {
+				panic(http.ErrAbortHandler)
+			}
+			writeErr(sw, http.StatusInternalServerError, errors.New("internal error"))
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// withLogging wraps a handler and logs each request as one entry with its
+// method, path, status, duration, response size and remote address. Server
+// errors are logged at error level and client errors at warn level.
 func withLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -647,72 +2539,141 @@ func withLogging(next http.Handler) http.Handler {
 
 		next.ServeHTTP(sw, r)
 
-		duration := time.Since(start)
-		statusCode := sw.status
-
-		// Color the status code for terminal readability.
-		statusColor := colorForStatus(statusCode)
-		methodColor := colorForMethod(r.Method)
-
-		log.Printf("%s %-7s %s\x1b[0m  %s %3d %s\x1b[0m  %s",
-			methodColor, r.Method, "\x1b[0m",
-			statusColor, statusCode, "\x1b[0m",
-			formatDuration(duration),
-		)
+		level := slog.LevelInfo
+		switch {
+		case sw.status >= 500:
+			level = slog.LevelError
+		case sw.status >= 400:
+			level = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int64("bytes", sw.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
 		if r.URL.RawQuery != "" {
-			log.Printf("         %s?%s", r.URL.Path, r.URL.RawQuery)
-		} else {
-			log.Printf("         %s", r.URL.Path)
+			attrs = append(attrs, slog.String("query", r.URL.RawQuery))
 		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
 	})
 }
 
-func colorForStatus(code int) string {
-	switch {
-	case code >= 200 && code < 300:
-		return "\x1b[32m" // green
-	case code >= 300 && code < 400:
-		return "\x1b[36m" // cyan
-	case code >= 400 && code < 500:
-		return "\x1b[33m" // yellow
-	default:
-		return "\x1b[31m" // red
-	}
-}
+// --- helpers ---
 
-func colorForMethod(method string) string {
-	switch method {
-	case http.MethodGet:
-		return "\x1b[36m" // cyan
-	case http.MethodPost:
-		return "\x1b[32m" // green
-	case http.MethodPatch, http.MethodPut:
-		return "\x1b[33m" // yellow
-	case http.MethodDelete:
-		return "\x1b[31m" // red
-	default:
-		return "\x1b[37m" // white
-	}
+// APIError is the standard error envelope for all error responses.
+type APIError struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Detail    string `json:"detail,omitempty"`
+	RequestID string `json:"request_id,omitempty"` // also sent as the X-Request-ID header
+	SourceID  int64  `json:"source_id,omitempty"`  // the existing source, when adding a duplicate one
 }
 
-func formatDuration(d time.Duration) string {
-	switch {
-	case d < time.Millisecond:
-		return fmt.Sprintf("%dus", d.Microseconds())
-	case d < time.Second:
-		return fmt.Sprintf("%dms", d.Milliseconds())
-	default:
-		return fmt.Sprintf("%.2fs", d.Seconds())
+// parseChannelFilter parses the filter query parameters shared by the channel
+// list, search and export endpoints: source_id, group_id and
+// exclude_group_id (both repeatable), media_type, favorite, tvg_id, quality,
+// status, added_since and include_hidden. Pagination and endpoint-specific parameters are
+// left to the caller.
+func parseChannelFilter(q url.Values) (store.ChannelFilter, error) {
+	filter := store.ChannelFilter{TvgID: q.Get("tvg_id")}
+	if v := q.Get("source_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid source_id: %s", v)
+		}
+		filter.SourceID = &id
+	}
+	for _, v := range q["group_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid group_id: %s", v)
+		}
+		filter.GroupIDs = append(filter.GroupIDs, id)
+	}
+	for _, v := range q["exclude_group_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid exclude_group_id: %s", v)
+		}
+		filter.ExcludeGroupIDs = append(filter.ExcludeGroupIDs, id)
+	}
+	if v := q.Get("media_type"); v != "" {
+		mt, err := models.ParseMediaType(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid media_type: %w", err)
+		}
+		filter.MediaType = &mt
+	}
+	if v := q.Get("favorite"); v != "" {
+		switch v {
+		case "true", "1":
+			fav := true
+			filter.Favorite = &fav
+		case "false", "0":
+			fav := false
+			filter.Favorite = &fav
+		default:
+			return filter, fmt.Errorf("invalid favorite: %s (use true or false)", v)
+		}
+	}
+	if v := q.Get("watched"); v != "" {
+		switch v {
+		case "true", "1":
+			watched := true
+			filter.Watched = &watched
+		case "false", "0":
+			watched := false
+			filter.Watched = &watched
+		default:
+			return filter, fmt.Errorf("invalid watched: %s (use true or false)", v)
+		}
+	}
+	if v := q.Get("include_hidden"); v != "" {
+		switch v {
+		case "true", "1":
+			filter.IncludeHidden = true
+		case "false", "0":
+		default:
+			return filter, fmt.Errorf("invalid include_hidden: %s (use true or false)", v)
+		}
 	}
+	if v := q.Get("status"); v != "" {
+		switch v {
+		case models.ChannelStatusOK, models.ChannelStatusDead, models.ChannelStatusTimeout, store.StatusUnchecked:
+			filter.Status = v
+		default:
+			return filter, fmt.Errorf("invalid status: %s (use ok, dead, timeout or unchecked)", v)
+		}
+	}
+	if v := q.Get("quality"); v != "" {
+		switch qv := strings.ToUpper(v); qv {
+		case models.QualitySD, models.QualityHD, models.QualityFHD, models.Quality4K:
+			filter.Quality = qv
+		default:
+			return filter, fmt.Errorf("invalid quality: %s (use SD, HD, FHD or 4K)", v)
+		}
+	}
+	if v := q.Get("added_since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid added_since: %s (use RFC 3339, e.g. 2024-06-01T00:00:00Z)", v)
+		}
+		filter.AddedSince = &t
+	}
+	return filter, nil
 }
 
-// --- helpers ---
-
-// APIError is the standard error envelope for all error responses.
-type APIError struct {
-	Status int    `json:"status"`
-	Error  string `json:"error"`
-	Detail string `json:"detail,omitempty"`
+// parseChannelSort validates the sort query parameter of the channel list and
+// export endpoints. An empty value selects the default (by name).
+func parseChannelSort(q url.Values) (string, error) {
+	v := q.Get("sort")
+	if v != "" && !slices.Contains(store.ChannelSorts, v) {
+		return "", fmt.Errorf("invalid sort: %s (use one of %s)", v, strings.Join(store.ChannelSorts, ", "))
+	}
+	return v, nil
 }
 
 // parseID extracts a path parameter by name and parses it as int64.
@@ -729,7 +2690,7 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(v); err != nil {
-		log.Printf("writeJSON: %v", err)
+		slog.Warn("writeJSON", "err", err)
 	}
 }
 
@@ -738,13 +2699,19 @@ func writeNoContent(w http.ResponseWriter) {
 }
 
 func writeErr(w http.ResponseWriter, status int, err error) {
+	// A query cancelled by the request timeout (see withTimeout) or an
+	// upstream that did not answer in time.
+	if status >= 500 && errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
 	if status >= 500 {
-		log.Printf("ERROR %d: %v", status, err)
+		slog.Error("request failed", "status", status, "err", err, "request_id", w.Header().Get(requestIDHeader))
 	}
 	writeJSON(w, status, APIError{
-		Status: status,
-		Error:  http.StatusText(status),
-		Detail: err.Error(),
+		Status:    status,
+		Error:     http.StatusText(status),
+		Detail:    err.Error(),
+		RequestID: w.Header().Get(requestIDHeader),
 	})
 }
 
@@ -779,6 +2746,7 @@ const swaggerUIHTML = `<!DOCTYPE html>
       dom_id: "#swagger-ui",
       presets: [SwaggerUIBundle.presets.apis, SwaggerUIBundle.SwaggerUIStandalonePreset],
       layout: "BaseLayout",
+      persistAuthorization: true,
     });
   </script>
 </body>
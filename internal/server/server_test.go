@@ -0,0 +1,730 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/jobs"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// newTestServer returns a Server over an empty memory store. configure, if
+// given, adjusts the config before the routes are registered.
+func newTestServer(t testing.TB, configure ...func(*config.Config)) (*Server, *store.Memory) {
+	t.Helper()
+	cfg := &config.Config{LogoCacheDir: t.TempDir(), UserAgent: "test"}
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	mem := store.NewMemory()
+	return New(mem, cfg, nil, nil), mem
+}
+
+// request serves a request with an optional JSON body and returns the
+// recorded response.
+func request(t testing.TB, h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// decode unmarshals the JSON body of w, failing the test if it is not.
+func decode[T any](t testing.TB, w *httptest.ResponseRecorder) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	return v
+}
+
+// wantStatus fails the test unless w has the given status.
+func wantStatus(t testing.TB, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d; body %s", w.Code, status, w.Body.String())
+	}
+}
+
+// wantAPIError fails the test unless w is an APIError envelope with the
+// given status.
+func wantAPIError(t *testing.T, w *httptest.ResponseRecorder, status int) APIError {
+	t.Helper()
+	wantStatus(t, w, status)
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+	e := decode[APIError](t, w)
+	if e.Status != status || e.Error != http.StatusText(status) || e.Detail == "" {
+		t.Fatalf("error body = %+v, want status %d with a detail", e, status)
+	}
+	return e
+}
+
+// addCustomSource creates a custom source through the API.
+func addCustomSource(t testing.TB, h http.Handler, name string) models.Source {
+	t.Helper()
+	w := request(t, h, "POST", "/api/sources", fmt.Sprintf(`{"type":"custom","name":%q}`, name))
+	wantStatus(t, w, http.StatusCreated)
+	return decode[models.Source](t, w)
+}
+
+// addChannel adds a channel to a custom source through the API; body holds
+// the fields besides name and url.
+func addChannel(t testing.TB, h http.Handler, sourceID int64, name, extra string) models.Channel {
+	t.Helper()
+	body := fmt.Sprintf(`{"name":%q,"url":"http://example.com/%s"%s}`, name, strings.ReplaceAll(name, " ", "-"), extra)
+	w := request(t, h, "POST", fmt.Sprintf("/api/sources/%d/channels", sourceID), body)
+	wantStatus(t, w, http.StatusCreated)
+	return decode[models.Channel](t, w)
+}
+
+// channelPage is the body of the channel list endpoints.
+type channelPage struct {
+	Channels []models.Channel `json:"channels"`
+	Total    *int             `json:"total"`
+	Limit    int              `json:"limit"`
+	Offset   *int             `json:"offset"`
+}
+
+func channelNames(channels []models.Channel) string {
+	names := make([]string, len(channels))
+	for i, ch := range channels {
+		names[i] = ch.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSourcesCRUD(t *testing.T) {
+	srv, _ := newTestServer(t)
+
+	src := addCustomSource(t, srv, "Mine")
+	if src.ID == 0 || src.Name != "Mine" || src.SourceType != models.SourceTypeCustom {
+		t.Fatalf("created source = %+v", src)
+	}
+	wantAPIError(t, request(t, srv, "POST", "/api/sources", `{"type":"custom","name":"Mine"}`), http.StatusConflict)
+	wantAPIError(t, request(t, srv, "POST", "/api/sources", `{"type":"custom"}`), http.StatusBadRequest)
+
+	w := request(t, srv, "GET", "/api/sources", "")
+	wantStatus(t, w, http.StatusOK)
+	if list := decode[[]models.Source](t, w); len(list) != 1 || list[0].ID != src.ID {
+		t.Fatalf("sources = %+v, want only %d", list, src.ID)
+	}
+
+	path := fmt.Sprintf("/api/sources/%d", src.ID)
+	w = request(t, srv, "PATCH", path, `{"name":"Renamed"}`)
+	wantStatus(t, w, http.StatusOK)
+	w = request(t, srv, "GET", path, "")
+	wantStatus(t, w, http.StatusOK)
+	if got := decode[models.Source](t, w); got.Name != "Renamed" {
+		t.Fatalf("name after PATCH = %q, want Renamed", got.Name)
+	}
+
+	wantStatus(t, request(t, srv, "DELETE", path, ""), http.StatusNoContent)
+	wantAPIError(t, request(t, srv, "GET", path, ""), http.StatusNotFound)
+	wantAPIError(t, request(t, srv, "DELETE", path, ""), http.StatusNotFound)
+	wantAPIError(t, request(t, srv, "GET", "/api/sources/abc", ""), http.StatusBadRequest)
+}
+
+func TestListChannelsFilters(t *testing.T) {
+	srv, _ := newTestServer(t)
+	a := addCustomSource(t, srv, "A")
+	b := addCustomSource(t, srv, "B")
+	addChannel(t, srv, a.ID, "BBC One", `,"group":"News"`)
+	addChannel(t, srv, a.ID, "Alien", `,"media_type":"movie"`)
+	addChannel(t, srv, b.ID, "CNN", `,"group":"News"`)
+
+	tests := []struct {
+		query string
+		want  string
+		total int
+	}{
+		{"", "Alien,BBC One,CNN", 3},
+		{"?sort=-name", "CNN,BBC One,Alien", 3},
+		{fmt.Sprintf("?source_id=%d", a.ID), "Alien,BBC One", 2},
+		{"?media_type=movie", "Alien", 1},
+		{"?media_type=0", "BBC One,CNN", 2},
+		{"?search=bbc", "BBC One", 1},
+		{"?limit=2", "Alien,BBC One", 3},
+		{"?limit=2&offset=2", "CNN", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := request(t, srv, "GET", "/api/channels"+tt.query, "")
+			wantStatus(t, w, http.StatusOK)
+			page := decode[channelPage](t, w)
+			if got := channelNames(page.Channels); got != tt.want {
+				t.Errorf("channels = %s, want %s", got, tt.want)
+			}
+			if page.Total == nil || *page.Total != tt.total {
+				t.Errorf("total = %v, want %d", page.Total, tt.total)
+			}
+		})
+	}
+
+	// Limits are clamped as by Postgres.
+	page := decode[channelPage](t, request(t, srv, "GET", "/api/channels?limit=1000", ""))
+	if page.Limit != 200 {
+		t.Errorf("limit = %d, want 200", page.Limit)
+	}
+	page = decode[channelPage](t, request(t, srv, "GET", "/api/channels", ""))
+	if page.Limit != 50 {
+		t.Errorf("default limit = %d, want 50", page.Limit)
+	}
+
+	for _, q := range []string{"?media_type=tv", "?favorite=maybe", "?source_id=x", "?limit=x"} {
+		wantAPIError(t, request(t, srv, "GET", "/api/channels"+q, ""), http.StatusBadRequest)
+	}
+	wantAPIError(t, request(t, srv, "GET", "/api/channels/999999", ""), http.StatusNotFound)
+}
+
+func TestToggleFavorite(t *testing.T) {
+	srv, _ := newTestServer(t)
+	src := addCustomSource(t, srv, "A")
+	ch := addChannel(t, srv, src.ID, "BBC One", "")
+	addChannel(t, srv, src.ID, "CNN", "")
+
+	path := fmt.Sprintf("/api/channels/%d/favorite", ch.ID)
+	w := request(t, srv, "PATCH", path, `{"favorite":true}`)
+	wantStatus(t, w, http.StatusOK)
+	if got := decode[map[string]any](t, w); got["favorite"] != true {
+		t.Fatalf("response = %v, want favorite true", got)
+	}
+
+	page := decode[channelPage](t, request(t, srv, "GET", "/api/channels?favorite=true", ""))
+	if got := channelNames(page.Channels); got != "BBC One" {
+		t.Fatalf("favorites = %s, want BBC One", got)
+	}
+	w = request(t, srv, "GET", fmt.Sprintf("/api/channels/%d", ch.ID), "")
+	if got := decode[models.Channel](t, w); !got.Favorite {
+		t.Fatalf("channel after toggle is not a favorite")
+	}
+
+	wantStatus(t, request(t, srv, "PATCH", path, `{"favorite":false}`), http.StatusOK)
+	page = decode[channelPage](t, request(t, srv, "GET", "/api/channels?favorite=true", ""))
+	if len(page.Channels) != 0 {
+		t.Fatalf("favorites after unset = %s, want none", channelNames(page.Channels))
+	}
+
+	wantAPIError(t, request(t, srv, "PATCH", "/api/channels/999999/favorite", `{"favorite":true}`), http.StatusNotFound)
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	srv, _ := newTestServer(t)
+	tests := []struct {
+		name, method, target, body string
+		status                     int
+	}{
+		{"bad id", "GET", "/api/channels/abc", "", http.StatusBadRequest},
+		{"not found", "GET", "/api/sources/42", "", http.StatusNotFound},
+		{"malformed body", "POST", "/api/sources", `{"type":`, http.StatusBadRequest},
+		{"no route", "GET", "/api/nope", "", http.StatusNotFound},
+		{"wrong method", "PUT", "/api/sources", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantAPIError(t, request(t, srv, tt.method, tt.target, tt.body), tt.status)
+		})
+	}
+}
+
+func TestFavoritesGroupCount(t *testing.T) {
+	srv, _ := newTestServer(t)
+	src := addCustomSource(t, srv, "A")
+	news := addChannel(t, srv, src.ID, "BBC One", `,"group":"News"`)
+	hidden := addChannel(t, srv, src.ID, "CNN", "")
+	plain := addChannel(t, srv, src.ID, "Alien", "")
+	for _, ch := range []models.Channel{news, hidden, plain} {
+		wantStatus(t, request(t, srv, "PATCH", fmt.Sprintf("/api/channels/%d/favorite", ch.ID), `{"favorite":true}`), http.StatusOK)
+	}
+	wantStatus(t, request(t, srv, "PATCH", fmt.Sprintf("/api/channels/%d/hidden", hidden.ID), `{"hidden":true}`), http.StatusOK)
+	wantStatus(t, request(t, srv, "PATCH", fmt.Sprintf("/api/groups/%d", *news.GroupID), `{"hidden":true}`), http.StatusOK)
+
+	for _, tt := range []struct {
+		query string
+		count int
+	}{
+		{"?include_favorites=true", 1},
+		{"?include_favorites=true&include_hidden=true", 3},
+	} {
+		groups := decode[[]models.Group](t, request(t, srv, "GET", "/api/groups"+tt.query, ""))
+		if len(groups) == 0 || !groups[0].Virtual || groups[0].ID != 0 {
+			t.Fatalf("%s: groups = %+v, want the virtual group first", tt.query, groups)
+		}
+		if groups[0].ChannelCount != tt.count {
+			t.Errorf("%s: favorites count = %d, want %d", tt.query, groups[0].ChannelCount, tt.count)
+		}
+		page := decode[channelPage](t, request(t, srv, "GET", "/api/favorites"+strings.Replace(tt.query, "include_favorites=true", "", 1), ""))
+		if *page.Total != tt.count {
+			t.Errorf("%s: /api/favorites total = %d, want %d", tt.query, *page.Total, tt.count)
+		}
+	}
+}
+
+func TestWithRecovery(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(http.ResponseWriter, *http.Request) { panic("boom") })
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, "ok") })
+	ts := httptest.NewServer(withRecovery(mux))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+	var e APIError
+	if err := json.Unmarshal(body, &e); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+	if e.Status != http.StatusInternalServerError || e.Detail != "internal error" {
+		t.Fatalf("error body = %+v, want a 500 with detail \"internal error\"", e)
+	}
+
+	// The server keeps serving after the panic.
+	resp, err = http.Get(ts.URL + "/ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("after a panic: status %d, body %q", resp.StatusCode, body)
+	}
+}
+
+func TestWithRecoveryAborts(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"ErrAbortHandler is re-panicked", func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) }},
+		{"panic after writing aborts", func(w http.ResponseWriter, _ *http.Request) {
+			io.WriteString(w, "partial")
+			panic("boom")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			defer func() {
+				if p := recover(); p != http.ErrAbortHandler {
+					t.Fatalf("recovered %v, want http.ErrAbortHandler", p)
+				}
+				if strings.Contains(w.Body.String(), "internal error") {
+					t.Fatalf("error body written: %q", w.Body.String())
+				}
+			}()
+			withRecovery(tt.handler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		})
+	}
+}
+
+// uploadPlaylist uploads an M3U playlist under name through the API.
+func uploadPlaylist(t testing.TB, h http.Handler, name, playlist string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", name)
+	fw, _ := mw.CreateFormFile("file", "playlist.m3u")
+	io.WriteString(fw, playlist)
+	mw.Close()
+	return request(t, h, "POST", "/api/sources/upload", body.String(), "Content-Type", mw.FormDataContentType())
+}
+
+func TestUploadSourceName(t *testing.T) {
+	srv, mem := newTestServer(t)
+	custom := addCustomSource(t, srv, "Custom")
+	addChannel(t, srv, custom.ID, "Mine", "")
+	playlist := "#EXTM3U\n#EXTINF:-1,BBC One\nhttp://example.com/bbc\n"
+
+	// The name of a custom or URL source is not taken over.
+	e := wantAPIError(t, uploadPlaylist(t, srv, "Custom", playlist), http.StatusConflict)
+	if e.SourceID != custom.ID {
+		t.Errorf("conflict source_id = %d, want %d", e.SourceID, custom.ID)
+	}
+	linked, err := mem.CreateOrGetSource(t.Context(), "Linked", "http://example.com/list.m3u", models.SourceTypeM3ULink, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAPIError(t, uploadPlaylist(t, srv, "Linked", playlist), http.StatusConflict)
+	page := decode[channelPage](t, request(t, srv, "GET", fmt.Sprintf("/api/channels?source_id=%d", custom.ID), ""))
+	if got := channelNames(page.Channels); got != "Mine" {
+		t.Fatalf("custom source channels after a refused upload = %s, want Mine", got)
+	}
+	if src, _ := mem.GetSourceByID(t.Context(), linked); src.SourceType != models.SourceTypeM3ULink {
+		t.Fatalf("linked source type = %d after a refused upload", src.SourceType)
+	}
+
+	// Uploading again under an upload's name replaces its channels.
+	w := uploadPlaylist(t, srv, "Uploaded", playlist)
+	wantStatus(t, w, http.StatusCreated)
+	first := decode[struct {
+		SourceID int64 `json:"source_id"`
+	}](t, w).SourceID
+	w = uploadPlaylist(t, srv, "Uploaded", "#EXTM3U\n#EXTINF:-1,CNN\nhttp://example.com/cnn\n")
+	wantStatus(t, w, http.StatusCreated)
+	if again := decode[struct {
+		SourceID int64 `json:"source_id"`
+	}](t, w).SourceID; again != first {
+		t.Fatalf("second upload made source %d, want %d", again, first)
+	}
+	page = decode[channelPage](t, request(t, srv, "GET", fmt.Sprintf("/api/channels?source_id=%d", first), ""))
+	if got := channelNames(page.Channels); got != "CNN" {
+		t.Fatalf("channels after a second upload = %s, want CNN", got)
+	}
+}
+
+func TestHDHomeRun(t *testing.T) {
+	srv, mem := newTestServer(t, func(cfg *config.Config) {
+		cfg.HDHREnabled = true
+		cfg.HDHRDeviceID = "12345678"
+		cfg.HDHRTunerCount = 4
+	})
+	ctx := t.Context()
+	sourceID, err := mem.CreateCustomSource(ctx, "TV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := mem.CreateCustomSource(ctx, "Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intp := func(n int) *int { return &n }
+	strp := func(s string) *string { return &s }
+	channels := []models.Channel{
+		{Name: "BBC One HD", URL: "http://example.com/bbc", Number: intp(101), Quality: strp(models.QualityHD), DisplayName: strp("BBC One"), Favorite: true},
+		{Name: "CNN", URL: "http://example.com/cnn", Number: intp(7), Quality: strp(models.QualitySD)},
+		{Name: "Unnumbered", URL: "http://example.com/un"},
+		{Name: "Heat", URL: "http://example.com/heat.mkv", MediaType: models.MediaTypeMovie, Number: intp(1)},
+	}
+	ids := make(map[string]int64)
+	for _, ch := range channels {
+		ch.SourceID = sourceID
+		id, err := mem.UpsertChannel(ctx, &ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[ch.Name] = id
+	}
+	ids["Elsewhere"], err = mem.UpsertChannel(ctx, &models.Channel{Name: "Elsewhere", URL: "http://example.com/else", SourceID: other, Number: intp(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := request(t, srv, "GET", "/discover.json", "", "X-Forwarded-Proto", "https")
+	wantStatus(t, w, http.StatusOK)
+	d := decode[hdhrDiscover](t, w)
+	if d.DeviceID != "12345678" || d.TunerCount != 4 || d.BaseURL != "https://example.com" || d.LineupURL != "https://example.com/lineup.json" {
+		t.Fatalf("discover = %+v", d)
+	}
+	w = request(t, srv, "GET", "/lineup_status.json", "")
+	wantStatus(t, w, http.StatusOK)
+	if st := decode[map[string]any](t, w); st["ScanInProgress"] != float64(0) {
+		t.Fatalf("lineup status = %v", st)
+	}
+
+	// Live channels only, in channel number order with unnumbered ones last.
+	w = request(t, srv, "GET", "/lineup.json", "")
+	wantStatus(t, w, http.StatusOK)
+	want := []hdhrLineupEntry{
+		{GuideNumber: "2", GuideName: "Elsewhere", URL: fmt.Sprintf("http://example.com/api/channels/%d/stream", ids["Elsewhere"])},
+		{GuideNumber: "7", GuideName: "CNN", URL: fmt.Sprintf("http://example.com/api/channels/%d/stream", ids["CNN"])},
+		{GuideNumber: "101", GuideName: "BBC One", URL: fmt.Sprintf("http://example.com/api/channels/%d/stream", ids["BBC One HD"]), HD: 1},
+		{GuideNumber: strconv.FormatInt(ids["Unnumbered"], 10), GuideName: "Unnumbered", URL: fmt.Sprintf("http://example.com/api/channels/%d/stream", ids["Unnumbered"])},
+	}
+	if got := decode[[]hdhrLineupEntry](t, w); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("lineup = %+v\nwant %+v", got, want)
+	}
+
+	// The stream URL redirects to the channel's upstream one.
+	w = request(t, srv, "GET", fmt.Sprintf("/api/channels/%d/stream", ids["CNN"]), "")
+	wantStatus(t, w, http.StatusFound)
+	if loc := w.Header().Get("Location"); loc != "http://example.com/cnn" {
+		t.Fatalf("stream redirect = %q", loc)
+	}
+	wantAPIError(t, request(t, srv, "GET", "/api/channels/999999/stream", ""), http.StatusNotFound)
+
+	// The HDHR_* filters narrow the lineup.
+	srv.cfg.HDHRSourceID = sourceID
+	srv.cfg.HDHRFavoritesOnly = true
+	lineup := decode[[]hdhrLineupEntry](t, request(t, srv, "GET", "/lineup.json", ""))
+	if len(lineup) != 1 || lineup[0].GuideName != "BBC One" {
+		t.Fatalf("favorites-only lineup = %+v, want BBC One", lineup)
+	}
+
+	// Without HDHR_ENABLED the routes do not exist.
+	srv, _ = newTestServer(t)
+	wantStatus(t, request(t, srv, "GET", "/lineup.json", ""), http.StatusNotFound)
+}
+
+// searchResponse is the body of /api/channels/search.
+type searchResponse struct {
+	Channels []store.SemanticResult `json:"channels"`
+	Total    int                    `json:"total"`
+	Mode     string                 `json:"mode"`
+}
+
+func TestSearchChannels(t *testing.T) {
+	// A fake embeddings API that embeds every query as the first unit vector.
+	var queries atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries.Add(1)
+		vec := make([]float32, embedding.Dimensions)
+		vec[0] = 1
+		json.NewEncoder(w).Encode(map[string]any{
+			"data":  []map[string]any{{"embedding": vec, "index": 0}},
+			"usage": map[string]int{"total_tokens": 1},
+		})
+	}))
+	t.Cleanup(api.Close)
+	embedder, err := embedding.NewClient("key", "", embedding.Options{URL: api.URL, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := store.NewMemory()
+	cfg := &config.Config{LogoCacheDir: t.TempDir(), UserAgent: "test"}
+	semantic := New(mem, cfg, embedder, nil)
+	lexical, _ := newTestServer(t)
+	lexical.store = mem
+
+	// The channels go in through the lexical server, which has no embedder
+	// to embed them in the background.
+	src := addCustomSource(t, lexical, "TV")
+	other := addCustomSource(t, lexical, "Other")
+	news := addChannel(t, lexical, src.ID, "BBC News", "")
+	sport := addChannel(t, lexical, src.ID, "BBC Sport", `,"media_type":"movie"`)
+	addChannel(t, lexical, other.ID, "BBC Other", "")
+	vecs := make([][]float32, 2)
+	for i := range vecs {
+		vecs[i] = make([]float32, embedding.Dimensions)
+		vecs[i][i] = 1
+	}
+	// News points the query's way; Sport is orthogonal to it.
+	err = mem.StoreEmbeddings(t.Context(), []int64{news.ID, sport.ID}, vecs, embedder.Model(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		srv    *Server
+		query  string
+		mode   string
+		names  string
+		called int32 // embedding requests made
+	}{
+		{"semantic", semantic, "q=bbc", "semantic", "BBC News,BBC Sport", 1},
+		{"semantic filtered", semantic, "q=bbc&media_type=movie", "semantic", "BBC Sport", 1},
+		{"explicit lexical", semantic, "q=bbc&mode=lexical", "lexical", "BBC News,BBC Other,BBC Sport", 0},
+		{"no embedder", lexical, "q=bbc", "lexical", "BBC News,BBC Other,BBC Sport", 0},
+		{"no embedder, hybrid asked", lexical, "q=bbc&mode=hybrid", "lexical", "BBC News,BBC Other,BBC Sport", 0},
+		{"no embedder, source filter", lexical, fmt.Sprintf("q=bbc&source_id=%d", other.ID), "lexical", "BBC Other", 0},
+		{"no embedder, media type", lexical, "q=bbc&media_type=movie", "lexical", "BBC Sport", 0},
+		{"no embedder, limit", lexical, "q=bbc&limit=1", "lexical", "BBC News", 0},
+		{"no embedder, no match", lexical, "q=cnn", "lexical", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries.Store(0)
+			w := request(t, tt.srv, "GET", "/api/channels/search?"+tt.query, "")
+			wantStatus(t, w, http.StatusOK)
+			resp := decode[searchResponse](t, w)
+			if resp.Mode != tt.mode {
+				t.Errorf("mode = %q, want %q", resp.Mode, tt.mode)
+			}
+			channels := make([]models.Channel, len(resp.Channels))
+			for i, r := range resp.Channels {
+				channels[i] = r.Channel
+			}
+			if got := channelNames(channels); got != tt.names {
+				t.Errorf("channels = %s, want %s", got, tt.names)
+			}
+			if got := queries.Load(); got != tt.called {
+				t.Errorf("embedding requests = %d, want %d", got, tt.called)
+			}
+		})
+	}
+
+	wantAPIError(t, request(t, lexical, "GET", "/api/channels/search", ""), http.StatusBadRequest)
+	wantAPIError(t, request(t, lexical, "GET", "/api/channels/search?q=bbc&mode=fuzzy", ""), http.StatusBadRequest)
+}
+
+func TestUpdateSource(t *testing.T) {
+	srv, mem := newTestServer(t)
+	ctx := t.Context()
+	id, err := mem.CreateOrGetSource(ctx, "List", "http://example.com/list.m3u", models.SourceTypeM3ULink, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addCustomSource(t, srv, "Taken")
+	path := fmt.Sprintf("/api/sources/%d", id)
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		refresh bool // the validators are cleared, so the next refresh re-ingests
+		check   func(src models.Source) bool
+	}{
+		{"empty", `{}`, http.StatusOK, false, func(src models.Source) bool { return src.Name == "List" }},
+		{"name", `{"name":"Renamed"}`, http.StatusOK, false, func(src models.Source) bool { return src.Name == "Renamed" }},
+		{"user agent", `{"user_agent":"Kodi/20"}`, http.StatusOK, false, func(src models.Source) bool { return src.UserAgent == "Kodi/20" }},
+		{"use_tvg_id unchanged", `{"use_tvg_id":true}`, http.StatusOK, false, func(src models.Source) bool { return *src.UseTvgID }},
+		{"use_tvg_id", `{"use_tvg_id":false}`, http.StatusOK, true, func(src models.Source) bool { return !*src.UseTvgID }},
+		{"url", `{"url":"https://example.com/new.m3u"}`, http.StatusOK, true, func(src models.Source) bool { return src.URL == "https://example.com/new.m3u" }},
+		{"url and use_tvg_id", `{"url":"https://example.com/other.m3u","use_tvg_id":false}`, http.StatusOK, true, func(src models.Source) bool {
+			return src.URL == "https://example.com/other.m3u" && !*src.UseTvgID
+		}},
+		{"name and user agent", `{"name":"Both","user_agent":"VLC"}`, http.StatusOK, false, func(src models.Source) bool {
+			return src.Name == "Both" && src.UserAgent == "VLC"
+		}},
+		{"not a URL", `{"url":"notaurl"}`, http.StatusBadRequest, false, nil},
+		{"relative URL", `{"url":"/list.m3u"}`, http.StatusBadRequest, false, nil},
+		{"ftp URL", `{"url":"ftp://example.com/list.m3u"}`, http.StatusBadRequest, false, nil},
+		{"bad URL with valid fields", `{"url":"notaurl","use_tvg_id":false}`, http.StatusBadRequest, false, nil},
+		{"empty name", `{"name":"  "}`, http.StatusBadRequest, false, nil},
+		{"use_tvg_id not a bool", `{"use_tvg_id":"yes"}`, http.StatusBadRequest, false, nil},
+		{"name taken", `{"name":"Taken"}`, http.StatusConflict, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Start from an ingested source using tvg-id names.
+			useTvgID := true
+			err := mem.UpdateSource(ctx, id, store.SourceUpdate{UseTvgID: &useTvgID})
+			if err == nil {
+				err = mem.UpdateSourceValidators(ctx, id, `"v1"`, "", "hash", 0)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, _ := mem.GetSourceByID(ctx, id)
+
+			w := request(t, srv, "PATCH", path, tt.body)
+			if tt.status != http.StatusOK {
+				wantAPIError(t, w, tt.status)
+				after, _ := mem.GetSourceByID(ctx, id)
+				if after.Name != before.Name || after.URL != before.URL || *after.UseTvgID != *before.UseTvgID || after.ContentHash == "" {
+					t.Fatalf("source changed by a refused PATCH: %+v", after)
+				}
+				return
+			}
+			wantStatus(t, w, http.StatusOK)
+			if src := decode[models.Source](t, w); !tt.check(src) {
+				t.Fatalf("source after PATCH %s = %+v", tt.body, src)
+			}
+			after, _ := mem.GetSourceByID(ctx, id)
+			if cleared := after.ContentHash == "" && after.ETag == ""; cleared != tt.refresh {
+				t.Fatalf("validators cleared = %t, want %t", cleared, tt.refresh)
+			}
+		})
+	}
+
+	wantAPIError(t, request(t, srv, "PATCH", "/api/sources/999999", `{"use_tvg_id":false}`), http.StatusNotFound)
+}
+
+// waitJob polls GET /api/jobs/{id} until the job has finished.
+func waitJob(t *testing.T, h http.Handler, id string) jobs.Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := request(t, h, "GET", "/api/jobs/"+id, "")
+		wantStatus(t, w, http.StatusOK)
+		st := decode[jobs.Status](t, w)
+		if st.State.Finished() {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, st.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAddSourceOptions(t *testing.T) {
+	var userAgent atomic.Value
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent.Store(r.Header.Get("User-Agent"))
+		io.WriteString(w, "#EXTM3U\n#EXTINF:-1 tvg-id=\"bbc1.uk\",BBC One\nhttp://example.com/bbc\n")
+	}))
+	t.Cleanup(upstream.Close)
+
+	tests := []struct {
+		name, body string
+		userAgent  string // sent to the upstream
+		channel    string // name the entry is stored under
+		useTvgID   bool
+	}{
+		{"defaults", `"name":"Defaults","url":%q`, "test", "bbc1.uk", true},
+		{"custom user agent", `"name":"UA","url":%q,"user_agent":"Kodi/20.0"`, "Kodi/20.0", "bbc1.uk", true},
+		{"title names", `"name":"Titles","url":%q,"use_tvg_id":false`, "test", "BBC One", false},
+		{"both", `"name":"Both","url":%q,"user_agent":" VLC/3.0 ","use_tvg_id":true`, "VLC/3.0", "bbc1.uk", true},
+	}
+	srv, mem := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := "{" + fmt.Sprintf(tt.body, upstream.URL) + "}"
+			w := request(t, srv, "POST", "/api/sources?force=true", body)
+			wantStatus(t, w, http.StatusAccepted)
+			st := waitJob(t, srv, decode[struct {
+				JobID string `json:"job_id"`
+			}](t, w).JobID)
+			if st.State != jobs.StateDone {
+				t.Fatalf("job = %+v", st)
+			}
+			if got, _ := userAgent.Load().(string); got != tt.userAgent {
+				t.Errorf("upstream User-Agent = %q, want %q", got, tt.userAgent)
+			}
+			src, err := mem.GetSourceByID(t.Context(), st.SourceID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src.UserAgent != tt.userAgent || src.UseTvgID == nil || *src.UseTvgID != tt.useTvgID {
+				t.Errorf("source user agent %q, use_tvg_id %v, want %q, %t", src.UserAgent, src.UseTvgID, tt.userAgent, tt.useTvgID)
+			}
+			page := decode[channelPage](t, request(t, srv, "GET", fmt.Sprintf("/api/channels?source_id=%d", src.ID), ""))
+			if got := channelNames(page.Channels); got != tt.channel {
+				t.Errorf("channels = %s, want %s", got, tt.channel)
+			}
+		})
+	}
+
+	bad := []string{
+		`{"name":"Bad","url":"http://example.com/x.m3u","user_agent":"a\r\nX-Injected: 1"}`,
+		`{"name":"Bad","url":"http://example.com/x.m3u","use_tvg_id":"yes"}`,
+		`{"type":"custom","name":"Bad","user_agent":"Kodi"}`,
+		`{"type":"custom","name":"Bad","use_tvg_id":false}`,
+	}
+	for _, body := range bad {
+		wantAPIError(t, request(t, srv, "POST", "/api/sources", body), http.StatusBadRequest)
+	}
+}
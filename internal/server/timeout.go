@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// untimedRoutes are the routes that may legitimately outlast a request
+// timeout: synchronous refreshes, uploads, event streams and exports that
+// write the response as they read it.
+var untimedRoutes = map[string]bool{
+	"POST /api/sources/upload":             true,
+	"POST /api/sources/refresh":            true,
+	"POST /api/sources/{id}/refresh":       true,
+	"GET /api/sources/{id}/refresh/events": true,
+	"GET /api/sources/{id}/playlist.m3u":   true,
+	"GET /api/playlist.m3u":                true,
+	"GET /api/playlists/{id}/playlist.m3u": true,
+	"GET /api/favorites/playlist.m3u":      true,
+	"GET /player_api.php":                  true,
+	"POST /player_api.php":                 true,
+	"GET /lineup.json":                     true,
+}
+
+// withTimeout gives each request a deadline, REQUEST_TIMEOUT for GET and
+// HEAD requests and REQUEST_WRITE_TIMEOUT for the others, so that a
+// pathological query is cancelled in Postgres instead of holding its
+// connection until the write timeout. Handlers report the cancelled query
+// as 504 through writeErr. untimedRoutes are left alone.
+func (s *Server) withTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := s.cfg.RequestWriteTimeout
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			timeout = s.cfg.RequestTimeout
+		}
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := s.mux.Handler(r); untimedRoutes[pattern] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/config"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// blockingStore holds channel reads until their context is done, as a
+// query Postgres cancels would, and records how each ended.
+type blockingStore struct {
+	*store.Memory
+	ended chan error // ctx.Err() of each held call
+	timed chan bool  // whether each call had a deadline
+}
+
+func (s *blockingStore) hold(ctx context.Context) error {
+	_, ok := ctx.Deadline()
+	s.timed <- ok
+	if !ok {
+		// Let untimed calls through, or the test would hang.
+		s.ended <- nil
+		return nil
+	}
+	<-ctx.Done()
+	s.ended <- ctx.Err()
+	return ctx.Err()
+}
+
+func (s *blockingStore) ListChannels(ctx context.Context, filter store.ChannelFilter) ([]models.Channel, int, error) {
+	if err := s.hold(ctx); err != nil {
+		return nil, 0, err
+	}
+	return s.Memory.ListChannels(ctx, filter)
+}
+
+func (s *blockingStore) StreamChannels(ctx context.Context, filter store.ChannelFilter, fn func(*models.Channel, *models.ChannelHttpHeaders) error) error {
+	if err := s.hold(ctx); err != nil {
+		return err
+	}
+	return s.Memory.StreamChannels(ctx, filter, fn)
+}
+
+func (s *blockingStore) CreateCustomSource(ctx context.Context, name string) (int64, error) {
+	if err := s.hold(ctx); err != nil {
+		return 0, err
+	}
+	return s.Memory.CreateCustomSource(ctx, name)
+}
+
+func TestWithTimeout(t *testing.T) {
+	tests := []struct {
+		name, method, target, body string
+		read, write                time.Duration
+		status                     int
+		timed                      bool
+	}{
+		{"read times out", "GET", "/api/channels", "", 20 * time.Millisecond, time.Hour, http.StatusGatewayTimeout, true},
+		{"write times out", "POST", "/api/sources", `{"type":"custom","name":"A"}`, time.Hour, 20 * time.Millisecond, http.StatusGatewayTimeout, true},
+		{"export opts out", "GET", "/api/playlist.m3u", "", 20 * time.Millisecond, 20 * time.Millisecond, http.StatusOK, false},
+		{"timeouts disabled", "GET", "/api/channels", "", 0, 0, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &blockingStore{Memory: store.NewMemory(), ended: make(chan error, 1), timed: make(chan bool, 1)}
+			cfg := &config.Config{LogoCacheDir: t.TempDir(), UserAgent: "test", RequestTimeout: tt.read, RequestWriteTimeout: tt.write}
+			srv := New(s, cfg, nil, nil)
+
+			start := time.Now()
+			w := request(t, srv, tt.method, tt.target, tt.body)
+			if d := time.Since(start); d > 2*time.Second {
+				t.Fatalf("request took %v", d)
+			}
+			if timed := <-s.timed; timed != tt.timed {
+				t.Errorf("store call had a deadline = %t, want %t", timed, tt.timed)
+			}
+			err := <-s.ended
+			if tt.status != http.StatusGatewayTimeout {
+				if w.Code/100 != 2 {
+					t.Fatalf("status = %d, want success; body %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			// The store saw the deadline fire, and the client got the
+			// error envelope rather than a partial response.
+			if err != context.DeadlineExceeded {
+				t.Errorf("store call ended with %v, want context.DeadlineExceeded", err)
+			}
+			e := wantAPIError(t, w, http.StatusGatewayTimeout)
+			if !strings.Contains(e.Detail, "deadline exceeded") {
+				t.Errorf("detail = %q", e.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// --- watch history handlers ---
+
+// watchSessionGap is how long a channel may go without a position report
+// before the next report starts a new watch event.
+const watchSessionGap = 30 * time.Minute
+
+type recordWatchRequest struct {
+	Position *int `json:"position"` // seconds from the start
+	Duration *int `json:"duration"` // seconds; left out for live streams
+}
+
+// handleRecordWatch stores the playback position of a channel. Players call
+// it periodically while playing; reports less than watchSessionGap apart
+// update the same watch event.
+func (s *Server) handleRecordWatch(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var req recordWatchRequest
+	if status, err := s.decodeJSON(w, r, &req); err != nil {
+		writeErr(w, status, err)
+		return
+	}
+	if req.Position == nil || *req.Position < 0 {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("position must be a number of seconds, 0 or more"))
+		return
+	}
+	if req.Duration != nil && *req.Duration <= 0 {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("duration must be a positive number of seconds"))
+		return
+	}
+
+	e, started, err := s.store.RecordWatch(r.Context(), channelID, *req.Position, req.Duration, time.Now().Add(-watchSessionGap))
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusOK
+	if started {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, e)
+}
+
+// handleGetWatch returns where playback of a channel last stopped. A channel
+// never watched gets position 0.
+func (s *Server) handleGetWatch(w http.ResponseWriter, r *http.Request) {
+	channelID, err := parseID(r, "id")
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, err)
+		return
+	}
+
+	e, err := s.store.GetWatchPosition(r.Context(), channelID)
+	if err == nil && e == nil {
+		if _, err = s.store.GetChannelByID(r.Context(), channelID); err == nil {
+			e = &models.WatchEvent{ChannelID: channelID}
+		}
+	}
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, fmt.Errorf("channel %d not found", channelID))
+			return
+		}
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleWatchHistory lists recently watched channels with their latest watch
+// event, most recent first. With in_progress=true it lists only unfinished
+// ones with a position to resume from, for "continue watching" rows.
+func (s *Server) handleWatchHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 20
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		limit = min(n, 100)
+	}
+	var inProgress bool
+	switch v := q.Get("in_progress"); v {
+	case "", "false", "0":
+	case "true", "1":
+		inProgress = true
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid in_progress: %s (use true or false)", v))
+		return
+	}
+
+	entries, err := s.store.ListWatchHistory(r.Context(), limit, inProgress)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	if entries == nil {
+		entries = []models.WatchHistoryEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
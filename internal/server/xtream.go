@@ -0,0 +1,344 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// --- Xtream Codes emulation ---
+//
+// IPTV apps such as TiviMate and IPTV Smarters speak the Xtream Codes
+// player API. player_api.php answers its catalogue actions from the stored
+// channels: groups become categories, media types become the live, movie
+// and series stream types, and channel ids become stream ids. Apps build
+// stream URLs as /{live|movie|series}/{user}/{pass}/{id}.{ext}, which
+// redirect to the channel's stream. The password is an API token and the
+// username is not checked; without API tokens any credentials log in, as
+// the API is then open.
+
+// xtreamCategory is an entry of get_*_categories.
+type xtreamCategory struct {
+	CategoryID   string `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	ParentID     int    `json:"parent_id"`
+}
+
+// xtreamStream is an entry of get_live_streams and get_vod_streams.
+type xtreamStream struct {
+	Num                int     `json:"num"`
+	Name               string  `json:"name"`
+	StreamType         string  `json:"stream_type"`
+	StreamID           int64   `json:"stream_id"`
+	StreamIcon         string  `json:"stream_icon"`
+	EPGChannelID       *string `json:"epg_channel_id"`
+	Added              string  `json:"added"`
+	CategoryID         string  `json:"category_id"`
+	CustomSID          string  `json:"custom_sid"`
+	DirectSource       string  `json:"direct_source"`
+	ContainerExtension string  `json:"container_extension,omitempty"` // movies only
+	TVArchive          int     `json:"tv_archive"`
+}
+
+// xtreamSeries is an entry of get_series.
+type xtreamSeries struct {
+	Num        int    `json:"num"`
+	Name       string `json:"name"`
+	SeriesID   int64  `json:"series_id"`
+	Cover      string `json:"cover"`
+	Plot       string `json:"plot"`
+	Genre      string `json:"genre"`
+	CategoryID string `json:"category_id"`
+}
+
+// xtreamEpisode is an entry of get_series_info's episode lists.
+type xtreamEpisode struct {
+	ID                 string `json:"id"`
+	EpisodeNum         int    `json:"episode_num"`
+	Title              string `json:"title"`
+	ContainerExtension string `json:"container_extension"`
+	Season             int    `json:"season"`
+	DirectSource       string `json:"direct_source"`
+}
+
+// Xtream stream types by media type.
+var xtreamStreamTypes = map[int16]string{
+	models.MediaTypeLivestream: "live",
+	models.MediaTypeMovie:      "movie",
+	models.MediaTypeSerie:      "series",
+}
+
+func (s *Server) handleXtreamAPI(w http.ResponseWriter, r *http.Request) {
+	if !s.xtreamAuth(r.FormValue("password")) {
+		// Xtream servers answer bad credentials with auth 0, not an HTTP error.
+		writeJSON(w, http.StatusOK, map[string]any{"user_info": map[string]any{"auth": 0}})
+		return
+	}
+
+	switch action := r.FormValue("action"); action {
+	case "":
+		s.xtreamAccountInfo(w, r)
+	case "get_live_categories":
+		s.xtreamCategories(w, r, models.MediaTypeLivestream)
+	case "get_vod_categories":
+		s.xtreamCategories(w, r, models.MediaTypeMovie)
+	case "get_series_categories":
+		s.xtreamCategories(w, r, models.MediaTypeSerie)
+	case "get_live_streams":
+		s.xtreamStreams(w, r, models.MediaTypeLivestream)
+	case "get_vod_streams":
+		s.xtreamStreams(w, r, models.MediaTypeMovie)
+	case "get_series":
+		s.xtreamSeriesList(w, r)
+	case "get_series_info":
+		s.xtreamSeriesInfo(w, r)
+	default:
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("unsupported action: %s", action))
+	}
+}
+
+// xtreamAuth reports whether pass is a read-write or read-only API token,
+// or whether no tokens are configured. The Xtream API only reads, so either
+// kind will do.
+func (s *Server) xtreamAuth(pass string) bool {
+	if len(s.cfg.APITokens) == 0 && len(s.cfg.APIReadTokens) == 0 {
+		return true
+	}
+	return matchToken(s.cfg.APITokens, pass) || matchToken(s.cfg.APIReadTokens, pass)
+}
+
+// xtreamAccountInfo answers the login request apps send first.
+func (s *Server) xtreamAccountInfo(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	scheme, host, _ := strings.Cut(base, "://")
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	now := time.Now().UTC()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user_info": map[string]any{
+			"username":               r.FormValue("username"),
+			"password":               r.FormValue("password"),
+			"auth":                   1,
+			"status":                 "Active",
+			"exp_date":               nil,
+			"is_trial":               "0",
+			"active_cons":            "0",
+			"max_connections":        "1",
+			"allowed_output_formats": []string{"m3u8", "ts"},
+		},
+		"server_info": map[string]any{
+			"url":             hostname,
+			"port":            port,
+			"https_port":      port,
+			"server_protocol": scheme,
+			"timezone":        "UTC",
+			"timestamp_now":   now.Unix(),
+			"time_now":        now.Format(time.DateTime),
+		},
+	})
+}
+
+// xtreamCategories lists the groups that contain channels of mediaType.
+func (s *Server) xtreamCategories(w http.ResponseWriter, r *http.Request, mediaType int16) {
+	used := map[int64]bool{}
+	err := s.store.StreamChannels(r.Context(), store.ChannelFilter{MediaType: &mediaType}, func(ch *models.Channel, _ *models.ChannelHttpHeaders) error {
+		if ch.GroupID != nil {
+			used[*ch.GroupID] = true
+		}
+		return nil
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	groups, err := s.store.ListGroups(r.Context(), nil)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	categories := []xtreamCategory{}
+	for _, g := range groups {
+		if used[g.ID] {
+			categories = append(categories, xtreamCategory{CategoryID: strconv.FormatInt(g.ID, 10), CategoryName: g.Name})
+		}
+	}
+	writeJSON(w, http.StatusOK, categories)
+}
+
+// xtreamStreams lists the channels of mediaType, optionally of one category.
+func (s *Server) xtreamStreams(w http.ResponseWriter, r *http.Request, mediaType int16) {
+	filter := store.ChannelFilter{MediaType: &mediaType, Sort: store.SortNumber}
+	if v := r.FormValue("category_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid category_id: %s", v))
+			return
+		}
+		filter.GroupID = &id
+	}
+
+	base := baseURL(r)
+	streams := []xtreamStream{}
+	err := s.store.StreamChannels(r.Context(), filter, func(ch *models.Channel, _ *models.ChannelHttpHeaders) error {
+		st := xtreamStream{
+			Num:          len(streams) + 1,
+			Name:         channelTitle(ch),
+			StreamType:   xtreamStreamTypes[mediaType],
+			StreamID:     ch.ID,
+			EPGChannelID: ch.TvgID,
+			DirectSource: s.channelURL(base, ch.ID, "stream"),
+		}
+		if ch.Image != nil {
+			st.StreamIcon = *ch.Image
+		}
+		if ch.GroupID != nil {
+			st.CategoryID = strconv.FormatInt(*ch.GroupID, 10)
+		}
+		if mediaType == models.MediaTypeMovie {
+			st.ContainerExtension = containerExtension(ch.URL, "mp4")
+		}
+		streams = append(streams, st)
+		return nil
+	})
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, streams)
+}
+
+// xtreamSeriesList lists the recognised series. Series have no id of their
+// own, so a hash of the name stands in as series_id.
+func (s *Server) xtreamSeriesList(w http.ResponseWriter, r *http.Request) {
+	series, err := s.store.ListSeries(r.Context(), nil)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	out := make([]xtreamSeries, 0, len(series))
+	for i, se := range series {
+		xs := xtreamSeries{Num: i + 1, Name: se.Name, SeriesID: xtreamSeriesID(se.Name)}
+		if se.Image != nil {
+			xs.Cover = *se.Image
+		}
+		out = append(out, xs)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// xtreamSeriesInfo lists a series' episodes grouped by season.
+func (s *Server) xtreamSeriesInfo(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("series_id"), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid series_id: %s", r.FormValue("series_id")))
+		return
+	}
+	series, err := s.store.ListSeries(r.Context(), nil)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	var found *models.Series
+	for i := range series {
+		if xtreamSeriesID(series[i].Name) == id {
+			found = &series[i]
+			break
+		}
+	}
+	if found == nil {
+		writeErr(w, http.StatusNotFound, fmt.Errorf("series %d not found", id))
+		return
+	}
+
+	episodes, err := s.store.ListSeriesEpisodes(r.Context(), found.Name, nil)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, err)
+		return
+	}
+	base := baseURL(r)
+	bySeason := map[string][]xtreamEpisode{}
+	for _, ch := range episodes {
+		season, episode := 0, 0
+		if ch.Season != nil {
+			season = *ch.Season
+		}
+		if ch.Episode != nil {
+			episode = *ch.Episode
+		}
+		key := strconv.Itoa(season)
+		bySeason[key] = append(bySeason[key], xtreamEpisode{
+			ID:                 strconv.FormatInt(ch.ID, 10),
+			EpisodeNum:         episode,
+			Title:              channelTitle(&ch),
+			ContainerExtension: containerExtension(ch.URL, "mp4"),
+			Season:             season,
+			DirectSource:       s.channelURL(base, ch.ID, "stream"),
+		})
+	}
+	cover := ""
+	if found.Image != nil {
+		cover = *found.Image
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"seasons":  []any{},
+		"info":     map[string]any{"name": found.Name, "cover": cover},
+		"episodes": bySeason,
+	})
+}
+
+// handleXtreamStream serves /{live|movie|series}/{user}/{pass}/{id}.{ext} by
+// redirecting to the channel's stream.
+func (s *Server) handleXtreamStream(w http.ResponseWriter, r *http.Request) {
+	if !s.xtreamAuth(r.PathValue("pass")) {
+		writeErr(w, http.StatusUnauthorized, fmt.Errorf("invalid credentials"))
+		return
+	}
+	file := r.PathValue("file")
+	id, err := strconv.ParseInt(strings.TrimSuffix(file, path.Ext(file)), 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, fmt.Errorf("invalid stream id: %s", file))
+		return
+	}
+	s.redirectToStream(w, r, id)
+}
+
+// xtreamSeriesID derives a stable positive id from a series name.
+func xtreamSeriesID(name string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int64(h.Sum32() & 0x7fffffff)
+}
+
+// channelTitle returns the display name of ch if it has one, else its name.
+func channelTitle(ch *models.Channel) string {
+	if ch.DisplayName != nil && *ch.DisplayName != "" {
+		return *ch.DisplayName
+	}
+	return ch.Name
+}
+
+// containerExtension returns the file extension of a stream URL without the
+// dot, or def if it has none.
+func containerExtension(rawURL, def string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if ext := strings.TrimPrefix(path.Ext(rawURL), "."); ext != "" && len(ext) <= 5 {
+		return strings.ToLower(ext)
+	}
+	return def
+}
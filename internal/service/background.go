@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// abandonGrace is how long Drain waits for cancelled tasks to return once
+// the drain timeout has passed. Store and API calls abort on cancellation,
+// so tasks normally stop at their next call.
+const abandonGrace = 10 * time.Second
+
+// background tracks work that outlives the call that started it: embeddings
+// generated after an ingest when no job queue is configured, and jobs the
+// server runs in-process. Shutdown waits for it with Drain before the
+// database pool is closed.
+var background = &tasks{running: make(map[int]*task)}
+
+type task struct {
+	name   string
+	cancel context.CancelFunc
+}
+
+type tasks struct {
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	running  map[int]*task
+	next     int
+	draining bool
+}
+
+type lifetimeKey struct{}
+
+// WithLifetime returns a context whose background work (see Go) is
+// cancelled, with the same cause, when life is done; a job uses it so that
+// cancelling the job also stops the embeddings it left running.
+func WithLifetime(ctx, life context.Context) context.Context {
+	return context.WithValue(ctx, lifetimeKey{}, life)
+}
+
+// Go runs fn in a goroutine that Drain waits for. fn's context keeps the
+// values of ctx (such as its progress reporter) but not its cancellation,
+// so the work carries on after a request completes; it is cancelled only
+// when Drain gives up waiting or the lifetime attached to ctx (see
+// WithLifetime) ends. Once Drain has started, fn is not run.
+func Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	b := background
+	b.mu.Lock()
+	if b.draining {
+		b.mu.Unlock()
+		slog.WarnContext(ctx, "background: shutting down, not starting task", "task", name)
+		return
+	}
+	tctx, cancelCause := context.WithCancelCause(context.WithoutCancel(ctx))
+	cancel := func() { cancelCause(nil) }
+	if life, ok := ctx.Value(lifetimeKey{}).(context.Context); ok {
+		stop := context.AfterFunc(life, func() { cancelCause(context.Cause(life)) })
+		cancel = func() { stop(); cancelCause(nil) }
+	}
+	id := b.next
+	b.next++
+	b.running[id] = &task{name: name, cancel: cancel}
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			delete(b.running, id)
+			b.mu.Unlock()
+			cancel()
+			b.wg.Done()
+		}()
+		fn(tctx)
+	}()
+}
+
+// Drain stops new background work from starting and waits up to timeout
+// for the running tasks. Tasks still running after that are cancelled and
+// given a short grace period to return. It reports the names of the tasks
+// that finished and of those that were cancelled.
+func Drain(timeout time.Duration) (drained, abandoned []string) {
+	b := background
+	b.mu.Lock()
+	b.draining = true
+	pending := make(map[int]*task, len(b.running))
+	for id, t := range b.running {
+		pending[id] = t
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	cancelled := make(map[int]bool)
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		b.mu.Lock()
+		for id, t := range b.running {
+			cancelled[id] = true
+			t.cancel()
+		}
+		b.mu.Unlock()
+		select {
+		case <-done:
+		case <-time.After(abandonGrace):
+			slog.Warn("background: tasks still running after cancellation")
+		}
+	}
+
+	for id, t := range pending {
+		if cancelled[id] {
+			abandoned = append(abandoned, t.name)
+		} else {
+			drained = append(drained, t.name)
+		}
+	}
+	return drained, abandoned
+}
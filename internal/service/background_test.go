@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// freshBackground replaces the background task set for the test, since
+// Drain leaves it refusing new work.
+func freshBackground(t *testing.T) {
+	t.Helper()
+	old := background
+	background = &tasks{running: make(map[int]*task)}
+	t.Cleanup(func() { background = old })
+}
+
+// writeCountingStore counts embedding writes, and those made once closed is
+// set, as they would be after the pool was closed on shutdown.
+type writeCountingStore struct {
+	*store.Memory
+	writes, late atomic.Int32
+	closed       atomic.Bool
+}
+
+func (s *writeCountingStore) StoreEmbeddings(ctx context.Context, ids []int64, vecs [][]float32, model string, hashes []string) error {
+	s.writes.Add(1)
+	if s.closed.Load() {
+		s.late.Add(1)
+	}
+	return s.Memory.StoreEmbeddings(ctx, ids, vecs, model, hashes)
+}
+
+func (s *writeCountingStore) UpdateSourceEmbeddingRun(ctx context.Context, sourceID int64, run models.EmbeddingRun) error {
+	s.writes.Add(1)
+	if s.closed.Load() {
+		s.late.Add(1)
+	}
+	return s.Memory.UpdateSourceEmbeddingRun(ctx, sourceID, run)
+}
+
+// TestDrainCancelsEmbeddings shuts down while the embeddings of an upload
+// are part way through: the first batch is stored, the second hangs in the
+// API until its request is cancelled, and nothing is written once Drain has
+// returned.
+func TestDrainCancelsEmbeddings(t *testing.T) {
+	freshBackground(t)
+	var requests atomic.Int32
+	hung := make(chan struct{})
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is read first: the server only notices the client going
+		// away once it has been.
+		io.Copy(io.Discard, r.Body)
+		if requests.Add(1) > 1 {
+			close(hung)
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+		vec := make([]float32, embedding.Dimensions)
+		vec[0] = 1
+		json.NewEncoder(w).Encode(map[string]any{
+			"data":  []map[string]any{{"embedding": vec, "index": 0}},
+			"usage": map[string]int{"total_tokens": 1},
+		})
+	}))
+	t.Cleanup(api.Close)
+	client, err := embedding.NewClient("key", "", embedding.Options{URL: api.URL, MaxAttempts: 1, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &writeCountingStore{Memory: store.NewMemory()}
+	playlist := "#EXTM3U\n#EXTINF:-1,A\nhttp://example.com/a\n#EXTINF:-1,B\nhttp://example.com/b\n#EXTINF:-1,C\nhttp://example.com/c\n"
+	if _, _, err := IngestUpload(context.Background(), s, strings.NewReader(playlist), "upload", true, client); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-hung:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second batch never reached the API")
+	}
+
+	start := time.Now()
+	drained, abandoned := Drain(20 * time.Millisecond)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Drain took %v; the cancelled task did not stop", d)
+	}
+	s.closed.Store(true)
+	if len(drained) != 0 || !slices.Equal(abandoned, []string{`embeddings of source "upload"`}) {
+		t.Errorf("drained %q, abandoned %q", drained, abandoned)
+	}
+	if got := s.writes.Load(); got != 1 {
+		t.Errorf("writes before shutdown = %d, want the first batch only", got)
+	}
+
+	// Nothing more is written, and no new work starts.
+	ran := make(chan struct{})
+	Go(context.Background(), "late", func(context.Context) { close(ran) })
+	time.Sleep(100 * time.Millisecond)
+	if got := s.late.Load(); got != 0 {
+		t.Errorf("%d writes after shutdown", got)
+	}
+	select {
+	case <-ran:
+		t.Error("a task started after Drain ran")
+	default:
+	}
+}
+
+func TestDrainWaitsForTasks(t *testing.T) {
+	freshBackground(t)
+	var finished atomic.Bool
+	Go(context.Background(), "short", func(ctx context.Context) {
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	})
+	drained, abandoned := Drain(time.Second)
+	if !finished.Load() {
+		t.Error("Drain returned before the task finished")
+	}
+	if !slices.Equal(drained, []string{"short"}) || len(abandoned) != 0 {
+		t.Errorf("drained %q, abandoned %q", drained, abandoned)
+	}
+}
+
+func TestGoLifetime(t *testing.T) {
+	errJobCancelled := errors.New("job cancelled")
+	freshBackground(t)
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	life, endLife := context.WithCancelCause(context.Background())
+	stopped := make(chan error, 1)
+	Go(WithLifetime(reqCtx, life), "job", func(ctx context.Context) {
+		<-ctx.Done()
+		stopped <- context.Cause(ctx)
+	})
+
+	// The request ending does not stop the task; its lifetime ending does.
+	cancelReq()
+	select {
+	case <-stopped:
+		t.Fatal("task stopped with the request")
+	case <-time.After(20 * time.Millisecond):
+	}
+	endLife(errJobCancelled)
+	select {
+	case cause := <-stopped:
+		if cause != errJobCancelled {
+			t.Errorf("cause = %v, want %v", cause, errJobCancelled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task kept running after its lifetime ended")
+	}
+	background.wg.Wait()
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// checkFlushSize is how many check results are buffered before they are
+// written to the store.
+const checkFlushSize = 500
+
+// Defaults for zero CheckOptions fields.
+const (
+	defaultCheckConcurrency = 10
+	defaultCheckTimeout     = 10 * time.Second
+)
+
+// CheckOptions controls a CheckChannels run.
+type CheckOptions struct {
+	Concurrency int           // parallel requests; 0 uses 10
+	Timeout     time.Duration // per-channel timeout; 0 uses 10s
+	UserAgent   string        // sent unless the channel has its own user-agent header
+
+	// DeadPolicy (models.DeadPolicyHide or DeadPolicyDelete) is applied to
+	// channels with at least DeadThreshold consecutive failed checks once
+	// the run completes. Empty or models.DeadPolicyKeep leaves them alone.
+	DeadPolicy    string
+	DeadThreshold int
+}
+
+// CheckResult counts the outcomes of a CheckChannels run.
+type CheckResult struct {
+	Checked int
+	OK      int
+	Dead    int
+	Timeout int
+	Hidden  int // channels hidden by the dead channel policy
+	Deleted int // channels deleted by the dead channel policy
+}
+
+// checkTarget is one channel to check with the headers its player would send.
+type checkTarget struct {
+	id        int64
+	url       string
+	headers   map[string]string
+	ignoreSSL bool
+}
+
+// CheckChannels probes the stream of every channel of the source, with at
+// most opts.Concurrency requests in flight, and records each channel's
+// status, HTTP code and check time. Per-channel headers from the playlist
+// (referrer, user-agent, origin) are sent with the requests. Results are
+// written in batches as they come in, so an interrupted run keeps what it
+// has checked so far. Hidden channels are checked too, so that their status
+// stays current; the dead channel policy runs only after a complete pass.
+func CheckChannels(ctx context.Context, s store.Store, sourceID int64, sourceName string, opts CheckOptions) (res CheckResult, err error) {
+	logger := sourceLogger(ctx, "check", sourceName)
+	totalStart := time.Now()
+	defer reportFailure(ctx, &err)
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultCheckConcurrency
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+
+	var targets []checkTarget
+	err = s.StreamChannels(ctx, store.ChannelFilter{SourceID: &sourceID, IncludeHidden: true}, func(ch *models.Channel, h *models.ChannelHttpHeaders) error {
+		targets = append(targets, newCheckTarget(ch, h, opts.UserAgent))
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("StreamChannels: %w", err)
+	}
+	total := len(targets)
+	logger.Info("checking channels", "phase", PhaseCheck, "total", total, "concurrency", concurrency, "timeout", timeout)
+	report(ctx, Progress{Phase: PhaseCheck, Total: total})
+
+	checker := fetcher.NewStreamChecker(timeout)
+	work := make(chan checkTarget)
+	results := make(chan store.ChannelCheck)
+
+	var wg sync.WaitGroup
+	for range min(concurrency, max(total, 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				status, code := checker.Check(ctx, t.url, t.headers, t.ignoreSSL)
+				results <- store.ChannelCheck{ChannelID: t.id, Status: status, StatusCode: code, CheckedAt: time.Now()}
+			}
+		}()
+	}
+	go func() {
+		defer close(work)
+		for _, t := range targets {
+			select {
+			case work <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		batch    []store.ChannelCheck
+		flushErr error
+	)
+	flush := func() {
+		if len(batch) == 0 || flushErr != nil {
+			return
+		}
+		// Results already gathered are stored even if the run was cancelled.
+		if err := s.UpdateChannelStatuses(context.WithoutCancel(ctx), batch); err != nil {
+			flushErr = fmt.Errorf("UpdateChannelStatuses: %w", err)
+		}
+		batch = batch[:0]
+	}
+	for c := range results {
+		// A check cut short by cancellation says nothing about the stream.
+		if ctx.Err() != nil {
+			continue
+		}
+		res.Checked++
+		switch c.Status {
+		case models.ChannelStatusOK:
+			res.OK++
+		case models.ChannelStatusDead:
+			res.Dead++
+		case models.ChannelStatusTimeout:
+			res.Timeout++
+		}
+		batch = append(batch, c)
+		if len(batch) >= checkFlushSize {
+			flush()
+			report(ctx, Progress{Phase: PhaseCheck, Processed: res.Checked, Total: total})
+		}
+	}
+	flush()
+	if flushErr != nil {
+		return res, flushErr
+	}
+	if err := ctx.Err(); err != nil {
+		return res, fmt.Errorf("check interrupted after %d of %d channels: %w", res.Checked, total, err)
+	}
+
+	if err := applyDeadPolicy(ctx, s, sourceID, opts, &res); err != nil {
+		return res, err
+	}
+	if res.Hidden > 0 || res.Deleted > 0 {
+		logger.Info("dead channel policy applied", "phase", PhaseCheck, "policy", opts.DeadPolicy, "hidden", res.Hidden, "deleted", res.Deleted)
+	}
+
+	logger.Info("check done", "phase", PhaseDone, "ok", res.OK, "dead", res.Dead, "timeout", res.Timeout, "duration", time.Since(totalStart))
+	report(ctx, Progress{Phase: PhaseDone, Processed: res.Checked, Total: total})
+	return res, nil
+}
+
+// applyDeadPolicy hides or deletes channels that have failed
+// opts.DeadThreshold consecutive checks, per opts.DeadPolicy, and removes
+// groups left empty by deletions.
+func applyDeadPolicy(ctx context.Context, s store.Store, sourceID int64, opts CheckOptions, res *CheckResult) error {
+	if opts.DeadPolicy == "" || opts.DeadPolicy == models.DeadPolicyKeep {
+		return nil
+	}
+	threshold := max(opts.DeadThreshold, 1)
+	hidden, deleted, err := s.PruneFailingChannels(ctx, sourceID, threshold, opts.DeadPolicy == models.DeadPolicyDelete)
+	if err != nil {
+		return fmt.Errorf("PruneFailingChannels: %w", err)
+	}
+	res.Hidden, res.Deleted = int(hidden), int(deleted)
+	if deleted > 0 {
+		if _, err := s.RemoveOrphanedGroups(ctx, sourceID); err != nil {
+			return fmt.Errorf("RemoveOrphanedGroups: %w", err)
+		}
+	}
+	return nil
+}
+
+// newCheckTarget builds the request headers for a channel's check. The
+// channel's own user-agent header, if any, takes precedence over userAgent.
+func newCheckTarget(ch *models.Channel, h *models.ChannelHttpHeaders, userAgent string) checkTarget {
+	t := checkTarget{id: ch.ID, url: ch.URL, headers: map[string]string{}}
+	if userAgent != "" {
+		t.headers["User-Agent"] = userAgent
+	}
+	if h == nil {
+		return t
+	}
+	if h.UserAgent != nil && *h.UserAgent != "" {
+		t.headers["User-Agent"] = *h.UserAgent
+	}
+	if h.Referrer != nil && *h.Referrer != "" {
+		t.headers["Referer"] = *h.Referrer
+	}
+	if h.HTTPOrigin != nil && *h.HTTPOrigin != "" {
+		t.headers["Origin"] = *h.HTTPOrigin
+	}
+	t.ignoreSSL = h.IgnoreSSL != nil && *h.IgnoreSSL
+	return t
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// epgKeepPast is how far back finished programmes are kept, so a guide view
+// can still show what aired earlier today.
+const epgKeepPast = 24 * time.Hour
+
+// RefreshEPG downloads the XMLTV guide(s) in epgURL (comma-separated when the
+// playlist lists several) and replaces the source's stored programmes.
+// Only programmes for channels of this source (by tvg-id) that end within
+// the last day or later are kept. The guides are streamed from the network
+// straight into the database, so memory use is constant regardless of size.
+// Returns the number of programmes stored.
+func RefreshEPG(ctx context.Context, s store.Store, sourceID int64, sourceName, epgURL, userAgent string, timeout time.Duration) (stored int, err error) {
+	logger := sourceLogger(ctx, "epg", sourceName)
+	totalStart := time.Now()
+	defer reportFailure(ctx, &err)
+
+	urls := splitEPGURLs(epgURL)
+	if len(urls) == 0 {
+		return 0, fmt.Errorf("source %d has no EPG URL", sourceID)
+	}
+
+	tvgIDs, err := s.ListChannelTvgIDs(ctx, sourceID)
+	if err != nil {
+		return 0, fmt.Errorf("ListChannelTvgIDs: %w", err)
+	}
+	wanted := make(map[string]struct{}, len(tvgIDs))
+	for _, id := range tvgIDs {
+		wanted[id] = struct{}{}
+	}
+	logger.Info("fetching guides", "phase", PhaseFetch, "tvg_ids", len(wanted), "guides", len(urls))
+	report(ctx, Progress{Phase: PhaseFetch})
+
+	cutoff := time.Now().Add(-epgKeepPast)
+	var (
+		cur     *fetcher.XMLTVReader
+		nextURL int
+		scanned int
+		kept    int
+	)
+	defer func() {
+		if cur != nil {
+			cur.Close()
+		}
+	}()
+
+	// next walks the guides in order, opening each one only when the
+	// previous one is exhausted, and yields the programmes worth keeping.
+	next := func() (*models.EPGProgram, error) {
+		for {
+			if cur == nil {
+				if nextURL == len(urls) {
+					return nil, io.EOF
+				}
+				u := urls[nextURL]
+				nextURL++
+				logger.Info("fetching guide", "phase", PhaseEPG, "url", u)
+				xr, err := fetcher.FetchXMLTV(ctx, u, userAgent, timeout)
+				if err != nil {
+					return nil, fmt.Errorf("fetch %s: %w", u, err)
+				}
+				cur = xr
+			}
+
+			prog, err := cur.Next()
+			if errors.Is(err, io.EOF) {
+				cur.Close()
+				cur = nil
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parse %s: %w", urls[nextURL-1], err)
+			}
+
+			scanned++
+			if _, ok := wanted[prog.ChannelTvgID]; !ok || prog.Stop.Before(cutoff) {
+				continue
+			}
+			kept++
+			if kept%10000 == 0 {
+				report(ctx, Progress{Phase: PhaseEPG, Processed: kept})
+			}
+			return prog, nil
+		}
+	}
+
+	report(ctx, Progress{Phase: PhaseEPG})
+	n, err := s.ReplaceEPGPrograms(ctx, sourceID, next)
+	if err != nil {
+		return 0, fmt.Errorf("ReplaceEPGPrograms: %w", err)
+	}
+	stored = int(n)
+
+	logger.Info("EPG refresh done", "phase", PhaseDone, "stored", stored, "scanned", scanned, "duration", time.Since(totalStart))
+	report(ctx, Progress{Phase: PhaseDone, Processed: stored, Total: stored})
+	return stored, nil
+}
+
+// splitEPGURLs splits a url-tvg value, which may list several guides
+// separated by commas, into individual URLs.
+func splitEPGURLs(v string) []string {
+	var urls []string
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
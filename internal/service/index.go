@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// RebuildEmbeddingIndex rebuilds the vector index used by semantic search,
+// reporting PhaseIndex with the tuples indexed so far. Returns the number of
+// tuples in the index when the build reported a total.
+func RebuildEmbeddingIndex(ctx context.Context, s store.Store) (total int, err error) {
+	defer reportFailure(ctx, &err)
+
+	start := time.Now()
+	report(ctx, Progress{Phase: PhaseIndex})
+	err = s.RebuildEmbeddingIndex(ctx, func(done, all int64) {
+		total = int(all)
+		report(ctx, Progress{Phase: PhaseIndex, Processed: int(done), Total: total})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	slog.InfoContext(ctx, "rebuilt embedding index", "phase", PhaseIndex, "tuples", total, "duration", time.Since(start))
+	report(ctx, Progress{Phase: PhaseDone, Processed: total, Total: total})
+	return total, nil
+}
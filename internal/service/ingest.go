@@ -2,104 +2,518 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"time"
+	"unicode/utf8"
 
 	"github.com/voyagen/popcornvault/internal/embedding"
 	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/logging"
+	"github.com/voyagen/popcornvault/internal/metrics"
 	"github.com/voyagen/popcornvault/internal/models"
 	"github.com/voyagen/popcornvault/internal/store"
 )
 
-// progressInterval controls how often the upsert loop logs progress.
-const progressInterval = 5000
+// upsertBatchSize is the number of channels written per round trip during
+// ingest; progress is logged after each batch.
+const upsertBatchSize = 5000
+
+// IngestOptions controls how Ingest fetches and stores a playlist.
+type IngestOptions struct {
+	UserAgent string            // optional User-Agent for the playlist request
+	Headers   map[string]string // extra playlist request headers; stored on a new source
+	Timeout   time.Duration     // fetch timeout
+	UseTvgID  bool              // prefer tvg-id over the title as the channel name fallback
+	NoGuess   bool              // classify media types by Xtream paths only (see fetcher.DetectMediaType)
+	Dedupe    bool              // keep only the first entry for each URL; stored on a new source
+	MaxBytes  int64             // limit on the playlist size; 0 means no limit
+	Retries   int               // fetch attempts; 0 uses the fetcher default
+	Backoff   time.Duration     // delay before the first retry; 0 uses the fetcher default
+
+	// SourceID is the existing source being refreshed, or 0 for a new one.
+	// When set, the playlist is fetched conditionally and the ingest is
+	// skipped if it has not changed since the last one, unless Force is set.
+	// Force also re-embeds channels whose embedding text is unchanged.
+	SourceID int64
+	Force    bool
+
+	// WarmCache fills the cache of a caching store (see store.CacheWarmer)
+	// once the playlist is written, so the first clients after a refresh
+	// do not all read from Postgres. It never fails the ingest.
+	WarmCache bool
+
+	Embedder *embedding.Client // optional; if non-nil, embeddings are generated
+
+	// EmbedQueue, when set, queues the embeddings as a separate job instead
+	// of generating them in a background goroutine that a restart would
+	// lose. Unused without an Embedder.
+	EmbedQueue EmbedQueue
+}
+
+// EmbedQueue queues an embeddings job for a source and returns its id. The
+// job embeds the channels without a current embedding when missingOnly is
+// set (see ResumeEmbeddings), otherwise those whose text changed, or all of
+// them with force (see RefreshEmbeddings).
+type EmbedQueue func(ctx context.Context, sourceID int64, sourceName string, missingOnly, force bool) (jobID string, err error)
+
+// IngestResult describes the outcome of Ingest.
+type IngestResult struct {
+	SourceID     int64
+	ChannelCount int
+	Unchanged    bool   // the playlist had not changed; nothing was written
+	Duplicates   int    // entries skipped by Dedupe
+	StaleRemoved int    // channels no longer in the playlist, removed or archived
+	EmbedJobID   string // the embeddings job queued through IngestOptions.EmbedQueue
+
+	// Diff lists the channels the ingest added, removed and updated, and
+	// RunID is the refresh run it was recorded as (0 if recording failed).
+	Diff  models.RefreshDiff
+	RunID int64
+}
 
 // Ingest fetches an M3U URL, parses it, and stores sources and channels.
 // Existing channels are updated in place (preserving user data like favorites).
-// Channels that no longer appear in the M3U are removed, and new ones are added.
+// Channels that no longer appear in the M3U are removed, or archived per the
+// source's stale policy, and new ones are added.
 // sourceName is optional; if empty, a default name is derived (e.g. from URL or "m3u").
-// embedder is optional; if non-nil, embeddings are generated for ingested channels.
-func Ingest(ctx context.Context, s store.Store, m3uURL string, sourceName string, userAgent string, timeout time.Duration, useTvgID bool, embedder ...*embedding.Client) (sourceID int64, channelCount int, err error) {
+func Ingest(ctx context.Context, s store.Store, m3uURL string, sourceName string, opts IngestOptions) (res IngestResult, err error) {
 	if m3uURL == "" {
-		return 0, 0, fmt.Errorf("m3u URL is required")
+		return res, fmt.Errorf("m3u URL is required")
 	}
 	if sourceName == "" {
 		sourceName = "m3u"
 	}
 
 	totalStart := time.Now()
-	prefix := fmt.Sprintf("ingest[%s]", sourceName)
+	logger := sourceLogger(ctx, "ingest", sourceName)
+	defer reportFailure(ctx, &err)
+
+	// Validators of the last ingested version, for a conditional fetch.
+	var prev fetcher.Validators
+	if opts.SourceID != 0 && !opts.Force {
+		src, err := s.GetSourceByID(ctx, opts.SourceID)
+		if err != nil {
+			return res, fmt.Errorf("GetSourceByID: %w", err)
+		}
+		// A changed URL is a different playlist, whatever its validators, and
+		// a newer parser may store the same playlist differently.
+		if src.URL == m3uURL && src.ParserVersion == fetcher.ParserVersion {
+			prev = fetcher.Validators{ETag: src.ETag, LastModified: src.LastModified, ContentHash: src.ContentHash}
+		}
+	}
 
 	// --- Phase 1: Fetch M3U ---
-	log.Printf("%s: fetching M3U from %s ...", prefix, m3uURL)
+	if len(opts.Headers) > 0 {
+		logger.Info("fetching M3U", "phase", PhaseFetch, "url", m3uURL, "headers", models.RedactHeaders(opts.Headers))
+	} else {
+		logger.Info("fetching M3U", "phase", PhaseFetch, "url", m3uURL)
+	}
+	report(ctx, Progress{Phase: PhaseFetch})
 	fetchStart := time.Now()
 
-	entries, err := fetcher.FetchM3U(ctx, m3uURL, userAgent, useTvgID, timeout)
+	pl, err := fetcher.FetchM3U(ctx, m3uURL, fetcher.FetchOptions{
+		UserAgent:    opts.UserAgent,
+		Headers:      opts.Headers,
+		Timeout:      opts.Timeout,
+		UseTvgID:     opts.UseTvgID,
+		MaxBodyBytes: opts.MaxBytes,
+		Retry:        fetcher.RetryPolicy{Attempts: opts.Retries, BaseDelay: opts.Backoff},
+	}, prev)
+	if errors.Is(err, fetcher.ErrNotModified) {
+		return ingestUnchanged(ctx, s, opts.SourceID, sourceName, opts.Embedder, opts.EmbedQueue, logger, totalStart)
+	}
 	if err != nil {
-		return 0, 0, fmt.Errorf("fetch: %w", err)
+		return res, fmt.Errorf("fetch: %w", err)
 	}
 
-	log.Printf("%s: fetched %d entries (%s)", prefix, len(entries), formatDur(time.Since(fetchStart)))
+	logger.Info("fetched M3U", "phase", PhaseFetch, "entries", len(pl.Entries), "duration", time.Since(fetchStart))
 
-	sourceID, err = s.CreateOrGetSource(ctx, sourceName, m3uURL, models.SourceTypeM3ULink, userAgent)
+	duplicates := prepareEntries(pl, opts, logger)
+	res, err = ingestEntries(ctx, s, pl, sourceName, m3uURL, models.SourceTypeM3ULink, opts.UserAgent, opts.Embedder, opts.EmbedQueue, opts.Force, opts.WarmCache, logger, totalStart)
+	res.Duplicates = duplicates
 	if err != nil {
-		return 0, 0, fmt.Errorf("CreateOrGetSource: %w", err)
+		return res, err
+	}
+
+	// A refresh passes the settings already stored on the source; a new
+	// source keeps the ones it was created with for later refreshes. The
+	// user agent is stored by CreateOrGetSource.
+	if opts.SourceID == 0 && (len(opts.Headers) > 0 || opts.Dedupe || !opts.UseTvgID) {
+		fields := store.SourceUpdate{FetchHeaders: opts.Headers}
+		if opts.Dedupe {
+			fields.Dedupe = &opts.Dedupe
+		}
+		if !opts.UseTvgID {
+			fields.UseTvgID = &opts.UseTvgID
+		}
+		if err := s.UpdateSource(ctx, res.SourceID, fields); err != nil {
+			return res, fmt.Errorf("UpdateSource settings: %w", err)
+		}
 	}
+	return res, nil
+}
+
+// prepareEntries classifies the media type, series episodes and quality of
+// a fetched playlist's entries and, with opts.Dedupe, drops repeated URLs,
+// returning how many were dropped.
+func prepareEntries(pl *fetcher.ParsedPlaylist, opts IngestOptions, logger *slog.Logger) (duplicates int) {
+	if opts.NoGuess {
+		for i := range pl.Entries {
+			ch := &pl.Entries[i].Channel
+			ch.MediaType = fetcher.DetectMediaType(ch.URL, ch.Group, false)
+		}
+	}
+	if n := markEpisodes(pl.Entries, !opts.NoGuess); n > 0 {
+		logger.Info("recognised series episodes", "phase", PhaseFetch, "episodes", n)
+	}
+	if n := classifyQuality(pl.Entries); n > 0 {
+		logger.Info("detected quality", "phase", PhaseFetch, "entries", n)
+	}
+	if opts.Dedupe {
+		pl.Entries, duplicates = dedupeEntries(pl.Entries)
+		logger.Info("skipped duplicate entries", "phase", PhaseFetch, "duplicates", duplicates, "entries", len(pl.Entries))
+	}
+	return duplicates
+}
+
+// dedupeEntries drops entries whose URL appeared earlier in the playlist,
+// keeping the first one (and so its group). Playlist order decides, so
+// repeated refreshes keep the same entries. Returns the kept entries and the
+// number dropped.
+func dedupeEntries(entries []fetcher.ParsedEntry) ([]fetcher.ParsedEntry, int) {
+	seen := make(map[string]struct{}, len(entries))
+	kept := entries[:0]
+	for _, e := range entries {
+		if _, dup := seen[e.Channel.URL]; dup {
+			continue
+		}
+		seen[e.Channel.URL] = struct{}{}
+		kept = append(kept, e)
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// ingestUnchanged finishes an ingest whose playlist has not changed since
+// the last one: only last_updated is bumped, so the refresh still shows as
+// recent, and the channels are left as they are. Channels without an
+// embedding from the current model are embedded by a queued job, or in the
+// background without a queue.
+func ingestUnchanged(ctx context.Context, s store.Store, sourceID int64, sourceName string, embClient *embedding.Client, queue EmbedQueue, logger *slog.Logger, totalStart time.Time) (IngestResult, error) {
+	if err := s.UpdateSourceLastUpdated(ctx, sourceID); err != nil {
+		return IngestResult{}, fmt.Errorf("UpdateSourceLastUpdated: %w", err)
+	}
+	count, err := s.CountChannelsBySource(ctx, sourceID)
+	if err != nil {
+		return IngestResult{}, fmt.Errorf("CountChannelsBySource: %w", err)
+	}
+
+	logger.Info("playlist unchanged, skipping ingest", "phase", PhaseDone, "channels", count, "duration", time.Since(totalStart))
+	res := IngestResult{SourceID: sourceID, ChannelCount: int(count), Unchanged: true, Diff: emptyDiff()}
+	res.RunID = recordRefreshRun(ctx, s, res, totalStart, logger)
+
+	if embClient != nil && queue != nil {
+		jobID, err := queue(ctx, sourceID, sourceName, true, false)
+		if err == nil {
+			logger.Info("queued embeddings job for channels missing an embedding", "phase", PhaseEmbeddings, "job_id", jobID)
+			res.EmbedJobID = jobID
+			report(ctx, Progress{Phase: PhaseDone, Processed: int(count), Total: int(count)})
+			return res, nil
+		}
+		logger.Warn("queue embeddings failed, embedding in the background instead", "phase", PhaseEmbeddings, "err", err)
+	}
+	if embClient != nil {
+		// The progress reporter travels with ctx, so progress is still
+		// reported to the caller after we return.
+		Go(ctx, fmt.Sprintf("embeddings of source %q", sourceName), func(bgCtx context.Context) {
+			er, err := EmbedMissing(bgCtx, s, embClient, sourceID, sourceName, logger)
+			if err != nil {
+				logger.Error("embedding generation failed", "phase", PhaseEmbeddings, "err", err)
+				report(bgCtx, Progress{Phase: PhaseFailed, Err: fmt.Errorf("embeddings: %w", err)})
+				return
+			}
+			n := er.Embedded
+			if n == 0 {
+				n = int(count)
+			} else {
+				recordEmbeddingRun(bgCtx, s, sourceID, embClient, er, logger)
+			}
+			report(bgCtx, Progress{Phase: PhaseDone, Processed: n, Total: n, Embedded: er.Embedded, Tokens: er.Tokens})
+		})
+		return res, nil
+	}
+
+	report(ctx, Progress{Phase: PhaseDone, Processed: int(count), Total: int(count)})
+	return res, nil
+}
+
+// IngestUpload parses an uploaded M3U playlist from r and stores it as a
+// file-based source (SourceTypeM3U). The playlist is parsed while it is read,
+// so the raw upload is never buffered in memory. Uploading again under the
+// same sourceName replaces the source's channels like a refresh would.
+// embClient is optional; if non-nil, embeddings are generated for ingested
+// channels in the background.
+func IngestUpload(ctx context.Context, s store.Store, r io.Reader, sourceName string, useTvgID bool, embClient *embedding.Client) (sourceID int64, channelCount int, err error) {
+	if sourceName == "" {
+		sourceName = "m3u"
+	}
+
+	totalStart := time.Now()
+	logger := sourceLogger(ctx, "ingest", sourceName)
+	defer reportFailure(ctx, &err)
+
+	// --- Phase 1: Parse upload ---
+	logger.Info("parsing uploaded M3U", "phase", PhaseFetch)
+	report(ctx, Progress{Phase: PhaseFetch})
+	parseStart := time.Now()
+
+	pl, err := fetcher.ParseM3U(r, useTvgID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse: %w", err)
+	}
+
+	logger.Info("parsed M3U", "phase", PhaseFetch, "entries", len(pl.Entries), "duration", time.Since(parseStart))
+	if n := markEpisodes(pl.Entries, true); n > 0 {
+		logger.Info("recognised series episodes", "phase", PhaseFetch, "episodes", n)
+	}
+	if n := classifyQuality(pl.Entries); n > 0 {
+		logger.Info("detected quality", "phase", PhaseFetch, "entries", n)
+	}
+
+	res, err := ingestEntries(ctx, s, pl, sourceName, "", models.SourceTypeM3U, "", embClient, nil, false, false, logger, totalStart)
+	return res.SourceID, res.ChannelCount, err
+}
+
+// ingestEntries stores a parsed playlist for a source (see writeEntries) and,
+// when embClient is non-nil, queues an embeddings job through queue, or
+// without one starts embedding generation in the background. Channels whose
+// embedding text is unchanged keep their embedding unless forceEmbed is set,
+// and warm fills the cache (see IngestOptions.WarmCache).
+// The result's EmbedJobID is the queued job, if any.
+func ingestEntries(ctx context.Context, s store.Store, pl *fetcher.ParsedPlaylist, sourceName, sourceURL string, sourceType int16, userAgent string, embClient *embedding.Client, queue EmbedQueue, forceEmbed, warm bool, logger *slog.Logger, totalStart time.Time) (res IngestResult, err error) {
+	entries := pl.Entries
+	var (
+		sourceID int64
+		keepIDs  []int64
+		diff     models.RefreshDiff
+	)
+	write := func(tx store.Store) error {
+		var err error
+		sourceID, keepIDs, diff, err = writeEntries(ctx, tx, pl, sourceName, sourceURL, sourceType, userAgent, logger)
+		return err
+	}
+
+	// Run all writes in one transaction when the store supports it, so a
+	// failed ingest leaves the previous channel set (and favorites) untouched.
+	if txs, ok := s.(store.Transactor); ok {
+		err = txs.WithTx(ctx, write)
+	} else {
+		err = write(s)
+	}
+	if err != nil {
+		return res, err
+	}
+	if inv, ok := s.(store.SourceInvalidator); ok {
+		inv.InvalidateSource(ctx, sourceID)
+	}
+	channelCount := len(keepIDs)
+	res = IngestResult{SourceID: sourceID, ChannelCount: channelCount, StaleRemoved: len(diff.Removed), Diff: diff}
+
+	logger.Info("ingest done", "phase", PhaseDone, "channels", channelCount, "added", len(diff.Added), "updated", len(diff.Updated),
+		"stale_removed", len(diff.Removed), "duration", time.Since(totalStart))
+	res.RunID = recordRefreshRun(ctx, s, res, totalStart, logger)
+	if warm {
+		warmCache(ctx, s, sourceID, logger)
+	}
+
+	// --- Phase 4: Embeddings (queued job or background) ---
+	// A queued job survives restarts and can be followed on its own status.
+	if embClient != nil && queue != nil && len(keepIDs) > 0 {
+		jobID, err := queue(ctx, sourceID, sourceName, false, forceEmbed)
+		if err == nil {
+			logger.Info("queued embeddings job", "phase", PhaseEmbeddings, "job_id", jobID, "channels", len(keepIDs))
+			report(ctx, Progress{Phase: PhaseDone, Processed: channelCount, Total: len(entries)})
+			res.EmbedJobID = jobID
+			return res, nil
+		}
+		logger.Warn("queue embeddings failed, embedding in the background instead", "phase", PhaseEmbeddings, "err", err)
+	}
+
+	// Without a queue, run embedding generation in the background with a
+	// detached context so it is not cancelled when the HTTP request
+	// completes; shutdown waits for it (see Drain).
+	if embClient != nil && len(keepIDs) > 0 {
+		// Copy what we need — the entries may be reused once we return.
+		ids := make([]int64, len(keepIDs))
+		copy(ids, keepIDs)
+		entriesCopy := make([]fetcher.ParsedEntry, len(entries))
+		copy(entriesCopy, entries)
+
+		// Keep reporting progress to the caller's reporter (carried by ctx)
+		// after we return.
+		Go(ctx, fmt.Sprintf("embeddings of source %q", sourceName), func(bgCtx context.Context) {
+			er, err := GenerateEmbeddings(bgCtx, s, embClient, sourceName, ids, entriesCopy, forceEmbed, logger)
+			if err != nil {
+				logger.Error("embedding generation failed", "phase", PhaseEmbeddings, "err", err)
+				report(bgCtx, Progress{Phase: PhaseFailed, Err: fmt.Errorf("embeddings: %w", err)})
+				return
+			}
+			recordEmbeddingRun(bgCtx, s, sourceID, embClient, er, logger)
+			report(bgCtx, Progress{Phase: PhaseDone, Processed: len(ids), Total: len(ids), Embedded: er.Embedded, Skipped: er.Skipped, Tokens: er.Tokens})
+		})
+		logger.Info("embedding generation started in background", "phase", PhaseEmbeddings, "channels", len(keepIDs))
+		return res, nil
+	}
+
+	report(ctx, Progress{Phase: PhaseDone, Processed: channelCount, Total: len(entries)})
+	return res, nil
+}
+
+// writeEntries creates the source if needed, records the playlist's EPG URL,
+// upserts channels, groups and headers, removes stale rows, and bumps
+// last_updated. It returns the ids of the channels in the playlist, in input
+// order, and the channels added, removed and updated.
+func writeEntries(ctx context.Context, s store.Store, pl *fetcher.ParsedPlaylist, sourceName, sourceURL string, sourceType int16, userAgent string, logger *slog.Logger) (sourceID int64, keepIDs []int64, diff models.RefreshDiff, err error) {
+	entries := pl.Entries
+	sourceID, err = s.CreateOrGetSource(ctx, sourceName, sourceURL, sourceType, userAgent)
+	if err != nil {
+		return 0, nil, diff, fmt.Errorf("CreateOrGetSource: %w", err)
+	}
+
+	// A URL declared by the playlist replaces the previous playlist one, but
+	// never a URL the user set through the API.
+	if pl.Meta.EPGURL != "" {
+		if err := s.SetPlaylistEPGURL(ctx, sourceID, pl.Meta.EPGURL); err != nil {
+			return 0, nil, diff, fmt.Errorf("SetPlaylistEPGURL: %w", err)
+		}
+	}
+
+	src, err := s.GetSourceByID(ctx, sourceID)
+	if err != nil {
+		return 0, nil, diff, fmt.Errorf("GetSourceByID: %w", err)
+	}
+	archive := src.StalePolicy == models.StalePolicyArchive
+
+	// The channels as they were, to tell which ones the ingest changes.
+	before, err := s.ChannelStates(ctx, sourceID)
+	if err != nil {
+		return 0, nil, diff, fmt.Errorf("ChannelStates: %w", err)
+	}
+	diff = emptyDiff()
 
 	// --- Phase 2: Upsert channels ---
-	log.Printf("%s: upserting channels ...", prefix)
+	// Archived channels the playlist lists again come back with their
+	// favorites and edits, whatever the policy is now. They were not in
+	// before, so the diff counts them as added.
+	restored, err := s.RestoreArchivedChannels(ctx, sourceID, channelKeys(entries))
+	if err != nil {
+		return 0, nil, diff, fmt.Errorf("RestoreArchivedChannels: %w", err)
+	}
+	if restored > 0 {
+		logger.Info("restored archived channels", "phase", PhaseUpsert, "channels", restored)
+	}
+
+	// Move channels whose URL or name changed upstream onto their new key
+	// first, so the upsert updates them rather than inserting duplicates.
+	rekeyed, err := s.RekeyChannelsByTvgID(ctx, sourceID, tvgIDKeys(entries))
+	if err != nil {
+		return 0, nil, diff, fmt.Errorf("RekeyChannelsByTvgID: %w", err)
+	}
+	if rekeyed > 0 {
+		logger.Info("matched renamed or moved channels by tvg-id", "phase", PhaseUpsert, "channels", rekeyed)
+	}
+
+	logger.Info("upserting channels", "phase", PhaseUpsert, "total", len(entries))
 	upsertStart := time.Now()
 
-	keepIDs := make([]int64, 0, len(entries))
+	keepIDs = make([]int64, 0, len(entries))
 	groupIDs := make(map[string]int64)
+	// Channels given headers so far. One listed twice keeps the headers of
+	// an entry that has them, as when they were upserted one at a time.
+	withHeaders := make(map[int64]bool)
 	total := len(entries)
+	report(ctx, Progress{Phase: PhaseUpsert, Total: total})
 
-	for i := range entries {
-		// Check for context cancellation between iterations to allow
+	bulk, _ := s.(store.BulkChannelUpserter)
+
+	for start := 0; start < total; start += upsertBatchSize {
+		// Check for context cancellation between batches to allow
 		// graceful shutdown during long ingests.
 		if err := ctx.Err(); err != nil {
-			return sourceID, channelCount, fmt.Errorf("ingest cancelled: %w", err)
+			return 0, nil, diff, fmt.Errorf("ingest cancelled: %w", err)
 		}
 
-		ch := &entries[i].Channel
-		ch.SourceID = sourceID
-
-		if ch.Group != nil && *ch.Group != "" {
-			gname := *ch.Group
-			if gid, ok := groupIDs[gname]; ok {
-				ch.GroupID = &gid
-			} else {
-				gid, err := s.GetOrCreateGroup(ctx, sourceID, gname, ch.Image)
-				if err != nil {
-					return 0, 0, fmt.Errorf("GetOrCreateGroup: %w", err)
+		end := start + upsertBatchSize
+		if end > total {
+			end = total
+		}
+		batch := entries[start:end]
+
+		channels := make([]models.Channel, len(batch))
+		for i := range batch {
+			ch := &batch[i].Channel
+			ch.SourceID = sourceID
+
+			if ch.Group != nil && *ch.Group != "" {
+				gname := *ch.Group
+				if gid, ok := groupIDs[gname]; ok {
+					ch.GroupID = &gid
+				} else {
+					gid, err := s.GetOrCreateGroup(ctx, sourceID, gname, ch.Image)
+					if err != nil {
+						return 0, nil, diff, fmt.Errorf("GetOrCreateGroup: %w", err)
+					}
+					groupIDs[gname] = gid
+					ch.GroupID = &gid
 				}
-				groupIDs[gname] = gid
-				ch.GroupID = &gid
 			}
+			channels[i] = *ch
 		}
 
-		cid, err := s.UpsertChannel(ctx, ch)
+		ids, err := upsertChannels(ctx, s, bulk, channels)
 		if err != nil {
-			return 0, 0, fmt.Errorf("UpsertChannel: %w", err)
+			return 0, nil, diff, err
 		}
-		keepIDs = append(keepIDs, cid)
-
-		if entries[i].Headers != nil {
-			if err := s.UpsertChannelHeaders(ctx, cid, entries[i].Headers); err != nil {
-				return 0, 0, fmt.Errorf("UpsertChannelHeaders: %w", err)
+		keepIDs = append(keepIDs, ids...)
+		diffChannels(&diff, before, ids, channels)
+
+		// A nil entry drops the headers of a channel whose entry no longer
+		// has EXTVLCOPT lines, unless they were set through the API.
+		headers := make(map[int64]*models.ChannelHttpHeaders, len(batch))
+		for i := range batch {
+			if h := batch[i].Headers; h != nil {
+				headers[ids[i]] = h
+				withHeaders[ids[i]] = true
+			} else if !withHeaders[ids[i]] {
+				headers[ids[i]] = nil
 			}
 		}
-		channelCount++
-
-		if channelCount%progressInterval == 0 {
-			log.Printf("%s:   %d / %d channels upserted", prefix, channelCount, total)
+		if err := s.BulkUpsertChannelHeaders(ctx, headers); err != nil {
+			return 0, nil, diff, fmt.Errorf("BulkUpsertChannelHeaders: %w", err)
+		}
+		props := make(map[int64]map[string]string)
+		for i := range batch {
+			if len(batch[i].ExtraProps) > 0 {
+				props[ids[i]] = batch[i].ExtraProps
+			}
+		}
+		if err := s.BulkUpsertChannelProps(ctx, props); err != nil {
+			return 0, nil, diff, fmt.Errorf("BulkUpsertChannelProps: %w", err)
+		}
+		if end < total {
+			logger.Info("channels upserted", "phase", PhaseUpsert, "processed", len(keepIDs), "total", total)
+			report(ctx, Progress{Phase: PhaseUpsert, Processed: len(keepIDs), Total: total})
 		}
 	}
 
-	log.Printf("%s:   %d / %d channels upserted (%s)", prefix, channelCount, total, formatDur(time.Since(upsertStart)))
+	logger.Info("channels upserted", "phase", PhaseUpsert, "processed", len(keepIDs), "total", total, "duration", time.Since(upsertStart))
 
 	// --- Phase 3: Cleanup ---
+	report(ctx, Progress{Phase: PhaseCleanup, Processed: len(keepIDs), Total: total})
 	cleanupStart := time.Now()
 
 	// Pre-count to show expected stale channels before the slow DELETE.
@@ -109,127 +523,424 @@ func Ingest(ctx context.Context, s store.Store, m3uURL string, sourceName string
 		expectedStale = 0
 	}
 
-	log.Printf("%s: removing stale channels (~%d of %d in db) ...", prefix, expectedStale, totalInDB)
+	logger.Info("removing stale channels", "phase", PhaseCleanup, "expected", expectedStale, "in_db", totalInDB, "policy", src.StalePolicy)
 	staleStart := time.Now()
 
-	staleCount, err := s.RemoveStaleChannels(ctx, sourceID, keepIDs)
+	removed, err := s.RemoveStaleChannels(ctx, sourceID, keepIDs, archive)
 	if err != nil {
-		return sourceID, channelCount, fmt.Errorf("RemoveStaleChannels: %w", err)
+		return 0, nil, diff, fmt.Errorf("RemoveStaleChannels: %w", err)
+	}
+	if removed != nil {
+		diff.Removed = removed
 	}
 
-	log.Printf("%s: removed %d stale channels (%s)", prefix, staleCount, formatDur(time.Since(staleStart)))
+	logger.Info("removed stale channels", "phase", PhaseCleanup, "channels", len(removed), "duration", time.Since(staleStart))
 
-	log.Printf("%s: removing orphaned groups ...", prefix)
+	logger.Info("removing orphaned groups", "phase", PhaseCleanup)
 	orphanStart := time.Now()
 
-	orphanCount, err := s.RemoveOrphanedGroups(ctx, sourceID)
+	orphans, err := s.RemoveOrphanedGroups(ctx, sourceID)
 	if err != nil {
-		return sourceID, channelCount, fmt.Errorf("RemoveOrphanedGroups: %w", err)
+		return 0, nil, diff, fmt.Errorf("RemoveOrphanedGroups: %w", err)
+	}
+	if orphans != nil {
+		diff.RemovedGroups = orphans
 	}
 
-	log.Printf("%s: removed %d orphaned groups (%s)", prefix, orphanCount, formatDur(time.Since(orphanStart)))
-	log.Printf("%s: cleanup done (%s)", prefix, formatDur(time.Since(cleanupStart)))
+	logger.Info("removed orphaned groups", "phase", PhaseCleanup, "groups", len(orphans), "duration", time.Since(orphanStart))
+	logger.Info("cleanup done", "phase", PhaseCleanup, "duration", time.Since(cleanupStart))
 
 	if err := s.UpdateSourceLastUpdated(ctx, sourceID); err != nil {
-		return sourceID, channelCount, fmt.Errorf("UpdateSourceLastUpdated: %w", err)
+		return 0, nil, diff, fmt.Errorf("UpdateSourceLastUpdated: %w", err)
 	}
+	// Recorded last, inside the same transaction, so a failed ingest is
+	// retried in full rather than skipped as unchanged.
+	v := pl.Validators
+	if err := s.UpdateSourceValidators(ctx, sourceID, v.ETag, v.LastModified, v.ContentHash, fetcher.ParserVersion); err != nil {
+		return 0, nil, diff, fmt.Errorf("UpdateSourceValidators: %w", err)
+	}
+	return sourceID, keepIDs, diff, nil
+}
 
-	log.Printf("%s: done -- %d channels ingested (%s)", prefix, channelCount, formatDur(time.Since(totalStart)))
+// emptyDiff returns a diff with empty rather than nil lists, so that they
+// are written as [] in JSON.
+func emptyDiff() models.RefreshDiff {
+	return models.RefreshDiff{Added: []int64{}, Removed: []models.ChannelRef{}, Updated: []int64{}, RemovedGroups: []models.GroupRef{}}
+}
 
-	// --- Phase 4: Embeddings (background) ---
-	// Run embedding generation in a background goroutine with a detached
-	// context so it is not cancelled when the HTTP request completes.
-	var embClient *embedding.Client
-	if len(embedder) > 0 {
-		embClient = embedder[0]
+// diffChannels adds the upserted channels to diff: those not in before as
+// added, and those whose name, URL or group differs from before as updated.
+// A group the user edited is kept by the upsert, so it is not compared.
+// Each channel is counted once, even when the playlist lists it twice.
+func diffChannels(diff *models.RefreshDiff, before map[int64]store.ChannelState, ids []int64, channels []models.Channel) {
+	for i, id := range ids {
+		st, ok := before[id]
+		if !ok {
+			diff.Added = append(diff.Added, id)
+		} else if ch := &channels[i]; st.Name != ch.Name || st.URL != ch.URL ||
+			(st.EditedFields&models.EditedGroup == 0 && !equalID(st.GroupID, ch.GroupID)) {
+			diff.Updated = append(diff.Updated, id)
+		}
+		// Seen now; a repeat of the entry must not be counted again.
+		before[id] = store.ChannelState{Name: channels[i].Name, URL: channels[i].URL, GroupID: channels[i].GroupID, EditedFields: st.EditedFields}
 	}
-	if embClient != nil && len(keepIDs) > 0 {
-		// Copy what we need — the goroutine must not reference the request context.
-		ids := make([]int64, len(keepIDs))
-		copy(ids, keepIDs)
-		entriesCopy := make([]fetcher.ParsedEntry, len(entries))
-		copy(entriesCopy, entries)
+}
 
-		go func() {
-			bgCtx := context.Background()
-			if err := GenerateEmbeddings(bgCtx, s, embClient, ids, entriesCopy, prefix); err != nil {
-				log.Printf("%s: warning: embedding generation failed: %v", prefix, err)
-			}
-		}()
-		log.Printf("%s: embedding generation started in background (%d channels)", prefix, len(keepIDs))
+// equalID reports whether two optional ids are both unset or equal.
+func equalID(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
 	}
-	return sourceID, channelCount, nil
+	return *a == *b
 }
 
-// RefreshEmbeddings loads all channels for a source from the database and
-// (re-)generates their embeddings. Embeddings are generated and stored one
-// batch at a time to keep memory usage constant regardless of source size.
-// Returns the number of channels that were embedded.
-func RefreshEmbeddings(ctx context.Context, s store.Store, embClient *embedding.Client, sourceID int64, sourceName string) (int, error) {
-	const batchSize = 128
+// recordRefreshRun records res as a refresh run started at startedAt and
+// returns its id. A failure is only logged: the ingest itself succeeded.
+func recordRefreshRun(ctx context.Context, s store.Store, res IngestResult, startedAt time.Time, logger *slog.Logger) int64 {
+	run := models.RefreshRun{
+		SourceID:     res.SourceID,
+		StartedAt:    startedAt,
+		FinishedAt:   time.Now(),
+		ChannelCount: res.ChannelCount,
+		Unchanged:    res.Unchanged,
+		RefreshDiff:  res.Diff,
+	}
+	if err := s.RecordRefreshRun(ctx, &run); err != nil {
+		logger.Warn("record refresh run", "err", err)
+		return 0
+	}
+	return run.ID
+}
+
+// warmCache fills the cache for sourceID when s has one. A failure is only
+// logged: the first reads then fill the cache, as they would without it.
+func warmCache(ctx context.Context, s store.Store, sourceID int64, logger *slog.Logger) {
+	w, ok := s.(store.CacheWarmer)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	if err := w.WarmSource(ctx, sourceID); err != nil {
+		logger.Warn("warm cache", "err", err)
+		return
+	}
+	logger.Debug("cache warmed", "duration", time.Since(start))
+}
+
+// tvgIDKeys returns the rekey keys for entries whose tvg-id appears exactly
+// once in the playlist. A tvg-id shared by several entries (e.g. HD and SD
+// variants of one channel) cannot tell them apart, so those entries keep the
+// (name, url) identity.
+func tvgIDKeys(entries []fetcher.ParsedEntry) []store.ChannelKey {
+	counts := make(map[string]int)
+	for i := range entries {
+		if id := entries[i].Channel.TvgID; id != nil && *id != "" {
+			counts[*id]++
+		}
+	}
+	var keys []store.ChannelKey
+	for i := range entries {
+		ch := &entries[i].Channel
+		if ch.TvgID == nil || counts[*ch.TvgID] != 1 {
+			continue
+		}
+		keys = append(keys, store.ChannelKey{TvgID: *ch.TvgID, Name: ch.Name, URL: ch.URL})
+	}
+	return keys
+}
+
+// channelKeys returns the tvg-id, name and URL of every entry, to find the
+// archived channels the playlist lists again.
+func channelKeys(entries []fetcher.ParsedEntry) []store.ChannelKey {
+	keys := make([]store.ChannelKey, len(entries))
+	for i := range entries {
+		ch := &entries[i].Channel
+		keys[i] = store.ChannelKey{Name: ch.Name, URL: ch.URL}
+		if ch.TvgID != nil {
+			keys[i].TvgID = *ch.TvgID
+		}
+	}
+	return keys
+}
 
-	prefix := fmt.Sprintf("embed-refresh[%s]", sourceName)
+// upsertChannels writes one batch of channels, using the store's bulk path
+// when it has one, and returns their ids in input order.
+func upsertChannels(ctx context.Context, s store.Store, bulk store.BulkChannelUpserter, channels []models.Channel) ([]int64, error) {
+	if bulk != nil {
+		ids, err := bulk.BulkUpsertChannels(ctx, channels)
+		if err != nil {
+			return nil, fmt.Errorf("BulkUpsertChannels: %w", err)
+		}
+		return ids, nil
+	}
+	ids := make([]int64, len(channels))
+	for i := range channels {
+		id, err := s.UpsertChannel(ctx, &channels[i])
+		if err != nil {
+			return nil, fmt.Errorf("UpsertChannel: %w", err)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// EmbedResult counts the channels of an embedding pass.
+type EmbedResult struct {
+	Embedded int   // channels sent to the embedding API and stored
+	Skipped  int   // channels whose embedding text had not changed
+	Tokens   int64 // tokens billed by the embedding API
+}
+
+// RefreshEmbeddings loads all channels for a source from the database and
+// (re-)generates the embeddings whose text has changed since they were
+// stored, or all of them when force is set. Embeddings are generated and
+// stored one batch at a time to keep memory usage constant regardless of
+// source size.
+func RefreshEmbeddings(ctx context.Context, s store.Store, embClient *embedding.Client, sourceID int64, sourceName string, force bool) (res EmbedResult, err error) {
+	logger := sourceLogger(ctx, "embed-refresh", sourceName)
 	totalStart := time.Now()
+	defer reportFailure(ctx, &err)
 
 	// Load all channels for this source.
-	log.Printf("%s: loading channels for source %d ...", prefix, sourceID)
+	logger.Info("loading channels", "phase", PhaseEmbeddings, "source_id", sourceID)
 	channels, err := s.ListChannelsBySource(ctx, sourceID)
 	if err != nil {
-		return 0, fmt.Errorf("ListChannelsBySource: %w", err)
+		return res, fmt.Errorf("ListChannelsBySource: %w", err)
 	}
 	if len(channels) == 0 {
-		log.Printf("%s: no channels found, nothing to embed", prefix)
-		return 0, nil
+		logger.Info("no channels found, nothing to embed", "phase", PhaseDone)
+		report(ctx, Progress{Phase: PhaseDone})
+		return res, nil
 	}
-	log.Printf("%s: loaded %d channels", prefix, len(channels))
+	logger.Info("loaded channels", "phase", PhaseEmbeddings, "channels", len(channels))
 
-	totalBatches := (len(channels) + batchSize - 1) / batchSize
-	log.Printf("%s: embedding and storing (%d/batch, %d batches) ...", prefix, batchSize, totalBatches)
+	ids := make([]int64, len(channels))
+	for i := range channels {
+		ids[i] = channels[i].ID
+	}
+	res, err = embedChannels(ctx, s, embClient, sourceName, ids, func(i int) string {
+		return storedEmbeddingText(&channels[i])
+	}, force, logger)
+	if err != nil {
+		return res, err
+	}
 
-	stored := 0
-	for i := 0; i < len(channels); i += batchSize {
-		if err := ctx.Err(); err != nil {
-			return stored, fmt.Errorf("embed-refresh cancelled: %w", err)
-		}
+	recordEmbeddingRun(ctx, s, sourceID, embClient, res, logger)
+	logger.Info("embeddings done", "phase", PhaseDone, "embedded", res.Embedded, "unchanged", res.Skipped, "tokens", res.Tokens, "duration", time.Since(totalStart))
+	report(ctx, Progress{Phase: PhaseDone, Processed: len(channels), Total: len(channels), Embedded: res.Embedded, Skipped: res.Skipped, Tokens: res.Tokens})
+	return res, nil
+}
 
-		end := i + batchSize
-		if end > len(channels) {
-			end = len(channels)
-		}
-		batch := channels[i:end]
+// ResumeEmbeddings embeds only the channels of a source that have no
+// embedding from the current model, e.g. those left over when a server
+// restart interrupted a run. Unlike RefreshEmbeddings it never loads the
+// whole source, so it is cheap when there is little left to do.
+func ResumeEmbeddings(ctx context.Context, s store.Store, embClient *embedding.Client, sourceID int64, sourceName string) (res EmbedResult, err error) {
+	logger := sourceLogger(ctx, "embed-resume", sourceName)
+	totalStart := time.Now()
+	defer reportFailure(ctx, &err)
+
+	res, err = EmbedMissing(ctx, s, embClient, sourceID, sourceName, logger)
+	if err != nil {
+		return res, err
+	}
+	if res.Embedded == 0 {
+		logger.Info("every channel is embedded, nothing to do", "phase", PhaseDone)
+	} else {
+		recordEmbeddingRun(ctx, s, sourceID, embClient, res, logger)
+		logger.Info("embeddings done", "phase", PhaseDone, "embedded", res.Embedded, "tokens", res.Tokens, "duration", time.Since(totalStart))
+	}
+	report(ctx, Progress{Phase: PhaseDone, Processed: res.Embedded, Total: res.Embedded, Embedded: res.Embedded, Tokens: res.Tokens})
+	return res, nil
+}
+
+// embedPage is how many channels are loaded or compared against their
+// stored text hashes at a time.
+const embedPage = 1024
+
+// EmbedMissing embeds the channels of a source that have no embedding or
+// one made with another model than embClient's, so that a model change is
+// caught up on the next refresh.
+func EmbedMissing(ctx context.Context, s store.Store, embClient *embedding.Client, sourceID int64, sourceName string, logger *slog.Logger) (res EmbedResult, err error) {
+	stats, err := s.EmbeddingStats(ctx, sourceID, embClient.Model())
+	if err != nil {
+		return res, err
+	}
+	total := stats.Stale + stats.Missing
+	if total == 0 {
+		return res, nil
+	}
+	logger.Info("embedding channels", "phase", PhaseEmbeddings, "total", total, "missing", stats.Missing, "other_model", stats.Stale)
+	report(ctx, Progress{Phase: PhaseEmbeddings, Total: total})
+	start := time.Now()
+	retries := embClient.Retries()
+	batchSize := embClient.BatchSize()
 
-		// Build texts and IDs for this batch only.
-		batchIDs := make([]int64, len(batch))
-		batchTexts := make([]string, len(batch))
-		for j, ch := range batch {
-			batchIDs[j] = ch.ID
-			group := ""
-			if ch.GroupName != nil && *ch.GroupName != "" {
-				group = *ch.GroupName
+	// Stored channels drop out of the list, so each page starts over.
+	for {
+		channels, err := s.ListChannelsWithoutEmbeddings(ctx, sourceID, embClient.Model(), embedPage)
+		if err != nil {
+			return res, fmt.Errorf("ListChannelsWithoutEmbeddings: %w", err)
+		}
+		if len(channels) == 0 {
+			break
+		}
+		for i := 0; i < len(channels); i += batchSize {
+			if err := ctx.Err(); err != nil {
+				return res, fmt.Errorf("embedding cancelled: %w", err)
+			}
+			batch := channels[i:min(i+batchSize, len(channels))]
+			ids := make([]int64, len(batch))
+			texts := make([]string, len(batch))
+			for j := range batch {
+				ids[j] = batch[j].ID
+				texts[j] = truncateEmbeddingText(embClient, batch[j].ID, storedEmbeddingText(&batch[j]), logger)
 			}
-			batchTexts[j] = fmt.Sprintf("%s | %s | %s", ch.Name, group, mediaTypeLabel(ch.MediaType))
+			tokens, err := embedAndStore(ctx, s, embClient, sourceName, ids, texts)
+			if err != nil {
+				return res, err
+			}
+			res.Embedded += len(batch)
+			res.Tokens += int64(tokens)
+			report(ctx, Progress{Phase: PhaseEmbeddings, Processed: res.Embedded, Total: total, Embedded: res.Embedded, Tokens: res.Tokens})
 		}
+		logger.Info("channels embedded", "phase", PhaseEmbeddings, "embedded", res.Embedded, "remaining", max(total-res.Embedded, 0), "tokens", res.Tokens, "api_retries", embClient.Retries()-retries)
+	}
 
-		// Generate embeddings for this batch.
-		embeddings, err := embClient.Embed(ctx, batchTexts, "document")
-		if err != nil {
-			return stored, fmt.Errorf("Embed batch %d: %w", (i/batchSize)+1, err)
+	logger.Info("channels embedded", "phase", PhaseEmbeddings, "embedded", res.Embedded, "tokens", res.Tokens, "api_retries", embClient.Retries()-retries, "duration", time.Since(start))
+	return res, nil
+}
+
+// embedChannels embeds the channels in ids, whose embedding texts are
+// returned by text(i). Channels whose stored embedding was generated by the
+// same model from the same text are skipped unless force is set.
+func embedChannels(ctx context.Context, s store.Store, embClient *embedding.Client, sourceName string, ids []int64, text func(i int) string, force bool, logger *slog.Logger) (EmbedResult, error) {
+	var res EmbedResult
+	total := len(ids)
+	batchSize := embClient.BatchSize()
+	logger.Info("embedding and storing channels", "phase", PhaseEmbeddings, "total", total, "batch_size", batchSize, "force", force)
+	report(ctx, Progress{Phase: PhaseEmbeddings, Total: total})
+	start := time.Now()
+	retries := embClient.Retries()
+
+	for i := 0; i < total; i += embedPage {
+		end := min(i+embedPage, total)
+		pageIDs := ids[i:end]
+
+		var known map[int64]string
+		if !force {
+			var err error
+			known, err = s.EmbeddingTextHashes(ctx, pageIDs, embClient.Model())
+			if err != nil {
+				return res, fmt.Errorf("EmbeddingTextHashes: %w", err)
+			}
 		}
 
-		// Store immediately — memory is freed before the next iteration.
-		if err := s.StoreEmbeddings(ctx, batchIDs, embeddings); err != nil {
-			return stored, fmt.Errorf("StoreEmbeddings batch %d: %w", (i/batchSize)+1, err)
+		// Keep only the channels whose text changed since their embedding.
+		var todoIDs []int64
+		var todoTexts []string
+		for j, id := range pageIDs {
+			t := truncateEmbeddingText(embClient, id, text(i+j), logger)
+			if h, ok := known[id]; ok && h == embeddingTextHash(t) {
+				res.Skipped++
+				continue
+			}
+			todoIDs = append(todoIDs, id)
+			todoTexts = append(todoTexts, t)
 		}
 
-		stored += len(batch)
-		batchNum := (i / batchSize) + 1
-		if batchNum%50 == 0 || end == len(channels) {
-			log.Printf("%s:   batch %d / %d  (%d channels stored)", prefix, batchNum, totalBatches, stored)
+		for k := 0; k < len(todoIDs); k += batchSize {
+			if err := ctx.Err(); err != nil {
+				return res, fmt.Errorf("embedding cancelled: %w", err)
+			}
+			kend := min(k+batchSize, len(todoIDs))
+			tokens, err := embedAndStore(ctx, s, embClient, sourceName, todoIDs[k:kend], todoTexts[k:kend])
+			if err != nil {
+				return res, err
+			}
+			res.Embedded += kend - k
+			res.Tokens += int64(tokens)
+			report(ctx, Progress{Phase: PhaseEmbeddings, Processed: i + (len(pageIDs) - len(todoIDs)) + kend, Total: total, Embedded: res.Embedded, Skipped: res.Skipped, Tokens: res.Tokens})
+		}
+		report(ctx, Progress{Phase: PhaseEmbeddings, Processed: end, Total: total, Embedded: res.Embedded, Skipped: res.Skipped, Tokens: res.Tokens})
+		if (i/embedPage+1)%10 == 0 || end == total {
+			logger.Info("embedding progress", "phase", PhaseEmbeddings, "processed", end, "total", total, "embedded", res.Embedded, "unchanged", res.Skipped, "tokens", res.Tokens, "api_retries", embClient.Retries()-retries)
 		}
 	}
 
-	log.Printf("%s: done -- %d channels embedded (%s total)", prefix, stored, formatDur(time.Since(totalStart)))
-	return stored, nil
+	logger.Info("embeddings stored", "phase", PhaseEmbeddings, "embedded", res.Embedded, "unchanged", res.Skipped, "tokens", res.Tokens, "api_retries", embClient.Retries()-retries, "duration", time.Since(start))
+	return res, nil
+}
+
+// embedAndStore generates the embeddings of texts with one API request and
+// stores them for ids along with the model and text hashes. The tokens used
+// are returned and counted against sourceName in the metrics.
+func embedAndStore(ctx context.Context, s store.Store, embClient *embedding.Client, sourceName string, ids []int64, texts []string) (tokens int, err error) {
+	res, err := embClient.Embed(ctx, texts, "document")
+	if err != nil {
+		return 0, fmt.Errorf("Embed: %w", err)
+	}
+	metrics.EmbeddingTokens.WithLabelValues(sourceName).Add(float64(res.Tokens))
+	hashes := make([]string, len(texts))
+	for i, t := range texts {
+		hashes[i] = embeddingTextHash(t)
+	}
+	if err := s.StoreEmbeddings(ctx, ids, res.Embeddings, embClient.Model(), hashes); err != nil {
+		return res.Tokens, fmt.Errorf("StoreEmbeddings: %w", err)
+	}
+	return res.Tokens, nil
+}
+
+// recordEmbeddingRun stores the totals of a completed embedding run on the
+// source. Failing to do so only loses the summary, so it is logged.
+func recordEmbeddingRun(ctx context.Context, s store.Store, sourceID int64, embClient *embedding.Client, res EmbedResult, logger *slog.Logger) {
+	run := models.EmbeddingRun{
+		Embedded:   res.Embedded,
+		Skipped:    res.Skipped,
+		Tokens:     res.Tokens,
+		Model:      embClient.Model(),
+		FinishedAt: time.Now(),
+	}
+	if err := s.UpdateSourceEmbeddingRun(ctx, sourceID, run); err != nil {
+		logger.Warn("record embedding run", "err", err)
+	}
+}
+
+// truncateEmbeddingText cuts a channel's embedding text to the client's
+// length limit, logging the channel so absurd playlist names can be found.
+func truncateEmbeddingText(embClient *embedding.Client, channelID int64, text string, logger *slog.Logger) string {
+	short, cut := embClient.Truncate(text)
+	if cut {
+		logger.Warn("truncated embedding text", "phase", PhaseEmbeddings, "channel_id", channelID, "from_chars", utf8.RuneCountInString(text), "to_chars", utf8.RuneCountInString(short))
+	}
+	return short
+}
+
+// embeddingTextHash returns the hash stored with an embedding to tell
+// whether the channel's embedding text has changed since.
+func embeddingTextHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// storedEmbeddingText returns the embedding text of a channel loaded from
+// the store, matching what GenerateEmbeddings builds from playlist entries.
+func storedEmbeddingText(ch *models.Channel) string {
+	group := ""
+	if ch.GroupName != nil && *ch.GroupName != "" {
+		group = *ch.GroupName
+	}
+	return fmt.Sprintf("%s | %s | %s", embeddingName(ch), group, mediaTypeLabel(ch.MediaType))
+}
+
+// entryEmbeddingText returns the embedding text of a parsed playlist entry:
+// "name | group | media type".
+func entryEmbeddingText(e *fetcher.ParsedEntry) string {
+	group := ""
+	if e.Channel.Group != nil && *e.Channel.Group != "" {
+		group = *e.Channel.Group
+	}
+	return fmt.Sprintf("%s | %s | %s", embeddingName(&e.Channel), group, mediaTypeLabel(e.Channel.MediaType))
 }
 
 // mediaTypeLabel returns a human-readable label for a media type constant.
@@ -247,69 +958,23 @@ func mediaTypeLabel(mt int16) string {
 }
 
 // GenerateEmbeddings creates embedding text for each channel and stores the
-// vectors. Embeddings are generated and stored one batch at a time to keep
-// memory usage constant regardless of channel count.
-func GenerateEmbeddings(ctx context.Context, s store.Store, embClient *embedding.Client, channelIDs []int64, entries []fetcher.ParsedEntry, prefix string) error {
-	const batchSize = 128
-
-	totalBatches := (len(entries) + batchSize - 1) / batchSize
-	log.Printf("%s: embedding and storing (%d/batch, %d batches) ...", prefix, batchSize, totalBatches)
-	start := time.Now()
-
-	stored := 0
-	for i := 0; i < len(entries); i += batchSize {
-		if err := ctx.Err(); err != nil {
-			return fmt.Errorf("embedding cancelled: %w", err)
-		}
-
-		end := i + batchSize
-		if end > len(entries) {
-			end = len(entries)
-		}
-
-		// Build texts for this batch only.
-		batchIDs := channelIDs[i:end]
-		batchTexts := make([]string, end-i)
-		for j, e := range entries[i:end] {
-			group := ""
-			if e.Channel.Group != nil && *e.Channel.Group != "" {
-				group = *e.Channel.Group
-			}
-			batchTexts[j] = fmt.Sprintf("%s | %s | %s", e.Channel.Name, group, mediaTypeLabel(e.Channel.MediaType))
-		}
-
-		// Generate embeddings for this batch.
-		embeddings, err := embClient.Embed(ctx, batchTexts, "document")
-		if err != nil {
-			return fmt.Errorf("Embed batch %d: %w", (i/batchSize)+1, err)
-		}
-
-		// Store immediately — memory is freed before the next iteration.
-		if err := s.StoreEmbeddings(ctx, batchIDs, embeddings); err != nil {
-			return fmt.Errorf("StoreEmbeddings batch %d: %w", (i/batchSize)+1, err)
-		}
-
-		stored += len(batchIDs)
-		batchNum := (i / batchSize) + 1
-		if batchNum%50 == 0 || end == len(entries) {
-			log.Printf("%s:   batch %d / %d  (%d channels stored)", prefix, batchNum, totalBatches, stored)
-		}
-	}
-
-	log.Printf("%s: all embeddings stored (%d channels, %s)", prefix, stored, formatDur(time.Since(start)))
-	return nil
+// vectors of the channels whose text changed since their last embedding, or
+// of all of them when force is set. Embeddings are generated and stored one
+// batch at a time to keep memory usage constant regardless of channel count.
+func GenerateEmbeddings(ctx context.Context, s store.Store, embClient *embedding.Client, sourceName string, channelIDs []int64, entries []fetcher.ParsedEntry, force bool, logger *slog.Logger) (EmbedResult, error) {
+	return embedChannels(ctx, s, embClient, sourceName, channelIDs, func(i int) string {
+		return entryEmbeddingText(&entries[i])
+	}, force, logger)
 }
 
-// formatDur formats a duration in a human-friendly way.
-func formatDur(d time.Duration) string {
-	switch {
-	case d < time.Second:
-		return fmt.Sprintf("%dms", d.Milliseconds())
-	case d < time.Minute:
-		return fmt.Sprintf("%.1fs", d.Seconds())
-	default:
-		m := int(d.Minutes())
-		s := int(d.Seconds()) % 60
-		return fmt.Sprintf("%dm%ds", m, s)
+// sourceLogger returns the logger for a task (ingest, epg, ...) on a source,
+// carrying the request id of ctx so that the task's entries, including those
+// of the embeddings it leaves running, can be tied to the API request. Each
+// entry also carries the phase it belongs to, as in Progress.
+func sourceLogger(ctx context.Context, task, sourceName string) *slog.Logger {
+	logger := slog.With("task", task, "source", sourceName)
+	if id := logging.RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
 	}
+	return logger
 }
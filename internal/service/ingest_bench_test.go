@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// countingBackend counts the commands a cache backend receives: one per
+// call, as Redis would get a GET, SET or DEL, and one sweep per pattern.
+type countingBackend struct {
+	cache.Backend
+	gets, sets, dels, patterns atomic.Int64
+}
+
+func (b *countingBackend) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	b.gets.Add(1)
+	return b.Backend.GetBytes(ctx, key)
+}
+
+func (b *countingBackend) SetBytes(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	b.sets.Add(1)
+	return b.Backend.SetBytes(ctx, key, data, ttl)
+}
+
+func (b *countingBackend) Delete(ctx context.Context, keys ...string) error {
+	b.dels.Add(1)
+	return b.Backend.Delete(ctx, keys...)
+}
+
+func (b *countingBackend) DeletePattern(ctx context.Context, pattern string) error {
+	b.patterns.Add(1)
+	return b.Backend.DeletePattern(ctx, pattern)
+}
+
+// report adds the commands counted so far, per iteration, to the benchmark
+// results.
+func (b *countingBackend) report(tb *testing.B) {
+	n := float64(tb.N)
+	tb.ReportMetric(float64(b.dels.Load())/n, "DEL/op")
+	tb.ReportMetric(float64(b.patterns.Load())/n, "SCAN-sweeps/op")
+	tb.ReportMetric(float64(b.gets.Load()+b.sets.Load()+b.dels.Load()+b.patterns.Load())/n, "commands/op")
+}
+
+// benchPlaylist returns an M3U playlist of n channels in 50 groups.
+func benchPlaylist(n int) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "#EXTINF:-1 group-title=\"Group %d\",Channel %d\nhttp://example.com/%d.ts\n", i%50, i, i)
+	}
+	return sb.String()
+}
+
+// BenchmarkIngestCacheCommands counts the cache commands an ingest of a
+// playlist sends through a CachedStore, which invalidates per source once
+// per batch and once at the end, against upserting the same channels one
+// at a time, where every channel invalidates the source's lists.
+func BenchmarkIngestCacheCommands(b *testing.B) {
+	ctx := context.Background()
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(logger) })
+
+	for _, n := range []int{1_000, 10_000} {
+		playlist := benchPlaylist(n)
+
+		b.Run(fmt.Sprintf("ingest/%d", n), func(b *testing.B) {
+			backend := &countingBackend{Backend: cache.NewMemory(10_000, 1<<24)}
+			s := store.NewCachedStore(store.NewMemory(), backend, store.CacheOptions{TTLChannels: time.Minute})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := IngestUpload(ctx, s, strings.NewReader(playlist), "bench", false, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			backend.report(b)
+		})
+
+		b.Run(fmt.Sprintf("per_channel/%d", n), func(b *testing.B) {
+			backend := &countingBackend{Backend: cache.NewMemory(10_000, 1<<24)}
+			s := store.NewCachedStore(store.NewMemory(), backend, store.CacheOptions{TTLChannels: time.Minute})
+			src, err := s.CreateCustomSource(ctx, "bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < n; j++ {
+					ch := models.Channel{Name: fmt.Sprintf("Channel %d", j), URL: fmt.Sprintf("http://example.com/%d.ts", j), SourceID: src}
+					if _, err := s.UpsertChannel(ctx, &ch); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			backend.report(b)
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/embedding"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// fakeVoyage answers embedding requests with Dimensions-long vectors and
+// counts the texts it was sent.
+func fakeVoyage(t *testing.T, texts *atomic.Int32) *embedding.Client {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input     []string `json:"input"`
+			InputType string   `json:"input_type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.InputType != "document" {
+			http.Error(w, `{"detail":"bad request"}`, http.StatusBadRequest)
+			return
+		}
+		texts.Add(int32(len(req.Input)))
+		type datum struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		}
+		var resp struct {
+			Data  []datum `json:"data"`
+			Usage struct {
+				TotalTokens int `json:"total_tokens"`
+			} `json:"usage"`
+		}
+		for i := range req.Input {
+			vec := make([]float32, embedding.Dimensions)
+			vec[0] = float32(i + 1)
+			resp.Data = append(resp.Data, datum{Embedding: vec, Index: i})
+		}
+		resp.Usage.TotalTokens = 10 * len(req.Input)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(ts.Close)
+	c, err := embedding.NewClient("key", "", embedding.Options{URL: ts.URL, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+const uploadPlaylist = `#EXTM3U
+#EXTINF:-1 group-title="News",BBC One
+http://example.com/bbc
+#EXTINF:-1 group-title="News",CNN
+http://example.com/cnn
+`
+
+func TestIngestUploadEmbeddings(t *testing.T) {
+	ctx := context.Background()
+	var texts atomic.Int32
+	client := fakeVoyage(t, &texts)
+
+	mem := store.NewMemory()
+	sourceID, count, err := IngestUpload(ctx, mem, strings.NewReader(uploadPlaylist), "upload", true, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("channel count = %d, want 2", count)
+	}
+	// The embeddings run in the background; wait for them.
+	background.wg.Wait()
+
+	if got := texts.Load(); got != 2 {
+		t.Errorf("texts embedded = %d, want 2", got)
+	}
+	channels, err := mem.ListChannelsBySource(ctx, sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range channels {
+		vec, err := mem.GetChannelEmbedding(ctx, ch.ID)
+		if err != nil || len(vec) != embedding.Dimensions {
+			t.Errorf("channel %q embedding = %d dimensions (%v), want %d", ch.Name, len(vec), err, embedding.Dimensions)
+		}
+	}
+	src, err := mem.GetSourceByID(ctx, sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run := src.LastEmbeddingRun; run == nil || run.Embedded != 2 || run.Tokens != 20 {
+		t.Errorf("last embedding run = %+v, want 2 embedded for 20 tokens", run)
+	}
+
+	// Uploading the same playlist again leaves the unchanged texts alone.
+	if _, _, err := IngestUpload(ctx, mem, strings.NewReader(uploadPlaylist), "upload", true, client); err != nil {
+		t.Fatal(err)
+	}
+	background.wg.Wait()
+	if got := texts.Load(); got != 2 {
+		t.Errorf("texts embedded after an unchanged upload = %d, want still 2", got)
+	}
+}
+
+func TestIngestUploadWithoutEmbedder(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	sourceID, _, err := IngestUpload(ctx, mem, strings.NewReader(uploadPlaylist), "upload", true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	background.wg.Wait()
+	channels, err := mem.ListChannelsBySource(ctx, sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ch := range channels {
+		if vec, _ := mem.GetChannelEmbedding(ctx, ch.ID); vec != nil {
+			t.Errorf("channel %q has an embedding without an embedder", ch.Name)
+		}
+	}
+}
+
+// propsCountingStore counts the player property writes an ingest makes.
+type propsCountingStore struct {
+	*store.Memory
+	single, bulk atomic.Int32
+	props        map[int64]map[string]string
+}
+
+func (s *propsCountingStore) UpsertChannelProps(ctx context.Context, channelID int64, props map[string]string) error {
+	s.single.Add(1)
+	return s.Memory.UpsertChannelProps(ctx, channelID, props)
+}
+
+func (s *propsCountingStore) BulkUpsertChannelProps(ctx context.Context, props map[int64]map[string]string) error {
+	s.bulk.Add(1)
+	for id, p := range props {
+		s.props[id] = p
+	}
+	return s.Memory.BulkUpsertChannelProps(ctx, props)
+}
+
+// TestIngestChannelPropsBatched checks that an ingest writes the KODIPROP
+// properties of a batch of channels with one BulkUpsertChannelProps call.
+func TestIngestChannelPropsBatched(t *testing.T) {
+	ctx := context.Background()
+	const playlist = `#EXTM3U
+#EXTINF:-1,DRM One
+#KODIPROP:inputstream.adaptive.license_type=clearkey
+#KODIPROP:inputstream.adaptive.license_key=k1
+http://example.com/1.mpd
+#EXTINF:-1,Plain
+http://example.com/2.ts
+#EXTINF:-1,DRM Two
+#KODIPROP:inputstream.adaptive.license_key=k2
+http://example.com/3.mpd
+`
+	s := &propsCountingStore{Memory: store.NewMemory(), props: map[int64]map[string]string{}}
+	sourceID, _, err := IngestUpload(ctx, s, strings.NewReader(playlist), "upload", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	background.wg.Wait()
+	if single, bulk := s.single.Load(), s.bulk.Load(); single != 0 || bulk != 1 {
+		t.Errorf("props writes: %d single, %d bulk, want 0 and 1", single, bulk)
+	}
+
+	channels, err := s.ListChannelsBySource(ctx, sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"DRM One": {"inputstream.adaptive.license_type": "clearkey", "inputstream.adaptive.license_key": "k1"},
+		"DRM Two": {"inputstream.adaptive.license_key": "k2"},
+	}
+	for _, ch := range channels {
+		if got := s.props[ch.ID]; !maps.Equal(got, want[ch.Name]) {
+			t.Errorf("channel %q props = %v, want %v", ch.Name, got, want[ch.Name])
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+// planSampleSize is how many channels of each kind an ingest plan lists.
+const planSampleSize = 20
+
+// PlanIngest fetches and parses a source's playlist like Ingest would and
+// compares it with the channels stored for the source, without writing
+// anything: no channels, no last_updated and no embeddings. Entries are
+// matched to channels by name and URL, or by tvg-id where a refresh would
+// rekey them (see store.Store.RekeyChannelsByTvgID). opts.SourceID must be
+// set; the playlist is always fetched in full.
+func PlanIngest(ctx context.Context, s store.Store, m3uURL, sourceName string, opts IngestOptions) (*models.IngestPlan, error) {
+	if opts.SourceID == 0 {
+		return nil, fmt.Errorf("source id is required")
+	}
+	if m3uURL == "" {
+		return nil, fmt.Errorf("m3u URL is required")
+	}
+
+	logger := sourceLogger(ctx, "plan", sourceName)
+	start := time.Now()
+
+	pl, err := fetcher.FetchM3U(ctx, m3uURL, fetcher.FetchOptions{
+		UserAgent:    opts.UserAgent,
+		Headers:      opts.Headers,
+		Timeout:      opts.Timeout,
+		UseTvgID:     opts.UseTvgID,
+		MaxBodyBytes: opts.MaxBytes,
+		Retry:        fetcher.RetryPolicy{Attempts: opts.Retries, BaseDelay: opts.Backoff},
+	}, fetcher.Validators{})
+	if err != nil {
+		return nil, fmt.Errorf("fetch: %w", err)
+	}
+	duplicates := prepareEntries(pl, opts, logger)
+
+	states, err := s.ChannelStates(ctx, opts.SourceID)
+	if err != nil {
+		return nil, fmt.Errorf("ChannelStates: %w", err)
+	}
+
+	plan := planEntries(pl.Entries, states)
+	plan.SourceID = opts.SourceID
+	plan.Duplicates = duplicates
+
+	logger.Info("planned ingest", "entries", len(pl.Entries), "added", plan.Added, "removed", plan.Removed,
+		"modified", plan.Modified, "unchanged", plan.Unchanged, "duration", time.Since(start))
+	return plan, nil
+}
+
+// planKey is the upsert conflict key of a channel within its source.
+type planKey struct {
+	name, url string
+}
+
+// planEntries compares playlist entries with the stored channels of their
+// source.
+func planEntries(entries []fetcher.ParsedEntry, states map[int64]store.ChannelState) *models.IngestPlan {
+	plan := &models.IngestPlan{
+		AddedSample:    []models.PlannedChannel{},
+		RemovedSample:  []models.ChannelRef{},
+		ModifiedSample: []models.PlannedChange{},
+	}
+
+	byKey := make(map[planKey]int64, len(states))
+	byTvgID := make(map[string][]int64)
+	for id, st := range states {
+		byKey[planKey{st.Name, st.URL}] = id
+		if st.TvgID != nil {
+			byTvgID[*st.TvgID] = append(byTvgID[*st.TvgID], id)
+		}
+	}
+
+	// Rekey like the refresh would: a channel with a tvg-id unique in both
+	// the playlist and the source moves to the entry's name and URL, unless
+	// a channel already has those. The first key for a name and URL wins.
+	moves := make(map[planKey]int64)
+	for _, k := range tvgIDKeys(entries) {
+		ids := byTvgID[k.TvgID]
+		if len(ids) != 1 {
+			continue
+		}
+		key := planKey{k.Name, k.URL}
+		st := states[ids[0]]
+		if _, taken := byKey[key]; taken || (st.Name == k.Name && st.URL == k.URL) {
+			continue
+		}
+		if _, dup := moves[key]; !dup {
+			moves[key] = ids[0]
+		}
+	}
+	for key, id := range moves {
+		st := states[id]
+		delete(byKey, planKey{st.Name, st.URL})
+		byKey[key] = id
+	}
+
+	seen := make(map[planKey]bool, len(entries))
+	matched := make(map[int64]bool, len(states))
+	for i := range entries {
+		ch := &entries[i].Channel
+		key := planKey{ch.Name, ch.URL}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		plan.ChannelCount++
+
+		group := ch.Group
+		if group != nil && *group == "" {
+			group = nil
+		}
+		id, ok := byKey[key]
+		if !ok {
+			plan.Added++
+			if len(plan.AddedSample) < planSampleSize {
+				plan.AddedSample = append(plan.AddedSample, models.PlannedChannel{Name: ch.Name, URL: ch.URL, Group: group})
+			}
+			continue
+		}
+		matched[id] = true
+
+		st := states[id]
+		var changed []string
+		if st.Name != ch.Name {
+			changed = append(changed, "name")
+		}
+		if st.URL != ch.URL {
+			changed = append(changed, "url")
+		}
+		if st.EditedFields&models.EditedGroup == 0 && !equalName(st.Group, group) {
+			changed = append(changed, "group")
+		}
+		if len(changed) == 0 {
+			plan.Unchanged++
+			continue
+		}
+		plan.Modified++
+		if len(plan.ModifiedSample) < planSampleSize {
+			plan.ModifiedSample = append(plan.ModifiedSample, models.PlannedChange{ID: id, Name: ch.Name, URL: ch.URL, Group: group, Changed: changed})
+		}
+	}
+
+	// Removed channels in id order, so the sample is the same every time.
+	var removed []int64
+	for id := range states {
+		if !matched[id] {
+			removed = append(removed, id)
+		}
+	}
+	slices.Sort(removed)
+	plan.Removed = len(removed)
+	for _, id := range removed[:min(len(removed), planSampleSize)] {
+		plan.RemovedSample = append(plan.RemovedSample, models.ChannelRef{ID: id, Name: states[id].Name})
+	}
+	return plan
+}
+
+// equalName reports whether two optional names are both unset or equal.
+func equalName(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
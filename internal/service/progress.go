@@ -0,0 +1,68 @@
+package service
+
+import "context"
+
+// Ingest, embedding, EPG, check and index phases reported through a
+// ProgressReporter.
+const (
+	PhaseFetch      = "fetch"
+	PhaseUpsert     = "upsert"
+	PhaseCleanup    = "cleanup"
+	PhaseEmbeddings = "embeddings"
+	PhaseEPG        = "epg"
+	PhaseCheck      = "check"
+	PhaseIndex      = "index"
+	PhaseDone       = "done"
+	PhaseFailed     = "failed"
+)
+
+// Progress is a snapshot of a running ingest, embedding, EPG, check or index
+// pass. Processed and Total are channel counts within the current phase
+// (programme counts for PhaseEPG, where Total is unknown and left at zero;
+// index tuples for PhaseIndex).
+type Progress struct {
+	Phase     string
+	Processed int
+	Total     int
+	Embedded  int   // channels embedded so far, in PhaseEmbeddings and the PhaseDone after it
+	Skipped   int   // channels whose embedding text was unchanged, likewise
+	Tokens    int64 // embedding API tokens used so far, likewise
+	Err       error // set when Phase is PhaseFailed
+}
+
+// ProgressReporter receives progress updates from Ingest and RefreshEmbeddings.
+// Implementations must be safe for concurrent use: the background embedding
+// goroutine keeps reporting after Ingest has returned.
+type ProgressReporter interface {
+	Report(p Progress)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that carries rep. Ingest, IngestUpload and
+// RefreshEmbeddings report to it, including from background goroutines they
+// spawn.
+func WithProgress(ctx context.Context, rep ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressKey{}, rep)
+}
+
+// progressFrom returns the reporter attached to ctx, or nil.
+func progressFrom(ctx context.Context) ProgressReporter {
+	rep, _ := ctx.Value(progressKey{}).(ProgressReporter)
+	return rep
+}
+
+// report sends p to the reporter attached to ctx, if any.
+func report(ctx context.Context, p Progress) {
+	if rep := progressFrom(ctx); rep != nil {
+		rep.Report(p)
+	}
+}
+
+// reportFailure reports PhaseFailed when *errp is non-nil. It is meant to be
+// deferred with a pointer to a named error result.
+func reportFailure(ctx context.Context, errp *error) {
+	if *errp != nil {
+		report(ctx, Progress{Phase: PhaseFailed, Err: *errp})
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// classifyQuality records the quality tier and cleaned display name of each
+// entry (see fetcher.DetectQuality). DisplayName is only set when it differs
+// from the original name. Returns the number of entries with a known quality.
+func classifyQuality(entries []fetcher.ParsedEntry) int {
+	n := 0
+	for i := range entries {
+		ch := &entries[i].Channel
+		quality, display := fetcher.DetectQuality(ch.Name)
+		if quality != "" {
+			ch.Quality = &quality
+			n++
+		}
+		if display != ch.Name {
+			ch.DisplayName = &display
+		}
+	}
+	return n
+}
+
+// embeddingName returns the name used in embedding text: the display name
+// when there is one, so quality tokens do not skew semantic search.
+func embeddingName(ch *models.Channel) string {
+	if ch.DisplayName != nil && *ch.DisplayName != "" {
+		return *ch.DisplayName
+	}
+	return ch.Name
+}
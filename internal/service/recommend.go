@@ -0,0 +1,49 @@
+package service
+
+import "math"
+
+// Centroid returns the unit-length mean of vecs, the direction a search for
+// "more like these" should look in. Vectors of a different length than the
+// first are skipped. Returns nil if there is nothing to average or the
+// vectors cancel out.
+func Centroid(vecs [][]float32) []float32 {
+	if len(vecs) == 0 || len(vecs[0]) == 0 {
+		return nil
+	}
+	sum := make([]float64, len(vecs[0]))
+	for _, v := range vecs {
+		if len(v) != len(sum) {
+			continue
+		}
+		// Normalise first so that every vector weighs the same.
+		norm := l2Norm(v)
+		if norm == 0 {
+			continue
+		}
+		for i, x := range v {
+			sum[i] += float64(x) / norm
+		}
+	}
+
+	var sq float64
+	for _, x := range sum {
+		sq += x * x
+	}
+	norm := math.Sqrt(sq)
+	if norm == 0 {
+		return nil
+	}
+	out := make([]float32, len(sum))
+	for i, x := range sum {
+		out[i] = float32(x / norm)
+	}
+	return out
+}
+
+func l2Norm(v []float32) float64 {
+	var sq float64
+	for _, x := range v {
+		sq += float64(x) * float64(x)
+	}
+	return math.Sqrt(sq)
+}
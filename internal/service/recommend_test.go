@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/models"
+	"github.com/voyagen/popcornvault/internal/store"
+)
+
+func TestCentroid(t *testing.T) {
+	tests := []struct {
+		name string
+		vecs [][]float32
+		want []float32 // nil for no centroid
+	}{
+		{"none", nil, nil},
+		{"empty vector", [][]float32{{}}, nil},
+		{"one", [][]float32{{3, 4}}, []float32{0.6, 0.8}},
+		{"equal weight", [][]float32{{10, 0}, {0, 1}}, []float32{math.Sqrt2 / 2, math.Sqrt2 / 2}},
+		{"zero vector skipped", [][]float32{{0, 0}, {0, 2}}, []float32{0, 1}},
+		{"other length skipped", [][]float32{{1, 0}, {0, 1, 0}}, []float32{1, 0}},
+		{"cancel out", [][]float32{{1, 0}, {-1, 0}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Centroid(tt.vecs)
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("Centroid = %v, want nil", got)
+				}
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Centroid = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(float64(got[i]-tt.want[i])) > 1e-6 {
+					t.Fatalf("Centroid = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestRecommendFromFavorites ranks channels by similarity to the centroid
+// of the favorites' embeddings, as /api/channels/recommended does, and
+// checks that favorites and hidden channels are left out.
+func TestRecommendFromFavorites(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	src, err := mem.CreateCustomSource(ctx, "TV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	embeddings := []struct {
+		name string
+		vec  []float32
+	}{
+		{"News 1", []float32{1, 0, 0}},
+		{"News 2", []float32{0.9, 0.1, 0}},
+		{"Sport 1", []float32{0, 1, 0}},
+		{"Sport 2", []float32{0.1, 0.9, 0}},
+		{"News and Sport", []float32{0.7, 0.7, 0}},
+		{"Movie", []float32{0, 0, 1}},
+		{"Hidden News", []float32{1, 0, 0.01}},
+	}
+	ids := make(map[string]int64)
+	var allIDs []int64
+	var vecs [][]float32
+	for _, e := range embeddings {
+		id, err := mem.UpsertChannel(ctx, &models.Channel{Name: e.name, URL: "http://example.com/" + e.name, SourceID: src})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[e.name] = id
+		allIDs = append(allIDs, id)
+		vecs = append(vecs, e.vec)
+	}
+	// A channel without an embedding is never recommended.
+	if _, err := mem.UpsertChannel(ctx, &models.Channel{Name: "Unembedded", URL: "http://example.com/u", SourceID: src}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.StoreEmbeddings(ctx, allIDs, vecs, "test-model", make([]string, len(allIDs))); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetChannelHidden(ctx, ids["Hidden News"], true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		favorites []string
+		limit     int
+		want      string // recommended names, most similar first; empty for no centroid
+	}{
+		{"news fan", []string{"News 1"}, 3, "News 2,News and Sport,Sport 2"},
+		{"sport fan", []string{"Sport 1"}, 2, "Sport 2,News and Sport"},
+		{"both", []string{"News 1", "Sport 1"}, 3, "News and Sport,News 2,Sport 2"},
+		{"favorites excluded", []string{"News 1", "News 2", "News and Sport"}, 2, "Sport 2,Sport 1"},
+		{"movie fan", []string{"Movie"}, 1, "News 1"},
+		{"no favorites", nil, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var favIDs []int64
+			for _, name := range tt.favorites {
+				favIDs = append(favIDs, ids[name])
+			}
+			for _, id := range ids {
+				if err := mem.ToggleChannelFavorite(ctx, id, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range favIDs {
+				if err := mem.ToggleChannelFavorite(ctx, id, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			favVecs, err := mem.GetChannelEmbeddings(ctx, favIDs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var centroidOf [][]float32
+			for _, id := range favIDs {
+				centroidOf = append(centroidOf, favVecs[id])
+			}
+			centroid := Centroid(centroidOf)
+			if tt.want == "" {
+				if centroid != nil {
+					t.Fatalf("centroid = %v, want none", centroid)
+				}
+				return
+			}
+
+			notFav := false
+			results, _, err := mem.SemanticSearch(ctx, centroid, store.ChannelFilter{Favorite: &notFav, Limit: tt.limit})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, r := range results {
+				names = append(names, r.Channel.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Fatalf("recommended = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
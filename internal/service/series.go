@@ -0,0 +1,31 @@
+package service
+
+import (
+	"github.com/voyagen/popcornvault/internal/fetcher"
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// markEpisodes recognises series episodes among entries by their names (see
+// fetcher.ParseEpisode), recording series name, season and episode and
+// classifying them as MediaTypeSerie. With guess false only entries already
+// classified as series (Xtream /series/ URLs) are annotated, and no media
+// type changes. Returns the number of episodes found.
+func markEpisodes(entries []fetcher.ParsedEntry, guess bool) int {
+	n := 0
+	for i := range entries {
+		ch := &entries[i].Channel
+		if !guess && ch.MediaType != models.MediaTypeSerie {
+			continue
+		}
+		ep, ok := fetcher.ParseEpisode(ch.Name, ch.Group)
+		if !ok {
+			continue
+		}
+		ch.MediaType = models.MediaTypeSerie
+		ch.Series = &ep.Series
+		ch.Season = &ep.Season
+		ch.Episode = &ep.Episode
+		n++
+	}
+	return n
+}
@@ -4,67 +4,158 @@ import (
 	"context"
 	"crypto/sha256"
 	"fmt"
-	"log"
+	"slices"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/voyagen/popcornvault/internal/cache"
 	"github.com/voyagen/popcornvault/internal/models"
+	"golang.org/x/sync/singleflight"
 )
 
-// Cache TTLs for different entity types.
-const (
-	ttlSources  = 2 * time.Minute
-	ttlSource   = 5 * time.Minute
-	ttlChannels = 1 * time.Minute
-	ttlChannel  = 5 * time.Minute
-	ttlGroups   = 5 * time.Minute
-	ttlSearch   = 2 * time.Minute
-)
+// CacheOptions configures a CachedStore.
+type CacheOptions struct {
+	// Prefix is prepended to every key, so that several instances can
+	// share one Redis.
+	Prefix string
+
+	// How long each kind of entry is cached; 0 leaves that kind uncached.
+	TTLSources   time.Duration // the source list
+	TTLSource    time.Duration // one source
+	TTLChannels  time.Duration // channel lists
+	TTLChannel   time.Duration // one channel
+	TTLGroups    time.Duration
+	TTLSearch    time.Duration
+	TTLSeries    time.Duration
+	TTLPlaylists time.Duration
+	TTLNotFound  time.Duration // lookups of sources and channels that do not exist
+}
 
-// CachedStore wraps a Store with a Redis caching layer.
+// CachedStore wraps a Store with a caching layer in Redis, or in process
+// memory when there is no Redis.
 // Read-heavy operations are served from cache when possible;
 // write operations invalidate the relevant cache keys.
 type CachedStore struct {
 	inner Store
-	cache *cache.Redis
+	cache cache.Backend
+	opts  CacheOptions
+	fills singleflight.Group        // one inner call per missed key at a time
+	stale atomic.Bool               // an invalidation failed; flush once the cache answers
+	stats map[string]*cacheCounters // by entity; never written after NewCachedStore
 }
 
-// NewCachedStore creates a CachedStore that wraps inner with Redis caching.
-func NewCachedStore(inner Store, c *cache.Redis) *CachedStore {
-	return &CachedStore{inner: inner, cache: c}
+// NewCachedStore creates a CachedStore that wraps inner with caching in c.
+func NewCachedStore(inner Store, c cache.Backend, opts CacheOptions) *CachedStore {
+	stats := make(map[string]*cacheCounters, len(cacheEntities))
+	for _, e := range cacheEntities {
+		stats[e] = new(cacheCounters)
+	}
+	return &CachedStore{inner: inner, cache: c, opts: opts, stats: stats}
 }
 
+// Keys of the list caches. The version is bumped when the cached payload
+// changes shape, so entries written by an older build are not read back.
+const (
+	keySources   = "sources:v2:all"
+	keyGroups    = "groups:v2:%s" // source id or "all"
+	keyPlaylists = "playlists:all"
+	keyStats     = "stats:v1:all"
+)
+
+// statsTTL is how long Stats are cached. Writes do not clear them: totals
+// for a dashboard may lag by that much.
+const statsTTL = time.Minute
+
+// Keys of the entries holding channels. The version follows the source
+// scope, so the patterns of invalidateSourceLists still match.
+const (
+	keyChannel  = "channel:v3:%d"
+	keyChannels = "channels:%s:v3:%s"            // scope, filter hash
+	keyCount    = "channels:%s:v3:count:%s"      // scope, filter hash
+	keySearch   = "search:%s:v3:%s:%s"           // scope, vector hash, filter hash
+	keyHybrid   = "search:%s:v3:hybrid:%s:%s:%s" // scope, vector, text and filter hashes
+	keyEpisodes = "series:%s:episodes:v3:%x"     // source id or "all", name hash
+)
+
+// Channel lists and search results are cached per source, under
+// "channels:src:<id>:" and "search:src:<id>:", when the filter selects one
+// source, and under "channels:all:" and "search:all:" otherwise, so a write
+// to one source only clears its own entries and the unscoped ones (see
+// invalidateSourceLists).
+
+// sourceScope is the cache namespace of a list filtered by sourceID.
+func sourceScope(sourceID *int64) string {
+	if sourceID == nil {
+		return "all"
+	}
+	return fmt.Sprintf("src:%d", *sourceID)
+}
+
+// cacheEntities are the kinds of entries the CachedStore writes. Each key
+// starts with its kind and a colon, which is how statistics are kept per
+// kind (see CacheStats).
+var cacheEntities = []string{"sources", "source", "channels", "channel", "groups", "search", "series", "playlists", "stats"}
+
+// allCachePatterns match every key the CachedStore writes.
+var allCachePatterns = func() []string {
+	patterns := make([]string, len(cacheEntities))
+	for i, e := range cacheEntities {
+		patterns[i] = e + ":*"
+	}
+	return patterns
+}()
+
 // --- cached read operations ---
 
 func (c *CachedStore) ListSources(ctx context.Context) ([]models.Source, error) {
-	const key = "sources:all"
-	if v, err := cache.Get[[]models.Source](ctx, c.cache, key); err == nil {
-		return v, nil
+	return cachedFetch(ctx, c, keySources, c.opts.TTLSources, c.inner.ListSources)
+}
+
+// Bounds of WarmSource: the page size the API uses when none is asked for,
+// and the groups whose first page is cached.
+const (
+	warmPageSize  = 50
+	warmMaxGroups = 100
+)
+
+// WarmSource fills the entries a client opening sourceID reads first: the
+// source list, the source's groups, and the first page of its channels and
+// of each of its first warmMaxGroups groups. Entries still cached are left
+// as they are. It stops at the first error.
+func (c *CachedStore) WarmSource(ctx context.Context, sourceID int64) error {
+	if _, err := c.ListSources(ctx); err != nil {
+		return fmt.Errorf("warm sources: %w", err)
 	}
-	sources, err := c.inner.ListSources(ctx)
+	groups, err := c.ListGroups(ctx, &sourceID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("warm groups: %w", err)
 	}
-	if err := cache.Set(ctx, c.cache, key, sources, ttlSources); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
+	filters := []ChannelFilter{{SourceID: &sourceID, Limit: warmPageSize}}
+	for _, g := range groups[:min(len(groups), warmMaxGroups)] {
+		filters = append(filters, ChannelFilter{SourceID: &sourceID, GroupIDs: []int64{g.ID}, Limit: warmPageSize})
 	}
-	return sources, nil
+	for _, f := range filters {
+		if _, _, err := c.ListChannels(ctx, f); err != nil {
+			return fmt.Errorf("warm channels: %w", err)
+		}
+	}
+	return nil
 }
 
 func (c *CachedStore) GetSourceByID(ctx context.Context, sourceID int64) (*models.Source, error) {
-	key := fmt.Sprintf("source:%d", sourceID)
-	if v, err := cache.Get[models.Source](ctx, c.cache, key); err == nil {
-		return &v, nil
-	}
-	src, err := c.inner.GetSourceByID(ctx, sourceID)
+	src, err := cachedFetch(ctx, c, fmt.Sprintf("source:%d", sourceID), c.opts.TTLSource, func(ctx context.Context) (models.Source, error) {
+		src, err := c.inner.GetSourceByID(ctx, sourceID)
+		if err != nil {
+			return models.Source{}, err
+		}
+		return *src, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := cache.Set(ctx, c.cache, key, src, ttlSource); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
-	}
-	return src, nil
+	return &src, nil
 }
 
 // channelListResult is a helper type to cache the ListChannels tuple.
@@ -74,33 +165,41 @@ type channelListResult struct {
 }
 
 func (c *CachedStore) ListChannels(ctx context.Context, filter ChannelFilter) ([]models.Channel, int, error) {
-	key := fmt.Sprintf("channels:%s", filterHash(filter))
-	if v, err := cache.Get[channelListResult](ctx, c.cache, key); err == nil {
-		return v.Channels, v.Total, nil
-	}
-	channels, total, err := c.inner.ListChannels(ctx, filter)
+	key := fmt.Sprintf(keyChannels, sourceScope(filter.SourceID), filterHash(filter))
+	v, err := cachedFetch(ctx, c, key, c.opts.TTLChannels, func(ctx context.Context) (channelListResult, error) {
+		channels, total, err := c.inner.ListChannels(ctx, filter)
+		return channelListResult{Channels: channels, Total: total}, err
+	})
 	if err != nil {
 		return nil, 0, err
 	}
-	if err := cache.Set(ctx, c.cache, key, channelListResult{Channels: channels, Total: total}, ttlChannels); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
-	}
-	return channels, total, nil
+	return v.Channels, v.Total, nil
+}
+
+// CountChannels is cached with the channel lists, and cleared with them.
+func (c *CachedStore) CountChannels(ctx context.Context, filter ChannelFilter) (int, error) {
+	key := fmt.Sprintf(keyCount, sourceScope(filter.SourceID), filterHash(filter))
+	return cachedFetch(ctx, c, key, c.opts.TTLChannels, func(ctx context.Context) (int, error) {
+		return c.inner.CountChannels(ctx, filter)
+	})
+}
+
+func (c *CachedStore) Stats(ctx context.Context) (models.Stats, error) {
+	return cachedFetch(ctx, c, keyStats, statsTTL, c.inner.Stats)
 }
 
 func (c *CachedStore) GetChannelByID(ctx context.Context, channelID int64) (*models.Channel, error) {
-	key := fmt.Sprintf("channel:%d", channelID)
-	if v, err := cache.Get[models.Channel](ctx, c.cache, key); err == nil {
-		return &v, nil
-	}
-	ch, err := c.inner.GetChannelByID(ctx, channelID)
+	ch, err := cachedFetch(ctx, c, fmt.Sprintf(keyChannel, channelID), c.opts.TTLChannel, func(ctx context.Context) (models.Channel, error) {
+		ch, err := c.inner.GetChannelByID(ctx, channelID)
+		if err != nil {
+			return models.Channel{}, err
+		}
+		return *ch, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := cache.Set(ctx, c.cache, key, ch, ttlChannel); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
-	}
-	return ch, nil
+	return &ch, nil
 }
 
 func (c *CachedStore) ListGroups(ctx context.Context, sourceID *int64) ([]models.Group, error) {
@@ -108,38 +207,65 @@ func (c *CachedStore) ListGroups(ctx context.Context, sourceID *int64) ([]models
 	if sourceID != nil {
 		sid = fmt.Sprintf("%d", *sourceID)
 	}
-	key := fmt.Sprintf("groups:%s", sid)
-	if v, err := cache.Get[[]models.Group](ctx, c.cache, key); err == nil {
-		return v, nil
-	}
-	groups, err := c.inner.ListGroups(ctx, sourceID)
-	if err != nil {
-		return nil, err
+	return cachedFetch(ctx, c, fmt.Sprintf(keyGroups, sid), c.opts.TTLGroups, func(ctx context.Context) ([]models.Group, error) {
+		return c.inner.ListGroups(ctx, sourceID)
+	})
+}
+
+func (c *CachedStore) ListSeries(ctx context.Context, sourceID *int64) ([]models.Series, error) {
+	sid := "all"
+	if sourceID != nil {
+		sid = fmt.Sprintf("%d", *sourceID)
 	}
-	if err := cache.Set(ctx, c.cache, key, groups, ttlGroups); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
+	return cachedFetch(ctx, c, fmt.Sprintf("series:%s", sid), c.opts.TTLSeries, func(ctx context.Context) ([]models.Series, error) {
+		return c.inner.ListSeries(ctx, sourceID)
+	})
+}
+
+func (c *CachedStore) ListSeriesEpisodes(ctx context.Context, name string, sourceID *int64) ([]models.Channel, error) {
+	sid := "all"
+	if sourceID != nil {
+		sid = fmt.Sprintf("%d", *sourceID)
 	}
-	return groups, nil
+	h := sha256.Sum256([]byte(name))
+	key := fmt.Sprintf(keyEpisodes, sid, h[:8])
+	return cachedFetch(ctx, c, key, c.opts.TTLSeries, func(ctx context.Context) ([]models.Channel, error) {
+		return c.inner.ListSeriesEpisodes(ctx, name, sourceID)
+	})
 }
 
 // semanticSearchResult caches the SemanticSearch return value.
 type semanticSearchResult struct {
 	Results []SemanticResult `json:"results"`
+	Total   int              `json:"total"`
 }
 
-func (c *CachedStore) SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, error) {
-	key := fmt.Sprintf("search:%s:%s", vecHash(queryVec), filterHash(filter))
-	if v, err := cache.Get[semanticSearchResult](ctx, c.cache, key); err == nil {
-		return v.Results, nil
-	}
-	results, err := c.inner.SemanticSearch(ctx, queryVec, filter)
+func (c *CachedStore) SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, int, error) {
+	key := fmt.Sprintf(keySearch, sourceScope(filter.SourceID), vecHash(queryVec), filterHash(filter))
+	v, err := cachedFetch(ctx, c, key, c.opts.TTLSearch, func(ctx context.Context) (semanticSearchResult, error) {
+		results, total, err := c.inner.SemanticSearch(ctx, queryVec, filter)
+		return semanticSearchResult{Results: results, Total: total}, err
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	if err := cache.Set(ctx, c.cache, key, semanticSearchResult{Results: results}, ttlSearch); err != nil {
-		log.Printf("cache: set %s: %v", key, err)
+	return v.Results, v.Total, nil
+}
+
+func (c *CachedStore) HybridSearch(ctx context.Context, queryVec []float32, query string, filter ChannelFilter) ([]SemanticResult, int, error) {
+	key := fmt.Sprintf(keyHybrid, sourceScope(filter.SourceID), vecHash(queryVec), textHash(query), filterHash(filter))
+	v, err := cachedFetch(ctx, c, key, c.opts.TTLSearch, func(ctx context.Context) (semanticSearchResult, error) {
+		results, total, err := c.inner.HybridSearch(ctx, queryVec, query, filter)
+		return semanticSearchResult{Results: results, Total: total}, err
+	})
+	if err != nil {
+		return nil, 0, err
 	}
-	return results, nil
+	return v.Results, v.Total, nil
+}
+
+func (c *CachedStore) ListPlaylists(ctx context.Context) ([]models.Playlist, error) {
+	return cachedFetch(ctx, c, keyPlaylists, c.opts.TTLPlaylists, c.inner.ListPlaylists)
 }
 
 // --- write operations with cache invalidation ---
@@ -149,15 +275,48 @@ func (c *CachedStore) CreateOrGetSource(ctx context.Context, name, url string, s
 	if err != nil {
 		return 0, err
 	}
-	c.invalidate(ctx, "sources:all")
+	// The key may hold a lookup of the id from before it existed.
+	c.invalidate(ctx, fmt.Sprintf("source:%d", id), keySources)
+	return id, nil
+}
+
+func (c *CachedStore) CreateCustomSource(ctx context.Context, name string) (int64, error) {
+	id, err := c.inner.CreateCustomSource(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidate(ctx, fmt.Sprintf("source:%d", id), keySources)
 	return id, nil
 }
 
+func (c *CachedStore) AddChannel(ctx context.Context, ch *models.Channel, h *models.ChannelHttpHeaders) (int64, error) {
+	id, err := c.inner.AddChannel(ctx, ch, h)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, id))
+	c.invalidateSourceLists(ctx, ch.SourceID)
+	return id, nil
+}
+
+func (c *CachedStore) DeleteChannel(ctx context.Context, channelID int64) error {
+	if err := c.inner.DeleteChannel(ctx, channelID); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID), keySources, keyPlaylists)
+	c.invalidatePattern(ctx, "channels:*", "groups:*", "search:*", "series:*")
+	return nil
+}
+
 func (c *CachedStore) UpdateSource(ctx context.Context, sourceID int64, fields SourceUpdate) error {
 	if err := c.inner.UpdateSource(ctx, sourceID, fields); err != nil {
 		return err
 	}
-	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), "sources:all")
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keySources)
+	if fields.Name != nil {
+		// Channels carry the source name.
+		c.invalidatePattern(ctx, "channels:*", "channel:*", "search:*", "series:*")
+	}
 	return nil
 }
 
@@ -165,8 +324,7 @@ func (c *CachedStore) DeleteSource(ctx context.Context, sourceID int64) error {
 	if err := c.inner.DeleteSource(ctx, sourceID); err != nil {
 		return err
 	}
-	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), "sources:all")
-	c.invalidatePattern(ctx, "channels:*", "groups:*", "search:*")
+	c.InvalidateSource(ctx, sourceID)
 	return nil
 }
 
@@ -174,7 +332,31 @@ func (c *CachedStore) UpdateSourceLastUpdated(ctx context.Context, sourceID int6
 	if err := c.inner.UpdateSourceLastUpdated(ctx, sourceID); err != nil {
 		return err
 	}
-	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), "sources:all")
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keySources)
+	return nil
+}
+
+func (c *CachedStore) UpdateSourceValidators(ctx context.Context, sourceID int64, etag, lastModified, contentHash string, parserVersion int) error {
+	if err := c.inner.UpdateSourceValidators(ctx, sourceID, etag, lastModified, contentHash, parserVersion); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keySources)
+	return nil
+}
+
+func (c *CachedStore) UpdateSourceEmbeddingRun(ctx context.Context, sourceID int64, run models.EmbeddingRun) error {
+	if err := c.inner.UpdateSourceEmbeddingRun(ctx, sourceID, run); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keySources)
+	return nil
+}
+
+func (c *CachedStore) SetPlaylistEPGURL(ctx context.Context, sourceID int64, epgURL string) error {
+	if err := c.inner.SetPlaylistEPGURL(ctx, sourceID, epgURL); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keySources)
 	return nil
 }
 
@@ -184,99 +366,535 @@ func (c *CachedStore) UpsertChannel(ctx context.Context, ch *models.Channel) (in
 		return 0, err
 	}
 	// Individual channel caches and list caches may be stale.
-	c.invalidate(ctx, fmt.Sprintf("channel:%d", id))
-	c.invalidatePattern(ctx, "channels:*")
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, id))
+	c.invalidateSourceLists(ctx, ch.SourceID)
 	return id, nil
 }
 
+func (c *CachedStore) RekeyChannelsByTvgID(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	n, err := c.inner.RekeyChannelsByTvgID(ctx, sourceID, keys)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		c.invalidatePattern(ctx, "channel:*")
+		c.invalidateSourceLists(ctx, sourceID)
+	}
+	return n, nil
+}
+
+// BulkUpsertChannels uses the inner store's bulk path when it has one and
+// invalidates list caches once for the whole batch.
+func (c *CachedStore) BulkUpsertChannels(ctx context.Context, channels []models.Channel) ([]int64, error) {
+	var ids []int64
+	if bulk, ok := c.inner.(BulkChannelUpserter); ok {
+		var err error
+		if ids, err = bulk.BulkUpsertChannels(ctx, channels); err != nil {
+			return nil, err
+		}
+	} else {
+		ids = make([]int64, len(channels))
+		for i := range channels {
+			id, err := c.inner.UpsertChannel(ctx, &channels[i])
+			if err != nil {
+				return nil, err
+			}
+			ids[i] = id
+		}
+	}
+
+	keys := make([]string, len(ids))
+	sources := make(map[int64]bool)
+	for i, id := range ids {
+		keys[i] = fmt.Sprintf(keyChannel, id)
+		sources[channels[i].SourceID] = true
+	}
+	c.invalidate(ctx, keys...)
+	for sourceID := range sources {
+		c.invalidateSourceLists(ctx, sourceID)
+	}
+	return ids, nil
+}
+
 func (c *CachedStore) UpsertChannelHeaders(ctx context.Context, channelID int64, h *models.ChannelHttpHeaders) error {
-	return c.inner.UpsertChannelHeaders(ctx, channelID, h)
+	if err := c.inner.UpsertChannelHeaders(ctx, channelID, h); err != nil {
+		return err
+	}
+	// Ingest upserts the channel itself first, which invalidates its entry.
+	if h.Edited {
+		c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID))
+	}
+	return nil
+}
+
+func (c *CachedStore) BulkUpsertChannelHeaders(ctx context.Context, headers map[int64]*models.ChannelHttpHeaders) error {
+	if err := c.inner.BulkUpsertChannelHeaders(ctx, headers); err != nil {
+		return err
+	}
+	// As in UpsertChannelHeaders, only edited headers need the channel's
+	// entry dropped here.
+	var keys []string
+	for id, h := range headers {
+		if h != nil && h.Edited {
+			keys = append(keys, fmt.Sprintf(keyChannel, id))
+		}
+	}
+	c.invalidate(ctx, keys...)
+	return nil
+}
+
+func (c *CachedStore) GetChannelEmbedding(ctx context.Context, channelID int64) ([]float32, error) {
+	return c.inner.GetChannelEmbedding(ctx, channelID)
+}
+
+func (c *CachedStore) EmbeddingTextHashes(ctx context.Context, channelIDs []int64, model string) (map[int64]string, error) {
+	return c.inner.EmbeddingTextHashes(ctx, channelIDs, model)
+}
+
+func (c *CachedStore) GetChannelEmbeddings(ctx context.Context, channelIDs []int64) (map[int64][]float32, error) {
+	return c.inner.GetChannelEmbeddings(ctx, channelIDs)
+}
+
+func (c *CachedStore) RebuildEmbeddingIndex(ctx context.Context, onProgress func(done, total int64)) error {
+	return c.inner.RebuildEmbeddingIndex(ctx, onProgress)
+}
+
+func (c *CachedStore) GetChannelHeaders(ctx context.Context, channelID int64) (*models.ChannelHttpHeaders, error) {
+	return c.inner.GetChannelHeaders(ctx, channelID)
+}
+
+func (c *CachedStore) UpsertChannelProps(ctx context.Context, channelID int64, props map[string]string) error {
+	return c.inner.UpsertChannelProps(ctx, channelID, props)
+}
+
+func (c *CachedStore) BulkUpsertChannelProps(ctx context.Context, props map[int64]map[string]string) error {
+	return c.inner.BulkUpsertChannelProps(ctx, props)
 }
 
 func (c *CachedStore) ToggleChannelFavorite(ctx context.Context, channelID int64, favorite bool) error {
 	if err := c.inner.ToggleChannelFavorite(ctx, channelID, favorite); err != nil {
 		return err
 	}
-	c.invalidate(ctx, fmt.Sprintf("channel:%d", channelID))
-	c.invalidatePattern(ctx, "channels:*")
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID))
+	c.invalidatePattern(ctx, "channels:*", "series:*")
 	return nil
 }
 
-func (c *CachedStore) RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64) (int64, error) {
-	n, err := c.inner.RemoveStaleChannels(ctx, sourceID, keepIDs)
+func (c *CachedStore) RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64, archive bool) ([]models.ChannelRef, error) {
+	removed, err := c.inner.RemoveStaleChannels(ctx, sourceID, keepIDs, archive)
+	if err != nil {
+		return nil, err
+	}
+	if len(removed) > 0 {
+		keys := make([]string, len(removed))
+		for i, ch := range removed {
+			keys[i] = fmt.Sprintf(keyChannel, ch.ID)
+		}
+		c.invalidate(ctx, append(keys, keyPlaylists)...)
+		c.invalidateSourceLists(ctx, sourceID)
+	}
+	return removed, nil
+}
+
+func (c *CachedStore) RestoreArchivedChannels(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	n, err := c.inner.RestoreArchivedChannels(ctx, sourceID, keys)
 	if err != nil {
 		return 0, err
 	}
 	if n > 0 {
-		c.invalidatePattern(ctx, "channels:*", "channel:*")
+		// Restored channels may be cached as missing.
+		c.invalidatePattern(ctx, "channel:*")
+		c.invalidateSourceLists(ctx, sourceID)
+	}
+	return n, nil
+}
+
+func (c *CachedStore) RestoreArchivedChannel(ctx context.Context, channelID int64) error {
+	if err := c.inner.RestoreArchivedChannel(ctx, channelID); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID), keySources)
+	c.invalidatePattern(ctx, "channels:*", "groups:*", "search:*", "series:*")
+	return nil
+}
+
+func (c *CachedStore) RemoveOrphanedGroups(ctx context.Context, sourceID int64) ([]models.GroupRef, error) {
+	removed, err := c.inner.RemoveOrphanedGroups(ctx, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(removed) > 0 {
+		c.invalidate(ctx, keySources, fmt.Sprintf(keyGroups, strconv.FormatInt(sourceID, 10)), fmt.Sprintf(keyGroups, "all"))
+	}
+	return removed, nil
+}
+
+func (c *CachedStore) UpdateGroup(ctx context.Context, groupID int64, fields GroupUpdate) error {
+	if err := c.inner.UpdateGroup(ctx, groupID, fields); err != nil {
+		return err
+	}
+	// Channels carry the group name and image.
+	c.invalidatePattern(ctx, "groups:*", "channels:*", "channel:*", "search:*", "series:*")
+	return nil
+}
+
+func (c *CachedStore) DeleteGroup(ctx context.Context, groupID int64, deleteChannels bool) (int64, error) {
+	n, err := c.inner.DeleteGroup(ctx, groupID, deleteChannels)
+	if err != nil {
+		return 0, err
 	}
+	c.invalidate(ctx, keySources, keyPlaylists)
+	c.invalidatePattern(ctx, "groups:*", "channels:*", "channel:*", "search:*", "series:*")
 	return n, nil
 }
 
-func (c *CachedStore) RemoveOrphanedGroups(ctx context.Context, sourceID int64) (int64, error) {
-	n, err := c.inner.RemoveOrphanedGroups(ctx, sourceID)
+func (c *CachedStore) MergeGroups(ctx context.Context, targetID int64, groupIDs []int64) (int64, error) {
+	n, err := c.inner.MergeGroups(ctx, targetID, groupIDs)
 	if err != nil {
 		return 0, err
 	}
-	if n > 0 {
+	c.invalidate(ctx, keySources)
+	c.invalidatePattern(ctx, "groups:*", "channels:*", "channel:*", "search:*")
+	return n, nil
+}
+
+func (c *CachedStore) UpdateChannelStatuses(ctx context.Context, checks []ChannelCheck) error {
+	if err := c.inner.UpdateChannelStatuses(ctx, checks); err != nil {
+		return err
+	}
+	c.invalidatePattern(ctx, "channels:*", "channel:*", "series:*", "search:*")
+	return nil
+}
+
+func (c *CachedStore) PruneFailingChannels(ctx context.Context, sourceID int64, threshold int, remove bool) (int64, int64, error) {
+	hidden, deleted, err := c.inner.PruneFailingChannels(ctx, sourceID, threshold, remove)
+	if err != nil {
+		return 0, 0, err
+	}
+	if hidden > 0 || deleted > 0 {
+		c.invalidatePattern(ctx, "channel:*")
+		c.invalidateSourceLists(ctx, sourceID)
+	}
+	if deleted > 0 {
+		c.invalidate(ctx, keyPlaylists)
+	}
+	return hidden, deleted, nil
+}
+
+func (c *CachedStore) UpdateChannel(ctx context.Context, channelID int64, fields ChannelUpdate) error {
+	if err := c.inner.UpdateChannel(ctx, channelID, fields); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID))
+	c.invalidatePattern(ctx, "channels:*", "series:*", "search:*")
+	if fields.GroupID != nil {
+		// Group channel counts changed.
 		c.invalidatePattern(ctx, "groups:*")
 	}
+	return nil
+}
+
+// BulkUpdateChannels invalidates the channel caches once for the whole
+// update.
+func (c *CachedStore) BulkUpdateChannels(ctx context.Context, ids []int64, filter ChannelFilter, fields ChannelFlags) (int64, error) {
+	n, err := c.inner.BulkUpdateChannels(ctx, ids, filter, fields)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		c.invalidatePattern(ctx, "channels:*", "channel:*", "series:*", "search:*")
+	}
 	return n, nil
 }
 
-func (c *CachedStore) StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32) error {
-	if err := c.inner.StoreEmbeddings(ctx, channelIDs, embeddings); err != nil {
+func (c *CachedStore) SetChannelHidden(ctx context.Context, channelID int64, hidden bool) error {
+	if err := c.inner.SetChannelHidden(ctx, channelID, hidden); err != nil {
+		return err
+	}
+	c.invalidate(ctx, fmt.Sprintf(keyChannel, channelID))
+	c.invalidatePattern(ctx, "channels:*", "search:*")
+	return nil
+}
+
+func (c *CachedStore) StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32, model string, textHashes []string) error {
+	if err := c.inner.StoreEmbeddings(ctx, channelIDs, embeddings, model, textHashes); err != nil {
 		return err
 	}
 	c.invalidatePattern(ctx, "search:*")
 	return nil
 }
 
+func (c *CachedStore) CreatePlaylist(ctx context.Context, name, description string) (int64, error) {
+	id, err := c.inner.CreatePlaylist(ctx, name, description)
+	if err != nil {
+		return 0, err
+	}
+	c.invalidate(ctx, keyPlaylists)
+	return id, nil
+}
+
+func (c *CachedStore) UpdatePlaylist(ctx context.Context, playlistID int64, fields PlaylistUpdate) error {
+	if err := c.inner.UpdatePlaylist(ctx, playlistID, fields); err != nil {
+		return err
+	}
+	c.invalidate(ctx, keyPlaylists)
+	return nil
+}
+
+func (c *CachedStore) DeletePlaylist(ctx context.Context, playlistID int64) error {
+	if err := c.inner.DeletePlaylist(ctx, playlistID); err != nil {
+		return err
+	}
+	c.invalidate(ctx, keyPlaylists)
+	c.invalidatePattern(ctx, "channels:*")
+	return nil
+}
+
+func (c *CachedStore) AddPlaylistChannel(ctx context.Context, playlistID, channelID int64) (bool, error) {
+	added, err := c.inner.AddPlaylistChannel(ctx, playlistID, channelID)
+	if err != nil {
+		return false, err
+	}
+	if added {
+		c.invalidate(ctx, keyPlaylists)
+		c.invalidatePattern(ctx, "channels:*")
+	}
+	return added, nil
+}
+
+func (c *CachedStore) RemovePlaylistChannel(ctx context.Context, playlistID, channelID int64) error {
+	if err := c.inner.RemovePlaylistChannel(ctx, playlistID, channelID); err != nil {
+		return err
+	}
+	c.invalidate(ctx, keyPlaylists)
+	c.invalidatePattern(ctx, "channels:*")
+	return nil
+}
+
+// RecordWatch invalidates the channel lists only when a new watch event
+// starts; position updates do not change what the watched filter selects.
+func (c *CachedStore) RecordWatch(ctx context.Context, channelID int64, position int, duration *int, sessionStart time.Time) (*models.WatchEvent, bool, error) {
+	e, started, err := c.inner.RecordWatch(ctx, channelID, position, duration, sessionStart)
+	if err != nil {
+		return nil, false, err
+	}
+	if started {
+		c.invalidatePattern(ctx, "channels:*")
+	}
+	return e, started, nil
+}
+
+func (c *CachedStore) PruneWatchEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	n, err := c.inner.PruneWatchEvents(ctx, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		c.invalidatePattern(ctx, "channels:*")
+	}
+	return n, nil
+}
+
+// WithTx runs fn inside the inner store's transaction. fn receives the
+// uncached transactional store, so nothing is invalidated per row; the
+// caller calls InvalidateSource once the transaction has committed.
+func (c *CachedStore) WithTx(ctx context.Context, fn func(Store) error) error {
+	txs, ok := c.inner.(Transactor)
+	if !ok {
+		return fn(c)
+	}
+	return txs.WithTx(ctx, fn)
+}
+
+// InvalidateSource drops every cached entry that may hold data of the
+// source: the source itself, its channels and the lists they appear in.
+func (c *CachedStore) InvalidateSource(ctx context.Context, sourceID int64) {
+	c.invalidate(ctx, fmt.Sprintf("source:%d", sourceID), keyPlaylists)
+	// Channel entries are keyed by channel id alone.
+	c.invalidatePattern(ctx, "channel:*")
+	c.invalidateSourceLists(ctx, sourceID)
+}
+
+// FlushCache deletes every entry this store cached, whatever its state:
+// unlike the invalidations it runs while Redis is being bypassed, and it
+// reports errors.
+func (c *CachedStore) FlushCache(ctx context.Context) error {
+	patterns := []string{"*"}
+	if c.opts.Prefix == "" {
+		// Without a prefix "*" would take the job queue along.
+		patterns = allCachePatterns
+	}
+	for _, p := range patterns {
+		if err := cache.DelPattern(ctx, c.cache, c.opts.Prefix+p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateSourceLists drops the cached lists a change to the channels or
+// groups of one source can affect: the source list (channel counts), its
+// own and the unscoped channel lists, searches, groups and series.
+func (c *CachedStore) invalidateSourceLists(ctx context.Context, sourceID int64) {
+	sid := strconv.FormatInt(sourceID, 10)
+	c.invalidate(ctx, keySources, fmt.Sprintf(keyGroups, sid), fmt.Sprintf(keyGroups, "all"), "series:"+sid, "series:all")
+	c.invalidatePattern(ctx, "channels:src:"+sid+":*", "channels:all:*", "search:src:"+sid+":*", "search:all:*",
+		"series:"+sid+":*", "series:all:*")
+}
+
 // --- passthrough (no caching) ---
 
 func (c *CachedStore) GetOrCreateGroup(ctx context.Context, sourceID int64, name string, image *string) (int64, error) {
 	return c.inner.GetOrCreateGroup(ctx, sourceID, name, image)
 }
 
+func (c *CachedStore) GetGroup(ctx context.Context, groupID int64) (*models.Group, error) {
+	return c.inner.GetGroup(ctx, groupID)
+}
+
+func (c *CachedStore) GetPlaylist(ctx context.Context, playlistID int64) (*models.Playlist, error) {
+	return c.inner.GetPlaylist(ctx, playlistID)
+}
+
+func (c *CachedStore) ListArchivedChannels(ctx context.Context, sourceID int64, limit, offset int) ([]models.ArchivedChannel, int, error) {
+	return c.inner.ListArchivedChannels(ctx, sourceID, limit, offset)
+}
+
+func (c *CachedStore) PruneArchivedChannels(ctx context.Context, cutoff time.Time) (int64, error) {
+	return c.inner.PruneArchivedChannels(ctx, cutoff)
+}
+
+func (c *CachedStore) GetWatchPosition(ctx context.Context, channelID int64) (*models.WatchEvent, error) {
+	return c.inner.GetWatchPosition(ctx, channelID)
+}
+
+func (c *CachedStore) ListWatchHistory(ctx context.Context, limit int, inProgress bool) ([]models.WatchHistoryEntry, error) {
+	return c.inner.ListWatchHistory(ctx, limit, inProgress)
+}
+
+func (c *CachedStore) ChannelStates(ctx context.Context, sourceID int64) (map[int64]ChannelState, error) {
+	return c.inner.ChannelStates(ctx, sourceID)
+}
+
+func (c *CachedStore) RecordRefreshRun(ctx context.Context, run *models.RefreshRun) error {
+	return c.inner.RecordRefreshRun(ctx, run)
+}
+
+func (c *CachedStore) ListRefreshRuns(ctx context.Context, sourceID int64, limit int) ([]models.RefreshRun, error) {
+	return c.inner.ListRefreshRuns(ctx, sourceID, limit)
+}
+
 func (c *CachedStore) CountChannelsBySource(ctx context.Context, sourceID int64) (int64, error) {
 	return c.inner.CountChannelsBySource(ctx, sourceID)
 }
 
+func (c *CachedStore) CountFavorites(ctx context.Context, sourceID *int64, includeHidden bool) (int, error) {
+	return c.inner.CountFavorites(ctx, sourceID, includeHidden)
+}
+
+func (c *CachedStore) StreamChannels(ctx context.Context, filter ChannelFilter, fn func(*models.Channel, *models.ChannelHttpHeaders) error) error {
+	return c.inner.StreamChannels(ctx, filter, fn)
+}
+
 func (c *CachedStore) ListChannelsBySource(ctx context.Context, sourceID int64) ([]models.Channel, error) {
 	return c.inner.ListChannelsBySource(ctx, sourceID)
 }
 
-func (c *CachedStore) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, limit int) ([]models.Channel, error) {
-	return c.inner.ListChannelsWithoutEmbeddings(ctx, sourceID, limit)
+func (c *CachedStore) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, model string, limit int) ([]models.Channel, error) {
+	return c.inner.ListChannelsWithoutEmbeddings(ctx, sourceID, model, limit)
+}
+
+func (c *CachedStore) EmbeddingStats(ctx context.Context, sourceID int64, model string) (EmbeddingStats, error) {
+	return c.inner.EmbeddingStats(ctx, sourceID, model)
+}
+
+func (c *CachedStore) ListChannelTvgIDs(ctx context.Context, sourceID int64) ([]string, error) {
+	return c.inner.ListChannelTvgIDs(ctx, sourceID)
+}
+
+func (c *CachedStore) ReplaceEPGPrograms(ctx context.Context, sourceID int64, next func() (*models.EPGProgram, error)) (int64, error) {
+	return c.inner.ReplaceEPGPrograms(ctx, sourceID, next)
+}
+
+func (c *CachedStore) ListChannelEPG(ctx context.Context, channelID int64, from, to time.Time) ([]models.EPGProgram, error) {
+	return c.inner.ListChannelEPG(ctx, channelID, from, to)
+}
+
+func (c *CachedStore) Ping(ctx context.Context) error {
+	return c.inner.Ping(ctx)
+}
+
+func (c *CachedStore) MigrationVersion(ctx context.Context) (int64, bool, error) {
+	return c.inner.MigrationVersion(ctx)
 }
 
 // --- helpers ---
 
-// invalidate deletes exact cache keys, logging any errors.
+// invalidate deletes exact cache keys. When that fails the cache is
+// flushed as soon as it answers again (see cacheOK).
 func (c *CachedStore) invalidate(ctx context.Context, keys ...string) {
-	if err := cache.Del(ctx, c.cache, keys...); err != nil && err != redis.Nil {
-		log.Printf("cache: del %v: %v", keys, err)
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.opts.Prefix + key
+		c.count(key).invalidations.Add(1)
+	}
+	if err := cache.Del(ctx, c.cache, prefixed...); err != nil && err != redis.Nil {
+		c.invalidateFailed(ctx, err)
 	}
 }
 
-// invalidatePattern deletes all keys matching the given glob patterns.
+// invalidatePattern deletes all keys matching the given glob patterns,
+// with failures handled like invalidate's.
 func (c *CachedStore) invalidatePattern(ctx context.Context, patterns ...string) {
 	for _, p := range patterns {
-		if err := cache.DelPattern(ctx, c.cache, p); err != nil {
-			log.Printf("cache: del pattern %s: %v", p, err)
+		c.count(p).invalidations.Add(1)
+		if err := cache.DelPattern(ctx, c.cache, c.opts.Prefix+p); err != nil {
+			c.invalidateFailed(ctx, err)
+			return
 		}
 	}
 }
 
+// invalidateFailed marks the cache stale after a failed invalidation.
+func (c *CachedStore) invalidateFailed(ctx context.Context, err error) {
+	c.stale.Store(true)
+	logCacheErr(ctx, "cache: invalidate", err)
+}
+
 // filterHash produces a short deterministic hash for a ChannelFilter so it
-// can be used as part of a cache key.
+// can be used as part of a cache key. Pointers are hashed by value and group
+// lists sorted, so equal filters share a key whatever the parameter order.
 func filterHash(f ChannelFilter) string {
-	raw := fmt.Sprintf("%v|%v|%v|%v|%s|%d|%d",
-		f.SourceID, f.GroupID, f.MediaType, f.Favorite, f.Search, f.Limit, f.Offset)
+	var after string
+	if f.After != nil {
+		after = f.After.Encode()
+	}
+	var since string
+	if f.AddedSince != nil {
+		since = f.AddedSince.UTC().Format(time.RFC3339Nano)
+	}
+	raw := fmt.Sprintf("%v|%v|%v|%v|%v|%v|%v|%v|%v|%s|%s|%s|%s|%t|%s|%t|%g|%d|%s|%s|%d|%d|%s|%t",
+		deref(f.SourceID), deref(f.ExcludeSourceID), deref(f.GroupID), slices.Sorted(slices.Values(f.GroupIDs)),
+		slices.Sorted(slices.Values(f.ExcludeGroupIDs)), deref(f.PlaylistID), deref(f.Watched), deref(f.MediaType), deref(f.Favorite), f.TvgID, f.Quality, f.Status, since,
+		f.IncludeHidden, f.Search, f.Rank, f.MinSimilarity, f.SearchAccuracy, f.EmbeddingModel, f.Sort, f.Limit, f.Offset, after, f.NoTotal)
 	h := sha256.Sum256([]byte(raw))
 	return fmt.Sprintf("%x", h[:8])
 }
 
+// deref returns *p, or nil when p is nil, for formatting.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// textHash produces a short hash for a string.
+func textHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("%x", h[:8])
+}
+
 // vecHash produces a short hash for a float32 vector.
 func vecHash(v []float32) string {
 	raw := fmt.Sprintf("%v", v)
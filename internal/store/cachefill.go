@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/voyagen/popcornvault/internal/cache"
+	"golang.org/x/sync/singleflight"
+)
+
+// fillTimeout bounds a shared cache fill, which outlives the caller that
+// started it.
+const fillTimeout = time.Minute
+
+type noCacheKey struct{}
+
+// WithoutCache returns a context whose CachedStore reads skip the cache and
+// go to the inner store. What they read is still cached for later reads.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cacheSkipped reports whether ctx comes from WithoutCache.
+func cacheSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+// cachedFetch returns the value cached under key or, on a miss, calls fill
+// and caches its result for ttl; a ttl of 0 leaves the value uncached. An
+// ErrNotFound from fill is cached for TTLNotFound and returned as is on
+// later hits, so repeated requests for a deleted channel do not each reach
+// Postgres. Concurrent misses on one key share a single fill. A cache that
+// fails is read through: the value comes from fill and the caller never
+// sees the error, and while Redis is down its breaker (see cache.Redis)
+// makes that immediate.
+//
+// The shared fill runs detached from the caller that started it, bounded by
+// fillTimeout, so one caller giving up does not fail the others waiting on
+// it; each caller still returns as soon as its own ctx is done.
+func cachedFetch[T any](ctx context.Context, c *CachedStore, key string, ttl time.Duration, fill func(context.Context) (T, error)) (T, error) {
+	var zero T
+	stats := c.count(key)
+	if ttl <= 0 {
+		stats.misses.Add(1)
+		return fill(ctx)
+	}
+	key = c.opts.Prefix + key
+	if !cacheSkipped(ctx) {
+		v, err := cache.Get[T](ctx, c.cache, key)
+		switch {
+		case err == nil:
+			stats.hits.Add(1)
+			c.cacheOK(ctx)
+			return v, nil
+		case errors.Is(err, cache.ErrMissing):
+			stats.hits.Add(1)
+			c.cacheOK(ctx)
+			return zero, ErrNotFound
+		case errors.Is(err, redis.Nil):
+			c.cacheOK(ctx)
+		case errors.Is(err, cache.ErrCodec):
+			// Overwritten by the fill below.
+			slog.WarnContext(ctx, "cache: get", "key", key, "err", err)
+		default:
+			logCacheErr(ctx, "cache: get", err)
+		}
+	}
+
+	stats.misses.Add(1)
+	ch := c.fills.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fillTimeout)
+		defer cancel()
+		v, err := fill(ctx)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			c.setMissing(ctx, key)
+		case err == nil:
+			c.set(ctx, key, v, ttl)
+		default:
+			stats.fillErrors.Add(1)
+		}
+		return v, err
+	})
+	var res singleflight.Result
+	select {
+	case res = <-ch:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	if res.Err != nil {
+		return zero, res.Err
+	}
+	if res.Shared {
+		// Every caller gets its own copy, as a cache hit would, so none can
+		// modify what another is reading.
+		return cloneJSON(res.Val.(T))
+	}
+	return res.Val.(T), nil
+}
+
+// set caches v under key for ttl.
+func (c *CachedStore) set(ctx context.Context, key string, v any, ttl time.Duration) {
+	if err := cache.Set(ctx, c.cache, key, v, ttl); err != nil {
+		logCacheErr(ctx, "cache: set", err)
+	}
+}
+
+// setMissing caches under key that the entity does not exist.
+func (c *CachedStore) setMissing(ctx context.Context, key string) {
+	if c.opts.TTLNotFound <= 0 {
+		return
+	}
+	if err := cache.SetMissing(ctx, c.cache, key, c.opts.TTLNotFound); err != nil {
+		logCacheErr(ctx, "cache: set", err)
+	}
+}
+
+// cacheOK records that the cache answered. After a failed invalidation
+// everything cached is dropped, as it may hold what that write changed.
+func (c *CachedStore) cacheOK(ctx context.Context) {
+	if c.stale.CompareAndSwap(true, false) {
+		slog.InfoContext(ctx, "cache: available again, flushing")
+		c.invalidatePattern(ctx, allCachePatterns...)
+	}
+}
+
+// logCacheErr logs a cache failure, except ErrUnavailable: an open breaker
+// already logged the outage once.
+func logCacheErr(ctx context.Context, msg string, err error) {
+	if !errors.Is(err, cache.ErrUnavailable) {
+		slog.WarnContext(ctx, msg, "err", err)
+	}
+}
+
+// cloneJSON returns a deep copy of v made the way the cache would.
+func cloneJSON[T any](v T) (T, error) {
+	var out T
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out, err
+	}
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// countingStore counts the calls that reach the inner store and holds them
+// until release is closed, so that concurrent misses pile up.
+type countingStore struct {
+	*Memory
+	sources atomic.Int32
+	source  sync.Map // source id -> *atomic.Int32
+	release chan struct{}
+}
+
+func (s *countingStore) ListSources(ctx context.Context) ([]models.Source, error) {
+	s.sources.Add(1)
+	<-s.release
+	return s.Memory.ListSources(ctx)
+}
+
+func (s *countingStore) GetSourceByID(ctx context.Context, sourceID int64) (*models.Source, error) {
+	n, _ := s.source.LoadOrStore(sourceID, new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+	<-s.release
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Memory.GetSourceByID(ctx, sourceID)
+}
+
+func (s *countingStore) sourceCalls(id int64) int32 {
+	n, ok := s.source.Load(id)
+	if !ok {
+		return 0
+	}
+	return n.(*atomic.Int32).Load()
+}
+
+// TestCachedFetchSingleflight misses the cache from many goroutines at once
+// and checks that each key reaches the inner store once.
+func TestCachedFetchSingleflight(t *testing.T) {
+	ctx := context.Background()
+	inner := &countingStore{Memory: NewMemory(), release: make(chan struct{})}
+	a, _ := inner.CreateCustomSource(ctx, "A")
+	b, _ := inner.CreateCustomSource(ctx, "B")
+	c := NewCachedStore(inner, cache.NewMemory(1000, 1<<20), CacheOptions{TTLSources: time.Minute, TTLSource: time.Minute})
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 3*n)
+	lists := make([][]models.Source, n)
+	for i := 0; i < n; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			list, err := c.ListSources(ctx)
+			lists[i] = list
+			errs <- err
+		}()
+		for _, id := range []int64{a, b} {
+			go func() {
+				defer wg.Done()
+				src, err := c.GetSourceByID(ctx, id)
+				if err == nil && src.ID != id {
+					t.Errorf("GetSourceByID(%d) = source %d", id, src.ID)
+				}
+				errs <- err
+			}()
+		}
+	}
+	// Let the goroutines reach the held inner calls before releasing them;
+	// ones that arrive later are served from the cache either way.
+	time.Sleep(50 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := inner.sources.Load(); got != 1 {
+		t.Errorf("inner ListSources calls = %d, want 1", got)
+	}
+	for _, id := range []int64{a, b} {
+		if got := inner.sourceCalls(id); got != 1 {
+			t.Errorf("inner GetSourceByID(%d) calls = %d, want 1", id, got)
+		}
+	}
+
+	// Callers that shared a fill got copies of its result.
+	lists[0][0].Name = "changed"
+	for i := 1; i < n; i++ {
+		if len(lists[i]) != 2 || lists[i][0].Name == "changed" {
+			t.Fatalf("list %d = %+v, shares the first caller's slice", i, lists[i])
+		}
+	}
+}
+
+// TestCachedFetchFirstCallerCancels cancels the caller whose miss started a
+// fill while another waits on it, and checks that the waiter still gets the
+// value and that it is cached.
+func TestCachedFetchFirstCallerCancels(t *testing.T) {
+	inner := &countingStore{Memory: NewMemory(), release: make(chan struct{})}
+	id, _ := inner.CreateCustomSource(context.Background(), "A")
+	c := NewCachedStore(inner, cache.NewMemory(1000, 1<<20), CacheOptions{TTLSource: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetSourceByID(ctx, id)
+		first <- err
+	}()
+	for inner.sourceCalls(id) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan error, 1)
+	go func() {
+		src, err := c.GetSourceByID(context.Background(), id)
+		if err == nil && src.ID != id {
+			t.Errorf("GetSourceByID(%d) = source %d", id, src.ID)
+		}
+		waiter <- err
+	}()
+	// Let the waiter join the fill the first caller started.
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first caller err = %v, want context.Canceled", err)
+	}
+	close(inner.release)
+	if err := <-waiter; err != nil {
+		t.Fatalf("waiter err = %v", err)
+	}
+	if got := inner.sourceCalls(id); got != 1 {
+		t.Errorf("inner GetSourceByID calls = %d, want 1", got)
+	}
+	if _, err := c.GetSourceByID(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if got := inner.sourceCalls(id); got != 1 {
+		t.Errorf("inner GetSourceByID calls after the fill = %d, want 1 (cached)", got)
+	}
+}
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+)
+
+// cacheCounters count what happened to one kind of cache entry.
+type cacheCounters struct {
+	hits          atomic.Int64
+	misses        atomic.Int64 // reads that went to the inner store, including uncached kinds and bypasses
+	fillErrors    atomic.Int64 // misses whose inner read failed
+	invalidations atomic.Int64 // keys and patterns deleted
+}
+
+// discardCounters absorb the counts of keys outside cacheEntities.
+var discardCounters cacheCounters
+
+// count returns the counters of the entity key belongs to.
+func (c *CachedStore) count(key string) *cacheCounters {
+	entity, _, _ := strings.Cut(key, ":")
+	if s, ok := c.stats[entity]; ok {
+		return s
+	}
+	return &discardCounters
+}
+
+// CacheStats are the counters of one kind of cache entry since the process
+// started.
+type CacheStats struct {
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	FillErrors    int64   `json:"fill_errors"`
+	Invalidations int64   `json:"invalidations"`
+	HitRatio      float64 `json:"hit_ratio"`      // hits / (hits + misses), 0 before any read
+	Keys          *int64  `json:"keys,omitempty"` // entries cached now, when counted
+}
+
+// CacheStats returns the counters of every kind of entry, keyed by the kind
+// ("channels", "source", ...).
+func (c *CachedStore) CacheStats() map[string]CacheStats {
+	stats := make(map[string]CacheStats, len(c.stats))
+	for entity, s := range c.stats {
+		st := CacheStats{
+			Hits:          s.hits.Load(),
+			Misses:        s.misses.Load(),
+			FillErrors:    s.fillErrors.Load(),
+			Invalidations: s.invalidations.Load(),
+		}
+		if reads := st.Hits + st.Misses; reads > 0 {
+			st.HitRatio = float64(st.Hits) / float64(reads)
+		}
+		stats[entity] = st
+	}
+	return stats
+}
+
+// CountCacheKeys counts the entries cached now of every kind. It walks the
+// keyspace once per kind, so it is meant for occasional inspection.
+func (c *CachedStore) CountCacheKeys(ctx context.Context) (map[string]int64, error) {
+	keys := make(map[string]int64, len(cacheEntities))
+	for _, entity := range cacheEntities {
+		n, err := c.cache.CountPattern(ctx, c.opts.Prefix+entity+":*")
+		if err != nil {
+			return nil, err
+		}
+		keys[entity] = n
+	}
+	return keys, nil
+}
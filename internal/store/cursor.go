@@ -0,0 +1,84 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// ChannelCursor is a position in a channel list: the sort key and id of a
+// channel. As ChannelFilter.After it makes ListChannels continue with the
+// channels that follow it, which unlike an offset costs the same on every
+// page and is not shifted by channels added or removed before it. Only
+// the fields of its Sort are set.
+type ChannelCursor struct {
+	Sort     string     `json:"s"`
+	ID       int64      `json:"i"`
+	Name     string     `json:"n,omitempty"`
+	Number   *int       `json:"c,omitempty"` // SortNumber
+	Group    *string    `json:"g,omitempty"` // SortGroup
+	Created  *time.Time `json:"t,omitempty"` // SortCreated, SortCreatedDesc
+	Favorite bool       `json:"f,omitempty"` // SortFavorite
+}
+
+// ErrBadCursor is returned by DecodeChannelCursor for a string that is not
+// a cursor.
+var ErrBadCursor = errors.New("invalid cursor")
+
+// CursorAfter returns the cursor of ch in the given sort order.
+func CursorAfter(ch models.Channel, sort string) ChannelCursor {
+	if sort == "" {
+		sort = SortName
+	}
+	cur := ChannelCursor{Sort: sort, ID: ch.ID}
+	switch sort {
+	case SortCreated, SortCreatedDesc:
+		cur.Created = ch.CreatedAt
+		return cur
+	case SortNumber:
+		cur.Number = ch.Number
+	case SortGroup:
+		cur.Group = ch.GroupName
+	case SortFavorite:
+		cur.Favorite = ch.Favorite
+	}
+	cur.Name = ch.Name
+	return cur
+}
+
+// Encode returns the cursor as an opaque URL-safe string.
+func (c ChannelCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeChannelCursor parses a string made by ChannelCursor.Encode.
+func DecodeChannelCursor(s string) (*ChannelCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c ChannelCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrBadCursor
+	}
+	switch c.Sort {
+	case SortCreated, SortCreatedDesc:
+		if c.Created == nil {
+			return nil, ErrBadCursor
+		}
+	case SortName, SortNameDesc, SortNumber, SortGroup, SortFavorite:
+	default:
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrBadCursor, c.Sort)
+	}
+	return &c, nil
+}
+
+// channel returns a channel with the sort key of c, to compare others to.
+func (c *ChannelCursor) channel() models.Channel {
+	return models.Channel{ID: c.ID, Name: c.Name, Number: c.Number, GroupName: c.Group, CreatedAt: c.Created, Favorite: c.Favorite}
+}
@@ -0,0 +1,2240 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// Memory implements Store in memory, for tests and for running the server
+// without a database. It follows the semantics of Postgres (ON DELETE
+// cascades, edited fields kept across upserts, limit defaults) with maps
+// guarded by a mutex: filters and sorts scan every row and vector searches
+// compare the query with every embedding, so it suits small data sets only.
+// Nothing is persisted.
+type Memory struct {
+	mu sync.RWMutex
+
+	lastID    int64 // ids are shared by every kind of row
+	dims      int   // dimension of the stored embeddings; 0 until the first is stored
+	sources   map[int64]*models.Source
+	groups    map[int64]*models.Group
+	channels  map[int64]*memChannel
+	archived  map[int64]*memArchived
+	headers   map[int64]*models.ChannelHttpHeaders // by channel id
+	playlists map[int64]*models.Playlist
+	members   map[int64]map[int64]bool // channel ids by playlist id
+	watches   map[int64]*models.WatchEvent
+	runs      map[int64]*models.RefreshRun
+	epg       map[int64][]models.EPGProgram // by source id
+}
+
+// memChannel is a stored channel. Name and URL are the playlist's; the
+// edited ones are kept apart, like custom_name and custom_url in Postgres.
+// GroupName, GroupImage, SourceName, Group and Headers are not stored.
+type memChannel struct {
+	models.Channel
+	customName     *string
+	customURL      *string
+	props          map[string]string
+	embedding      []float32
+	embeddingModel string
+	textHash       string
+}
+
+// memArchived is a channel removed by a refresh with the archive policy,
+// with its headers.
+type memArchived struct {
+	ch         memChannel
+	headers    *models.ChannelHttpHeaders
+	archivedAt time.Time
+}
+
+var _ Store = (*Memory)(nil)
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		sources:   make(map[int64]*models.Source),
+		groups:    make(map[int64]*models.Group),
+		channels:  make(map[int64]*memChannel),
+		archived:  make(map[int64]*memArchived),
+		headers:   make(map[int64]*models.ChannelHttpHeaders),
+		playlists: make(map[int64]*models.Playlist),
+		members:   make(map[int64]map[int64]bool),
+		watches:   make(map[int64]*models.WatchEvent),
+		runs:      make(map[int64]*models.RefreshRun),
+		epg:       make(map[int64][]models.EPGProgram),
+	}
+}
+
+// nextID returns a new row id. Callers hold mu.
+func (m *Memory) nextID() int64 {
+	m.lastID++
+	return m.lastID
+}
+
+// now returns the current time as a pointer, for timestamp columns.
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
+
+// view returns the channel as read queries return it: with the edited name
+// and URL, and the group name joined. Callers hold mu.
+func (m *Memory) view(c *memChannel) models.Channel {
+	ch := c.Channel
+	if c.customName != nil {
+		ch.Name = *c.customName
+	}
+	if c.customURL != nil {
+		ch.URL = *c.customURL
+	}
+	if c.GroupID != nil {
+		if g := m.groups[*c.GroupID]; g != nil {
+			name := g.Name
+			ch.GroupName = &name
+			if g.Image != nil {
+				image := *g.Image
+				ch.GroupImage = &image
+			}
+		}
+	}
+	if src := m.sources[c.SourceID]; src != nil {
+		ch.SourceName = src.Name
+	}
+	return ch
+}
+
+// deleteChannel removes a channel with its headers, watch events and
+// playlist memberships. Callers hold mu.
+func (m *Memory) deleteChannel(id int64) {
+	delete(m.channels, id)
+	delete(m.headers, id)
+	for wid, w := range m.watches {
+		if w.ChannelID == id {
+			delete(m.watches, wid)
+		}
+	}
+	for _, ids := range m.members {
+		delete(ids, id)
+	}
+}
+
+// findChannel returns the source's channel with the playlist name and URL,
+// or nil. Callers hold mu.
+func (m *Memory) findChannel(sourceID int64, name, url string) *memChannel {
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && c.Name == name && c.URL == url {
+			return c
+		}
+	}
+	return nil
+}
+
+// cloneSource returns a copy of s that shares no maps with it.
+func cloneSource(s *models.Source) models.Source {
+	out := *s
+	out.FetchHeaders = maps.Clone(s.FetchHeaders)
+	return out
+}
+
+// CreateOrGetSource creates a source by name if not exists, returns id. An
+// existing source is returned unchanged.
+func (m *Memory) CreateOrGetSource(ctx context.Context, name, url string, sourceType int16, userAgent string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sources {
+		if s.Name == name {
+			return s.ID, nil
+		}
+	}
+	useTvgID := true
+	s := &models.Source{
+		ID:             m.nextID(),
+		Name:           name,
+		URL:            url,
+		SourceType:     sourceType,
+		UseTvgID:       &useTvgID,
+		UserAgent:      userAgent,
+		Enabled:        true,
+		GuessMediaType: true,
+		DeadPolicy:     models.DeadPolicyKeep,
+		DeadThreshold:  3,
+		StalePolicy:    models.StalePolicyDelete,
+		CreatedAt:      now(),
+	}
+	m.sources[s.ID] = s
+	return s.ID, nil
+}
+
+// GetOrCreateGroup returns group id for name/sourceID. An existing group
+// takes image only when it is non-nil.
+func (m *Memory) GetOrCreateGroup(ctx context.Context, sourceID int64, name string, image *string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sources[sourceID] == nil {
+		return 0, fmt.Errorf("GetOrCreateGroup: source %d: %w", sourceID, ErrNotFound)
+	}
+	for _, g := range m.groups {
+		if g.SourceID == sourceID && g.Name == name {
+			if image != nil {
+				g.Image = image
+			}
+			return g.ID, nil
+		}
+	}
+	g := &models.Group{ID: m.nextID(), Name: name, Image: image, SourceID: sourceID}
+	m.groups[g.ID] = g
+	return g.ID, nil
+}
+
+// UpsertChannel inserts or updates a channel; returns channel id.
+func (m *Memory) UpsertChannel(ctx context.Context, ch *models.Channel) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id, err := m.upsertChannel(ch)
+	if err != nil {
+		return 0, fmt.Errorf("UpsertChannel: %w", err)
+	}
+	return id, nil
+}
+
+// upsertChannel stores ch under its (name, source, url) key. Image, media
+// type and group are left alone while edited, and the favorite flag is only
+// set on insert. Callers hold mu.
+func (m *Memory) upsertChannel(ch *models.Channel) (int64, error) {
+	if m.sources[ch.SourceID] == nil {
+		return 0, fmt.Errorf("source %d: %w", ch.SourceID, ErrNotFound)
+	}
+	if ch.GroupID != nil && m.groups[*ch.GroupID] == nil {
+		return 0, fmt.Errorf("group %d: %w", *ch.GroupID, ErrNotFound)
+	}
+
+	c := m.findChannel(ch.SourceID, ch.Name, ch.URL)
+	if c == nil {
+		c = &memChannel{Channel: *ch}
+		c.ID = m.nextID()
+		c.Group, c.GroupName, c.GroupImage, c.SourceName, c.Headers = nil, nil, nil, "", nil
+		c.Status, c.StatusCode, c.LastChecked, c.FailCount, c.Hidden, c.Edited = nil, nil, nil, 0, false, 0
+		c.CreatedAt = now()
+		c.UpdatedAt = c.CreatedAt
+		m.channels[c.ID] = c
+		return c.ID, nil
+	}
+
+	changed := !samePtr(c.TvgID, ch.TvgID) || !samePtr(c.Number, ch.Number) ||
+		!samePtr(c.Series, ch.Series) || !samePtr(c.Season, ch.Season) || !samePtr(c.Episode, ch.Episode) ||
+		!samePtr(c.Quality, ch.Quality) || !samePtr(c.DisplayName, ch.DisplayName)
+	if c.Edited&models.EditedImage == 0 {
+		changed = changed || !samePtr(c.Image, ch.Image)
+		c.Image = ch.Image
+	}
+	if c.Edited&models.EditedMediaType == 0 {
+		changed = changed || c.MediaType != ch.MediaType
+		c.MediaType = ch.MediaType
+	}
+	if c.Edited&models.EditedGroup == 0 {
+		changed = changed || !samePtr(c.GroupID, ch.GroupID)
+		c.GroupID = ch.GroupID
+	}
+	c.TvgID, c.Number = ch.TvgID, ch.Number
+	c.Series, c.Season, c.Episode = ch.Series, ch.Season, ch.Episode
+	c.Quality, c.DisplayName = ch.Quality, ch.DisplayName
+	if changed {
+		c.UpdatedAt = now()
+	}
+	return c.ID, nil
+}
+
+// samePtr reports whether a and b are both nil or point to equal values.
+func samePtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// RekeyChannelsByTvgID moves channels whose tvg-id matches a key to the
+// key's name and URL, under the same conditions as Postgres: the tvg-id is
+// unique within the source and no channel has the target name and URL yet.
+func (m *Memory) RekeyChannelsByTvgID(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// One key per name and URL, the lowest tvg-id winning.
+	targets := make(map[channelTarget]ChannelKey, len(keys))
+	for _, k := range keys {
+		t := channelTarget{k.Name, k.URL}
+		if prev, ok := targets[t]; !ok || k.TvgID < prev.TvgID {
+			targets[t] = k
+		}
+	}
+	byTvgID := make(map[string][]*memChannel)
+	taken := make(map[channelTarget]bool)
+	for _, c := range m.channels {
+		if c.SourceID != sourceID {
+			continue
+		}
+		taken[channelTarget{c.Name, c.URL}] = true
+		if c.TvgID != nil {
+			byTvgID[*c.TvgID] = append(byTvgID[*c.TvgID], c)
+		}
+	}
+
+	// Conditions are checked against the channels before any move, like
+	// the single UPDATE of Postgres.
+	type move struct {
+		c *memChannel
+		k ChannelKey
+	}
+	var moves []move
+	for t, k := range targets {
+		cs := byTvgID[k.TvgID]
+		if len(cs) != 1 || taken[t] || (cs[0].Name == k.Name && cs[0].URL == k.URL) {
+			continue
+		}
+		moves = append(moves, move{cs[0], k})
+	}
+	for _, mv := range moves {
+		mv.c.Name, mv.c.URL = mv.k.Name, mv.k.URL
+		mv.c.UpdatedAt = now()
+	}
+	return int64(len(moves)), nil
+}
+
+// channelTarget is the name and URL a channel is keyed by within its source.
+type channelTarget struct {
+	name, url string
+}
+
+// UpsertChannelHeaders inserts or updates the headers of a channel. Headers
+// from a playlist do not replace ones marked edited.
+func (m *Memory) UpsertChannelHeaders(ctx context.Context, channelID int64, h *models.ChannelHttpHeaders) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.channels[channelID] == nil {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	m.upsertHeaders(channelID, h)
+	return nil
+}
+
+// BulkUpsertChannelHeaders upserts the headers of many channels, and deletes
+// those of nil entries unless edited. Nothing is stored if a channel does
+// not exist.
+func (m *Memory) BulkUpsertChannelHeaders(ctx context.Context, headers map[int64]*models.ChannelHttpHeaders) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range headers {
+		if m.channels[id] == nil {
+			return fmt.Errorf("BulkUpsertChannelHeaders: channel %d: %w", id, ErrNotFound)
+		}
+	}
+	for id, h := range headers {
+		if h != nil {
+			m.upsertHeaders(id, h)
+		} else if prev := m.headers[id]; prev != nil && !prev.Edited {
+			delete(m.headers, id)
+		}
+	}
+	return nil
+}
+
+// upsertHeaders stores h for a channel that exists. Callers hold mu.
+func (m *Memory) upsertHeaders(channelID int64, h *models.ChannelHttpHeaders) {
+	prev := m.headers[channelID]
+	if prev != nil && prev.Edited && !h.Edited {
+		return
+	}
+	ignoreSSL := h.IgnoreSSL != nil && *h.IgnoreSSL
+	stored := &models.ChannelHttpHeaders{
+		ChannelID:  channelID,
+		Referrer:   h.Referrer,
+		UserAgent:  h.UserAgent,
+		HTTPOrigin: h.HTTPOrigin,
+		IgnoreSSL:  &ignoreSSL,
+		Edited:     h.Edited,
+	}
+	if prev != nil {
+		stored.ID = prev.ID
+	} else {
+		stored.ID = m.nextID()
+	}
+	m.headers[channelID] = stored
+}
+
+// GetChannelHeaders returns the HTTP headers of a channel, or nil if it has
+// none.
+func (m *Memory) GetChannelHeaders(ctx context.Context, channelID int64) (*models.ChannelHttpHeaders, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h := m.headers[channelID]
+	if h == nil {
+		return nil, nil
+	}
+	out := *h
+	return &out, nil
+}
+
+// UpsertChannelProps inserts or replaces the player properties of a channel.
+func (m *Memory) UpsertChannelProps(ctx context.Context, channelID int64, props map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.channels[channelID]
+	if c == nil {
+		return fmt.Errorf("UpsertChannelProps: channel %d: %w", channelID, ErrNotFound)
+	}
+	c.props = maps.Clone(props)
+	return nil
+}
+
+// BulkUpsertChannelProps inserts or replaces the player properties of many
+// channels. Nothing is stored if a channel does not exist.
+func (m *Memory) BulkUpsertChannelProps(ctx context.Context, props map[int64]map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range props {
+		if m.channels[id] == nil {
+			return fmt.Errorf("BulkUpsertChannelProps: channel %d: %w", id, ErrNotFound)
+		}
+	}
+	for id, p := range props {
+		m.channels[id].props = maps.Clone(p)
+	}
+	return nil
+}
+
+// ChannelStates returns the playlist name, URL, tvg-id, group and edited
+// fields of the source's channels by id.
+func (m *Memory) ChannelStates(ctx context.Context, sourceID int64) (map[int64]ChannelState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := make(map[int64]ChannelState)
+	for _, c := range m.channels {
+		if c.SourceID != sourceID {
+			continue
+		}
+		st := ChannelState{Name: c.Name, URL: c.URL, TvgID: c.TvgID, GroupID: c.GroupID, EditedFields: c.Edited}
+		if c.GroupID != nil {
+			if g := m.groups[*c.GroupID]; g != nil {
+				name := g.Name
+				st.Group = &name
+			}
+		}
+		states[c.ID] = st
+	}
+	return states, nil
+}
+
+// RemoveStaleChannels deletes, or with archive archives, the source's
+// channels whose ids are not in keepIDs and returns them by id.
+func (m *Memory) RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64, archive bool) ([]models.ChannelRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keep := make(map[int64]bool, len(keepIDs))
+	for _, id := range keepIDs {
+		keep[id] = true
+	}
+	var removed []models.ChannelRef
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && !keep[c.ID] {
+			removed = append(removed, models.ChannelRef{ID: c.ID, Name: c.Name})
+		}
+	}
+	slices.SortFunc(removed, func(a, b models.ChannelRef) int { return cmp.Compare(a.ID, b.ID) })
+	for _, ref := range removed {
+		if archive {
+			m.archived[ref.ID] = &memArchived{ch: *m.channels[ref.ID], headers: m.headers[ref.ID], archivedAt: time.Now()}
+		}
+		m.deleteChannel(ref.ID)
+	}
+	return removed, nil
+}
+
+// RestoreArchivedChannels moves the source's archived channels that keys
+// list back among its channels: by name and URL, or by tvg-id while no
+// channel of the source has it.
+func (m *Memory) RestoreArchivedChannels(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	targets := make(map[channelTarget]bool, len(keys))
+	tvgIDs := make(map[string]bool)
+	for _, k := range keys {
+		targets[channelTarget{k.Name, k.URL}] = true
+		if k.TvgID != "" {
+			tvgIDs[k.TvgID] = true
+		}
+	}
+	live := make(map[string]bool)
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && c.TvgID != nil {
+			live[*c.TvgID] = true
+		}
+	}
+	var n int64
+	for _, a := range m.archived {
+		c := &a.ch
+		if c.SourceID != sourceID {
+			continue
+		}
+		byTvgID := c.TvgID != nil && *c.TvgID != "" && tvgIDs[*c.TvgID] && !live[*c.TvgID]
+		if (targets[channelTarget{c.Name, c.URL}] || byTvgID) && m.restoreArchived(a) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// restoreArchived moves a back among the channels unless its source has a
+// channel with its name and URL again, dropping its group if that was
+// deleted meanwhile. Callers hold mu.
+func (m *Memory) restoreArchived(a *memArchived) bool {
+	c := a.ch
+	if m.findChannel(c.SourceID, c.Name, c.URL) != nil {
+		return false
+	}
+	if c.GroupID != nil && m.groups[*c.GroupID] == nil {
+		c.GroupID = nil
+	}
+	m.channels[c.ID] = &c
+	if a.headers != nil {
+		m.headers[c.ID] = a.headers
+	}
+	delete(m.archived, c.ID)
+	return true
+}
+
+// ListArchivedChannels returns a page of the source's archived channels,
+// most recently archived first, and their total.
+func (m *Memory) ListArchivedChannels(ctx context.Context, sourceID int64, limit, offset int) ([]models.ArchivedChannel, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var all []*memArchived
+	for _, a := range m.archived {
+		if a.ch.SourceID == sourceID {
+			all = append(all, a)
+		}
+	}
+	slices.SortFunc(all, func(a, b *memArchived) int {
+		return cmp.Or(b.archivedAt.Compare(a.archivedAt), cmp.Compare(b.ch.ID, a.ch.ID))
+	})
+	total := len(all)
+	all = all[min(offset, total):min(offset+limit, total)]
+	out := make([]models.ArchivedChannel, len(all))
+	for i, a := range all {
+		c := &a.ch
+		out[i] = models.ArchivedChannel{ID: c.ID, SourceID: c.SourceID, Name: c.Name, URL: c.URL, TvgID: c.TvgID,
+			Favorite: c.Favorite, Hidden: c.Hidden, ArchivedAt: a.archivedAt}
+		if c.customName != nil {
+			out[i].Name = *c.customName
+		}
+		if c.customURL != nil {
+			out[i].URL = *c.customURL
+		}
+	}
+	return out, total, nil
+}
+
+// RestoreArchivedChannel moves one archived channel back among its
+// source's channels.
+func (m *Memory) RestoreArchivedChannel(ctx context.Context, channelID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.archived[channelID]
+	if a == nil {
+		return fmt.Errorf("archived channel %d: %w", channelID, ErrNotFound)
+	}
+	if !m.restoreArchived(a) {
+		return fmt.Errorf("archived channel %d: name and url taken: %w", channelID, ErrConflict)
+	}
+	return nil
+}
+
+// PruneArchivedChannels deletes the channels archived before cutoff.
+func (m *Memory) PruneArchivedChannels(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, a := range m.archived {
+		if a.archivedAt.Before(cutoff) {
+			delete(m.archived, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RemoveOrphanedGroups deletes groups for the source that have no remaining
+// channels and returns them in id order.
+func (m *Memory) RemoveOrphanedGroups(ctx context.Context, sourceID int64) ([]models.GroupRef, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used := make(map[int64]bool)
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && c.GroupID != nil {
+			used[*c.GroupID] = true
+		}
+	}
+	var removed []models.GroupRef
+	for id, g := range m.groups {
+		if g.SourceID == sourceID && !used[id] {
+			delete(m.groups, id)
+			removed = append(removed, models.GroupRef{ID: id, Name: g.Name})
+		}
+	}
+	slices.SortFunc(removed, func(a, b models.GroupRef) int { return cmp.Compare(a.ID, b.ID) })
+	return removed, nil
+}
+
+// updateSource applies fn to a source, or does nothing if it does not
+// exist, like an UPDATE matching no row.
+func (m *Memory) updateSource(sourceID int64, fn func(*models.Source)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s := m.sources[sourceID]; s != nil {
+		fn(s)
+	}
+}
+
+// UpdateSourceLastUpdated sets last_updated for the source.
+func (m *Memory) UpdateSourceLastUpdated(ctx context.Context, sourceID int64) error {
+	m.updateSource(sourceID, func(s *models.Source) { s.LastUpdated = now() })
+	return nil
+}
+
+// UpdateSourceValidators records the version of the playlist just ingested.
+func (m *Memory) UpdateSourceValidators(ctx context.Context, sourceID int64, etag, lastModified, contentHash string, parserVersion int) error {
+	m.updateSource(sourceID, func(s *models.Source) {
+		s.ETag, s.LastModified, s.ContentHash, s.ParserVersion = etag, lastModified, contentHash, parserVersion
+	})
+	return nil
+}
+
+// UpdateSourceEmbeddingRun records the totals of the source's last
+// completed embedding run.
+func (m *Memory) UpdateSourceEmbeddingRun(ctx context.Context, sourceID int64, run models.EmbeddingRun) error {
+	m.updateSource(sourceID, func(s *models.Source) { s.LastEmbeddingRun = &run })
+	return nil
+}
+
+// SetPlaylistEPGURL stores the playlist's EPG URL on the source unless the
+// user has chosen one explicitly.
+func (m *Memory) SetPlaylistEPGURL(ctx context.Context, sourceID int64, epgURL string) error {
+	m.updateSource(sourceID, func(s *models.Source) {
+		if !s.EPGURLManual {
+			s.EPGURL = epgURL
+		}
+	})
+	return nil
+}
+
+// RecordRefreshRun stores a refresh run and deletes the source's runs older
+// than the latest refreshRunsKept.
+func (m *Memory) RecordRefreshRun(ctx context.Context, run *models.RefreshRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sources[run.SourceID] == nil {
+		return fmt.Errorf("RecordRefreshRun: source %d: %w", run.SourceID, ErrNotFound)
+	}
+	run.ID = m.nextID()
+	stored := *run
+	stored.Added = append([]int64{}, run.Added...)
+	stored.Removed = append([]models.ChannelRef{}, run.Removed...)
+	stored.Updated = append([]int64{}, run.Updated...)
+	stored.RemovedGroups = append([]models.GroupRef{}, run.RemovedGroups...)
+	m.runs[stored.ID] = &stored
+
+	runs := m.sourceRuns(run.SourceID)
+	for _, r := range runs[min(len(runs), refreshRunsKept):] {
+		delete(m.runs, r.ID)
+	}
+	return nil
+}
+
+// sourceRuns returns the refresh runs of a source, most recent first.
+// Callers hold mu.
+func (m *Memory) sourceRuns(sourceID int64) []models.RefreshRun {
+	var runs []models.RefreshRun
+	for _, r := range m.runs {
+		if r.SourceID == sourceID {
+			runs = append(runs, *r)
+		}
+	}
+	slices.SortFunc(runs, func(a, b models.RefreshRun) int {
+		return cmp.Or(b.StartedAt.Compare(a.StartedAt), cmp.Compare(b.ID, a.ID))
+	})
+	return runs
+}
+
+// ListRefreshRuns returns the latest refresh runs of a source, most recent
+// first.
+func (m *Memory) ListRefreshRuns(ctx context.Context, sourceID int64, limit int) ([]models.RefreshRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	runs := m.sourceRuns(sourceID)
+	if limit >= 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+// CreateCustomSource inserts a custom source; ErrConflict if the name is
+// taken.
+func (m *Memory) CreateCustomSource(ctx context.Context, name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.sources {
+		if s.Name == name {
+			return 0, fmt.Errorf("source %q: %w", name, ErrConflict)
+		}
+	}
+	useTvgID := true
+	s := &models.Source{
+		ID:             m.nextID(),
+		Name:           name,
+		SourceType:     models.SourceTypeCustom,
+		UseTvgID:       &useTvgID,
+		Enabled:        true,
+		GuessMediaType: true,
+		DeadPolicy:     models.DeadPolicyKeep,
+		DeadThreshold:  3,
+		StalePolicy:    models.StalePolicyDelete,
+		CreatedAt:      now(),
+	}
+	m.sources[s.ID] = s
+	return s.ID, nil
+}
+
+// AddChannel inserts a single channel and its headers.
+func (m *Memory) AddChannel(ctx context.Context, ch *models.Channel, h *models.ChannelHttpHeaders) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findChannel(ch.SourceID, ch.Name, ch.URL) != nil {
+		return 0, fmt.Errorf("channel %q: %w", ch.Name, ErrConflict)
+	}
+	add := models.Channel{
+		Name:      ch.Name,
+		Image:     ch.Image,
+		URL:       ch.URL,
+		MediaType: ch.MediaType,
+		SourceID:  ch.SourceID,
+		GroupID:   ch.GroupID,
+		Favorite:  ch.Favorite,
+	}
+	id, err := m.upsertChannel(&add)
+	if err != nil {
+		return 0, fmt.Errorf("AddChannel: %w", err)
+	}
+	if h != nil {
+		m.upsertHeaders(id, h)
+	}
+	return id, nil
+}
+
+// DeleteChannel deletes a channel with its headers and properties.
+func (m *Memory) DeleteChannel(ctx context.Context, channelID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.channels[channelID] == nil {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	m.deleteChannel(channelID)
+	return nil
+}
+
+// ListSources returns all sources ordered by id, with their channel and
+// group counts.
+func (m *Memory) ListSources(ctx context.Context) ([]models.Source, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := make(map[int64]int)
+	for _, c := range m.channels {
+		channels[c.SourceID]++
+	}
+	groups := make(map[int64]int)
+	for _, g := range m.groups {
+		groups[g.SourceID]++
+	}
+
+	var sources []models.Source
+	for _, s := range m.sources {
+		out := cloneSource(s)
+		nc, ng := channels[s.ID], groups[s.ID]
+		out.ChannelCount, out.GroupCount = &nc, &ng
+		sources = append(sources, out)
+	}
+	slices.SortFunc(sources, func(a, b models.Source) int { return cmp.Compare(a.ID, b.ID) })
+	return sources, nil
+}
+
+// GetSourceByID returns a single source by id.
+func (m *Memory) GetSourceByID(ctx context.Context, sourceID int64) (*models.Source, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := m.sources[sourceID]
+	if s == nil {
+		return nil, fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+	}
+	out := cloneSource(s)
+	return &out, nil
+}
+
+// UpdateSource applies the non-nil fields of a source. Changing the URL or
+// a setting that changes how the playlist is stored clears the validators.
+// A name another source has gives ErrConflict.
+func (m *Memory) UpdateSource(ctx context.Context, sourceID int64, fields SourceUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.sources[sourceID]
+	if s == nil {
+		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+	}
+	if fields.Name != nil {
+		for _, other := range m.sources {
+			if other.ID != sourceID && other.Name == *fields.Name {
+				return fmt.Errorf("source %d: name %q: %w", sourceID, *fields.Name, ErrConflict)
+			}
+		}
+		s.Name = *fields.Name
+	}
+	if fields.UserAgent != nil {
+		s.UserAgent = *fields.UserAgent
+	}
+	if fields.EPGURL != nil {
+		s.EPGURL, s.EPGURLManual = *fields.EPGURL, *fields.EPGURL != ""
+	}
+	if fields.Enabled != nil {
+		s.Enabled = *fields.Enabled
+	}
+
+	same := true
+	if fields.URL != nil {
+		same = same && s.URL == *fields.URL
+		s.URL = *fields.URL
+	}
+	if fields.GuessMediaType != nil {
+		same = same && s.GuessMediaType == *fields.GuessMediaType
+		s.GuessMediaType = *fields.GuessMediaType
+	}
+	if fields.Dedupe != nil {
+		same = same && s.Dedupe == *fields.Dedupe
+		s.Dedupe = *fields.Dedupe
+	}
+	if fields.UseTvgID != nil {
+		same = same && s.UseTvgID != nil && *s.UseTvgID == *fields.UseTvgID
+		useTvgID := *fields.UseTvgID
+		s.UseTvgID = &useTvgID
+	}
+	if !same {
+		s.ETag, s.LastModified, s.ContentHash = "", "", ""
+	}
+
+	if fields.DeadPolicy != nil {
+		s.DeadPolicy = *fields.DeadPolicy
+	}
+	if fields.DeadThreshold != nil {
+		s.DeadThreshold = *fields.DeadThreshold
+	}
+	if fields.StalePolicy != nil {
+		s.StalePolicy = *fields.StalePolicy
+	}
+	if fields.WebhookURL != nil {
+		s.WebhookURL = *fields.WebhookURL
+	}
+	if fields.FetchHeaders != nil {
+		s.FetchHeaders = nil
+		if len(fields.FetchHeaders) > 0 {
+			s.FetchHeaders = maps.Clone(fields.FetchHeaders)
+		}
+	}
+	return nil
+}
+
+// DeleteSource deletes a source with its channels, groups, refresh runs and
+// EPG programmes.
+func (m *Memory) DeleteSource(ctx context.Context, sourceID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sources[sourceID] == nil {
+		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+	}
+	for id, c := range m.channels {
+		if c.SourceID == sourceID {
+			m.deleteChannel(id)
+		}
+	}
+	for id, a := range m.archived {
+		if a.ch.SourceID == sourceID {
+			delete(m.archived, id)
+		}
+	}
+	for id, g := range m.groups {
+		if g.SourceID == sourceID {
+			delete(m.groups, id)
+		}
+	}
+	for id, r := range m.runs {
+		if r.SourceID == sourceID {
+			delete(m.runs, id)
+		}
+	}
+	delete(m.epg, sourceID)
+	delete(m.sources, sourceID)
+	return nil
+}
+
+// GetChannelByID returns a single channel by id with group name and HTTP
+// headers joined.
+func (m *Memory) GetChannelByID(ctx context.Context, channelID int64) (*models.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := m.channels[channelID]
+	if c == nil {
+		return nil, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	ch := m.view(c)
+	if h := m.headers[channelID]; h != nil {
+		hc := *h
+		ch.Headers = &hc
+	}
+	return &ch, nil
+}
+
+// clampPage applies the default and maximum page size of channel listings.
+func clampPage(filter *ChannelFilter) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+	if filter.Limit > 200 {
+		filter.Limit = 200
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+}
+
+// page returns the items of a page, or nil if it is empty.
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	return items[offset:min(len(items), offset+limit)]
+}
+
+// filterChannels returns the channels matching filter, in no particular
+// order. Limit, Offset and Sort are not handled here. Callers hold mu.
+func (m *Memory) filterChannels(filter ChannelFilter) []*memChannel {
+	var watched map[int64]bool
+	if filter.Watched != nil {
+		watched = make(map[int64]bool)
+		for _, w := range m.watches {
+			watched[w.ChannelID] = true
+		}
+	}
+	search := strings.ToLower(filter.Search)
+
+	var out []*memChannel
+	for _, c := range m.channels {
+		if filter.SourceID != nil && c.SourceID != *filter.SourceID {
+			continue
+		}
+		if filter.ExcludeSourceID != nil && c.SourceID == *filter.ExcludeSourceID {
+			continue
+		}
+		if filter.GroupID != nil && (c.GroupID == nil || *c.GroupID != *filter.GroupID) {
+			continue
+		}
+		if len(filter.GroupIDs) > 0 && (c.GroupID == nil || !slices.Contains(filter.GroupIDs, *c.GroupID)) {
+			continue
+		}
+		if len(filter.ExcludeGroupIDs) > 0 && c.GroupID != nil && slices.Contains(filter.ExcludeGroupIDs, *c.GroupID) {
+			continue
+		}
+		if filter.Watched != nil && watched[c.ID] != *filter.Watched {
+			continue
+		}
+		if filter.PlaylistID != nil && !m.members[*filter.PlaylistID][c.ID] {
+			continue
+		}
+		if filter.MediaType != nil && c.MediaType != *filter.MediaType {
+			continue
+		}
+		if filter.Favorite != nil && c.Favorite != *filter.Favorite {
+			continue
+		}
+		if filter.TvgID != "" && (c.TvgID == nil || *c.TvgID != filter.TvgID) {
+			continue
+		}
+		if filter.Quality != "" && (c.Quality == nil || *c.Quality != filter.Quality) {
+			continue
+		}
+		if filter.AddedSince != nil && (c.CreatedAt == nil || c.CreatedAt.Before(*filter.AddedSince)) {
+			continue
+		}
+		if !filter.IncludeHidden {
+			if c.Hidden {
+				continue
+			}
+			if c.GroupID != nil && m.groups[*c.GroupID] != nil && m.groups[*c.GroupID].Hidden {
+				continue
+			}
+		}
+		switch filter.Status {
+		case "":
+		case StatusUnchecked:
+			if c.Status != nil {
+				continue
+			}
+		default:
+			if c.Status == nil || *c.Status != filter.Status {
+				continue
+			}
+		}
+		if search != "" && !strings.Contains(strings.ToLower(c.Name), search) &&
+			(c.customName == nil || !strings.Contains(strings.ToLower(*c.customName), search)) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// sortChannels orders channel views by filter.Sort like channelOrder, with
+// the id breaking ties.
+func sortChannels(channels []models.Channel, sort string) {
+	slices.SortFunc(channels, compareChannels(sort))
+}
+
+// compareChannels returns the comparison of channel views behind
+// sortChannels.
+func compareChannels(sort string) func(a, b models.Channel) int {
+	byName := func(a, b models.Channel) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	}
+	var fn func(a, b models.Channel) int
+	switch sort {
+	case SortNameDesc:
+		fn = func(a, b models.Channel) int { return byName(b, a) }
+	case SortNumber:
+		fn = func(a, b models.Channel) int { return cmp.Or(compareNullsLast(a.Number, b.Number), byName(a, b)) }
+	case SortGroup:
+		fn = func(a, b models.Channel) int { return cmp.Or(compareNullsLast(a.GroupName, b.GroupName), byName(a, b)) }
+	case SortCreated:
+		fn = func(a, b models.Channel) int {
+			return cmp.Or(compareTimes(a.CreatedAt, b.CreatedAt), cmp.Compare(a.ID, b.ID))
+		}
+	case SortCreatedDesc:
+		fn = func(a, b models.Channel) int {
+			return cmp.Or(compareTimes(b.CreatedAt, a.CreatedAt), cmp.Compare(b.ID, a.ID))
+		}
+	case SortFavorite:
+		fn = func(a, b models.Channel) int {
+			if a.Favorite != b.Favorite {
+				if a.Favorite {
+					return -1
+				}
+				return 1
+			}
+			return byName(a, b)
+		}
+	default:
+		fn = byName
+	}
+	return fn
+}
+
+// compareNullsLast compares optional values, nil after every value.
+func compareNullsLast[T cmp.Ordered](a, b *T) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return cmp.Compare(*a, *b)
+}
+
+// compareTimes compares optional timestamps, nil last.
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	return a.Compare(*b)
+}
+
+// listChannels returns the views of the channels matching filter in
+// filter.Sort order. Callers hold mu.
+func (m *Memory) listChannels(filter ChannelFilter) []models.Channel {
+	matched := m.filterChannels(filter)
+	channels := make([]models.Channel, 0, len(matched))
+	for _, c := range matched {
+		channels = append(channels, m.view(c))
+	}
+	sortChannels(channels, filter.Sort)
+	return channels
+}
+
+// ListChannels returns channels matching the filter and total count (before
+// limit/offset or cursor), or -1 for the count with NoTotal. Search results
+// are not ranked: there is no pg_trgm.
+func (m *Memory) ListChannels(ctx context.Context, filter ChannelFilter) ([]models.Channel, int, error) {
+	clampPage(&filter)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channels := m.listChannels(filter)
+	total := len(channels)
+	if filter.NoTotal {
+		total = -1
+	}
+	if filter.After != nil {
+		compare, after := compareChannels(filter.Sort), filter.After.channel()
+		i := slices.IndexFunc(channels, func(ch models.Channel) bool { return compare(after, ch) < 0 })
+		if i < 0 {
+			i = len(channels)
+		}
+		return page(channels[i:], filter.Limit, 0), total, nil
+	}
+	return page(channels, filter.Limit, filter.Offset), total, nil
+}
+
+// CountChannels returns the number of channels matching the filter.
+func (m *Memory) CountChannels(ctx context.Context, filter ChannelFilter) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.filterChannels(filter)), nil
+}
+
+// Stats returns the totals over every source.
+func (m *Memory) Stats(ctx context.Context) (models.Stats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := models.NewStats()
+	stats.Sources, stats.Groups, stats.Channels = len(m.sources), len(m.groups), len(m.channels)
+	for _, c := range m.channels {
+		if name := models.MediaTypeName(c.MediaType); name != "" {
+			stats.ByMediaType[name]++
+		}
+		if c.Favorite {
+			stats.Favorites++
+		}
+		if c.embedding != nil {
+			stats.Embedded++
+		}
+	}
+	return stats, nil
+}
+
+// StreamChannels calls fn for every channel matching filter (Limit and
+// Offset are ignored), in filter.Sort order, with group name and HTTP
+// headers joined. The channels are copied first, so fn may use the store.
+func (m *Memory) StreamChannels(ctx context.Context, filter ChannelFilter, fn func(*models.Channel, *models.ChannelHttpHeaders) error) error {
+	m.mu.RLock()
+	channels := m.listChannels(filter)
+	headers := make(map[int64]models.ChannelHttpHeaders)
+	for _, ch := range channels {
+		if h := m.headers[ch.ID]; h != nil {
+			headers[ch.ID] = *h
+		}
+	}
+	m.mu.RUnlock()
+
+	for i := range channels {
+		var hp *models.ChannelHttpHeaders
+		if h, ok := headers[channels[i].ID]; ok {
+			hp = &h
+		}
+		if err := fn(&channels[i], hp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupCounts returns the number of channels of each group. Callers hold mu.
+func (m *Memory) groupCounts() map[int64]int {
+	counts := make(map[int64]int)
+	for _, c := range m.channels {
+		if c.GroupID != nil {
+			counts[*c.GroupID]++
+		}
+	}
+	return counts
+}
+
+// ListGroups returns groups with their channel counts, optionally filtered
+// by source id, ordered by name.
+func (m *Memory) ListGroups(ctx context.Context, sourceID *int64) ([]models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := m.groupCounts()
+	var groups []models.Group
+	for _, g := range m.groups {
+		if sourceID != nil && g.SourceID != *sourceID {
+			continue
+		}
+		out := *g
+		out.ChannelCount = counts[g.ID]
+		groups = append(groups, out)
+	}
+	slices.SortFunc(groups, func(a, b models.Group) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+	return groups, nil
+}
+
+// GetGroup returns a single group by id with its channel count.
+func (m *Memory) GetGroup(ctx context.Context, groupID int64) (*models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	g := m.groups[groupID]
+	if g == nil {
+		return nil, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	out := *g
+	out.ChannelCount = m.groupCounts()[groupID]
+	return &out, nil
+}
+
+// UpdateGroup applies non-nil fields to a group. A name already used by
+// another group of the source gives ErrConflict.
+func (m *Memory) UpdateGroup(ctx context.Context, groupID int64, fields GroupUpdate) error {
+	if fields.Name == nil && fields.Image == nil && fields.Hidden == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	g := m.groups[groupID]
+	if g == nil {
+		return fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	if fields.Name != nil {
+		for _, other := range m.groups {
+			if other.ID != groupID && other.SourceID == g.SourceID && other.Name == *fields.Name {
+				return fmt.Errorf("group %d: name %q: %w", groupID, *fields.Name, ErrConflict)
+			}
+		}
+		g.Name = *fields.Name
+	}
+	if fields.Image != nil {
+		g.Image = nil
+		if *fields.Image != "" {
+			image := *fields.Image
+			g.Image = &image
+		}
+	}
+	if fields.Hidden != nil {
+		g.Hidden = *fields.Hidden
+	}
+	return nil
+}
+
+// DeleteGroup deletes a group and, with deleteChannels, its channels.
+// Otherwise the channels are kept without a group.
+func (m *Memory) DeleteGroup(ctx context.Context, groupID int64, deleteChannels bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.groups[groupID] == nil {
+		return 0, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	var deleted int64
+	for id, c := range m.channels {
+		if c.GroupID == nil || *c.GroupID != groupID {
+			continue
+		}
+		if deleteChannels {
+			m.deleteChannel(id)
+			deleted++
+		} else {
+			c.GroupID = nil
+		}
+	}
+	delete(m.groups, groupID)
+	return deleted, nil
+}
+
+// UpdateChannel applies fields to a channel. An edited name or URL is kept
+// next to the playlist's and only counts as edited while it differs from
+// it, as in Postgres.
+func (m *Memory) UpdateChannel(ctx context.Context, channelID int64, fields ChannelUpdate) error {
+	if fields == (ChannelUpdate{}) {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.channels[channelID]
+	if c == nil {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	if fields.GroupID != nil && *fields.GroupID != 0 && m.groups[*fields.GroupID] == nil {
+		return fmt.Errorf("UpdateChannel: group %d: %w", *fields.GroupID, ErrNotFound)
+	}
+
+	unset := fields.Reset
+	var set, differs models.EditedFields
+	if fields.Name != nil {
+		c.customName = nil
+		if *fields.Name != c.Name {
+			name := *fields.Name
+			c.customName = &name
+			differs |= models.EditedName
+		}
+		unset |= models.EditedName
+	} else if fields.Reset&models.EditedName != 0 {
+		c.customName = nil
+	}
+	if fields.URL != nil {
+		c.customURL = nil
+		if *fields.URL != c.URL {
+			url := *fields.URL
+			c.customURL = &url
+			differs |= models.EditedURL
+		}
+		unset |= models.EditedURL
+	} else if fields.Reset&models.EditedURL != 0 {
+		c.customURL = nil
+	}
+	if fields.Image != nil {
+		c.Image = nil
+		if *fields.Image != "" {
+			image := *fields.Image
+			c.Image = &image
+		}
+		set |= models.EditedImage
+	}
+	if fields.GroupID != nil {
+		c.GroupID = nil
+		if *fields.GroupID != 0 {
+			groupID := *fields.GroupID
+			c.GroupID = &groupID
+		}
+		set |= models.EditedGroup
+	}
+	if fields.MediaType != nil {
+		c.MediaType = *fields.MediaType
+		set |= models.EditedMediaType
+	}
+	if fields.Favorite != nil {
+		c.Favorite = *fields.Favorite
+	}
+	if fields.Hidden != nil {
+		setHidden(c, *fields.Hidden)
+	}
+	c.Edited = c.Edited&^unset | set&^fields.Reset | differs
+	return nil
+}
+
+// setHidden hides or restores a channel; restoring resets its failed check
+// count.
+func setHidden(c *memChannel, hidden bool) {
+	c.Hidden = hidden
+	if !hidden {
+		c.FailCount = 0
+	}
+}
+
+// BulkUpdateChannels applies fields to the selected channels. Channels that
+// already have the requested values are not counted.
+func (m *Memory) BulkUpdateChannels(ctx context.Context, ids []int64, filter ChannelFilter, fields ChannelFlags) (int64, error) {
+	if fields.Favorite == nil && fields.Hidden == nil {
+		return 0, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var selected []*memChannel
+	if ids != nil {
+		for _, id := range ids {
+			if c := m.channels[id]; c != nil && !slices.Contains(selected, c) {
+				selected = append(selected, c)
+			}
+		}
+	} else {
+		selected = m.filterChannels(filter)
+	}
+
+	var n int64
+	for _, c := range selected {
+		changed := (fields.Favorite != nil && c.Favorite != *fields.Favorite) ||
+			(fields.Hidden != nil && c.Hidden != *fields.Hidden)
+		if !changed {
+			continue
+		}
+		if fields.Favorite != nil {
+			c.Favorite = *fields.Favorite
+		}
+		if fields.Hidden != nil {
+			setHidden(c, *fields.Hidden)
+		}
+		n++
+	}
+	return n, nil
+}
+
+// MergeGroups moves the channels of groupIDs into targetID and deletes the
+// emptied groups.
+func (m *Memory) MergeGroups(ctx context.Context, targetID int64, groupIDs []int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := m.groups[targetID]
+	if target == nil {
+		return 0, fmt.Errorf("group %d: %w", targetID, ErrNotFound)
+	}
+	for _, id := range groupIDs {
+		g := m.groups[id]
+		if g == nil {
+			return 0, fmt.Errorf("group %d: %w", id, ErrNotFound)
+		}
+		if g.SourceID != target.SourceID {
+			return 0, fmt.Errorf("group %d is in source %d, group %d in source %d: %w",
+				id, g.SourceID, targetID, target.SourceID, ErrSourceMismatch)
+		}
+	}
+
+	var moved int64
+	for _, c := range m.channels {
+		if c.GroupID != nil && slices.Contains(groupIDs, *c.GroupID) {
+			id := targetID
+			c.GroupID = &id
+			moved++
+		}
+	}
+	for _, id := range groupIDs {
+		delete(m.groups, id)
+	}
+	return moved, nil
+}
+
+// playlist returns a copy of pl with its channel count. Callers hold mu.
+func (m *Memory) playlist(pl *models.Playlist) models.Playlist {
+	out := *pl
+	out.ChannelCount = len(m.members[pl.ID])
+	return out
+}
+
+// ListPlaylists returns all playlists by name with their channel counts.
+func (m *Memory) ListPlaylists(ctx context.Context) ([]models.Playlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var playlists []models.Playlist
+	for _, pl := range m.playlists {
+		playlists = append(playlists, m.playlist(pl))
+	}
+	slices.SortFunc(playlists, func(a, b models.Playlist) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+	return playlists, nil
+}
+
+// GetPlaylist returns a single playlist by id with its channel count.
+func (m *Memory) GetPlaylist(ctx context.Context, playlistID int64) (*models.Playlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pl := m.playlists[playlistID]
+	if pl == nil {
+		return nil, fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	out := m.playlist(pl)
+	return &out, nil
+}
+
+// CreatePlaylist inserts an empty playlist.
+func (m *Memory) CreatePlaylist(ctx context.Context, name, description string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, pl := range m.playlists {
+		if pl.Name == name {
+			return 0, fmt.Errorf("playlist %q: %w", name, ErrConflict)
+		}
+	}
+	t := now()
+	pl := &models.Playlist{ID: m.nextID(), Name: name, Description: description, CreatedAt: t, UpdatedAt: t}
+	m.playlists[pl.ID] = pl
+	m.members[pl.ID] = make(map[int64]bool)
+	return pl.ID, nil
+}
+
+// UpdatePlaylist applies non-nil fields to a playlist.
+func (m *Memory) UpdatePlaylist(ctx context.Context, playlistID int64, fields PlaylistUpdate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pl := m.playlists[playlistID]
+	if pl == nil {
+		return fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	if fields.Name != nil {
+		for _, other := range m.playlists {
+			if other.ID != playlistID && other.Name == *fields.Name {
+				return fmt.Errorf("playlist %d: name %q: %w", playlistID, *fields.Name, ErrConflict)
+			}
+		}
+		pl.Name = *fields.Name
+	}
+	if fields.Description != nil {
+		pl.Description = *fields.Description
+	}
+	pl.UpdatedAt = now()
+	return nil
+}
+
+// DeletePlaylist deletes a playlist with its memberships.
+func (m *Memory) DeletePlaylist(ctx context.Context, playlistID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.playlists[playlistID] == nil {
+		return fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	delete(m.playlists, playlistID)
+	delete(m.members, playlistID)
+	return nil
+}
+
+// AddPlaylistChannel adds a channel to a playlist.
+func (m *Memory) AddPlaylistChannel(ctx context.Context, playlistID, channelID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pl := m.playlists[playlistID]
+	if pl == nil {
+		return false, fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	if m.channels[channelID] == nil {
+		return false, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	if m.members[playlistID][channelID] {
+		return false, nil
+	}
+	m.members[playlistID][channelID] = true
+	pl.UpdatedAt = now()
+	return true, nil
+}
+
+// RemovePlaylistChannel removes a channel from a playlist.
+func (m *Memory) RemovePlaylistChannel(ctx context.Context, playlistID, channelID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.members[playlistID][channelID] {
+		return fmt.Errorf("channel %d in playlist %d: %w", channelID, playlistID, ErrNotFound)
+	}
+	delete(m.members[playlistID], channelID)
+	m.playlists[playlistID].UpdatedAt = now()
+	return nil
+}
+
+// latestWatch returns the most recently updated watch event of a channel,
+// or nil. Callers hold mu.
+func (m *Memory) latestWatch(channelID int64) *models.WatchEvent {
+	var latest *models.WatchEvent
+	for _, w := range m.watches {
+		if w.ChannelID == channelID && (latest == nil || w.UpdatedAt.After(*latest.UpdatedAt)) {
+			latest = w
+		}
+	}
+	return latest
+}
+
+// watchFinished reports whether position reached 95% of a known duration.
+func watchFinished(position int, duration *int) bool {
+	return duration != nil && *duration > 0 && float64(position) >= float64(*duration)*0.95
+}
+
+// RecordWatch updates the channel's latest watch event, or inserts one when
+// there is none since sessionStart. A duration left out keeps the one
+// already known.
+func (m *Memory) RecordWatch(ctx context.Context, channelID int64, position int, duration *int, sessionStart time.Time) (*models.WatchEvent, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w := m.latestWatch(channelID); w != nil && w.UpdatedAt.After(sessionStart) {
+		w.Position = position
+		if duration != nil {
+			d := *duration
+			w.Duration = &d
+		}
+		w.UpdatedAt = now()
+		w.Finished = watchFinished(w.Position, w.Duration)
+		out := *w
+		return &out, false, nil
+	}
+
+	if m.channels[channelID] == nil {
+		return nil, false, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	t := now()
+	w := &models.WatchEvent{
+		ID:        m.nextID(),
+		ChannelID: channelID,
+		StartedAt: t,
+		UpdatedAt: t,
+		Position:  position,
+		Finished:  watchFinished(position, duration),
+	}
+	if duration != nil {
+		d := *duration
+		w.Duration = &d
+	}
+	m.watches[w.ID] = w
+	out := *w
+	return &out, true, nil
+}
+
+// GetWatchPosition returns the channel's most recently updated watch event.
+func (m *Memory) GetWatchPosition(ctx context.Context, channelID int64) (*models.WatchEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	w := m.latestWatch(channelID)
+	if w == nil {
+		return nil, nil
+	}
+	out := *w
+	return &out, nil
+}
+
+// ListWatchHistory returns the latest watch event of each channel with the
+// channel joined, most recently updated first.
+func (m *Memory) ListWatchHistory(ctx context.Context, limit int, inProgress bool) ([]models.WatchHistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []models.WatchHistoryEntry
+	for id, c := range m.channels {
+		w := m.latestWatch(id)
+		if w == nil || (inProgress && (w.Finished || w.Position <= 0)) {
+			continue
+		}
+		entries = append(entries, models.WatchHistoryEntry{Channel: m.view(c), Watch: *w})
+	}
+	slices.SortFunc(entries, func(a, b models.WatchHistoryEntry) int {
+		return cmp.Or(b.Watch.UpdatedAt.Compare(*a.Watch.UpdatedAt), cmp.Compare(b.Watch.ID, a.Watch.ID))
+	})
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// PruneWatchEvents deletes the watch events not updated since cutoff.
+func (m *Memory) PruneWatchEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for id, w := range m.watches {
+		if w.UpdatedAt.Before(cutoff) {
+			delete(m.watches, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListSeries returns the series found among episode channels, optionally
+// filtered by source id, ordered by name.
+func (m *Memory) ListSeries(ctx context.Context, sourceID *int64) ([]models.Series, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	byName := make(map[string]*models.Series)
+	seasons := make(map[string]map[int]bool)
+	for _, c := range m.channels {
+		if c.Series == nil || (sourceID != nil && c.SourceID != *sourceID) {
+			continue
+		}
+		s := byName[*c.Series]
+		if s == nil {
+			s = &models.Series{Name: *c.Series}
+			byName[s.Name] = s
+			seasons[s.Name] = make(map[int]bool)
+		}
+		s.EpisodeCount++
+		if c.Season != nil {
+			seasons[s.Name][*c.Season] = true
+		}
+		if c.Image != nil && (s.Image == nil || *c.Image < *s.Image) {
+			s.Image = c.Image
+		}
+	}
+
+	var series []models.Series
+	for name, s := range byName {
+		s.SeasonCount = len(seasons[name])
+		series = append(series, *s)
+	}
+	slices.SortFunc(series, func(a, b models.Series) int { return strings.Compare(a.Name, b.Name) })
+	return series, nil
+}
+
+// ListSeriesEpisodes returns the episodes of the named series ordered by
+// season and episode, optionally filtered by source id.
+func (m *Memory) ListSeriesEpisodes(ctx context.Context, name string, sourceID *int64) ([]models.Channel, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var episodes []*memChannel
+	for _, c := range m.channels {
+		if c.Series != nil && *c.Series == name && (sourceID == nil || c.SourceID == *sourceID) {
+			episodes = append(episodes, c)
+		}
+	}
+	slices.SortFunc(episodes, func(a, b *memChannel) int {
+		return cmp.Or(compareNullsLast(a.Season, b.Season), compareNullsLast(a.Episode, b.Episode),
+			strings.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+
+	var out []models.Channel
+	for _, c := range episodes {
+		out = append(out, m.view(c))
+	}
+	return out, nil
+}
+
+// updateChannel applies fn to a channel; ErrNotFound if it does not exist.
+func (m *Memory) updateChannel(channelID int64, fn func(*memChannel)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := m.channels[channelID]
+	if c == nil {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	fn(c)
+	return nil
+}
+
+// ToggleChannelFavorite sets the favorite flag on a channel.
+func (m *Memory) ToggleChannelFavorite(ctx context.Context, channelID int64, favorite bool) error {
+	return m.updateChannel(channelID, func(c *memChannel) { c.Favorite = favorite })
+}
+
+// SetChannelHidden hides or restores a channel. Restoring also resets its
+// failed check count.
+func (m *Memory) SetChannelHidden(ctx context.Context, channelID int64, hidden bool) error {
+	return m.updateChannel(channelID, func(c *memChannel) { setHidden(c, hidden) })
+}
+
+// CountChannelsBySource returns the total number of channels for a source.
+func (m *Memory) CountChannelsBySource(ctx context.Context, sourceID int64) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	for _, c := range m.channels {
+		if c.SourceID == sourceID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// CountFavorites returns the number of favorite channels, optionally of one
+// source, leaving out hidden ones unless includeHidden is set.
+func (m *Memory) CountFavorites(ctx context.Context, sourceID *int64, includeHidden bool) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int
+	for _, c := range m.channels {
+		if !c.Favorite || (sourceID != nil && c.SourceID != *sourceID) {
+			continue
+		}
+		if !includeHidden {
+			if c.Hidden {
+				continue
+			}
+			if c.GroupID != nil && m.groups[*c.GroupID] != nil && m.groups[*c.GroupID].Hidden {
+				continue
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+// UpdateChannelStatuses records the outcome of stream checks. A failed check
+// increments the channel's fail count; a successful one resets it. Checks
+// of channels that no longer exist are ignored.
+func (m *Memory) UpdateChannelStatuses(ctx context.Context, checks []ChannelCheck) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, chk := range checks {
+		c := m.channels[chk.ChannelID]
+		if c == nil {
+			continue
+		}
+		status, checked := chk.Status, chk.CheckedAt
+		c.Status, c.LastChecked, c.StatusCode = &status, &checked, nil
+		if chk.StatusCode != 0 {
+			code := chk.StatusCode
+			c.StatusCode = &code
+		}
+		if chk.Status == models.ChannelStatusOK {
+			c.FailCount = 0
+		} else {
+			c.FailCount++
+		}
+	}
+	return nil
+}
+
+// PruneFailingChannels applies a dead channel policy to the source's
+// channels with at least threshold consecutive failed checks. With remove
+// set they are deleted, except favorites; all remaining ones are hidden.
+func (m *Memory) PruneFailingChannels(ctx context.Context, sourceID int64, threshold int, remove bool) (hidden, deleted int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, c := range m.channels {
+		if c.SourceID != sourceID || c.FailCount < threshold {
+			continue
+		}
+		switch {
+		case remove && !c.Favorite:
+			m.deleteChannel(id)
+			deleted++
+		case !c.Hidden:
+			c.Hidden = true
+			hidden++
+		}
+	}
+	return hidden, deleted, nil
+}
+
+// StoreEmbeddings sets the embeddings of the given channels, recording the
+// model and text hashes. Every vector must have the dimension of the first
+// one ever stored. Channels that do not exist are skipped.
+func (m *Memory) StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32, model string, textHashes []string) error {
+	if len(channelIDs) != len(embeddings) {
+		return fmt.Errorf("StoreEmbeddings: channelIDs length (%d) != embeddings length (%d)", len(channelIDs), len(embeddings))
+	}
+	if len(channelIDs) != len(textHashes) {
+		return fmt.Errorf("StoreEmbeddings: channelIDs length (%d) != textHashes length (%d)", len(channelIDs), len(textHashes))
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, vec := range embeddings {
+		if m.dims == 0 {
+			m.dims = len(vec)
+		}
+		if len(vec) != m.dims {
+			return fmt.Errorf("StoreEmbeddings id=%d: %w: %d dimensions, stored embeddings have %d",
+				channelIDs[i], ErrDimensionMismatch, len(vec), m.dims)
+		}
+	}
+	for i, id := range channelIDs {
+		if c := m.channels[id]; c != nil {
+			c.embedding, c.embeddingModel, c.textHash = slices.Clone(embeddings[i]), model, textHashes[i]
+		}
+	}
+	return nil
+}
+
+// EmbeddingTextHashes returns the text hashes stored with the given
+// channels' embeddings from model, by channel id.
+func (m *Memory) EmbeddingTextHashes(ctx context.Context, channelIDs []int64, model string) (map[int64]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[int64]string)
+	for _, id := range channelIDs {
+		if c := m.channels[id]; c != nil && c.embedding != nil && c.embeddingModel == model && c.textHash != "" {
+			out[id] = c.textHash
+		}
+	}
+	return out, nil
+}
+
+// EmbeddingStats counts a source's channels by the state of their embedding
+// relative to model.
+func (m *Memory) EmbeddingStats(ctx context.Context, sourceID int64, model string) (EmbeddingStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	st := EmbeddingStats{Model: model}
+	for _, c := range m.channels {
+		switch {
+		case c.SourceID != sourceID:
+		case c.embedding == nil:
+			st.Missing++
+		case c.embeddingModel == model:
+			st.Current++
+		default:
+			st.Stale++
+		}
+	}
+	return st, nil
+}
+
+// GetChannelEmbedding returns the stored embedding of a channel, or nil if
+// it has none yet.
+func (m *Memory) GetChannelEmbedding(ctx context.Context, channelID int64) ([]float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := m.channels[channelID]
+	if c == nil {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(c.embedding), nil
+}
+
+// GetChannelEmbeddings returns the stored embeddings of the given channels
+// by channel id. Channels without an embedding are left out.
+func (m *Memory) GetChannelEmbeddings(ctx context.Context, channelIDs []int64) (map[int64][]float32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[int64][]float32)
+	for _, id := range channelIDs {
+		if c := m.channels[id]; c != nil && c.embedding != nil {
+			out[id] = slices.Clone(c.embedding)
+		}
+	}
+	return out, nil
+}
+
+// RebuildEmbeddingIndex does nothing: searches compare every embedding, so
+// there is no index to build.
+func (m *Memory) RebuildEmbeddingIndex(ctx context.Context, onProgress func(done, total int64)) error {
+	return nil
+}
+
+// cosine returns the cosine similarity of two vectors of equal length, 0 if
+// either is all zeros.
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// checkQueryVec rejects query vectors not as long as the stored embeddings.
+// Callers hold mu.
+func (m *Memory) checkQueryVec(queryVec []float32) error {
+	if m.dims != 0 && len(queryVec) != m.dims {
+		return fmt.Errorf("%w: query vector has %d dimensions, stored embeddings have %d", ErrDimensionMismatch, len(queryVec), m.dims)
+	}
+	return nil
+}
+
+// semanticMatches returns the channels matching filter that have an
+// embedding, with their similarity to queryVec, most similar first.
+// MinSimilarity and EmbeddingModel apply. Callers hold mu.
+func (m *Memory) semanticMatches(queryVec []float32, filter ChannelFilter) []SemanticResult {
+	var results []SemanticResult
+	for _, c := range m.filterChannels(filter) {
+		if c.embedding == nil || (filter.EmbeddingModel != "" && c.embeddingModel != filter.EmbeddingModel) {
+			continue
+		}
+		sim := cosine(queryVec, c.embedding)
+		if filter.MinSimilarity != 0 && sim < filter.MinSimilarity {
+			continue
+		}
+		results = append(results, SemanticResult{Channel: m.view(c), Similarity: sim})
+	}
+	slices.SortFunc(results, func(a, b SemanticResult) int {
+		return cmp.Or(cmp.Compare(b.Similarity, a.Similarity), cmp.Compare(a.Channel.ID, b.Channel.ID))
+	})
+	return results
+}
+
+// SemanticSearch returns channels ordered by cosine similarity to queryVec,
+// found by comparing it with every stored embedding, and the number of
+// matches before Limit and Offset.
+func (m *Memory) SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, int, error) {
+	clampPage(&filter)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkQueryVec(queryVec); err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch: %w", err)
+	}
+	results := m.semanticMatches(queryVec, filter)
+	return page(results, filter.Limit, filter.Offset), len(results), nil
+}
+
+// words splits s into lower-case words of letters and digits, like the
+// simple text search configuration.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// lexicalScore scores a channel name against the query words: the share of
+// the name's words that are query words, when it has all of them, as a
+// rough stand-in for ts_rank. Zero means no word match.
+func lexicalScore(name string, query []string) float64 {
+	doc := words(name)
+	if len(query) == 0 || len(doc) == 0 {
+		return 0
+	}
+	matched := 0
+	for _, q := range query {
+		if !slices.Contains(doc, q) {
+			return 0
+		}
+	}
+	for _, w := range doc {
+		if slices.Contains(query, w) {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(doc))
+}
+
+// HybridSearch merges the SemanticSearch ranking with a match of query
+// against channel names by reciprocal rank fusion, like Postgres: each list
+// contributes up to four times Offset+Limit candidates and each result
+// scores 1/(rrfK+rank) per list it appears in. Names match when they have
+// every word of query or contain it.
+func (m *Memory) HybridSearch(ctx context.Context, queryVec []float32, query string, filter ChannelFilter) ([]SemanticResult, int, error) {
+	clampPage(&filter)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkQueryVec(queryVec); err != nil {
+		return nil, 0, fmt.Errorf("HybridSearch: %w", err)
+	}
+	candidates := (filter.Offset + filter.Limit) * 4
+
+	merged := make(map[int64]*SemanticResult)
+	var order []int64
+	result := func(ch models.Channel) *SemanticResult {
+		r := merged[ch.ID]
+		if r == nil {
+			r = &SemanticResult{Channel: ch}
+			merged[ch.ID] = r
+			order = append(order, ch.ID)
+		}
+		return r
+	}
+
+	for i, sr := range page(m.semanticMatches(queryVec, filter), candidates, 0) {
+		r := result(sr.Channel)
+		r.Similarity = sr.Similarity
+		r.Score += 1.0 / float64(rrfK+i+1)
+	}
+
+	type lexMatch struct {
+		c     *memChannel
+		score float64
+	}
+	var lex []lexMatch
+	queryWords := words(query)
+	needle := strings.ToLower(query)
+	for _, c := range m.filterChannels(filter) {
+		docName := c.Name
+		if c.DisplayName != nil {
+			docName = *c.DisplayName
+		}
+		shown := c.Name
+		if c.customName != nil {
+			shown = *c.customName
+		}
+		score := lexicalScore(docName, queryWords)
+		if score == 0 && !strings.Contains(strings.ToLower(shown), needle) {
+			continue
+		}
+		lex = append(lex, lexMatch{c, score})
+	}
+	slices.SortFunc(lex, func(a, b lexMatch) int {
+		return cmp.Or(cmp.Compare(b.score, a.score),
+			cmp.Compare(utf8.RuneCountInString(a.c.Name), utf8.RuneCountInString(b.c.Name)),
+			cmp.Compare(a.c.ID, b.c.ID))
+	})
+	for i, lm := range page(lex, candidates, 0) {
+		r := result(m.view(lm.c))
+		r.LexicalScore = lm.score
+		r.Score += 1.0 / float64(rrfK+i+1)
+	}
+
+	results := make([]SemanticResult, 0, len(order))
+	for _, id := range order {
+		results = append(results, *merged[id])
+	}
+	// Ties are broken by the playlist name, as in Postgres.
+	slices.SortFunc(results, func(a, b SemanticResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score),
+			strings.Compare(m.channels[a.Channel.ID].Name, m.channels[b.Channel.ID].Name),
+			cmp.Compare(a.Channel.ID, b.Channel.ID))
+	})
+	return page(results, filter.Limit, filter.Offset), len(results), nil
+}
+
+// ListChannelsBySource returns all channels for a source (with group name joined).
+func (m *Memory) ListChannelsBySource(ctx context.Context, sourceID int64) ([]models.Channel, error) {
+	return m.sourceChannels(sourceID, -1, func(*memChannel) bool { return true }), nil
+}
+
+// ListChannelsWithoutEmbeddings returns channels for a source that have no
+// embedding yet or one generated with another model than model.
+func (m *Memory) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, model string, limit int) ([]models.Channel, error) {
+	if limit <= 0 {
+		limit = 1000
+	}
+	return m.sourceChannels(sourceID, limit, func(c *memChannel) bool {
+		return c.embedding == nil || c.embeddingModel != model
+	}), nil
+}
+
+// sourceChannels returns the views of up to limit (-1 for all) channels of
+// a source accepted by keep, by id.
+func (m *Memory) sourceChannels(sourceID int64, limit int, keep func(*memChannel) bool) []models.Channel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var channels []models.Channel
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && keep(c) {
+			channels = append(channels, m.view(c))
+		}
+	}
+	slices.SortFunc(channels, func(a, b models.Channel) int { return cmp.Compare(a.ID, b.ID) })
+	if limit >= 0 && len(channels) > limit {
+		channels = channels[:limit]
+	}
+	return channels
+}
+
+// ListChannelTvgIDs returns the distinct non-empty tvg-ids of a source's
+// channels, sorted.
+func (m *Memory) ListChannelTvgIDs(ctx context.Context, sourceID int64) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []string
+	for _, c := range m.channels {
+		if c.SourceID == sourceID && c.TvgID != nil && *c.TvgID != "" && !slices.Contains(ids, *c.TvgID) {
+			ids = append(ids, *c.TvgID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// ReplaceEPGPrograms replaces the source's programmes with those returned by
+// next. They are read before the lock is taken, and a failing next leaves
+// the previous guide untouched.
+func (m *Memory) ReplaceEPGPrograms(ctx context.Context, sourceID int64, next func() (*models.EPGProgram, error)) (int64, error) {
+	var programs []models.EPGProgram
+	for {
+		prog, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("ReplaceEPGPrograms copy: %w", err)
+		}
+		programs = append(programs, *prog)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range programs {
+		programs[i].ID, programs[i].SourceID = m.nextID(), sourceID
+	}
+	m.epg[sourceID] = programs
+	return int64(len(programs)), nil
+}
+
+// ListChannelEPG returns the programmes of a channel that overlap [from, to),
+// ordered by start time, matched on its tvg-id within its own source.
+func (m *Memory) ListChannelEPG(ctx context.Context, channelID int64, from, to time.Time) ([]models.EPGProgram, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c := m.channels[channelID]
+	if c == nil || c.TvgID == nil {
+		return nil, nil
+	}
+	var programs []models.EPGProgram
+	for _, e := range m.epg[c.SourceID] {
+		if e.ChannelTvgID == *c.TvgID && e.Stop.After(from) && e.Start.Before(to) {
+			programs = append(programs, e)
+		}
+	}
+	slices.SortFunc(programs, func(a, b models.EPGProgram) int { return a.Start.Compare(b.Start) })
+	return programs, nil
+}
+
+// Ping always succeeds: there is no database to reach.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// MigrationVersion reports version 0: the in-memory store has no schema.
+func (m *Memory) MigrationVersion(ctx context.Context) (version int64, dirty bool, err error) {
+	return 0, false, nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+func strPtr(s string) *string { return &s }
+
+// TestBulkUpsertChannelHeaders refreshes a playlist twice: headers the
+// second playlist no longer lists are deleted unless they were edited
+// through the API, and edited ones are not replaced by the playlist's.
+func TestBulkUpsertChannelHeaders(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	src, err := m.CreateOrGetSource(ctx, "A", "http://example.com/a.m3u", models.SourceTypeM3U, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dropped, edited, kept int64
+	for _, p := range []struct {
+		id   *int64
+		name string
+	}{{&dropped, "Dropped"}, {&edited, "Edited"}, {&kept, "Kept"}} {
+		if *p.id, err = m.UpsertChannel(ctx, &models.Channel{SourceID: src, Name: p.name, URL: "http://example.com/" + p.name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// First refresh: the playlist gives every channel a referrer.
+	err = m.BulkUpsertChannelHeaders(ctx, map[int64]*models.ChannelHttpHeaders{
+		dropped: {Referrer: strPtr("http://a/")},
+		edited:  {Referrer: strPtr("http://a/")},
+		kept:    {Referrer: strPtr("http://a/")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The user edits one channel's headers.
+	if err := m.UpsertChannelHeaders(ctx, edited, &models.ChannelHttpHeaders{UserAgent: strPtr("mine"), Edited: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second refresh: the playlist drops the headers of two channels and
+	// changes the third.
+	err = m.BulkUpsertChannelHeaders(ctx, map[int64]*models.ChannelHttpHeaders{
+		dropped: nil,
+		edited:  nil,
+		kept:    {Referrer: strPtr("http://b/")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h, _ := m.GetChannelHeaders(ctx, dropped); h != nil {
+		t.Errorf("headers missing from the playlist = %+v, want deleted", h)
+	}
+	h, _ := m.GetChannelHeaders(ctx, edited)
+	if h == nil || !h.Edited || h.UserAgent == nil || *h.UserAgent != "mine" || h.Referrer != nil {
+		t.Errorf("edited headers after refresh = %+v, want the edited ones", h)
+	}
+	h, _ = m.GetChannelHeaders(ctx, kept)
+	if h == nil || h.Referrer == nil || *h.Referrer != "http://b/" {
+		t.Errorf("playlist headers after refresh = %+v, want referrer http://b/", h)
+	}
+
+	// A third refresh that lists headers for the edited channel leaves them
+	// alone too.
+	err = m.BulkUpsertChannelHeaders(ctx, map[int64]*models.ChannelHttpHeaders{edited: {Referrer: strPtr("http://c/")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := m.GetChannelHeaders(ctx, edited); h == nil || h.Referrer != nil || *h.UserAgent != "mine" {
+		t.Errorf("edited headers after a playlist upsert = %+v, want the edited ones", h)
+	}
+
+	// Nothing is stored when one of the channels does not exist.
+	err = m.BulkUpsertChannelHeaders(ctx, map[int64]*models.ChannelHttpHeaders{
+		kept:      {Referrer: strPtr("http://d/")},
+		kept + 99: {Referrer: strPtr("http://d/")},
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if h, _ := m.GetChannelHeaders(ctx, kept); *h.Referrer != "http://b/" {
+		t.Errorf("referrer after a failed upsert = %s, want http://b/", *h.Referrer)
+	}
+}
@@ -1,14 +1,22 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path/filepath"
 	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	_ "github.com/lib/pq"
+
+	"github.com/voyagen/popcornvault/migrations"
 )
 
 // EnsurePgvector attempts to create the pgvector extension. If the current
@@ -16,13 +24,53 @@ import (
 // exists. This allows non-superuser roles to run the app as long as a DBA
 // has pre-created the extension.
 func EnsurePgvector(dsn string) error {
+	return ensureExtension(dsn, "vector")
+}
+
+// EnsurePgTrgm does the same for pg_trgm, which indexes the channel name
+// search. Unlike pgvector it is optional: callers may log the error and
+// carry on with an unindexed search.
+func EnsurePgTrgm(dsn string) error {
+	return ensureExtension(dsn, "pg_trgm")
+}
+
+// PingDatabase checks that the database at dsn accepts connections.
+func PingDatabase(ctx context.Context, dsn string) error {
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return fmt.Errorf("open: %w", err)
 	}
 	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	return nil
+}
 
-	_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS vector")
+// ExtensionInstalled reports whether the named extension exists in the
+// database at dsn, without trying to create it.
+func ExtensionInstalled(ctx context.Context, dsn, name string) (bool, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return false, fmt.Errorf("open: %w", err)
+	}
+	defer db.Close()
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = $1)", name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("check %s: %w", name, err)
+	}
+	return exists, nil
+}
+
+func ensureExtension(dsn, name string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS " + name)
 	if err == nil {
 		return nil // created or already existed with sufficient privileges
 	}
@@ -30,25 +78,27 @@ func EnsurePgvector(dsn string) error {
 	// If we got a permission error, check whether the extension already exists.
 	if strings.Contains(err.Error(), "permission denied") {
 		var exists bool
-		qErr := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'vector')").Scan(&exists)
+		qErr := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = $1)", name).Scan(&exists)
 		if qErr != nil {
-			return fmt.Errorf("check pgvector: %w (original: %w)", qErr, err)
+			return fmt.Errorf("check %s: %w (original: %w)", name, qErr, err)
 		}
 		if exists {
 			return nil // extension was pre-created by an admin
 		}
-		return fmt.Errorf("pgvector extension is not installed and the current database user lacks permission to create it; "+
-			"ask your database admin to run: CREATE EXTENSION vector; (original: %w)", err)
+		return fmt.Errorf("%s extension is not installed and the current database user lacks permission to create it; "+
+			"ask your database admin to run: CREATE EXTENSION %s; (original: %w)", name, name, err)
 	}
 
-	return fmt.Errorf("create pgvector extension: %w", err)
+	return fmt.Errorf("create %s extension: %w", name, err)
 }
 
-// RunMigrations runs SQL migrations from the given directory (e.g. "file://migrations") against the DSN.
-func RunMigrations(dsn string, migrationsPath string) error {
-	m, err := migrate.New(migrationsPath, dsn)
+// RunMigrations applies all pending migrations against the DSN. The
+// migrations embedded in the binary are used unless dir names a directory
+// to read them from instead, which is handy when developing new ones.
+func RunMigrations(dsn, dir string) error {
+	m, err := newMigrate(dsn, dir)
 	if err != nil {
-		return fmt.Errorf("migrate.New: %w", err)
+		return err
 	}
 	defer m.Close()
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
@@ -56,3 +106,106 @@ func RunMigrations(dsn string, migrationsPath string) error {
 	}
 	return nil
 }
+
+// RollbackMigrations reverts the last n applied migrations.
+func RollbackMigrations(dsn, dir string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("rollback: step count must be positive, got %d", n)
+	}
+	m, err := newMigrate(dsn, dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Steps(-n); err != nil {
+		return fmt.Errorf("migrate.Steps: %w", err)
+	}
+	return nil
+}
+
+// MigrationVersion returns the schema version and whether the last migration
+// failed halfway (dirty). Version 0 means no migration has been applied.
+func MigrationVersion(dsn, dir string) (version uint, dirty bool, err error) {
+	m, err := newMigrate(dsn, dir)
+	if err != nil {
+		return 0, false, err
+	}
+	defer m.Close()
+	version, dirty, err = m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("migrate.Version: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// ForceMigrationVersion records version as applied and clears the dirty flag
+// without running any migration, after the schema has been repaired by hand.
+// Version -1 means no migration applied.
+func ForceMigrationVersion(dsn, dir string, version int) error {
+	m, err := newMigrate(dsn, dir)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("migrate.Force: %w", err)
+	}
+	return nil
+}
+
+// LatestMigration returns the highest migration version available from the
+// embedded migrations, or from dir when set.
+func LatestMigration(dir string) (uint, error) {
+	src, err := migrationSource(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	v, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("first migration: %w", err)
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return v, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("next migration: %w", err)
+		}
+		v = next
+	}
+}
+
+// newMigrate opens a migrator on the embedded migrations, or on dir when set.
+func newMigrate(dsn, dir string) (*migrate.Migrate, error) {
+	src, err := migrationSource(dir)
+	if err != nil {
+		return nil, err
+	}
+	m, err := migrate.NewWithSourceInstance("migrations", src, dsn)
+	if err != nil {
+		src.Close()
+		return nil, fmt.Errorf("migrate.New: %w", err)
+	}
+	return m, nil
+}
+
+// migrationSource reads the embedded migrations, or those in dir when set.
+func migrationSource(dir string) (source.Driver, error) {
+	if dir != "" {
+		src, err := source.Open("file://" + filepath.ToSlash(dir))
+		if err != nil {
+			return nil, fmt.Errorf("migrations in %s: %w", dir, err)
+		}
+		return src, nil
+	}
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("embedded migrations: %w", err)
+	}
+	return src, nil
+}
@@ -2,12 +2,17 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	pgvector "github.com/pgvector/pgvector-go"
 	"github.com/voyagen/popcornvault/internal/models"
@@ -16,11 +21,55 @@ import (
 // Postgres implements Store using PostgreSQL.
 type Postgres struct {
 	pool *pgxpool.Pool
+	db   dbtx // pool, or the open transaction inside WithTx
+	trgm bool // pg_trgm is installed, so name search results can be ranked
+	dims int  // declared dimension of channels.embedding
+
+	// writers holds a slot for each WithTx transaction, so that ingests
+	// cannot take every pooled connection from the API's reads.
+	writers          chan struct{}
+	statementTimeout time.Duration
 }
 
-// NewPostgres creates a Postgres store from a DSN. Caller must call Close when done.
-func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
-	pool, err := pgxpool.New(ctx, dsn)
+// PoolOptions sizes the connection pool. Zero values keep the pgxpool
+// defaults (see pgxpool.ParseConfig) or the DSN's pool_* parameters.
+type PoolOptions struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	HealthCheckPeriod time.Duration
+
+	// StatementTimeout is the statement_timeout of pooled connections; 0
+	// means none. Ingest transactions and index rebuilds are exempt.
+	StatementTimeout time.Duration
+
+	// WriteConns is how many WithTx transactions may hold a connection at
+	// once; 0 means a quarter of the pool, at least one.
+	WriteConns int
+
+	Tracer pgx.QueryTracer // optional, e.g. a SlowQueryTracer
+}
+
+// dbtx is the query surface shared by *pgxpool.Pool and pgx.Tx, so that
+// every store method can run either standalone or inside WithTx. Methods that
+// begin their own transaction get a savepoint when already inside one.
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// NewPostgres creates a Postgres store from a DSN, with the pool sized by
+// opts. Caller must call Close when done.
+func NewPostgres(ctx context.Context, dsn string, opts PoolOptions) (*Postgres, error) {
+	cfg, writeConns, err := poolConfig(dsn, opts)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("pgxpool.New: %w", err)
 	}
@@ -28,7 +77,49 @@ func NewPostgres(ctx context.Context, dsn string) (*Postgres, error) {
 		pool.Close()
 		return nil, fmt.Errorf("ping: %w", err)
 	}
-	return &Postgres{pool: pool}, nil
+	var trgm bool
+	if err := pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm')`).Scan(&trgm); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("check pg_trgm: %w", err)
+	}
+	p := &Postgres{pool: pool, db: pool, trgm: trgm, writers: make(chan struct{}, writeConns), statementTimeout: opts.StatementTimeout}
+	if p.dims, err = p.EmbeddingDimensions(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// poolConfig parses dsn and applies the non-zero opts to it. It also
+// returns the number of write connections, see PoolOptions.WriteConns.
+func poolConfig(dsn string, opts PoolOptions) (cfg *pgxpool.Config, writeConns int, err error) {
+	cfg, err = pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pgxpool.ParseConfig: %w", err)
+	}
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = opts.MinConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = opts.HealthCheckPeriod
+	}
+	if opts.StatementTimeout > 0 {
+		cfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	if opts.Tracer != nil {
+		cfg.ConnConfig.Tracer = opts.Tracer
+	}
+	writeConns = opts.WriteConns
+	if writeConns <= 0 {
+		writeConns = max(1, int(cfg.MaxConns)/4)
+	}
+	return cfg, writeConns, nil
 }
 
 // Close closes the connection pool.
@@ -36,13 +127,57 @@ func (p *Postgres) Close() {
 	p.pool.Close()
 }
 
-// CreateOrGetSource creates a source by name if not exists, returns id.
+// PoolStat returns the statistics of the connection pool.
+func (p *Postgres) PoolStat() *pgxpool.Stat {
+	return p.pool.Stat()
+}
+
+// WithTx runs fn with a Postgres store bound to one transaction. Channel
+// upserts, stale-row removal and every other write made through the store
+// passed to fn become visible together on commit, or not at all. At most
+// PoolOptions.WriteConns transactions run at once; the others wait their
+// turn. The transaction has no statement timeout.
+func (p *Postgres) WithTx(ctx context.Context, fn func(Store) error) error {
+	// A nested WithTx runs in a savepoint on the connection already held.
+	if p.db == dbtx(p.pool) {
+		select {
+		case p.writers <- struct{}{}:
+			defer func() { <-p.writers }()
+		case <-ctx.Done():
+			return fmt.Errorf("WithTx: waiting for a write connection: %w", ctx.Err())
+		}
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("WithTx begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if p.statementTimeout > 0 {
+		if _, err := tx.Exec(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+			return fmt.Errorf("WithTx: %w", err)
+		}
+	}
+
+	if err := fn(&Postgres{pool: p.pool, db: tx, trgm: p.trgm, dims: p.dims, writers: p.writers, statementTimeout: p.statementTimeout}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("WithTx commit: %w", err)
+	}
+	return nil
+}
+
+// CreateOrGetSource creates a source by name if not exists, returns id. An
+// existing source keeps its URL and user agent: those change only through
+// UpdateSource. The no-op update makes RETURNING yield its id.
 func (p *Postgres) CreateOrGetSource(ctx context.Context, name, url string, sourceType int16, userAgent string) (int64, error) {
 	var id int64
-	err := p.pool.QueryRow(ctx,
+	err := p.db.QueryRow(ctx,
 		`INSERT INTO sources (name, source_type, url, user_agent, enabled)
 		 VALUES ($1, $2, $3, NULLIF($4,''), true)
-		 ON CONFLICT (name) DO UPDATE SET url = EXCLUDED.url, user_agent = EXCLUDED.user_agent
+		 ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name
 		 RETURNING id`,
 		name, sourceType, url, userAgent,
 	).Scan(&id)
@@ -55,36 +190,36 @@ func (p *Postgres) CreateOrGetSource(ctx context.Context, name, url string, sour
 // RemoveStaleChannels deletes channels (and their headers via CASCADE) for the
 // source whose IDs are NOT in keepIDs. This is used during refresh to prune
 // channels that no longer exist in the upstream M3U without touching favourites
-// or other user data on channels that still exist.
-// Returns the number of deleted channels.
+// or other user data on channels that still exist. With archive the channels
+// are copied to archived_channels first (see staleDelete).
+// Returns the id and name of each deleted channel, for the refresh diff.
 //
 // For large channel counts, uses a temporary table instead of an array parameter
 // to avoid PostgreSQL performance issues with huge ANY/ALL arrays.
-func (p *Postgres) RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64) (int64, error) {
+func (p *Postgres) RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64, archive bool) ([]models.ChannelRef, error) {
 	if len(keepIDs) == 0 {
 		// Nothing to keep — delete every channel for this source.
-		tag, err := p.pool.Exec(ctx,
-			`DELETE FROM channels WHERE source_id = $1`, sourceID)
+		removed, err := collectChannelRefs(p.db.Query(ctx, staleDelete(`c.source_id = $1`, archive), sourceID))
 		if err != nil {
-			return 0, fmt.Errorf("RemoveStaleChannels (all): %w", err)
+			return nil, fmt.Errorf("RemoveStaleChannels (all): %w", err)
 		}
-		return tag.RowsAffected(), nil
+		return removed, nil
 	}
 
 	// Use a transaction with a temp table for efficient bulk exclusion.
-	tx, err := p.pool.Begin(ctx)
+	tx, err := p.db.Begin(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels begin: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels begin: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
 	// Drop any leftover temp table from a previous session on this connection,
 	// then create a fresh one without constraints for fast COPY inserts.
 	if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS _keep_ids`); err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels drop temp: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels drop temp: %w", err)
 	}
 	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE _keep_ids (id BIGINT) ON COMMIT DROP`); err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels create temp: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels create temp: %w", err)
 	}
 
 	// Bulk-insert keepIDs using COPY for speed.
@@ -95,36 +230,251 @@ func (p *Postgres) RemoveStaleChannels(ctx context.Context, sourceID int64, keep
 		&int64CopySource{ids: keepIDs},
 	)
 	if err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels copy: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels copy: %w", err)
 	}
 
 	// Index after bulk insert is faster than maintaining an index during COPY.
 	if _, err := tx.Exec(ctx, `CREATE INDEX ON _keep_ids (id)`); err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels index temp: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels index temp: %w", err)
 	}
 
 	// Analyze the temp table so the planner picks a good join strategy.
 	if _, err := tx.Exec(ctx, `ANALYZE _keep_ids`); err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels analyze temp: %w", err)
+		return nil, fmt.Errorf("RemoveStaleChannels analyze temp: %w", err)
 	}
 
 	// Delete channels not in the keep set.
-	tag, err := tx.Exec(ctx,
-		`DELETE FROM channels c
-		 WHERE c.source_id = $1
-		   AND NOT EXISTS (SELECT 1 FROM _keep_ids k WHERE k.id = c.id)`,
-		sourceID)
+	removed, err := collectChannelRefs(tx.Query(ctx,
+		staleDelete(`c.source_id = $1 AND NOT EXISTS (SELECT 1 FROM _keep_ids k WHERE k.id = c.id)`, archive),
+		sourceID))
+	if err != nil {
+		return nil, fmt.Errorf("RemoveStaleChannels delete: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("RemoveStaleChannels commit: %w", err)
+	}
+
+	return removed, nil
+}
+
+// staleDelete returns the statement deleting the channels c matching cond
+// and returning their ids and names. With archive it first copies each one
+// with its headers and props to archived_channels; every part of the
+// statement sees the rows as they were before it, so the headers are read
+// before the cascade deletes them.
+func staleDelete(cond string, archive bool) string {
+	if !archive {
+		return `DELETE FROM channels c WHERE ` + cond + ` RETURNING c.id, c.name`
+	}
+	return `WITH stale AS (
+		     DELETE FROM channels c WHERE ` + cond + ` RETURNING c.*
+		 ), archived AS (
+		     INSERT INTO archived_channels (id, source_id, name, url, tvg_id, channel, headers, props)
+		     SELECT s.id, s.source_id, s.name, s.url, s.tvg_id, to_jsonb(s),
+		            (SELECT to_jsonb(h) FROM channel_http_headers h WHERE h.channel_id = s.id),
+		            (SELECT pr.props FROM channel_props pr WHERE pr.channel_id = s.id)
+		     FROM stale s
+		 )
+		 SELECT id, name FROM stale`
+}
+
+// restoreArchived returns the statement moving the archived channels a
+// matching cond back to channels, with their headers and props, and
+// returning how many it moved. A channel whose name and URL its source has
+// again stays archived. A group deleted meanwhile is dropped; the upsert
+// that follows a refresh's restore sets the playlist's again.
+func restoreArchived(cond string) string {
+	return `WITH picked AS (
+		     DELETE FROM archived_channels a
+		     WHERE ` + cond + `
+		       AND NOT EXISTS (SELECT 1 FROM channels c WHERE c.source_id = a.source_id AND c.name = a.name AND c.url = a.url)
+		     RETURNING a.*
+		 ), restored AS (
+		     INSERT INTO channels
+		     SELECT r.*
+		     FROM picked p, jsonb_populate_record(NULL::channels, p.channel || jsonb_build_object('group_id',
+		            (SELECT g.id FROM groups g WHERE g.id = (p.channel->>'group_id')::bigint))) r
+		     RETURNING id
+		 ), headers AS (
+		     INSERT INTO channel_http_headers
+		     SELECT r.*
+		     FROM picked p, jsonb_populate_record(NULL::channel_http_headers, p.headers) r
+		     WHERE p.headers IS NOT NULL
+		 ), props AS (
+		     INSERT INTO channel_props (channel_id, props)
+		     SELECT p.id, p.props FROM picked p WHERE p.props IS NOT NULL
+		 )
+		 SELECT COUNT(*) FROM restored`
+}
+
+// RestoreArchivedChannels moves the source's archived channels that keys
+// list back to channels. Like RekeyChannelsByTvgID it stages the keys in a
+// temporary table.
+func (p *Postgres) RestoreArchivedChannels(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	// Most sources delete stale channels and have nothing to restore.
+	var archived bool
+	if err := p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM archived_channels WHERE source_id = $1)`, sourceID).Scan(&archived); err != nil {
+		return 0, fmt.Errorf("RestoreArchivedChannels: %w", err)
+	}
+	if !archived {
+		return 0, nil
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("RestoreArchivedChannels begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS _restore_keys`); err != nil {
+		return 0, fmt.Errorf("RestoreArchivedChannels drop temp: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`CREATE TEMP TABLE _restore_keys (tvg_id TEXT, name TEXT, url TEXT) ON COMMIT DROP`); err != nil {
+		return 0, fmt.Errorf("RestoreArchivedChannels create temp: %w", err)
+	}
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"_restore_keys"},
+		[]string{"tvg_id", "name", "url"},
+		pgx.CopyFromSlice(len(keys), func(i int) ([]any, error) {
+			return []any{keys[i].TvgID, keys[i].Name, keys[i].URL}, nil
+		}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("RestoreArchivedChannels copy: %w", err)
+	}
+
+	var n int64
+	err = tx.QueryRow(ctx, restoreArchived(
+		`a.source_id = $1
+		   AND (EXISTS (SELECT 1 FROM _restore_keys k WHERE k.name = a.name AND k.url = a.url)
+		        OR (a.tvg_id <> ''
+		            AND EXISTS (SELECT 1 FROM _restore_keys k WHERE k.tvg_id = a.tvg_id)
+		            AND NOT EXISTS (SELECT 1 FROM channels d WHERE d.source_id = $1 AND d.tvg_id = a.tvg_id)))`),
+		sourceID).Scan(&n)
 	if err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels delete: %w", err)
+		return 0, fmt.Errorf("RestoreArchivedChannels: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return 0, fmt.Errorf("RemoveStaleChannels commit: %w", err)
+		return 0, fmt.Errorf("RestoreArchivedChannels commit: %w", err)
+	}
+	return n, nil
+}
+
+// ListArchivedChannels returns a page of the source's archived channels,
+// most recently archived first, and their total.
+func (p *Postgres) ListArchivedChannels(ctx context.Context, sourceID int64, limit, offset int) ([]models.ArchivedChannel, int, error) {
+	var total int
+	if err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM archived_channels WHERE source_id = $1`, sourceID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("ListArchivedChannels count: %w", err)
+	}
+	rows, err := p.db.Query(ctx,
+		`SELECT id, source_id, COALESCE(channel->>'custom_name', name), COALESCE(channel->>'custom_url', url), tvg_id,
+		        COALESCE((channel->>'favorite')::boolean, false), COALESCE((channel->>'hidden')::boolean, false), archived_at
+		 FROM archived_channels
+		 WHERE source_id = $1
+		 ORDER BY archived_at DESC, id DESC
+		 LIMIT $2 OFFSET $3`,
+		sourceID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListArchivedChannels: %w", err)
 	}
+	defer rows.Close()
+
+	var out []models.ArchivedChannel
+	for rows.Next() {
+		var a models.ArchivedChannel
+		if err := rows.Scan(&a.ID, &a.SourceID, &a.Name, &a.URL, &a.TvgID, &a.Favorite, &a.Hidden, &a.ArchivedAt); err != nil {
+			return nil, 0, fmt.Errorf("ListArchivedChannels scan: %w", err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ListArchivedChannels rows: %w", err)
+	}
+	return out, total, nil
+}
 
+// RestoreArchivedChannel moves one archived channel back to channels.
+func (p *Postgres) RestoreArchivedChannel(ctx context.Context, channelID int64) error {
+	var n int64
+	if err := p.db.QueryRow(ctx, restoreArchived(`a.id = $1`), channelID).Scan(&n); err != nil {
+		return fmt.Errorf("RestoreArchivedChannel: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	// Not restored: either not archived, or its name and URL are taken.
+	var archived bool
+	if err := p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM archived_channels WHERE id = $1)`, channelID).Scan(&archived); err != nil {
+		return fmt.Errorf("RestoreArchivedChannel: %w", err)
+	}
+	if archived {
+		return fmt.Errorf("archived channel %d: name and url taken: %w", channelID, ErrConflict)
+	}
+	return fmt.Errorf("archived channel %d: %w", channelID, ErrNotFound)
+}
+
+// PruneArchivedChannels deletes the channels archived before cutoff.
+func (p *Postgres) PruneArchivedChannels(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM archived_channels WHERE archived_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("PruneArchivedChannels: %w", err)
+	}
 	return tag.RowsAffected(), nil
 }
 
+// collectChannelRefs reads the id and name rows of a query, closing them.
+func collectChannelRefs(rows pgx.Rows, err error) ([]models.ChannelRef, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []models.ChannelRef
+	for rows.Next() {
+		var ref models.ChannelRef
+		if err := rows.Scan(&ref.ID, &ref.Name); err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+// ChannelStates returns the playlist name, URL, tvg-id, group and edited
+// fields of the source's channels by id.
+func (p *Postgres) ChannelStates(ctx context.Context, sourceID int64) (map[int64]ChannelState, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT c.id, c.name, c.url, c.tvg_id, c.group_id, g.name, c.edited_fields
+		 FROM channels c
+		 LEFT JOIN groups g ON c.group_id = g.id
+		 WHERE c.source_id = $1`, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("ChannelStates: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[int64]ChannelState)
+	for rows.Next() {
+		var (
+			id int64
+			st ChannelState
+		)
+		if err := rows.Scan(&id, &st.Name, &st.URL, &st.TvgID, &st.GroupID, &st.Group, &st.EditedFields); err != nil {
+			return nil, fmt.Errorf("ChannelStates scan: %w", err)
+		}
+		states[id] = st
+	}
+	return states, rows.Err()
+}
+
 // int64CopySource implements pgx.CopyFromSource for a slice of int64 values.
 type int64CopySource struct {
 	ids []int64
@@ -145,24 +495,42 @@ func (s *int64CopySource) Err() error {
 	return nil
 }
 
-// RemoveOrphanedGroups deletes groups for the source that have no remaining channels.
-// Returns the number of deleted groups.
-func (p *Postgres) RemoveOrphanedGroups(ctx context.Context, sourceID int64) (int64, error) {
-	tag, err := p.pool.Exec(ctx,
-		`DELETE FROM groups
-		 WHERE source_id = $1
-		   AND id NOT IN (SELECT DISTINCT group_id FROM channels WHERE source_id = $1 AND group_id IS NOT NULL)`,
+// RemoveOrphanedGroups deletes groups for the source that have no remaining
+// channels and returns them in id order. NOT EXISTS is an anti-join probing
+// idx_channels_source_group once per group, where NOT IN over the source's
+// channels could not be planned as one and rescanned them for every group.
+func (p *Postgres) RemoveOrphanedGroups(ctx context.Context, sourceID int64) ([]models.GroupRef, error) {
+	rows, err := p.db.Query(ctx,
+		`WITH removed AS (
+		   DELETE FROM groups g
+		   WHERE g.source_id = $1
+		     AND NOT EXISTS (SELECT 1 FROM channels c WHERE c.source_id = $1 AND c.group_id = g.id)
+		   RETURNING g.id, g.name)
+		 SELECT id, name FROM removed ORDER BY id`,
 		sourceID)
 	if err != nil {
-		return 0, fmt.Errorf("RemoveOrphanedGroups: %w", err)
+		return nil, fmt.Errorf("RemoveOrphanedGroups: %w", err)
 	}
-	return tag.RowsAffected(), nil
+	defer rows.Close()
+
+	var groups []models.GroupRef
+	for rows.Next() {
+		var g models.GroupRef
+		if err := rows.Scan(&g.ID, &g.Name); err != nil {
+			return nil, fmt.Errorf("RemoveOrphanedGroups scan: %w", err)
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("RemoveOrphanedGroups: %w", err)
+	}
+	return groups, nil
 }
 
 // GetOrCreateGroup returns group id for name/sourceID.
 func (p *Postgres) GetOrCreateGroup(ctx context.Context, sourceID int64, name string, image *string) (int64, error) {
 	var id int64
-	err := p.pool.QueryRow(ctx,
+	err := p.db.QueryRow(ctx,
 		`INSERT INTO groups (name, image, source_id) VALUES ($1, $2, $3)
 		 ON CONFLICT (name, source_id) DO UPDATE SET image = COALESCE(EXCLUDED.image, groups.image)
 		 RETURNING id`,
@@ -174,16 +542,42 @@ func (p *Postgres) GetOrCreateGroup(ctx context.Context, sourceID int64, name st
 	return id, nil
 }
 
+// keepEditedSet is the part of a channel upsert's SET list for the fields a
+// user can edit: a refresh overwrites them only while they are unedited (see
+// models.EditedFields; 4 is the image, 8 the group, 16 the media type).
+// Edited names and URLs live in custom_name and custom_url, which refreshes
+// never touch.
+const keepEditedSet = `image = CASE WHEN channels.edited_fields & 4 <> 0 THEN channels.image ELSE EXCLUDED.image END,
+		   media_type = CASE WHEN channels.edited_fields & 16 <> 0 THEN channels.media_type ELSE EXCLUDED.media_type END,
+		   group_id = CASE WHEN channels.edited_fields & 8 <> 0 THEN channels.group_id ELSE EXCLUDED.group_id END`
+
+// touchUpdatedSet is the part of a channel upsert's SET list that moves
+// updated_at to now when the playlist changed any field the upsert writes,
+// leaving it alone for the unchanged channels a refresh upserts again.
+const touchUpdatedSet = `updated_at = CASE WHEN
+		     (channels.tvg_id, channels.channel_number, channels.series_name, channels.season, channels.episode, channels.quality, channels.display_name)
+		     IS DISTINCT FROM (EXCLUDED.tvg_id, EXCLUDED.channel_number, EXCLUDED.series_name, EXCLUDED.season, EXCLUDED.episode, EXCLUDED.quality, EXCLUDED.display_name)
+		     OR (channels.edited_fields & 4 = 0 AND channels.image IS DISTINCT FROM EXCLUDED.image)
+		     OR (channels.edited_fields & 16 = 0 AND channels.media_type <> EXCLUDED.media_type)
+		     OR (channels.edited_fields & 8 = 0 AND channels.group_id IS DISTINCT FROM EXCLUDED.group_id)
+		   THEN now() ELSE channels.updated_at END`
+
 // UpsertChannel inserts or updates a channel; returns channel id.
 func (p *Postgres) UpsertChannel(ctx context.Context, ch *models.Channel) (int64, error) {
 	var id int64
-	err := p.pool.QueryRow(ctx,
-		`INSERT INTO channels (name, image, url, media_type, source_id, group_id, favorite)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+	err := p.db.QueryRow(ctx,
+		`INSERT INTO channels (name, image, url, media_type, source_id, group_id, favorite, tvg_id, channel_number,
+		   series_name, season, episode, quality, display_name)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		 ON CONFLICT (name, source_id, url) DO UPDATE SET
-		   image = EXCLUDED.image, media_type = EXCLUDED.media_type, group_id = EXCLUDED.group_id
+		   `+touchUpdatedSet+`,
+		   `+keepEditedSet+`,
+		   tvg_id = EXCLUDED.tvg_id, channel_number = EXCLUDED.channel_number,
+		   series_name = EXCLUDED.series_name, season = EXCLUDED.season, episode = EXCLUDED.episode,
+		   quality = EXCLUDED.quality, display_name = EXCLUDED.display_name
 		 RETURNING id`,
-		ch.Name, ch.Image, ch.URL, ch.MediaType, ch.SourceID, ch.GroupID, ch.Favorite,
+		ch.Name, ch.Image, ch.URL, ch.MediaType, ch.SourceID, ch.GroupID, ch.Favorite, ch.TvgID, ch.Number,
+		ch.Series, ch.Season, ch.Episode, ch.Quality, ch.DisplayName,
 	).Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("UpsertChannel: %w", err)
@@ -191,39 +585,364 @@ func (p *Postgres) UpsertChannel(ctx context.Context, ch *models.Channel) (int64
 	return id, nil
 }
 
-// UpsertChannelHeaders inserts or updates headers for a channel.
+// RekeyChannelsByTvgID renames existing channels whose tvg-id matches a key
+// to the key's name and URL, so that the ON CONFLICT (name, source_id, url)
+// upsert that follows finds them. This makes tvg-id the effective identity of
+// a channel: when a provider changes a stream URL, the row, its favorite flag
+// and its embedding survive. A channel is only moved when its tvg-id is
+// unique within the source and no other channel already has the target name
+// and URL; every other case falls back to the (name, source_id, url) key.
+func (p *Postgres) RekeyChannelsByTvgID(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS _rekey_channels`); err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID drop temp: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`CREATE TEMP TABLE _rekey_channels (tvg_id TEXT, name TEXT, url TEXT) ON COMMIT DROP`); err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID create temp: %w", err)
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"_rekey_channels"},
+		[]string{"tvg_id", "name", "url"},
+		pgx.CopyFromSlice(len(keys), func(i int) ([]any, error) {
+			return []any{keys[i].TvgID, keys[i].Name, keys[i].URL}, nil
+		}),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID copy: %w", err)
+	}
+
+	// DISTINCT ON guards against two keys targeting the same name and URL,
+	// which would violate the unique constraint within one statement.
+	tag, err := tx.Exec(ctx,
+		`UPDATE channels c SET name = k.name, url = k.url, updated_at = now()
+		 FROM (SELECT DISTINCT ON (name, url) tvg_id, name, url
+		       FROM _rekey_channels ORDER BY name, url, tvg_id) k
+		 WHERE c.source_id = $1
+		   AND c.tvg_id = k.tvg_id
+		   AND (c.name <> k.name OR c.url <> k.url)
+		   AND NOT EXISTS (SELECT 1 FROM channels d
+		                   WHERE d.source_id = $1 AND d.tvg_id = c.tvg_id AND d.id <> c.id)
+		   AND NOT EXISTS (SELECT 1 FROM channels e
+		                   WHERE e.source_id = $1 AND e.name = k.name AND e.url = k.url)`,
+		sourceID)
+	if err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID update: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("RekeyChannelsByTvgID commit: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// BulkUpsertChannels upserts channels in one transaction: rows are COPYed into
+// a temporary staging table and merged with a single INSERT ... ON CONFLICT,
+// the same temp-table approach RemoveStaleChannels uses for large id sets.
+// Returned ids are in input order; duplicate entries within the batch resolve
+// to the same id, and the last duplicate's fields win as with UpsertChannel.
+func (p *Postgres) BulkUpsertChannels(ctx context.Context, channels []models.Channel) ([]int64, error) {
+	if len(channels) == 0 {
+		return nil, nil
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS _stage_channels`); err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels drop temp: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`CREATE TEMP TABLE _stage_channels (
+		   ord INT, name TEXT, image TEXT, url TEXT, media_type SMALLINT,
+		   source_id BIGINT, group_id BIGINT, favorite BOOLEAN, tvg_id TEXT, channel_number INTEGER,
+		   series_name TEXT, season INTEGER, episode INTEGER, quality TEXT, display_name TEXT
+		 ) ON COMMIT DROP`); err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels create temp: %w", err)
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"_stage_channels"},
+		[]string{"ord", "name", "image", "url", "media_type", "source_id", "group_id", "favorite", "tvg_id", "channel_number",
+			"series_name", "season", "episode", "quality", "display_name"},
+		pgx.CopyFromSlice(len(channels), func(i int) ([]any, error) {
+			ch := &channels[i]
+			return []any{i, ch.Name, ch.Image, ch.URL, ch.MediaType, ch.SourceID, ch.GroupID, ch.Favorite, ch.TvgID, ch.Number,
+				ch.Series, ch.Season, ch.Episode, ch.Quality, ch.DisplayName}, nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels copy: %w", err)
+	}
+
+	// DISTINCT ON keeps one row per conflict key; ON CONFLICT cannot touch
+	// the same row twice in one statement.
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO channels (name, image, url, media_type, source_id, group_id, favorite, tvg_id, channel_number,
+		   series_name, season, episode, quality, display_name)
+		 SELECT DISTINCT ON (name, source_id, url) name, image, url, media_type, source_id, group_id, favorite, tvg_id, channel_number,
+		   series_name, season, episode, quality, display_name
+		 FROM _stage_channels
+		 ORDER BY name, source_id, url, ord DESC
+		 ON CONFLICT (name, source_id, url) DO UPDATE SET
+		   `+touchUpdatedSet+`,
+		   `+keepEditedSet+`,
+		   tvg_id = EXCLUDED.tvg_id, channel_number = EXCLUDED.channel_number,
+		   series_name = EXCLUDED.series_name, season = EXCLUDED.season, episode = EXCLUDED.episode,
+		   quality = EXCLUDED.quality, display_name = EXCLUDED.display_name`); err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels merge: %w", err)
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT s.ord, c.id
+		 FROM _stage_channels s
+		 JOIN channels c ON c.source_id = s.source_id AND c.name = s.name AND c.url = s.url`)
+	if err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels ids: %w", err)
+	}
+	ids := make([]int64, len(channels))
+	for rows.Next() {
+		var ord int
+		var id int64
+		if err := rows.Scan(&ord, &id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("BulkUpsertChannels ids scan: %w", err)
+		}
+		ids[ord] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels ids rows: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("BulkUpsertChannels commit: %w", err)
+	}
+	return ids, nil
+}
+
+// UpsertChannelHeaders inserts or updates headers for a channel. Headers
+// from a playlist do not replace ones marked edited; edited headers replace
+// whatever is stored and keep the mark.
 func (p *Postgres) UpsertChannelHeaders(ctx context.Context, channelID int64, h *models.ChannelHttpHeaders) error {
 	ignoreSSL := false
 	if h.IgnoreSSL != nil {
 		ignoreSSL = *h.IgnoreSSL
 	}
-	_, err := p.pool.Exec(ctx,
-		`INSERT INTO channel_http_headers (channel_id, referrer, user_agent, http_origin, ignore_ssl)
-		 VALUES ($1, $2, $3, $4, $5)
+	_, err := p.db.Exec(ctx,
+		`INSERT INTO channel_http_headers (channel_id, referrer, user_agent, http_origin, ignore_ssl, edited)
+		 VALUES ($1, $2, $3, $4, $5, $6)
 		 ON CONFLICT (channel_id) DO UPDATE SET
 		   referrer = EXCLUDED.referrer, user_agent = EXCLUDED.user_agent,
-		   http_origin = EXCLUDED.http_origin, ignore_ssl = EXCLUDED.ignore_ssl`,
-		channelID, h.Referrer, h.UserAgent, h.HTTPOrigin, ignoreSSL,
+		   http_origin = EXCLUDED.http_origin, ignore_ssl = EXCLUDED.ignore_ssl,
+		   edited = EXCLUDED.edited
+		 WHERE EXCLUDED.edited OR NOT channel_http_headers.edited`,
+		channelID, h.Referrer, h.UserAgent, h.HTTPOrigin, ignoreSSL, h.Edited,
 	)
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation
+			return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+		}
 		return fmt.Errorf("UpsertChannelHeaders: %w", err)
 	}
 	return nil
 }
 
+// BulkUpsertChannelHeaders upserts the headers of many channels, and deletes
+// those of nil entries unless edited, by staging them in a temp table.
+func (p *Postgres) BulkUpsertChannelHeaders(ctx context.Context, headers map[int64]*models.ChannelHttpHeaders) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(headers))
+	for id := range headers {
+		ids = append(ids, id)
+	}
+
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DROP TABLE IF EXISTS _stage_headers`); err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders drop temp: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`CREATE TEMP TABLE _stage_headers (
+		   channel_id BIGINT, present BOOLEAN, referrer TEXT, user_agent TEXT, http_origin TEXT,
+		   ignore_ssl BOOLEAN, edited BOOLEAN
+		 ) ON COMMIT DROP`); err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders create temp: %w", err)
+	}
+
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"_stage_headers"},
+		[]string{"channel_id", "present", "referrer", "user_agent", "http_origin", "ignore_ssl", "edited"},
+		pgx.CopyFromSlice(len(ids), func(i int) ([]any, error) {
+			h := headers[ids[i]]
+			if h == nil {
+				return []any{ids[i], false, nil, nil, nil, false, false}, nil
+			}
+			ignoreSSL := h.IgnoreSSL != nil && *h.IgnoreSSL
+			return []any{ids[i], true, h.Referrer, h.UserAgent, h.HTTPOrigin, ignoreSSL, h.Edited}, nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders copy: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM channel_http_headers h
+		 USING _stage_headers s
+		 WHERE h.channel_id = s.channel_id AND NOT s.present AND NOT h.edited`); err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders delete: %w", err)
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO channel_http_headers (channel_id, referrer, user_agent, http_origin, ignore_ssl, edited)
+		 SELECT channel_id, referrer, user_agent, http_origin, ignore_ssl, edited
+		 FROM _stage_headers WHERE present
+		 ON CONFLICT (channel_id) DO UPDATE SET
+		   referrer = EXCLUDED.referrer, user_agent = EXCLUDED.user_agent,
+		   http_origin = EXCLUDED.http_origin, ignore_ssl = EXCLUDED.ignore_ssl,
+		   edited = EXCLUDED.edited
+		 WHERE EXCLUDED.edited OR NOT channel_http_headers.edited`); err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders merge: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("BulkUpsertChannelHeaders commit: %w", err)
+	}
+	return nil
+}
+
+// GetChannelHeaders returns the HTTP headers of a channel, or nil if it has
+// none.
+func (p *Postgres) GetChannelHeaders(ctx context.Context, channelID int64) (*models.ChannelHttpHeaders, error) {
+	h := models.ChannelHttpHeaders{ChannelID: channelID}
+	err := p.db.QueryRow(ctx,
+		`SELECT id, referrer, user_agent, http_origin, ignore_ssl, edited FROM channel_http_headers WHERE channel_id = $1`,
+		channelID,
+	).Scan(&h.ID, &h.Referrer, &h.UserAgent, &h.HTTPOrigin, &h.IgnoreSSL, &h.Edited)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetChannelHeaders: %w", err)
+	}
+	return &h, nil
+}
+
+// UpsertChannelProps inserts or replaces the player properties of a channel.
+func (p *Postgres) UpsertChannelProps(ctx context.Context, channelID int64, props map[string]string) error {
+	_, err := p.db.Exec(ctx,
+		`INSERT INTO channel_props (channel_id, props) VALUES ($1, $2)
+		 ON CONFLICT (channel_id) DO UPDATE SET props = EXCLUDED.props`,
+		channelID, props,
+	)
+	if err != nil {
+		return fmt.Errorf("UpsertChannelProps: %w", err)
+	}
+	return nil
+}
+
+// BulkUpsertChannelProps inserts or replaces the player properties of many
+// channels in one statement.
+func (p *Postgres) BulkUpsertChannelProps(ctx context.Context, props map[int64]map[string]string) error {
+	if len(props) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(props))
+	docs := make([]string, 0, len(props))
+	for id, pr := range props {
+		doc, err := json.Marshal(pr)
+		if err != nil {
+			return fmt.Errorf("BulkUpsertChannelProps: channel %d: %w", id, err)
+		}
+		ids = append(ids, id)
+		docs = append(docs, string(doc))
+	}
+	_, err := p.db.Exec(ctx,
+		`INSERT INTO channel_props (channel_id, props)
+		 SELECT channel_id, props::jsonb FROM unnest($1::bigint[], $2::text[]) AS s(channel_id, props)
+		 ON CONFLICT (channel_id) DO UPDATE SET props = EXCLUDED.props`,
+		ids, docs,
+	)
+	if err != nil {
+		return fmt.Errorf("BulkUpsertChannelProps: %w", err)
+	}
+	return nil
+}
+
 // UpdateSourceLastUpdated sets last_updated for the source.
 func (p *Postgres) UpdateSourceLastUpdated(ctx context.Context, sourceID int64) error {
-	_, err := p.pool.Exec(ctx, `UPDATE sources SET last_updated = NOW() WHERE id = $1`, sourceID)
+	_, err := p.db.Exec(ctx, `UPDATE sources SET last_updated = NOW() WHERE id = $1`, sourceID)
 	if err != nil {
 		return fmt.Errorf("UpdateSourceLastUpdated: %w", err)
 	}
 	return nil
 }
 
-// ListSources returns all sources ordered by id.
+// UpdateSourceValidators records the version of the playlist just ingested.
+// Empty values are stored as NULL.
+func (p *Postgres) UpdateSourceValidators(ctx context.Context, sourceID int64, etag, lastModified, contentHash string, parserVersion int) error {
+	_, err := p.db.Exec(ctx,
+		`UPDATE sources SET etag = NULLIF($2, ''), last_modified = NULLIF($3, ''), content_hash = NULLIF($4, ''),
+		   parser_version = $5
+		 WHERE id = $1`,
+		sourceID, etag, lastModified, contentHash, parserVersion)
+	if err != nil {
+		return fmt.Errorf("UpdateSourceValidators: %w", err)
+	}
+	return nil
+}
+
+// UpdateSourceEmbeddingRun records the totals of the source's last
+// completed embedding run.
+func (p *Postgres) UpdateSourceEmbeddingRun(ctx context.Context, sourceID int64, run models.EmbeddingRun) error {
+	_, err := p.db.Exec(ctx, `UPDATE sources SET last_embedding_run = $2 WHERE id = $1`, sourceID, run)
+	if err != nil {
+		return fmt.Errorf("UpdateSourceEmbeddingRun: %w", err)
+	}
+	return nil
+}
+
+// SetPlaylistEPGURL stores the playlist's EPG URL on the source unless the
+// user has chosen one explicitly.
+func (p *Postgres) SetPlaylistEPGURL(ctx context.Context, sourceID int64, epgURL string) error {
+	_, err := p.db.Exec(ctx,
+		`UPDATE sources SET epg_url = NULLIF($2, '') WHERE id = $1 AND NOT epg_url_manual`,
+		sourceID, epgURL)
+	if err != nil {
+		return fmt.Errorf("SetPlaylistEPGURL: %w", err)
+	}
+	return nil
+}
+
+// ListSources returns all sources ordered by id, with their channel and
+// group counts.
 func (p *Postgres) ListSources(ctx context.Context) ([]models.Source, error) {
-	rows, err := p.pool.Query(ctx,
-		`SELECT id, name, source_type, url, use_tvg_id, user_agent, enabled, last_updated, created_at
+	rows, err := p.db.Query(ctx,
+		`SELECT `+sourceColumns+`,
+		        (SELECT COUNT(*) FROM channels c WHERE c.source_id = sources.id),
+		        (SELECT COUNT(*) FROM groups g WHERE g.source_id = sources.id)
 		 FROM sources ORDER BY id`)
 	if err != nil {
 		return nil, fmt.Errorf("ListSources: %w", err)
@@ -233,37 +952,75 @@ func (p *Postgres) ListSources(ctx context.Context) ([]models.Source, error) {
 	var sources []models.Source
 	for rows.Next() {
 		var s models.Source
-		var userAgent *string
-		if err := rows.Scan(&s.ID, &s.Name, &s.SourceType, &s.URL, &s.UseTvgID, &userAgent, &s.Enabled, &s.LastUpdated, &s.CreatedAt); err != nil {
+		var channels, groups int
+		if err := rows.Scan(append(sourceDest(&s), &channels, &groups)...); err != nil {
 			return nil, fmt.Errorf("ListSources scan: %w", err)
 		}
-		if userAgent != nil {
-			s.UserAgent = *userAgent
-		}
+		s.ChannelCount, s.GroupCount = &channels, &groups
 		sources = append(sources, s)
 	}
 	return sources, rows.Err()
 }
 
-// GetChannelByID returns a single channel by id with group name joined.
+// sourceColumns is the select list for reading a source; nullable text
+// columns are coalesced to empty strings. Scan it with sourceDest.
+const sourceColumns = `id, name, source_type, COALESCE(url, ''), use_tvg_id, COALESCE(user_agent, ''), COALESCE(epg_url, ''), epg_url_manual,
+	enabled, last_updated, created_at, COALESCE(etag, ''), COALESCE(last_modified, ''), COALESCE(content_hash, ''), fetch_headers,
+	guess_media_type, dedupe, parser_version, dead_channel_policy, dead_channel_threshold, stale_policy, last_embedding_run,
+	COALESCE(webhook_url, '')`
+
+// sourceDest returns the scan destinations matching sourceColumns.
+func sourceDest(s *models.Source) []any {
+	return []any{&s.ID, &s.Name, &s.SourceType, &s.URL, &s.UseTvgID, &s.UserAgent, &s.EPGURL, &s.EPGURLManual,
+		&s.Enabled, &s.LastUpdated, &s.CreatedAt, &s.ETag, &s.LastModified, &s.ContentHash, &s.FetchHeaders,
+		&s.GuessMediaType, &s.Dedupe, &s.ParserVersion, &s.DeadPolicy, &s.DeadThreshold, &s.StalePolicy, &s.LastEmbeddingRun,
+		&s.WebhookURL}
+}
+
+// channelColumns is the select list for reading a channel with its group
+// name and image and its source name; queries alias channels as c, LEFT
+// JOIN groups as g and JOIN sources as src. Scan it with channelDest.
+const channelColumns = `c.id, COALESCE(c.custom_name, c.name), c.image, COALESCE(c.custom_url, c.url), c.media_type, c.source_id, c.group_id, c.favorite, c.tvg_id, c.channel_number,
+	c.series_name, c.season, c.episode, c.quality, c.display_name, c.status, c.status_code, c.last_checked,
+	c.fail_count, c.hidden, c.created_at, c.updated_at, c.edited_fields, g.name, g.image, src.name`
+
+// channelDest returns the scan destinations matching channelColumns.
+func channelDest(ch *models.Channel) []any {
+	return []any{&ch.ID, &ch.Name, &ch.Image, &ch.URL, &ch.MediaType, &ch.SourceID, &ch.GroupID, &ch.Favorite, &ch.TvgID, &ch.Number,
+		&ch.Series, &ch.Season, &ch.Episode, &ch.Quality, &ch.DisplayName, &ch.Status, &ch.StatusCode, &ch.LastChecked,
+		&ch.FailCount, &ch.Hidden, &ch.CreatedAt, &ch.UpdatedAt, &ch.Edited, &ch.GroupName, &ch.GroupImage, &ch.SourceName}
+}
+
+// GetChannelByID returns a single channel by id with group name and HTTP
+// headers joined.
 func (p *Postgres) GetChannelByID(ctx context.Context, channelID int64) (*models.Channel, error) {
 	var ch models.Channel
-	err := p.pool.QueryRow(ctx,
-		`SELECT c.id, c.name, c.image, c.url, c.media_type, c.source_id, c.group_id, c.favorite, g.name
+	var h models.ChannelHttpHeaders
+	var headersID *int64
+	var edited *bool
+	err := p.db.QueryRow(ctx,
+		`SELECT `+channelColumns+`, h.id, h.referrer, h.user_agent, h.http_origin, h.ignore_ssl, h.edited
 		 FROM channels c
 		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
+		 LEFT JOIN channel_http_headers h ON h.channel_id = c.id
 		 WHERE c.id = $1`, channelID,
-	).Scan(&ch.ID, &ch.Name, &ch.Image, &ch.URL, &ch.MediaType, &ch.SourceID, &ch.GroupID, &ch.Favorite, &ch.GroupName)
+	).Scan(append(channelDest(&ch), &headersID, &h.Referrer, &h.UserAgent, &h.HTTPOrigin, &h.IgnoreSSL, &edited)...)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
 		}
 		return nil, fmt.Errorf("GetChannelByID: %w", err)
 	}
+	if headersID != nil {
+		h.ID, h.ChannelID, h.Edited = *headersID, ch.ID, edited != nil && *edited
+		ch.Headers = &h
+	}
 	return &ch, nil
 }
 
-// ListChannels returns channels matching the filter and total count (before limit/offset).
+// ListChannels returns channels matching the filter and total count (before
+// limit/offset or cursor), or -1 for the count with NoTotal.
 func (p *Postgres) ListChannels(ctx context.Context, filter ChannelFilter) ([]models.Channel, int, error) {
 	// Apply defaults.
 	if filter.Limit <= 0 {
@@ -276,14 +1033,91 @@ func (p *Postgres) ListChannels(ctx context.Context, filter ChannelFilter) ([]mo
 		filter.Offset = 0
 	}
 
-	// Build dynamic WHERE clause.
-	where := []string{}
-	args := []any{}
-	argIdx := 1
+	where, args, argIdx := channelFilterClauses(filter, 1)
 
-	if filter.SourceID != nil {
-		where = append(where, fmt.Sprintf("c.source_id = $%d", argIdx))
-		args = append(args, *filter.SourceID)
+	whereClause := ""
+	if len(where) > 0 {
+		whereClause = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	// Count query.
+	total := -1
+	if !filter.NoTotal {
+		countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM channels c %s`, whereClause)
+		if err := p.db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+			return nil, 0, fmt.Errorf("ListChannels count: %w", err)
+		}
+	}
+
+	// Ranked searches put the names most similar to the term first.
+	order := channelOrder(filter)
+	dataArgs := args
+	if filter.Rank && filter.Search != "" && p.trgm {
+		if filter.After != nil {
+			return nil, 0, fmt.Errorf("ListChannels: %w: ranked results cannot be paged by cursor", ErrBadCursor)
+		}
+		order = fmt.Sprintf("similarity(%s, $%d) DESC, %s", channelName, argIdx, order)
+		dataArgs = append(dataArgs, filter.Search)
+		argIdx++
+	}
+
+	// A cursor page starts after the cursor's channel rather than at an
+	// offset; the count above still covers every match.
+	if filter.After != nil {
+		var after string
+		var afterArgs []any
+		after, afterArgs, argIdx = channelAfter(filter.Sort, filter.After, argIdx)
+		where = append(where, after)
+		whereClause = "WHERE " + strings.Join(where, " AND ")
+		dataArgs = append(dataArgs, afterArgs...)
+		filter.Offset = 0
+	}
+
+	// Data query with LEFT JOIN on groups for group_name.
+	dataQuery := fmt.Sprintf(
+		`SELECT `+channelColumns+`
+		 FROM channels c
+		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
+		 %s
+		 ORDER BY %s
+		 LIMIT $%d OFFSET $%d`,
+		whereClause, order, argIdx, argIdx+1,
+	)
+	dataArgs = append(dataArgs, filter.Limit, filter.Offset)
+
+	rows, err := p.db.Query(ctx, dataQuery, dataArgs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("ListChannels query: %w", err)
+	}
+	defer rows.Close()
+
+	var channels []models.Channel
+	for rows.Next() {
+		var ch models.Channel
+		if err := rows.Scan(channelDest(&ch)...); err != nil {
+			return nil, 0, fmt.Errorf("ListChannels scan: %w", err)
+		}
+		channels = append(channels, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("ListChannels rows: %w", err)
+	}
+	return channels, total, nil
+}
+
+// channelFilterClauses builds the WHERE conditions and arguments for filter,
+// numbering placeholders from argIdx. It returns the next free placeholder
+// index. Limit and Offset are not handled here.
+func channelFilterClauses(filter ChannelFilter, argIdx int) (where []string, args []any, next int) {
+	if filter.SourceID != nil {
+		where = append(where, fmt.Sprintf("c.source_id = $%d", argIdx))
+		args = append(args, *filter.SourceID)
+		argIdx++
+	}
+	if filter.ExcludeSourceID != nil {
+		where = append(where, fmt.Sprintf("c.source_id <> $%d", argIdx))
+		args = append(args, *filter.ExcludeSourceID)
 		argIdx++
 	}
 	if filter.GroupID != nil {
@@ -291,6 +1125,28 @@ func (p *Postgres) ListChannels(ctx context.Context, filter ChannelFilter) ([]mo
 		args = append(args, *filter.GroupID)
 		argIdx++
 	}
+	if len(filter.GroupIDs) > 0 {
+		where = append(where, fmt.Sprintf("c.group_id = ANY($%d)", argIdx))
+		args = append(args, filter.GroupIDs)
+		argIdx++
+	}
+	if len(filter.ExcludeGroupIDs) > 0 {
+		where = append(where, fmt.Sprintf("(c.group_id IS NULL OR NOT c.group_id = ANY($%d))", argIdx))
+		args = append(args, filter.ExcludeGroupIDs)
+		argIdx++
+	}
+	if filter.Watched != nil {
+		clause := "EXISTS (SELECT 1 FROM watch_events we WHERE we.channel_id = c.id)"
+		if !*filter.Watched {
+			clause = "NOT " + clause
+		}
+		where = append(where, clause)
+	}
+	if filter.PlaylistID != nil {
+		where = append(where, fmt.Sprintf("EXISTS (SELECT 1 FROM playlist_channels pc WHERE pc.playlist_id = $%d AND pc.channel_id = c.id)", argIdx))
+		args = append(args, *filter.PlaylistID)
+		argIdx++
+	}
 	if filter.MediaType != nil {
 		where = append(where, fmt.Sprintf("c.media_type = $%d", argIdx))
 		args = append(args, *filter.MediaType)
@@ -301,68 +1157,184 @@ func (p *Postgres) ListChannels(ctx context.Context, filter ChannelFilter) ([]mo
 		args = append(args, *filter.Favorite)
 		argIdx++
 	}
+	if filter.TvgID != "" {
+		where = append(where, fmt.Sprintf("c.tvg_id = $%d", argIdx))
+		args = append(args, filter.TvgID)
+		argIdx++
+	}
+	if filter.Quality != "" {
+		where = append(where, fmt.Sprintf("c.quality = $%d", argIdx))
+		args = append(args, filter.Quality)
+		argIdx++
+	}
+	if filter.AddedSince != nil {
+		where = append(where, fmt.Sprintf("c.created_at >= $%d", argIdx))
+		args = append(args, *filter.AddedSince)
+		argIdx++
+	}
+	if !filter.IncludeHidden {
+		where = append(where, "NOT c.hidden",
+			"NOT EXISTS (SELECT 1 FROM groups hg WHERE hg.id = c.group_id AND hg.hidden)")
+	}
+	switch filter.Status {
+	case "":
+	case StatusUnchecked:
+		where = append(where, "c.status IS NULL")
+	default:
+		where = append(where, fmt.Sprintf("c.status = $%d", argIdx))
+		args = append(args, filter.Status)
+		argIdx++
+	}
 	if filter.Search != "" {
-		where = append(where, fmt.Sprintf("c.name ILIKE $%d", argIdx))
+		where = append(where, fmt.Sprintf("(c.name ILIKE $%[1]d OR c.custom_name ILIKE $%[1]d)", argIdx))
 		args = append(args, "%"+filter.Search+"%")
 		argIdx++
 	}
+	return where, args, argIdx
+}
+
+// channelName is the name a channel is shown with: the edited one, if any.
+const channelName = "COALESCE(c.custom_name, c.name)"
+
+// channelOrder returns the ORDER BY list for filter.Sort. c.id breaks ties
+// so that pages do not overlap when names or numbers repeat.
+func channelOrder(filter ChannelFilter) string {
+	switch filter.Sort {
+	case SortNameDesc:
+		return channelName + " DESC, c.id DESC"
+	case SortNumber:
+		return "c.channel_number NULLS LAST, " + channelName + ", c.id"
+	case SortGroup:
+		return "g.name NULLS LAST, " + channelName + ", c.id"
+	case SortCreated:
+		return "c.created_at, c.id"
+	case SortCreatedDesc:
+		return "c.created_at DESC, c.id DESC"
+	case SortFavorite:
+		return "c.favorite DESC, " + channelName + ", c.id"
+	default:
+		return channelName + ", c.id"
+	}
+}
+
+// channelAfter returns the condition selecting the channels that follow cur
+// in channelOrder for sort, numbering placeholders from argIdx. Rows are
+// compared on the whole sort key, so the condition matches the ORDER BY
+// whatever the collation, and NULLs come last as they do there.
+func channelAfter(sort string, cur *ChannelCursor, argIdx int) (cond string, args []any, next int) {
+	arg := func(v any) string {
+		args = append(args, v)
+		argIdx++
+		return fmt.Sprintf("$%d", argIdx-1)
+	}
+	byName := func(op string) string {
+		return fmt.Sprintf("(%s, c.id) %s (%s, %s)", channelName, op, arg(cur.Name), arg(cur.ID))
+	}
+	// nullsLast handles a leading column sorted ascending with NULLs last.
+	nullsLast := func(col string, v any, null bool) string {
+		if null {
+			return fmt.Sprintf("(%s IS NULL AND %s)", col, byName(">"))
+		}
+		p := arg(v)
+		return fmt.Sprintf("(%[1]s > %[2]s OR %[1]s IS NULL OR (%[1]s = %[2]s AND %[3]s))", col, p, byName(">"))
+	}
+
+	switch sort {
+	case SortNameDesc:
+		cond = byName("<")
+	case SortNumber:
+		var n any
+		if cur.Number != nil {
+			n = *cur.Number
+		}
+		cond = nullsLast("c.channel_number", n, cur.Number == nil)
+	case SortGroup:
+		var g any
+		if cur.Group != nil {
+			g = *cur.Group
+		}
+		cond = nullsLast("g.name", g, cur.Group == nil)
+	case SortCreated:
+		cond = fmt.Sprintf("(c.created_at, c.id) > (%s, %s)", arg(cur.Created), arg(cur.ID))
+	case SortCreatedDesc:
+		cond = fmt.Sprintf("(c.created_at, c.id) < (%s, %s)", arg(cur.Created), arg(cur.ID))
+	case SortFavorite:
+		// Favorites come first: false sorts after true.
+		f := arg(cur.Favorite)
+		cond = fmt.Sprintf("(c.favorite < %[1]s OR (c.favorite = %[1]s AND %[2]s))", f, byName(">"))
+	default:
+		cond = byName(">")
+	}
+	return cond, args, argIdx
+}
 
+// StreamChannels calls fn for every channel matching filter (Limit and Offset
+// are ignored), in filter.Sort order, with group name and HTTP headers joined.
+// Rows are streamed from the database rather than collected, so exports of
+// very large sources use constant memory. Iteration stops at fn's first error.
+func (p *Postgres) StreamChannels(ctx context.Context, filter ChannelFilter, fn func(*models.Channel, *models.ChannelHttpHeaders) error) error {
+	where, args, _ := channelFilterClauses(filter, 1)
 	whereClause := ""
 	if len(where) > 0 {
 		whereClause = "WHERE " + strings.Join(where, " AND ")
 	}
 
-	// Count query.
-	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM channels c %s`, whereClause)
-	var total int
-	if err := p.pool.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
-		return nil, 0, fmt.Errorf("ListChannels count: %w", err)
-	}
-
-	// Data query with LEFT JOIN on groups for group_name.
-	dataQuery := fmt.Sprintf(
-		`SELECT c.id, c.name, c.image, c.url, c.media_type, c.source_id, c.group_id, c.favorite, g.name
+	rows, err := p.db.Query(ctx, fmt.Sprintf(
+		`SELECT `+channelColumns+`,
+		        h.id, h.referrer, h.user_agent, h.http_origin, h.ignore_ssl
 		 FROM channels c
 		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
+		 LEFT JOIN channel_http_headers h ON h.channel_id = c.id
 		 %s
-		 ORDER BY c.name
-		 LIMIT $%d OFFSET $%d`,
-		whereClause, argIdx, argIdx+1,
-	)
-	dataArgs := append(args, filter.Limit, filter.Offset)
-
-	rows, err := p.pool.Query(ctx, dataQuery, dataArgs...)
+		 ORDER BY %s`, whereClause, channelOrder(filter)), args...)
 	if err != nil {
-		return nil, 0, fmt.Errorf("ListChannels query: %w", err)
+		return fmt.Errorf("StreamChannels: %w", err)
 	}
 	defer rows.Close()
 
-	var channels []models.Channel
 	for rows.Next() {
 		var ch models.Channel
-		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Image, &ch.URL, &ch.MediaType, &ch.SourceID, &ch.GroupID, &ch.Favorite, &ch.GroupName); err != nil {
-			return nil, 0, fmt.Errorf("ListChannels scan: %w", err)
+		var h models.ChannelHttpHeaders
+		var headerID *int64
+		dest := append(channelDest(&ch), &headerID, &h.Referrer, &h.UserAgent, &h.HTTPOrigin, &h.IgnoreSSL)
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("StreamChannels scan: %w", err)
+		}
+		var hp *models.ChannelHttpHeaders
+		if headerID != nil {
+			h.ID = *headerID
+			h.ChannelID = ch.ID
+			hp = &h
+		}
+		if err := fn(&ch, hp); err != nil {
+			return err
 		}
-		channels = append(channels, ch)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("ListChannels rows: %w", err)
+		return fmt.Errorf("StreamChannels rows: %w", err)
 	}
-	return channels, total, nil
+	return nil
 }
 
-// ListGroups returns groups, optionally filtered by source id, ordered by name.
+// ListGroups returns groups with their channel counts, optionally filtered
+// by source id, ordered by name.
 func (p *Postgres) ListGroups(ctx context.Context, sourceID *int64) ([]models.Group, error) {
 	var rows pgx.Rows
 	var err error
 	if sourceID != nil {
-		rows, err = p.pool.Query(ctx,
-			`SELECT id, name, image, source_id FROM groups WHERE source_id = $1 ORDER BY name`,
+		rows, err = p.db.Query(ctx,
+			`SELECT g.id, g.name, g.image, g.source_id, g.hidden, COUNT(c.id)
+			 FROM groups g LEFT JOIN channels c ON c.group_id = g.id
+			 WHERE g.source_id = $1
+			 GROUP BY g.id ORDER BY g.name`,
 			*sourceID,
 		)
 	} else {
-		rows, err = p.pool.Query(ctx,
-			`SELECT id, name, image, source_id FROM groups ORDER BY name`)
+		rows, err = p.db.Query(ctx,
+			`SELECT g.id, g.name, g.image, g.source_id, g.hidden, COUNT(c.id)
+			 FROM groups g LEFT JOIN channels c ON c.group_id = g.id
+			 GROUP BY g.id ORDER BY g.name`)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("ListGroups: %w", err)
@@ -372,7 +1344,7 @@ func (p *Postgres) ListGroups(ctx context.Context, sourceID *int64) ([]models.Gr
 	var groups []models.Group
 	for rows.Next() {
 		var g models.Group
-		if err := rows.Scan(&g.ID, &g.Name, &g.Image, &g.SourceID); err != nil {
+		if err := rows.Scan(&g.ID, &g.Name, &g.Image, &g.SourceID, &g.Hidden, &g.ChannelCount); err != nil {
 			return nil, fmt.Errorf("ListGroups scan: %w", err)
 		}
 		groups = append(groups, g)
@@ -380,23 +1352,220 @@ func (p *Postgres) ListGroups(ctx context.Context, sourceID *int64) ([]models.Gr
 	return groups, rows.Err()
 }
 
+// GetGroup returns a single group by id with its channel count.
+func (p *Postgres) GetGroup(ctx context.Context, groupID int64) (*models.Group, error) {
+	var g models.Group
+	err := p.db.QueryRow(ctx,
+		`SELECT g.id, g.name, g.image, g.source_id, g.hidden, COUNT(c.id)
+		 FROM groups g LEFT JOIN channels c ON c.group_id = g.id
+		 WHERE g.id = $1
+		 GROUP BY g.id`, groupID,
+	).Scan(&g.ID, &g.Name, &g.Image, &g.SourceID, &g.Hidden, &g.ChannelCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("GetGroup: %w", err)
+	}
+	return &g, nil
+}
+
+// UpdateGroup applies non-nil fields to a group. A name already used by
+// another group of the source gives ErrConflict. GetOrCreateGroup leaves
+// hidden alone, so a hidden group stays hidden across refreshes.
+func (p *Postgres) UpdateGroup(ctx context.Context, groupID int64, fields GroupUpdate) error {
+	setClauses := []string{}
+	args := []any{}
+	idx := 1
+
+	if fields.Name != nil {
+		setClauses = append(setClauses, fmt.Sprintf("name = $%d", idx))
+		args = append(args, *fields.Name)
+		idx++
+	}
+	if fields.Image != nil {
+		setClauses = append(setClauses, fmt.Sprintf("image = NULLIF($%d, '')", idx))
+		args = append(args, *fields.Image)
+		idx++
+	}
+	if fields.Hidden != nil {
+		setClauses = append(setClauses, fmt.Sprintf("hidden = $%d", idx))
+		args = append(args, *fields.Hidden)
+		idx++
+	}
+	if len(setClauses) == 0 {
+		return nil
+	}
+
+	args = append(args, groupID)
+	query := fmt.Sprintf("UPDATE groups SET %s WHERE id = $%d", strings.Join(setClauses, ", "), idx)
+	tag, err := p.db.Exec(ctx, query, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return fmt.Errorf("group %d: name %q: %w", groupID, *fields.Name, ErrConflict)
+		}
+		return fmt.Errorf("UpdateGroup: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteGroup deletes a group and, with deleteChannels, its channels.
+// Otherwise the channels lose their group through ON DELETE SET NULL.
+func (p *Postgres) DeleteGroup(ctx context.Context, groupID int64, deleteChannels bool) (int64, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteGroup begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var deleted int64
+	if deleteChannels {
+		tag, err := tx.Exec(ctx, `DELETE FROM channels WHERE group_id = $1`, groupID)
+		if err != nil {
+			return 0, fmt.Errorf("DeleteGroup channels: %w", err)
+		}
+		deleted = tag.RowsAffected()
+	}
+	tag, err := tx.Exec(ctx, `DELETE FROM groups WHERE id = $1`, groupID)
+	if err != nil {
+		return 0, fmt.Errorf("DeleteGroup: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, fmt.Errorf("group %d: %w", groupID, ErrNotFound)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("DeleteGroup commit: %w", err)
+	}
+	return deleted, nil
+}
+
+// MergeGroups moves the channels of groupIDs into targetID and deletes the
+// emptied groups. The groups are locked first, so a concurrent refresh
+// cannot assign channels to them in between.
+func (p *Postgres) MergeGroups(ctx context.Context, targetID int64, groupIDs []int64) (int64, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("MergeGroups begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	ids := append([]int64{targetID}, groupIDs...)
+	rows, err := tx.Query(ctx, `SELECT id, source_id FROM groups WHERE id = ANY($1) ORDER BY id FOR UPDATE`, ids)
+	if err != nil {
+		return 0, fmt.Errorf("MergeGroups lock: %w", err)
+	}
+	sources := make(map[int64]int64, len(ids))
+	for rows.Next() {
+		var id, sourceID int64
+		if err := rows.Scan(&id, &sourceID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("MergeGroups scan: %w", err)
+		}
+		sources[id] = sourceID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("MergeGroups lock: %w", err)
+	}
+	for _, id := range ids {
+		if _, ok := sources[id]; !ok {
+			return 0, fmt.Errorf("group %d: %w", id, ErrNotFound)
+		}
+		if sources[id] != sources[targetID] {
+			return 0, fmt.Errorf("group %d is in source %d, group %d in source %d: %w",
+				id, sources[id], targetID, sources[targetID], ErrSourceMismatch)
+		}
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE channels SET group_id = $1 WHERE group_id = ANY($2)`, targetID, groupIDs)
+	if err != nil {
+		return 0, fmt.Errorf("MergeGroups move: %w", err)
+	}
+	moved := tag.RowsAffected()
+	if _, err := tx.Exec(ctx, `DELETE FROM groups WHERE id = ANY($1)`, groupIDs); err != nil {
+		return 0, fmt.Errorf("MergeGroups delete: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("MergeGroups commit: %w", err)
+	}
+	return moved, nil
+}
+
+// ListSeries returns the series found among episode channels, optionally
+// filtered by source id, ordered by name.
+func (p *Postgres) ListSeries(ctx context.Context, sourceID *int64) ([]models.Series, error) {
+	query := `SELECT series_name, COUNT(*), COUNT(DISTINCT season), MIN(image)
+		 FROM channels WHERE series_name IS NOT NULL`
+	var args []any
+	if sourceID != nil {
+		query += ` AND source_id = $1`
+		args = append(args, *sourceID)
+	}
+	query += ` GROUP BY series_name ORDER BY series_name`
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ListSeries: %w", err)
+	}
+	defer rows.Close()
+
+	var series []models.Series
+	for rows.Next() {
+		var s models.Series
+		if err := rows.Scan(&s.Name, &s.EpisodeCount, &s.SeasonCount, &s.Image); err != nil {
+			return nil, fmt.Errorf("ListSeries scan: %w", err)
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// ListSeriesEpisodes returns the episodes of the named series ordered by
+// season and episode, optionally filtered by source id.
+func (p *Postgres) ListSeriesEpisodes(ctx context.Context, name string, sourceID *int64) ([]models.Channel, error) {
+	query := `SELECT ` + channelColumns + `
+		 FROM channels c LEFT JOIN groups g ON g.id = c.group_id JOIN sources src ON src.id = c.source_id
+		 WHERE c.series_name = $1`
+	args := []any{name}
+	if sourceID != nil {
+		query += ` AND c.source_id = $2`
+		args = append(args, *sourceID)
+	}
+	query += ` ORDER BY c.season, c.episode, c.name, c.id`
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ListSeriesEpisodes: %w", err)
+	}
+	defer rows.Close()
+
+	var episodes []models.Channel
+	for rows.Next() {
+		var ch models.Channel
+		if err := rows.Scan(channelDest(&ch)...); err != nil {
+			return nil, fmt.Errorf("ListSeriesEpisodes scan: %w", err)
+		}
+		episodes = append(episodes, ch)
+	}
+	return episodes, rows.Err()
+}
+
 // GetSourceByID returns a single source by id.
 func (p *Postgres) GetSourceByID(ctx context.Context, sourceID int64) (*models.Source, error) {
 	var s models.Source
-	var userAgent *string
-	err := p.pool.QueryRow(ctx,
-		`SELECT id, name, source_type, url, use_tvg_id, user_agent, enabled, last_updated, created_at
-		 FROM sources WHERE id = $1`, sourceID,
-	).Scan(&s.ID, &s.Name, &s.SourceType, &s.URL, &s.UseTvgID, &userAgent, &s.Enabled, &s.LastUpdated, &s.CreatedAt)
+	err := p.db.QueryRow(ctx,
+		`SELECT `+sourceColumns+` FROM sources WHERE id = $1`, sourceID,
+	).Scan(sourceDest(&s)...)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
 		}
 		return nil, fmt.Errorf("GetSourceByID: %w", err)
 	}
-	if userAgent != nil {
-		s.UserAgent = *userAgent
-	}
 	return &s, nil
 }
 
@@ -411,80 +1580,750 @@ func (p *Postgres) UpdateSource(ctx context.Context, sourceID int64, fields Sour
 		args = append(args, *fields.Name)
 		idx++
 	}
-	if fields.URL != nil {
-		setClauses = append(setClauses, fmt.Sprintf("url = $%d", idx))
-		args = append(args, *fields.URL)
-		idx++
-	}
 	if fields.UserAgent != nil {
 		setClauses = append(setClauses, fmt.Sprintf("user_agent = $%d", idx))
 		args = append(args, *fields.UserAgent)
 		idx++
 	}
+	if fields.EPGURL != nil {
+		setClauses = append(setClauses, fmt.Sprintf("epg_url = NULLIF($%[1]d, ''), epg_url_manual = ($%[1]d <> '')", idx))
+		args = append(args, *fields.EPGURL)
+		idx++
+	}
 	if fields.Enabled != nil {
 		setClauses = append(setClauses, fmt.Sprintf("enabled = $%d", idx))
 		args = append(args, *fields.Enabled)
 		idx++
 	}
-
+	// Settings that change how the playlist is stored keep the validators
+	// only while unchanged, so the next refresh re-ingests when they change.
+	var sameSettings []string
+	if fields.URL != nil {
+		setClauses = append(setClauses, fmt.Sprintf("url = $%d", idx))
+		sameSettings = append(sameSettings, fmt.Sprintf("url IS NOT DISTINCT FROM $%d", idx))
+		args = append(args, *fields.URL)
+		idx++
+	}
+	if fields.GuessMediaType != nil {
+		setClauses = append(setClauses, fmt.Sprintf("guess_media_type = $%d", idx))
+		sameSettings = append(sameSettings, fmt.Sprintf("guess_media_type = $%d", idx))
+		args = append(args, *fields.GuessMediaType)
+		idx++
+	}
+	if fields.Dedupe != nil {
+		setClauses = append(setClauses, fmt.Sprintf("dedupe = $%d", idx))
+		sameSettings = append(sameSettings, fmt.Sprintf("dedupe = $%d", idx))
+		args = append(args, *fields.Dedupe)
+		idx++
+	}
+	if fields.UseTvgID != nil {
+		setClauses = append(setClauses, fmt.Sprintf("use_tvg_id = $%d", idx))
+		sameSettings = append(sameSettings, fmt.Sprintf("use_tvg_id IS NOT DISTINCT FROM $%d", idx))
+		args = append(args, *fields.UseTvgID)
+		idx++
+	}
+	if len(sameSettings) > 0 {
+		same := strings.Join(sameSettings, " AND ")
+		for _, col := range []string{"etag", "last_modified", "content_hash"} {
+			setClauses = append(setClauses, fmt.Sprintf("%[1]s = CASE WHEN %[2]s THEN %[1]s END", col, same))
+		}
+	}
+	if fields.DeadPolicy != nil {
+		setClauses = append(setClauses, fmt.Sprintf("dead_channel_policy = $%d", idx))
+		args = append(args, *fields.DeadPolicy)
+		idx++
+	}
+	if fields.DeadThreshold != nil {
+		setClauses = append(setClauses, fmt.Sprintf("dead_channel_threshold = $%d", idx))
+		args = append(args, *fields.DeadThreshold)
+		idx++
+	}
+	if fields.StalePolicy != nil {
+		setClauses = append(setClauses, fmt.Sprintf("stale_policy = $%d", idx))
+		args = append(args, *fields.StalePolicy)
+		idx++
+	}
+	if fields.WebhookURL != nil {
+		setClauses = append(setClauses, fmt.Sprintf("webhook_url = NULLIF($%d, '')", idx))
+		args = append(args, *fields.WebhookURL)
+		idx++
+	}
+	if fields.FetchHeaders != nil {
+		setClauses = append(setClauses, fmt.Sprintf("fetch_headers = NULLIF($%d::jsonb, '{}'::jsonb)", idx))
+		args = append(args, fields.FetchHeaders)
+		idx++
+	}
+
+	if len(setClauses) == 0 {
+		return nil // nothing to update
+	}
+
+	query := fmt.Sprintf("UPDATE sources SET %s WHERE id = $%d",
+		strings.Join(setClauses, ", "), idx)
+	args = append(args, sourceID)
+
+	tag, err := p.db.Exec(ctx, query, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return fmt.Errorf("source %d: name %q: %w", sourceID, *fields.Name, ErrConflict)
+		}
+		return fmt.Errorf("UpdateSource: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+	}
+	return nil
+}
+
+// CreateCustomSource inserts a custom source. Unlike CreateOrGetSource it
+// never reuses a source with the same name, which could be a playlist's.
+func (p *Postgres) CreateCustomSource(ctx context.Context, name string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(ctx,
+		`INSERT INTO sources (name, source_type, enabled) VALUES ($1, $2, true)
+		 ON CONFLICT (name) DO NOTHING
+		 RETURNING id`,
+		name, models.SourceTypeCustom,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("source %q: %w", name, ErrConflict)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("CreateCustomSource: %w", err)
+	}
+	return id, nil
+}
+
+// AddChannel inserts a single channel and its headers.
+func (p *Postgres) AddChannel(ctx context.Context, ch *models.Channel, h *models.ChannelHttpHeaders) (int64, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("AddChannel begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO channels (name, image, url, media_type, source_id, group_id, favorite)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (name, source_id, url) DO NOTHING
+		 RETURNING id`,
+		ch.Name, ch.Image, ch.URL, ch.MediaType, ch.SourceID, ch.GroupID, ch.Favorite,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("channel %q: %w", ch.Name, ErrConflict)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("AddChannel: %w", err)
+	}
+	if h != nil {
+		txp := &Postgres{pool: p.pool, db: tx, trgm: p.trgm, dims: p.dims}
+		if err := txp.UpsertChannelHeaders(ctx, id, h); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("AddChannel commit: %w", err)
+	}
+	return id, nil
+}
+
+// DeleteChannel deletes a channel by id. Its headers and properties are
+// removed via ON DELETE CASCADE.
+func (p *Postgres) DeleteChannel(ctx context.Context, channelID int64) error {
+	tag, err := p.db.Exec(ctx, "DELETE FROM channels WHERE id = $1", channelID)
+	if err != nil {
+		return fmt.Errorf("DeleteChannel: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	return nil
+}
+
+// DeleteSource deletes a source by id. Related channels and groups are removed via ON DELETE CASCADE.
+func (p *Postgres) DeleteSource(ctx context.Context, sourceID int64) error {
+	tag, err := p.db.Exec(ctx, "DELETE FROM sources WHERE id = $1", sourceID)
+	if err != nil {
+		return fmt.Errorf("DeleteSource: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+	}
+	return nil
+}
+
+// playlistColumns is the select list for reading a playlist with its channel
+// count from playlists p LEFT JOIN playlist_channels pc, grouped by p.id.
+const playlistColumns = `p.id, p.name, COALESCE(p.description, ''), p.created_at, p.updated_at, COUNT(pc.channel_id)`
+
+// ListPlaylists returns all playlists by name with their channel counts.
+func (p *Postgres) ListPlaylists(ctx context.Context) ([]models.Playlist, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT `+playlistColumns+`
+		 FROM playlists p LEFT JOIN playlist_channels pc ON pc.playlist_id = p.id
+		 GROUP BY p.id ORDER BY p.name`)
+	if err != nil {
+		return nil, fmt.Errorf("ListPlaylists: %w", err)
+	}
+	defer rows.Close()
+
+	var playlists []models.Playlist
+	for rows.Next() {
+		var pl models.Playlist
+		if err := rows.Scan(&pl.ID, &pl.Name, &pl.Description, &pl.CreatedAt, &pl.UpdatedAt, &pl.ChannelCount); err != nil {
+			return nil, fmt.Errorf("ListPlaylists scan: %w", err)
+		}
+		playlists = append(playlists, pl)
+	}
+	return playlists, rows.Err()
+}
+
+// GetPlaylist returns a single playlist by id with its channel count.
+func (p *Postgres) GetPlaylist(ctx context.Context, playlistID int64) (*models.Playlist, error) {
+	var pl models.Playlist
+	err := p.db.QueryRow(ctx,
+		`SELECT `+playlistColumns+`
+		 FROM playlists p LEFT JOIN playlist_channels pc ON pc.playlist_id = p.id
+		 WHERE p.id = $1
+		 GROUP BY p.id`, playlistID,
+	).Scan(&pl.ID, &pl.Name, &pl.Description, &pl.CreatedAt, &pl.UpdatedAt, &pl.ChannelCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+		}
+		return nil, fmt.Errorf("GetPlaylist: %w", err)
+	}
+	return &pl, nil
+}
+
+// CreatePlaylist inserts an empty playlist.
+func (p *Postgres) CreatePlaylist(ctx context.Context, name, description string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(ctx,
+		`INSERT INTO playlists (name, description) VALUES ($1, NULLIF($2, ''))
+		 ON CONFLICT (name) DO NOTHING
+		 RETURNING id`,
+		name, description,
+	).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("playlist %q: %w", name, ErrConflict)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("CreatePlaylist: %w", err)
+	}
+	return id, nil
+}
+
+// UpdatePlaylist applies non-nil fields to a playlist.
+func (p *Postgres) UpdatePlaylist(ctx context.Context, playlistID int64, fields PlaylistUpdate) error {
+	setClauses := []string{"updated_at = NOW()"}
+	args := []any{}
+	idx := 1
+
+	if fields.Name != nil {
+		setClauses = append(setClauses, fmt.Sprintf("name = $%d", idx))
+		args = append(args, *fields.Name)
+		idx++
+	}
+	if fields.Description != nil {
+		setClauses = append(setClauses, fmt.Sprintf("description = NULLIF($%d, '')", idx))
+		args = append(args, *fields.Description)
+		idx++
+	}
+
+	args = append(args, playlistID)
+	query := fmt.Sprintf("UPDATE playlists SET %s WHERE id = $%d", strings.Join(setClauses, ", "), idx)
+	tag, err := p.db.Exec(ctx, query, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" { // unique_violation
+			return fmt.Errorf("playlist %d: name %q: %w", playlistID, *fields.Name, ErrConflict)
+		}
+		return fmt.Errorf("UpdatePlaylist: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	return nil
+}
+
+// DeletePlaylist deletes a playlist; its memberships go with it via ON
+// DELETE CASCADE.
+func (p *Postgres) DeletePlaylist(ctx context.Context, playlistID int64) error {
+	tag, err := p.db.Exec(ctx, "DELETE FROM playlists WHERE id = $1", playlistID)
+	if err != nil {
+		return fmt.Errorf("DeletePlaylist: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+	}
+	return nil
+}
+
+// AddPlaylistChannel adds a channel to a playlist. A missing playlist or
+// channel shows up as a foreign key violation.
+func (p *Postgres) AddPlaylistChannel(ctx context.Context, playlistID, channelID int64) (bool, error) {
+	tag, err := p.db.Exec(ctx,
+		`INSERT INTO playlist_channels (playlist_id, channel_id) VALUES ($1, $2)
+		 ON CONFLICT DO NOTHING`,
+		playlistID, channelID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation
+			if pgErr.ConstraintName == "playlist_channels_channel_id_fkey" {
+				return false, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+			}
+			return false, fmt.Errorf("playlist %d: %w", playlistID, ErrNotFound)
+		}
+		return false, fmt.Errorf("AddPlaylistChannel: %w", err)
+	}
+	added := tag.RowsAffected() > 0
+	if added {
+		if _, err := p.db.Exec(ctx, "UPDATE playlists SET updated_at = NOW() WHERE id = $1", playlistID); err != nil {
+			return false, fmt.Errorf("AddPlaylistChannel: %w", err)
+		}
+	}
+	return added, nil
+}
+
+// RemovePlaylistChannel removes a channel from a playlist.
+func (p *Postgres) RemovePlaylistChannel(ctx context.Context, playlistID, channelID int64) error {
+	tag, err := p.db.Exec(ctx,
+		"DELETE FROM playlist_channels WHERE playlist_id = $1 AND channel_id = $2", playlistID, channelID)
+	if err != nil {
+		return fmt.Errorf("RemovePlaylistChannel: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("channel %d in playlist %d: %w", channelID, playlistID, ErrNotFound)
+	}
+	if _, err := p.db.Exec(ctx, "UPDATE playlists SET updated_at = NOW() WHERE id = $1", playlistID); err != nil {
+		return fmt.Errorf("RemovePlaylistChannel: %w", err)
+	}
+	return nil
+}
+
+// watchColumns is the select list for reading a watch event w. Scan it
+// with watchDest.
+const watchColumns = `w.id, w.channel_id, w.started_at, w.updated_at, w.position, w.duration, w.finished`
+
+// watchDest returns the scan destinations matching watchColumns.
+func watchDest(e *models.WatchEvent) []any {
+	return []any{&e.ID, &e.ChannelID, &e.StartedAt, &e.UpdatedAt, &e.Position, &e.Duration, &e.Finished}
+}
+
+// RecordWatch updates the channel's latest watch event, or inserts one when
+// there is none since sessionStart. A duration left out keeps the one
+// already known.
+func (p *Postgres) RecordWatch(ctx context.Context, channelID int64, position int, duration *int, sessionStart time.Time) (*models.WatchEvent, bool, error) {
+	var e models.WatchEvent
+	err := p.db.QueryRow(ctx,
+		`UPDATE watch_events w SET position = $2, duration = COALESCE($3, w.duration), updated_at = NOW(),
+		   finished = COALESCE(COALESCE($3, w.duration) > 0 AND $2 >= COALESCE($3, w.duration) * 0.95, false)
+		 WHERE w.id = (SELECT id FROM watch_events
+		               WHERE channel_id = $1 AND updated_at > $4
+		               ORDER BY updated_at DESC LIMIT 1)
+		 RETURNING `+watchColumns,
+		channelID, position, duration, sessionStart,
+	).Scan(watchDest(&e)...)
+	if err == nil {
+		return &e, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("RecordWatch: %w", err)
+	}
+
+	err = p.db.QueryRow(ctx,
+		`INSERT INTO watch_events AS w (channel_id, position, duration, finished)
+		 VALUES ($1, $2, $3, COALESCE($3 > 0 AND $2 >= $3 * 0.95, false))
+		 RETURNING `+watchColumns,
+		channelID, position, duration,
+	).Scan(watchDest(&e)...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23503" { // foreign_key_violation
+			return nil, false, fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+		}
+		return nil, false, fmt.Errorf("RecordWatch insert: %w", err)
+	}
+	return &e, true, nil
+}
+
+// GetWatchPosition returns the channel's most recently updated watch event.
+func (p *Postgres) GetWatchPosition(ctx context.Context, channelID int64) (*models.WatchEvent, error) {
+	var e models.WatchEvent
+	err := p.db.QueryRow(ctx,
+		`SELECT `+watchColumns+` FROM watch_events w
+		 WHERE w.channel_id = $1
+		 ORDER BY w.updated_at DESC LIMIT 1`, channelID,
+	).Scan(watchDest(&e)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetWatchPosition: %w", err)
+	}
+	return &e, nil
+}
+
+// ListWatchHistory returns the latest watch event of each channel with the
+// channel joined, most recently updated first.
+func (p *Postgres) ListWatchHistory(ctx context.Context, limit int, inProgress bool) ([]models.WatchHistoryEntry, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT `+channelColumns+`, `+watchColumns+`
+		 FROM (SELECT DISTINCT ON (channel_id) * FROM watch_events
+		       ORDER BY channel_id, updated_at DESC) w
+		 JOIN channels c ON c.id = w.channel_id
+		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
+		 WHERE NOT $2 OR (NOT w.finished AND w.position > 0)
+		 ORDER BY w.updated_at DESC, w.id DESC
+		 LIMIT $1`, limit, inProgress)
+	if err != nil {
+		return nil, fmt.Errorf("ListWatchHistory: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.WatchHistoryEntry
+	for rows.Next() {
+		var e models.WatchHistoryEntry
+		if err := rows.Scan(append(channelDest(&e.Channel), watchDest(&e.Watch)...)...); err != nil {
+			return nil, fmt.Errorf("ListWatchHistory scan: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PruneWatchEvents deletes the watch events not updated since cutoff.
+func (p *Postgres) PruneWatchEvents(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := p.db.Exec(ctx, "DELETE FROM watch_events WHERE updated_at < $1", cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("PruneWatchEvents: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// refreshRunsKept is how many refresh runs RecordRefreshRun keeps per source.
+const refreshRunsKept = 100
+
+const refreshRunColumns = `id, source_id, started_at, finished_at, channel_count, unchanged, added, removed, updated, removed_groups`
+
+// RecordRefreshRun inserts a refresh run and deletes the source's runs older
+// than the latest refreshRunsKept.
+func (p *Postgres) RecordRefreshRun(ctx context.Context, run *models.RefreshRun) error {
+	err := p.db.QueryRow(ctx,
+		`INSERT INTO refresh_runs (source_id, started_at, finished_at, channel_count, unchanged, added, removed, updated, removed_groups)
+		 VALUES ($1, $2, $3, $4, $5, COALESCE($6, '{}'::bigint[]), COALESCE($7, '[]'::jsonb), COALESCE($8, '{}'::bigint[]), COALESCE($9, '[]'::jsonb))
+		 RETURNING id`,
+		run.SourceID, run.StartedAt, run.FinishedAt, run.ChannelCount, run.Unchanged, run.Added, run.Removed, run.Updated, run.RemovedGroups,
+	).Scan(&run.ID)
+	if err != nil {
+		return fmt.Errorf("RecordRefreshRun: %w", err)
+	}
+
+	_, err = p.db.Exec(ctx,
+		`DELETE FROM refresh_runs
+		 WHERE source_id = $1 AND id NOT IN (
+		   SELECT id FROM refresh_runs WHERE source_id = $1 ORDER BY started_at DESC, id DESC LIMIT $2)`,
+		run.SourceID, refreshRunsKept)
+	if err != nil {
+		return fmt.Errorf("RecordRefreshRun prune: %w", err)
+	}
+	return nil
+}
+
+// ListRefreshRuns returns the latest refresh runs of a source, most recent
+// first.
+func (p *Postgres) ListRefreshRuns(ctx context.Context, sourceID int64, limit int) ([]models.RefreshRun, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT `+refreshRunColumns+` FROM refresh_runs
+		 WHERE source_id = $1
+		 ORDER BY started_at DESC, id DESC
+		 LIMIT $2`, sourceID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ListRefreshRuns: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []models.RefreshRun
+	for rows.Next() {
+		var run models.RefreshRun
+		if err := rows.Scan(&run.ID, &run.SourceID, &run.StartedAt, &run.FinishedAt, &run.ChannelCount, &run.Unchanged,
+			&run.Added, &run.Removed, &run.Updated, &run.RemovedGroups); err != nil {
+			return nil, fmt.Errorf("ListRefreshRuns scan: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// ToggleChannelFavorite sets the favorite flag on a channel.
+func (p *Postgres) ToggleChannelFavorite(ctx context.Context, channelID int64, favorite bool) error {
+	tag, err := p.db.Exec(ctx, "UPDATE channels SET favorite = $1 WHERE id = $2", favorite, channelID)
+	if err != nil {
+		return fmt.Errorf("ToggleChannelFavorite: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	return nil
+}
+
+// SetChannelHidden hides or restores a channel. Restoring also resets its
+// failed check count so the dead channel policy does not hide it again on
+// the next check.
+func (p *Postgres) SetChannelHidden(ctx context.Context, channelID int64, hidden bool) error {
+	tag, err := p.db.Exec(ctx,
+		`UPDATE channels SET hidden = $1, fail_count = CASE WHEN $1 THEN fail_count ELSE 0 END WHERE id = $2`,
+		hidden, channelID)
+	if err != nil {
+		return fmt.Errorf("SetChannelHidden: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	}
+	return nil
+}
+
+// UpdateChannel applies fields to a channel. An edited name or URL is kept
+// in custom_name and custom_url, next to the playlist's, and only counts as
+// edited while it differs from it; the other fields are overwritten in
+// place and protected from refreshes by their edited_fields bit.
+func (p *Postgres) UpdateChannel(ctx context.Context, channelID int64, fields ChannelUpdate) error {
+	setClauses := []string{}
+	args := []any{}
+	idx := 1
+	// Bits to unset, then to set, in edited_fields; a name or URL matching
+	// the playlist's sets its bit only through the CASE terms in bitTerms.
+	unset := fields.Reset
+	var set models.EditedFields
+	var bitTerms []string
+
+	if fields.Name != nil {
+		setClauses = append(setClauses, fmt.Sprintf("custom_name = NULLIF($%d, name)", idx))
+		bitTerms = append(bitTerms, fmt.Sprintf("CASE WHEN $%d <> name THEN %d ELSE 0 END", idx, models.EditedName))
+		args = append(args, *fields.Name)
+		unset |= models.EditedName
+		idx++
+	} else if fields.Reset&models.EditedName != 0 {
+		setClauses = append(setClauses, "custom_name = NULL")
+	}
+	if fields.URL != nil {
+		setClauses = append(setClauses, fmt.Sprintf("custom_url = NULLIF($%d, url)", idx))
+		bitTerms = append(bitTerms, fmt.Sprintf("CASE WHEN $%d <> url THEN %d ELSE 0 END", idx, models.EditedURL))
+		args = append(args, *fields.URL)
+		unset |= models.EditedURL
+		idx++
+	} else if fields.Reset&models.EditedURL != 0 {
+		setClauses = append(setClauses, "custom_url = NULL")
+	}
+	if fields.Image != nil {
+		setClauses = append(setClauses, fmt.Sprintf("image = NULLIF($%d, '')", idx))
+		args = append(args, *fields.Image)
+		set |= models.EditedImage
+		idx++
+	}
+	if fields.GroupID != nil {
+		setClauses = append(setClauses, fmt.Sprintf("group_id = NULLIF($%d, 0)", idx))
+		args = append(args, *fields.GroupID)
+		set |= models.EditedGroup
+		idx++
+	}
+	if fields.MediaType != nil {
+		setClauses = append(setClauses, fmt.Sprintf("media_type = $%d", idx))
+		args = append(args, *fields.MediaType)
+		set |= models.EditedMediaType
+		idx++
+	}
+	if fields.Favorite != nil {
+		setClauses = append(setClauses, fmt.Sprintf("favorite = $%d", idx))
+		args = append(args, *fields.Favorite)
+		idx++
+	}
+	if fields.Hidden != nil {
+		setClauses = append(setClauses, fmt.Sprintf("hidden = $%[1]d, fail_count = CASE WHEN $%[1]d THEN fail_count ELSE 0 END", idx))
+		args = append(args, *fields.Hidden)
+		idx++
+	}
+	if unset != 0 || set != 0 {
+		edited := fmt.Sprintf("(edited_fields & ~%d) | %d", unset, set&^fields.Reset)
+		for _, t := range bitTerms {
+			edited += " | " + t
+		}
+		setClauses = append(setClauses, "edited_fields = "+edited)
+	}
 	if len(setClauses) == 0 {
-		return nil // nothing to update
+		return nil
 	}
 
-	query := fmt.Sprintf("UPDATE sources SET %s WHERE id = $%d",
-		strings.Join(setClauses, ", "), idx)
-	args = append(args, sourceID)
-
-	tag, err := p.pool.Exec(ctx, query, args...)
+	args = append(args, channelID)
+	query := fmt.Sprintf("UPDATE channels SET %s WHERE id = $%d", strings.Join(setClauses, ", "), idx)
+	tag, err := p.db.Exec(ctx, query, args...)
 	if err != nil {
-		return fmt.Errorf("UpdateSource: %w", err)
+		return fmt.Errorf("UpdateChannel: %w", err)
 	}
 	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
 	}
 	return nil
 }
 
-// DeleteSource deletes a source by id. Related channels and groups are removed via ON DELETE CASCADE.
-func (p *Postgres) DeleteSource(ctx context.Context, sourceID int64) error {
-	tag, err := p.pool.Exec(ctx, "DELETE FROM sources WHERE id = $1", sourceID)
-	if err != nil {
-		return fmt.Errorf("DeleteSource: %w", err)
+// BulkUpdateChannels applies fields to the selected channels in a single
+// UPDATE. Channels that already have the requested values are not counted.
+func (p *Postgres) BulkUpdateChannels(ctx context.Context, ids []int64, filter ChannelFilter, fields ChannelFlags) (int64, error) {
+	var (
+		where  []string
+		args   []any
+		argIdx = 1
+	)
+	if ids != nil {
+		where = append(where, "c.id = ANY($1)")
+		args = append(args, ids)
+		argIdx++
+	} else {
+		where, args, argIdx = channelFilterClauses(filter, 1)
 	}
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("source %d: %w", sourceID, ErrNotFound)
+
+	var setClauses, changed []string
+	if fields.Favorite != nil {
+		setClauses = append(setClauses, fmt.Sprintf("favorite = $%d", argIdx))
+		changed = append(changed, fmt.Sprintf("c.favorite IS DISTINCT FROM $%d", argIdx))
+		args = append(args, *fields.Favorite)
+		argIdx++
 	}
-	return nil
+	if fields.Hidden != nil {
+		setClauses = append(setClauses, fmt.Sprintf("hidden = $%[1]d, fail_count = CASE WHEN $%[1]d THEN c.fail_count ELSE 0 END", argIdx))
+		changed = append(changed, fmt.Sprintf("c.hidden IS DISTINCT FROM $%d", argIdx))
+		args = append(args, *fields.Hidden)
+		argIdx++
+	}
+	if len(setClauses) == 0 {
+		return 0, nil
+	}
+	where = append(where, "("+strings.Join(changed, " OR ")+")")
+
+	query := fmt.Sprintf("UPDATE channels c SET %s WHERE %s", strings.Join(setClauses, ", "), strings.Join(where, " AND "))
+	tag, err := p.db.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("BulkUpdateChannels: %w", err)
+	}
+	return tag.RowsAffected(), nil
 }
 
-// ToggleChannelFavorite sets the favorite flag on a channel.
-func (p *Postgres) ToggleChannelFavorite(ctx context.Context, channelID int64, favorite bool) error {
-	tag, err := p.pool.Exec(ctx, "UPDATE channels SET favorite = $1 WHERE id = $2", favorite, channelID)
+// PruneFailingChannels applies a dead channel policy to the source's channels
+// with at least threshold consecutive failed checks. With remove set they
+// are deleted, except favorites; all remaining ones are hidden. Returns the
+// number of channels hidden and deleted.
+func (p *Postgres) PruneFailingChannels(ctx context.Context, sourceID int64, threshold int, remove bool) (hidden, deleted int64, err error) {
+	tx, err := p.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("ToggleChannelFavorite: %w", err)
+		return 0, 0, fmt.Errorf("PruneFailingChannels begin: %w", err)
 	}
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("channel %d: %w", channelID, ErrNotFound)
+	defer tx.Rollback(ctx)
+
+	if remove {
+		tag, err := tx.Exec(ctx,
+			`DELETE FROM channels WHERE source_id = $1 AND fail_count >= $2 AND NOT favorite`,
+			sourceID, threshold)
+		if err != nil {
+			return 0, 0, fmt.Errorf("PruneFailingChannels delete: %w", err)
+		}
+		deleted = tag.RowsAffected()
 	}
-	return nil
+	tag, err := tx.Exec(ctx,
+		`UPDATE channels SET hidden = true WHERE source_id = $1 AND fail_count >= $2 AND NOT hidden`,
+		sourceID, threshold)
+	if err != nil {
+		return 0, 0, fmt.Errorf("PruneFailingChannels hide: %w", err)
+	}
+	hidden = tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, fmt.Errorf("PruneFailingChannels commit: %w", err)
+	}
+	return hidden, deleted, nil
 }
 
 // CountChannelsBySource returns the total number of channels for a source.
 func (p *Postgres) CountChannelsBySource(ctx context.Context, sourceID int64) (int64, error) {
 	var count int64
-	err := p.pool.QueryRow(ctx, `SELECT COUNT(*) FROM channels WHERE source_id = $1`, sourceID).Scan(&count)
+	err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM channels WHERE source_id = $1`, sourceID).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("CountChannelsBySource: %w", err)
 	}
 	return count, nil
 }
 
-// StoreEmbeddings batch-updates the embedding column for the given channel IDs.
+// CountChannels returns the number of channels matching the filter.
+func (p *Postgres) CountChannels(ctx context.Context, filter ChannelFilter) (int, error) {
+	where, args, _ := channelFilterClauses(filter, 1)
+	query := `SELECT COUNT(*) FROM channels c`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	var count int
+	if err := p.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("CountChannels: %w", err)
+	}
+	return count, nil
+}
+
+// Stats returns the totals over every source, reading channels once.
+func (p *Postgres) Stats(ctx context.Context) (models.Stats, error) {
+	stats := models.NewStats()
+	var mediaTypes []int16
+	var counts []int
+	err := p.db.QueryRow(ctx,
+		`SELECT (SELECT COUNT(*) FROM sources), (SELECT COUNT(*) FROM groups),
+		   COALESCE(SUM(n), 0), COALESCE(SUM(favorites), 0), COALESCE(SUM(embedded), 0),
+		   COALESCE(array_agg(media_type), '{}'), COALESCE(array_agg(n), '{}')
+		 FROM (
+		   SELECT media_type, COUNT(*) AS n,
+		     COUNT(*) FILTER (WHERE favorite) AS favorites,
+		     COUNT(*) FILTER (WHERE embedding IS NOT NULL) AS embedded
+		   FROM channels GROUP BY media_type) t`,
+	).Scan(&stats.Sources, &stats.Groups, &stats.Channels, &stats.Favorites, &stats.Embedded, &mediaTypes, &counts)
+	if err != nil {
+		return stats, fmt.Errorf("Stats: %w", err)
+	}
+	for i, mt := range mediaTypes {
+		if name := models.MediaTypeName(mt); name != "" {
+			stats.ByMediaType[name] = counts[i]
+		}
+	}
+	return stats, nil
+}
+
+// CountFavorites returns the number of favorite channels, optionally of one
+// source, leaving out hidden ones unless includeHidden is set. It reads
+// idx_channels_favorite.
+func (p *Postgres) CountFavorites(ctx context.Context, sourceID *int64, includeHidden bool) (int, error) {
+	var count int
+	err := p.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM channels c
+		 WHERE c.favorite AND ($1::bigint IS NULL OR c.source_id = $1)
+		   AND ($2 OR (NOT c.hidden
+		     AND NOT EXISTS (SELECT 1 FROM groups hg WHERE hg.id = c.group_id AND hg.hidden)))`,
+		sourceID, includeHidden).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("CountFavorites: %w", err)
+	}
+	return count, nil
+}
+
+// StoreEmbeddings batch-updates the embedding column for the given channel IDs,
+// recording model as the model they were generated with and textHashes as
+// the hashes of the texts they were generated from.
 // Sends updates in chunks of 5,000 to avoid overwhelming PostgreSQL.
-func (p *Postgres) StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32) error {
+func (p *Postgres) StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32, model string, textHashes []string) error {
 	if len(channelIDs) != len(embeddings) {
 		return fmt.Errorf("StoreEmbeddings: channelIDs length (%d) != embeddings length (%d)", len(channelIDs), len(embeddings))
 	}
+	if len(channelIDs) != len(textHashes) {
+		return fmt.Errorf("StoreEmbeddings: channelIDs length (%d) != textHashes length (%d)", len(channelIDs), len(textHashes))
+	}
 
 	const chunkSize = 5000
 	total := len(channelIDs)
@@ -498,10 +2337,11 @@ func (p *Postgres) StoreEmbeddings(ctx context.Context, channelIDs []int64, embe
 		batch := &pgx.Batch{}
 		for i := start; i < end; i++ {
 			vec := pgvector.NewVector(embeddings[i])
-			batch.Queue("UPDATE channels SET embedding = $1 WHERE id = $2", vec, channelIDs[i])
+			batch.Queue("UPDATE channels SET embedding = $1, embedding_model = $2, embedding_text_hash = $3 WHERE id = $4",
+				vec, model, textHashes[i], channelIDs[i])
 		}
 
-		br := p.pool.SendBatch(ctx, batch)
+		br := p.db.SendBatch(ctx, batch)
 		for i := start; i < end; i++ {
 			if _, err := br.Exec(); err != nil {
 				br.Close()
@@ -513,88 +2353,452 @@ func (p *Postgres) StoreEmbeddings(ctx context.Context, channelIDs []int64, embe
 	return nil
 }
 
-// SemanticSearch returns channels ordered by cosine similarity to queryVec.
-func (p *Postgres) SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, error) {
+// UpdateChannelStatuses records the outcome of stream checks. A failed check
+// increments the channel's fail_count; a successful one resets it.
+func (p *Postgres) UpdateChannelStatuses(ctx context.Context, checks []ChannelCheck) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(checks))
+	statuses := make([]string, len(checks))
+	codes := make([]*int32, len(checks))
+	checked := make([]time.Time, len(checks))
+	for i, c := range checks {
+		ids[i] = c.ChannelID
+		statuses[i] = c.Status
+		if c.StatusCode != 0 {
+			code := int32(c.StatusCode)
+			codes[i] = &code
+		}
+		checked[i] = c.CheckedAt
+	}
+	_, err := p.db.Exec(ctx,
+		`UPDATE channels c
+		 SET status = u.status, status_code = u.code, last_checked = u.checked,
+		     fail_count = CASE WHEN u.status = 'ok' THEN 0 ELSE c.fail_count + 1 END
+		 FROM unnest($1::bigint[], $2::text[], $3::int[], $4::timestamptz[]) AS u(id, status, code, checked)
+		 WHERE c.id = u.id`,
+		ids, statuses, codes, checked)
+	if err != nil {
+		return fmt.Errorf("UpdateChannelStatuses: %w", err)
+	}
+	return nil
+}
+
+// GetChannelEmbedding returns the stored embedding of a channel, or nil if
+// it has none yet.
+func (p *Postgres) GetChannelEmbedding(ctx context.Context, channelID int64) ([]float32, error) {
+	var vec *pgvector.Vector
+	err := p.db.QueryRow(ctx, `SELECT embedding FROM channels WHERE id = $1`, channelID).Scan(&vec)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetChannelEmbedding: %w", err)
+	}
+	if vec == nil {
+		return nil, nil
+	}
+	return vec.Slice(), nil
+}
+
+// GetChannelEmbeddings returns the stored embeddings of the given channels
+// by channel id. Channels without an embedding are left out.
+func (p *Postgres) GetChannelEmbeddings(ctx context.Context, channelIDs []int64) (map[int64][]float32, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT id, embedding FROM channels WHERE id = ANY($1) AND embedding IS NOT NULL`,
+		channelIDs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("GetChannelEmbeddings: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[int64][]float32)
+	for rows.Next() {
+		var id int64
+		var vec pgvector.Vector
+		if err := rows.Scan(&id, &vec); err != nil {
+			return nil, fmt.Errorf("GetChannelEmbeddings scan: %w", err)
+		}
+		out[id] = vec.Slice()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("GetChannelEmbeddings rows: %w", err)
+	}
+	return out, nil
+}
+
+// EmbeddingTextHashes returns the text hashes recorded by StoreEmbeddings
+// for the given channels by channel id. Channels without an embedding from
+// model, or without a recorded hash, are left out.
+func (p *Postgres) EmbeddingTextHashes(ctx context.Context, channelIDs []int64, model string) (map[int64]string, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT id, embedding_text_hash FROM channels
+		 WHERE id = ANY($1) AND embedding IS NOT NULL AND embedding_model = $2 AND embedding_text_hash IS NOT NULL`,
+		channelIDs, model,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("EmbeddingTextHashes: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, fmt.Errorf("EmbeddingTextHashes scan: %w", err)
+		}
+		out[id] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("EmbeddingTextHashes rows: %w", err)
+	}
+	return out, nil
+}
+
+// checkQueryVec rejects query vectors that cannot be compared with the
+// stored embeddings, which pgvector would fail on with an operator error.
+func (p *Postgres) checkQueryVec(queryVec []float32) error {
+	if len(queryVec) != p.dims {
+		return fmt.Errorf("%w: query vector has %d dimensions, stored embeddings have %d", ErrDimensionMismatch, len(queryVec), p.dims)
+	}
+	return nil
+}
+
+// EmbeddingStats counts a source's channels by the state of their embedding
+// relative to model.
+func (p *Postgres) EmbeddingStats(ctx context.Context, sourceID int64, model string) (EmbeddingStats, error) {
+	st := EmbeddingStats{Model: model}
+	err := p.db.QueryRow(ctx,
+		`SELECT COUNT(*) FILTER (WHERE embedding IS NOT NULL AND embedding_model = $2),
+		        COUNT(*) FILTER (WHERE embedding IS NOT NULL AND embedding_model IS DISTINCT FROM $2),
+		        COUNT(*) FILTER (WHERE embedding IS NULL)
+		 FROM channels WHERE source_id = $1`,
+		sourceID, model,
+	).Scan(&st.Current, &st.Stale, &st.Missing)
+	if err != nil {
+		return st, fmt.Errorf("EmbeddingStats: %w", err)
+	}
+	return st, nil
+}
+
+// searchDB returns the connection a vector search runs on. With
+// filter.SearchAccuracy set it begins a transaction and sets hnsw.ef_search
+// for it only; release ends the transaction and must be called when done.
+func (p *Postgres) searchDB(ctx context.Context, filter ChannelFilter) (db dbtx, release func(), err error) {
+	if filter.SearchAccuracy <= 0 {
+		return p.db, func() {}, nil
+	}
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	release = func() { tx.Rollback(ctx) } // read-only, nothing to commit
+	if _, err := tx.Exec(ctx, `SELECT set_config('hnsw.ef_search', $1, true)`, strconv.Itoa(filter.SearchAccuracy)); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return tx, release, nil
+}
+
+// SemanticSearch returns channels ordered by cosine similarity to queryVec,
+// and the number of matches before Limit and Offset. Channels less similar
+// than filter.MinSimilarity are left out.
+func (p *Postgres) SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, int, error) {
+	if err := p.checkQueryVec(queryVec); err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch: %w", err)
+	}
 	if filter.Limit <= 0 {
 		filter.Limit = 50
 	}
 	if filter.Limit > 200 {
 		filter.Limit = 200
 	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
 
 	vec := pgvector.NewVector(queryVec)
 
-	where := []string{"c.embedding IS NOT NULL"}
-	args := []any{vec}
-	argIdx := 2 // $1 is the query vector
-
-	if filter.SourceID != nil {
-		where = append(where, fmt.Sprintf("c.source_id = $%d", argIdx))
-		args = append(args, *filter.SourceID)
-		argIdx++
-	}
-	if filter.GroupID != nil {
-		where = append(where, fmt.Sprintf("c.group_id = $%d", argIdx))
-		args = append(args, *filter.GroupID)
-		argIdx++
-	}
-	if filter.MediaType != nil {
-		where = append(where, fmt.Sprintf("c.media_type = $%d", argIdx))
-		args = append(args, *filter.MediaType)
+	// $1 is the query vector.
+	where, args, argIdx := channelFilterClauses(filter, 2)
+	where = append([]string{"c.embedding IS NOT NULL"}, where...)
+	args = append([]any{vec}, args...)
+	if filter.MinSimilarity != 0 {
+		where = append(where, fmt.Sprintf("1 - (c.embedding <=> $1) >= $%d", argIdx))
+		args = append(args, filter.MinSimilarity)
 		argIdx++
 	}
-	if filter.Favorite != nil {
-		where = append(where, fmt.Sprintf("c.favorite = $%d", argIdx))
-		args = append(args, *filter.Favorite)
+	if filter.EmbeddingModel != "" {
+		where = append(where, fmt.Sprintf("c.embedding_model = $%d", argIdx))
+		args = append(args, filter.EmbeddingModel)
 		argIdx++
 	}
 
 	whereClause := "WHERE " + strings.Join(where, " AND ")
 
+	db, release, err := p.searchDB(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch: %w", err)
+	}
+	defer release()
+
+	var total int
+	countQuery := fmt.Sprintf(`SELECT COUNT(*) FROM channels c %s`, whereClause)
+	if err := db.QueryRow(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch count: %w", err)
+	}
+
 	query := fmt.Sprintf(
-		`SELECT c.id, c.name, c.image, c.url, c.media_type, c.source_id, c.group_id, c.favorite, g.name,
+		`SELECT `+channelColumns+`,
 		        1 - (c.embedding <=> $1) AS similarity
 		 FROM channels c
 		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
 		 %s
 		 ORDER BY c.embedding <=> $1 ASC
-		 LIMIT $%d`,
-		whereClause, argIdx,
+		 LIMIT $%d OFFSET $%d`,
+		whereClause, argIdx, argIdx+1,
+	)
+	args = append(args, filter.Limit, filter.Offset)
+
+	slog.DebugContext(ctx, "semantic search SQL", "query", query, "args", fmt.Sprint(args[1:])) // args without the vector
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SemanticResult
+	for rows.Next() {
+		var r SemanticResult
+		if err := rows.Scan(append(channelDest(&r.Channel), &r.Similarity)...); err != nil {
+			return nil, 0, fmt.Errorf("SemanticSearch scan: %w", err)
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("SemanticSearch rows: %w", err)
+	}
+	return results, total, nil
+}
+
+// rrfK damps the reciprocal rank fusion in HybridSearch, so that a top rank
+// in one list does not outweigh good ranks in both.
+const rrfK = 60
+
+// HybridSearch ranks channels by both cosine similarity to queryVec and a
+// full-text match of query against their names, and merges the two rankings
+// with reciprocal rank fusion: each result scores 1/(rrfK+rank) per list it
+// appears in. Each list contributes up to four times Offset+Limit
+// candidates; the returned total counts the merged candidates. MinSimilarity
+// applies to the semantic list only, so exact name matches are kept.
+func (p *Postgres) HybridSearch(ctx context.Context, queryVec []float32, query string, filter ChannelFilter) ([]SemanticResult, int, error) {
+	if err := p.checkQueryVec(queryVec); err != nil {
+		return nil, 0, fmt.Errorf("HybridSearch: %w", err)
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+	if filter.Limit > 200 {
+		filter.Limit = 200
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	// $1 is the query vector, $2 the query text.
+	where, args, argIdx := channelFilterClauses(filter, 3)
+	args = append([]any{pgvector.NewVector(queryVec), query}, args...)
+	filterClause := ""
+	if len(where) > 0 {
+		filterClause = " AND " + strings.Join(where, " AND ")
+	}
+	semClause := filterClause
+	if filter.MinSimilarity != 0 {
+		semClause += fmt.Sprintf(" AND 1 - (c.embedding <=> $1) >= $%d", argIdx)
+		args = append(args, filter.MinSimilarity)
+		argIdx++
+	}
+	if filter.EmbeddingModel != "" {
+		semClause += fmt.Sprintf(" AND c.embedding_model = $%d", argIdx)
+		args = append(args, filter.EmbeddingModel)
+		argIdx++
+	}
+
+	// Names are matched as words with the simple configuration (no stemming,
+	// so "CNN" matches "CNN") or as a substring, like ListChannels' search.
+	const doc = `to_tsvector('simple', COALESCE(c.display_name, c.name))`
+	const tsq = `plainto_tsquery('simple', $2::text)`
+	sql := fmt.Sprintf(
+		`WITH sem AS (
+		     SELECT c.id, 1 - (c.embedding <=> $1) AS similarity,
+		            row_number() OVER (ORDER BY c.embedding <=> $1) AS rank
+		     FROM channels c
+		     WHERE c.embedding IS NOT NULL%[7]s
+		     ORDER BY c.embedding <=> $1
+		     LIMIT $%[2]d
+		 ), lex AS (
+		     SELECT c.id, ts_rank(%[3]s, %[4]s) AS score,
+		            row_number() OVER (ORDER BY ts_rank(%[3]s, %[4]s) DESC, length(c.name), c.id) AS rank
+		     FROM channels c
+		     WHERE (%[3]s @@ %[4]s OR COALESCE(c.custom_name, c.name) ILIKE '%%' || $2 || '%%')%[1]s
+		     ORDER BY rank
+		     LIMIT $%[2]d
+		 )
+		 SELECT `+channelColumns+`,
+		        COALESCE(sem.similarity, 0), COALESCE(lex.score, 0),
+		        COALESCE(1.0 / (%[5]d + sem.rank), 0) + COALESCE(1.0 / (%[5]d + lex.rank), 0) AS fused,
+		        COUNT(*) OVER ()
+		 FROM sem
+		 FULL JOIN lex ON lex.id = sem.id
+		 JOIN channels c ON c.id = COALESCE(sem.id, lex.id)
+		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
+		 ORDER BY fused DESC, c.name, c.id
+		 LIMIT $%[6]d OFFSET $%[8]d`,
+		filterClause, argIdx, doc, tsq, rrfK, argIdx+1, semClause, argIdx+2,
 	)
-	args = append(args, filter.Limit)
+	args = append(args, (filter.Offset+filter.Limit)*4, filter.Limit, filter.Offset)
 
-	log.Printf("SemanticSearch SQL: %s  args (excl. vector): %v", query, args[1:])
+	db, release, err := p.searchDB(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("HybridSearch: %w", err)
+	}
+	defer release()
 
-	rows, err := p.pool.Query(ctx, query, args...)
+	rows, err := db.Query(ctx, sql, args...)
 	if err != nil {
-		return nil, fmt.Errorf("SemanticSearch: %w", err)
+		return nil, 0, fmt.Errorf("HybridSearch: %w", err)
 	}
 	defer rows.Close()
 
 	var results []SemanticResult
+	var total int
 	for rows.Next() {
 		var r SemanticResult
-		if err := rows.Scan(
-			&r.Channel.ID, &r.Channel.Name, &r.Channel.Image, &r.Channel.URL,
-			&r.Channel.MediaType, &r.Channel.SourceID, &r.Channel.GroupID,
-			&r.Channel.Favorite, &r.Channel.GroupName, &r.Similarity,
-		); err != nil {
-			return nil, fmt.Errorf("SemanticSearch scan: %w", err)
+		if err := rows.Scan(append(channelDest(&r.Channel), &r.Similarity, &r.LexicalScore, &r.Score, &total)...); err != nil {
+			return nil, 0, fmt.Errorf("HybridSearch scan: %w", err)
 		}
 		results = append(results, r)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("SemanticSearch rows: %w", err)
+		return nil, 0, fmt.Errorf("HybridSearch rows: %w", err)
+	}
+	return results, total, nil
+}
+
+// Ping checks that a pooled connection to the database works.
+func (p *Postgres) Ping(ctx context.Context) error {
+	if err := p.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("Ping: %w", err)
+	}
+	return nil
+}
+
+// MigrationVersion reads the schema_migrations table kept by golang-migrate.
+func (p *Postgres) MigrationVersion(ctx context.Context) (version int64, dirty bool, err error) {
+	err = p.db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		return 0, false, fmt.Errorf("MigrationVersion: %w", err)
+	}
+	return version, dirty, nil
+}
+
+// embeddingIndex is the HNSW index on channels.embedding.
+const embeddingIndex = "idx_channels_embedding_hnsw"
+
+// EmbeddingDimensions returns the declared dimension of channels.embedding,
+// which must match the embedding model.
+func (p *Postgres) EmbeddingDimensions(ctx context.Context) (int, error) {
+	var dims int
+	err := p.db.QueryRow(ctx,
+		`SELECT atttypmod FROM pg_attribute WHERE attrelid = 'channels'::regclass AND attname = 'embedding'`,
+	).Scan(&dims)
+	if err != nil {
+		return 0, fmt.Errorf("EmbeddingDimensions: %w", err)
+	}
+	return dims, nil
+}
+
+// RebuildEmbeddingIndex rebuilds the HNSW index on channels.embedding, or
+// creates it if it is missing. The build runs CONCURRENTLY, so searches and
+// ingests carry on meanwhile; on a large table it takes minutes. While it
+// runs, onProgress (if non-nil) is called every few seconds with the tuples
+// done and total from pg_stat_progress_create_index. A failed rebuild can
+// leave an invalid idx_channels_embedding_hnsw_ccnew index, which the next
+// run drops.
+func (p *Postgres) RebuildEmbeddingIndex(ctx context.Context, onProgress func(done, total int64)) error {
+	if _, err := p.pool.Exec(ctx, `DROP INDEX CONCURRENTLY IF EXISTS `+embeddingIndex+`_ccnew`); err != nil {
+		return fmt.Errorf("RebuildEmbeddingIndex drop leftover: %w", err)
+	}
+	var exists bool
+	if err := p.pool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, embeddingIndex).Scan(&exists); err != nil {
+		return fmt.Errorf("RebuildEmbeddingIndex: %w", err)
+	}
+	stmt := `REINDEX INDEX CONCURRENTLY ` + embeddingIndex
+	if !exists {
+		stmt = `CREATE INDEX CONCURRENTLY ` + embeddingIndex + `
+		         ON channels USING hnsw (embedding vector_cosine_ops)
+		         WITH (m = 16, ef_construction = 64)`
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if onProgress != nil {
+		go func() {
+			ticker := time.NewTicker(3 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				var tuplesDone, tuplesTotal int64
+				err := p.pool.QueryRow(ctx,
+					`SELECT tuples_done, tuples_total FROM pg_stat_progress_create_index
+					 WHERE relid = 'channels'::regclass`,
+				).Scan(&tuplesDone, &tuplesTotal)
+				if err == nil {
+					onProgress(tuplesDone, tuplesTotal)
+				}
+			}
+		}()
+	}
+
+	// CONCURRENTLY cannot run inside a transaction, so this uses the pool
+	// even within WithTx. The build takes minutes, past any statement
+	// timeout, so the connection runs it without one.
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("RebuildEmbeddingIndex acquire: %w", err)
+	}
+	defer conn.Release()
+	if p.statementTimeout > 0 {
+		if _, err := conn.Exec(ctx, `SET statement_timeout = 0`); err != nil {
+			return fmt.Errorf("RebuildEmbeddingIndex: %w", err)
+		}
+		defer conn.Exec(context.WithoutCancel(ctx), `RESET statement_timeout`)
 	}
-	return results, nil
+	if _, err := conn.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("RebuildEmbeddingIndex: %w", err)
+	}
+	return nil
 }
 
 // ListChannelsBySource returns all channels for a source (with group name joined).
 func (p *Postgres) ListChannelsBySource(ctx context.Context, sourceID int64) ([]models.Channel, error) {
-	rows, err := p.pool.Query(ctx,
-		`SELECT c.id, c.name, c.image, c.url, c.media_type, c.source_id, c.group_id, c.favorite, g.name
+	rows, err := p.db.Query(ctx,
+		`SELECT `+channelColumns+`
 		 FROM channels c
 		 LEFT JOIN groups g ON c.group_id = g.id
+		 JOIN sources src ON src.id = c.source_id
 		 WHERE c.source_id = $1
 		 ORDER BY c.id`,
 		sourceID,
@@ -607,7 +2811,7 @@ func (p *Postgres) ListChannelsBySource(ctx context.Context, sourceID int64) ([]
 	var channels []models.Channel
 	for rows.Next() {
 		var ch models.Channel
-		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Image, &ch.URL, &ch.MediaType, &ch.SourceID, &ch.GroupID, &ch.Favorite, &ch.GroupName); err != nil {
+		if err := rows.Scan(channelDest(&ch)...); err != nil {
 			return nil, fmt.Errorf("ListChannelsBySource scan: %w", err)
 		}
 		channels = append(channels, ch)
@@ -615,20 +2819,22 @@ func (p *Postgres) ListChannelsBySource(ctx context.Context, sourceID int64) ([]
 	return channels, rows.Err()
 }
 
-// ListChannelsWithoutEmbeddings returns channels for a source that have no embedding yet.
-func (p *Postgres) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, limit int) ([]models.Channel, error) {
+// ListChannelsWithoutEmbeddings returns channels for a source that have no
+// embedding yet or one generated with another model than model.
+func (p *Postgres) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, model string, limit int) ([]models.Channel, error) {
 	if limit <= 0 {
 		limit = 1000
 	}
 
-	rows, err := p.pool.Query(ctx,
-		`SELECT c.id, c.name, c.image, c.url, c.media_type, c.source_id, c.group_id, c.favorite, g.name
+	rows, err := p.db.Query(ctx,
+		`SELECT `+channelColumns+`
 		 FROM channels c
 		 LEFT JOIN groups g ON c.group_id = g.id
-		 WHERE c.source_id = $1 AND c.embedding IS NULL
+		 JOIN sources src ON src.id = c.source_id
+		 WHERE c.source_id = $1 AND (c.embedding IS NULL OR c.embedding_model IS DISTINCT FROM $2)
 		 ORDER BY c.id
-		 LIMIT $2`,
-		sourceID, limit,
+		 LIMIT $3`,
+		sourceID, model, limit,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("ListChannelsWithoutEmbeddings: %w", err)
@@ -638,10 +2844,119 @@ func (p *Postgres) ListChannelsWithoutEmbeddings(ctx context.Context, sourceID i
 	var channels []models.Channel
 	for rows.Next() {
 		var ch models.Channel
-		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Image, &ch.URL, &ch.MediaType, &ch.SourceID, &ch.GroupID, &ch.Favorite, &ch.GroupName); err != nil {
+		if err := rows.Scan(channelDest(&ch)...); err != nil {
 			return nil, fmt.Errorf("ListChannelsWithoutEmbeddings scan: %w", err)
 		}
 		channels = append(channels, ch)
 	}
 	return channels, rows.Err()
 }
+
+// ListChannelTvgIDs returns the distinct non-empty tvg-ids of a source's channels.
+func (p *Postgres) ListChannelTvgIDs(ctx context.Context, sourceID int64) ([]string, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT DISTINCT tvg_id FROM channels WHERE source_id = $1 AND tvg_id <> ''`, sourceID)
+	if err != nil {
+		return nil, fmt.Errorf("ListChannelTvgIDs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ListChannelTvgIDs scan: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ReplaceEPGPrograms deletes the source's programmes and COPYs in the ones
+// returned by next, in one transaction. Readers keep seeing the previous
+// guide until the new one is fully loaded, and a failed download leaves it
+// untouched. Programmes are streamed straight into COPY, so memory use does
+// not depend on the size of the guide.
+func (p *Postgres) ReplaceEPGPrograms(ctx context.Context, sourceID int64, next func() (*models.EPGProgram, error)) (int64, error) {
+	tx, err := p.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("ReplaceEPGPrograms begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM epg_programs WHERE source_id = $1`, sourceID); err != nil {
+		return 0, fmt.Errorf("ReplaceEPGPrograms delete: %w", err)
+	}
+
+	n, err := tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"epg_programs"},
+		[]string{"source_id", "channel_tvg_id", "start_time", "stop_time", "title", "description", "category"},
+		&epgCopySource{sourceID: sourceID, next: next},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("ReplaceEPGPrograms copy: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("ReplaceEPGPrograms commit: %w", err)
+	}
+	return n, nil
+}
+
+// epgCopySource implements pgx.CopyFromSource over a programme iterator.
+type epgCopySource struct {
+	sourceID int64
+	next     func() (*models.EPGProgram, error)
+	cur      *models.EPGProgram
+	err      error
+}
+
+func (s *epgCopySource) Next() bool {
+	prog, err := s.next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.err = err
+		}
+		return false
+	}
+	s.cur = prog
+	return true
+}
+
+func (s *epgCopySource) Values() ([]any, error) {
+	c := s.cur
+	return []any{s.sourceID, c.ChannelTvgID, c.Start, c.Stop, c.Title, c.Description, c.Category}, nil
+}
+
+func (s *epgCopySource) Err() error {
+	return s.err
+}
+
+// ListChannelEPG returns the programmes of a channel that overlap [from, to),
+// ordered by start time. Programmes are matched on the channel's tvg-id
+// within its own source; channels without a tvg-id have no programmes.
+func (p *Postgres) ListChannelEPG(ctx context.Context, channelID int64, from, to time.Time) ([]models.EPGProgram, error) {
+	rows, err := p.db.Query(ctx,
+		`SELECT e.id, e.source_id, e.channel_tvg_id, e.start_time, e.stop_time, e.title, e.description, e.category
+		 FROM channels c
+		 JOIN epg_programs e ON e.source_id = c.source_id AND e.channel_tvg_id = c.tvg_id
+		 WHERE c.id = $1 AND e.stop_time > $2 AND e.start_time < $3
+		 ORDER BY e.start_time`,
+		channelID, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ListChannelEPG: %w", err)
+	}
+	defer rows.Close()
+
+	var programs []models.EPGProgram
+	for rows.Next() {
+		var e models.EPGProgram
+		if err := rows.Scan(&e.ID, &e.SourceID, &e.ChannelTvgID, &e.Start, &e.Stop, &e.Title, &e.Description, &e.Category); err != nil {
+			return nil, fmt.Errorf("ListChannelEPG scan: %w", err)
+		}
+		programs = append(programs, e)
+	}
+	return programs, rows.Err()
+}
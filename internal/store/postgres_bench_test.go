@@ -0,0 +1,194 @@
+package store
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// testDatabaseEnv names a Postgres DSN the store benchmarks may fill with
+// fixtures. It should point at a throwaway database: the migrations are run
+// against it and the benchmarks drop and recreate indexes.
+const testDatabaseEnv = "POPCORNVAULT_TEST_DATABASE_URL"
+
+// testPostgres returns a migrated Postgres store, skipping the benchmark
+// when testDatabaseEnv is unset.
+func testPostgres(tb testing.TB) *Postgres {
+	tb.Helper()
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDatabaseEnv)
+	}
+	if err := EnsurePgvector(dsn); err != nil {
+		tb.Fatal(err)
+	}
+	if err := EnsurePgTrgm(dsn); err != nil {
+		tb.Logf("pg_trgm: %v", err)
+	}
+	if err := RunMigrations(dsn, ""); err != nil {
+		tb.Fatal(err)
+	}
+	p, err := NewPostgres(context.Background(), dsn, PoolOptions{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(p.Close)
+	return p
+}
+
+// benchSource creates an empty source named name, deleted with its
+// channels and groups when the benchmark ends.
+func benchSource(tb testing.TB, p *Postgres, name string) int64 {
+	tb.Helper()
+	ctx := context.Background()
+	if _, err := p.db.Exec(ctx, `DELETE FROM sources WHERE name = $1`, name); err != nil {
+		tb.Fatal(err)
+	}
+	id, err := p.CreateCustomSource(ctx, name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if _, err := p.db.Exec(context.Background(), `DELETE FROM sources WHERE id = $1`, id); err != nil {
+			tb.Error(err)
+		}
+	})
+	return id
+}
+
+// searchFixtureSize is the channel count of the name search fixture, the
+// size of the library that prompted the trigram index.
+const searchFixtureSize = 300_000
+
+// BenchmarkChannelSearch runs the ILIKE name search of channelFilterClauses
+// over searchFixtureSize channels through ListChannels, with the trigram
+// index, ranked, and with the index dropped as before the migration.
+func BenchmarkChannelSearch(b *testing.B) {
+	p := testPostgres(b)
+	if !p.trgm {
+		b.Skip("pg_trgm is not installed")
+	}
+	ctx := context.Background()
+	src := benchSource(b, p, "bench-search")
+	_, err := p.db.Exec(ctx,
+		`INSERT INTO channels (name, url, media_type, source_id)
+		 SELECT 'Channel ' || i || ' ' || left(md5(i::text), 8), 'http://example.com/' || i, 0, $1
+		 FROM generate_series(1, $2::int) i`,
+		src, searchFixtureSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := p.db.Exec(ctx, `ANALYZE channels`); err != nil {
+		b.Fatal(err)
+	}
+
+	// The md5 fragment of one channel's name matches about one row.
+	term := fmt.Sprintf("%x", md5.Sum([]byte("123456")))[:8]
+	filter := ChannelFilter{Search: term, Limit: 50}
+	search := func(b *testing.B, filter ChannelFilter) {
+		for i := 0; i < b.N; i++ {
+			channels, _, err := p.ListChannels(ctx, filter)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(channels) == 0 {
+				b.Fatalf("no channel matches %q", filter.Search)
+			}
+		}
+	}
+
+	b.Run("trgm", func(b *testing.B) { search(b, filter) })
+	b.Run("trgm_ranked", func(b *testing.B) {
+		ranked := filter
+		ranked.Rank = true
+		search(b, ranked)
+	})
+	b.Run("unindexed", func(b *testing.B) {
+		if _, err := p.db.Exec(ctx, `DROP INDEX idx_channels_name_trgm`); err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() {
+			_, err := p.db.Exec(context.Background(),
+				`CREATE INDEX IF NOT EXISTS idx_channels_name_trgm ON channels USING gin (name gin_trgm_ops)`)
+			if err != nil {
+				b.Error(err)
+			}
+		})
+		b.ResetTimer()
+		search(b, filter)
+	})
+}
+
+// orphanFixtureGroups is the group count of the RemoveOrphanedGroups
+// fixture, half of them without channels.
+const orphanFixtureGroups = 50_000
+
+// removeOrphanedGroupsNotIn is the statement RemoveOrphanedGroups ran
+// before it was rewritten with NOT EXISTS.
+const removeOrphanedGroupsNotIn = `DELETE FROM groups
+	 WHERE source_id = $1
+	   AND id NOT IN (SELECT DISTINCT group_id FROM channels WHERE source_id = $1 AND group_id IS NOT NULL)`
+
+// BenchmarkRemoveOrphanedGroups deletes the orphaned half of
+// orphanFixtureGroups groups with RemoveOrphanedGroups and with the NOT IN
+// statement it replaced. The other half have four channels each. The
+// orphans are recreated before every iteration, outside the timer.
+func BenchmarkRemoveOrphanedGroups(b *testing.B) {
+	p := testPostgres(b)
+	ctx := context.Background()
+	src := benchSource(b, p, "bench-orphans")
+	_, err := p.db.Exec(ctx,
+		`WITH g AS (
+		   INSERT INTO groups (name, source_id)
+		   SELECT 'Group ' || i, $1 FROM generate_series(1, $2::int) i
+		   RETURNING id)
+		 INSERT INTO channels (name, url, media_type, source_id, group_id)
+		 SELECT 'Channel ' || id || '-' || n, 'http://example.com/' || id || '/' || n, 0, $1, id
+		 FROM g, generate_series(1, 4) n`,
+		src, orphanFixtureGroups/2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := p.db.Exec(ctx, `ANALYZE groups, channels`); err != nil {
+		b.Fatal(err)
+	}
+
+	addOrphans := func(b *testing.B) {
+		b.StopTimer()
+		defer b.StartTimer()
+		_, err := p.db.Exec(ctx,
+			`INSERT INTO groups (name, source_id)
+			 SELECT 'Orphan ' || i, $1 FROM generate_series(1, $2::int) i`,
+			src, orphanFixtureGroups/2)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("not_exists", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			addOrphans(b)
+			removed, err := p.RemoveOrphanedGroups(ctx, src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(removed) != orphanFixtureGroups/2 {
+				b.Fatalf("removed %d groups, want %d", len(removed), orphanFixtureGroups/2)
+			}
+		}
+	})
+	b.Run("not_in", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			addOrphans(b)
+			tag, err := p.db.Exec(ctx, removeOrphanedGroupsNotIn, src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if tag.RowsAffected() != orphanFixtureGroups/2 {
+				b.Fatalf("removed %d groups, want %d", tag.RowsAffected(), orphanFixtureGroups/2)
+			}
+		}
+	})
+}
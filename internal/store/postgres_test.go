@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPoolConfig(t *testing.T) {
+	const dsn = "postgres://u:p@localhost:5432/db"
+	tracer := NewSlowQueryTracer(false, time.Second)
+	tests := []struct {
+		name             string
+		dsn              string
+		opts             PoolOptions
+		maxConns         int32
+		minConns         int32
+		lifetime, health time.Duration
+		statementTimeout string // "" for none
+		writeConns       int
+	}{
+		{"defaults", dsn, PoolOptions{}, 0, 0, 0, 0, "", 0},
+		{"all set", dsn, PoolOptions{
+			MaxConns: 20, MinConns: 2, MaxConnLifetime: 30 * time.Minute, HealthCheckPeriod: 15 * time.Second,
+			StatementTimeout: 2500 * time.Millisecond, WriteConns: 3, Tracer: tracer,
+		}, 20, 2, 30 * time.Minute, 15 * time.Second, "2500", 3},
+		{"write conns from the pool size", dsn, PoolOptions{MaxConns: 20}, 20, 0, 0, 0, "", 5},
+		{"at least one write conn", dsn, PoolOptions{MaxConns: 2}, 2, 0, 0, 0, "", 1},
+		{"DSN pool size kept", dsn + "?pool_max_conns=12&pool_min_conns=3", PoolOptions{}, 12, 3, 0, 0, "", 3},
+		{"options override the DSN", dsn + "?pool_max_conns=12", PoolOptions{MaxConns: 8}, 8, 0, 0, 0, "", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, writeConns, err := poolConfig(tt.dsn, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defaults, _, _ := poolConfig(dsn, PoolOptions{})
+			want := func(v, def int64) int64 {
+				if v == 0 {
+					return def
+				}
+				return v
+			}
+			if got := int64(cfg.MaxConns); got != want(int64(tt.maxConns), int64(defaults.MaxConns)) {
+				t.Errorf("MaxConns = %d, want %d", got, tt.maxConns)
+			}
+			if got := int64(cfg.MinConns); got != want(int64(tt.minConns), int64(defaults.MinConns)) {
+				t.Errorf("MinConns = %d, want %d", got, tt.minConns)
+			}
+			if got := cfg.MaxConnLifetime; int64(got) != want(int64(tt.lifetime), int64(defaults.MaxConnLifetime)) {
+				t.Errorf("MaxConnLifetime = %v, want %v", got, tt.lifetime)
+			}
+			if got := cfg.HealthCheckPeriod; int64(got) != want(int64(tt.health), int64(defaults.HealthCheckPeriod)) {
+				t.Errorf("HealthCheckPeriod = %v, want %v", got, tt.health)
+			}
+			if got := cfg.ConnConfig.RuntimeParams["statement_timeout"]; got != tt.statementTimeout {
+				t.Errorf("statement_timeout = %q, want %q", got, tt.statementTimeout)
+			}
+			if tt.writeConns != 0 && writeConns != tt.writeConns {
+				t.Errorf("write conns = %d, want %d", writeConns, tt.writeConns)
+			}
+			if (tt.opts.Tracer != nil) != (cfg.ConnConfig.Tracer != nil) {
+				t.Errorf("tracer = %v, want %v", cfg.ConnConfig.Tracer, tt.opts.Tracer)
+			}
+		})
+	}
+
+	if _, _, err := poolConfig("postgres://u:p@localhost/db?pool_max_conns=many", PoolOptions{}); err == nil {
+		t.Error("poolConfig accepted an invalid pool_max_conns")
+	}
+}
+
+// TestPoolOptionsApplied opens a pool with options and checks them on the
+// server: pooled connections have the statement timeout, WithTx
+// transactions have none, and the write slots are sized.
+func TestPoolOptionsApplied(t *testing.T) {
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+	testPostgres(t) // migrates
+	ctx := context.Background()
+	p, err := NewPostgres(ctx, dsn, PoolOptions{MaxConns: 6, StatementTimeout: 1500 * time.Millisecond, WriteConns: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if got := p.PoolStat().MaxConns(); got != 6 {
+		t.Errorf("MaxConns = %d, want 6", got)
+	}
+	if got := cap(p.writers); got != 2 {
+		t.Errorf("write slots = %d, want 2", got)
+	}
+	var timeout string
+	if err := p.pool.QueryRow(ctx, `SHOW statement_timeout`).Scan(&timeout); err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "1500ms" {
+		t.Errorf("statement_timeout = %q, want 1500ms", timeout)
+	}
+	err = p.WithTx(ctx, func(s Store) error {
+		return s.(*Postgres).db.QueryRow(ctx, `SHOW statement_timeout`).Scan(&timeout)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout != "0" {
+		t.Errorf("statement_timeout in WithTx = %q, want 0", timeout)
+	}
+}
+
+// TestQueryCancelledOnDeadline checks that a query whose context times
+// out, as a request's does in the server's timeout middleware, is
+// cancelled on the server rather than left running after pgx returns.
+func TestQueryCancelledOnDeadline(t *testing.T) {
+	p := testPostgres(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := p.db.Exec(ctx, `SELECT pg_sleep(30) /* popcornvault-cancel-test */`)
+	if err == nil {
+		t.Fatal("pg_sleep(30) finished under a 100ms deadline")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("query returned after %v", d)
+	}
+
+	// The cancel request reaches the backend asynchronously; allow it a
+	// moment before checking nothing is still sleeping.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var running int
+		err := p.db.QueryRow(context.Background(),
+			`SELECT count(*) FROM pg_stat_activity
+			 WHERE query LIKE '%popcornvault-cancel-test%' AND state = 'active' AND pid <> pg_backend_pid()`).Scan(&running)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d queries still running on the server", running)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
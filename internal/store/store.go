@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/voyagen/popcornvault/internal/models"
 )
@@ -10,31 +11,102 @@ import (
 // ErrNotFound is returned when a requested resource does not exist.
 var ErrNotFound = errors.New("not found")
 
+// ErrConflict is returned when a write would violate a uniqueness
+// constraint, such as renaming a group to a name its source already has.
+var ErrConflict = errors.New("conflict")
+
+// ErrSourceMismatch is returned when groups that must belong to the same
+// source do not.
+var ErrSourceMismatch = errors.New("groups belong to different sources")
+
+// ErrDimensionMismatch is returned by vector searches when the query vector
+// is not as long as the stored embeddings.
+var ErrDimensionMismatch = errors.New("embedding dimension mismatch")
+
 // Store defines persistence for sources, channels, groups, and channel headers.
 type Store interface {
 	// CreateOrGetSource creates a source by name/url if not exists, returns id.
+	// An existing source with the name is returned unchanged.
 	CreateOrGetSource(ctx context.Context, name, url string, sourceType int16, userAgent string) (int64, error)
 	// GetOrCreateGroup returns group id for name/sourceID, creating the group if needed.
 	GetOrCreateGroup(ctx context.Context, sourceID int64, name string, image *string) (int64, error)
 	// UpsertChannel inserts or updates a channel; returns channel id.
 	UpsertChannel(ctx context.Context, ch *models.Channel) (int64, error)
-	// UpsertChannelHeaders inserts or ignores headers for a channel.
+	// RekeyChannelsByTvgID moves existing channels of the source to the name
+	// and URL in keys with the same tvg-id, so that the upserts that follow
+	// update them in place (keeping favorites) instead of inserting new rows.
+	// Returns the number of channels moved.
+	RekeyChannelsByTvgID(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error)
+	// UpsertChannelHeaders inserts or updates the headers of a channel.
+	// Headers marked Edited are kept when later ones from a playlist are not.
 	UpsertChannelHeaders(ctx context.Context, channelID int64, h *models.ChannelHttpHeaders) error
-	// RemoveStaleChannels deletes channels (and their headers) for the source that are NOT in keepIDs.
-	// Returns the number of deleted channels.
-	RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64) (int64, error)
-	// RemoveOrphanedGroups deletes groups for the source that have no remaining channels.
-	// Returns the number of deleted groups.
-	RemoveOrphanedGroups(ctx context.Context, sourceID int64) (int64, error)
+	// BulkUpsertChannelHeaders upserts the headers of many channels as
+	// UpsertChannelHeaders does. A nil entry deletes the channel's headers
+	// unless they are marked Edited, for a playlist that no longer gives any.
+	BulkUpsertChannelHeaders(ctx context.Context, headers map[int64]*models.ChannelHttpHeaders) error
+	// GetChannelHeaders returns the HTTP headers of a channel, or nil if it
+	// has none.
+	GetChannelHeaders(ctx context.Context, channelID int64) (*models.ChannelHttpHeaders, error)
+	// UpsertChannelProps inserts or replaces the player properties (KODIPROP)
+	// of a channel.
+	UpsertChannelProps(ctx context.Context, channelID int64, props map[string]string) error
+	// BulkUpsertChannelProps upserts the player properties of many channels
+	// as UpsertChannelProps does.
+	BulkUpsertChannelProps(ctx context.Context, props map[int64]map[string]string) error
+	// ChannelStates returns the playlist name, URL, tvg-id and group of
+	// every channel of the source by id, for telling what an ingest changes.
+	ChannelStates(ctx context.Context, sourceID int64) (map[int64]ChannelState, error)
+	// RemoveStaleChannels deletes channels (and their headers) for the source that are NOT in keepIDs,
+	// or with archive moves them to the archive (see models.StalePolicyArchive).
+	// Returns the id and name of each removed channel.
+	RemoveStaleChannels(ctx context.Context, sourceID int64, keepIDs []int64, archive bool) ([]models.ChannelRef, error)
+	// RestoreArchivedChannels moves the source's archived channels that keys
+	// list back among its channels, with their ids, flags, edits and
+	// headers, so that the upserts that follow update them. A channel is
+	// matched by name and URL, or by tvg-id while no channel of the source
+	// has it. Returns the number of channels restored.
+	RestoreArchivedChannels(ctx context.Context, sourceID int64, keys []ChannelKey) (int64, error)
+	// RemoveOrphanedGroups deletes groups for the source that have no remaining
+	// channels and returns them in id order.
+	RemoveOrphanedGroups(ctx context.Context, sourceID int64) ([]models.GroupRef, error)
 	// UpdateSourceLastUpdated sets last_updated for the source.
 	UpdateSourceLastUpdated(ctx context.Context, sourceID int64) error
+	// UpdateSourceValidators records the ETag, Last-Modified and content hash
+	// of the playlist version just ingested, and the parser version that
+	// ingested it, for skipping unchanged refreshes.
+	UpdateSourceValidators(ctx context.Context, sourceID int64, etag, lastModified, contentHash string, parserVersion int) error
+	// UpdateSourceEmbeddingRun records the totals of the source's last
+	// completed embedding run.
+	UpdateSourceEmbeddingRun(ctx context.Context, sourceID int64, run models.EmbeddingRun) error
+	// SetPlaylistEPGURL records the EPG URL declared by the source's playlist,
+	// unless one was set explicitly through UpdateSource.
+	SetPlaylistEPGURL(ctx context.Context, sourceID int64, epgURL string) error
+
+	// RecordRefreshRun stores an ingest of a source and sets run.ID. Runs
+	// beyond the latest 100 of the source are deleted.
+	RecordRefreshRun(ctx context.Context, run *models.RefreshRun) error
+	// ListRefreshRuns returns the latest ingests of a source, most recent
+	// first.
+	ListRefreshRuns(ctx context.Context, sourceID int64, limit int) ([]models.RefreshRun, error)
 
-	// ListSources returns all sources.
+	// CreateCustomSource creates a source without a playlist, whose channels
+	// are added one by one through AddChannel; ErrConflict if the name is
+	// taken.
+	CreateCustomSource(ctx context.Context, name string) (int64, error)
+	// AddChannel inserts a channel, and its HTTP headers when h is non-nil,
+	// in one transaction. ErrConflict if the source already has a channel
+	// with the same name and URL.
+	AddChannel(ctx context.Context, ch *models.Channel, h *models.ChannelHttpHeaders) (int64, error)
+	// DeleteChannel deletes a channel with its headers and properties.
+	DeleteChannel(ctx context.Context, channelID int64) error
+
+	// ListSources returns all sources with their channel and group counts.
 	ListSources(ctx context.Context) ([]models.Source, error)
 	// GetSourceByID returns a single source by id.
 	GetSourceByID(ctx context.Context, sourceID int64) (*models.Source, error)
 
-	// UpdateSource updates mutable fields of a source.
+	// UpdateSource updates mutable fields of a source. A name another
+	// source has gives ErrConflict.
 	UpdateSource(ctx context.Context, sourceID int64, fields SourceUpdate) error
 	// DeleteSource deletes a source and cascades to channels/groups (via ON DELETE CASCADE).
 	DeleteSource(ctx context.Context, sourceID int64) error
@@ -43,46 +115,381 @@ type Store interface {
 	GetChannelByID(ctx context.Context, channelID int64) (*models.Channel, error)
 	// ListChannels returns channels matching the filter and the total count (before limit/offset).
 	ListChannels(ctx context.Context, filter ChannelFilter) ([]models.Channel, int, error)
-	// ListGroups returns groups, optionally filtered by source id.
+	// CountChannels returns the number of channels matching the filter, the
+	// total of ListChannels without reading any of them.
+	CountChannels(ctx context.Context, filter ChannelFilter) (int, error)
+	// Stats returns the totals over every source.
+	Stats(ctx context.Context) (models.Stats, error)
+	// StreamChannels calls fn for each channel matching filter (ignoring Limit
+	// and Offset), with group name and HTTP headers joined, without loading
+	// the whole result into memory.
+	StreamChannels(ctx context.Context, filter ChannelFilter, fn func(*models.Channel, *models.ChannelHttpHeaders) error) error
+	// ListGroups returns groups with their channel counts, optionally
+	// filtered by source id.
 	ListGroups(ctx context.Context, sourceID *int64) ([]models.Group, error)
+	// GetGroup returns a single group with its channel count.
+	GetGroup(ctx context.Context, groupID int64) (*models.Group, error)
+	// UpdateGroup renames, hides or sets the image of a group; ErrConflict
+	// if the source already has a group with the new name.
+	UpdateGroup(ctx context.Context, groupID int64, fields GroupUpdate) error
+	// DeleteGroup deletes a group. Its channels are deleted with it when
+	// deleteChannels is set, otherwise they are kept without a group.
+	// Returns the number of channels deleted.
+	DeleteGroup(ctx context.Context, groupID int64, deleteChannels bool) (int64, error)
+	// UpdateChannel applies fields to a channel and records the edited ones,
+	// which refreshes then leave alone.
+	UpdateChannel(ctx context.Context, channelID int64, fields ChannelUpdate) error
+	// BulkUpdateChannels sets the non-nil fields on the channels with the
+	// given ids or, when ids is nil, on every channel matching filter (Limit,
+	// Offset and Sort are ignored). Returns the number of channels changed.
+	BulkUpdateChannels(ctx context.Context, ids []int64, filter ChannelFilter, fields ChannelFlags) (int64, error)
+	// MergeGroups moves the channels of groupIDs to targetID and deletes
+	// those groups, all in one transaction. Every group must belong to the
+	// target's source (ErrSourceMismatch). Returns the number of channels
+	// moved.
+	MergeGroups(ctx context.Context, targetID int64, groupIDs []int64) (int64, error)
+
+	// ListPlaylists returns the user playlists with their channel counts,
+	// by name.
+	ListPlaylists(ctx context.Context) ([]models.Playlist, error)
+	// GetPlaylist returns a single playlist with its channel count.
+	GetPlaylist(ctx context.Context, playlistID int64) (*models.Playlist, error)
+	// CreatePlaylist creates an empty playlist; ErrConflict if the name is
+	// taken.
+	CreatePlaylist(ctx context.Context, name, description string) (int64, error)
+	// UpdatePlaylist renames a playlist or changes its description;
+	// ErrConflict if another playlist has the new name.
+	UpdatePlaylist(ctx context.Context, playlistID int64, fields PlaylistUpdate) error
+	// DeletePlaylist deletes a playlist. Its channels are kept.
+	DeletePlaylist(ctx context.Context, playlistID int64) error
+	// AddPlaylistChannel adds a channel to a playlist, reporting false if it
+	// was already in it. ErrNotFound if either does not exist.
+	AddPlaylistChannel(ctx context.Context, playlistID, channelID int64) (bool, error)
+	// RemovePlaylistChannel removes a channel from a playlist; ErrNotFound
+	// if it is not in it.
+	RemovePlaylistChannel(ctx context.Context, playlistID, channelID int64) error
+
+	// RecordWatch stores a playback position for a channel. It updates the
+	// channel's latest watch event when that was reported after
+	// sessionStart, and starts a new event otherwise, reporting true.
+	// ErrNotFound if the channel does not exist.
+	RecordWatch(ctx context.Context, channelID int64, position int, duration *int, sessionStart time.Time) (*models.WatchEvent, bool, error)
+	// GetWatchPosition returns the latest watch event of a channel, or nil
+	// if it was never watched.
+	GetWatchPosition(ctx context.Context, channelID int64) (*models.WatchEvent, error)
+	// ListWatchHistory returns the most recently watched channels with their
+	// latest watch event, most recent first; with inProgress only those not
+	// finished.
+	ListWatchHistory(ctx context.Context, limit int, inProgress bool) ([]models.WatchHistoryEntry, error)
+	// PruneWatchEvents deletes watch events last updated before cutoff and
+	// returns how many were deleted.
+	PruneWatchEvents(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// ListArchivedChannels returns a page of the source's archived channels,
+	// most recently archived first, and how many it has in all.
+	ListArchivedChannels(ctx context.Context, sourceID int64, limit, offset int) ([]models.ArchivedChannel, int, error)
+	// RestoreArchivedChannel moves one archived channel back among its
+	// source's channels. ErrNotFound if it is not archived, ErrConflict if
+	// the source has a channel with its name and URL again.
+	RestoreArchivedChannel(ctx context.Context, channelID int64) error
+	// PruneArchivedChannels deletes the channels archived before cutoff and
+	// returns how many were deleted.
+	PruneArchivedChannels(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// ListSeries returns series with their episode and season counts,
+	// optionally filtered by source id.
+	ListSeries(ctx context.Context, sourceID *int64) ([]models.Series, error)
+	// ListSeriesEpisodes returns the episodes of a series in season and
+	// episode order, optionally filtered by source id.
+	ListSeriesEpisodes(ctx context.Context, name string, sourceID *int64) ([]models.Channel, error)
 
 	// ToggleChannelFavorite sets the favorite flag on a channel.
 	ToggleChannelFavorite(ctx context.Context, channelID int64, favorite bool) error
+	// CountFavorites returns the number of favorite channels, optionally of
+	// one source. Hidden channels and channels in hidden groups are left out
+	// unless includeHidden is set, as in ListChannels.
+	CountFavorites(ctx context.Context, sourceID *int64, includeHidden bool) (int, error)
 	// CountChannelsBySource returns the total number of channels for a source.
 	CountChannelsBySource(ctx context.Context, sourceID int64) (int64, error)
 
-	// StoreEmbeddings batch-updates the embedding column for the given channel IDs.
-	StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32) error
-	// SemanticSearch returns channels ordered by cosine similarity to queryVec.
-	SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, error)
+	// UpdateChannelStatuses records the outcome of stream checks and counts
+	// consecutive failures per channel.
+	UpdateChannelStatuses(ctx context.Context, checks []ChannelCheck) error
+	// PruneFailingChannels hides, or with remove deletes, the source's
+	// channels with at least threshold consecutive failed checks. Favorites
+	// are never deleted, only hidden. Returns the counts hidden and deleted.
+	PruneFailingChannels(ctx context.Context, sourceID int64, threshold int, remove bool) (hidden, deleted int64, err error)
+	// SetChannelHidden hides or restores a channel.
+	SetChannelHidden(ctx context.Context, channelID int64, hidden bool) error
+
+	// StoreEmbeddings batch-updates the embedding column for the given channel IDs,
+	// recording the model the vectors were generated with and the hashes of
+	// their texts.
+	StoreEmbeddings(ctx context.Context, channelIDs []int64, embeddings [][]float32, model string, textHashes []string) error
+	// EmbeddingTextHashes returns the text hashes stored with the given
+	// channels' embeddings from model, by channel id.
+	EmbeddingTextHashes(ctx context.Context, channelIDs []int64, model string) (map[int64]string, error)
+	// EmbeddingStats counts a source's channels embedded with model, with
+	// another model, and not at all.
+	EmbeddingStats(ctx context.Context, sourceID int64, model string) (EmbeddingStats, error)
+	// GetChannelEmbedding returns the stored embedding of a channel, or nil if
+	// it has none yet.
+	GetChannelEmbedding(ctx context.Context, channelID int64) ([]float32, error)
+	// GetChannelEmbeddings returns the stored embeddings of the given
+	// channels by id, leaving out channels without one.
+	GetChannelEmbeddings(ctx context.Context, channelIDs []int64) (map[int64][]float32, error)
+	// RebuildEmbeddingIndex rebuilds (or creates) the vector index used by
+	// SemanticSearch without blocking reads and writes, reporting progress
+	// to onProgress while it runs.
+	RebuildEmbeddingIndex(ctx context.Context, onProgress func(done, total int64)) error
+	// SemanticSearch returns channels ordered by cosine similarity to queryVec
+	// and the number of matches before limit/offset.
+	SemanticSearch(ctx context.Context, queryVec []float32, filter ChannelFilter) ([]SemanticResult, int, error)
+	// HybridSearch merges the SemanticSearch ranking with a full-text match
+	// of query against channel names.
+	HybridSearch(ctx context.Context, queryVec []float32, query string, filter ChannelFilter) ([]SemanticResult, int, error)
 	// ListChannelsBySource returns all channels for a source (with group name joined).
 	ListChannelsBySource(ctx context.Context, sourceID int64) ([]models.Channel, error)
-	// ListChannelsWithoutEmbeddings returns channels for a source that have no embedding yet.
-	ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, limit int) ([]models.Channel, error)
+	// ListChannelsWithoutEmbeddings returns channels for a source that have no
+	// embedding yet or one generated with another model than model.
+	ListChannelsWithoutEmbeddings(ctx context.Context, sourceID int64, model string, limit int) ([]models.Channel, error)
+
+	// ListChannelTvgIDs returns the distinct non-empty tvg-ids of a source's channels.
+	ListChannelTvgIDs(ctx context.Context, sourceID int64) ([]string, error)
+	// ReplaceEPGPrograms atomically replaces a source's EPG programmes with
+	// those returned by next, which signals the end with io.EOF. Returns the
+	// number of programmes stored.
+	ReplaceEPGPrograms(ctx context.Context, sourceID int64, next func() (*models.EPGProgram, error)) (int64, error)
+	// ListChannelEPG returns the programmes of a channel (matched by tvg-id
+	// within its source) that overlap [from, to), ordered by start time.
+	ListChannelEPG(ctx context.Context, channelID int64, from, to time.Time) ([]models.EPGProgram, error)
+
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// MigrationVersion returns the last applied schema migration and whether
+	// it failed halfway (dirty), which needs manual repair.
+	MigrationVersion(ctx context.Context) (version int64, dirty bool, err error)
+}
+
+// BulkChannelUpserter is implemented by stores that can upsert many channels
+// in a single round trip. Ingest uses it when available and falls back to
+// per-row UpsertChannel calls otherwise.
+type BulkChannelUpserter interface {
+	// BulkUpsertChannels inserts or updates channels with the same semantics as
+	// UpsertChannel and returns their ids in input order.
+	BulkUpsertChannels(ctx context.Context, channels []models.Channel) ([]int64, error)
+}
+
+// Transactor is implemented by stores that can run a group of writes
+// atomically. Ingest uses it so that a failed refresh leaves the previous
+// channel set exactly as it was.
+type Transactor interface {
+	// WithTx calls fn with a Store bound to a single transaction. The
+	// transaction is committed when fn returns nil and rolled back otherwise.
+	WithTx(ctx context.Context, fn func(Store) error) error
+}
+
+// SourceInvalidator is implemented by caching stores. Writes made inside a
+// Transactor's transaction bypass the cache, so once it has committed the
+// caller invalidates the source it wrote, in one go rather than per row.
+type SourceInvalidator interface {
+	// InvalidateSource drops every cached entry that may hold data of the
+	// source.
+	InvalidateSource(ctx context.Context, sourceID int64)
+}
+
+// CacheWarmer is implemented by caching stores that can fill the cache
+// ahead of reads.
+type CacheWarmer interface {
+	// WarmSource caches what clients read first after a refresh of the
+	// source.
+	WarmSource(ctx context.Context, sourceID int64) error
+}
+
+// CacheFlusher is implemented by caching stores that can drop everything
+// they cached.
+type CacheFlusher interface {
+	FlushCache(ctx context.Context) error
+}
+
+// CacheInspector is implemented by caching stores that keep statistics of
+// their cache.
+type CacheInspector interface {
+	CacheStats() map[string]CacheStats
+	CountCacheKeys(ctx context.Context) (map[string]int64, error)
+}
+
+// EmbeddingStats is the embedding coverage of a source for one model.
+type EmbeddingStats struct {
+	Model   string `json:"model"`
+	Current int    `json:"current"` // embedded with Model
+	Stale   int    `json:"stale"`   // embedded with another model; re-embedded on the next refresh
+	Missing int    `json:"missing"` // not embedded yet
 }
 
 // SemanticResult wraps a Channel with its cosine similarity score.
 type SemanticResult struct {
 	Channel    models.Channel `json:"channel"`
 	Similarity float64        `json:"similarity"`
+	// Set by HybridSearch only: the full-text rank of the name match and the
+	// fused score results are ordered by.
+	LexicalScore float64 `json:"lexical_score,omitempty"`
+	Score        float64 `json:"score,omitempty"`
+}
+
+// ChannelState is the playlist side of a stored channel, as returned by
+// ChannelStates. Name and URL are the playlist's even when the user edited
+// them.
+type ChannelState struct {
+	Name         string
+	URL          string
+	TvgID        *string
+	GroupID      *int64
+	Group        *string // group name
+	EditedFields models.EditedFields
+}
+
+// ChannelKey identifies a playlist entry for RekeyChannelsByTvgID. The
+// TvgIDs in one call must be distinct.
+type ChannelKey struct {
+	TvgID string
+	Name  string
+	URL   string
 }
 
 // ChannelFilter holds optional filters for listing channels.
 type ChannelFilter struct {
-	SourceID  *int64
-	GroupID   *int64
-	MediaType *int16 // 0 = Livestream, 1 = Movie, 2 = Serie
-	Favorite  *bool  // filter by favorite status
-	Search    string // case-insensitive substring match on channel name
-	Limit     int    // default 50, max 200
-	Offset    int
+	SourceID        *int64
+	ExcludeSourceID *int64 // channels of any other source
+	GroupID         *int64
+	GroupIDs        []int64 // channels in any of these groups
+	ExcludeGroupIDs []int64 // channels in none of these groups; ungrouped channels match
+	PlaylistID      *int64  // channels in this user playlist
+	Watched         *bool   // channels with (true) or without (false) watch history
+	MediaType       *int16  // 0 = Livestream, 1 = Movie, 2 = Serie
+	Favorite        *bool   // filter by favorite status
+	TvgID           string  // exact match on tvg-id
+	Quality         string  // exact match on quality tier (models.QualitySD etc.)
+	Status          string  // health status (models.ChannelStatusOK etc.) or StatusUnchecked
+	IncludeHidden   bool    // include hidden channels and channels of hidden groups
+	Search          string  // case-insensitive substring match on channel name
+	Rank            bool    // ListChannels: order Search results by trigram similarity first (needs pg_trgm)
+	MinSimilarity   float64 // SemanticSearch: minimum cosine similarity; 0 = no threshold
+	SearchAccuracy  int     // SemanticSearch: hnsw.ef_search, the index candidates scanned (1-1000); 0 = server default
+	EmbeddingModel  string  // SemanticSearch: only channels embedded with this model
+	Sort            string  // one of ChannelSorts; empty means SortName
+	Limit           int     // default 50, max 200
+	Offset          int
+
+	// AddedSince keeps the channels created at or after it.
+	AddedSince *time.Time
+
+	// ListChannels only: continue after this position instead of skipping
+	// Offset channels; it must have been made for Sort and cannot follow
+	// ranked search results. NoTotal skips counting the matches, and the
+	// total is then -1.
+	After   *ChannelCursor
+	NoTotal bool
+}
+
+// StatusUnchecked in ChannelFilter.Status selects channels that have never
+// been checked.
+const StatusUnchecked = "unchecked"
+
+// ChannelCheck is the outcome of checking one channel's stream.
+type ChannelCheck struct {
+	ChannelID  int64
+	Status     string // models.ChannelStatusOK, ChannelStatusDead or ChannelStatusTimeout
+	StatusCode int    // HTTP status of the last response; 0 if none arrived
+	CheckedAt  time.Time
 }
 
+// Channel list orders accepted in ChannelFilter.Sort.
+const (
+	SortName        = "name"        // alphabetical
+	SortNameDesc    = "-name"       // reverse alphabetical
+	SortNumber      = "number"      // by channel number (tvg-chno), unnumbered last, then name
+	SortGroup       = "group"       // by group name, ungrouped last, then name
+	SortCreated     = "created_at"  // oldest first
+	SortCreatedDesc = "-created_at" // recently added first
+	SortFavorite    = "favorite"    // favorites first, then name
+)
+
+// ChannelSorts lists the valid ChannelFilter.Sort values.
+var ChannelSorts = []string{SortName, SortNameDesc, SortNumber, SortGroup, SortCreated, SortCreatedDesc, SortFavorite}
+
 // SourceUpdate holds mutable fields for PATCH /sources/{id}.
 // Pointer fields: nil = don't change, non-nil = set.
 type SourceUpdate struct {
-	Name      *string
+	Name *string
+	// URL moves the source to another playlist; changing it clears the
+	// playlist validators like GuessMediaType.
 	URL       *string
 	UserAgent *string
+	EPGURL    *string // marks the URL as user-chosen; "" clears it and the mark
 	Enabled   *bool
+
+	// GuessMediaType toggles media type guessing. Changing it clears the
+	// playlist validators so the next refresh re-classifies every channel.
+	GuessMediaType *bool
+	// Dedupe toggles collapsing entries with the same URL; like
+	// GuessMediaType, changing it forces a full re-ingest.
+	Dedupe *bool
+	// UseTvgID toggles naming entries without a tvg-name by their tvg-id
+	// rather than the title; changing it renames channels, so it forces a
+	// full re-ingest.
+	UseTvgID *bool
+	// DeadPolicy and DeadThreshold set what happens to channels failing
+	// that many consecutive checks (see models.DeadPolicyKeep).
+	DeadPolicy    *string
+	DeadThreshold *int
+	// StalePolicy sets what refreshes do with channels the playlist no
+	// longer lists (see models.StalePolicyArchive).
+	StalePolicy *string
+
+	// WebhookURL is notified when a refresh of the source ends; "" clears it.
+	WebhookURL *string
+
+	// FetchHeaders replaces the extra playlist request headers when non-nil;
+	// an empty map clears them.
+	FetchHeaders map[string]string
+}
+
+// GroupUpdate holds mutable fields for PATCH /groups/{id}.
+// Pointer fields: nil = don't change, non-nil = set.
+type GroupUpdate struct {
+	Name   *string
+	Image  *string // "" clears it
+	Hidden *bool   // hides the group and its channels from listings
+}
+
+// ChannelUpdate holds the fields of PATCH /channels/{id}. Name, Image, URL,
+// GroupID and MediaType are recorded as edited and kept across refreshes
+// until reset. Pointer fields: nil = don't change, non-nil = set.
+type ChannelUpdate struct {
+	Name      *string
+	Image     *string // "" clears it
+	URL       *string
+	GroupID   *int64 // 0 removes the channel from its group
+	MediaType *int16
+	Favorite  *bool
+	Hidden    *bool // false also resets the failed check count, like SetChannelHidden
+
+	// Reset drops the edits of these fields: the name and URL go back to the
+	// playlist's at once, the others at the next refresh.
+	Reset models.EditedFields
+}
+
+// PlaylistUpdate holds mutable fields for PATCH /playlists/{id}.
+// Pointer fields: nil = don't change, non-nil = set.
+type PlaylistUpdate struct {
+	Name        *string
+	Description *string // "" clears it
+}
+
+// ChannelFlags holds the user flags of a channel for BulkUpdateChannels.
+// Pointer fields: nil = don't change, non-nil = set.
+type ChannelFlags struct {
+	Favorite *bool
+	Hidden   *bool // false also resets the failed check count, like SetChannelHidden
 }
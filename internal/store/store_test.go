@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/voyagen/popcornvault/internal/cache"
+	"github.com/voyagen/popcornvault/internal/models"
+)
+
+// testStores builds each Store implementation for the tests that run on
+// all of them; Postgres runs when testDatabaseEnv is set.
+var testStores = []struct {
+	name string
+	new  func(t *testing.T) Store
+}{
+	{"memory", func(t *testing.T) Store { return NewMemory() }},
+	{"cached", func(t *testing.T) Store {
+		return NewCachedStore(NewMemory(), cache.NewMemory(1000, 1<<20), CacheOptions{TTLSources: time.Minute, TTLGroups: time.Minute})
+	}},
+	{"postgres", func(t *testing.T) Store { return testPostgres(t) }},
+}
+
+// TestListCounts checks the channel and group counts of ListSources and
+// ListGroups on a fixture with hidden, ungrouped and empty entries, on each
+// Store implementation.
+func TestListCounts(t *testing.T) {
+	for _, st := range testStores {
+		t.Run(st.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := st.new(t)
+			newSource := func(name string) int64 {
+				name = fmt.Sprintf("counts-%s-%d", name, time.Now().UnixNano())
+				id, err := s.CreateCustomSource(ctx, name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { s.DeleteSource(context.Background(), id) })
+				return id
+			}
+			newGroup := func(src int64, name string) int64 {
+				id, err := s.GetOrCreateGroup(ctx, src, name, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return id
+			}
+			addChannels := func(src int64, group *int64, n int) []int64 {
+				var ids []int64
+				for i := 0; i < n; i++ {
+					ch := models.Channel{SourceID: src, GroupID: group, Name: fmt.Sprintf("ch-%d-%d", len(ids), time.Now().UnixNano()), URL: "http://example.com/"}
+					id, err := s.UpsertChannel(ctx, &ch)
+					if err != nil {
+						t.Fatal(err)
+					}
+					ids = append(ids, id)
+				}
+				return ids
+			}
+
+			a, b, empty := newSource("a"), newSource("b"), newSource("empty")
+			news, sport, unused := newGroup(a, "News"), newGroup(a, "Sport"), newGroup(a, "Unused")
+			movies := newGroup(b, "Movies")
+			hidden := addChannels(a, &news, 3)[0]
+			if err := s.SetChannelHidden(ctx, hidden, true); err != nil {
+				t.Fatal(err)
+			}
+			addChannels(a, &sport, 1)
+			addChannels(a, nil, 2)
+			addChannels(b, &movies, 4)
+
+			sources, err := s.ListSources(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSources := map[int64][2]int{a: {6, 3}, b: {4, 1}, empty: {0, 0}}
+			for _, src := range sources {
+				want, ok := wantSources[src.ID]
+				if !ok {
+					continue
+				}
+				delete(wantSources, src.ID)
+				if src.ChannelCount == nil || src.GroupCount == nil || *src.ChannelCount != want[0] || *src.GroupCount != want[1] {
+					t.Errorf("source %q counts = %v channels, %v groups, want %d, %d", src.Name, src.ChannelCount, src.GroupCount, want[0], want[1])
+				}
+			}
+			if len(wantSources) != 0 {
+				t.Errorf("sources missing from the list: %v", wantSources)
+			}
+
+			groups, err := s.ListGroups(ctx, &a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantGroups := map[int64]int{news: 3, sport: 1, unused: 0}
+			if len(groups) != len(wantGroups) {
+				t.Fatalf("groups of a = %+v, want 3", groups)
+			}
+			for _, g := range groups {
+				if g.ChannelCount != wantGroups[g.ID] {
+					t.Errorf("group %q count = %d, want %d", g.Name, g.ChannelCount, wantGroups[g.ID])
+				}
+			}
+
+			// The counts follow writes, through the cache too.
+			addChannels(b, &movies, 1)
+			sources, err = s.ListSources(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, src := range sources {
+				if src.ID == b && (src.ChannelCount == nil || *src.ChannelCount != 5) {
+					t.Errorf("source b channel count after an insert = %v, want 5", src.ChannelCount)
+				}
+			}
+			groups, err = s.ListGroups(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, g := range groups {
+				if g.ID == movies && g.ChannelCount != 5 {
+					t.Errorf("group Movies count after an insert = %d, want 5", g.ChannelCount)
+				}
+			}
+		})
+	}
+}
+
+// channelProps reads back the player properties stored for a channel, nil
+// if it has none.
+func channelProps(t *testing.T, s Store, id int64) map[string]string {
+	t.Helper()
+	switch s := s.(type) {
+	case *CachedStore:
+		return channelProps(t, s.inner, id)
+	case *Memory:
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.channels[id].props
+	case *Postgres:
+		var props map[string]string
+		err := s.db.QueryRow(context.Background(), `SELECT props FROM channel_props WHERE channel_id = $1`, id).Scan(&props)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			t.Fatal(err)
+		}
+		return props
+	}
+	t.Fatalf("no props reader for %T", s)
+	return nil
+}
+
+func TestBulkUpsertChannelProps(t *testing.T) {
+	for _, st := range testStores {
+		t.Run(st.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := st.new(t)
+			src, err := s.CreateCustomSource(ctx, fmt.Sprintf("props-%d", time.Now().UnixNano()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { s.DeleteSource(context.Background(), src) })
+			var ids []int64
+			for _, name := range []string{"A", "B", "C"} {
+				id, err := s.UpsertChannel(ctx, &models.Channel{SourceID: src, Name: name, URL: "http://example.com/" + name})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+			}
+			a, b, c := ids[0], ids[1], ids[2]
+
+			if err := s.BulkUpsertChannelProps(ctx, nil); err != nil {
+				t.Fatalf("empty batch: %v", err)
+			}
+			first := map[int64]map[string]string{
+				a: {"inputstream.adaptive.license_type": "clearkey", "inputstream.adaptive.license_key": "k1"},
+				b: {"inputstream": "inputstream.adaptive"},
+			}
+			if err := s.BulkUpsertChannelProps(ctx, first); err != nil {
+				t.Fatal(err)
+			}
+			// A second batch replaces a channel's props whole and leaves the
+			// channels it does not list alone.
+			second := map[int64]map[string]string{a: {"inputstream.adaptive.license_key": "k2"}}
+			if err := s.BulkUpsertChannelProps(ctx, second); err != nil {
+				t.Fatal(err)
+			}
+			for id, want := range map[int64]map[string]string{a: second[a], b: first[b], c: nil} {
+				if got := channelProps(t, s, id); !maps.Equal(got, want) {
+					t.Errorf("channel %d props = %v, want %v", id, got, want)
+				}
+			}
+
+			// A batch naming a missing channel stores nothing.
+			err = s.BulkUpsertChannelProps(ctx, map[int64]map[string]string{c: {"k": "v"}, c + 1<<40: {"k": "v"}})
+			if err == nil {
+				t.Fatal("no error for a missing channel")
+			}
+			if got := channelProps(t, s, c); got != nil {
+				t.Errorf("channel %d props = %v after a failed batch, want none", c, got)
+			}
+		})
+	}
+}
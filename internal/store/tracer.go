@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/voyagen/popcornvault/internal/metrics"
+)
+
+// DefaultSlowQueryThreshold is the duration from which SlowQueryTracer
+// logs a query when no other threshold is configured.
+const DefaultSlowQueryThreshold = 250 * time.Millisecond
+
+// slowQuerySQLLimit is the length at which logged SQL is cut off.
+const slowQuerySQLLimit = 1000
+
+// SlowQueryTracer is a pgx query tracer that logs queries taking longer
+// than a threshold, with the Postgres method that ran them and the SQL, but
+// not the arguments, which may hold search terms and credentials. Slow
+// queries are also counted in metrics. It can be switched on and off, and
+// its threshold changed, while the server runs.
+type SlowQueryTracer struct {
+	enabled   atomic.Bool
+	threshold atomic.Int64 // time.Duration
+}
+
+// NewSlowQueryTracer returns a tracer logging queries slower than
+// threshold (DefaultSlowQueryThreshold if not positive) when enabled.
+func NewSlowQueryTracer(enabled bool, threshold time.Duration) *SlowQueryTracer {
+	t := &SlowQueryTracer{}
+	t.enabled.Store(enabled)
+	t.SetThreshold(threshold)
+	return t
+}
+
+// Enabled reports whether slow queries are logged.
+func (t *SlowQueryTracer) Enabled() bool { return t.enabled.Load() }
+
+// SetEnabled switches slow query logging on or off.
+func (t *SlowQueryTracer) SetEnabled(on bool) { t.enabled.Store(on) }
+
+// Threshold returns the duration from which a query is logged.
+func (t *SlowQueryTracer) Threshold() time.Duration { return time.Duration(t.threshold.Load()) }
+
+// SetThreshold sets the duration from which a query is logged; a value
+// that is not positive restores DefaultSlowQueryThreshold.
+func (t *SlowQueryTracer) SetThreshold(d time.Duration) {
+	if d <= 0 {
+		d = DefaultSlowQueryThreshold
+	}
+	t.threshold.Store(int64(d))
+}
+
+type traceKey struct{}
+
+// queryTrace is what TraceQueryStart hands to TraceQueryEnd.
+type queryTrace struct {
+	start  time.Time
+	sql    string
+	method string
+}
+
+// TraceQueryStart notes the start of a query, and which store method runs
+// it while the caller's stack is at hand.
+func (t *SlowQueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !t.Enabled() {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, &queryTrace{start: time.Now(), sql: data.SQL, method: storeMethod()})
+}
+
+// TraceQueryEnd logs the query if it took longer than the threshold.
+func (t *SlowQueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	qt, ok := ctx.Value(traceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+	d := time.Since(qt.start)
+	if d < t.Threshold() {
+		return
+	}
+	metrics.SlowQueries.WithLabelValues(qt.method).Inc()
+	attrs := []any{"method", qt.method, "duration", d, "sql", compactSQL(qt.sql)}
+	if data.Err != nil {
+		attrs = append(attrs, "err", data.Err)
+	}
+	slog.WarnContext(ctx, "slow query", attrs...)
+}
+
+// storeMethod returns the name of the innermost exported Postgres method on
+// the stack, e.g. "ListChannels", or "unknown". Unexported helpers are
+// skipped to name the method callers know.
+func storeMethod() string {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	const prefix = "github.com/voyagen/popcornvault/internal/store.(*Postgres)."
+	for {
+		f, more := frames.Next()
+		if name, ok := strings.CutPrefix(f.Function, prefix); ok && name != "" && unicode.IsUpper(rune(name[0])) {
+			// Drop the suffix of closures, e.g. "RebuildEmbeddingIndex.func1".
+			name, _, _ = strings.Cut(name, ".")
+			return name
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// compactSQL collapses the whitespace of a query and cuts it off at
+// slowQuerySQLLimit bytes.
+func compactSQL(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > slowQuerySQLLimit {
+		sql = sql[:slowQuerySQLLimit] + "..."
+	}
+	return sql
+}
@@ -0,0 +1,145 @@
+// Package webhook delivers refresh notifications to user-configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Event types sent in Event.Event.
+const (
+	EventRefreshCompleted = "refresh.completed"
+	EventRefreshFailed    = "refresh.failed"
+)
+
+// Headers set on every delivery. SignatureHeader is only set when a secret
+// is configured: "sha256=" followed by the hex HMAC-SHA256 of the body.
+const (
+	EventHeader     = "X-PopcornVault-Event"
+	SignatureHeader = "X-PopcornVault-Signature"
+)
+
+// Event is the JSON body POSTed when a source refresh (an ingest or
+// embeddings job) ends.
+type Event struct {
+	Event            string    `json:"event"`
+	JobID            string    `json:"job_id"`
+	Kind             string    `json:"kind"`
+	State            string    `json:"state"` // done, failed or cancelled
+	SourceID         int64     `json:"source_id"`
+	SourceName       string    `json:"source_name"`
+	ChannelCount     int       `json:"channel_count"`
+	StaleRemoved     int       `json:"stale_removed"`
+	EmbeddingsStored int       `json:"embeddings_stored"`
+	Unchanged        bool      `json:"unchanged,omitempty"`
+	DurationMS       int64     `json:"duration_ms"`
+	Error            string    `json:"error,omitempty"`
+	FinishedAt       time.Time `json:"finished_at"`
+}
+
+// Defaults for Sender.
+const (
+	DefaultAttempts = 3
+	defaultBackoff  = 2 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Sender POSTs events, retrying network errors, 429 and 5xx responses with
+// exponential backoff.
+type Sender struct {
+	Secret   string        // HMAC key for SignatureHeader; empty disables signing
+	Attempts int           // total attempts; 0 uses DefaultAttempts
+	Backoff  time.Duration // delay before the second attempt, doubled after each one
+
+	client *http.Client
+}
+
+// NewSender returns a Sender signing with secret, which may be empty.
+func NewSender(secret string) *Sender {
+	return &Sender{
+		Secret:   secret,
+		Attempts: DefaultAttempts,
+		Backoff:  defaultBackoff,
+		client:   &http.Client{Timeout: defaultTimeout},
+	}
+}
+
+// Send POSTs ev to url and returns the last error once every attempt has
+// failed.
+func (s *Sender) Send(ctx context.Context, url string, ev Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("webhook marshal: %w", err)
+	}
+
+	attempts := s.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	delay := s.Backoff
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, url, ev.Event, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt >= attempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("webhook: %w (last error: %v)", ctx.Err(), lastErr)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return fmt.Errorf("webhook %s: %w", url, lastErr)
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (s *Sender) post(ctx context.Context, url, event string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PopcornVault-Webhook/1.0")
+	req.Header.Set(EventHeader, event)
+	if s.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("Do: %w", err)
+	}
+	defer resp.Body.Close()
+	io.CopyN(io.Discard, resp.Body, 4096)
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("HTTP %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the SignatureHeader value for body: "sha256=" and the hex
+// HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
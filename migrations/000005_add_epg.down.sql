@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS epg_programs;
+ALTER TABLE channels DROP COLUMN IF EXISTS tvg_id;
+ALTER TABLE sources DROP COLUMN IF EXISTS epg_url;
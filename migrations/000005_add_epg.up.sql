@@ -0,0 +1,18 @@
+-- EPG URL declared by the playlist (url-tvg) or set by the user
+ALTER TABLE sources ADD COLUMN epg_url TEXT;
+
+-- tvg-id links a channel to its programmes in the XMLTV guide
+ALTER TABLE channels ADD COLUMN tvg_id TEXT;
+
+-- epg_programs: XMLTV programmes per source, keyed by the channel's tvg-id
+CREATE TABLE IF NOT EXISTS epg_programs (
+    id BIGSERIAL PRIMARY KEY,
+    source_id BIGINT NOT NULL REFERENCES sources(id) ON DELETE CASCADE,
+    channel_tvg_id TEXT NOT NULL,
+    start_time TIMESTAMPTZ NOT NULL,
+    stop_time TIMESTAMPTZ NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT,
+    category TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_epg_programs_channel ON epg_programs(source_id, channel_tvg_id, start_time);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_channels_source_tvg_id;
@@ -0,0 +1,2 @@
+-- Lookups by tvg-id: refresh matching, ?tvg_id= filtering and EPG joins
+CREATE INDEX IF NOT EXISTS idx_channels_source_tvg_id ON channels(source_id, tvg_id) WHERE tvg_id IS NOT NULL;
@@ -0,0 +1,2 @@
+-- channel_number: lineup position from tvg-chno
+ALTER TABLE channels ADD COLUMN channel_number INTEGER;
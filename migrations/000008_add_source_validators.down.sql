@@ -0,0 +1,3 @@
+ALTER TABLE sources DROP COLUMN IF EXISTS content_hash;
+ALTER TABLE sources DROP COLUMN IF EXISTS last_modified;
+ALTER TABLE sources DROP COLUMN IF EXISTS etag;
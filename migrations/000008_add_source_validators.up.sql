@@ -0,0 +1,4 @@
+-- Version of the playlist last ingested, used to skip unchanged refreshes
+ALTER TABLE sources ADD COLUMN etag TEXT;
+ALTER TABLE sources ADD COLUMN last_modified TEXT;
+ALTER TABLE sources ADD COLUMN content_hash TEXT;
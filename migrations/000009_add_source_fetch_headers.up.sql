@@ -0,0 +1,2 @@
+-- Extra HTTP headers (name -> value) sent when fetching the playlist
+ALTER TABLE sources ADD COLUMN fetch_headers JSONB;
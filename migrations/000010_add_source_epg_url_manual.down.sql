@@ -0,0 +1 @@
+ALTER TABLE sources DROP COLUMN IF EXISTS epg_url_manual;
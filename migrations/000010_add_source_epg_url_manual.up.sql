@@ -0,0 +1,3 @@
+-- Set when epg_url was chosen through the API; the playlist's url-tvg then
+-- no longer overwrites it on refresh
+ALTER TABLE sources ADD COLUMN epg_url_manual BOOLEAN NOT NULL DEFAULT false;
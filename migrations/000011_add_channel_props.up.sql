@@ -0,0 +1,6 @@
+-- channel_props: player properties per channel (from #KODIPROP), e.g.
+-- inputstream.adaptive.license_key
+CREATE TABLE IF NOT EXISTS channel_props (
+    channel_id BIGINT PRIMARY KEY REFERENCES channels(id) ON DELETE CASCADE,
+    props JSONB NOT NULL
+);
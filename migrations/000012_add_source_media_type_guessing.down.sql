@@ -0,0 +1,2 @@
+ALTER TABLE sources DROP COLUMN IF EXISTS parser_version;
+ALTER TABLE sources DROP COLUMN IF EXISTS guess_media_type;
@@ -0,0 +1,7 @@
+-- guess_media_type: classify VOD by file extension and group-title keywords,
+-- not only by Xtream /movie/ and /series/ paths
+ALTER TABLE sources ADD COLUMN guess_media_type BOOLEAN NOT NULL DEFAULT true;
+
+-- parser_version: fetcher.ParserVersion of the last ingest; older sources
+-- are re-ingested even when the playlist is unchanged
+ALTER TABLE sources ADD COLUMN parser_version INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_channels_series;
+ALTER TABLE channels DROP COLUMN IF EXISTS episode;
+ALTER TABLE channels DROP COLUMN IF EXISTS season;
+ALTER TABLE channels DROP COLUMN IF EXISTS series_name;
@@ -0,0 +1,7 @@
+-- Series episodes recognised from channel names (SxxEyy / NxNN)
+ALTER TABLE channels ADD COLUMN series_name TEXT;
+ALTER TABLE channels ADD COLUMN season INTEGER;
+ALTER TABLE channels ADD COLUMN episode INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_channels_series
+    ON channels (series_name, season, episode) WHERE series_name IS NOT NULL;
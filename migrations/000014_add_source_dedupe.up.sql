@@ -0,0 +1,2 @@
+-- dedupe: collapse playlist entries with the same URL into one channel
+ALTER TABLE sources ADD COLUMN dedupe BOOLEAN NOT NULL DEFAULT false;
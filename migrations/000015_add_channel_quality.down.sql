@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_channels_quality;
+ALTER TABLE channels DROP COLUMN IF EXISTS display_name;
+ALTER TABLE channels DROP COLUMN IF EXISTS quality;
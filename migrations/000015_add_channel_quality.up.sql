@@ -0,0 +1,5 @@
+-- Stream quality detected from channel names, and the name without quality tokens
+ALTER TABLE channels ADD COLUMN quality TEXT;
+ALTER TABLE channels ADD COLUMN display_name TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_channels_quality ON channels (quality) WHERE quality IS NOT NULL;
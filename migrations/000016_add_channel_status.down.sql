@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_channels_source_status;
+ALTER TABLE channels DROP COLUMN IF EXISTS last_checked;
+ALTER TABLE channels DROP COLUMN IF EXISTS status_code;
+ALTER TABLE channels DROP COLUMN IF EXISTS status;
@@ -0,0 +1,6 @@
+-- Stream health recorded by channel checks (POST /api/sources/{id}/check)
+ALTER TABLE channels ADD COLUMN status TEXT;
+ALTER TABLE channels ADD COLUMN status_code INTEGER;
+ALTER TABLE channels ADD COLUMN last_checked TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_channels_source_status ON channels (source_id, status);
@@ -0,0 +1,4 @@
+ALTER TABLE channels DROP COLUMN IF EXISTS hidden;
+ALTER TABLE channels DROP COLUMN IF EXISTS fail_count;
+ALTER TABLE sources DROP COLUMN IF EXISTS dead_channel_threshold;
+ALTER TABLE sources DROP COLUMN IF EXISTS dead_channel_policy;
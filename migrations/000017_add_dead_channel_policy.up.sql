@@ -0,0 +1,9 @@
+-- dead_channel_policy: what to do with channels failing dead_channel_threshold consecutive checks
+ALTER TABLE sources ADD COLUMN dead_channel_policy TEXT NOT NULL DEFAULT 'keep'
+    CHECK (dead_channel_policy IN ('keep', 'hide', 'delete'));
+ALTER TABLE sources ADD COLUMN dead_channel_threshold INTEGER NOT NULL DEFAULT 3
+    CHECK (dead_channel_threshold > 0);
+
+-- fail_count: consecutive failed checks; hidden: left out of listings unless include_hidden=true
+ALTER TABLE channels ADD COLUMN fail_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT false;
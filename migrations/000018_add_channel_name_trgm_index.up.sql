@@ -0,0 +1,10 @@
+-- Trigram index for the channel name search (ILIKE '%term%'), which otherwise
+-- scans every row. pg_trgm is optional: EnsurePgTrgm creates it at startup
+-- when it can, and without it the search still works, unindexed.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_trgm') THEN
+        CREATE INDEX IF NOT EXISTS idx_channels_name_trgm ON channels USING gin (name gin_trgm_ops);
+    END IF;
+END
+$$;
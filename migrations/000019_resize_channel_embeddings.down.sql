@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_channels_embedding_hnsw;
+
+UPDATE channels SET embedding = NULL;
+
+ALTER TABLE channels ALTER COLUMN embedding TYPE vector(1024);
+
+CREATE INDEX idx_channels_embedding_hnsw
+    ON channels USING hnsw (embedding vector_cosine_ops)
+    WITH (m = 16, ef_construction = 64);
@@ -0,0 +1,16 @@
+-- The embedding model (voyage-3-lite) returns 512-dimensional vectors, which
+-- a vector(1024) column rejects. Embeddings of any other size cannot be
+-- compared with query vectors, so they are cleared; refreshing a source
+-- embeds its channels again.
+DROP INDEX IF EXISTS idx_channels_embedding_hnsw;
+
+UPDATE channels SET embedding = NULL WHERE embedding IS NOT NULL AND vector_dims(embedding) <> 512;
+
+ALTER TABLE channels ALTER COLUMN embedding TYPE vector(512);
+
+-- Built inside the migration's transaction, so not CONCURRENTLY; the column
+-- is mostly empty at this point. POST /api/admin/reindex-embeddings rebuilds
+-- it without blocking writes.
+CREATE INDEX idx_channels_embedding_hnsw
+    ON channels USING hnsw (embedding vector_cosine_ops)
+    WITH (m = 16, ef_construction = 64);
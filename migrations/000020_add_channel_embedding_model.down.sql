@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_channels_source_embedding_model;
+ALTER TABLE channels DROP COLUMN IF EXISTS embedding_model;
@@ -0,0 +1,9 @@
+-- Model each embedding was generated with; vectors of different models are
+-- not comparable, so channels embedded with another model than the
+-- configured one count as not embedded. Existing embeddings were all made
+-- with the previously hard-coded voyage-3-lite.
+ALTER TABLE channels ADD COLUMN embedding_model TEXT;
+
+UPDATE channels SET embedding_model = 'voyage-3-lite' WHERE embedding IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_channels_source_embedding_model ON channels (source_id, embedding_model);
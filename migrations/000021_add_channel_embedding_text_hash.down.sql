@@ -0,0 +1 @@
+ALTER TABLE channels DROP COLUMN IF EXISTS embedding_text_hash;
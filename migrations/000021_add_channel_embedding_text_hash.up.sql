@@ -0,0 +1,4 @@
+-- SHA-256 of the text each embedding was generated from, so refreshes only
+-- embed channels whose name, group or media type changed. Existing
+-- embeddings have no hash and are regenerated once on their next refresh.
+ALTER TABLE channels ADD COLUMN embedding_text_hash TEXT;
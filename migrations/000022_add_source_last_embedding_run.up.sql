@@ -0,0 +1,3 @@
+-- Totals of the source's last completed embedding run (channels embedded
+-- and skipped, VoyageAI tokens used), as JSON so new fields need no migration.
+ALTER TABLE sources ADD COLUMN last_embedding_run JSONB;
@@ -0,0 +1,2 @@
+-- URL notified with a JSON POST when a refresh of the source ends.
+ALTER TABLE sources ADD COLUMN webhook_url TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_channels_created_at;
+ALTER TABLE channels DROP COLUMN IF EXISTS created_at;
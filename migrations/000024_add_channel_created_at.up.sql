@@ -0,0 +1,5 @@
+-- When the channel first appeared in its source, for "recently added" lists.
+-- Channels that already exist get the time of the migration.
+ALTER TABLE channels ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS idx_channels_created_at ON channels (created_at, id);
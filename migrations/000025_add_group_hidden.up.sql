@@ -0,0 +1,3 @@
+-- Hidden groups and their channels are left out of listings, searches and
+-- exports; the flag survives refreshes like the channel flag does.
+ALTER TABLE groups ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT false;
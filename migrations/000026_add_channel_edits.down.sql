@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_channels_custom_name_trgm;
+ALTER TABLE channels DROP COLUMN IF EXISTS custom_url;
+ALTER TABLE channels DROP COLUMN IF EXISTS custom_name;
+ALTER TABLE channels DROP COLUMN IF EXISTS edited_fields;
@@ -0,0 +1,11 @@
+-- Channel fields edited through the API (models.EditedFields bits). A refresh
+-- keeps edited image, group and media type values. Name and URL are the
+-- channel's identity in the playlist, so edits to them are stored apart and
+-- read in their place, leaving the playlist values for refreshes to match.
+ALTER TABLE channels ADD COLUMN edited_fields INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE channels ADD COLUMN custom_name TEXT;
+ALTER TABLE channels ADD COLUMN custom_url TEXT;
+
+-- Name searches match edited names too.
+CREATE INDEX IF NOT EXISTS idx_channels_custom_name_trgm ON channels USING gin (custom_name gin_trgm_ops)
+    WHERE custom_name IS NOT NULL;
@@ -0,0 +1,18 @@
+-- playlists: user-defined collections of channels from any source
+CREATE TABLE IF NOT EXISTS playlists (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- playlist_channels: membership by channel id, which refreshes keep; a
+-- channel dropped by its source leaves its playlists with it.
+CREATE TABLE IF NOT EXISTS playlist_channels (
+    playlist_id BIGINT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+    channel_id BIGINT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (playlist_id, channel_id)
+);
+CREATE INDEX IF NOT EXISTS idx_playlist_channels_channel_id ON playlist_channels(channel_id);
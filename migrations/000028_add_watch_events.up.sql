@@ -0,0 +1,13 @@
+-- watch_events: one row per viewing session of a channel, with the last
+-- reported playback position for resuming VOD.
+CREATE TABLE IF NOT EXISTS watch_events (
+    id BIGSERIAL PRIMARY KEY,
+    channel_id BIGINT NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    position INTEGER NOT NULL DEFAULT 0,
+    duration INTEGER,
+    finished BOOLEAN NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_watch_events_channel_updated ON watch_events(channel_id, updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_watch_events_updated_at ON watch_events(updated_at);
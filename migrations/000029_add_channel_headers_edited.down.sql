@@ -0,0 +1 @@
+ALTER TABLE channel_http_headers DROP COLUMN IF EXISTS edited;
@@ -0,0 +1,2 @@
+-- Headers set through the API are kept when a refresh brings the playlist's.
+ALTER TABLE channel_http_headers ADD COLUMN IF NOT EXISTS edited BOOLEAN NOT NULL DEFAULT false;
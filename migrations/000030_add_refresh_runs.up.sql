@@ -0,0 +1,15 @@
+-- refresh_runs: what each ingest of a source changed, for the refresh
+-- history. added and updated hold channel ids; removed holds the id and name
+-- of each deleted channel, since the rows themselves are gone.
+CREATE TABLE IF NOT EXISTS refresh_runs (
+    id BIGSERIAL PRIMARY KEY,
+    source_id BIGINT NOT NULL REFERENCES sources(id) ON DELETE CASCADE,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    channel_count INTEGER NOT NULL DEFAULT 0,
+    unchanged BOOLEAN NOT NULL DEFAULT false,
+    added BIGINT[] NOT NULL DEFAULT '{}',
+    removed JSONB NOT NULL DEFAULT '[]',
+    updated BIGINT[] NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_runs_source_started ON refresh_runs(source_id, started_at DESC);
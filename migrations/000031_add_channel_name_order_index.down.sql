@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_channels_display_name;
+DROP INDEX IF EXISTS idx_channels_source_display_name;
@@ -0,0 +1,5 @@
+-- Indexes matching the default channel order (display name, then id), so
+-- cursor pages of a source or of all channels start with an index seek
+-- instead of sorting every match.
+CREATE INDEX IF NOT EXISTS idx_channels_source_display_name ON channels (source_id, (COALESCE(custom_name, name)), id);
+CREATE INDEX IF NOT EXISTS idx_channels_display_name ON channels ((COALESCE(custom_name, name)), id);
@@ -0,0 +1,8 @@
+-- When a refresh last changed the channel's playlist fields. Channels that
+-- already exist start at their created_at.
+ALTER TABLE channels ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+UPDATE channels SET updated_at = created_at;
+
+-- Recently added channels of one source; idx_channels_created_at covers all
+-- sources, in either direction.
+CREATE INDEX IF NOT EXISTS idx_channels_source_created_at ON channels (source_id, created_at, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS archived_channels;
+ALTER TABLE sources DROP COLUMN IF EXISTS stale_policy;
@@ -0,0 +1,24 @@
+-- stale_policy: what a refresh does with channels the playlist no longer lists
+ALTER TABLE sources ADD COLUMN stale_policy TEXT NOT NULL DEFAULT 'delete'
+    CHECK (stale_policy IN ('delete', 'archive'));
+
+-- archived_channels: channels a refresh removed from a source with
+-- stale_policy 'archive'. The channel, header and props rows are kept as
+-- JSON so that a channel coming back is restored with its id, favorite and
+-- hidden flags, edits and headers. name, url and tvg_id are the playlist's,
+-- to find it again.
+CREATE TABLE IF NOT EXISTS archived_channels (
+    id BIGINT PRIMARY KEY,
+    source_id BIGINT NOT NULL REFERENCES sources(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    tvg_id TEXT,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    channel JSONB NOT NULL,
+    headers JSONB,
+    props JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_archived_channels_source_key ON archived_channels (source_id, name, url);
+CREATE INDEX IF NOT EXISTS idx_archived_channels_source_tvg_id ON archived_channels (source_id, tvg_id);
+CREATE INDEX IF NOT EXISTS idx_archived_channels_archived_at ON archived_channels (archived_at);
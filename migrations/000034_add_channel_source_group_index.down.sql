@@ -0,0 +1,2 @@
+ALTER TABLE refresh_runs DROP COLUMN IF EXISTS removed_groups;
+DROP INDEX IF EXISTS idx_channels_source_group;
@@ -0,0 +1,7 @@
+-- Channels of one source by group, so finding the source's groups without
+-- channels after a refresh is an index-only probe per group.
+CREATE INDEX IF NOT EXISTS idx_channels_source_group ON channels (source_id, group_id);
+
+-- Groups a refresh deleted because none of its channels were left in them,
+-- with their names as the rows are gone.
+ALTER TABLE refresh_runs ADD COLUMN IF NOT EXISTS removed_groups JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1,3 @@
+-- Favorites are few, so a partial index keeps counting and listing them
+-- cheap across all sources or within one.
+CREATE INDEX IF NOT EXISTS idx_channels_favorite ON channels (source_id) WHERE favorite;
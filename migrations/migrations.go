@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL schema migrations so the binary can
+// apply them without the migrations directory on disk.
+package migrations
+
+import "embed"
+
+// FS holds the numbered *.up.sql and *.down.sql files.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"io/fs"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var migrationName = regexp.MustCompile(`^(\d{6})_(\w+)\.(up|down)\.sql$`)
+
+// TestMigrationsPaired checks that every embedded migration has both an up
+// and a down file under the same name, and that the versions run from 1
+// without gaps or repeats.
+func TestMigrationsPaired(t *testing.T) {
+	files, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type migration struct {
+		name     string
+		up, down bool
+	}
+	byVersion := map[int]*migration{}
+	for _, f := range files {
+		m := migrationName.FindStringSubmatch(f)
+		if m == nil {
+			t.Errorf("%s: name is not NNNNNN_name.up.sql or NNNNNN_name.down.sql", f)
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{name: m[2]}
+			byVersion[version] = mig
+		} else if mig.name != m[2] {
+			t.Errorf("version %d is both %s and %s", version, mig.name, m[2])
+		}
+		if data, err := fs.ReadFile(FS, f); err != nil || len(data) == 0 {
+			t.Errorf("%s: empty or unreadable (%v)", f, err)
+		}
+		if m[3] == "up" {
+			mig.up = true
+		} else {
+			mig.down = true
+		}
+	}
+
+	if len(byVersion) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for v := 1; v <= len(byVersion); v++ {
+		mig := byVersion[v]
+		switch {
+		case mig == nil:
+			t.Errorf("version %d is missing; the %d migrations should run from 1 to %d", v, len(byVersion), len(byVersion))
+		case !mig.up:
+			t.Errorf("version %d (%s) has no .up.sql", v, mig.name)
+		case !mig.down:
+			t.Errorf("version %d (%s) has no .down.sql", v, mig.name)
+		}
+	}
+}